@@ -0,0 +1,367 @@
+package main
+
+/*
+This file implements the `app evaluate` subcommand, which scores the detection of the documents on a labeled corpus:
+every image of the corpus is sent to the server embedded in the process (`pkg/server`) for its corners
+(`protocol.OperationCorners`), exactly as a client would, and the corners found are compared with those of the
+ground truth. The report gives, per preset, the rate of the images where a document was found and the error of their
+corners in pixels, so a change of the thresholds or of an algorithm can be measured on real photos before it ships.
+
+The ground truth is a JSON object mapping the path of every image, relative to the corpus directory, to its preset
+(the `document` preset if omitted) and the top-left, top-right, bottom-right and bottom-left corners of its document:
+
+```json
+{
+  "desk/letter-01.jpg": {
+    "corners": [{"x": 412, "y": 230}, {"x": 2860, "y": 255}, {"x": 2901, "y": 3710}, {"x": 380, "y": 3690}]
+  },
+  "receipts/cafe.jpg": {
+    "preset": "receipt",
+    "corners": [{"x": 610, "y": 95}, {"x": 1250, "y": 120}, {"x": 1190, "y": 2980}, {"x": 540, "y": 2950}]
+  }
+}
+```
+
+The corners of a document are matched with those of the truth in the order of the smallest error, among the four
+rotations of their order: a receipt photographed sideways has no natural top-left corner.
+
+---
+
+### Constants
+- `defaultTruthFile`: Name of the ground truth file in the corpus directory, used when `-truth` is not given.
+
+---
+
+### `truthEntry`
+Ground truth of an image: its preset and the corners of its document.
+
+### `evaluation`
+Outcome of the images of a preset.
+
+- Fields:
+  - `Preset`: Name of the preset.
+  - `Images`: Number of images evaluated.
+  - `Detected`: Number of images where a document was found.
+  - `DetectionRate`: `Detected` over `Images`.
+  - `MeanError`, `P95Error`, `MaxError`: Mean, 95th percentile and largest distance, in pixels, between a corner
+    found and the corner of the truth, over the corners of the images where a document was found.
+  - `errors`: The distances of every corner, sorted once the images are evaluated.
+
+---
+
+### `runEvaluate(args []string) int`
+Parses the flags of the subcommand, evaluates the images of the truth file and prints the report, per preset and
+for the whole corpus. Returns the exit status of the command: 0 if every image was evaluated, 1 if some could not be
+read or processed (they count as not detected), 2 if the evaluation could not run.
+
+- Flags:
+  - `-corpus`: Directory of the images.
+  - `-truth`: Ground truth file, `defaultTruthFile` in the corpus directory by default.
+  - `-preset`: Preset every image is processed with, instead of the preset of its truth entry, e.g. to compare
+    presets on the same corpus.
+  - `-workers`: Workers of the embedded server, the number of CPU cores if 0.
+  - `-json`: Prints the report as JSON instead of a table.
+
+### `evaluateCorpus(client *clientlib.Client, corpus string, truth map[string]truthEntry, preset string) ([]*evaluation, int)`
+Sends every image of `truth` to the server of `client`, with `preset` if not empty, and returns the outcome of each
+preset, sorted by name, followed by that of the whole corpus if there are several presets, with the exit status of
+`runEvaluate`. The images which cannot be read or processed are reported on the standard error.
+
+### `readTruth(path string) (map[string]truthEntry, error)`
+Reads a ground truth file, failing on an entry without four corners.
+
+### `startEvaluationServer(workers int) (string, func(), error)`
+Starts the embedded server with `workers` workers, and returns its address and the function stopping it. The log of
+the server and the standard logger, that of its workers, are discarded: they would bury the report.
+
+### `detectCorners(client *clientlib.Client, path, preset string) ([]protocol.Point, error)`
+Returns the corners of the document the server finds on the image at `path` with `preset`, nil if none is found.
+
+### `cornerErrors(found, truth []protocol.Point) []float64`
+Returns the distance between every corner of `found` and the matching corner of `truth`, for the rotation of the
+order of `found` of the smallest mean distance.
+
+### `(result *evaluation) add(distances []float64, detected bool)` / `(result *evaluation) finish()`
+Counts an image, with the errors of its corners if a document was found, and computes the statistics once every
+image is counted.
+
+### `errorPercentile(sorted []float64, percent float64) float64`
+Returns the `percent` percentile of sorted errors, by the nearest-rank method, 0 if there is none.
+
+---
+
+### Example Usage:
+```
+./app evaluate -corpus testdata/photos
+./app evaluate -corpus testdata/receipts -truth receipts.json -preset photo -json
+```
+*/
+
+import (
+	clientlib "ELP-project/internal/client"
+	"ELP-project/internal/netUtils"
+	"ELP-project/internal/protocol"
+	serverlib "ELP-project/pkg/server"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const defaultTruthFile = "corners.json"
+
+type truthEntry struct {
+	Preset  string           `json:"preset,omitempty"`
+	Corners []protocol.Point `json:"corners"`
+}
+
+type evaluation struct {
+	Preset        string  `json:"preset"`
+	Images        int     `json:"images"`
+	Detected      int     `json:"detected"`
+	DetectionRate float64 `json:"detectionRate"`
+	MeanError     float64 `json:"meanError"`
+	P95Error      float64 `json:"p95Error"`
+	MaxError      float64 `json:"maxError"`
+
+	errors []float64
+}
+
+func runEvaluate(args []string) int {
+	flagSet := flag.NewFlagSet("evaluate", flag.ContinueOnError)
+	corpus := flagSet.String("corpus", "", "directory of the images of the corpus")
+	truthPath := flagSet.String("truth", "", "ground truth file (default "+defaultTruthFile+" in the corpus directory)")
+	preset := flagSet.String("preset", "", "preset every image is processed with, instead of the preset of its truth entry")
+	workers := flagSet.Int("workers", 0, "workers processing the images (number of CPU cores if 0)")
+	asJSON := flagSet.Bool("json", false, "print the report as JSON")
+	if err := flagSet.Parse(args); err != nil {
+		return 2
+	}
+	if *corpus == "" || flagSet.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: ./app evaluate -corpus dir [-truth corners.json] [-preset name] [-workers n] [-json]")
+		return 2
+	}
+	if *truthPath == "" {
+		*truthPath = filepath.Join(*corpus, defaultTruthFile)
+	}
+
+	truth, err := readTruth(*truthPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading the ground truth:", err)
+		return 2
+	}
+
+	address, stop, err := startEvaluationServer(*workers)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error starting the server:", err)
+		return 2
+	}
+	defer stop()
+	client, err := clientlib.DialCodec("tcp", address, netUtils.DefaultSocketOptions(), protocol.Protobuf)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error connecting to the server:", err)
+		return 2
+	}
+	defer client.Close()
+
+	report, status := evaluateCorpus(client, *corpus, truth, *preset)
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing the report:", err)
+			return 2
+		}
+		return status
+	}
+	fmt.Printf("%-12s %7s %9s %7s %10s %10s %10s\n", "preset", "images", "detected", "rate", "mean err", "p95 err", "max err")
+	for _, result := range report {
+		fmt.Printf("%-12s %7d %9d %6.1f%% %7.1f px %7.1f px %7.1f px\n", result.Preset, result.Images, result.Detected,
+			100*result.DetectionRate, result.MeanError, result.P95Error, result.MaxError)
+	}
+	return status
+}
+
+func evaluateCorpus(client *clientlib.Client, corpus string, truth map[string]truthEntry, preset string) ([]*evaluation, int) {
+	status := 0
+	results := make(map[string]*evaluation)
+	total := &evaluation{Preset: "all"}
+	for _, name := range slices.Sorted(maps.Keys(truth)) {
+		entry := truth[name]
+		imagePreset := cmp.Or(preset, entry.Preset, protocol.PresetDocument)
+		result, ok := results[imagePreset]
+		if !ok {
+			result = &evaluation{Preset: imagePreset}
+			results[imagePreset] = result
+		}
+
+		corners, err := detectCorners(client, filepath.Join(corpus, name), imagePreset)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error evaluating %s: %v\n", name, err)
+			status = 1
+		}
+		var distances []float64
+		if corners != nil {
+			distances = cornerErrors(corners, entry.Corners)
+		}
+		result.add(distances, corners != nil)
+		total.add(distances, corners != nil)
+	}
+
+	report := make([]*evaluation, 0, len(results)+1)
+	for _, name := range slices.Sorted(maps.Keys(results)) {
+		report = append(report, results[name])
+	}
+	if len(results) > 1 {
+		report = append(report, total)
+	}
+	for _, result := range report {
+		result.finish()
+	}
+	return report, status
+}
+
+func readTruth(path string) (map[string]truthEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var truth map[string]truthEntry
+	if err := json.Unmarshal(data, &truth); err != nil {
+		return nil, fmt.Errorf("invalid ground truth: %w", err)
+	}
+	if len(truth) == 0 {
+		return nil, errors.New("no image in the ground truth")
+	}
+	for name, entry := range truth {
+		if len(entry.Corners) != 4 {
+			return nil, fmt.Errorf("%s has %d corners, expected 4", name, len(entry.Corners))
+		}
+	}
+	return truth, nil
+}
+
+func startEvaluationServer(workers int) (string, func(), error) {
+	jobsDir, err := os.MkdirTemp("", "elp-evaluate-")
+	if err != nil {
+		return "", nil, fmt.Errorf("error creating job directory: %w", err)
+	}
+	defer os.RemoveAll(jobsDir)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, fmt.Errorf("error listening on an ephemeral port: %w", err)
+	}
+
+	config := serverlib.DefaultConfig()
+	config.Listener = listener
+	config.JobsDir = jobsDir
+	config.Workers = workers
+	config.Logger = log.New(io.Discard, "", 0)
+	log.SetOutput(io.Discard)
+
+	server, err := serverlib.New(config)
+	if err != nil {
+		listener.Close()
+		return "", nil, err
+	}
+	if err := server.Start(context.Background()); err != nil {
+		listener.Close()
+		return "", nil, err
+	}
+
+	stop := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			fmt.Fprintln(os.Stderr, "Error stopping the server:", err)
+		}
+	}
+	return server.Addr().String(), stop, nil
+}
+
+func detectCorners(client *clientlib.Client, path, preset string) ([]protocol.Point, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	header := protocol.Header{Operation: protocol.OperationCorners, Preset: preset, NoCache: true}
+	response, err := client.Do(header, bytes.NewReader(data), int64(len(data)))
+	var errorMessage protocol.ErrorMessage
+	if errors.As(err, &errorMessage) && errorMessage.Code == protocol.CodeNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var document protocol.Document
+	if err := json.Unmarshal(response.Data, &document); err != nil {
+		return nil, fmt.Errorf("invalid corners: %w", err)
+	}
+	if len(document.Corners) != 4 {
+		return nil, nil
+	}
+	return document.Corners, nil
+}
+
+func cornerErrors(found, truth []protocol.Point) []float64 {
+	var best []float64
+	bestSum := math.Inf(1)
+	for rotation := range found {
+		distances := make([]float64, len(truth))
+		var sum float64
+		for i, corner := range truth {
+			matched := found[(i+rotation)%len(found)]
+			distances[i] = math.Hypot(float64(matched.X-corner.X), float64(matched.Y-corner.Y))
+			sum += distances[i]
+		}
+		if sum < bestSum {
+			best, bestSum = distances, sum
+		}
+	}
+	return best
+}
+
+func (result *evaluation) add(distances []float64, detected bool) {
+	result.Images++
+	if detected {
+		result.Detected++
+		result.errors = append(result.errors, distances...)
+	}
+}
+
+func (result *evaluation) finish() {
+	result.DetectionRate = float64(result.Detected) / float64(result.Images)
+	slices.Sort(result.errors)
+	var sum float64
+	for _, distance := range result.errors {
+		sum += distance
+	}
+	if len(result.errors) > 0 {
+		result.MeanError = sum / float64(len(result.errors))
+		result.MaxError = result.errors[len(result.errors)-1]
+	}
+	result.P95Error = errorPercentile(result.errors, 95)
+}
+
+func errorPercentile(sorted []float64, percent float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(percent / 100 * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
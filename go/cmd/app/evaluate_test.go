@@ -0,0 +1,173 @@
+package main
+
+/*
+This file tests the scoring of `app evaluate`: the matching of the corners found with those of the truth, the
+statistics of a preset, and the evaluation of a small corpus of synthetic photos by the embedded server.
+
+---
+
+### `writeFixture(t *testing.T, path string, corners []protocol.Point)`
+Writes a JPEG photo of a light page with the given corners on a dark desk, or of the bare desk if `corners` is nil.
+*/
+
+import (
+	clientlib "ELP-project/internal/client"
+	"ELP-project/internal/netUtils"
+	"ELP-project/internal/protocol"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func writeFixture(t *testing.T, path string, corners []protocol.Point) {
+	t.Helper()
+
+	desk := color.RGBA{R: 60, G: 50, B: 40, A: 255}
+	paper := color.RGBA{R: 235, G: 230, B: 220, A: 255}
+	img := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	for y := 0; y < 480; y++ {
+		for x := 0; x < 640; x++ {
+			inside := corners != nil
+			for i, corner := range corners {
+				next := corners[(i+1)%len(corners)]
+				if (next.X-corner.X)*(y-corner.Y)-(next.Y-corner.Y)*(x-corner.X) < 0 {
+					inside = false
+				}
+			}
+			if inside {
+				img.SetRGBA(x, y, paper)
+			} else {
+				img.SetRGBA(x, y, desk)
+			}
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := jpeg.Encode(file, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCornerErrors(t *testing.T) {
+	truth := []protocol.Point{{X: 100, Y: 100}, {X: 500, Y: 100}, {X: 500, Y: 400}, {X: 100, Y: 400}}
+
+	// The same corners starting from another one, plus an offset of 3-4-5 pixels on the first corner of the truth.
+	found := []protocol.Point{{X: 500, Y: 100}, {X: 500, Y: 400}, {X: 100, Y: 400}, {X: 103, Y: 104}}
+	if distances := cornerErrors(found, truth); !slices.Equal(distances, []float64{5, 0, 0, 0}) {
+		t.Fatalf("corner errors %v, expected [5 0 0 0]", distances)
+	}
+}
+
+func TestEvaluationStatistics(t *testing.T) {
+	result := &evaluation{Preset: protocol.PresetDocument}
+	var distances []float64
+	for i := 20; i >= 1; i-- {
+		distances = append(distances, float64(i))
+	}
+	result.add(distances[:10], true)
+	result.add(distances[10:], true)
+	result.add(nil, false)
+	result.add(nil, false)
+	result.finish()
+
+	if result.Images != 4 || result.Detected != 2 || result.DetectionRate != 0.5 {
+		t.Fatalf("%d images, %d detected (rate %v), expected 4, 2 and 0.5", result.Images, result.Detected,
+			result.DetectionRate)
+	}
+	if result.MeanError != 10.5 || result.P95Error != 19 || result.MaxError != 20 {
+		t.Fatalf("errors: mean %v, p95 %v, max %v, expected 10.5, 19 and 20", result.MeanError, result.P95Error,
+			result.MaxError)
+	}
+
+	if p95 := errorPercentile(nil, 95); p95 != 0 {
+		t.Fatalf("95th percentile of no error is %v, expected 0", p95)
+	}
+}
+
+func TestReadTruth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), defaultTruthFile)
+	for _, content := range []string{`{}`, `{"a.jpg": {"corners": [{"x": 1, "y": 1}]}}`, `[]`} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readTruth(path); err == nil {
+			t.Fatalf("ground truth %s read, expected an error", content)
+		}
+	}
+}
+
+func TestEvaluateCorpus(t *testing.T) {
+	corpus := t.TempDir()
+	page := []protocol.Point{{X: 150, Y: 90}, {X: 490, Y: 110}, {X: 480, Y: 400}, {X: 140, Y: 385}}
+	writeFixture(t, filepath.Join(corpus, "page.jpg"), page)
+	writeFixture(t, filepath.Join(corpus, "sideways.jpg"), page)
+	writeFixture(t, filepath.Join(corpus, "desk.jpg"), nil)
+
+	// The truth of the sideways page starts from its top-right corner, as for a photo taken sideways.
+	truth := map[string]truthEntry{
+		"page.jpg":     {Corners: page},
+		"sideways.jpg": {Corners: append(slices.Clone(page[1:]), page[0])},
+		"desk.jpg":     {Preset: protocol.PresetPhoto, Corners: page},
+		"missing.jpg":  {Corners: page},
+	}
+
+	data, err := json.Marshal(truth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(corpus, defaultTruthFile), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	truth, err = readTruth(filepath.Join(corpus, defaultTruthFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address, stop, err := startEvaluationServer(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	client, err := clientlib.DialCodec("tcp", address, netUtils.DefaultSocketOptions(), protocol.Protobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	report, status := evaluateCorpus(client, corpus, truth, "")
+	if status != 1 {
+		t.Errorf("exit status %d with a missing image, expected 1", status)
+	}
+	var presets []string
+	for _, result := range report {
+		presets = append(presets, result.Preset)
+	}
+	if !slices.Equal(presets, []string{protocol.PresetDocument, protocol.PresetPhoto, "all"}) {
+		t.Fatalf("report of the presets %v, expected document, photo and all", presets)
+	}
+
+	document, photo, all := report[0], report[1], report[2]
+	if document.Images != 3 || document.Detected != 2 {
+		t.Errorf("document preset: %d of %d images detected, expected 2 of 3", document.Detected, document.Images)
+	}
+	if document.MaxError > 8 {
+		t.Errorf("document preset: corners found up to %.1f px away from the truth", document.MaxError)
+	}
+	if photo.Images != 1 || photo.Detected != 0 || photo.MaxError != 0 {
+		t.Errorf("photo preset: %d of %d images detected (max error %.1f px), expected none of 1", photo.Detected,
+			photo.Images, photo.MaxError)
+	}
+	if all.Images != 4 || all.Detected != 2 || all.MeanError != document.MeanError {
+		t.Errorf("whole corpus: %d of %d images detected (mean error %.1f px), expected 2 of 4 with the error of the "+
+			"document preset", all.Detected, all.Images, all.MeanError)
+	}
+}
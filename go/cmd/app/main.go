@@ -4,16 +4,36 @@ import (
 	"ELP-project/internal/geometry"
 	"ELP-project/internal/imageUtils"
 	"ELP-project/internal/utils"
+	"ELP-project/pkg/server"
+	"encoding/json"
+	"flag"
 	"fmt"
-	"image"
-	"image/draw"
 	"image/jpeg"
 	"log"
 	"os"
+	"runtime"
 )
 
 // Main Canny filter pipeline.
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "evaluate" {
+		os.Exit(runEvaluate(os.Args[2:]))
+	}
+
+	printPipeline := flag.Bool("print-pipeline", false, "print the pipeline the server runs for -preset, and exit")
+	preset := flag.String("preset", "", "preset whose pipeline -print-pipeline prints (document if empty)")
+	pipelineFormat := flag.String("pipeline-format", "json", "format of -print-pipeline: json or dot")
+	scan := flag.Bool("scan", false, "give the straightened document the look of a scan: white paper and a boosted contrast")
+	binarize := flag.Bool("binarize", false, "with -scan, binarize the document into black and white")
+	flag.Parse()
+
+	if *printPipeline {
+		if err := printPipelineOf(*preset, *pipelineFormat); err != nil {
+			log.Fatalf("Failed to print the pipeline: %v", err)
+		}
+		return
+	}
+
 	// Input/output paths
 	inputPath := "./go/image2.jpg"
 	outputPath := "output.jpg"
@@ -27,7 +47,10 @@ func main() {
 	// Convert to grayscale
 	grayImg := imageUtils.Grayscale(img)
 
-	edges := utils.ApplyCannyEdgeDetection(grayImg)
+	// Detect the edges of the whole image on all the cores
+	parameters := utils.DefaultCannyParameters
+	parameters.Workers = runtime.NumCPU()
+	edges, _ := utils.ApplyCannyEdgeDetectionWith(grayImg, parameters)
 
 	// Save edges to a file for visualization
 	edgesFile, err := os.Create("edges.jpg")
@@ -42,11 +65,6 @@ func main() {
 	contourComplet := utils.FindQuadrilateral(contours)
 	fmt.Println(len(contourComplet.Contour))
 
-	/*if len(contourA4) != 4 {
-		fmt.Println("No contour found.")
-		return
-	}*/
-
 	// Sauvegarder l'image avec le contour dessiné
 	contourImg := utils.DrawContour(img, contourComplet.Contour)
 	outFile, err := os.Create("contour_detected.jpg")
@@ -58,53 +76,10 @@ func main() {
 	jpeg.Encode(outFile, contourImg, nil)
 	fmt.Println("Image avec contour sauvegardée dans contour_detected.jpg")
 
-	corner1 := geometry.Point{
-		X: 1500,
-		Y: 2000,
-	}
-	corner2 := geometry.Point{
-		X: 1500,
-		Y: 2000,
-	}
-	corner3 := geometry.Point{
-		X: 1500,
-		Y: 2000,
-	}
-	corner4 := geometry.Point{
-		X: 1500,
-		Y: 2000,
-	}
-	for contour := range contourComplet.Contour {
-		if contourComplet.Contour[contour].X < corner1.X {
-			corner1.X = contourComplet.Contour[contour].X
-		}
-		if contourComplet.Contour[contour].Y < corner1.Y {
-			corner1.Y = contourComplet.Contour[contour].Y
-		}
-		if contourComplet.Contour[contour].X > corner2.X {
-			corner2.X = contourComplet.Contour[contour].X
-		}
-		if contourComplet.Contour[contour].Y < corner2.Y {
-			corner2.Y = contourComplet.Contour[contour].Y
-		}
-		if contourComplet.Contour[contour].X < corner3.X {
-			corner3.X = contourComplet.Contour[contour].X
-		}
-		if contourComplet.Contour[contour].Y > corner3.Y {
-			corner3.Y = contourComplet.Contour[contour].Y
-		}
-		if contourComplet.Contour[contour].X > corner4.X {
-			corner4.X = contourComplet.Contour[contour].X
-		}
-		if contourComplet.Contour[contour].Y > corner4.Y {
-			corner4.Y = contourComplet.Contour[contour].Y
-		}
-	}
-	contourA4 := geometry.Contour{
-		corner1,
-		corner2,
-		corner4,
-		corner3,
+	contourA4 := utils.FitQuadrilateral(contourComplet.Contour).Contour
+	if len(contourA4) != 4 {
+		fmt.Println("No contour found.")
+		return
 	}
 	fmt.Printf("Contour A4 points: %+v\n", contourA4)
 
@@ -118,29 +93,16 @@ func main() {
 	defer outFile.Close()
 	jpeg.Encode(outFile, extractedRegion, nil)
 	fmt.Println("Image extraite sauvegardée dans extracted_region.jpg")
-	/*
-		// Définir un rectangle A4 cible
-		targetSize := [4]utils.Point2f{
-			{0, 0},
-			{3072, 0},
-			{3072, 4096},
-			{0, 4096},
-		}
-
-		// Calcul de l'homographie
-		homography := utils.ComputeHomographyMatrix([4]utils.Point2f{
-			{float64(contourA4[0].X), float64(contourA4[0].Y)},
-			{float64(contourA4[1].X), float64(contourA4[1].Y)},
-			{float64(contourA4[2].X), float64(contourA4[2].Y)},
-			{float64(contourA4[3].X), float64(contourA4[3].Y)},
-		}, targetSize)
-
-		// Appliquer la transformation
-		warped := utils.ApplyPerspectiveTransform(img, homography, 3072, 4096)*/
 
-	rect := image.Rect(contourA4[0].X, contourA4[0].Y, contourA4[2].X, contourA4[2].Y)
-	warped := image.NewRGBA(rect)
-	draw.Draw(warped, rect, img, image.Pt(contourA4[0].X, contourA4[0].Y), draw.Src)
+	// Redresser le document sur un rectangle aux proportions A4
+	a4, err := geometry.ParsePageSize("A4")
+	if err != nil {
+		log.Fatalf("Failed to get the A4 page size: %v", err)
+	}
+	warped := utils.WarpToPage(img, contourA4, a4)
+	if *scan {
+		warped = imageUtils.ScanDocument(warped, *binarize)
+	}
 
 	// Save the result
 	err = imageUtils.SaveImage(warped, outputPath, format)
@@ -155,3 +117,23 @@ func main() {
 	//imageUtils.SaveImage(img2, "image_with_corner.jpg", format)
 
 }
+
+// Prints the pipeline a server of the default configuration runs for a preset, as JSON or as a Graphviz graph.
+func printPipelineOf(preset, format string) error {
+	pipeline, err := server.DescribePipeline(server.DefaultConfig(), preset)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(pipeline)
+	case "dot":
+		_, err := fmt.Print(pipeline.DOT())
+		return err
+	default:
+		return fmt.Errorf("unknown format: %q", format)
+	}
+}
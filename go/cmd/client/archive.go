@@ -0,0 +1,163 @@
+package main
+
+/*
+This file implements the ZIP output of a batch: with `-zip <path>`, the results of the images of a batch are written
+into one ZIP archive instead of one file each, named by the `-name` template, with the text recognized on them
+(`-ocr`), their processing reports (`-report`, see `report.go`) and a `protocol.Manifest` describing every image in its `protocol.ManifestName` file, the failed ones
+included. A pile of scans is then collected as a single file, and the manifest tells which result comes from which
+image without parsing the names.
+
+The results are added to the archive as soon as they arrive, so the archive of a large batch does not keep every
+result in memory; only the manifest, written last, waits for the end of the batch.
+
+---
+
+### `batchArchive`
+ZIP archive being written by a batch.
+
+- Fields:
+  - `path`: Path of the archive.
+  - `file`, `writer`: The file of the archive and the ZIP writer over it.
+  - `names`: Names of the files already in the archive, so a result named like another gets a suffix.
+  - `entries`: Entry of the manifest of every image, by its index in the batch.
+
+- Methods:
+  - `add(client *Client, result batchResult, report []byte) (string, error)`: Adds the result of an image, its text
+    if the server recognized it, and its processing `report` unless nil, and returns the name of the result in the
+    archive.
+  - `fail(result batchResult)`: Records in the manifest that the image failed.
+  - `create(name string, data []byte) error`: Adds a file to the archive. The images are stored as they are, being
+    already compressed, the other files are deflated.
+  - `unique(name string) string`: Returns `name`, or `name` with `_<n>` inserted before its extension if the archive
+    already has a file of that name.
+  - `close() error`: Writes the manifest, then the end of the archive, and closes its file. The entries of the images
+    which got no answer are left out.
+
+---
+
+### `newBatchArchive(path string, size int) (*batchArchive, error)`
+Creates the archive of a batch of `size` images at `path`, replacing any existing file.
+*/
+
+import (
+	"ELP-project/internal/protocol"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type batchArchive struct {
+	path    string
+	file    *os.File
+	writer  *zip.Writer
+	names   map[string]bool
+	entries []*protocol.ManifestEntry
+}
+
+func newBatchArchive(path string, size int) (*batchArchive, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &batchArchive{
+		path:    path,
+		file:    file,
+		writer:  zip.NewWriter(file),
+		names:   make(map[string]bool),
+		entries: make([]*protocol.ManifestEntry, size),
+	}, nil
+}
+
+func (archive *batchArchive) add(client *Client, result batchResult, report []byte) (string, error) {
+	metadata := result.response.Metadata
+	name, err := client.templateName(result.input, metadata.Format, result.index+1)
+	if err != nil {
+		return "", err
+	}
+	entry := &protocol.ManifestEntry{
+		File:     archive.unique(name),
+		Input:    result.input,
+		Page:     result.index + 1,
+		Format:   metadata.Format,
+		Rotation: metadata.Rotation,
+	}
+	if config, _, err := image.DecodeConfig(bytes.NewReader(result.response.Data)); err == nil {
+		entry.Width, entry.Height = config.Width, config.Height
+	}
+	if err := archive.create(entry.File, result.response.Data); err != nil {
+		return "", err
+	}
+
+	if metadata.Text != "" {
+		entry.Text = archive.unique(strings.TrimSuffix(entry.File, filepath.Ext(entry.File)) + ".txt")
+		if err := archive.create(entry.Text, []byte(metadata.Text+"\n")); err != nil {
+			return "", err
+		}
+	}
+	if report != nil {
+		entry.Report = archive.unique(strings.TrimSuffix(entry.File, filepath.Ext(entry.File)) + ".html")
+		if err := archive.create(entry.Report, report); err != nil {
+			return "", err
+		}
+	}
+	archive.entries[result.index] = entry
+	return entry.File, nil
+}
+
+func (archive *batchArchive) fail(result batchResult) {
+	archive.entries[result.index] = &protocol.ManifestEntry{
+		Input: result.input,
+		Page:  result.index + 1,
+		Error: result.response.Err.Error(),
+	}
+}
+
+func (archive *batchArchive) create(name string, data []byte) error {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()}
+	if _, ok := contactSheetFormat(name); ok {
+		header.Method = zip.Store
+	}
+	file, err := archive.writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	return err
+}
+
+func (archive *batchArchive) unique(name string) string {
+	extension := filepath.Ext(name)
+	candidate := name
+	for index := 1; archive.names[candidate]; index++ {
+		candidate = fmt.Sprintf("%s_%d%s", strings.TrimSuffix(name, extension), index, extension)
+	}
+	archive.names[candidate] = true
+	return candidate
+}
+
+func (archive *batchArchive) close() error {
+	var manifest protocol.Manifest
+	for _, entry := range archive.entries {
+		if entry != nil {
+			manifest.Entries = append(manifest.Entries, *entry)
+		}
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := archive.create(protocol.ManifestName, append(data, '\n')); err != nil {
+		return err
+	}
+	if err := archive.writer.Close(); err != nil {
+		archive.file.Close()
+		return err
+	}
+	return archive.file.Close()
+}
@@ -0,0 +1,507 @@
+package main
+
+/*
+This file implements the batch mode of the client: given a directory or a glob pattern instead of an image, the
+client sends every image it designates to the server and writes the results to a target directory, named by the
+`-name` template (see `naming.go`).
+
+The images are sent on `-parallel` connections (one by default) taking them from a shared queue, each connection
+pipelining several images at a time (see `batchWindow`), so the server processes them in parallel and the upload of an
+image overlaps the processing of the previous ones. A connection which is lost stops taking images from the queue. The
+images which did not get an answer are sent again once on new connections, after `batchRetryDelay` to let a
+restarting server come back. A connection refused by the server (`protocol.CodeBusy`, e.g. when the server limits the
+connections of a host) counts as lost, and the next attempt opens that many connections less. An image rejected by the server does not stop the batch: the error is printed, and the
+client exits with an error status once every image was processed.
+
+Every result is printed as soon as it arrives, prefixed by the number of images finished so far out of the batch.
+The batch ends with a summary: the number of images which succeeded and failed, the elapsed time, and the error of
+every failed image.
+
+With `-contact-sheet <path>`, the batch also writes one overview image tiling the thumbnails of every result, captioned
+with the name of its image, in the order of the batch (see `imageUtils.ContactSheet`). A failed image gets a
+crossed-out cell captioned `FAILED`, so a whole scanning session is checked at a glance. The thumbnails are made as
+soon as the results arrive, so the sheet of a large batch does not keep every result in memory.
+
+With `-zip <path>`, the results are collected into one ZIP archive with a manifest instead of one file each (see
+`archive.go`).
+
+---
+
+### Constants
+- `batchWindow`: Highest number of images of a batch waiting for their result at the same time.
+- `batchAttempts`: Number of connections an image of a batch is sent on before it is given up.
+- `batchRetryDelay`: Time waited before opening a new connection once the connection of a batch was lost.
+- `contactSheetCell`: Size of the cells of a contact sheet, in pixels.
+
+---
+
+### `batchResult`
+Response of the server to an image of a batch, with the position and the path of the image.
+
+### `batchFailure`
+Path of an image of a batch which failed, with its error, for the summary.
+
+---
+
+### `isBatch(path string) bool`
+Tells whether the image argument designates a batch: an existing directory, or a pattern containing glob
+metacharacters. A URL is a single image, whatever its query (e.g. `?id=42`).
+
+### `batchInputs(path string) ([]string, error)`
+Lists the images of a batch, in lexical order: the `.jpg`, `.jpeg` and `.png` files of a directory (not recursively),
+or the files matching a pattern of `filepath.Glob`. Fails if there is no image.
+
+### `Client.runBatch(inputs []string)`
+Sends every image of `inputs` on the connections of the client and saves the results. Prints one line per image,
+and a summary.
+
+- **Exits**:
+  - With an error status if any image failed.
+
+### `Client.sendBatch(inputs []string, queue <-chan int, results chan<- batchResult, last bool) ([]int, bool)`
+Opens a new connection and sends on it the images of `inputs` at the indexes taken from `queue`, until the queue is
+empty or the connection is lost, and passes their responses to `results`. Returns the indexes of the images to send
+again because the connection was lost, unless this is the `last` attempt, and whether the server refused the
+connection. With `-session`, every image is numbered as a page of the session by its index in the batch, from 1, so
+the pages sent in parallel or sent again are combined in order.
+
+### `openImage(path string) (*os.File, int64, error)`
+Opens an image of a batch and returns its size.
+
+### `Client.saveBatchResult(result batchResult, prefix string) error`
+Saves the result of an image under the name given by the template in the output directory, or in the archive of
+`-zip` (see `archive.go`), or prints the ID of its job for an asynchronous request, or its estimated processing time
+for `-op estimate`. The printed line starts
+with `prefix`. Returns the error of the image if it failed.
+
+### `batchTile(result batchResult, err error) imageUtils.Tile`
+Returns the cell of the contact sheet showing the result of an image, `err` being the error of the image if it
+failed. A result which cannot be decoded gets an empty cell captioned `NO PREVIEW`.
+
+### `writeContactSheet(path string, tiles []imageUtils.Tile) error`
+Draws the contact sheet of a batch and writes it to `path`, in the format of its extension.
+
+### `contactSheetFormat(path string) (string, bool)`
+Returns the format of a contact sheet written to `path`: `jpeg` for a `.jpg` or `.jpeg` file, `png` for a `.png`
+file. Returns false for any other extension.
+
+### `printBatchSummary(total int, failures []batchFailure, elapsed time.Duration)`
+Prints the number of images of the batch which succeeded and failed, and the error of every failed image.
+
+### `connectionLost(err error) bool`
+Tells whether a request failed because of its connection, lost or refused by the server, rather than being rejected
+by the server.
+
+### `connectionRefused(err error) bool`
+Tells whether a request failed because the server refused its connection (`protocol.CodeBusy`).
+*/
+
+import (
+	clientlib "ELP-project/internal/client"
+	"ELP-project/internal/imageUtils"
+	"ELP-project/internal/protocol"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	batchWindow     = 4
+	batchAttempts   = 2
+	batchRetryDelay = time.Second
+
+	contactSheetCell = 256
+)
+
+type batchResult struct {
+	index    int
+	input    string
+	response clientlib.Response
+}
+
+type batchFailure struct {
+	input string
+	err   error
+}
+
+var imageExtensions = []string{".jpg", ".jpeg", ".png"}
+
+func isBatch(path string) bool {
+	if isURL(path) {
+		return false
+	}
+	if info, err := os.Stat(path); err == nil {
+		return info.IsDir()
+	}
+	return strings.ContainsAny(path, "*?[")
+}
+
+func batchInputs(path string) ([]string, error) {
+	var inputs []string
+
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Type().IsRegular() && slices.Contains(imageExtensions, strings.ToLower(filepath.Ext(entry.Name()))) {
+				inputs = append(inputs, filepath.Join(path, entry.Name()))
+			}
+		}
+	} else {
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", path, err)
+		}
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+				inputs = append(inputs, match)
+			}
+		}
+	}
+
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no image found in %s", path)
+	}
+	return inputs, nil
+}
+
+func (client *Client) runBatch(inputs []string) {
+	log.Printf("Batch of %d images on %d connections", len(inputs), client.parallel)
+	start := time.Now()
+
+	if client.zipPath != "" {
+		archive, err := newBatchArchive(client.zipPath, len(inputs))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error creating the archive:", err)
+			log.Fatalf("Error creating the archive: %v", err)
+		}
+		client.archive = archive
+	}
+
+	results := make(chan batchResult, len(inputs))
+	summary := make(chan []batchFailure)
+	var tiles []imageUtils.Tile
+	if client.contactSheet != "" {
+		tiles = make([]imageUtils.Tile, len(inputs))
+	}
+	go func() {
+		var failures []batchFailure
+		finished := 0
+		for result := range results {
+			finished++
+			prefix := fmt.Sprintf("[%d/%d] %s:", finished, len(inputs), result.input)
+			err := client.saveBatchResult(result, prefix)
+			if err != nil {
+				failures = append(failures, batchFailure{input: result.input, err: err})
+			}
+			if tiles != nil {
+				tiles[result.index] = batchTile(result, err)
+			}
+		}
+		summary <- failures
+	}()
+
+	pending := make([]int, len(inputs))
+	for i := range pending {
+		pending[i] = i
+	}
+	connections := client.parallel
+	for attempt := 1; len(pending) > 0; attempt++ {
+		if attempt > 1 {
+			log.Printf("Connection lost, sending %d images again", len(pending))
+			time.Sleep(batchRetryDelay)
+		}
+
+		queue := make(chan int, len(pending))
+		for _, index := range pending {
+			queue <- index
+		}
+		close(queue)
+
+		var wait sync.WaitGroup
+		var mutex sync.Mutex
+		var retry []int
+		refused := 0
+		for range min(connections, len(pending)) {
+			wait.Add(1)
+			go func() {
+				defer wait.Done()
+				lost, busy := client.sendBatch(inputs, queue, results, attempt == batchAttempts)
+				mutex.Lock()
+				retry = append(retry, lost...)
+				if busy {
+					refused++
+				}
+				mutex.Unlock()
+			}()
+		}
+		wait.Wait()
+
+		if refused > 0 {
+			connections = max(connections-refused, 1)
+			log.Printf("The server refused %d connections, going on with %d", refused, connections)
+		}
+
+		// Images left in the queue once every connection was lost.
+		for index := range queue {
+			retry = append(retry, index)
+		}
+		slices.Sort(retry)
+		pending = retry
+	}
+	close(results)
+
+	failures := <-summary
+	printBatchSummary(len(inputs), failures, time.Since(start))
+	if client.archive != nil {
+		if err := client.archive.close(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing the archive:", err)
+			log.Fatalf("Error writing the archive: %v", err)
+		}
+		log.Printf("Archive saved: %s", client.zipPath)
+		fmt.Println("Archive:", client.zipPath)
+	}
+	if tiles != nil {
+		if err := writeContactSheet(client.contactSheet, tiles); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing the contact sheet:", err)
+			log.Printf("Error writing the contact sheet: %v", err)
+		} else {
+			log.Printf("Contact sheet saved: %s", client.contactSheet)
+			fmt.Println("Contact sheet:", client.contactSheet)
+		}
+	}
+	if len(failures) > 0 {
+		log.Fatalf("Batch finished with %d failed images", len(failures))
+	}
+}
+
+func (client *Client) sendBatch(inputs []string, queue <-chan int, results chan<- batchResult, last bool) ([]int, bool) {
+	conn := client.connect()
+	log.Printf("Connected to server: %s", conn.RemoteAddr().String())
+	defer conn.Close()
+
+	window := make(chan struct{}, batchWindow)
+	var mutex sync.Mutex
+	var retry []int
+	lost, refused := false, false
+
+	for index := range queue {
+		file, size, err := openImage(inputs[index])
+		if err != nil {
+			results <- batchResult{index: index, input: inputs[index], response: clientlib.Response{Err: err}}
+			continue
+		}
+		window <- struct{}{}
+
+		mutex.Lock()
+		if lost {
+			retry = append(retry, index)
+			mutex.Unlock()
+			file.Close()
+			<-window
+			break
+		}
+		mutex.Unlock()
+
+		var once sync.Once
+		handle := func(response clientlib.Response) {
+			once.Do(func() {
+				<-window
+				if !last && connectionLost(response.Err) {
+					mutex.Lock()
+					retry = append(retry, index)
+					lost = true
+					refused = refused || connectionRefused(response.Err)
+					mutex.Unlock()
+					return
+				}
+				results <- batchResult{index: index, input: inputs[index], response: response}
+			})
+		}
+
+		header := client.header
+		if header.Session != "" {
+			header.Page = index + 1
+		}
+		err = conn.Submit(header, file, size, handle)
+		file.Close()
+		if err != nil {
+			handle(clientlib.Response{Err: err})
+		}
+	}
+	conn.Wait()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	return retry, refused
+}
+
+func openImage(path string) (*os.File, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+func (client *Client) saveBatchResult(result batchResult, prefix string) error {
+	response := result.response
+
+	if response.Err != nil {
+		var errorMessage protocol.ErrorMessage
+		if errors.As(response.Err, &errorMessage) {
+			fmt.Println(prefix, "server error:", errorMessage.Message)
+		} else {
+			fmt.Println(prefix, "error:", response.Err)
+		}
+		log.Printf("Image %s failed: %v", result.input, response.Err)
+		client.saveArtifacts(result.input, result.index+1, response.Artifacts)
+		if client.archive != nil {
+			client.archive.fail(result)
+		}
+		return response.Err
+	}
+
+	logStats(response.Metadata.Stats)
+	logColors(response.Metadata.Colors)
+	logRotation(response.Metadata.Rotation)
+	logLossless(client.header, response.Metadata.Lossless)
+	if client.header.Async {
+		log.Printf("Job created for %s: %s", result.input, response.Metadata.JobID)
+		fmt.Println(prefix, "job", response.Metadata.JobID)
+		return nil
+	}
+	if estimate := response.Metadata.Estimate; estimate != nil {
+		fmt.Printf("%s %.1f ms (%dx%d pixels, %d chunks)\n", prefix, estimate.Millis, estimate.Width, estimate.Height,
+			estimate.Chunks)
+		return nil
+	}
+	reportTimings(response.Trailer, false)
+	client.saveArtifacts(result.input, result.index+1, response.Artifacts)
+
+	var source []byte
+	if client.report {
+		var err error
+		if source, err = os.ReadFile(result.input); err != nil {
+			log.Printf("Error reading %s for its report: %v", result.input, err)
+		}
+	}
+
+	if client.archive != nil {
+		var report []byte
+		if client.report {
+			var err error
+			if report, err = client.buildReport(result.input, source, response); err != nil {
+				log.Printf("Error making the report of %s: %v", result.input, err)
+			}
+		}
+		name, err := client.archive.add(client, result, report)
+		if err != nil {
+			fmt.Println(prefix, "error:", err)
+			log.Printf("Error adding the result of %s to %s: %v", result.input, client.archive.path, err)
+			return err
+		}
+		log.Printf("Processed image added to %s: %s", client.archive.path, name)
+		fmt.Println(prefix, name)
+		return nil
+	}
+	client.saveText(result.input, result.index+1, response.Metadata.Text)
+	client.saveReport(result.input, result.index+1, source, response)
+	path, err := client.writeOutput(result.input, response.Metadata.Format, result.index+1, response.Data)
+	if errors.Is(err, errOutputExists) {
+		fmt.Println(prefix, "skipped,", path, "already exists")
+		log.Printf("Result of %s not saved: %s already exists", result.input, path)
+		return nil
+	}
+	if err != nil {
+		fmt.Println(prefix, "error:", err)
+		log.Printf("Error writing the result of %s: %v", result.input, err)
+		return err
+	}
+	log.Printf("Processed image saved: %s", path)
+	fmt.Println(prefix, path)
+	return nil
+}
+
+func batchTile(result batchResult, err error) imageUtils.Tile {
+	tile := imageUtils.Tile{Caption: filepath.Base(result.input)}
+	if err != nil {
+		tile.Caption += "\nFAILED"
+		return tile
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(result.response.Data))
+	if err != nil {
+		log.Printf("Error decoding the result of %s for the contact sheet: %v", result.input, err)
+		tile.Caption += "\nNO PREVIEW"
+		return tile
+	}
+	tile.Image = imageUtils.Thumbnail(img, contactSheetCell)
+	return tile
+}
+
+func writeContactSheet(path string, tiles []imageUtils.Tile) error {
+	format, ok := contactSheetFormat(path)
+	if !ok {
+		return fmt.Errorf("unsupported extension: %s", filepath.Ext(path))
+	}
+	return imageUtils.SaveImage(imageUtils.ContactSheet(tiles, 0, contactSheetCell), path, format)
+}
+
+func contactSheetFormat(path string) (string, bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg":
+		return "jpeg", true
+	case ".png":
+		return "png", true
+	default:
+		return "", false
+	}
+}
+
+func printBatchSummary(total int, failures []batchFailure, elapsed time.Duration) {
+	fmt.Printf("%d images processed in %s: %d succeeded, %d failed\n", total, elapsed.Round(time.Millisecond),
+		total-len(failures), len(failures))
+	log.Printf("Batch finished in %s: %d succeeded, %d failed", elapsed, total-len(failures), len(failures))
+
+	slices.SortFunc(failures, func(a, b batchFailure) int {
+		return strings.Compare(a.input, b.input)
+	})
+	for _, failure := range failures {
+		var errorMessage protocol.ErrorMessage
+		if errors.As(failure.err, &errorMessage) {
+			fmt.Printf("  %s: server error: %s\n", failure.input, errorMessage.Message)
+		} else {
+			fmt.Printf("  %s: %v\n", failure.input, failure.err)
+		}
+	}
+}
+
+func connectionLost(err error) bool {
+	var errorMessage protocol.ErrorMessage
+	if errors.As(err, &errorMessage) {
+		return errorMessage.Code == protocol.CodeBusy
+	}
+	return err != nil && !errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+func connectionRefused(err error) bool {
+	var errorMessage protocol.ErrorMessage
+	return errors.As(err, &errorMessage) && errorMessage.Code == protocol.CodeBusy
+}
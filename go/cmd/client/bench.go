@@ -0,0 +1,256 @@
+package main
+
+/*
+This file implements the `client bench` subcommand, which measures the throughput of a server for capacity planning:
+it sends the same image again and again on several connections at once for a given duration, then prints the
+number of images processed per second and the percentiles of the latency of the requests.
+
+Every request asks the server not to answer from its cache of duplicate submissions (`protocol.Header.NoCache`), so
+each of them goes through the whole pipeline. Servers released before that option answer the repeated image from
+their cache, which the very low latency of the requests then shows. The connections of a benchmark all come from
+the same host: a server limiting the connections per host (`-max-conns-per-host`) refuses the ones over its limit,
+so run it with a higher limit to benchmark more connections.
+
+A request in progress when the duration elapses is waited for and counted. A request failing with an error of the
+server (e.g. no document found) counts as an error; the connection of a request whose connection was lost is opened
+again. The errors do not stop the benchmark, but the command then exits with status 1.
+
+---
+
+### Constants
+- `defaultBenchDuration`, `defaultBenchConcurrency`: Duration and number of connections of a benchmark by default,
+  the number of connections being the limit per host of a server with its default configuration.
+
+---
+
+### `benchSample`
+Outcome of a request of the benchmark: its latency, from the first byte sent to the end of the response, the
+processing time reported by the server (`protocol.TransferStats.ProcessMillis`, 0 if not reported), and its error.
+
+---
+
+### `runBench(args []string) int`
+Parses the flags of the subcommand, runs the benchmark on the image given as argument and prints its report.
+Returns the exit status of the command: 0 if every request succeeded, 1 if some failed, 2 if the benchmark could
+not run.
+
+- Flags:
+  - `-server`, `-balance`, `-network`, `-token`, `-encoding`: The server and the connection, as for a single image.
+  - `-local`, `-workers`: Benchmarks the server embedded in the client instead (see `local.go`), to measure the
+    pipeline without the network.
+  - `-op`, `-preset`, `-format`, `-page`, `-dpi`: The request sent, as for a single image.
+  - `-concurrency`: Number of connections sending requests at once.
+  - `-duration`: How long new requests are sent.
+
+### `(client *Client) benchConnection(data []byte, deadline time.Time, samples chan<- benchSample)`
+Sends the image on one connection until `deadline`, reporting every request to `samples`.
+
+### `printBenchReport(samples []benchSample, elapsed time.Duration, size int)`
+Prints the number of requests and errors, the throughput, and the latency percentiles of the successful requests.
+
+### `latencyPercentile(sorted []time.Duration, percent float64) time.Duration`
+Returns the `percent` percentile of sorted latencies, by the nearest-rank method.
+
+---
+
+### Example Usage:
+```
+./client bench -server scan1:14750 -concurrency 8 -duration 1m path/to/photo.jpg
+./client bench -local -workers 4 -preset receipt path/to/receipt.jpg
+```
+*/
+
+import (
+	clientlib "ELP-project/internal/client"
+	"ELP-project/internal/netUtils"
+	"ELP-project/internal/protocol"
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBenchDuration    = 30 * time.Second
+	defaultBenchConcurrency = 2
+)
+
+type benchSample struct {
+	latency    time.Duration
+	processing time.Duration
+	err        error
+}
+
+func runBench(args []string) int {
+	flagSet := flag.NewFlagSet("bench", flag.ContinueOnError)
+	server := flagSet.String("server", net.JoinHostPort(defaultHost, defaultPort), "address of the server, or a comma-separated list of servers")
+	balance := flagSet.String("balance", balanceRoundRobin, "with several servers, how the server of every connection is chosen: round-robin or latency")
+	network := flagSet.String("network", "tcp", "network of the server: tcp, or unix with the path of its socket as address")
+	token := flagSet.String("token", "", "API key sent to the server (default $"+tokenEnvironment+")")
+	encoding := flagSet.String("encoding", "protobuf", "encoding of the control messages: protobuf, or json for older servers")
+	local := flagSet.Bool("local", false, "benchmark the server embedded in the client")
+	workers := flagSet.Int("workers", 0, "with -local, workers processing the images (number of CPU cores if 0)")
+	operation := flagSet.String("op", protocol.OperationCrop, "result asked for: crop, grayscale, edges, scan, corners or estimate")
+	preset := flagSet.String("preset", "", "kind of document the processing is tuned for: document, whiteboard, receipt or photo")
+	format := flagSet.String("format", "", "format of the result: png or jpeg")
+	pageSize := flagSet.String("page", "", "page size of the output (A4, A5, Letter, Legal or WxH in millimeters)")
+	dpi := flagSet.Int("dpi", 0, "resolution of the output page in dots per inch")
+	concurrency := flagSet.Int("concurrency", defaultBenchConcurrency, "number of connections sending requests at once")
+	duration := flagSet.Duration("duration", defaultBenchDuration, "how long new requests are sent")
+	if err := flagSet.Parse(args); err != nil {
+		return 2
+	}
+	if flagSet.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: ./client bench [-server address] [-concurrency n] [-duration d] [flags] <image_file_path>")
+		return 2
+	}
+	if *concurrency < 1 || *duration <= 0 {
+		fmt.Fprintln(os.Stderr, "-concurrency and -duration must be positive")
+		return 2
+	}
+
+	data, err := os.ReadFile(flagSet.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading the image:", err)
+		return 2
+	}
+
+	address := *server
+	if *local {
+		localAddress, stop, err := startLocalServer(*workers)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error starting the local server:", err)
+			return 2
+		}
+		defer stop()
+		address = localAddress
+		*network = "tcp"
+	}
+	addresses, err := parseServers(address, *network)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid server address:", err)
+		return 2
+	}
+	servers, err := newServerPool(addresses, *balance)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 2
+	}
+	if *token == "" {
+		*token = os.Getenv(tokenEnvironment)
+	}
+
+	header := protocol.Header{
+		Operation: *operation,
+		Preset:    *preset,
+		Format:    *format,
+		PageSize:  *pageSize,
+		DPI:       *dpi,
+		NoCache:   true,
+	}
+	client := newClient(*network, servers, header, netUtils.DefaultSocketOptions(), *token)
+	client.codec = parseEncoding(*encoding)
+
+	fmt.Printf("Benchmarking %s on %d connections for %s with %s (%d bytes)...\n", servers, *concurrency, *duration, flagSet.Arg(0), len(data))
+	log.Printf("Benchmark of %s: %d connections, %s", servers, *concurrency, *duration)
+
+	samples := make(chan benchSample, *concurrency)
+	var connections sync.WaitGroup
+	start := time.Now()
+	deadline := start.Add(*duration)
+	for range *concurrency {
+		connections.Add(1)
+		go func() {
+			defer connections.Done()
+			client.benchConnection(data, deadline, samples)
+		}()
+	}
+	go func() {
+		connections.Wait()
+		close(samples)
+	}()
+
+	var results []benchSample
+	for sample := range samples {
+		if sample.err != nil {
+			log.Printf("Benchmark request failed: %v", sample.err)
+		}
+		results = append(results, sample)
+	}
+	printBenchReport(results, time.Since(start), len(data))
+
+	if slices.ContainsFunc(results, func(sample benchSample) bool { return sample.err != nil }) {
+		return 1
+	}
+	return 0
+}
+
+func (client *Client) benchConnection(data []byte, deadline time.Time, samples chan<- benchSample) {
+	var conn *clientlib.Client
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	for time.Now().Before(deadline) {
+		if conn == nil {
+			conn = client.connect()
+		}
+
+		start := time.Now()
+		response, err := conn.Do(client.header, bytes.NewReader(data), int64(len(data)))
+		sample := benchSample{latency: time.Since(start), err: err}
+		if stats := response.Metadata.Stats; stats != nil {
+			sample.processing = time.Duration(stats.ProcessMillis * float64(time.Millisecond))
+		}
+		samples <- sample
+
+		if err != nil && connectionLost(err) {
+			conn.Close()
+			conn = nil
+		}
+	}
+}
+
+func printBenchReport(samples []benchSample, elapsed time.Duration, size int) {
+	var latencies []time.Duration
+	var processing time.Duration
+	for _, sample := range samples {
+		if sample.err == nil {
+			latencies = append(latencies, sample.latency)
+			processing += sample.processing
+		}
+	}
+	slices.Sort(latencies)
+
+	seconds := elapsed.Seconds()
+	fmt.Printf("Requests: %d in %.1f s, %d failed\n", len(samples), seconds, len(samples)-len(latencies))
+	fmt.Printf("Throughput: %.2f images/s, %.2f MB/s sent\n", float64(len(latencies))/seconds, float64(len(latencies)*size)/1e6/seconds)
+	if len(latencies) == 0 {
+		return
+	}
+
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+	fmt.Printf("Latency: mean %s, processing %s on the server\n", (total / time.Duration(len(latencies))).Round(time.Microsecond*100),
+		(processing / time.Duration(len(latencies))).Round(time.Microsecond*100))
+	for _, percent := range []float64{50, 90, 95, 99, 100} {
+		fmt.Printf("  p%-4g %10s\n", percent, latencyPercentile(latencies, percent).Round(time.Microsecond*100))
+	}
+	log.Printf("Benchmark: %d requests, %d failed, %.2f images/s, p50 %s, p99 %s", len(samples), len(samples)-len(latencies),
+		float64(len(latencies))/seconds, latencyPercentile(latencies, 50), latencyPercentile(latencies, 99))
+}
+
+func latencyPercentile(sorted []time.Duration, percent float64) time.Duration {
+	rank := int(math.Ceil(percent / 100 * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
@@ -0,0 +1,84 @@
+package main
+
+/*
+This file implements the two-sided documents of the client: with `-back <path>`, the image argument is the front of
+a document, e.g. an identity card scanned with `-preset id-card`, and `-back` its back, captured separately. Both are
+sent on the same connection and their results are composed into one output image, the front above the back, in the
+format of the result.
+
+---
+
+### Constants
+- `cardGap`: Height in pixels of the white band between the front and the back, as a fraction of the width of the
+  result.
+
+---
+
+### `Client.sendBack(conn *clientlib.Client) clientlib.Response`
+Sends the image of `-back` on `conn`, with the options of the front, and returns its response.
+
+- **Exits**:
+  - If the image cannot be read, or the server fails to process it.
+
+### `composeSides(front, back []byte, format string) ([]byte, error)`
+Decodes the results of the front and the back, lays them out one below the other on a white background, the back
+after a band of `cardGap`, and encodes the composition in `format`.
+*/
+
+import (
+	clientlib "ELP-project/internal/client"
+	"ELP-project/internal/imageUtils"
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+)
+
+const cardGap = 0.05
+
+func (client *Client) sendBack(conn *clientlib.Client) clientlib.Response {
+	input, size, name, err := openInput(client.back)
+	if err != nil {
+		log.Fatalf("error opening back image file: %v", err)
+	}
+	defer func(input io.ReadCloser) {
+		err := input.Close()
+		if err != nil {
+			log.Fatalf("Error closing file: %v", err)
+		}
+	}(input)
+
+	log.Printf("Sending back image %s...", name)
+	return client.sendImage(input, size, name, conn)
+}
+
+func composeSides(front, back []byte, format string) ([]byte, error) {
+	frontImage, _, err := image.Decode(bytes.NewReader(front))
+	if err != nil {
+		return nil, err
+	}
+	backImage, _, err := image.Decode(bytes.NewReader(back))
+	if err != nil {
+		return nil, err
+	}
+
+	width := max(frontImage.Bounds().Dx(), backImage.Bounds().Dx())
+	gap := image.NewRGBA(image.Rect(0, 0, width, max(int(float64(width)*cardGap), 1)))
+	draw.Draw(gap, gap.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	composed := imageUtils.StackImages(frontImage, gap, backImage)
+
+	var encoded bytes.Buffer
+	if format == "png" {
+		err = png.Encode(&encoded, composed)
+	} else {
+		err = jpeg.Encode(&encoded, composed, nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return encoded.Bytes(), nil
+}
@@ -0,0 +1,99 @@
+package main
+
+/*
+This file implements the images given as `http://` or `https://` URLs instead of paths, e.g. scans living in cloud
+storage behind a (presigned) link: the client downloads the image and streams it to the server as it arrives, then
+saves the result locally like for a file, named after the last segment of the URL path.
+
+---
+
+### Constants
+- `downloadHeaderTimeout`: Time the client waits for the headers of the response of the image URL, the body being
+  streamed to the server without limit.
+- `downloadName`: Name given to an image whose URL has no path, e.g. `https://example.com/?id=42`.
+
+---
+
+### `isURL(path string) bool`
+Reports whether the image argument is an `http://` or `https://` URL rather than a path.
+
+### `openURL(rawURL string) (io.ReadCloser, int64, string, error)`
+Requests the image at `rawURL` and returns the body of the response, its size and the name of the image. The body is
+streamed when the server of the URL announces its size, and read whole otherwise, since the size of the image is sent
+before it. The name is the last segment of the URL path, its extension being derived from the `Content-Type` of the
+response when it has none, e.g. `scan.jpg` for `https://example.com/scans/scan?format=jpeg`.
+
+- **Errors**:
+  - If the URL cannot be reached, or its response is not a success (e.g. `404 Not Found`, or `403 Forbidden` for an
+    expired presigned URL).
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	downloadHeaderTimeout = 30 * time.Second
+
+	downloadName = "download"
+)
+
+var downloadClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		ResponseHeaderTimeout: downloadHeaderTimeout,
+	},
+}
+
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+func openURL(rawURL string) (io.ReadCloser, int64, string, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	response, err := downloadClient.Get(target.String())
+	if err != nil {
+		return nil, 0, "", err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		response.Body.Close()
+		return nil, 0, "", fmt.Errorf("downloading %s: %s", rawURL, response.Status)
+	}
+
+	name := path.Base(target.Path)
+	if name == "/" || name == "." {
+		name = downloadName
+	}
+	if path.Ext(name) == "" {
+		if mediaType, _, err := mime.ParseMediaType(response.Header.Get("Content-Type")); err == nil {
+			switch mediaType {
+			case "image/jpeg":
+				name += ".jpg"
+			case "image/png":
+				name += ".png"
+			}
+		}
+	}
+
+	if response.ContentLength >= 0 {
+		return response.Body, response.ContentLength, name, nil
+	}
+	data, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("downloading %s: %w", rawURL, err)
+	}
+	return io.NopCloser(bytes.NewReader(data)), int64(len(data)), name, nil
+}
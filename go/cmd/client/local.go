@@ -0,0 +1,74 @@
+package main
+
+/*
+This file implements the local mode of the client (`-local`): instead of contacting a server, the client starts the
+processing server of `pkg/server` inside its own process, on an ephemeral port of the loopback interface, and sends
+the images to it as it would to a remote server. The whole pipeline (grayscale, Canny edge detection, contours,
+crop) and every option of the requests (page size, format, anonymization, stamps, corners, batches on several
+connections) thus work offline, exactly as on a server running the same version.
+
+The embedded server logs to `client.log`, its lines prefixed by `local server: `. Its requests cannot be
+asynchronous, since the jobs would die with the process: its job directory is removed as soon as it started.
+
+---
+
+### Constants
+- `localShutdownTimeout`: Longest time the embedded server waits for its requests once the client is done.
+
+---
+
+### `startLocalServer(workers int) (string, func(), error)`
+Starts the embedded server with `workers` workers (number of CPU cores if 0), and returns its address and the
+function stopping it.
+*/
+
+import (
+	serverlib "ELP-project/pkg/server"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"time"
+)
+
+const localShutdownTimeout = 10 * time.Second
+
+func startLocalServer(workers int) (string, func(), error) {
+	jobsDir, err := os.MkdirTemp("", "elp-local-")
+	if err != nil {
+		return "", nil, fmt.Errorf("error creating job directory: %w", err)
+	}
+	defer os.RemoveAll(jobsDir)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, fmt.Errorf("error listening on an ephemeral port: %w", err)
+	}
+
+	config := serverlib.DefaultConfig()
+	config.Listener = listener
+	config.JobsDir = jobsDir
+	config.Workers = workers
+	config.MaxConnectionsPerHost = 0
+	config.Logger = log.New(log.Writer(), "local server: ", log.LstdFlags)
+
+	server, err := serverlib.New(config)
+	if err != nil {
+		listener.Close()
+		return "", nil, err
+	}
+	if err := server.Start(context.Background()); err != nil {
+		listener.Close()
+		return "", nil, err
+	}
+
+	stop := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), localShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Error stopping the local server: %v", err)
+		}
+	}
+	return server.Addr().String(), stop, nil
+}
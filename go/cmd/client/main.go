@@ -10,12 +10,147 @@ and then receives a processed image from the server, saving it locally.
 ### Key Features
 - **Server Connection**:
   - Connects to a TCP server for communication.
-  - Default server address is `localhost:14750`.
+  - Default server address is `localhost:14750`, another one is given by `-server` (or as second argument).
+  - `-server` also accepts a comma-separated list of servers: every connection goes to one of them, in turn or the
+    fastest one as selected by `-balance round-robin|latency`, and fails over to the next one if its server cannot be
+    reached (see `servers.go`).
+  - With `-network unix`, connects to the Unix domain socket of a local server instead, the server address being the
+    path of the socket (default `/tmp/elp-project.sock`).
+  - Authenticates with the API key given by `-token`, or by the `ELP_API_KEY` environment variable, when set.
+  - With `-timeout`, gives up if the server has not answered within that time, connection included.
 - **Image File Transmission**:
-  - Sends an image file to the server using a buffered approach.
+  - Sends an image file to the server using the client library (`internal/client`).
+  - The image may also be an `http://` or `https://` URL, e.g. a presigned link to a scan in cloud storage: the
+    client downloads it, streaming it to the server as it arrives, and saves the result locally, named after the
+    URL (see `download.go`).
   - Receives the processed image file from the server and saves it locally.
+- **Page Size Selection**:
+  - The `-page` and `-dpi` flags ask the server to scale the result to a physical page size (A4, Letter, ...).
+- **Output Control**:
+  - `-op` selects what the server returns: the cropped document (`crop`, the default), the grayscale image
+    (`grayscale`), the edge map (`edges`), or the cropped document with the look of a scan (`scan`): white paper and a
+    boosted contrast, binarized into black and white with `-binarize`, whose specks and pinholes of fewer than 8 pixels
+    are cleaned up (`-speck-size` and `-pinhole-size` change these sizes, -1 keeps them all). `-op estimate` prints the
+    expected processing cost of the image (megapixels, chunks, time per stage) instead, the server reading only the
+    header of the image. `-op corners` prints the corners and the area of the detected document as JSON instead of
+    downloading the cropped image, for callers doing their own cropping; with `-o`, or for a batch, the JSON is saved
+    like an image result. With `-multi`, the JSON also lists every document found on the photo, e.g. several receipts
+    laid on a table, those smaller than `-min-area` (a fraction of the photo) or thinner than `-min-aspect` (the ratio
+    of their shorter side to their longer one) being left out.
+  - `-format png|jpeg|pdf` selects the format of the result, the format of the sent image by default. `pdf` gives a
+    PDF of one page, at the physical size of the document; with `-ocr`, it is searchable: its text can be found and
+    selected over the image.
+  - `-accept webp,png` lists the formats the result can be returned in, in order of preference, when `-format` is
+    not given: the server returns the first of them it can produce, e.g. a lossless PNG of a JPEG photo, and the
+    result is saved with the extension of the format it picked.
+  - `-preset` tunes the processing for the kind of document photographed: `document` (printed pages, the default),
+    `whiteboard`, `receipt` (thermal receipts) or `photo` (photo prints). A preset sets the edge detection and the
+    format of the result, which `-format` still overrides. `-preset whiteboard` also cleans the board up: a white
+    background whatever the lighting, and saturated marker strokes. `-preset receipt` straightens a tilted receipt,
+    however long, and outputs it at a resolution suited to OCR (80 mm wide at 400 dpi, or the `-page` and `-dpi`
+    given). `-preset id-card` checks that the document has the proportions of an identity or bank card (ISO/IEC 7810
+    ID-1) and outputs every card at the same size, 85.60 x 53.98 mm at 300 dpi. `-preset book` straightens the pages
+    of an open book and splits a double-page spread into its two pages, saved as a ZIP archive (or a PDF of two
+    pages with `-format pdf`).
+  - `-border` sets what becomes of the contours touching the border of the photo, often the background cut by the
+    frame (a table, a keyboard) outgrowing the document: `keep` (the default), `penalize` to only pick them if no
+    other contour comes close in area, or `discard` to never pick them, for documents photographed with a margin.
+  - `-detector hough` finds the document as the quadrilateral of the four dominant straight lines of the photo
+    instead of its largest contour, which still finds the corners of a page partly hidden, e.g. by the hand holding
+    it. The contour is used when the lines do not make a quadrilateral. `-detector ransac` keeps the largest
+    contour, but fits its four sides as straight lines, so its corners are not cut when they are rounded or hidden.
+  - `-edge-detector log` detects the edges of the photo as the zero crossings of its Laplacian of Gaussian instead of
+    with the Canny detector (`canny`, the default), which keeps them closed at the corners and along curved borders;
+    compare both with `-op edges` on a difficult scan.
+  - `-shape <page size>` prefers the documents of the proportions of a page size, e.g. `A4` or `Letter`, over a
+    larger table edge or window frame of another shape.
+  - `-centering <weight>` prefers the documents near the center of the photo, from 0 (the default, the largest one
+    wins) to 1, e.g. when a larger sheet lies at the edge of the table.
+  - `-back <path>` sends a second image, the back of a two-sided document such as an ID card, and composes both
+    results into one image, the front above the back (see `card.go`).
+  - `-o <path>` writes the result to that path, replacing any existing file, instead of the generated name.
+  - `-ocr` also asks for the text of the document, recognized by the server, and saves it beside the result as a
+    `.txt` file, e.g. to feed a search index. The server must have a text recognizer (`server -ocr`).
+  - `-orient` asks the server to turn the document upright, e.g. a page photographed upside down, from the
+    orientation its text is read best in. Slow: the text is recognized in the four orientations.
+  - `-deskew` asks the server to straighten the document when its text lines are slanted by a few degrees, e.g. a
+    page photographed nearly flat but turned.
+  - `-fill-holes` asks the server to paint over the punched holes along the edges of the document and the staple in
+    its corners, for the pages taken out of a binder.
+  - `-flatten` asks the server to flatten the illumination of the photo before detecting the document, so the border
+    of a shadow cast on the page, e.g. by the hand of the photographer, is not mistaken for an edge.
+  - `-white-balance` chooses the white balance of the cropped document: `auto` (the default) corrects the color cast
+    of its paper when it is visible, `white-patch` always balances its paper, `gray-world` always balances its mean
+    color, e.g. for a document with little paper showing, and `off` keeps its colors.
+  - `-lossless` asks the server to cut the document out of a JPEG photo without encoding it again, so it keeps the
+    quality of the photo. Only for a crop along the bounding box of the document, in JPEG, without `-page` nor the
+    options changing its pixels; the images that cannot be cropped losslessly are cropped as usual.
+  - `-tone gamma=1.8,brightness=0.05,contrast=0.3` asks the server to adjust the tone of the cropped document, any of
+    the three being optional, and `-detection-tone` with the same syntax that of the grayscale image the document
+    is detected on, e.g. `-detection-tone gamma=2` to find a page in a dark photo.
+  - `-session <id>` adds the cropped documents to a scan session of the server, one page per image: the images of a
+    directory or a pattern are numbered in the order of their names. `-finalize <path>` then gets the pages of the
+    session combined into one `.pdf` (searchable with `-ocr`) or `.zip` file, once the images given are sent, or
+    alone to finalize a session scanned by earlier runs.
+  - `-deterministic` asks for a result bit-identical across runs and servers, for archives checked by checksum.
+  - `-anonymize` asks the server to blur the photos found on the document (faces, ID photos), e.g. before
+    archiving identity cards. It cannot be combined with `-artifacts`, apart from `-artifacts histograms`.
+  - `-stamp <text>` asks the server to stamp the document with a text in a red frame, e.g. `COPY` or
+    `'SCANNED {date}'` (placeholders `{date}`, `{time}` and `{request}`), and `-stamp-image <path>` with an image
+    such as a logo instead. `-stamp-position` places the stamp at a corner (`top-left`, `top-right`, `bottom-left`,
+    `bottom-right`, the default) or at the `center`, `-stamp-opacity` sets its opacity (0.6 by default).
+- **Asynchronous Jobs**:
+  - With `-async`, the server answers immediately with a job ID and processes the image in the background.
+  - With `-webhook <url>`, the server POSTs the state of the job to that URL once it is finished, so nobody has to
+    poll it.
+  - With `-job <id>`, the client fetches the result of a job, and keeps checking it every `-poll` interval
+    until it is finished. If the connection is lost while the result is downloaded, the client reconnects and
+    resumes the download from the last byte received instead of downloading the whole result again.
+- **Progress Bar**:
+  - With `-progress`, the server reports each completed stage of the processing (grayscale, edges, contours,
+    cropping) and the client draws a progress bar on the standard error.
+- **Intermediate Results**:
+  - With `-artifacts grayscale,edges,contours`, the server also returns the grayscale image, the edge map and the
+    detected contours drawn over the photo. They are saved as `output_<name>_<artifact>.png`, even when the
+    document is not found, to understand why the detection failed. `-artifacts histograms` also returns the
+    histograms of the luminance and of the gradients of the image, with the thresholds of the edge detection.
+- **Timing Report**:
+  - Every response ends with the time the server spent in each stage (upload, grayscale, blur, Sobel, NMS,
+    hysteresis, contours, document detection, crop, enhancement, anonymization, stamp, encoding, sending), written to
+    `client.log`. With `-timings`, it is also printed as a table on the standard error.
+- **Processing Report**:
+  - With `-report`, every result is saved with a small self-contained HTML page auditing it, e.g.
+    `output_scan.html`, or added to the `-zip` archive: the thumbnails of the photo, with the corners of the detected
+    document drawn over it, of the intermediate images and of the result, the options of the request and the time
+    spent in every stage, so the result can be checked by anyone with a browser (see `report.go`). The intermediate
+    images are asked for without being saved, unless `-artifacts` names them.
+- **Batch Processing**:
+  - Given a directory or a glob pattern (e.g. `'scans/*.jpg'`) instead of an image, the client sends every image
+    and writes the results to `-out-dir`, named by the `-name` template (see `batch.go`).
+  - `-parallel <n>` sends the images of a batch on `n` connections at once, and the batch ends with a summary of the
+    images which succeeded and failed.
+  - `-contact-sheet <path>` also writes one overview image (`.png` or `.jpg`) tiling the thumbnails of the results,
+    captioned with the names of the images, to check a whole scanning session at a glance.
+  - `-zip <path>` writes the results into one ZIP archive instead, with their text and a `manifest.json` listing
+    every image, its result and its error (see `archive.go`).
+- **Benchmark**:
+  - `./client bench [flags] <image>` sends the same image on `-concurrency` connections for `-duration`, and prints
+    the throughput (images per second) and the latency percentiles, for capacity planning (see `bench.go`).
+- **Local Mode**:
+  - `-local` processes the images inside the client, with the pipeline of the server embedded in it, so the client
+    works offline (see `local.go`). Every option works as with a server, except the asynchronous jobs. `-workers`
+    sets the number of workers of the embedded server, the number of CPU cores by default.
+- **Standard Streams**:
+  - `-` as image reads the image from the standard input, and writes the result to the standard output unless `-o`
+    is given, so the client composes with shell pipelines: `./client - < scan.jpg > cropped.jpg`.
+  - `-o -` writes the result to the standard output, whatever the input.
+  - Error messages go to the standard error, so they never end up in a piped result.
 - **Dynamic File Handling**:
-  - If a file with the same output name exists, generates a new name to avoid overwriting.
+  - Without `-o`, the results are named by the `-name` template, `output_{{.Stem}}.{{.Ext}}` by default, e.g.
+    `-name '{{.Stem}}_scanned.{{.Ext}}'`, for single images, jobs and batches alike (see `naming.go`).
+  - If a file with the same name exists, `-collision` keeps both by adding a number to the new name (`suffix`, the
+    default), replaces it (`overwrite`) or keeps it and drops the result (`skip`).
+  - `-out-dir` selects the directory the results are written to, created if needed.
 
 ---
 
@@ -23,7 +158,12 @@ and then receives a processed image from the server, saving it locally.
 
 - `defaultHost`: The default hostname of the server (`"localhost"`).
 - `defaultPort`: The default port of the server (`"14750"`).
-- `bufferSize`: Buffer size (in bytes) used for reading/writing data (`1024`).
+- `defaultSocketPath`: The default path of the Unix socket of the server (`"/tmp/elp-project.sock"`).
+- `tokenEnvironment`: The environment variable holding the default API key (`"ELP_API_KEY"`).
+- `progressWidth`: Width of the progress bar, in characters.
+- `stdioPath`: Path standing for the standard input as image, or the standard output as `-o` (`"-"`).
+- `jobResumes`: Number of times the download of the result of a job is resumed on a new connection before the
+  client gives up.
 
 ---
 
@@ -33,47 +173,166 @@ and then receives a processed image from the server, saving it locally.
 Defines the TCP client for communication with the server.
 
 - **Fields**:
-  - `host string`: The server's hostname.
-  - `port string`: The server's port.
+  - `network string`: The network of the server (`tcp` or `unix`).
+  - `servers *serverPool`: The servers' addresses, `host:port` or the path of a Unix socket, and the policy choosing
+    the server of every connection.
+  - `header protocol.Header`: The request options sent before the image.
+  - `socket netUtils.SocketOptions`: Tuning of the connection (kernel buffers, `TCP_NODELAY`, write coalescing).
+  - `token string`: API key sent to the server, empty if the server does not require authentication.
+  - `timings bool`: Whether the timing report of the responses is printed.
+  - `codec protocol.Codec`: Encoding of the control messages, set by the `-encoding` flag.
+  - `output string`: Path the result is written to, set by the `-o` flag. Empty for the generated name, `-` for the
+    standard output.
+  - `outDir string`: Directory the results are written to, set by the `-out-dir` flag. Empty for the working
+    directory.
+  - `nameTemplate *template.Template`: Template naming the results, set by the `-name` flag.
+  - `collision string`: What is done when a named result already exists, set by the `-collision` flag.
+  - `deadline time.Time`: Time after which the client gives up, set by the `-timeout` flag. Zero for no limit.
+  - `parallel int`: Number of connections the images of a batch are sent on, set by the `-parallel` flag.
+  - `contactSheet string`: Path the contact sheet of a batch is written to, set by the `-contact-sheet` flag. Empty
+    for no contact sheet.
+  - `zipPath string`: Path of the ZIP archive the results of a batch are written to, set by the `-zip` flag. Empty
+    for one file per result.
+  - `archive *batchArchive`: The archive being written by the batch, nil without `-zip` (see `archive.go`).
+  - `back string`: Image of the back of the document, set by the `-back` flag. Empty for a one-sided document.
+  - `artifacts []string`: Intermediate images saved beside the results, set by the `-artifacts` flag. The header
+    also asks for those of the report with `-report`, which are not saved.
+  - `report bool`: Whether a processing report is saved with every result, set by the `-report` flag (see
+    `report.go`).
 
 - **Methods**:
-  - `connect() net.Conn`: Establishes a connection to the server and returns the connection object.
-  - `sendImage(file *os.File, conn net.Conn)`: Sends the specified image file to the server.
-  - `receiveImage(conn net.Conn, file *os.File)`: Receives the processed image from the server and saves it locally.
+  - `connect() *clientlib.Client`: Establishes a connection to one of the servers and returns the connection object.
+  - `sendImage(input io.Reader, size int64, name string, conn *clientlib.Client) clientlib.Response`: Sends an image
+    to the server and returns its response.
+  - `fetchJob(jobID string, poll time.Duration)`: Fetches the result of an asynchronous job.
+  - `finalizeSession(session string, path string)`: Fetches the pages of a scan session combined into one file.
+  - `saveResult(inputPath string, format string, data []byte)`: Saves the result of a request to the `-o` path (the
+    standard output for `-`), or under the name of the `-name` template.
+  - `saveArtifacts(inputPath string, index int, artifacts []protocol.Artifact)`: Saves the intermediate images of a
+    response.
+  - `saveText(inputPath string, index int, text string)`: Saves the text recognized on a document.
+  - `saveReport(inputPath string, index int, source []byte, response clientlib.Response)`: Saves the processing
+    report of an image (see `report.go`).
   - `run(imageFilePath string)`: Coordinates the process of connecting, sending, and receiving.
 
 ---
 
 ### Functions
 
-#### `newClient(host string, port string) *Client`
+#### `newClient(network string, servers *serverPool, header protocol.Header, socket netUtils.SocketOptions, token string) *Client`
 Creates and initializes a new instance of `Client`.
 
 - **Parameters**:
-  - `host string`: Hostname of the server.
-  - `port string`: Port of the server.
+  - `network string`: Network of the server, set by the `-network` flag.
+  - `servers *serverPool`: Addresses of the servers, set by the `-server` and `-balance` flags.
+  - `header protocol.Header`: Options of the request (page size, resolution).
+  - `socket netUtils.SocketOptions`: Socket options of the connection, set by the `-so-rcvbuf`, `-so-sndbuf`,
+    `-nodelay` and `-io-buffer` flags.
+  - `token string`: API key of the client.
 - **Returns**:
   - A pointer to a new `Client` instance.
 
-#### `Client.connect() net.Conn`
-Connects to the specified server and returns the established connection.
+#### `Client.connect() *clientlib.Client`
+Connects to a server chosen by the balancing policy, authenticates if an API key is set, and returns the established
+connection. The servers which cannot be reached are skipped, in the order of the policy. The connection and its reads
+and writes fail once the deadline of the client is reached.
 
-- **Panics**:
-  - If the connection fails.
+- **Exits**:
+  - If no server can be reached, or the authentication fails.
 
-#### `Client.sendImage(file *os.File, conn net.Conn)`
-Sends the given image file to the server using the specified connection.
+#### `Client.sendImage(input io.Reader, size int64, name string, conn *clientlib.Client) clientlib.Response`
+Sends the given image to the server using the specified connection and waits for the response.
 
 - **Parameters**:
-  - `file *os.File`: The file object of the image to send.
-  - `conn net.Conn`: The connection object.
+  - `input io.Reader`: The content of the image to send, of `size` bytes.
+  - `name string`: The name of the image, naming its intermediate images.
+  - `conn *clientlib.Client`: The connection object.
+- **Returns**:
+  - The response of the server: the processed image, or the ID of the job for an asynchronous request.
+- **Exits**:
+  - If the server answered with an error, the error message is printed and the client stops, after saving the
+    intermediate images received before the error.
 
-#### `Client.receiveImage(conn net.Conn, file *os.File)`
-Receives a file from the server and writes it to the specified file object.
+#### `Client.fetchJob(jobID string, poll time.Duration)`
+Queries an asynchronous job. If the job is done, its result is saved as `output_<id>.<format>` (or to the `-o` path),
+otherwise its state is printed. If `poll` is positive, the job is queried again at that interval until it is finished.
+A connection lost during the query is replaced, at most `jobResumes` times, and the download of the result goes on
+from the bytes already received (`protocol.Header.Offset`). A server which ignores the offset, released before
+resumable downloads, sends the whole result again, which replaces the received bytes.
 
-- **Parameters**:
-  - `conn net.Conn`: The connection object.
-  - `file *os.File`: The output file object where data is written.
+#### `Client.finalizeSession(session string, path string)`
+Finalizes the scan session `session` and writes its pages, combined by the server, to `path` (the standard output for
+`-`): a PDF, or a ZIP archive if `path` ends with `.zip`.
+
+#### `printProgress(progress protocol.Progress)`
+Redraws the progress bar on the standard error with the stage just completed, and ends its line after the last
+stage.
+
+#### `printEstimate(estimate *protocol.Estimate)`
+Prints the cost estimate of an image returned for `-op estimate`.
+
+#### `logStats(stats *protocol.TransferStats)`
+Logs the transfer statistics reported by the server in the metadata of a response, if any.
+
+#### `logColors(colors *protocol.ColorStats)`
+Logs the color statistics of the cropped document reported by the server, if any, and its color cast.
+
+#### `logRotation(rotation int)`
+Logs the rotation the server turned the document by to stand it upright (`-orient`), if any.
+
+#### `logLossless(header protocol.Header, lossless bool)`
+Logs whether the server cropped the document losslessly when the request asked for it (`-lossless`).
+
+#### `reportTimings(trailer protocol.Trailer, print bool)`
+Logs the time spent in every stage of a request, and prints it on the standard error if `print` is set, with the
+share of each stage in the total.
+
+#### `Client.saveResult(inputPath string, format string, data []byte)`
+Writes the result of a request to the path of the `-o` flag, to the standard output if it is `-`, or under the name
+the `-name` template gives to `inputPath` if it is not set (see `naming.go`).
+
+#### `Client.saveText(inputPath string, index int, text string)`
+Writes the text recognized on the document of `inputPath` (`-ocr`) beside its result: next to the `-o` path with
+the `.txt` extension, to the standard error if the result goes to the standard output, or under the name the `-name`
+template gives to `inputPath` with the `txt` extension, e.g. `output_scan.txt`. Nothing is written if the request
+did not ask for the text and none was returned, e.g. for a job created without `-ocr`.
+
+#### `openInput(path string) (io.ReadCloser, int64, string, error)`
+Opens the image to send and returns its content, its size and its name. `-` reads the whole standard input, named
+`stdin`, since the size of the image is sent before it, and an `http(s)://` URL is downloaded (see `download.go`).
+
+#### `resultExtension(inputPath string, format string) string`
+Returns the extension, without dot, of the result of an input encoded in `format`: the one of the input if it
+matches the format, e.g. `jpeg` for `scan.jpeg`, otherwise `jpg`, `png`, or `zip` for the pages of a spread.
+
+#### `Client.saveArtifacts(inputPath string, index int, artifacts []protocol.Artifact)`
+Writes every intermediate image of the `index`-th image asked for by `-artifacts` under the name the `-name` template
+gives to the input suffixed with the name of the artifact, e.g. `output_photo_edges.png`. An intermediate image which
+cannot be written is only logged.
+
+#### `parseList(list string) []string`
+Splits a comma-separated flag, `-artifacts` or `-accept`, dropping the empty items.
+
+#### `parseStamp(text, imagePath, position string, opacity float64) *protocol.Stamp`
+Returns the stamp of the `-stamp`, `-stamp-image`, `-stamp-position` and `-stamp-opacity` flags, reading the image
+of `-stamp-image`. Returns nil if neither `-stamp` nor `-stamp-image` is given.
+
+- **Exits**:
+  - If the image cannot be read.
+
+#### `parseTone(flagName, list string) *protocol.Tone`
+Returns the tone adjustment of the `-tone` or `-detection-tone` flag, a comma-separated list of `gamma=`,
+`brightness=` and `contrast=` values. Returns nil if the flag is empty.
+
+- **Exits**:
+  - If an item is not one of them, or its value not a number.
+
+#### `parseEncoding(name string) protocol.Codec`
+Returns the codec named by the `-encoding` flag: `protobuf`, or `json` for servers released before the protobuf
+schema.
+
+- **Exits**:
+  - If the encoding is unknown.
 
 ---
 
@@ -83,8 +342,18 @@ Receives a file from the server and writes it to the specified file object.
 The entry point of the application.
 
 - **Behavior**:
+  - Runs the `bench` subcommand if it is given (see `bench.go`).
+  - Parses the `-o`, `-out-dir`, `-name`, `-collision`, `-parallel`, `-contact-sheet`, `-zip`, `-op`, `-format`,
+    `-accept`, `-preset`, `-detector`, `-edge-detector`, `-shape`, `-multi`, `-min-area`, `-min-aspect`, `-border`, `-centering`, `-back`, `-ocr`,
+    `-orient`, `-deskew`, `-fill-holes`, `-flatten`, `-white-balance`, `-lossless`, `-tone`, `-detection-tone`, `-binarize`, `-speck-size`, `-pinhole-size`, `-session`, `-finalize`,
+    `-deterministic`, `-anonymize`, `-stamp`, `-stamp-image`, `-stamp-position`, `-stamp-opacity`, `-server`,
+    `-balance`, `-timeout`, `-page`, `-dpi`, `-async`, `-progress`, `-artifacts`, `-report`, `-timings`, `-webhook`, `-job`,
+    `-poll`, `-token`, `-network`, `-encoding`, `-local` and `-workers` flags, and the socket tuning flags (`-so-rcvbuf`, `-so-sndbuf`, `-nodelay`, `-io-buffer`).
+  - With `-local`, starts the embedded server and sends the requests to it instead (see `local.go`). `-server`, a
+    server address argument, `-network`, `-async` and `-job` are then refused.
   - Validates command-line arguments to ensure proper usage.
-  - Parses the image file path and (optionally) the server address from arguments.
+  - Parses the image file path and (optionally, when `-server` is not used) the server address from arguments.
+  - If the path is a directory or a glob pattern, processes the whole batch (see `batch.go`).
   - Creates a `Client` instance and manages the workflow:
     1. Opens the image file.
     2. Connects to the server.
@@ -98,22 +367,91 @@ The entry point of the application.
 ```bash
 # Run the client with the image file and optional server address
 ./client path/to/image.png localhost:14750
+./client -server scanner.local:14750 path/to/image.png
+
+# Spread a batch over two servers, or send every image to the fastest one still up
+./client -server scan1:14750,scan2:14750 -parallel 4 path/to/photos
+./client -server scan1:14750,scan2:14750 -balance latency path/to/image.png
+
+# Read the image from the standard input and write the result to the standard output
+./client - < scan.jpg > cropped.jpg
+curl -s https://example.com/scan.jpg | ./client -format png - | convert - cropped.pdf
+
+# Process an image downloaded from a URL, saving the result as output_scan.jpg
+./client https://example.com/scans/scan.jpg
+
+# Process the image without any server, e.g. offline
+./client -local -page A4 path/to/image.png
+
+# Scan a whiteboard, or a pile of receipts
+./client -preset whiteboard path/to/board.jpg
+./client -preset receipt -out-dir receipts path/to/receipts
+
+# Scan both sides of an identity card into one image
+./client -preset id-card -back path/to/back.jpg path/to/front.jpg
+
+# Scan a letter into a searchable PDF at A4 size, its text also saved as output_letter.txt
+./client -page A4 -format pdf -ocr path/to/letter.jpg
+./client -orient -ocr path/to/upside-down.jpg
+
+# Scan the pages of a contract into one searchable PDF, or add a forgotten page to a session before finalizing it
+./client -session contract-42 -ocr -finalize contract.pdf path/to/contract-pages
+./client -session report -format jpeg path/to/page-1.jpg
+./client -session report -finalize report.zip
+
+# Get a lossless result of a JPEG photo, in WebP if the server supports it, saved as output_scan.png otherwise
+./client -accept webp,png path/to/scan.jpg
+
+# Get the edge map as a PNG file, giving up after 10 seconds
+./client -op edges -format png -o edges.png -timeout 10s path/to/photo.jpg
+
+# Compare the edges of the Laplacian of Gaussian with those of the Canny detector
+./client -op edges -edge-detector log -o edges_log.png path/to/photo.jpg
+
+# Process every image of a directory, or matching a pattern, into another directory
+./client -out-dir scanned path/to/photos
+./client -out-dir scanned -name '{{.Index}}_{{.Stem}}.{{.Ext}}' 'path/to/photos/*.jpg'
+./client -out-dir scanned -parallel 4 path/to/photos
+./client -out-dir scanned -contact-sheet session.png path/to/photos
+./client -ocr -zip scans.zip path/to/photos
+
+# Ask for an A4 page at 300 dpi, showing the progress of the processing and where the time was spent
+./client -page A4 -dpi 300 -progress -timings path/to/image.png
+
+# Measure the throughput of a server on 8 connections for a minute
+./client bench -server scan1:14750 -concurrency 8 -duration 1m path/to/photo.jpg
+
+# Find out why the document is not detected on a photo
+./client -artifacts grayscale,edges,contours,histograms path/to/photo.jpg
+
+# Save an HTML report of the processing with the result, output_photo.html, or with every result of an archive
+./client -report path/to/photo.jpg
+./client -report -zip scans.zip path/to/photos
+
+# Process a large image in the background, then fetch the result
+./client -async path/to/image.png
+./client -async -webhook https://example.com/scans/done path/to/image.png
+./client -job 3f2a... -poll 2s
+
+# Talk to a local server listening on a Unix socket
+./client -network unix path/to/image.png /tmp/elp-project.sock
 ```
 
 ---
 
 ### Workflow Steps
 1. **Initialization**:
-   - The client accepts an image file path and an optional server address as command-line arguments.
+   - The client accepts an image file path and an optional server address (or `-server`) as command-line
+     arguments.
    - If the server address is not provided, the default address (`localhost:14750`) is used.
 2. **Connection**:
-   - Establishes a TCP connection to the server.
+   - Establishes a TCP connection to the server and sends the protocol magic (see `internal/protocol`).
 3. **Data Transmission**:
-   - Reads the image file in chunks of `bufferSize` bytes and sends it to the server.
-   - A special "EOF" marker is sent to indicate the end of the file.
+   - Sends the request header, then an image frame containing the file.
 4. **Receiving Processed Image**:
-   - Reads the processed image data from the server and writes it to a local file.
-   - If the output file already exists, a new filename is generated to avoid overwriting.
+   - Waits for the response of the request: an error stops the client with the server's message.
+   - Writes the processed image data to a local file, the `-o` path if given.
+   - Otherwise, if the output file already exists, a new filename is generated to avoid overwriting.
 5. Logs all activities (including errors) to a log file named `client.log`.
 
 ---
@@ -136,146 +474,573 @@ The entry point of the application.
 func main() {
     // Parse arguments
     imageFilePath := "example.png"
-    host := "localhost"
-    port := "14750"
+    servers, _ := newServerPool([]string{"localhost:14750"}, balanceRoundRobin)
 
     // Create a new client
-    client := newClient(host, port)
+    client := newClient("tcp", servers, protocol.Header{PageSize: "A4"}, netUtils.DefaultSocketOptions(), "")
     client.run(imageFilePath)
 }
 ```
 */
 
 import (
+	clientlib "ELP-project/internal/client"
+	"ELP-project/internal/netUtils"
+	"ELP-project/internal/protocol"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
 )
 
 const (
 	defaultHost = "localhost"
 	defaultPort = "14750"
-	bufferSize  = 1024
+
+	defaultSocketPath = "/tmp/elp-project.sock"
+
+	tokenEnvironment = "ELP_API_KEY"
+
+	progressWidth = 30
+
+	stdioPath = "-"
+
+	jobResumes = 5
 )
 
 type Client struct {
-	host string
-	port string
+	network      string
+	servers      *serverPool
+	header       protocol.Header
+	socket       netUtils.SocketOptions
+	token        string
+	timings      bool
+	codec        protocol.Codec
+	output       string
+	outDir       string
+	nameTemplate *template.Template
+	collision    string
+	deadline     time.Time
+	parallel     int
+	contactSheet string
+	zipPath      string
+	archive      *batchArchive
+	back         string
+	artifacts    []string
+	report       bool
 }
 
-func newClient(host string, port string) *Client {
+func newClient(network string, servers *serverPool, header protocol.Header, socket netUtils.SocketOptions, token string) *Client {
 	return &Client{
-		host: host,
-		port: port,
+		network:  network,
+		servers:  servers,
+		header:   header,
+		socket:   socket,
+		token:    token,
+		parallel: 1,
+	}
+}
+
+func (client *Client) connect() *clientlib.Client {
+	ctx := context.Background()
+	if !client.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, client.deadline)
+		defer cancel()
+	}
+
+	var lastErr error
+	for _, address := range client.servers.candidates() {
+		start := time.Now()
+		conn, err := clientlib.DialContext(ctx, client.network, address, client.socket, client.codec)
+		if err != nil {
+			log.Printf("Error connecting to server %s: %v", address, err)
+			client.servers.fail(address)
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		if err := conn.SetDeadline(client.deadline); err != nil {
+			log.Fatalf("error setting the deadline: %v", err)
+		}
+
+		if client.token != "" {
+			if err := conn.Authenticate(client.token); err != nil {
+				log.Fatalf("error authenticating: %v", err)
+			}
+		}
+
+		client.servers.record(address, time.Since(start))
+		return conn
 	}
+
+	log.Fatalf("error connecting to server: %v", lastErr)
+	return nil
 }
 
-func (client *Client) connect() net.Conn {
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%s", client.host, client.port))
+func (client *Client) sendImage(input io.Reader, size int64, name string, conn *clientlib.Client) clientlib.Response {
+	response, err := conn.Do(client.header, input, size)
 	if err != nil {
-		log.Fatalf("error connecting to server: %v", err)
+		client.saveArtifacts(name, 1, response.Artifacts)
+		exitOnError(err)
 	}
 
-	return conn
+	return response
 }
 
-func (client *Client) sendImage(file *os.File, conn net.Conn) {
-	buffer := make([]byte, bufferSize)
+func openInput(path string) (io.ReadCloser, int64, string, error) {
+	if isURL(path) {
+		return openURL(path)
+	}
+	if path == stdioPath {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		return io.NopCloser(bytes.NewReader(data)), int64(len(data)), "stdin", nil
+	}
 
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, "", err
+	}
+	return file, info.Size(), file.Name(), nil
+}
+
+func (client *Client) fetchJob(jobID string, poll time.Duration) {
+	conn := client.connect()
+	log.Printf("Connected to server: %s", conn.RemoteAddr().String())
+	defer func() {
+		err := conn.Close()
+		if err != nil {
+			log.Fatalf("Error closing connection: %v", err)
+		}
+	}()
+
+	var received []byte
+	resumes := 0
 	for {
-		n, err := file.Read(buffer)
-		if n > 0 {
-			_, writeErr := conn.Write(buffer[:n])
-			if writeErr != nil {
-				log.Fatalf("Error sending data: %v", writeErr)
+		offset := len(received)
+		response, err := conn.QueryFrom(jobID, int64(offset))
+		if offset > 0 && len(response.Data) > 0 && response.Metadata.Size == 0 {
+			received = nil
+		}
+		received = append(received, response.Data...)
+		if err != nil {
+			if !connectionLost(err) || resumes == jobResumes {
+				exitOnError(err)
 			}
+			resumes++
+			log.Printf("Connection lost while querying job %s, %d of %d bytes of the result received: %v", jobID,
+				len(received), response.Metadata.Size, err)
+			conn.Close()
+			time.Sleep(batchRetryDelay)
+			conn = client.connect()
+			log.Printf("Connected to server: %s", conn.RemoteAddr().String())
+			continue
 		}
+		log.Printf("Job %s is %s", jobID, response.Metadata.Status)
 
-		if err != nil {
-			if err.Error() == "EOF" {
-				break
+		switch response.Metadata.Status {
+		case protocol.StatusDone:
+			if size := response.Metadata.Size; size != 0 && int64(len(received)) != size {
+				log.Fatalf("Incomplete result of job %s: %d of %d bytes", jobID, len(received), size)
 			}
-			log.Fatalf("Error reading file: %v", err)
+			if offset > 0 {
+				log.Printf("Download of the result of job %s resumed at byte %d", jobID, offset)
+			}
+			logColors(response.Metadata.Colors)
+			logRotation(response.Metadata.Rotation)
+			reportTimings(response.Trailer, client.timings)
+			client.saveResult(jobID, response.Metadata.Format, received)
+			client.saveText(jobID, 1, response.Metadata.Text)
+			return
+		case protocol.StatusFailed:
+			fmt.Fprintln(os.Stderr, "Job failed:", response.Metadata.Error)
+			log.Fatalf("Job %s failed: %s", jobID, response.Metadata.Error)
+		}
+
+		if poll <= 0 {
+			fmt.Printf("Job %s is %s\n", jobID, response.Metadata.Status)
+			return
 		}
+		time.Sleep(poll)
 	}
-	_, err := conn.Write([]byte("EOF"))
+}
+
+func (client *Client) finalizeSession(session string, path string) {
+	format := protocol.FormatPDF
+	if strings.EqualFold(filepath.Ext(path), ".zip") {
+		format = protocol.FormatZIP
+	}
+
+	conn := client.connect()
+	log.Printf("Connected to server: %s", conn.RemoteAddr().String())
+	defer conn.Close()
+
+	response, err := conn.Finalize(session, format)
 	if err != nil {
-		log.Fatalf("Error sending EOF: %v", err)
+		exitOnError(err)
+	}
+	reportTimings(response.Trailer, client.timings)
+
+	if path == stdioPath {
+		if _, err := os.Stdout.Write(response.Data); err != nil {
+			log.Fatalf("Error writing the session to the standard output: %v", err)
+		}
+	} else if err := os.WriteFile(path, response.Data, 0644); err != nil {
+		log.Fatalf("Error writing the session: %v", err)
 	}
+	log.Printf("Session %s finalized: %d pages saved to %s", session, response.Metadata.Pages, path)
 }
 
-func (client *Client) receiveImage(conn net.Conn, file *os.File) {
-	buffer := make([]byte, bufferSize)
+func printProgress(progress protocol.Progress) {
+	if progress.Steps <= 0 {
+		return
+	}
+	filled := progressWidth * progress.Step / progress.Steps
+	fmt.Fprintf(os.Stderr, "\r[%s%s] %d/%d %-10s", strings.Repeat("#", filled), strings.Repeat(".", progressWidth-filled),
+		progress.Step, progress.Steps, progress.Stage)
+	if progress.Step == progress.Steps {
+		fmt.Fprintln(os.Stderr)
+	}
+}
 
-	for {
-		n, err := conn.Read(buffer)
+func printEstimate(estimate *protocol.Estimate) {
+	if estimate == nil {
+		fmt.Fprintln(os.Stderr, "The server returned no estimate")
+		return
+	}
+	log.Printf("Estimate: %dx%d pixels, %d chunks, %.1f ms", estimate.Width, estimate.Height, estimate.Chunks, estimate.Millis)
+
+	fmt.Printf("Image: %dx%d pixels (%.2f MP), %d chunks\n", estimate.Width, estimate.Height, estimate.Megapixels,
+		estimate.Chunks)
+	for _, stage := range estimate.Stages {
+		fmt.Printf("%-14s %9.1f ms\n", stage.Stage, stage.Millis)
+	}
+	fmt.Printf("%-14s %9.1f ms\n", "total", estimate.Millis)
+}
+
+func logStats(stats *protocol.TransferStats) {
+	if stats == nil {
+		return
+	}
+	log.Printf("Transfer statistics: protocol %s %v, %d bytes sent in %.1f ms, processed in %.1f ms, %d bytes received",
+		stats.Protocol, stats.Features, stats.BytesReceived, stats.ReceiveMillis, stats.ProcessMillis, stats.BytesSent)
+}
+
+func logColors(colors *protocol.ColorStats) {
+	if colors == nil {
+		return
+	}
+	for _, channel := range []struct {
+		name  string
+		stats protocol.ChannelStats
+	}{{"red", colors.Red}, {"green", colors.Green}, {"blue", colors.Blue}} {
+		log.Printf("Color %s: mean %.1f, p5 %d, median %d, p95 %d", channel.name, channel.stats.Mean, channel.stats.P5, channel.stats.P50, channel.stats.P95)
+	}
+	switch {
+	case colors.Balanced && colors.Cast == "":
+		log.Printf("Colors balanced by the server")
+	case colors.Balanced:
+		log.Printf("Color cast: %s (%.0f%%), corrected by the server with a white balance", colors.Cast, 100*colors.CastStrength)
+	case colors.Cast != "":
+		log.Printf("Color cast: %s (%.0f%%), the document needs a white balance", colors.Cast, 100*colors.CastStrength)
+	}
+}
+
+func logRotation(rotation int) {
+	if rotation != 0 {
+		log.Printf("Document turned by %d degrees clockwise to read its text", rotation)
+	}
+}
+
+func logLossless(header protocol.Header, lossless bool) {
+	switch {
+	case !header.Lossless:
+	case lossless:
+		log.Printf("Document cropped losslessly out of the JPEG image")
+	default:
+		log.Printf("Document cropped by decoding the image, which cannot be cropped losslessly")
+	}
+}
+
+func reportTimings(trailer protocol.Trailer, print bool) {
+	if len(trailer.Timings) == 0 {
+		return
+	}
+
+	total := 0.0
+	for _, timing := range trailer.Timings {
+		total += timing.Millis
+		log.Printf("Stage %s: %.1f ms", timing.Stage, timing.Millis)
+	}
+	if !print {
+		return
+	}
+
+	for _, timing := range trailer.Timings {
+		fmt.Fprintf(os.Stderr, "%-14s %9.1f ms %5.1f%%\n", timing.Stage, timing.Millis, 100*timing.Millis/total)
+	}
+	fmt.Fprintf(os.Stderr, "%-14s %9.1f ms\n", "total", total)
+}
+
+func exitOnError(err error) {
+	var errorMessage protocol.ErrorMessage
+	if errors.As(err, &errorMessage) {
+		fmt.Fprintln(os.Stderr, "Server error:", errorMessage.Message)
+		log.Fatalf("Server returned an error: %v", errorMessage)
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		fmt.Fprintln(os.Stderr, "Timed out waiting for the server")
+	}
+	log.Fatalf("Error sending image: %v", err)
+}
+
+func (client *Client) saveResult(inputPath string, format string, data []byte) {
+	if client.output == "" {
+		path, err := client.writeOutput(inputPath, format, 1, data)
+		if errors.Is(err, errOutputExists) {
+			fmt.Fprintln(os.Stderr, "Skipped:", path, "already exists")
+			log.Printf("Result of %s not saved: %s already exists", inputPath, path)
+			return
+		}
 		if err != nil {
-			if err.Error() == "EOF" || err == io.EOF {
-				break
-			}
-			log.Fatalf("Error reading from connection: %v", err)
+			log.Fatalf("Error writing output file: %v", err)
+		}
+		log.Printf("Processed image saved: %s", path)
+		return
+	}
+	if client.output == stdioPath {
+		if _, err := os.Stdout.Write(data); err != nil {
+			log.Fatalf("Error writing the result to the standard output: %v", err)
+		}
+		log.Printf("Processed image written to the standard output (%d bytes)", len(data))
+		return
+	}
+
+	if err := os.WriteFile(client.output, data, 0644); err != nil {
+		log.Fatalf("Error writing output file: %v", err)
+	}
+	log.Printf("Processed image saved: %s", client.output)
+}
+
+func resultExtension(inputPath string, format string) string {
+	extension := strings.TrimPrefix(filepath.Ext(inputPath), ".")
+	current := strings.ToLower(extension)
+	if current == "jpg" {
+		current = "jpeg"
+	}
+	if format == "" || current == format {
+		return extension
+	}
+
+	if format == "jpeg" {
+		return "jpg"
+	}
+	return format
+}
+
+func (client *Client) saveArtifacts(inputPath string, index int, artifacts []protocol.Artifact) {
+	base := filepath.Base(inputPath)
+	name := strings.TrimSuffix(base, filepath.Ext(base))
+	for _, artifact := range artifacts {
+		if !slices.Contains(client.artifacts, artifact.Name) {
+			continue
 		}
+		path, err := client.writeOutput(name+"_"+artifact.Name+".png", "png", index, artifact.Data)
+		switch {
+		case errors.Is(err, errOutputExists):
+			log.Printf("Artifact %s of %s not saved: %s already exists", artifact.Name, inputPath, path)
+		case err != nil:
+			log.Printf("Error writing artifact %s of %s: %v", artifact.Name, inputPath, err)
+		default:
+			log.Printf("Artifact saved: %s", path)
+		}
+	}
+}
+
+func (client *Client) saveText(inputPath string, index int, text string) {
+	if !client.header.OCR && text == "" {
+		return
+	}
+	if text != "" {
+		text += "\n"
+	}
 
-		_, writeErr := file.Write(buffer[:n])
-		if writeErr != nil {
-			log.Fatalf("Error writing to file: %v", writeErr)
+	switch client.output {
+	case "":
+		path, err := client.writeOutput(inputPath, "txt", index, []byte(text))
+		switch {
+		case errors.Is(err, errOutputExists):
+			log.Printf("Text of %s not saved: %s already exists", inputPath, path)
+		case err != nil:
+			log.Fatalf("Error writing text file: %v", err)
+		default:
+			log.Printf("Recognized text saved: %s", path)
 		}
+	case stdioPath:
+		fmt.Fprint(os.Stderr, text)
+	default:
+		path := strings.TrimSuffix(client.output, filepath.Ext(client.output)) + ".txt"
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			log.Fatalf("Error writing text file: %v", err)
+		}
+		log.Printf("Recognized text saved: %s", path)
+	}
+}
+
+func parseList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseStamp(text, imagePath, position string, opacity float64) *protocol.Stamp {
+	if text == "" && imagePath == "" {
+		return nil
+	}
+
+	stamp := &protocol.Stamp{Text: text, Position: position, Opacity: opacity}
+	if imagePath != "" {
+		data, err := os.ReadFile(imagePath)
+		if err != nil {
+			log.Fatalf("Error reading stamp image: %v", err)
+		}
+		stamp.Image = data
+	}
+	return stamp
+}
+
+func parseTone(flagName, list string) *protocol.Tone {
+	if list == "" {
+		return nil
+	}
+
+	tone := &protocol.Tone{}
+	for _, item := range strings.Split(list, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(item), "=")
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			log.Fatalf("Invalid -%s value %q: %v", flagName, item, err)
+		}
+		switch name {
+		case "gamma":
+			tone.Gamma = number
+		case "brightness":
+			tone.Brightness = number
+		case "contrast":
+			tone.Contrast = number
+		default:
+			log.Fatalf("Unknown -%s adjustment %q (gamma, brightness or contrast)", flagName, name)
+		}
+	}
+	return tone
+}
+
+func parseEncoding(name string) protocol.Codec {
+	switch name {
+	case "protobuf":
+		return protocol.Protobuf
+	case "json":
+		return protocol.JSON
+	default:
+		log.Fatalf("Unknown encoding %q (protobuf or json)", name)
+		return nil
 	}
 }
 
 func (client *Client) run(imageFilePath string) {
-	file, err := os.Open(imageFilePath)
+	input, size, name, err := openInput(imageFilePath)
 	if err != nil {
 		log.Fatalf("error opening image file: %v", err)
 	}
-	log.Printf("Image file opened: %s", file.Name())
-	defer func(file *os.File) {
-		err := file.Close()
+	log.Printf("Image file opened: %s", name)
+	defer func(input io.ReadCloser) {
+		err := input.Close()
 		if err != nil {
 			log.Fatalf("Error closing file: %v", err)
 		}
-	}(file)
+	}(input)
+
+	var body io.Reader = input
+	var source bytes.Buffer
+	if client.report {
+		body = io.TeeReader(input, &source)
+	}
 
 	conn := client.connect()
 	log.Printf("Connected to server: %s", conn.RemoteAddr().String())
-	defer func(conn net.Conn) {
+	defer func(conn *clientlib.Client) {
 		err := conn.Close()
 		if err != nil {
 			log.Fatalf("Error closing connection: %v", err)
 		}
 	}(conn)
 
+	if client.header.Progress {
+		conn.OnProgress(func(_ uint32, progress protocol.Progress) {
+			printProgress(progress)
+		})
+	}
+
 	log.Println("Sending image...")
-	client.sendImage(file, conn)
-	log.Println("Image sent successfully!")
+	response := client.sendImage(body, size, name, conn)
+	logStats(response.Metadata.Stats)
+	logColors(response.Metadata.Colors)
+	logRotation(response.Metadata.Rotation)
+	logLossless(client.header, response.Metadata.Lossless)
 
-	newFileName := "output_" + filepath.Base(file.Name())
-	fileIndex := 1
-	for {
-		if _, err := os.Stat(newFileName); os.IsNotExist(err) {
-			break
-		} else {
-			newFileName = fmt.Sprintf("output_%d_%s", fileIndex, filepath.Base(file.Name()))
-			fileIndex++
-		}
+	if client.header.Async {
+		log.Printf("Job created: %s", response.Metadata.JobID)
+		fmt.Println("Job ID:", response.Metadata.JobID)
+		return
 	}
-
-	newFile, err := os.Create(newFileName)
-	if err != nil {
-		log.Fatalf("Error creating output file: %v", err)
+	if client.header.Operation == protocol.OperationEstimate {
+		printEstimate(response.Metadata.Estimate)
+		return
 	}
-	defer func(newFile *os.File) {
-		err := newFile.Close()
-		if err != nil {
-			log.Fatalf("Error closing file: %v", err)
-		}
-	}(newFile)
+	log.Println("Image processed successfully!")
+	reportTimings(response.Trailer, client.timings)
 
-	log.Println("Receiving image...")
-	client.receiveImage(conn, newFile)
+	client.saveArtifacts(name, 1, response.Artifacts)
+	if client.back != "" {
+		back := client.sendBack(conn)
+		reportTimings(back.Trailer, client.timings)
+		client.saveArtifacts(client.back, 2, back.Artifacts)
+		if response.Data, err = composeSides(response.Data, back.Data, response.Metadata.Format); err != nil {
+			log.Fatalf("error composing the front and the back: %v", err)
+		}
+		response.Metadata.Text = strings.TrimSpace(response.Metadata.Text + "\n\n" + back.Metadata.Text)
+	}
+	if (imageFilePath == stdioPath || client.header.Operation == protocol.OperationCorners) && client.output == "" {
+		client.output = stdioPath
+	}
+	client.saveResult(name, response.Metadata.Format, response.Data)
+	client.saveText(name, 1, response.Metadata.Text)
+	client.saveReport(name, 1, source.Bytes(), response)
 }
 
 func main() {
@@ -292,28 +1057,261 @@ func main() {
 
 	log.SetOutput(logFile)
 
-	args := os.Args
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(runBench(os.Args[2:]))
+	}
 
-	if len(args) > 3 || len(args) < 2 {
-		fmt.Println("Usage: ./client <image_file_path> <server_address>")
+	output := flag.String("o", "", "path the result is written to (default output_<image name>)")
+	outDir := flag.String("out-dir", "", "directory the results are written to, created if needed (default the working directory)")
+	name := flag.String("name", defaultNameTemplate, "template naming the results: {{.Stem}}, {{.Ext}}, {{.Index}}")
+	collision := flag.String("collision", collisionSuffix, "when a named result already exists: suffix (add a number), overwrite or skip")
+	parallel := flag.Int("parallel", 1, "number of connections the images of a directory or pattern are sent on")
+	zipPath := flag.String("zip", "", "with a directory or pattern, write the results into this ZIP archive with a manifest.json instead of one file each")
+	contactSheet := flag.String("contact-sheet", "", "with a directory or pattern, also write the thumbnails of the results to this .png or .jpg image")
+	operation := flag.String("op", protocol.OperationCrop, "result to get back: crop, grayscale, edges, scan (crop with the look of a scan), corners (JSON of the document corners), or estimate for the processing cost")
+	binarize := flag.Bool("binarize", false, "with -op scan, binarize the document into black and white")
+	speckSize := flag.Int("speck-size", 0, "with -binarize, remove the black specks of fewer pixels, -1 for none (default 8)")
+	pinholeSize := flag.Int("pinhole-size", 0, "with -binarize, fill the white pinholes of fewer pixels, -1 for none (default 8)")
+	format := flag.String("format", "", "format of the result: png, jpeg or pdf (default the format of the preset, or of the image)")
+	accept := flag.String("accept", "", "without -format, comma-separated formats the result can be returned in, in order of preference, e.g. webp,png")
+	preset := flag.String("preset", "", "kind of document the processing is tuned for: document (the default), whiteboard, receipt, photo, id-card or book")
+	detector := flag.String("detector", protocol.DetectorContours, "how the document is found: contours (its largest contour) hough (its four dominant lines) or ransac (the sides fitted on its largest contour)")
+	edgeDetector := flag.String("edge-detector", protocol.EdgeDetectorCanny, "how the edges of the photo are detected: canny, or log (zero crossings of the Laplacian of Gaussian)")
+	shape := flag.String("shape", "", "page size the document has the proportions of, e.g. A4 or Letter, preferred over other shapes")
+	multi := flag.Bool("multi", false, "with -op corners, also list every document found on the photo, e.g. several receipts")
+	minArea := flag.Float64("min-area", protocol.DefaultDocumentArea, "with -multi, smallest area of a document, as a fraction of the photo")
+	minAspect := flag.Float64("min-aspect", protocol.DefaultDocumentAspect, "with -multi, smallest ratio of the shorter side of a document to its longer one")
+	border := flag.String("border", protocol.BorderKeep, "contours touching the border of the photo: keep, penalize (pick them last) or discard")
+	centering := flag.Float64("centering", 0, "preference for the documents near the center of the photo, from 0 to 1")
+	orient := flag.Bool("orient", false, "turn the document upright from the orientation its text is read best in")
+	deskew := flag.Bool("deskew", false, "straighten the document by the angle its text lines are slanted by")
+	fillHoles := flag.Bool("fill-holes", false, "paint over the punched holes and the staples of the document")
+	flatten := flag.Bool("flatten", false, "flatten the illumination of the photo before detecting the document, against the shadows cast on it")
+	whiteBalance := flag.String("white-balance", "", "white balance of the cropped document: auto (the default, only when its paper has a visible cast), white-patch, gray-world or off")
+	lossless := flag.Bool("lossless", false, "cut the document out of a JPEG photo without encoding it again, keeping its quality")
+	tone := flag.String("tone", "", "tone of the cropped document, e.g. gamma=1.8,brightness=0.05,contrast=0.3 (brightness and contrast from -1 to 1)")
+	detectionTone := flag.String("detection-tone", "", "tone of the photo the document is detected on, e.g. gamma=2 for a dark photo")
+	session := flag.String("session", "", "ID of the scan session the documents are added to as pages, e.g. contract-42")
+	finalize := flag.String("finalize", "", "with -session, file the pages of the session are combined into once the images are sent: a .pdf or .zip")
+	recognize := flag.Bool("ocr", false, "also get the text recognized on the document, saved beside the result as a .txt file")
+	back := flag.String("back", "", "image of the back of a two-sided document, e.g. an ID card, composed with the front into one result")
+	deterministic := flag.Bool("deterministic", false, "ask for a result bit-identical across runs and servers")
+	anonymize := flag.Bool("anonymize", false, "blur the photos (faces, ID photos) found on the document")
+	stamp := flag.String("stamp", "", "text stamped on the document, e.g. COPY or 'SCANNED {date}' ({date}, {time}, {request})")
+	stampImage := flag.String("stamp-image", "", "image (e.g. a logo) stamped on the document instead of a text")
+	stampPosition := flag.String("stamp-position", protocol.PositionBottomRight, "position of the stamp: top-left, top-right, bottom-left, bottom-right or center")
+	stampOpacity := flag.Float64("stamp-opacity", protocol.DefaultStampOpacity, "opacity of the stamp, from 0 to 1")
+	server := flag.String("server", "", "address of the server, host:port or the path of its socket with -network unix, or a comma-separated list of servers")
+	balance := flag.String("balance", balanceRoundRobin, "with several servers, how the server of every connection is chosen: round-robin or latency")
+	timeout := flag.Duration("timeout", 0, "give up if the server has not answered within this time (0 for no limit)")
+	pageSize := flag.String("page", "", "page size of the output (A4, A5, Letter, Legal or WxH in millimeters)")
+	dpi := flag.Int("dpi", 0, "resolution of the output page in dots per inch (server default if 0)")
+	async := flag.Bool("async", false, "process the image in the background and print the ID of the job")
+	timings := flag.Bool("timings", false, "print the time spent by the server in every stage of the processing")
+	webhook := flag.String("webhook", "", "with -async, URL notified by the server once the job is finished")
+	progress := flag.Bool("progress", false, "show the progress of the processing")
+	artifacts := flag.String("artifacts", "", "comma-separated intermediate images to save: grayscale, edges, contours, histograms")
+	report := flag.Bool("report", false, "also save an HTML report of the processing (thumbnails of the stages, corners, options, timings) beside every result")
+	jobID := flag.String("job", "", "fetch the result of an asynchronous job instead of sending an image")
+	local := flag.Bool("local", false, "process the images in this process, without contacting a server")
+	workers := flag.Int("workers", 0, "with -local, workers processing the images (number of CPU cores if 0)")
+	poll := flag.Duration("poll", 0, "with -job, check the job again at this interval until it is finished")
+	token := flag.String("token", "", "API key sent to the server (default $"+tokenEnvironment+")")
+	network := flag.String("network", "tcp", "network of the server: tcp, or unix with the path of its socket as address")
+	encoding := flag.String("encoding", "protobuf", "encoding of the control messages: protobuf, or json for older servers")
+	socket := netUtils.DefaultSocketOptions()
+	socket.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	args := flag.Args()
+
+	if *finalize != "" && *session == "" {
+		fmt.Fprintln(os.Stderr, "-finalize combines the pages of the session given with -session")
+		log.Fatal("-finalize given without -session")
+	}
+	if *jobID != "" || (*finalize != "" && len(args) == 0) {
+		args = append([]string{""}, args...)
+	}
+	if len(args) > 2 || len(args) < 1 || (*server != "" && len(args) > 1) {
+		fmt.Fprintln(os.Stderr, "Usage: ./client [-o path] [-op operation] [-format format] [-server address] [-timeout duration] <image_file_path>")
+		fmt.Fprintln(os.Stderr, "       ./client [flags] <image_file_path> <server_address>")
+		fmt.Fprintln(os.Stderr, "       ./client -job id [-poll interval] [-server address]")
+		fmt.Fprintln(os.Stderr, "       ./client -session id -finalize path [-server address]")
+		fmt.Fprintln(os.Stderr, "       ./client -local [flags] <image_file_path>")
 		log.Fatal("Invalid number of arguments")
 	}
+	if *local && (*server != "" || len(args) > 1 || *network != "tcp" || *async || *jobID != "") {
+		fmt.Fprintln(os.Stderr, "-local processes the images in this process, without -server, -network, -async nor -job")
+		log.Fatal("-local given with a server option")
+	}
 
-	imageFilePath := args[1]
+	imageFilePath := args[0]
 	log.Printf("Image file path: %s", imageFilePath)
 
-	host := defaultHost
-	port := defaultPort
-	if len(args) == 3 {
-		tmpHost, tmpPort, err := net.SplitHostPort(args[2])
+	address := net.JoinHostPort(defaultHost, defaultPort)
+	if *network == "unix" {
+		address = defaultSocketPath
+	}
+	if len(args) == 2 {
+		*server = args[1]
+	}
+	if *server != "" {
+		address = *server
+	}
+	if *local {
+		localAddress, stop, err := startLocalServer(*workers)
 		if err != nil {
-			log.Fatalf("Invalid server address format: %v", err)
+			fmt.Fprintln(os.Stderr, "Error starting the local server:", err)
+			log.Fatalf("Error starting the local server: %v", err)
 		}
-		host = tmpHost
-		port = tmpPort
+		defer stop()
+		address = localAddress
+	}
+	addresses, err := parseServers(address, *network)
+	if err != nil {
+		log.Fatalf("Invalid server address format: %v", err)
+	}
+	servers, err := newServerPool(addresses, *balance)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "-balance is round-robin or latency")
+		log.Fatalf("Invalid -balance: %v", err)
+	}
+	log.Printf("Server address: %s (%s)", servers, *network)
+
+	if *format == "jpg" {
+		*format = "jpeg"
 	}
-	log.Printf("Server address: %s:%s", host, port)
 
-	client := newClient(host, port)
-	client.run(imageFilePath)
+	header := protocol.Header{
+		Operation:     *operation,
+		Format:        *format,
+		Preset:        *preset,
+		Border:        *border,
+		Centering:     *centering,
+		Detector:      *detector,
+		EdgeDetector:  *edgeDetector,
+		Shape:         *shape,
+		Deterministic: *deterministic,
+		Anonymize:     *anonymize,
+		Stamp:         parseStamp(*stamp, *stampImage, *stampPosition, *stampOpacity),
+		PageSize:      *pageSize,
+		DPI:           *dpi,
+		Async:         *async,
+		Webhook:       *webhook,
+		Progress:      *progress,
+		Artifacts:     parseList(*artifacts),
+		Accept:        parseList(*accept),
+		OCR:           *recognize,
+		Orient:        *orient,
+		Deskew:        *deskew,
+		FillHoles:     *fillHoles,
+		Flatten:       *flatten,
+		Binarize:      *binarize,
+		WhiteBalance:  *whiteBalance,
+		Lossless:      *lossless,
+		Tone:          parseTone("tone", *tone),
+		DetectionTone: parseTone("detection-tone", *detectionTone),
+		Session:       *session,
+	}
+	if *report {
+		header.Artifacts = reportArtifacts(header.Artifacts, *anonymize)
+	}
+	if *multi {
+		header.MultiDocument = &protocol.MultiDocument{MinArea: *minArea, MinAspect: *minAspect}
+	}
+	if *speckSize != 0 || *pinholeSize != 0 {
+		header.Despeckle = &protocol.Despeckle{SpeckSize: *speckSize, PinholeSize: *pinholeSize}
+	}
+
+	if *token == "" {
+		*token = os.Getenv(tokenEnvironment)
+	}
+
+	client := newClient(*network, servers, header, socket, *token)
+	client.timings = *timings
+	client.codec = parseEncoding(*encoding)
+	client.output = *output
+	client.outDir = *outDir
+	client.collision = *collision
+	client.artifacts = parseList(*artifacts)
+	client.report = *report
+	if client.nameTemplate, err = parseNameTemplate(*name, *collision); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid -name or -collision:", err)
+		log.Fatalf("Invalid -name or -collision: %v", err)
+	}
+	if *outDir != "" {
+		if err := os.MkdirAll(*outDir, 0755); err != nil {
+			log.Fatalf("Error creating output directory: %v", err)
+		}
+	}
+	if *timeout > 0 {
+		client.deadline = time.Now().Add(*timeout)
+	}
+	if *report && (*async || *jobID != "" || *operation == protocol.OperationEstimate) {
+		fmt.Fprintln(os.Stderr, "-report describes the processing of the images sent, not with -async, -job nor -op estimate")
+		log.Fatal("-report given without images processed")
+	}
+	if *jobID != "" {
+		client.fetchJob(*jobID, *poll)
+		return
+	}
+	if *finalize != "" && imageFilePath == "" {
+		client.finalizeSession(*session, *finalize)
+		return
+	}
+	if *zipPath != "" && !isBatch(imageFilePath) {
+		fmt.Fprintln(os.Stderr, "-zip collects the results of a batch, give it a directory or a pattern")
+		log.Fatal("-zip given without a batch")
+	}
+	if *contactSheet != "" && !isBatch(imageFilePath) {
+		fmt.Fprintln(os.Stderr, "-contact-sheet summarizes a batch, give it a directory or a pattern")
+		log.Fatal("-contact-sheet given without a batch")
+	}
+	if *back != "" {
+		if isBatch(imageFilePath) || *async || (*operation != protocol.OperationCrop && *operation != "") || *format == protocol.FormatPDF || *session != "" {
+			fmt.Fprintln(os.Stderr, "-back composes two cropped images, not with a batch, -async, another -op, -format pdf nor -session")
+			log.Fatal("-back given without a single cropped image")
+		}
+		client.back = *back
+	}
+	if isBatch(imageFilePath) {
+		if *output != "" {
+			fmt.Fprintln(os.Stderr, "-o names a single result, use -out-dir with a directory or a pattern")
+			log.Fatal("-o given with a batch")
+		}
+		if *parallel < 1 {
+			fmt.Fprintln(os.Stderr, "-parallel needs at least one connection")
+			log.Fatalf("Invalid -parallel: %d", *parallel)
+		}
+		client.parallel = *parallel
+		if *contactSheet != "" {
+			if *async || *operation == protocol.OperationEstimate || *operation == protocol.OperationCorners {
+				fmt.Fprintln(os.Stderr, "-contact-sheet needs images back, not with -async, -op estimate nor -op corners")
+				log.Fatal("-contact-sheet given without image results")
+			}
+			if _, ok := contactSheetFormat(*contactSheet); !ok {
+				fmt.Fprintln(os.Stderr, "-contact-sheet writes a .png, .jpg or .jpeg image")
+				log.Fatalf("Invalid -contact-sheet: %s", *contactSheet)
+			}
+			client.contactSheet = *contactSheet
+		}
+		if *zipPath != "" {
+			if *async || *operation == protocol.OperationEstimate {
+				fmt.Fprintln(os.Stderr, "-zip collects the results, not with -async nor -op estimate")
+				log.Fatal("-zip given without results")
+			}
+			client.zipPath = *zipPath
+		}
+		inputs, err := batchInputs(imageFilePath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			log.Fatalf("Error listing the images: %v", err)
+		}
+		client.runBatch(inputs)
+	} else {
+		client.run(imageFilePath)
+	}
+	if *finalize != "" {
+		client.finalizeSession(*session, *finalize)
+	}
 }
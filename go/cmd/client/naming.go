@@ -0,0 +1,161 @@
+package main
+
+/*
+This file implements the naming of the files the client writes: the result of a single image, of a job, of every
+image of a batch, and the intermediate images of a response. Their names are given by the `-name` template (see
+`text/template`), `output_{{.Stem}}.{{.Ext}}` by default, in the `-out-dir` directory. A name may contain
+directories, e.g. `{{.Stem}}/scan.{{.Ext}}`, which are created as needed. The `-o` flag bypasses the template: the
+result goes to that path, replacing any existing file.
+
+When a file of that name already exists, the `-collision` policy decides:
+- `suffix` (the default): the result is written under the name with `_1`, `_2`, ... inserted before its extension.
+- `overwrite`: the existing file is replaced, so running a batch again gives the same files.
+- `skip`: the existing file is kept and the result is dropped, e.g. to complete an interrupted batch without
+  duplicating the results already written. The image is still sent: the name of its result depends on the format
+  the server answers with.
+
+---
+
+### Constants
+- `defaultNameTemplate`: Default template of the names (`output_{{.Stem}}.{{.Ext}}`).
+- `collisionSuffix`, `collisionOverwrite`, `collisionSkip`: Names of the policies of the `-collision` flag.
+
+### Variables
+- `errOutputExists`: Returned for a file kept by the `skip` policy.
+
+---
+
+### `outputName`
+Data of the template naming a file.
+
+- Fields:
+  - `Stem`: Name of the input file without its extension, e.g. `photo` for `scans/photo.jpg`. The ID of the job for
+    the result of a job, and the name of the input suffixed with the name of the artifact for an intermediate
+    image, e.g. `photo_edges`.
+  - `Ext`: Extension of the file, without dot: the one of the input, unless the result is in another format.
+  - `Index`: Position of the image in the batch, from 1. Always 1 outside a batch.
+
+---
+
+### `parseNameTemplate(text string, collision string) (*template.Template, error)`
+Parses the `-name` template, and checks it by naming a sample file, so an invalid template fails before any image is
+sent. Also fails on an unknown `-collision` policy.
+
+### `(client *Client) templateName(inputPath string, format string, index int) (string, error)`
+Returns the name the template gives the file of an input, the `format` of the file giving its extension.
+
+### `(client *Client) outputPath(inputPath string, format string, index int) (string, error)`
+Returns the path of the file of an input in the output directory, named by `templateName`, and applies the collision
+policy. Returns the path with `errOutputExists` if the policy keeps the existing file.
+
+### `(client *Client) writeOutput(inputPath string, format string, index int, data []byte) (string, error)`
+Writes `data` to the path given by `outputPath`, creating its directory, and returns that path.
+
+### `uniquePath(path string) string`
+Returns `path`, or `path` with `_<n>` inserted before its extension if that file already exists.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const (
+	defaultNameTemplate = "output_{{.Stem}}.{{.Ext}}"
+
+	collisionSuffix    = "suffix"
+	collisionOverwrite = "overwrite"
+	collisionSkip      = "skip"
+)
+
+var errOutputExists = errors.New("the output file already exists")
+
+type outputName struct {
+	Stem  string
+	Ext   string
+	Index int
+}
+
+func parseNameTemplate(text string, collision string) (*template.Template, error) {
+	switch collision {
+	case collisionSuffix, collisionOverwrite, collisionSkip:
+	default:
+		return nil, fmt.Errorf("unknown collision policy: %q", collision)
+	}
+
+	nameTemplate, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	var sample strings.Builder
+	if err := nameTemplate.Execute(&sample, outputName{Stem: "photo", Ext: "jpg", Index: 1}); err != nil {
+		return nil, err
+	}
+	if sample.Len() == 0 {
+		return nil, errors.New("the template gives an empty name")
+	}
+	return nameTemplate, nil
+}
+
+func (client *Client) templateName(inputPath string, format string, index int) (string, error) {
+	base := filepath.Base(inputPath)
+	var name strings.Builder
+	err := client.nameTemplate.Execute(&name, outputName{
+		Stem:  strings.TrimSuffix(base, filepath.Ext(base)),
+		Ext:   resultExtension(inputPath, format),
+		Index: index,
+	})
+	if err != nil {
+		return "", err
+	}
+	if name.Len() == 0 {
+		return "", errors.New("the -name template gives an empty name")
+	}
+	return name.String(), nil
+}
+
+func (client *Client) outputPath(inputPath string, format string, index int) (string, error) {
+	name, err := client.templateName(inputPath, format, index)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(client.outDir, name)
+	switch client.collision {
+	case collisionOverwrite:
+		return path, nil
+	case collisionSkip:
+		if _, err := os.Stat(path); err == nil {
+			return path, errOutputExists
+		}
+		return path, nil
+	default:
+		return uniquePath(path), nil
+	}
+}
+
+func (client *Client) writeOutput(inputPath string, format string, index int, data []byte) (string, error) {
+	path, err := client.outputPath(inputPath, format, index)
+	if err != nil {
+		return path, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return path, err
+	}
+	return path, os.WriteFile(path, data, 0644)
+}
+
+func uniquePath(path string) string {
+	extension := filepath.Ext(path)
+	candidate := path
+	for index := 1; ; index++ {
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s_%d%s", strings.TrimSuffix(path, extension), index, extension)
+	}
+}
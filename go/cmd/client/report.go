@@ -0,0 +1,397 @@
+package main
+
+/*
+This file implements the processing report of the client: with `-report`, every image processed also gets a small HTML
+page telling how its result was obtained, so the result of a scan can be checked by someone who never ran the client.
+The page is self-contained: its thumbnails are embedded as `data:` URIs and its style is inline, without script, so
+it opens in any browser, offline, and can be mailed or archived with the result.
+
+The report shows:
+- the thumbnail of the sent image, with the corners of the detected document drawn over it
+  (`protocol.Metadata.Corners`), and listed in the pixels of the image;
+- the thumbnails of the intermediate images of the processing (the grayscale image, the edge map, the contours and the
+  histograms), which `-report` asks the server for (see `reportArtifacts`), and the thumbnail of the result;
+- the description of the result: its format, size, split spread, rotation, color cast, and whether it was cropped
+  losslessly;
+- the options of the request, those of its header which are set;
+- the time the server spent in every stage, with its share of the total.
+
+The report is written beside the result: next to the `-o` path with the `.html` extension, or under the name the
+`-name` template gives to the image with the `html` extension, e.g. `output_scan.html`. With `-zip`, it is added to
+the archive beside the result instead, and named by the `Report` of its manifest entry (see `archive.go`).
+
+---
+
+### Constants
+- `reportThumbnailSize`: Size of the longer side of the thumbnails of the report, in pixels.
+- `reportValueLength`: Longest value of an option shown by the report, in characters; the longer ones, e.g. the image
+  of a stamp, are cut.
+
+---
+
+### `reportArtifacts(artifacts []string, anonymize bool) []string`
+Returns the intermediate images to ask the server for with `-report`: those of `-artifacts`, and every one shown by the
+report, except those showing the photos of the document when it is anonymized (the server refuses them), so only the
+histograms are left.
+
+### `Client.buildReport(inputPath string, source []byte, response clientlib.Response) ([]byte, error)`
+Returns the HTML report of the response of the server to the image `source` read from `inputPath`.
+
+### `Client.saveReport(inputPath string, index int, source []byte, response clientlib.Response)`
+Writes the report of the `index`-th image beside its result, if the client was given `-report`. A report which cannot
+be made or written is only logged, the result being saved already.
+
+### `reportImage(caption string, data []byte, format string) reportThumbnail`
+Returns the thumbnail of an image of the report, encoded in `format` ("jpeg" or "png") as a `data:` URI. An image
+which cannot be decoded gets no thumbnail, only its caption.
+
+### `reportParameters(header protocol.Header) []reportField`
+Lists the options of a request which are set, by their JSON name.
+
+### `reportDetails(response clientlib.Response) []reportField`
+Describes the result of a response: its format, size and pixels, and what the server did to the document.
+*/
+
+import (
+	clientlib "ELP-project/internal/client"
+	"ELP-project/internal/imageUtils"
+	"ELP-project/internal/protocol"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	reportThumbnailSize = 320
+	reportValueLength   = 60
+)
+
+type reportPage struct {
+	Title      string
+	Generated  string
+	Input      reportThumbnail
+	Polygon    string
+	Corners    []reportCorner
+	Stages     []reportThumbnail
+	Result     reportThumbnail
+	Details    []reportField
+	Parameters []reportField
+	Timings    []reportTiming
+	Total      float64
+}
+
+type reportThumbnail struct {
+	Caption string
+	URI     template.URL
+	Width   int
+	Height  int
+	Size    image.Point
+}
+
+type reportCorner struct {
+	Name string
+	protocol.Point
+}
+
+type reportField struct {
+	Name  string
+	Value string
+}
+
+type reportTiming struct {
+	Stage  string
+	Millis float64
+	Share  float64
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Processing report: {{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; margin-bottom: 0; }
+h2 { font-size: 1.1em; margin-top: 2em; border-bottom: 1px solid #ccc; }
+.generated { color: #777; }
+.images { display: flex; flex-wrap: wrap; gap: 1em; }
+figure { margin: 0; text-align: center; }
+figure img, figure svg { border: 1px solid #ccc; background: #eee; }
+figcaption { font-size: 0.9em; color: #555; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 0.8em; text-align: left; vertical-align: top; }
+tr:nth-child(even) { background: #f4f4f4; }
+td.number { text-align: right; font-variant-numeric: tabular-nums; }
+.bar { background: #4a7bd0; height: 0.8em; }
+</style>
+</head>
+<body>
+<h1>Processing report: {{.Title}}</h1>
+<p class="generated">Generated on {{.Generated}}</p>
+
+<h2>Images</h2>
+<div class="images">
+<figure>
+{{- with .Input}}{{if .URI}}
+<svg width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}">
+<image href="{{.URI}}" width="{{.Width}}" height="{{.Height}}"/>
+{{- if $.Polygon}}
+<polygon points="{{$.Polygon}}" fill="rgba(74,123,208,0.2)" stroke="#e03030" stroke-width="2"/>
+{{- end}}
+</svg>
+{{- else}}<p>No preview</p>{{end}}
+<figcaption>{{.Caption}}{{if .Size.X}} ({{.Size.X}}x{{.Size.Y}}){{end}}</figcaption>
+{{- end}}
+</figure>
+{{- range .Stages}}
+<figure>
+{{- if .URI}}<img src="{{.URI}}" width="{{.Width}}" height="{{.Height}}" alt="{{.Caption}}">{{else}}<p>No preview</p>{{end}}
+<figcaption>{{.Caption}}</figcaption>
+</figure>
+{{- end}}
+{{- with .Result}}
+<figure>
+{{- if .URI}}<img src="{{.URI}}" width="{{.Width}}" height="{{.Height}}" alt="{{.Caption}}">{{else}}<p>No preview</p>{{end}}
+<figcaption>{{.Caption}}{{if .Size.X}} ({{.Size.X}}x{{.Size.Y}}){{end}}</figcaption>
+</figure>
+{{- end}}
+</div>
+
+<h2>Detected corners</h2>
+{{- if .Corners}}
+<table>
+<tr><th>Corner</th><th>x</th><th>y</th></tr>
+{{- range .Corners}}
+<tr><td>{{.Name}}</td><td class="number">{{.X}}</td><td class="number">{{.Y}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>No corners reported by the server.</p>
+{{- end}}
+
+<h2>Result</h2>
+<table>
+{{- range .Details}}
+<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Parameters</h2>
+{{- if .Parameters}}
+<table>
+{{- range .Parameters}}
+<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>Default options.</p>
+{{- end}}
+
+<h2>Timings</h2>
+{{- if .Timings}}
+<table>
+<tr><th>Stage</th><th>Time (ms)</th><th>Share</th><th></th></tr>
+{{- range .Timings}}
+<tr><td>{{.Stage}}</td><td class="number">{{printf "%.1f" .Millis}}</td><td class="number">{{printf "%.1f%%" .Share}}</td><td style="width: 12em"><div class="bar" style="width: {{printf "%.1f" .Share}}%"></div></td></tr>
+{{- end}}
+<tr><th>total</th><td class="number">{{printf "%.1f" .Total}}</td><td></td><td></td></tr>
+</table>
+{{- else}}
+<p>No timings reported by the server.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+func reportArtifacts(artifacts []string, anonymize bool) []string {
+	wanted := []string{protocol.ArtifactGrayscale, protocol.ArtifactEdges, protocol.ArtifactContours, protocol.ArtifactHistograms}
+	if anonymize {
+		wanted = []string{protocol.ArtifactHistograms}
+	}
+	for _, name := range wanted {
+		if !slices.Contains(artifacts, name) {
+			artifacts = append(artifacts, name)
+		}
+	}
+	return artifacts
+}
+
+func (client *Client) buildReport(inputPath string, source []byte, response clientlib.Response) ([]byte, error) {
+	metadata := response.Metadata
+	page := reportPage{
+		Title:      filepath.Base(inputPath),
+		Generated:  time.Now().Format("2006-01-02 15:04:05"),
+		Input:      reportImage("Sent image", source, "jpeg"),
+		Details:    reportDetails(response),
+		Parameters: reportParameters(client.header),
+	}
+	names := []string{"top-left", "top-right", "bottom-right", "bottom-left"}
+	for i, corner := range metadata.Corners {
+		page.Corners = append(page.Corners, reportCorner{Name: names[i%len(names)], Point: corner})
+	}
+	if page.Input.URI != "" && len(metadata.Corners) > 0 {
+		scale := float64(page.Input.Width) / float64(page.Input.Size.X)
+		points := make([]string, 0, len(metadata.Corners))
+		for _, corner := range metadata.Corners {
+			points = append(points, fmt.Sprintf("%.1f,%.1f", float64(corner.X)*scale, float64(corner.Y)*scale))
+		}
+		page.Polygon = strings.Join(points, " ")
+	}
+
+	for _, artifact := range response.Artifacts {
+		format := "png"
+		if artifact.Name == protocol.ArtifactContours {
+			format = "jpeg"
+		}
+		page.Stages = append(page.Stages, reportImage(strings.ToUpper(artifact.Name[:1])+artifact.Name[1:], artifact.Data, format))
+	}
+	if metadata.Format != protocol.FormatJSON && metadata.Format != protocol.FormatPDF {
+		page.Result = reportImage("Result", response.Data, "jpeg")
+	}
+
+	for _, timing := range response.Trailer.Timings {
+		page.Total += timing.Millis
+	}
+	for _, timing := range response.Trailer.Timings {
+		share := 0.0
+		if page.Total > 0 {
+			share = 100 * timing.Millis / page.Total
+		}
+		page.Timings = append(page.Timings, reportTiming{Stage: timing.Stage, Millis: timing.Millis, Share: share})
+	}
+
+	var html bytes.Buffer
+	if err := reportTemplate.Execute(&html, page); err != nil {
+		return nil, err
+	}
+	return html.Bytes(), nil
+}
+
+func (client *Client) saveReport(inputPath string, index int, source []byte, response clientlib.Response) {
+	if !client.report {
+		return
+	}
+	data, err := client.buildReport(inputPath, source, response)
+	if err != nil {
+		log.Printf("Error making the report of %s: %v", inputPath, err)
+		return
+	}
+
+	path := strings.TrimSuffix(client.output, filepath.Ext(client.output)) + ".html"
+	if client.output == "" || client.output == stdioPath {
+		path, err = client.writeOutput(inputPath, "html", index, data)
+	} else {
+		err = os.WriteFile(path, data, 0644)
+	}
+	switch {
+	case errors.Is(err, errOutputExists):
+		log.Printf("Report of %s not saved: %s already exists", inputPath, path)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "Error writing the report:", err)
+		log.Printf("Error writing the report of %s: %v", inputPath, err)
+	default:
+		log.Printf("Processing report saved: %s", path)
+	}
+}
+
+func reportImage(caption string, data []byte, format string) reportThumbnail {
+	thumbnail := reportThumbnail{Caption: caption}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return thumbnail
+	}
+	small := imageUtils.Thumbnail(img, reportThumbnailSize)
+
+	var encoded bytes.Buffer
+	if format == "png" {
+		err = png.Encode(&encoded, small)
+	} else {
+		err = jpeg.Encode(&encoded, small, &jpeg.Options{Quality: 80})
+	}
+	if err != nil {
+		return thumbnail
+	}
+	thumbnail.URI = template.URL("data:image/" + format + ";base64," + base64.StdEncoding.EncodeToString(encoded.Bytes()))
+	thumbnail.Width, thumbnail.Height = small.Bounds().Dx(), small.Bounds().Dy()
+	thumbnail.Size = img.Bounds().Size()
+	return thumbnail
+}
+
+func reportParameters(header protocol.Header) []reportField {
+	data, err := json.Marshal(header)
+	if err != nil {
+		return nil
+	}
+	var options map[string]json.RawMessage
+	if err := json.Unmarshal(data, &options); err != nil {
+		return nil
+	}
+
+	fields := make([]reportField, 0, len(options))
+	for name, value := range options {
+		text := string(value)
+		var str string
+		var list []string
+		if json.Unmarshal(value, &str) == nil {
+			text = str
+		} else if json.Unmarshal(value, &list) == nil {
+			text = strings.Join(list, ", ")
+		}
+		if runes := []rune(text); len(runes) > reportValueLength {
+			text = string(runes[:reportValueLength]) + "..."
+		}
+		fields = append(fields, reportField{Name: name, Value: text})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields
+}
+
+func reportDetails(response clientlib.Response) []reportField {
+	metadata := response.Metadata
+	details := []reportField{
+		{"Format", metadata.Format},
+		{"Size", fmt.Sprintf("%d bytes", len(response.Data))},
+	}
+	if config, _, err := image.DecodeConfig(bytes.NewReader(response.Data)); err == nil {
+		details = append(details, reportField{"Pixels", fmt.Sprintf("%dx%d", config.Width, config.Height)})
+	}
+	if metadata.Spread {
+		details = append(details, reportField{"Spread", "split into its two pages"})
+	}
+	if metadata.Rotation != 0 {
+		details = append(details, reportField{"Rotation", fmt.Sprintf("%d degrees clockwise", metadata.Rotation)})
+	}
+	if colors := metadata.Colors; colors != nil {
+		cast := "none"
+		if colors.Cast != "" {
+			cast = fmt.Sprintf("%s (%.0f%%)", colors.Cast, 100*colors.CastStrength)
+		}
+		if colors.Balanced {
+			cast += ", white balanced"
+		}
+		details = append(details, reportField{"Color cast", cast})
+	}
+	if metadata.Lossless {
+		details = append(details, reportField{"Lossless crop", "yes, the JPEG was not encoded again"})
+	}
+	if len(metadata.Alternatives) > 0 {
+		details = append(details, reportField{"Other candidates", fmt.Sprint(len(metadata.Alternatives))})
+	}
+	if metadata.Text != "" {
+		details = append(details, reportField{"Recognized text", fmt.Sprintf("%d characters", len([]rune(metadata.Text)))})
+	}
+	return details
+}
@@ -0,0 +1,159 @@
+package main
+
+/*
+This file implements the selection of the server among several: `-server` (or the server address argument) accepts a
+comma-separated list of addresses, e.g. `scan1:14750,scan2:14750`, and every connection of the client goes to one of
+them, chosen by the `-balance` policy:
+- `round-robin` (the default): the servers take turns, so the connections of a batch (`-parallel`) are spread over
+  them.
+- `latency`: the server which answered the fastest, measured on the previous connections, the time to connect and
+  authenticate being averaged over them. A server is tried once before any comparison, in the order of the list.
+
+A server which cannot be reached is skipped: the client fails over to the next one of the order of the policy, and
+only gives up once every server failed. A failed server goes to the end of the order of both policies, until it is
+reached again. The images of a batch
+lost with their connection are sent again on new connections (see `batch.go`), thus to the other servers. A job
+(`-job`) is only found on the server which created it, or on the servers sharing its job storage.
+
+---
+
+### Constants
+- `balanceRoundRobin`, `balanceLatency`: Names of the policies of the `-balance` flag.
+- `failedLatency`: Latency given to a server which could not be reached, higher than any measured latency.
+
+---
+
+### `serverPool`
+Servers of the client, with the state of their policy. Safe for concurrent use by the connections of a batch.
+
+- Fields:
+  - `addresses`: Addresses of the servers, in the order of the list.
+  - `balance`: Policy choosing the server of a connection.
+  - `next`: With `balanceRoundRobin`, index of the server of the next connection.
+  - `latency`: With `balanceLatency`, average latency of every server reached so far.
+
+---
+
+### `parseServers(list string, network string) ([]string, error)`
+Splits the comma-separated list of server addresses, checking that the TCP addresses are `host:port`.
+
+### `newServerPool(addresses []string, balance string) (*serverPool, error)`
+Creates the pool of `addresses`, failing on an unknown policy.
+
+### `(pool *serverPool) candidates() []string`
+Returns every server, in the order they are tried for a new connection: from the turn of the connection for
+`balanceRoundRobin`, from the fastest for `balanceLatency`, the servers which could not be reached last.
+
+### `(pool *serverPool) record(address string, latency time.Duration)`
+Records the latency of a connection to a server.
+
+### `(pool *serverPool) fail(address string)`
+Records that a server could not be reached.
+
+### `(pool *serverPool) String() string`
+Returns the list of the servers, for the logs.
+*/
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	balanceRoundRobin = "round-robin"
+	balanceLatency    = "latency"
+
+	failedLatency = time.Hour
+)
+
+type serverPool struct {
+	mutex     sync.Mutex
+	addresses []string
+	balance   string
+	next      int
+	latency   map[string]time.Duration
+}
+
+func parseServers(list string, network string) ([]string, error) {
+	var addresses []string
+	for _, address := range strings.Split(list, ",") {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+		if network != "unix" {
+			if _, _, err := net.SplitHostPort(address); err != nil {
+				return nil, err
+			}
+		}
+		addresses = append(addresses, address)
+	}
+
+	if len(addresses) == 0 {
+		return nil, errors.New("no server address")
+	}
+	return addresses, nil
+}
+
+func newServerPool(addresses []string, balance string) (*serverPool, error) {
+	switch balance {
+	case balanceRoundRobin, balanceLatency:
+	default:
+		return nil, fmt.Errorf("unknown balancing policy: %q", balance)
+	}
+	return &serverPool{addresses: addresses, balance: balance, latency: make(map[string]time.Duration)}, nil
+}
+
+func (pool *serverPool) candidates() []string {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	if pool.balance == balanceLatency {
+		candidates := slices.Clone(pool.addresses)
+		slices.SortStableFunc(candidates, func(a, b string) int {
+			return cmp.Compare(pool.latency[a], pool.latency[b])
+		})
+		return candidates
+	}
+
+	start := pool.next
+	pool.next = (pool.next + 1) % len(pool.addresses)
+	candidates := append(slices.Clone(pool.addresses[start:]), pool.addresses[:start]...)
+	failed := func(address string) int {
+		if pool.latency[address] == failedLatency {
+			return 1
+		}
+		return 0
+	}
+	slices.SortStableFunc(candidates, func(a, b string) int {
+		return failed(a) - failed(b)
+	})
+	return candidates
+}
+
+func (pool *serverPool) record(address string, latency time.Duration) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	if previous, ok := pool.latency[address]; ok && previous != failedLatency {
+		latency = (previous + latency) / 2
+	}
+	pool.latency[address] = latency
+}
+
+func (pool *serverPool) fail(address string) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	pool.latency[address] = failedLatency
+}
+
+func (pool *serverPool) String() string {
+	return strings.Join(pool.addresses, ", ")
+}
@@ -0,0 +1,156 @@
+package main
+
+/*
+This file implements the `server calibrate` subcommand, which benchmarks the processing pipeline on the host and
+writes the calibration profile the server loads with `-calibration` to estimate the cost of the images (see
+`pkg/server/calibration.go`).
+
+The benchmark runs on synthetic documents, drawn at every size of `-sizes`.
+Run it on an idle host: the other processes slow the measures down.
+
+---
+
+### Constants
+- `defaultCalibrationSizes`: Sizes benchmarked by default, from 0.8 to 12 megapixels.
+- `calibrationAngle`: Rotation of the synthetic documents, in radians.
+
+---
+
+### `runCalibrate(args []string) int`
+Parses the flags of the subcommand, benchmarks every size, prints the measures and writes the profile. Returns the
+exit status of the command.
+
+- Flags:
+  - `-o`: Path of the profile (`calibration.json`).
+  - `-sizes`: Comma-separated sizes of the benchmarked images, `WxH` in pixels.
+
+### `parseSizes(list string) ([]image.Point, error)`
+Parses the `-sizes` flag.
+
+### `syntheticDocument(width, height int, angle float64) image.Image`
+Draws a light page rotated by `angle`, covered with text lines, on a dark background: the pipeline runs every stage
+on it, from the edges of the page to its crop.
+
+---
+
+### Example Usage:
+```
+go run . calibrate -o /etc/elp/calibration.json
+go run . -calibration /etc/elp/calibration.json
+```
+*/
+
+import (
+	serverlib "ELP-project/pkg/server"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultCalibrationSizes = "1024x768,2048x1536,4000x3000"
+	calibrationAngle        = 0.08
+)
+
+func runCalibrate(args []string) int {
+	flagSet := flag.NewFlagSet("calibrate", flag.ContinueOnError)
+	output := flagSet.String("o", "calibration.json", "path of the calibration profile")
+	sizeList := flagSet.String("sizes", defaultCalibrationSizes, "comma-separated sizes of the benchmarked images, WxH in pixels")
+	if err := flagSet.Parse(args); err != nil {
+		return 2
+	}
+
+	sizes, err := parseSizes(*sizeList)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 2
+	}
+
+	var images []image.Image
+	for _, size := range sizes {
+		images = append(images, syntheticDocument(size.X, size.Y, calibrationAngle))
+	}
+
+	fmt.Printf("Benchmarking %d sizes on a single worker...\n", len(images))
+	profile, err := serverlib.Calibrate(images)
+	if err != nil {
+		fmt.Println("Error calibrating:", err)
+		return 1
+	}
+
+	for _, sample := range profile.Samples {
+		fmt.Printf("%dx%d (%.1f MP):", sample.Width, sample.Height, sample.Megapixels)
+		for _, stage := range sample.Stages {
+			fmt.Printf(" %s %.1f ms", stage.Stage, stage.Millis)
+		}
+		fmt.Println()
+	}
+	var stages []string
+	for _, sample := range profile.Samples {
+		for _, stage := range sample.Stages {
+			if !slices.Contains(stages, stage.Stage) {
+				stages = append(stages, stage.Stage)
+			}
+		}
+	}
+	fmt.Println("Rates, in ms per megapixel:")
+	for _, stage := range stages {
+		fmt.Printf("  %-14s %8.1f\n", stage, profile.Rates[stage])
+	}
+
+	if err := profile.Save(*output); err != nil {
+		fmt.Println("Error writing the profile:", err)
+		return 1
+	}
+	fmt.Println("Calibration profile written to", *output)
+	return 0
+}
+
+func parseSizes(list string) ([]image.Point, error) {
+	var sizes []image.Point
+	for _, size := range strings.Split(list, ",") {
+		width, height, ok := strings.Cut(strings.TrimSpace(size), "x")
+		x, errWidth := strconv.Atoi(width)
+		y, errHeight := strconv.Atoi(height)
+		if !ok || errWidth != nil || errHeight != nil || x <= 0 || y <= 0 {
+			return nil, fmt.Errorf("invalid size %q, expected WxH", size)
+		}
+		sizes = append(sizes, image.Pt(x, y))
+	}
+	return sizes, nil
+}
+
+func syntheticDocument(width, height int, angle float64) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	centerX, centerY := float64(width)/2, float64(height)/2
+	halfWidth, halfHeight := float64(width)*0.31, float64(height)*0.35
+
+	background := color.RGBA{R: 60, G: 50, B: 40, A: 255}
+	paper := color.RGBA{R: 235, G: 230, B: 220, A: 255}
+	ink := color.RGBA{R: 20, G: 20, B: 20, A: 255}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dx, dy := float64(x)-centerX, float64(y)-centerY
+			rotatedX := dx*math.Cos(angle) + dy*math.Sin(angle)
+			rotatedY := -dx*math.Sin(angle) + dy*math.Cos(angle)
+
+			pixel := background
+			if math.Abs(rotatedX) < halfWidth && math.Abs(rotatedY) < halfHeight {
+				pixel = paper
+				line := int(rotatedY+halfHeight) % 40
+				if line < 4 && math.Abs(rotatedX) < halfWidth*0.8 {
+					pixel = ink
+				}
+			}
+			img.SetRGBA(x, y, pixel)
+		}
+	}
+
+	return img
+}
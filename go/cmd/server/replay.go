@@ -0,0 +1,242 @@
+package main
+
+/*
+This file implements the `server replay` subcommand, which runs the request of a debug bundle (see
+`pkg/server/debug.go`) again through a server embedded in the process, listening on an ephemeral port of the
+loopback interface with a temporary job directory, so a failure reported by a user can be reproduced without their
+client.
+
+The logs of the embedded server are written to the standard error with microsecond timestamps, along with the
+progress of the request. The request is sent with its original header, apart from:
+- It is always synchronous: an asynchronous request is replayed without its job nor its webhook.
+- Every intermediate image is asked for, and saved with the result in the output directory. An anonymized request
+  only asks for the histograms: the other intermediate images would show the photos of the document.
+
+---
+
+### Constants
+- `replayDirectory`: Directory of the bundle the outputs of a replay are written to by default.
+- `replayTimeout`: Longest time the embedded server is waited for once the replay is done.
+
+---
+
+### `embeddedServer`
+A server embedded in the process by the replay.
+
+- Fields:
+  - `server`: The server, configured like a production one apart from its listener and job directory.
+  - `address`: The address the server listens on (`127.0.0.1:<port>`).
+
+- Methods:
+  - `stop()`: Shuts the server down, waiting for at most `replayTimeout`.
+
+---
+
+### `runReplay(args []string) int`
+Parses the flags of the subcommand, replays the bundle given as argument and prints the outcome, compared with the
+original error. Returns the exit status of the command: 0 if the request succeeded, 1 if it failed again (whatever
+the error), 2 if the bundle could not be replayed. The status makes the command usable by `git bisect run`.
+
+- Flags:
+  - `-o`: Directory the result and the intermediate images are written to (`<bundle>/replay`).
+  - `-workers`: Number of workers of the embedded server (number of CPU cores if 0).
+  - `-deterministic`: Processes the request in deterministic mode, whatever its header.
+
+### `startEmbeddedServer(config serverlib.Config) (*embeddedServer, error)`
+Listens on an ephemeral port and starts a server accepting the connections of that listener.
+
+### `replayRequest(address string, header protocol.Header, data []byte) clientlib.Response`
+Sends the request of the bundle to the embedded server, printing its progress, and returns its response.
+
+### `saveReplay(dir string, response clientlib.Response) error`
+Writes the intermediate images of a response to `dir`, and its result as `result.<format>`. Nothing is written,
+nor printed, if the response has none.
+
+---
+
+### Example Usage:
+```
+go run . replay debug/20261017T011805.718Z-58d447d8
+git bisect run sh -c 'go build -o /tmp/server ./cmd/server && /tmp/server replay debug/20261017T011805.718Z-58d447d8'
+```
+*/
+
+import (
+	clientlib "ELP-project/internal/client"
+	"ELP-project/internal/protocol"
+	serverlib "ELP-project/pkg/server"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	replayDirectory = "replay"
+	replayTimeout   = 30 * time.Second
+)
+
+type embeddedServer struct {
+	server  *serverlib.Server
+	address string
+}
+
+func runReplay(args []string) int {
+	flagSet := flag.NewFlagSet("replay", flag.ContinueOnError)
+	output := flagSet.String("o", "", "directory the result and the intermediate images are written to (default <bundle>/replay)")
+	workers := flagSet.Int("workers", 0, "workers of the embedded server (number of CPU cores if 0)")
+	deterministic := flagSet.Bool("deterministic", false, "process the request in deterministic mode")
+	if err := flagSet.Parse(args); err != nil {
+		return 2
+	}
+	if flagSet.NArg() != 1 {
+		fmt.Println("Usage: ./server replay [-o directory] [-workers n] [-deterministic] <bundle>")
+		return 2
+	}
+	bundle := flagSet.Arg(0)
+	if *output == "" {
+		*output = filepath.Join(bundle, replayDirectory)
+	}
+
+	report, data, err := serverlib.ReadDebugBundle(bundle)
+	if err != nil {
+		fmt.Println("Error reading the bundle:", err)
+		return 2
+	}
+	fmt.Printf("Bundle %s: request %d from %s at %s, %d bytes\n", report.ID, report.RequestID, report.RemoteAddr,
+		report.Time.Format("2006-01-02 15:04:05"), report.InputBytes)
+	fmt.Printf("Original error: %s: %s\n", report.Code, report.Error)
+	if report.Panic != "" {
+		fmt.Printf("Original panic: %s\n", report.Panic)
+	}
+
+	jobsDir, err := os.MkdirTemp("", "elp-replay-")
+	if err != nil {
+		fmt.Println("Error creating job directory:", err)
+		return 2
+	}
+	defer os.RemoveAll(jobsDir)
+
+	config := serverlib.DefaultConfig()
+	config.JobsDir = jobsDir
+	config.Workers = *workers
+	config.Deterministic = *deterministic
+	config.MaxConnectionsPerHost = 0
+	config.Logger = log.New(os.Stderr, "server: ", log.Ltime|log.Lmicroseconds)
+
+	embedded, err := startEmbeddedServer(config)
+	if err != nil {
+		fmt.Println("Error starting server:", err)
+		return 2
+	}
+	defer embedded.stop()
+
+	header := report.Header
+	header.Async = false
+	header.Webhook = ""
+	header.Progress = true
+	switch {
+	case header.Operation == protocol.OperationEstimate:
+	case header.Anonymize:
+		header.Artifacts = []string{protocol.ArtifactHistograms}
+	default:
+		header.Artifacts = []string{protocol.ArtifactGrayscale, protocol.ArtifactEdges, protocol.ArtifactContours, protocol.ArtifactHistograms}
+	}
+	response := replayRequest(embedded.address, header, data)
+
+	if err := saveReplay(*output, response); err != nil {
+		fmt.Println("Error saving the replay:", err)
+		return 2
+	}
+	for _, timing := range response.Trailer.Timings {
+		fmt.Printf("%-14s %9.1f ms\n", timing.Stage, timing.Millis)
+	}
+
+	if response.Err == nil {
+		fmt.Println("Not reproduced: the request succeeded")
+		return 0
+	}
+
+	var errorMessage protocol.ErrorMessage
+	if !errors.As(response.Err, &errorMessage) {
+		fmt.Println("Error replaying the request:", response.Err)
+		return 2
+	}
+	if errorMessage.Code == report.Code && errorMessage.Message == report.Error {
+		fmt.Println("Reproduced")
+	} else {
+		fmt.Printf("Failed with another error: %s: %s\n", errorMessage.Code, errorMessage.Message)
+	}
+	return 1
+}
+
+func startEmbeddedServer(config serverlib.Config) (*embeddedServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("error listening on an ephemeral port: %w", err)
+	}
+	config.Listener = listener
+
+	server, err := serverlib.New(config)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if err := server.Start(context.Background()); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return &embeddedServer{server: server, address: server.Addr().String()}, nil
+}
+
+func (embedded *embeddedServer) stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), replayTimeout)
+	defer cancel()
+	embedded.server.Shutdown(ctx)
+}
+
+func replayRequest(address string, header protocol.Header, data []byte) clientlib.Response {
+	client, err := clientlib.Dial(address)
+	if err != nil {
+		return clientlib.Response{Err: err}
+	}
+	defer client.Close()
+
+	client.OnProgress(func(_ uint32, progress protocol.Progress) {
+		fmt.Fprintf(os.Stderr, "progress: %d/%d %s\n", progress.Step, progress.Steps, progress.Stage)
+	})
+
+	response, err := client.Do(header, bytes.NewReader(data), int64(len(data)))
+	response.Err = err
+	return response
+}
+
+func saveReplay(dir string, response clientlib.Response) error {
+	if len(response.Artifacts) == 0 && response.Data == nil {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for _, artifact := range response.Artifacts {
+		if err := os.WriteFile(filepath.Join(dir, artifact.Name+".png"), artifact.Data, 0644); err != nil {
+			return err
+		}
+	}
+	if response.Data != nil {
+		name := "result." + response.Metadata.Format
+		if err := os.WriteFile(filepath.Join(dir, name), response.Data, 0644); err != nil {
+			return err
+		}
+	}
+	fmt.Println("Outputs written to", dir)
+	return nil
+}
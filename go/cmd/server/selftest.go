@@ -0,0 +1,486 @@
+package main
+
+/*
+This file implements the `server selftest` subcommand, a soak test of the server: it starts the server embedded in
+the process (`pkg/server`), loads it for `-duration` with clients sending synthetic documents over the protocol,
+exactly as real clients would, and checks that it stays stable. The worker pools are resized back and forth through
+the admin interface during the load, and the server is shut down at the end: the paths stopping the workers, which
+no request exercises, run under load.
+
+The load mixes the operations of the server: synchronous crops, corners, edges and scans, and asynchronous jobs
+polled until they are done. Every client reconnects after `selftestReconnect` requests, so the connections are
+opened and closed all along the test.
+
+The test passes if:
+  - every request succeeded, and every job was done: no task was dropped by a resize or lost by a worker;
+  - the server counted no failure nor panic, and nothing is left in progress or queued once the load stopped;
+  - the server shut down within `selftestShutdownTimeout`, its workers stopped, and no task was sent to their closed
+    channels (see `worker.Tracker`);
+  - the goroutines went back to their number before the server started: no worker, connection or job leaked;
+  - the heap stayed below `-max-heap` during the load, and the heap left once the server is stopped and collected
+    is at most `selftestRetainedHeap` above that before it started.
+
+The logs of the server go to `server.log`, as for the command.
+
+---
+
+### Constants
+- `defaultSelftestSizes`: Sizes of the synthetic documents sent by default.
+- `selftestAngles`: Rotations of the synthetic documents, in radians, one document per size and angle.
+- `selftestReconnect`: Number of requests after which a client closes its connection and opens a new one.
+- `selftestJobTimeout` / `selftestPollPeriod`: Longest wait for an asynchronous job, and period of its polling.
+- `selftestSampleInterval`: Period of the sampling of the heap.
+- `selftestShutdownTimeout`: Longest wait for the shutdown of the server.
+- `selftestSettleTimeout`: Longest wait for the goroutines to stop once the server is shut down.
+- `selftestRetainedHeap`: Largest growth of the heap, in bytes, left once the server is stopped.
+
+---
+
+### `selftestStats`
+Counters of the load, updated by the clients with atomic operations.
+
+- Fields:
+  - `sent`: Requests sent, the polling of the jobs excluded.
+  - `succeeded`: Requests answered successfully, and jobs done.
+  - `resizes`: Resizes of the worker pools.
+  - `peakHeap`: Largest heap in use sampled during the load, in bytes.
+
+### `adminStats`
+Counters of `GET /stats` the test checks.
+
+---
+
+### `runSelftest(args []string) int`
+Parses the flags of the subcommand, runs the load, then stops the server and prints the report. Returns the exit
+status of the command: 0 if every check passed, 1 if one failed, 2 if the test could not run.
+
+- Flags:
+  - `-duration`: Duration of the load (1 minute).
+  - `-clients`: Number of concurrent clients (8).
+  - `-workers`: Workers of the embedded server, the number of CPU cores if 0. The pools are resized from 1 to twice
+    this number.
+  - `-resize-every`: Period of the resizes of the worker pools (2 seconds), never resized if 0.
+  - `-sizes`: Comma-separated sizes of the synthetic documents, `WxH` in pixels.
+  - `-max-heap`: Largest heap in use during the load, in MiB.
+
+### `selftestImages(sizes []image.Point) ([][]byte, error)`
+Draws and encodes the synthetic documents, alternately as JPEG and PNG.
+
+### `runClient(address string, images [][]byte, client int, stop <-chan struct{}, stats *selftestStats, failures chan<- error)`
+Sends requests until `stop` is closed, cycling through the operations and the images, and reports every failed one
+to `failures`. The request in progress when `stop` is closed is finished.
+
+### `selftestRequest(client *clientlib.Client, header protocol.Header, data []byte) error`
+Sends a request and, for an asynchronous job, polls it until it is done. Returns an error if the request or the job
+failed, or if the job is not done after `selftestJobTimeout`.
+
+### `resizeWorkers(admin *http.Client, address string, counts []int, interval time.Duration, stop <-chan struct{}, stats *selftestStats, failures chan<- error)`
+Resizes the worker pools to every count of `counts` in turn, every `interval`, until `stop` is closed.
+
+### `sampleHeap(stop <-chan struct{}, stats *selftestStats)`
+Samples the heap in use every `selftestSampleInterval` until `stop` is closed, and keeps its peak.
+
+### `readAdminStats(admin *http.Client, address string) (adminStats, error)`
+Reads the counters of the server from `GET /stats`.
+
+### `settledGoroutines(baseline int) int`
+Waits for the number of goroutines to go back to `baseline`, for at most `selftestSettleTimeout`, and returns it.
+
+### `heapInUse() uint64`
+Returns the heap in use after a garbage collection, in bytes.
+
+---
+
+### Example Usage:
+```
+go run . selftest --duration 5m
+go run . selftest -duration 30s -clients 16 -workers 4 -sizes 2048x1536
+```
+*/
+
+import (
+	clientlib "ELP-project/internal/client"
+	"ELP-project/internal/protocol"
+	serverlib "ELP-project/pkg/server"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultSelftestSizes    = "800x600,1600x1200"
+	selftestReconnect       = 20
+	selftestJobTimeout      = time.Minute
+	selftestPollPeriod      = 50 * time.Millisecond
+	selftestSampleInterval  = 200 * time.Millisecond
+	selftestShutdownTimeout = 30 * time.Second
+	selftestSettleTimeout   = 10 * time.Second
+	selftestRetainedHeap    = 64 << 20
+)
+
+var selftestAngles = []float64{0.08, -0.15}
+
+type selftestStats struct {
+	sent      atomic.Int64
+	succeeded atomic.Int64
+	resizes   atomic.Int64
+	peakHeap  atomic.Uint64
+}
+
+type adminStats struct {
+	Requests    int64 `json:"requests"`
+	Failures    int64 `json:"failures"`
+	Panics      int64 `json:"panics"`
+	InFlight    int64 `json:"inFlight"`
+	RunningJobs int64 `json:"runningJobs"`
+	QueuedJobs  int   `json:"queuedJobs"`
+}
+
+func runSelftest(args []string) int {
+	flagSet := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	duration := flagSet.Duration("duration", time.Minute, "duration of the load")
+	clients := flagSet.Int("clients", 8, "number of concurrent clients")
+	workers := flagSet.Int("workers", 0, "workers of the embedded server (number of CPU cores if 0)")
+	resizeEvery := flagSet.Duration("resize-every", 2*time.Second, "period of the resizes of the worker pools (never resized if 0)")
+	sizeList := flagSet.String("sizes", defaultSelftestSizes, "comma-separated sizes of the synthetic documents, WxH")
+	maxHeap := flagSet.Uint64("max-heap", 1024, "largest heap in use during the load, in MiB")
+	if err := flagSet.Parse(args); err != nil {
+		return 2
+	}
+	if flagSet.NArg() != 0 || *duration <= 0 || *clients <= 0 || *workers < 0 || *resizeEvery < 0 {
+		fmt.Println("Usage: ./server selftest [-duration d] [-clients n] [-workers n] [-resize-every d] [-sizes WxH,...] [-max-heap MiB]")
+		return 2
+	}
+	sizes, err := parseSizes(*sizeList)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return 2
+	}
+	if *workers == 0 {
+		*workers = runtime.NumCPU()
+	}
+
+	images, err := selftestImages(sizes)
+	if err != nil {
+		fmt.Println("Error encoding the documents:", err)
+		return 2
+	}
+	jobsDir, err := os.MkdirTemp("", "elp-selftest-")
+	if err != nil {
+		fmt.Println("Error creating job directory:", err)
+		return 2
+	}
+	defer os.RemoveAll(jobsDir)
+
+	baselineGoroutines := runtime.NumGoroutine()
+	baselineHeap := heapInUse()
+
+	config := serverlib.DefaultConfig()
+	config.JobsDir = jobsDir
+	config.Workers = *workers
+	config.MaxConnectionsPerHost = 0
+	config.AdminAddress = "127.0.0.1:0"
+	embedded, err := startEmbeddedServer(config)
+	if err != nil {
+		fmt.Println("Error starting server:", err)
+		return 2
+	}
+	adminAddr := embedded.server.AdminAddr()
+	if adminAddr == nil {
+		embedded.stop()
+		fmt.Println("Error starting server: the admin interface is not listening")
+		return 2
+	}
+	adminAddress := adminAddr.String()
+	transport := &http.Transport{}
+	admin := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+
+	fmt.Printf("Loading the server for %v with %d clients, %d to %d workers\n", *duration, *clients, 1, 2**workers)
+
+	var stats selftestStats
+	var problems []string
+	failures := make(chan error, *clients)
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for err := range failures {
+			if len(problems) < 10 {
+				problems = append(problems, err.Error())
+			}
+		}
+	}()
+
+	stop := make(chan struct{})
+	var load, background sync.WaitGroup
+	started := time.Now()
+	for client := range *clients {
+		load.Add(1)
+		go func() {
+			defer load.Done()
+			runClient(embedded.address, images, client, stop, &stats, failures)
+		}()
+	}
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		sampleHeap(stop, &stats)
+	}()
+	if *resizeEvery > 0 {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			resizeWorkers(admin, adminAddress, []int{1, *workers, 2 * *workers}, *resizeEvery, stop, &stats, failures)
+		}()
+	}
+
+	time.Sleep(*duration)
+	close(stop)
+	load.Wait()
+	background.Wait()
+	elapsed := time.Since(started)
+
+	serverStats, statsErr := readAdminStats(admin, adminAddress)
+	transport.CloseIdleConnections()
+
+	ctx, cancel := context.WithTimeout(context.Background(), selftestShutdownTimeout)
+	shutdownErr := embedded.server.Shutdown(ctx)
+	workersErr := embedded.server.WaitWorkers(ctx)
+	cancel()
+	embedded = nil // The retained heap is measured without the caches of the server.
+	close(failures)
+	<-collected
+
+	goroutines := settledGoroutines(baselineGoroutines)
+	retainedHeap := heapInUse()
+
+	sent, succeeded := stats.sent.Load(), stats.succeeded.Load()
+	fmt.Printf("Requests:   %d sent, %d succeeded, %d dropped (%.1f/s)\n", sent, succeeded, sent-succeeded,
+		float64(sent)/elapsed.Seconds())
+	fmt.Printf("Resizes:    %d\n", stats.resizes.Load())
+	fmt.Printf("Heap:       %.1f MiB peak (limit %d MiB), %.1f MiB before, %.1f MiB after\n",
+		float64(stats.peakHeap.Load())/(1<<20), *maxHeap, float64(baselineHeap)/(1<<20), float64(retainedHeap)/(1<<20))
+	fmt.Printf("Goroutines: %d before, %d after\n", baselineGoroutines, goroutines)
+	for _, problem := range problems {
+		fmt.Println("Failed request:", problem)
+	}
+
+	var failed []string
+	if sent != succeeded {
+		failed = append(failed, fmt.Sprintf("%d requests dropped", sent-succeeded))
+	}
+	switch {
+	case statsErr != nil:
+		failed = append(failed, fmt.Sprintf("stats unavailable: %v", statsErr))
+	case serverStats.Failures != 0 || serverStats.Panics != 0:
+		failed = append(failed, fmt.Sprintf("server counted %d failures and %d panics", serverStats.Failures, serverStats.Panics))
+	case serverStats.InFlight != 0 || serverStats.RunningJobs != 0 || serverStats.QueuedJobs != 0:
+		failed = append(failed, fmt.Sprintf("%d requests, %d running jobs and %d queued jobs left after the load",
+			serverStats.InFlight, serverStats.RunningJobs, serverStats.QueuedJobs))
+	}
+	if shutdownErr != nil {
+		failed = append(failed, fmt.Sprintf("shutdown: %v", shutdownErr))
+	}
+	if workersErr != nil {
+		failed = append(failed, fmt.Sprintf("workers: %v", workersErr))
+	}
+	if goroutines > baselineGoroutines {
+		failed = append(failed, fmt.Sprintf("%d goroutines leaked", goroutines-baselineGoroutines))
+	}
+	if stats.peakHeap.Load() > *maxHeap<<20 {
+		failed = append(failed, "heap above the limit")
+	}
+	if retainedHeap > baselineHeap+selftestRetainedHeap {
+		failed = append(failed, fmt.Sprintf("%.1f MiB retained after the shutdown", float64(retainedHeap-baselineHeap)/(1<<20)))
+	}
+
+	if len(failed) > 0 {
+		for _, reason := range failed {
+			fmt.Println("FAIL:", reason)
+		}
+		return 1
+	}
+	fmt.Println("PASS")
+	return 0
+}
+
+func selftestImages(sizes []image.Point) ([][]byte, error) {
+	var images [][]byte
+	for _, size := range sizes {
+		for _, angle := range selftestAngles {
+			document := syntheticDocument(size.X, size.Y, angle)
+			var buffer bytes.Buffer
+			var err error
+			if len(images)%2 == 0 {
+				err = jpeg.Encode(&buffer, document, &jpeg.Options{Quality: 90})
+			} else {
+				err = png.Encode(&buffer, document)
+			}
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, buffer.Bytes())
+		}
+	}
+	return images, nil
+}
+
+func runClient(address string, images [][]byte, client int, stop <-chan struct{}, stats *selftestStats, failures chan<- error) {
+	operations := []protocol.Header{
+		{Operation: protocol.OperationCrop},
+		{Operation: protocol.OperationCorners},
+		{Operation: protocol.OperationEdges},
+		{Operation: protocol.OperationScan},
+		{Operation: protocol.OperationCrop, Async: true},
+	}
+
+	var connection *clientlib.Client
+	defer func() {
+		if connection != nil {
+			connection.Close()
+		}
+	}()
+
+	for n := client; ; n++ {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		if connection == nil {
+			var err error
+			if connection, err = clientlib.Dial(address); err != nil {
+				stats.sent.Add(1)
+				failures <- fmt.Errorf("client %d: %w", client, err)
+				time.Sleep(selftestPollPeriod)
+				continue
+			}
+		}
+
+		header := operations[n%len(operations)]
+		header.NoCache = true
+		stats.sent.Add(1)
+		if err := selftestRequest(connection, header, images[n%len(images)]); err != nil {
+			failures <- fmt.Errorf("client %d, %s: %w", client, header.Operation, err)
+		} else {
+			stats.succeeded.Add(1)
+		}
+
+		if (n-client+1)%selftestReconnect == 0 {
+			connection.Close()
+			connection = nil
+		}
+	}
+}
+
+func selftestRequest(client *clientlib.Client, header protocol.Header, data []byte) error {
+	response, err := client.Do(header, bytes.NewReader(data), int64(len(data)))
+	if err != nil || !header.Async {
+		return err
+	}
+
+	jobID := response.Metadata.JobID
+	deadline := time.Now().Add(selftestJobTimeout)
+	for time.Now().Before(deadline) {
+		response, err := client.Do(protocol.Header{JobID: jobID}, nil, 0)
+		if err != nil {
+			return fmt.Errorf("job %s: %w", jobID, err)
+		}
+		switch response.Metadata.Status {
+		case protocol.StatusDone:
+			return nil
+		case protocol.StatusFailed:
+			return fmt.Errorf("job %s failed", jobID)
+		}
+		time.Sleep(selftestPollPeriod)
+	}
+	return fmt.Errorf("job %s not done after %v", jobID, selftestJobTimeout)
+}
+
+func resizeWorkers(admin *http.Client, address string, counts []int, interval time.Duration, stop <-chan struct{}, stats *selftestStats, failures chan<- error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for n := 0; ; n++ {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		url := fmt.Sprintf("http://%s/workers?count=%d", address, counts[n%len(counts)])
+		response, err := admin.Post(url, "", nil)
+		if err != nil {
+			failures <- fmt.Errorf("resize: %w", err)
+			continue
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			failures <- fmt.Errorf("resize: %s", response.Status)
+			continue
+		}
+		stats.resizes.Add(1)
+	}
+}
+
+func sampleHeap(stop <-chan struct{}, stats *selftestStats) {
+	ticker := time.NewTicker(selftestSampleInterval)
+	defer ticker.Stop()
+
+	var memory runtime.MemStats
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		runtime.ReadMemStats(&memory)
+		if memory.HeapInuse > stats.peakHeap.Load() {
+			stats.peakHeap.Store(memory.HeapInuse)
+		}
+	}
+}
+
+func readAdminStats(admin *http.Client, address string) (adminStats, error) {
+	var stats adminStats
+	response, err := admin.Get("http://" + address + "/stats")
+	if err != nil {
+		return stats, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return stats, errors.New(response.Status)
+	}
+	err = json.NewDecoder(response.Body).Decode(&stats)
+	return stats, err
+}
+
+func settledGoroutines(baseline int) int {
+	deadline := time.Now().Add(selftestSettleTimeout)
+	for {
+		goroutines := runtime.NumGoroutine()
+		if goroutines <= baseline || time.Now().After(deadline) {
+			return goroutines
+		}
+		time.Sleep(selftestPollPeriod)
+	}
+}
+
+func heapInUse() uint64 {
+	runtime.GC()
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	return memory.HeapInuse
+}
@@ -0,0 +1,34 @@
+package main
+
+/*
+This file runs a short `server selftest` against the synthetic documents: it must pass on a healthy server, and
+return a non-zero exit status when one of its checks fails or its flags are invalid.
+*/
+
+import (
+	"io"
+	"log"
+	"testing"
+)
+
+func TestSelftest(t *testing.T) {
+	output := log.Writer()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(output)
+
+	args := []string{"-duration", "2s", "-clients", "2", "-workers", "2", "-resize-every", "300ms", "-sizes", "400x300"}
+	if status := runSelftest(args); status != 0 {
+		t.Fatalf("self-test exited with status %d, expected 0", status)
+	}
+
+	// No heap is below a limit of 0 MiB.
+	if status := runSelftest(append(args, "-max-heap", "0")); status != 1 {
+		t.Fatalf("failed self-test exited with status %d, expected 1", status)
+	}
+
+	for _, invalid := range [][]string{{"-clients", "0"}, {"-sizes", "400"}, {"unexpected"}} {
+		if status := runSelftest(invalid); status != 2 {
+			t.Fatalf("self-test with %v exited with status %d, expected 2", invalid, status)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package anonymize
+
+/*
+This file implements the blurring of the regions found by a `Detector`.
+
+---
+
+### Constants
+- `blurPasses`: Number of box blurs applied in a row, which together approach a Gaussian blur.
+- `minBlurRadius`: Smallest radius of the blur, in pixels.
+- `blurRadiusDivisor`: The radius of the blur of a region is its smaller side divided by this, so a photo is as
+  unrecognizable whatever its resolution.
+
+---
+
+### `Blur(img image.Image, regions []image.Rectangle) *image.RGBA`
+Returns a copy of `img` in which every region is blurred. The blur of a region only reads the pixels of the region,
+so the rest of the document stays sharp up to its border.
+
+### `blurRows(img *image.RGBA, radius int)` / `blurColumns(img *image.RGBA, radius int)`
+Applies a box blur of `radius` pixels to the rows, or the columns, of `img` in place. The pixels beyond the borders
+repeat the border pixels.
+*/
+
+import (
+	"image"
+	"image/draw"
+)
+
+const (
+	blurPasses        = 3
+	minBlurRadius     = 4
+	blurRadiusDivisor = 6
+)
+
+func Blur(img image.Image, regions []image.Rectangle) *image.RGBA {
+	bounds := img.Bounds()
+	output := image.NewRGBA(bounds)
+	draw.Draw(output, bounds, img, bounds.Min, draw.Src)
+
+	for _, region := range regions {
+		region = region.Intersect(bounds)
+		if region.Empty() {
+			continue
+		}
+
+		patch := image.NewRGBA(region)
+		draw.Draw(patch, region, output, region.Min, draw.Src)
+		radius := max(minBlurRadius, min(region.Dx(), region.Dy())/blurRadiusDivisor)
+		for pass := 0; pass < blurPasses; pass++ {
+			blurRows(patch, radius)
+			blurColumns(patch, radius)
+		}
+		draw.Draw(output, region, patch, region.Min, draw.Src)
+	}
+
+	return output
+}
+
+func blurRows(img *image.RGBA, radius int) {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	window := 2*radius + 1
+	line := make([]uint8, width*4)
+
+	for y := 0; y < height; y++ {
+		row := img.Pix[y*img.Stride : y*img.Stride+width*4]
+		copy(line, row)
+		for channel := 0; channel < 4; channel++ {
+			sum := 0
+			for k := -radius; k <= radius; k++ {
+				sum += int(line[min(max(k, 0), width-1)*4+channel])
+			}
+			for x := 0; x < width; x++ {
+				row[x*4+channel] = uint8(sum / window)
+				sum += int(line[min(x+radius+1, width-1)*4+channel]) - int(line[max(x-radius, 0)*4+channel])
+			}
+		}
+	}
+}
+
+func blurColumns(img *image.RGBA, radius int) {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	window := 2*radius + 1
+	line := make([]uint8, height)
+
+	for x := 0; x < width; x++ {
+		for channel := 0; channel < 4; channel++ {
+			offset := x*4 + channel
+			for y := 0; y < height; y++ {
+				line[y] = img.Pix[y*img.Stride+offset]
+			}
+
+			sum := 0
+			for k := -radius; k <= radius; k++ {
+				sum += int(line[min(max(k, 0), height-1)])
+			}
+			for y := 0; y < height; y++ {
+				img.Pix[y*img.Stride+offset] = uint8(sum / window)
+				sum += int(line[min(y+radius+1, height-1)]) - int(line[max(y-radius, 0)])
+			}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package anonymize
+
+/*
+Package anonymize hides the photos printed on a document, e.g. the ID photo of an identity card or a face on a form,
+before the document leaves the server. A `Detector` finds the regions to hide, which `Blur` makes unrecognizable.
+Detectors can be swapped: the server uses `SkinDetector` unless it is given another one.
+
+---
+
+### `Detector`
+Finds the regions of an image to anonymize.
+
+- Methods:
+  - `Detect(img image.Image) []image.Rectangle`: Returns the regions to blur, in the coordinates of `img`. They can
+    overlap.
+
+### `SkinDetector`
+Detects the photos of people from the skin tones they show: the image is divided into square blocks, the blocks
+mostly covered with skin-tone pixels are grouped with their neighbours, and the compact groups large enough to be a
+photo are returned, widened by a margin to cover the hair and the background of the photo.
+
+- Fields:
+  - `BlockSize`: Side of the blocks, in pixels.
+  - `MinCoverage`: Share of skin-tone pixels making a block part of a photo.
+  - `MinFill`: Smallest share of the bounding box of a group covered by its blocks, so scattered blocks are ignored.
+  - `MinArea`, `MaxArea`: Smallest and largest area of a group, as a share of the image. A group covering most of
+    the image is rather a tinted paper or background than a photo.
+  - `Margin`: Margin added around a group on every side, as a share of its size.
+
+- Methods:
+  - `Detect(img image.Image) []image.Rectangle`: Implements `Detector`.
+
+---
+
+### `NewSkinDetector() *SkinDetector`
+Returns a detector with settings suited to ID cards and forms photographed at a usual resolution.
+
+### `isSkin(pixel color.RGBA) bool`
+Tells whether a pixel has a skin tone, by the RGB rule of Kovač et al.: reddish, bright enough and saturated enough,
+which leaves out the white, cream and gray papers.
+
+### `Apply(img image.Image, detector Detector) (image.Image, []image.Rectangle)`
+Blurs the regions found by `detector` in `img`, and returns the anonymized image with the blurred regions. `img` is
+returned unchanged if no region is found.
+*/
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+type Detector interface {
+	Detect(img image.Image) []image.Rectangle
+}
+
+type SkinDetector struct {
+	BlockSize   int
+	MinCoverage float64
+	MinFill     float64
+	MinArea     float64
+	MaxArea     float64
+	Margin      float64
+}
+
+func NewSkinDetector() *SkinDetector {
+	return &SkinDetector{
+		BlockSize:   8,
+		MinCoverage: 0.5,
+		MinFill:     0.4,
+		MinArea:     0.005,
+		MaxArea:     0.6,
+		Margin:      0.25,
+	}
+}
+
+func (detector *SkinDetector) Detect(img image.Image) []image.Rectangle {
+	bounds := img.Bounds()
+	if bounds.Empty() || detector.BlockSize <= 0 {
+		return nil
+	}
+
+	source, ok := img.(*image.RGBA)
+	if !ok {
+		source = image.NewRGBA(bounds)
+		draw.Draw(source, bounds, img, bounds.Min, draw.Src)
+	}
+
+	size := detector.BlockSize
+	columns := (bounds.Dx() + size - 1) / size
+	rows := (bounds.Dy() + size - 1) / size
+	skin := make([]bool, columns*rows)
+	for row := 0; row < rows; row++ {
+		for column := 0; column < columns; column++ {
+			block := image.Rect(column*size, row*size, (column+1)*size, (row+1)*size).Add(bounds.Min).Intersect(bounds)
+			count := 0
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					if isSkin(source.RGBAAt(x, y)) {
+						count++
+					}
+				}
+			}
+			skin[row*columns+column] = float64(count) >= detector.MinCoverage*float64(block.Dx()*block.Dy())
+		}
+	}
+
+	imageArea := float64(bounds.Dx() * bounds.Dy())
+	visited := make([]bool, len(skin))
+	var regions []image.Rectangle
+	for start := range skin {
+		if !skin[start] || visited[start] {
+			continue
+		}
+
+		// Groups the skin blocks connected to the start block, and measures their bounding box in blocks.
+		queue := []int{start}
+		visited[start] = true
+		blocks := 0
+		first := image.Pt(start%columns, start/columns)
+		box := image.Rectangle{Min: first, Max: first.Add(image.Pt(1, 1))}
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			blocks++
+			column, row := current%columns, current/columns
+			box = box.Union(image.Rect(column, row, column+1, row+1))
+
+			for _, neighbour := range [][2]int{{column - 1, row}, {column + 1, row}, {column, row - 1}, {column, row + 1}} {
+				if neighbour[0] < 0 || neighbour[0] >= columns || neighbour[1] < 0 || neighbour[1] >= rows {
+					continue
+				}
+				index := neighbour[1]*columns + neighbour[0]
+				if skin[index] && !visited[index] {
+					visited[index] = true
+					queue = append(queue, index)
+				}
+			}
+		}
+
+		if float64(blocks) < detector.MinFill*float64(box.Dx()*box.Dy()) {
+			continue
+		}
+		region := image.Rect(box.Min.X*size, box.Min.Y*size, box.Max.X*size, box.Max.Y*size).Add(bounds.Min).Intersect(bounds)
+		area := float64(region.Dx() * region.Dy())
+		if area < detector.MinArea*imageArea || area > detector.MaxArea*imageArea {
+			continue
+		}
+
+		marginX := int(detector.Margin * float64(region.Dx()))
+		marginY := int(detector.Margin * float64(region.Dy()))
+		region = image.Rect(region.Min.X-marginX, region.Min.Y-marginY, region.Max.X+marginX, region.Max.Y+marginY)
+		regions = append(regions, region.Intersect(bounds))
+	}
+
+	return regions
+}
+
+func isSkin(pixel color.RGBA) bool {
+	r, g, b := int(pixel.R), int(pixel.G), int(pixel.B)
+	return r > 95 && g > 40 && b > 20 &&
+		max(r, g, b)-min(r, g, b) > 15 &&
+		r-g > 15 && r > b
+}
+
+func Apply(img image.Image, detector Detector) (image.Image, []image.Rectangle) {
+	regions := detector.Detect(img)
+	if len(regions) == 0 {
+		return img, nil
+	}
+	return Blur(img, regions), regions
+}
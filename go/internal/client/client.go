@@ -0,0 +1,458 @@
+package client
+
+/*
+Package client implements the client side of the processing protocol (see `internal/protocol`).
+
+A single connection can carry several outstanding requests: `Submit` sends a request without waiting for the
+previous responses, and the response is delivered to a per-request callback as soon as the server has finished
+processing it. `Do` is the synchronous equivalent used when only one image is processed.
+
+---
+
+### Types
+
+#### `Response`
+Result of a request.
+
+- **Fields**:
+  - `RequestID uint32`: The ID of the request on the connection.
+  - `Metadata protocol.Metadata`: The metadata sent by the server, such as the ID and state of an asynchronous job.
+  - `Data []byte`: The processed image returned by the server, nil if the response has no image.
+  - `Trailer protocol.Trailer`: The trailer sent by the server after the image, with the time spent in every
+    stage of the request.
+  - `Artifacts []protocol.Artifact`: The intermediate images requested with `protocol.Header.Artifacts`, in the
+    order they were computed. Also set when the request failed after some of them were sent.
+  - `Err error`: The error of the request. A `protocol.ErrorMessage` if the server rejected the request or the
+    whole connection (e.g. `protocol.CodeBusy`, the request can be retried later). If the connection was lost,
+    the fields above hold what was received before, `Data` included: the bytes of the image received so far, from
+    which the result of a job can be resumed with `QueryFrom`.
+
+#### `Callback`
+Function receiving the `Response` of a request. Callbacks are called from the goroutine reading the connection,
+they must not block.
+
+#### `ProgressCallback`
+Function receiving the progress reports of the requests sent with `protocol.Header.Progress`, with the ID of the
+request. Called from the goroutine reading the connection, it must not block.
+
+#### `Client`
+A connection to the processing server.
+
+- **Methods**:
+  - `Authenticate(token string) error`: Sends the API key of the client, must be called before the first request
+    when the server requires authentication. A rejected key makes every request fail with a
+    `protocol.CodeUnauthorized` error.
+  - `Submit(header protocol.Header, image io.Reader, size int64, callback Callback) error`: Sends a request and
+    returns as soon as it has been written.
+  - `Do(header protocol.Header, image io.Reader, size int64) (Response, error)`: Sends a request and waits for its
+    response.
+  - `Query(jobID string) (Response, error)`: Asks the state of an asynchronous job, and its result once it is done.
+  - `QueryFrom(jobID string, offset int64) (Response, error)`: Same as `Query`, the result of a finished job being
+    sent from byte `offset`, to resume a download interrupted after that many bytes. `Metadata.Size` is the size
+    of the whole result.
+  - `Finalize(session string, format string) (Response, error)`: Finalizes a scan session, whose pages were sent
+    with `protocol.Header.Session`, and returns its pages combined in `format` (`protocol.FormatPDF` if empty, or
+    `protocol.FormatZIP`).
+  - `OnProgress(callback ProgressCallback)`: Sets the callback receiving the progress reports of the requests.
+  - `Wait()`: Waits until every submitted request has received its response.
+  - `SetDeadline(deadline time.Time) error`: Sets the time after which the reads and writes of the connection fail,
+    which fails the pending requests. The zero time removes the deadline.
+  - `Close() error`: Closes the connection, pending requests fail with `ErrClosed`.
+
+---
+
+### Dial(address string) (*Client, error)
+Connects to the server at `address` (`host:port`) and sends the protocol magic, using the default socket options.
+The control messages are encoded with protobuf (`protocol.Protobuf`).
+
+### DialWithOptions(address string, options netUtils.SocketOptions) (*Client, error)
+Same as `Dial`, with the given socket options (kernel buffers, `TCP_NODELAY`, size of the buffers coalescing the
+reads and writes of the connection).
+
+### DialNetwork(network string, address string, options netUtils.SocketOptions) (*Client, error)
+Same as `DialWithOptions` on any stream network of `net.Dial`, e.g. `unix` with the path of the socket of a local
+server as `address`. The kernel settings of the options only apply to TCP connections.
+
+### DialCodec(network string, address string, options netUtils.SocketOptions, codec protocol.Codec) (*Client, error)
+Same as `DialNetwork`, encoding the control messages with `codec`. `protocol.JSON` talks to servers released before
+the protobuf schema.
+
+### DialContext(ctx context.Context, network string, address string, options netUtils.SocketOptions, codec protocol.Codec) (*Client, error)
+Same as `DialCodec`, giving up connecting once `ctx` is done.
+
+---
+
+### Example Usage:
+```go
+client, err := client.Dial("localhost:14750")
+if err != nil {
+	log.Fatal(err)
+}
+defer client.Close()
+client.Authenticate(os.Getenv("ELP_API_KEY"))
+
+for _, path := range paths {
+	file, _ := os.Open(path)
+	info, _ := file.Stat()
+	client.Submit(protocol.Header{}, file, info.Size(), func(response client.Response) {
+		// save response.Data
+	})
+}
+client.Wait()
+```
+*/
+
+import (
+	"ELP-project/internal/netUtils"
+	"ELP-project/internal/protocol"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrClosed = errors.New("client: connection closed")
+
+type Response struct {
+	RequestID uint32
+	Metadata  protocol.Metadata
+	Data      []byte
+	Artifacts []protocol.Artifact
+	Trailer   protocol.Trailer
+	Err       error
+}
+
+type Callback func(Response)
+
+type ProgressCallback func(requestID uint32, progress protocol.Progress)
+
+type Client struct {
+	conn       net.Conn
+	codec      protocol.Codec
+	reader     *bufio.Reader
+	writer     *bufio.Writer
+	writeMutex sync.Mutex
+
+	mutex    sync.Mutex
+	pending  map[uint32]Callback
+	nextID   uint32
+	err      error
+	progress ProgressCallback
+
+	inFlight sync.WaitGroup
+}
+
+func Dial(address string) (*Client, error) {
+	return DialWithOptions(address, netUtils.DefaultSocketOptions())
+}
+
+func DialWithOptions(address string, options netUtils.SocketOptions) (*Client, error) {
+	return DialNetwork("tcp", address, options)
+}
+
+func DialNetwork(network string, address string, options netUtils.SocketOptions) (*Client, error) {
+	return DialCodec(network, address, options, protocol.Protobuf)
+}
+
+func DialCodec(network string, address string, options netUtils.SocketOptions, codec protocol.Codec) (*Client, error) {
+	return DialContext(context.Background(), network, address, options, codec)
+}
+
+func DialContext(ctx context.Context, network string, address string, options netUtils.SocketOptions, codec protocol.Codec) (*Client, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to server: %w", err)
+	}
+
+	if err := options.Apply(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error tuning connection: %w", err)
+	}
+
+	if err := protocol.WriteMagic(conn, codec); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error sending protocol magic: %w", err)
+	}
+
+	client := &Client{
+		conn:    conn,
+		codec:   codec,
+		reader:  bufio.NewReaderSize(conn, options.IOBufferSize),
+		writer:  bufio.NewWriterSize(conn, options.IOBufferSize),
+		pending: make(map[uint32]Callback),
+	}
+	go client.readLoop()
+
+	return client, nil
+}
+
+func (client *Client) RemoteAddr() net.Addr {
+	return client.conn.RemoteAddr()
+}
+
+func (client *Client) Authenticate(token string) error {
+	client.writeMutex.Lock()
+	defer client.writeMutex.Unlock()
+
+	if err := protocol.WriteAuth(client.writer, client.codec, protocol.Auth{Token: token}); err != nil {
+		return fmt.Errorf("error sending API key: %w", err)
+	}
+	return client.flush()
+}
+
+func (client *Client) Submit(header protocol.Header, image io.Reader, size int64, callback Callback) error {
+	client.mutex.Lock()
+	if client.err != nil {
+		err := client.err
+		client.mutex.Unlock()
+		return err
+	}
+	client.nextID++
+	requestID := client.nextID
+	client.pending[requestID] = callback
+	client.inFlight.Add(1)
+	client.mutex.Unlock()
+
+	if err := client.sendRequest(requestID, header, image, size); err != nil {
+		client.mutex.Lock()
+		_, stillPending := client.pending[requestID]
+		delete(client.pending, requestID)
+		client.mutex.Unlock()
+
+		if stillPending {
+			client.inFlight.Done()
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (client *Client) Do(header protocol.Header, image io.Reader, size int64) (Response, error) {
+	responseChan := make(chan Response, 1)
+
+	err := client.Submit(header, image, size, func(response Response) {
+		responseChan <- response
+	})
+	if err != nil {
+		return Response{}, err
+	}
+
+	response := <-responseChan
+	return response, response.Err
+}
+
+func (client *Client) Query(jobID string) (Response, error) {
+	return client.QueryFrom(jobID, 0)
+}
+
+func (client *Client) QueryFrom(jobID string, offset int64) (Response, error) {
+	return client.Do(protocol.Header{JobID: jobID, Offset: offset}, nil, 0)
+}
+
+func (client *Client) Finalize(session string, format string) (Response, error) {
+	return client.Do(protocol.Header{Session: session, Finalize: true, Format: format}, nil, 0)
+}
+
+func (client *Client) OnProgress(callback ProgressCallback) {
+	client.mutex.Lock()
+	client.progress = callback
+	client.mutex.Unlock()
+}
+
+func (client *Client) Wait() {
+	client.inFlight.Wait()
+}
+
+func (client *Client) SetDeadline(deadline time.Time) error {
+	return client.conn.SetDeadline(deadline)
+}
+
+func (client *Client) Close() error {
+	return client.conn.Close()
+}
+
+func (client *Client) sendRequest(requestID uint32, header protocol.Header, image io.Reader, size int64) error {
+	client.writeMutex.Lock()
+	defer client.writeMutex.Unlock()
+
+	if err := protocol.WriteHeader(client.writer, client.codec, requestID, header); err != nil {
+		return fmt.Errorf("error sending request header: %w", err)
+	}
+	if image == nil {
+		return client.flush()
+	}
+	if err := protocol.WriteFrameHeader(client.writer, protocol.FrameImage, requestID, int(size)); err != nil {
+		return fmt.Errorf("error sending data: %w", err)
+	}
+
+	if _, err := netUtils.CopyN(client.writer, image, size); err != nil {
+		return fmt.Errorf("error sending image: %w", err)
+	}
+
+	return client.flush()
+}
+
+func (client *Client) flush() error {
+	if err := client.writer.Flush(); err != nil {
+		return fmt.Errorf("error sending data: %w", err)
+	}
+	return nil
+}
+
+func (client *Client) readLoop() {
+	responses := make(map[uint32]*Response)
+
+	for {
+		frameType, requestID, length, err := protocol.ReadFrameHeader(client.reader)
+		if err != nil {
+			client.fail(err, responses)
+			return
+		}
+
+		response, ok := responses[requestID]
+		if !ok {
+			response = &Response{RequestID: requestID}
+			responses[requestID] = response
+		}
+
+		switch frameType {
+		case protocol.FrameMetadata:
+			payload, err := protocol.ReadPayload(client.reader, length, protocol.MaxControlFrameSize)
+			if err != nil {
+				client.fail(err, responses)
+				return
+			}
+			response.Metadata, err = protocol.DecodeMetadata(client.codec, payload)
+			if err != nil {
+				client.fail(err, responses)
+				return
+			}
+			continue
+		case protocol.FrameProgress:
+			payload, err := protocol.ReadPayload(client.reader, length, protocol.MaxControlFrameSize)
+			if err != nil {
+				client.fail(err, responses)
+				return
+			}
+			progress, err := protocol.DecodeProgress(client.codec, payload)
+			if err != nil {
+				client.fail(err, responses)
+				return
+			}
+			client.mutex.Lock()
+			callback := client.progress
+			client.mutex.Unlock()
+			if callback != nil {
+				callback(requestID, progress)
+			}
+			continue
+		case protocol.FrameImage:
+			response.Data, err = client.receiveImage(length)
+			if err != nil {
+				client.fail(err, responses)
+				return
+			}
+			continue
+		case protocol.FrameArtifact:
+			payload, err := client.receiveImage(length)
+			if err != nil {
+				client.fail(err, responses)
+				return
+			}
+			artifact, err := protocol.DecodeArtifact(payload)
+			if err != nil {
+				client.fail(err, responses)
+				return
+			}
+			response.Artifacts = append(response.Artifacts, artifact)
+			continue
+		case protocol.FrameTrailer:
+			payload, err := protocol.ReadPayload(client.reader, length, protocol.MaxControlFrameSize)
+			if err != nil {
+				client.fail(err, responses)
+				return
+			}
+			response.Trailer, err = protocol.DecodeTrailer(client.codec, payload)
+			if err != nil {
+				client.fail(err, responses)
+				return
+			}
+			continue
+		case protocol.FrameError:
+			payload, err := protocol.ReadPayload(client.reader, length, protocol.MaxControlFrameSize)
+			if err != nil {
+				client.fail(err, responses)
+				return
+			}
+			if requestID == protocol.ConnectionRequestID {
+				client.fail(protocol.DecodeError(client.codec, payload), responses)
+				return
+			}
+			response.Err = protocol.DecodeError(client.codec, payload)
+		case protocol.FrameEnd:
+			if _, err := protocol.ReadPayload(client.reader, length, protocol.MaxControlFrameSize); err != nil {
+				client.fail(err, responses)
+				return
+			}
+		default:
+			client.fail(fmt.Errorf("unexpected %s frame from server", frameType), responses)
+			return
+		}
+
+		delete(responses, requestID)
+		client.deliver(*response)
+	}
+}
+
+func (client *Client) deliver(response Response) {
+	client.mutex.Lock()
+	callback, ok := client.pending[response.RequestID]
+	delete(client.pending, response.RequestID)
+	client.mutex.Unlock()
+
+	if !ok {
+		return
+	}
+	callback(response)
+	client.inFlight.Done()
+}
+
+func (client *Client) receiveImage(length int) ([]byte, error) {
+	var dataBuffer bytes.Buffer
+	dataBuffer.Grow(length + bytes.MinRead)
+
+	if _, err := netUtils.CopyN(&dataBuffer, client.reader, int64(length)); err != nil {
+		return dataBuffer.Bytes(), fmt.Errorf("error reading from connection: %w", err)
+	}
+
+	return dataBuffer.Bytes(), nil
+}
+
+func (client *Client) fail(err error, responses map[uint32]*Response) {
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		err = ErrClosed
+	}
+
+	client.mutex.Lock()
+	client.err = err
+	pending := client.pending
+	client.pending = make(map[uint32]Callback)
+	client.mutex.Unlock()
+
+	for requestID, callback := range pending {
+		response := Response{RequestID: requestID}
+		if partial, ok := responses[requestID]; ok {
+			response = *partial
+		}
+		response.Err = err
+		callback(response)
+		client.inFlight.Done()
+	}
+}
@@ -0,0 +1,132 @@
+package geometry
+
+/*
+Package geometry provides a registry of physical page sizes used to compute the output canvas of a scanned document.
+
+---
+
+### PageSize
+Represents the physical size of a page in portrait orientation.
+
+- **Fields**:
+  - `Name`: The name of the page size (e.g. "A4"), or "custom" for a size given in millimeters.
+  - `Width`: The width of the page in millimeters.
+  - `Height`: The height of the page in millimeters.
+
+### ID1
+The ISO/IEC 7810 ID-1 format of identity cards, driving licences and bank cards: 85.60 x 53.98 mm.
+
+---
+
+### ParsePageSize(name string) (PageSize, error)
+Returns the page size matching `name`.
+
+- **Parameters**:
+  - `name`: A registered name (case-insensitive: "A4", "A5", "Letter", "Legal", or "ID-1" for the ISO/IEC 7810
+    ID-1 format of identity and bank cards) or a custom size in millimeters written `WxH` (e.g. "100x150").
+- **Returns**:
+  - The matching `PageSize`, or an error if the name is unknown or the custom size is invalid. The whole custom
+    size must be two decimal numbers separated by `x`: trailing characters are rejected.
+
+---
+
+### PageSize.Canvas(dpi int, landscape bool) image.Point
+Computes the size in pixels of the page at the given resolution.
+
+- **Parameters**:
+  - `dpi`: Resolution in dots per inch.
+  - `landscape`: If true, the width and height of the page are swapped.
+- **Returns**:
+  - The size of the canvas in pixels (`X` is the width, `Y` is the height).
+
+### PageSize.Ratio() float64
+Returns the ratio of the longer side of the page to its shorter side, whatever its orientation: √2 for the A sizes,
+about 1.29 for Letter.
+
+### Pixels(millimeters float64, dpi int) int
+Converts a length in millimeters to pixels at the given resolution, rounded to the nearest pixel.
+
+---
+
+### Example Usage:
+```go
+page, err := geometry.ParsePageSize("A4")
+if err != nil {
+	panic(err)
+}
+canvas := page.Canvas(300, false) // 2480 x 3508 pixels
+```
+*/
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultDPI  = 200
+	mmPerInch   = 25.4
+	maxCustomMM = 2000
+)
+
+type PageSize struct {
+	Name          string
+	Width, Height float64
+}
+
+var ID1 = PageSize{Name: "ID-1", Width: 53.98, Height: 85.6}
+
+var pageSizes = map[string]PageSize{
+	"a4":     {Name: "A4", Width: 210, Height: 297},
+	"a5":     {Name: "A5", Width: 148, Height: 210},
+	"letter": {Name: "Letter", Width: 215.9, Height: 279.4},
+	"legal":  {Name: "Legal", Width: 215.9, Height: 355.6},
+	"id-1":   ID1,
+}
+
+func ParsePageSize(name string) (PageSize, error) {
+	key := strings.ToLower(strings.TrimSpace(name))
+
+	if page, ok := pageSizes[key]; ok {
+		return page, nil
+	}
+
+	widthText, heightText, found := strings.Cut(key, "x")
+	if !found {
+		return PageSize{}, fmt.Errorf("unknown page size: %q", name)
+	}
+	width, widthErr := strconv.ParseFloat(widthText, 64)
+	height, heightErr := strconv.ParseFloat(heightText, 64)
+	if widthErr != nil || heightErr != nil {
+		return PageSize{}, fmt.Errorf("unknown page size: %q", name)
+	}
+	if !(width > 0 && height > 0 && width <= maxCustomMM && height <= maxCustomMM) {
+		return PageSize{}, fmt.Errorf("invalid custom page size: %q", name)
+	}
+
+	if width > height {
+		width, height = height, width
+	}
+	return PageSize{Name: "custom", Width: width, Height: height}, nil
+}
+
+func (page PageSize) Canvas(dpi int, landscape bool) image.Point {
+	width := Pixels(page.Width, dpi)
+	height := Pixels(page.Height, dpi)
+
+	if landscape {
+		width, height = height, width
+	}
+	return image.Point{X: width, Y: height}
+}
+
+func (page PageSize) Ratio() float64 {
+	return max(page.Width, page.Height) / min(page.Width, page.Height)
+}
+
+func Pixels(millimeters float64, dpi int) int {
+	return int(math.Round(millimeters / mmPerInch * float64(dpi)))
+}
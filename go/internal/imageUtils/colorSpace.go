@@ -0,0 +1,356 @@
+package imageUtils
+
+/*
+Package imageUtils provides the conversions of an image between RGB and the color spaces separating the color of a
+pixel from its lightness: HSV, YCbCr and CIE Lab. A stage judging the paper by its saturation, or balancing the
+colors without touching the lightness, works on the channels of these spaces, then converts the result back.
+
+---
+
+### Constants
+- `SpaceRGB`, `SpaceHSV`, `SpaceYCbCr`, `SpaceLab`: The color spaces, whose channels are, in order:
+  - RGB: red, green and blue, from 0 to 255.
+  - HSV: hue in degrees, from 0 to 360 (0 for the grays), saturation and value, from 0 to 1.
+  - YCbCr: luma and the blue and red chroma, from 0 to 255, as in JPEG (see `color.RGBToYCbCr`).
+  - Lab: lightness, from 0 to 100, and the green-red `a` and blue-yellow `b` axes, roughly from -128 to 127, of an
+    sRGB color under the D65 illuminant.
+- `labWhite`: The X, Y and Z of the D65 white point.
+- `labEpsilon` / `labKappa`: Limits of the linear part of the Lab lightness curve, for the darkest colors.
+
+---
+
+### ColorSpace
+A color space of the conversions.
+
+- **Methods**:
+  - `String() string`: Name of the space, e.g. `hsv`.
+
+### ColorPlanes
+Channels of an image converted to a color space, as floating-point values.
+
+- Fields:
+  - `Space`: The color space of the channels.
+  - `Rect`: The bounds of the image.
+  - `Channels`: The three channels, row by row, in the ranges of `Space`.
+
+- **Methods**:
+  - `RGBA() *image.RGBA`: Converts the channels back to an opaque RGB image, the values out of range being clamped.
+  - `Channel(channel int) *image.Gray`: Returns the channel of index `channel` (0 to 2) as a grayscale image, its
+    range scaled to 0-255, e.g. the hue or the saturation to threshold.
+
+---
+
+### ToColorSpace(img image.Image, space ColorSpace) *ColorPlanes
+Converts every pixel of `img` to `space`. The alpha channel is ignored.
+
+### ExtractChannel(img image.Image, space ColorSpace, channel int) *image.Gray
+Returns a channel of `img` in `space` as `ColorPlanes.Channel` does, without keeping the other two.
+
+### RGBToHSV(r, g, b uint8) (h, s, v float64) / HSVToRGB(h, s, v float64) (r, g, b uint8)
+Convert a color between RGB and HSV. A hue out of 0-360 is wrapped around.
+
+### RGBToLab(r, g, b uint8) (l, a, bStar float64) / LabToRGB(l, a, bStar float64) (r, g, b uint8)
+Convert a color between sRGB and CIE Lab, through the linear RGB and the XYZ of the color. The colors out of the
+sRGB gamut are clamped.
+
+---
+
+### toSpace(space ColorSpace, r, g, b uint8) [3]float64 / fromSpace(space ColorSpace, values [3]float64) (r, g, b uint8)
+Convert a color between RGB and `space`.
+
+### channelRange(space ColorSpace, channel int) (low, high float64)
+Returns the range of a channel of `space`, mapped to 0-255 by `ColorPlanes.Channel`.
+
+### scaleChannel(value, low, high float64) uint8
+Maps `value` from `low`-`high` to 0-255, clamped.
+
+### labCurve(t float64) float64 / inverseLabCurve(t float64) float64
+The cube root curve of the Lab lightness, linear near black, and its inverse.
+
+### toSRGB(linear float64) uint8
+Encodes a linear value of 0 to 1 with the sRGB transfer curve, inverse of `srgbLinear`, a table of the linear value of
+every 8-bit level.
+
+---
+
+### Example Usage:
+```go
+saturation := imageUtils.ExtractChannel(photo, imageUtils.SpaceHSV, 1)
+paperThreshold := imageUtils.GrayHistogram(saturation).Otsu()
+
+planes := imageUtils.ToColorSpace(photo, imageUtils.SpaceLab)
+for i := range planes.Channels[1] {
+	planes.Channels[1][i] *= 0.5 // Halves the green-red tint.
+}
+corrected := planes.RGBA()
+```
+*/
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+type ColorSpace int
+
+const (
+	SpaceRGB ColorSpace = iota
+	SpaceHSV
+	SpaceYCbCr
+	SpaceLab
+)
+
+const (
+	labEpsilon = 216.0 / 24389
+	labKappa   = 24389.0 / 27
+)
+
+var labWhite = [3]float64{0.95047, 1, 1.08883}
+
+var srgbLinear = func() [256]float64 {
+	var table [256]float64
+	for value := range table {
+		encoded := float64(value) / 255
+		if encoded <= 0.04045 {
+			table[value] = encoded / 12.92
+		} else {
+			table[value] = math.Pow((encoded+0.055)/1.055, 2.4)
+		}
+	}
+	return table
+}()
+
+type ColorPlanes struct {
+	Space    ColorSpace
+	Rect     image.Rectangle
+	Channels [3][]float64
+}
+
+func (space ColorSpace) String() string {
+	switch space {
+	case SpaceRGB:
+		return "rgb"
+	case SpaceHSV:
+		return "hsv"
+	case SpaceYCbCr:
+		return "ycbcr"
+	case SpaceLab:
+		return "lab"
+	}
+	return "unknown"
+}
+
+func ToColorSpace(img image.Image, space ColorSpace) *ColorPlanes {
+	rgba := toRGBA(img)
+	bounds := rgba.Bounds()
+	planes := &ColorPlanes{Space: space, Rect: bounds}
+	for channel := range planes.Channels {
+		planes.Channels[channel] = make([]float64, bounds.Dx()*bounds.Dy())
+	}
+
+	i := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := rgba.Pix[rgba.PixOffset(bounds.Min.X, y):rgba.PixOffset(bounds.Max.X, y)]
+		for x := 0; x < len(row); x += 4 {
+			values := toSpace(space, row[x], row[x+1], row[x+2])
+			for channel, value := range values {
+				planes.Channels[channel][i] = value
+			}
+			i++
+		}
+	}
+	return planes
+}
+
+func (planes *ColorPlanes) RGBA() *image.RGBA {
+	output := image.NewRGBA(planes.Rect)
+	i := 0
+	for y := planes.Rect.Min.Y; y < planes.Rect.Max.Y; y++ {
+		row := output.Pix[output.PixOffset(planes.Rect.Min.X, y):output.PixOffset(planes.Rect.Max.X, y)]
+		for x := 0; x < len(row); x += 4 {
+			values := [3]float64{planes.Channels[0][i], planes.Channels[1][i], planes.Channels[2][i]}
+			row[x], row[x+1], row[x+2] = fromSpace(planes.Space, values)
+			row[x+3] = 255
+			i++
+		}
+	}
+	return output
+}
+
+func (planes *ColorPlanes) Channel(channel int) *image.Gray {
+	gray := image.NewGray(planes.Rect)
+	low, high := channelRange(planes.Space, channel)
+	width := planes.Rect.Dx()
+	for y := 0; y < planes.Rect.Dy(); y++ {
+		row := gray.Pix[y*gray.Stride : y*gray.Stride+width]
+		for x := range row {
+			row[x] = scaleChannel(planes.Channels[channel][y*width+x], low, high)
+		}
+	}
+	return gray
+}
+
+func ExtractChannel(img image.Image, space ColorSpace, channel int) *image.Gray {
+	rgba := toRGBA(img)
+	bounds := rgba.Bounds()
+	gray := image.NewGray(bounds)
+	low, high := channelRange(space, channel)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := rgba.Pix[rgba.PixOffset(bounds.Min.X, y):rgba.PixOffset(bounds.Max.X, y)]
+		grayRow := gray.Pix[gray.PixOffset(bounds.Min.X, y):gray.PixOffset(bounds.Max.X, y)]
+		for x := range grayRow {
+			grayRow[x] = scaleChannel(toSpace(space, row[4*x], row[4*x+1], row[4*x+2])[channel], low, high)
+		}
+	}
+	return gray
+}
+
+func RGBToHSV(r, g, b uint8) (h, s, v float64) {
+	red, green, blue := float64(r)/255, float64(g)/255, float64(b)/255
+	highest := max(red, green, blue)
+	chroma := highest - min(red, green, blue)
+	v = highest
+	if highest > 0 {
+		s = chroma / highest
+	}
+	if chroma == 0 {
+		return 0, s, v
+	}
+
+	switch highest {
+	case red:
+		h = math.Mod((green-blue)/chroma, 6)
+	case green:
+		h = (blue-red)/chroma + 2
+	default:
+		h = (red-green)/chroma + 4
+	}
+	h *= 60
+	if h < 0 {
+		h += 360
+	}
+	return h, s, v
+}
+
+func HSVToRGB(h, s, v float64) (r, g, b uint8) {
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	s, v = min(max(s, 0), 1), min(max(v, 0), 1)
+
+	chroma := v * s
+	sector := h / 60
+	second := chroma * (1 - math.Abs(math.Mod(sector, 2)-1))
+	var red, green, blue float64
+	switch int(sector) {
+	case 0:
+		red, green = chroma, second
+	case 1:
+		red, green = second, chroma
+	case 2:
+		green, blue = chroma, second
+	case 3:
+		green, blue = second, chroma
+	case 4:
+		red, blue = second, chroma
+	default:
+		red, blue = chroma, second
+	}
+
+	offset := v - chroma
+	return uint8(math.Round(255 * (red + offset))), uint8(math.Round(255 * (green + offset))), uint8(math.Round(255 * (blue + offset)))
+}
+
+func RGBToLab(r, g, b uint8) (l, a, bStar float64) {
+	red, green, blue := srgbLinear[r], srgbLinear[g], srgbLinear[b]
+	x := (0.4124564*red + 0.3575761*green + 0.1804375*blue) / labWhite[0]
+	y := (0.2126729*red + 0.7151522*green + 0.0721750*blue) / labWhite[1]
+	z := (0.0193339*red + 0.1191920*green + 0.9503041*blue) / labWhite[2]
+
+	fx, fy, fz := labCurve(x), labCurve(y), labCurve(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+func LabToRGB(l, a, bStar float64) (r, g, b uint8) {
+	fy := (l + 16) / 116
+	x := inverseLabCurve(fy+a/500) * labWhite[0]
+	y := inverseLabCurve(fy) * labWhite[1]
+	z := inverseLabCurve(fy-bStar/200) * labWhite[2]
+
+	red := 3.2404542*x - 1.5371385*y - 0.4985314*z
+	green := -0.9692660*x + 1.8760108*y + 0.0415560*z
+	blue := 0.0556434*x - 0.2040259*y + 1.0572252*z
+	return toSRGB(red), toSRGB(green), toSRGB(blue)
+}
+
+func toSpace(space ColorSpace, r, g, b uint8) [3]float64 {
+	switch space {
+	case SpaceHSV:
+		h, s, v := RGBToHSV(r, g, b)
+		return [3]float64{h, s, v}
+	case SpaceYCbCr:
+		y, cb, cr := color.RGBToYCbCr(r, g, b)
+		return [3]float64{float64(y), float64(cb), float64(cr)}
+	case SpaceLab:
+		l, a, bStar := RGBToLab(r, g, b)
+		return [3]float64{l, a, bStar}
+	}
+	return [3]float64{float64(r), float64(g), float64(b)}
+}
+
+func fromSpace(space ColorSpace, values [3]float64) (r, g, b uint8) {
+	switch space {
+	case SpaceHSV:
+		return HSVToRGB(values[0], values[1], values[2])
+	case SpaceYCbCr:
+		return color.YCbCrToRGB(scaleChannel(values[0], 0, 255), scaleChannel(values[1], 0, 255), scaleChannel(values[2], 0, 255))
+	case SpaceLab:
+		return LabToRGB(values[0], values[1], values[2])
+	}
+	return scaleChannel(values[0], 0, 255), scaleChannel(values[1], 0, 255), scaleChannel(values[2], 0, 255)
+}
+
+func channelRange(space ColorSpace, channel int) (low, high float64) {
+	switch {
+	case space == SpaceHSV && channel == 0:
+		return 0, 360
+	case space == SpaceHSV:
+		return 0, 1
+	case space == SpaceLab && channel == 0:
+		return 0, 100
+	case space == SpaceLab:
+		return -128, 127
+	}
+	return 0, 255
+}
+
+func scaleChannel(value, low, high float64) uint8 {
+	return uint8(math.Round(min(max(255*(value-low)/(high-low), 0), 255)))
+}
+
+func labCurve(t float64) float64 {
+	if t > labEpsilon {
+		return math.Cbrt(t)
+	}
+	return (labKappa*t + 16) / 116
+}
+
+func inverseLabCurve(t float64) float64 {
+	if cube := t * t * t; cube > labEpsilon {
+		return cube
+	}
+	return (116*t - 16) / labKappa
+}
+
+func toSRGB(linear float64) uint8 {
+	linear = min(max(linear, 0), 1)
+	var encoded float64
+	if linear <= 0.0031308 {
+		encoded = 12.92 * linear
+	} else {
+		encoded = 1.055*math.Pow(linear, 1/2.4) - 0.055
+	}
+	return uint8(math.Round(255 * encoded))
+}
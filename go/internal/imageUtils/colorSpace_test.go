@@ -0,0 +1,85 @@
+package imageUtils
+
+/*
+This file tests the color space conversions: the channels of a few known colors, the round trip of a range of colors
+through every space, and the extraction of a channel as a grayscale image.
+*/
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestKnownColors(t *testing.T) {
+	tests := []struct {
+		name     string
+		color    [3]uint8
+		space    ColorSpace
+		expected [3]float64
+	}{
+		{"red hsv", [3]uint8{255, 0, 0}, SpaceHSV, [3]float64{0, 1, 1}},
+		{"blue hsv", [3]uint8{0, 0, 255}, SpaceHSV, [3]float64{240, 1, 1}},
+		{"gray hsv", [3]uint8{128, 128, 128}, SpaceHSV, [3]float64{0, 0, 128.0 / 255}},
+		{"white lab", [3]uint8{255, 255, 255}, SpaceLab, [3]float64{100, 0, 0}},
+		{"red lab", [3]uint8{255, 0, 0}, SpaceLab, [3]float64{53.24, 80.09, 67.20}},
+		{"black lab", [3]uint8{0, 0, 0}, SpaceLab, [3]float64{0, 0, 0}},
+		{"white ycbcr", [3]uint8{255, 255, 255}, SpaceYCbCr, [3]float64{255, 128, 128}},
+	}
+	for _, test := range tests {
+		values := toSpace(test.space, test.color[0], test.color[1], test.color[2])
+		for channel, value := range values {
+			if math.Abs(value-test.expected[channel]) > 0.01 {
+				t.Errorf("%s: channel %d is %.3f, expected %.3f", test.name, channel, value, test.expected[channel])
+			}
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8*8))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 8; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x * 36), G: uint8(y % 8 * 36), B: uint8(y / 8 * 36), A: 255})
+		}
+	}
+
+	for _, space := range []ColorSpace{SpaceRGB, SpaceHSV, SpaceYCbCr, SpaceLab} {
+		converted := ToColorSpace(img, space).RGBA()
+		for i := range img.Pix {
+			if difference := int(converted.Pix[i]) - int(img.Pix[i]); difference < -1 || difference > 1 {
+				t.Fatalf("%s: value %d becomes %d after the round trip", space, img.Pix[i], converted.Pix[i])
+			}
+		}
+	}
+}
+
+func TestExtractChannel(t *testing.T) {
+	img := image.NewRGBA(image.Rect(2, 3, 4, 4))
+	img.SetRGBA(2, 3, color.RGBA{B: 255, A: 255})
+	img.SetRGBA(3, 3, color.RGBA{R: 200, G: 200, B: 200, A: 255})
+
+	hue := ExtractChannel(img, SpaceHSV, 0)
+	if hue.Bounds() != img.Bounds() {
+		t.Fatalf("channel bounds are %v, expected %v", hue.Bounds(), img.Bounds())
+	}
+	if level := hue.GrayAt(2, 3).Y; level != 170 {
+		t.Errorf("hue of blue is level %d, expected 170", level)
+	}
+
+	saturation := ExtractChannel(img, SpaceHSV, 1)
+	if level := saturation.GrayAt(3, 3).Y; level != 0 {
+		t.Errorf("saturation of gray is level %d, expected 0", level)
+	}
+
+	planes := ToColorSpace(img, SpaceHSV)
+	for channel := range planes.Channels {
+		extracted, fromPlanes := ExtractChannel(img, SpaceHSV, channel), planes.Channel(channel)
+		for i := range extracted.Pix {
+			if extracted.Pix[i] != fromPlanes.Pix[i] {
+				t.Errorf("channel %d differs between ExtractChannel and ColorPlanes.Channel", channel)
+			}
+		}
+	}
+}
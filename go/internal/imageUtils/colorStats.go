@@ -0,0 +1,252 @@
+package imageUtils
+
+/*
+Package imageUtils provides per-channel color statistics of an image, and the estimate of its color cast, e.g. the
+yellow tint of a document photographed under a tungsten lamp.
+
+---
+
+### Constants
+- `highlightShare`: Share of the brightest pixels of the image taken as the white of the paper.
+- `castThreshold`: Smallest deviation of a channel of the highlights from their gray, relative to it, reported as a
+  color cast. Below it, the tint is not visible on a scan.
+- `maxGrayWorldGain`: Largest gain of a gray-world white balance, and inverse of the smallest: a document mostly
+  covered by a colored photo or form is not neutral on average, and would otherwise be tinted with the opposite color.
+
+---
+
+### ChannelStats
+Statistics of a channel of an image, in 8-bit values.
+
+- Fields:
+  - `Mean`: Mean value of the channel.
+  - `P5`, `P50`, `P95`: 5th, 50th (median) and 95th percentiles of the channel.
+
+### ColorCast
+Color cast of an image, estimated on its highlights: the brightest `highlightShare` of the pixels of a scan are the
+paper, which is expected to be neutral, so any tint they have is the tint of the light.
+
+- Fields:
+  - `Color`: Name of the cast (`red`, `green`, `blue`, or the complementary `cyan`, `magenta`, `yellow`), given by
+    the channel deviating the most from the gray of the highlights. Empty if no channel deviates by `castThreshold`.
+  - `Strength`: Deviation of that channel from the gray of the highlights, relative to it, e.g. 0.1 for 10%.
+  - `Gains`: Factors of the red, green and blue channels turning the highlights into their gray, i.e. the gains of a
+    gray-world white balance on the paper.
+
+### ColorStatistics
+Color statistics of an image: `Red`, `Green` and `Blue` channel statistics, and its `Cast`.
+
+---
+
+### ColorStats(img image.Image) ColorStatistics
+Computes the channel statistics and the color cast of `img`, in two passes over its pixels. Fully transparent pixels
+are counted as black. Fast on `*image.RGBA` images, which the pipeline produces.
+
+### (stats ColorStatistics) NeedsWhiteBalance() bool
+Tells whether the image has a color cast worth correcting with a white balance.
+
+### (stats ColorStatistics) GrayWorldGains() [3]float64
+Returns the gains of a gray-world white balance: the factors of the red, green and blue channels turning the mean
+color of the whole image into its gray, bounded by `maxGrayWorldGain`. Unlike the `Cast.Gains`, estimated on the
+paper only (a white-patch white balance), they also balance a document with no white margin.
+
+### WhiteBalance(img *image.RGBA, gains [3]float64)
+Multiplies the red, green and blue channels of every pixel of `img` by `gains`, in place, e.g. the `Cast.Gains` of
+its statistics to neutralize its color cast. The values are clamped to 255, the alpha channel is left unchanged.
+
+### (histogram Histogram) Percentile(percent float64) uint8
+Returns the smallest value of the histogram below or at which `percent` % of its pixels are, 0 if it counts no
+pixel.
+
+### channelStats(histogram Histogram) ChannelStats
+Summarizes the histogram of a channel.
+
+### eachPixel(img image.Image, visit func(r, g, b uint8))
+Calls `visit` with the 8-bit color of every pixel of `img`.
+
+---
+
+### Example Usage:
+```go
+stats := imageUtils.ColorStats(img)
+if stats.NeedsWhiteBalance() {
+	fmt.Printf("%s cast (%.0f%%)\n", stats.Cast.Color, 100*stats.Cast.Strength)
+}
+```
+*/
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	highlightShare   = 0.1
+	castThreshold    = 0.05
+	maxGrayWorldGain = 2
+)
+
+type ChannelStats struct {
+	Mean float64
+	P5   uint8
+	P50  uint8
+	P95  uint8
+}
+
+type ColorCast struct {
+	Color    string
+	Strength float64
+	Gains    [3]float64
+}
+
+type ColorStatistics struct {
+	Red   ChannelStats
+	Green ChannelStats
+	Blue  ChannelStats
+	Cast  ColorCast
+}
+
+var castColors = [3][2]string{
+	{"red", "cyan"},
+	{"green", "magenta"},
+	{"blue", "yellow"},
+}
+
+func ColorStats(img image.Image) ColorStatistics {
+	var red, green, blue, luminance Histogram
+	eachPixel(img, func(r, g, b uint8) {
+		red[r]++
+		green[g]++
+		blue[b]++
+		luminance[(299*int(r)+587*int(g)+114*int(b))/1000]++
+	})
+
+	stats := ColorStatistics{
+		Red:   channelStats(red),
+		Green: channelStats(green),
+		Blue:  channelStats(blue),
+		Cast:  ColorCast{Gains: [3]float64{1, 1, 1}},
+	}
+
+	threshold := int(luminance.Percentile(100 * (1 - highlightShare)))
+	var sums [3]float64
+	count := 0
+	eachPixel(img, func(r, g, b uint8) {
+		if (299*int(r)+587*int(g)+114*int(b))/1000 >= threshold {
+			sums[0] += float64(r)
+			sums[1] += float64(g)
+			sums[2] += float64(b)
+			count++
+		}
+	})
+	gray := (sums[0] + sums[1] + sums[2]) / 3
+	if count == 0 || gray == 0 {
+		return stats
+	}
+
+	for channel, sum := range sums {
+		deviation := (sum - gray) / gray
+		if sum > 0 {
+			stats.Cast.Gains[channel] = gray / sum
+		}
+		if math.Abs(deviation) <= stats.Cast.Strength {
+			continue
+		}
+		stats.Cast.Strength = math.Abs(deviation)
+		if deviation > 0 {
+			stats.Cast.Color = castColors[channel][0]
+		} else {
+			stats.Cast.Color = castColors[channel][1]
+		}
+	}
+	if stats.Cast.Strength < castThreshold {
+		stats.Cast.Color = ""
+	}
+	return stats
+}
+
+func (stats ColorStatistics) NeedsWhiteBalance() bool {
+	return stats.Cast.Color != ""
+}
+
+func (stats ColorStatistics) GrayWorldGains() [3]float64 {
+	means := [3]float64{stats.Red.Mean, stats.Green.Mean, stats.Blue.Mean}
+	gray := (means[0] + means[1] + means[2]) / 3
+
+	gains := [3]float64{1, 1, 1}
+	for channel, mean := range means {
+		if mean > 0 && gray > 0 {
+			gains[channel] = min(max(gray/mean, 1.0/maxGrayWorldGain), maxGrayWorldGain)
+		}
+	}
+	return gains
+}
+
+func WhiteBalance(img *image.RGBA, gains [3]float64) {
+	var tables [3][256]uint8
+	for channel, gain := range gains {
+		for value := range tables[channel] {
+			tables[channel][value] = uint8(min(math.Round(float64(value)*gain), 255))
+		}
+	}
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(bounds.Min.X, y):img.PixOffset(bounds.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			row[i] = tables[0][row[i]]
+			row[i+1] = tables[1][row[i+1]]
+			row[i+2] = tables[2][row[i+2]]
+		}
+	}
+}
+
+func (histogram Histogram) Percentile(percent float64) uint8 {
+	total := 0
+	for _, count := range histogram {
+		total += count
+	}
+
+	if total == 0 {
+		return 0
+	}
+
+	target := max(int(math.Ceil(percent/100*float64(total))), 1)
+	cumulated := 0
+	for value, count := range histogram {
+		cumulated += count
+		if cumulated >= target {
+			return uint8(value)
+		}
+	}
+	return 255
+}
+
+func channelStats(histogram Histogram) ChannelStats {
+	return ChannelStats{
+		Mean: histogram.Mean(),
+		P5:   histogram.Percentile(5),
+		P50:  histogram.Percentile(50),
+		P95:  histogram.Percentile(95),
+	}
+}
+
+func eachPixel(img image.Image, visit func(r, g, b uint8)) {
+	bounds := img.Bounds()
+	if rgba, ok := img.(*image.RGBA); ok {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			row := rgba.Pix[rgba.PixOffset(bounds.Min.X, y):rgba.PixOffset(bounds.Max.X, y)]
+			for i := 0; i < len(row); i += 4 {
+				visit(row[i], row[i+1], row[i+2])
+			}
+		}
+		return
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			visit(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package imageUtils
+
+/*
+This file tests the color statistics: the color cast estimated on the paper of a tinted page, and the gains of the
+white-patch and gray-world white balances that neutralize it.
+*/
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestWhiteBalanceGains(t *testing.T) {
+	// A yellowish page, a quarter of which is covered by a dark blue photo.
+	img := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			pixel := color.RGBA{R: 240, G: 230, B: 180, A: 255}
+			if x < 10 && y < 10 {
+				pixel = color.RGBA{R: 20, G: 40, B: 120, A: 255}
+			}
+			img.SetRGBA(x, y, pixel)
+		}
+	}
+
+	stats := ColorStats(img)
+	if stats.Cast.Color != "yellow" || !stats.NeedsWhiteBalance() {
+		t.Fatalf("cast is %q, expected yellow", stats.Cast.Color)
+	}
+
+	balanced := image.NewRGBA(img.Bounds())
+	copy(balanced.Pix, img.Pix)
+	WhiteBalance(balanced, stats.Cast.Gains)
+	if paper := balanced.RGBAAt(19, 19); paper.R != paper.G || paper.G != paper.B {
+		t.Errorf("paper is %v after the white-patch balance, expected gray", paper)
+	}
+
+	gains := stats.GrayWorldGains()
+	for channel, mean := range []float64{stats.Red.Mean, stats.Green.Mean, stats.Blue.Mean} {
+		gray := (stats.Red.Mean + stats.Green.Mean + stats.Blue.Mean) / 3
+		if balancedMean := mean * gains[channel]; math.Abs(balancedMean-gray) > 0.5 {
+			t.Errorf("channel %d has a mean of %.1f after the gray-world balance, expected %.1f", channel, balancedMean, gray)
+		}
+	}
+
+	black := ColorStats(image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	if gains := black.GrayWorldGains(); gains != [3]float64{1, 1, 1} {
+		t.Errorf("gray-world gains of a black image are %v, expected none", gains)
+	}
+}
@@ -0,0 +1,140 @@
+package imageUtils
+
+/*
+Package imageUtils provides a contact sheet generator, tiling the thumbnails of several images with their captions into
+one overview image, e.g. to check the results of a scanning session at a glance.
+
+---
+
+### Constants
+- `sheetMargin`: Space around the cells of a contact sheet, and between a thumbnail and its caption, in pixels.
+- `captionScaleDivisor`: The captions are drawn at the size of a cell divided by this, so they keep the same size
+  relative to the thumbnails.
+
+---
+
+### Tile
+A cell of a contact sheet.
+
+- Fields:
+  - `Image`: The image shown in the cell, scaled down to a thumbnail. A missing image (nil), e.g. for a failed scan,
+    is shown as a crossed-out gray box.
+  - `Caption`: Text written under the thumbnail, on one or more lines. The lines too long for the cell are cut and
+    end with `..`.
+
+---
+
+### Thumbnail(img image.Image, size int) *image.RGBA
+Scales `img` down to fit in a square of `size` pixels, keeping its aspect ratio, antialiased (see `Resize`) so the
+text of a scan stays readable. Images which already fit are copied at their size. Useful to keep only the thumbnails of large images until the sheet is drawn.
+
+### ContactSheet(tiles []Tile, columns int, cellSize int) *image.RGBA
+Tiles the thumbnails of `tiles` on a white sheet, `columns` per row (a square grid if 0), in cells of `cellSize`
+pixels. Every thumbnail is centered in its cell, above its caption. The captions of all the cells get as many
+lines as the longest one, so the rows stay aligned.
+
+### fitCaption(caption string, width int, scale int) string
+Cuts the lines of `caption` to the number of characters fitting in `width` pixels at `scale`.
+
+---
+
+### Example Usage:
+```go
+tiles := []imageUtils.Tile{
+	{Image: first, Caption: "scan_001.jpg"},
+	{Caption: "scan_002.jpg\nFAILED"},
+}
+sheet := imageUtils.ContactSheet(tiles, 0, 256)
+imageUtils.SaveImage(sheet, "session.png", "png")
+```
+*/
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+)
+
+const (
+	sheetMargin         = 16
+	captionScaleDivisor = 128
+)
+
+type Tile struct {
+	Image   image.Image
+	Caption string
+}
+
+func Thumbnail(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(height*size/max(width, 1), 1)
+		} else {
+			width, height = max(width*size/max(height, 1), 1), size
+		}
+	}
+	return Resize(img, width, height, Bilinear)
+}
+
+func ContactSheet(tiles []Tile, columns int, cellSize int) *image.RGBA {
+	if columns <= 0 {
+		columns = max(int(math.Ceil(math.Sqrt(float64(len(tiles))))), 1)
+	}
+	rows := (len(tiles) + columns - 1) / columns
+
+	scale := max(cellSize/captionScaleDivisor, 1)
+	captionLines := 0
+	captions := make([]string, len(tiles))
+	for i, tile := range tiles {
+		captions[i] = fitCaption(tile.Caption, cellSize, scale)
+		if tile.Caption != "" {
+			captionLines = max(captionLines, strings.Count(captions[i], "\n")+1)
+		}
+	}
+	captionHeight := 0
+	if captionLines > 0 {
+		captionHeight = TextSize(strings.Repeat("\n", captionLines-1), scale).Y + sheetMargin/2
+	}
+
+	cellWidth := cellSize + sheetMargin
+	cellHeight := cellSize + captionHeight + sheetMargin
+	sheet := image.NewRGBA(image.Rect(0, 0, columns*cellWidth+sheetMargin, rows*cellHeight+sheetMargin))
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	gray := color.RGBA{R: 200, G: 200, B: 200, A: 255}
+	dark := color.RGBA{R: 64, G: 64, B: 64, A: 255}
+	for i, tile := range tiles {
+		cell := image.Pt(sheetMargin+(i%columns)*cellWidth, sheetMargin+(i/columns)*cellHeight)
+
+		if tile.Image == nil {
+			box := image.Rectangle{Min: cell, Max: cell.Add(image.Pt(cellSize, cellSize))}
+			draw.Draw(sheet, box, image.NewUniform(gray), image.Point{}, draw.Src)
+			DrawLine(sheet, box.Min, box.Max.Sub(image.Pt(1, 1)), scale, dark)
+			DrawLine(sheet, image.Pt(box.Min.X, box.Max.Y-1), image.Pt(box.Max.X-1, box.Min.Y), scale, dark)
+		} else {
+			thumbnail := Thumbnail(tile.Image, cellSize)
+			size := thumbnail.Bounds().Size()
+			origin := cell.Add(image.Pt((cellSize-size.X)/2, (cellSize-size.Y)/2))
+			draw.Draw(sheet, image.Rectangle{Min: origin, Max: origin.Add(size)}, thumbnail, image.Point{}, draw.Src)
+		}
+
+		DrawText(sheet, captions[i], cell.Add(image.Pt(0, cellSize+sheetMargin/2)), scale, color.Black)
+	}
+
+	return sheet
+}
+
+func fitCaption(caption string, width int, scale int) string {
+	limit := max((width/scale+1)/glyphAdvance, 1)
+	lines := strings.Split(caption, "\n")
+	for i, line := range lines {
+		if runes := []rune(line); len(runes) > limit {
+			lines[i] = string(runes[:max(limit-2, 0)]) + ".."
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,120 @@
+package imageUtils
+
+/*
+Package imageUtils provides the despeckle of a binarized page: the binarization of a scan (see `ScanDocument`) turns
+the dust, the grain of the paper and the noise of the photo into isolated black specks, and pierces the thick
+strokes of the print and the filled figures with white pinholes. The specks are the connected components of black
+pixels smaller than a size, the pinholes those of white pixels, and each is flipped to the color around it.
+
+---
+
+### Constants
+- `speckThreshold`: Gray level under which a pixel is black, the middle of the levels.
+
+---
+
+### Despeckle(img *image.RGBA, speckSize, pinholeSize int) (int, int)
+Removes the black specks of fewer than `speckSize` pixels from `img`, a black and white page, in place, then fills
+its white pinholes of fewer than `pinholeSize` pixels, and returns the number of specks and pinholes flipped. A size
+of 1 or less keeps them all.
+
+- **Behavior**:
+  - The black pixels are connected by their 8 neighbors, the white ones by their 4 neighbors, so a diagonal stroke
+    of the print is one component, and the white on both sides of it two.
+  - The white components touching the border of the page are its paper, never pinholes.
+  - The pinholes are found once the specks are removed, so the white left by a removed speck joins the paper around
+    it.
+
+### flipComponents(img *image.RGBA, black []bool, color bool, size int) int
+Flips to the opposite color the components of the pixels of `img` whose value in `black`, one per pixel row by row,
+is `color`, and which have fewer than `size` pixels, and returns their number. `black` is updated with them.
+
+---
+
+### Example Usage:
+```go
+scan := imageUtils.ScanDocument(croppedPage, true)
+specks, pinholes := imageUtils.Despeckle(scan, 8, 8)
+log.Printf("Removed %d specks and filled %d pinholes", specks, pinholes)
+```
+*/
+
+import (
+	"image"
+)
+
+const speckThreshold = 128
+
+func Despeckle(img *image.RGBA, speckSize, pinholeSize int) (int, int) {
+	bounds := img.Bounds()
+	width := bounds.Dx()
+	black := make([]bool, width*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			offset := img.PixOffset(x, y)
+			black[(y-bounds.Min.Y)*width+x-bounds.Min.X] = luminance(img.Pix[offset:offset+3]) < speckThreshold
+		}
+	}
+
+	specks := flipComponents(img, black, true, speckSize)
+	pinholes := flipComponents(img, black, false, pinholeSize)
+	return specks, pinholes
+}
+
+func flipComponents(img *image.RGBA, black []bool, color bool, size int) int {
+	if size <= 1 {
+		return 0
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	neighbors := []image.Point{{-1, 0}, {1, 0}, {0, -1}, {0, 1}}
+	if color {
+		neighbors = append(neighbors, image.Point{-1, -1}, image.Point{1, -1}, image.Point{-1, 1}, image.Point{1, 1})
+	}
+
+	visited := make([]bool, len(black))
+	var stack, component []int
+	flipped := 0
+	for start := range black {
+		if visited[start] || black[start] != color {
+			continue
+		}
+		visited[start] = true
+		stack, component = append(stack[:0], start), component[:0]
+		border := false
+		for len(stack) > 0 {
+			pixel := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(component) < size {
+				component = append(component, pixel)
+			}
+			x, y := pixel%width, pixel/width
+			border = border || x == 0 || y == 0 || x == width-1 || y == height-1
+			for _, step := range neighbors {
+				nx, ny := x+step.X, y+step.Y
+				if nx < 0 || nx >= width || ny < 0 || ny >= height {
+					continue
+				}
+				if neighbor := ny*width + nx; !visited[neighbor] && black[neighbor] == color {
+					visited[neighbor] = true
+					stack = append(stack, neighbor)
+				}
+			}
+		}
+		if len(component) >= size || (!color && border) {
+			continue
+		}
+
+		var value uint8
+		if color {
+			value = 255
+		}
+		for _, pixel := range component {
+			black[pixel] = !color
+			offset := img.PixOffset(bounds.Min.X+pixel%width, bounds.Min.Y+pixel/width)
+			img.Pix[offset], img.Pix[offset+1], img.Pix[offset+2] = value, value, value
+		}
+		flipped++
+	}
+	return flipped
+}
@@ -0,0 +1,44 @@
+package imageUtils
+
+/*
+This file tests the despeckle of a black and white page: a speck of dust must be removed and a pinhole of a filled
+figure filled, while the strokes of the print and the paper around the figure are kept.
+*/
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func speckledPage() *image.RGBA {
+	page := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	black := image.NewUniform(color.Black)
+	draw.Draw(page, page.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(page, image.Rect(10, 10, 60, 13), black, image.Point{}, draw.Src)
+	draw.Draw(page, image.Rect(80, 80, 82, 82), black, image.Point{}, draw.Src)
+	draw.Draw(page, image.Rect(20, 40, 50, 70), black, image.Point{}, draw.Src)
+	draw.Draw(page, image.Rect(30, 50, 32, 51), image.NewUniform(color.White), image.Point{}, draw.Src)
+	return page
+}
+
+func TestDespeckle(t *testing.T) {
+	page := speckledPage()
+	if specks, pinholes := Despeckle(page, 8, 8); specks != 1 || pinholes != 1 {
+		t.Errorf("%d specks and %d pinholes flipped, expected 1 of each", specks, pinholes)
+	}
+	for _, check := range []struct {
+		point image.Point
+		value uint8
+	}{{image.Pt(80, 80), 255}, {image.Pt(30, 50), 0}, {image.Pt(35, 11), 0}, {image.Pt(25, 45), 0}, {image.Pt(5, 5), 255}} {
+		if value := page.RGBAAt(check.point.X, check.point.Y).R; value != check.value {
+			t.Errorf("pixel at %v is %d once despeckled, expected %d", check.point, value, check.value)
+		}
+	}
+
+	untouched := speckledPage()
+	if specks, pinholes := Despeckle(untouched, 0, 1); specks != 0 || pinholes != 0 || untouched.RGBAAt(80, 80).R != 0 {
+		t.Errorf("%d specks and %d pinholes flipped with sizes of 0 and 1, expected none", specks, pinholes)
+	}
+}
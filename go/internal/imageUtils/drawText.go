@@ -0,0 +1,199 @@
+package imageUtils
+
+/*
+Package imageUtils provides a bitmap font to write labels and stamps on images, without any font file, e.g. to
+annotate the debug overlays of the pipeline, stamp the documents or caption the thumbnails of a contact sheet.
+
+---
+
+### Font
+The glyphs are 5x7 pixel bitmaps, drawn on a grid of `glyphAdvance` x `lineAdvance` pixels so the characters and the
+lines of a text are spaced. The font covers the digits, the uppercase letters and the usual punctuation: lowercase
+letters are drawn in uppercase, and the other characters as `?`. Every pixel of a glyph is drawn as a square of
+`scale` x `scale` pixels.
+
+---
+
+### TextSize(text string, scale int) image.Point
+Returns the size in pixels of `text` drawn at `scale`: the width of its longest line, and the height of its lines.
+A `scale` below 1 counts as 1.
+
+### DrawText(img draw.Image, text string, origin image.Point, scale int, c color.Color)
+Draws `text` on `img` with its top left corner at `origin`, in color `c` blended over the image, so a translucent
+color gives a translucent text. `\n` starts a new line. The pixels falling out of the image are ignored.
+
+### DrawLabel(img draw.Image, text string, origin image.Point, scale int, foreground, background color.Color) image.Rectangle
+Draws `text` like `DrawText` over a box filled with `background`, one font pixel larger than the text on every side,
+so the label stays readable over any image. The box has its top left corner at `origin`. Returns the box, e.g. to
+place the next label below it.
+
+### LabelScale(bounds image.Rectangle) int
+Returns the scale at which labels are drawn on an image of `bounds`: one image pixel per font pixel up to
+`labelScaleDivisor` pixels on the smaller side, and larger on larger images, so the labels stay readable once the
+image is shown at the size of a screen.
+
+### textMask(text string, scale int) *image.Alpha
+Renders `text` as an opaque mask whose bounds start at (0, 0), used to blend the text in a single pass.
+
+### glyph(r rune) [glyphHeight]uint8
+Returns the bitmap of a character, one byte per row with the leftmost pixel in the highest of its `glyphWidth` bits.
+
+---
+
+### Example Usage:
+```go
+img := image.NewRGBA(image.Rect(0, 0, 200, 50))
+imageUtils.DrawText(img, "COPY 2026-10-17", image.Pt(10, 10), 2, color.RGBA{R: 200, A: 255})
+
+box := imageUtils.DrawLabel(img, "scan.jpg", image.Pt(0, 0), imageUtils.LabelScale(img.Bounds()), color.White, color.Black)
+```
+*/
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"unicode"
+)
+
+const (
+	glyphWidth   = 5
+	glyphHeight  = 7
+	glyphAdvance = glyphWidth + 1
+	lineAdvance  = glyphHeight + 2
+
+	labelScaleDivisor = 400
+)
+
+var font = map[rune][glyphHeight]uint8{
+	' ':  {},
+	'0':  {0b01110, 0b10001, 0b10011, 0b10101, 0b11001, 0b10001, 0b01110},
+	'1':  {0b00100, 0b01100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'2':  {0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0b01000, 0b11111},
+	'3':  {0b11111, 0b00010, 0b00100, 0b00010, 0b00001, 0b10001, 0b01110},
+	'4':  {0b00010, 0b00110, 0b01010, 0b10010, 0b11111, 0b00010, 0b00010},
+	'5':  {0b11111, 0b10000, 0b11110, 0b00001, 0b00001, 0b10001, 0b01110},
+	'6':  {0b00110, 0b01000, 0b10000, 0b11110, 0b10001, 0b10001, 0b01110},
+	'7':  {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b01000, 0b01000},
+	'8':  {0b01110, 0b10001, 0b10001, 0b01110, 0b10001, 0b10001, 0b01110},
+	'9':  {0b01110, 0b10001, 0b10001, 0b01111, 0b00001, 0b00010, 0b01100},
+	'A':  {0b01110, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001, 0b10001},
+	'B':  {0b11110, 0b10001, 0b10001, 0b11110, 0b10001, 0b10001, 0b11110},
+	'C':  {0b01110, 0b10001, 0b10000, 0b10000, 0b10000, 0b10001, 0b01110},
+	'D':  {0b11100, 0b10010, 0b10001, 0b10001, 0b10001, 0b10010, 0b11100},
+	'E':  {0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b11111},
+	'F':  {0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b10000},
+	'G':  {0b01110, 0b10001, 0b10000, 0b10111, 0b10001, 0b10001, 0b01111},
+	'H':  {0b10001, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001, 0b10001},
+	'I':  {0b01110, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'J':  {0b00111, 0b00010, 0b00010, 0b00010, 0b00010, 0b10010, 0b01100},
+	'K':  {0b10001, 0b10010, 0b10100, 0b11000, 0b10100, 0b10010, 0b10001},
+	'L':  {0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b11111},
+	'M':  {0b10001, 0b11011, 0b10101, 0b10101, 0b10001, 0b10001, 0b10001},
+	'N':  {0b10001, 0b10001, 0b11001, 0b10101, 0b10011, 0b10001, 0b10001},
+	'O':  {0b01110, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110},
+	'P':  {0b11110, 0b10001, 0b10001, 0b11110, 0b10000, 0b10000, 0b10000},
+	'Q':  {0b01110, 0b10001, 0b10001, 0b10001, 0b10101, 0b10010, 0b01101},
+	'R':  {0b11110, 0b10001, 0b10001, 0b11110, 0b10100, 0b10010, 0b10001},
+	'S':  {0b01111, 0b10000, 0b10000, 0b01110, 0b00001, 0b00001, 0b11110},
+	'T':  {0b11111, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100},
+	'U':  {0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110},
+	'V':  {0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01010, 0b00100},
+	'W':  {0b10001, 0b10001, 0b10001, 0b10101, 0b10101, 0b10101, 0b01010},
+	'X':  {0b10001, 0b10001, 0b01010, 0b00100, 0b01010, 0b10001, 0b10001},
+	'Y':  {0b10001, 0b10001, 0b10001, 0b01010, 0b00100, 0b00100, 0b00100},
+	'Z':  {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b10000, 0b11111},
+	'.':  {0, 0, 0, 0, 0, 0b01100, 0b01100},
+	',':  {0, 0, 0, 0, 0b01100, 0b00100, 0b01000},
+	':':  {0, 0b01100, 0b01100, 0, 0b01100, 0b01100, 0},
+	';':  {0, 0b01100, 0b01100, 0, 0b01100, 0b00100, 0b01000},
+	'-':  {0, 0, 0, 0b11111, 0, 0, 0},
+	'_':  {0, 0, 0, 0, 0, 0, 0b11111},
+	'/':  {0, 0b00001, 0b00010, 0b00100, 0b01000, 0b10000, 0},
+	'(':  {0b00010, 0b00100, 0b01000, 0b01000, 0b01000, 0b00100, 0b00010},
+	')':  {0b01000, 0b00100, 0b00010, 0b00010, 0b00010, 0b00100, 0b01000},
+	'[':  {0b01110, 0b01000, 0b01000, 0b01000, 0b01000, 0b01000, 0b01110},
+	']':  {0b01110, 0b00010, 0b00010, 0b00010, 0b00010, 0b00010, 0b01110},
+	'<':  {0b00010, 0b00100, 0b01000, 0b10000, 0b01000, 0b00100, 0b00010},
+	'>':  {0b01000, 0b00100, 0b00010, 0b00001, 0b00010, 0b00100, 0b01000},
+	'#':  {0b01010, 0b01010, 0b11111, 0b01010, 0b11111, 0b01010, 0b01010},
+	'!':  {0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0, 0b00100},
+	'?':  {0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0, 0b00100},
+	'\'': {0b00100, 0b00100, 0b01000, 0, 0, 0, 0},
+	'"':  {0b01010, 0b01010, 0, 0, 0, 0, 0},
+	'+':  {0, 0b00100, 0b00100, 0b11111, 0b00100, 0b00100, 0},
+	'=':  {0, 0, 0b11111, 0, 0b11111, 0, 0},
+	'*':  {0, 0b00100, 0b10101, 0b01110, 0b10101, 0b00100, 0},
+	'%':  {0b11000, 0b11001, 0b00010, 0b00100, 0b01000, 0b10011, 0b00011},
+	'&':  {0b01100, 0b10010, 0b10100, 0b01000, 0b10101, 0b10010, 0b01101},
+	'@':  {0b01110, 0b10001, 0b00001, 0b01101, 0b10101, 0b10101, 0b01110},
+}
+
+func TextSize(text string, scale int) image.Point {
+	scale = max(scale, 1)
+	lines := strings.Split(text, "\n")
+
+	width := 0
+	for _, line := range lines {
+		if n := len([]rune(line)); n > 0 {
+			width = max(width, n*glyphAdvance-1)
+		}
+	}
+	height := len(lines)*lineAdvance - (lineAdvance - glyphHeight)
+
+	return image.Pt(width*scale, height*scale)
+}
+
+func DrawText(img draw.Image, text string, origin image.Point, scale int, c color.Color) {
+	mask := textMask(text, scale)
+	rect := mask.Bounds().Add(origin)
+	draw.DrawMask(img, rect, image.NewUniform(c), image.Point{}, mask, image.Point{}, draw.Over)
+}
+
+func DrawLabel(img draw.Image, text string, origin image.Point, scale int, foreground, background color.Color) image.Rectangle {
+	scale = max(scale, 1)
+	size := TextSize(text, scale)
+	box := image.Rectangle{Min: origin, Max: origin.Add(size).Add(image.Pt(2*scale, 2*scale))}
+
+	draw.Draw(img, box, image.NewUniform(background), image.Point{}, draw.Over)
+	DrawText(img, text, origin.Add(image.Pt(scale, scale)), scale, foreground)
+	return box
+}
+
+func LabelScale(bounds image.Rectangle) int {
+	return max(min(bounds.Dx(), bounds.Dy())/labelScaleDivisor, 1)
+}
+
+func textMask(text string, scale int) *image.Alpha {
+	scale = max(scale, 1)
+	size := TextSize(text, scale)
+	mask := image.NewAlpha(image.Rect(0, 0, size.X, size.Y))
+	opaque := image.NewUniform(color.Alpha{A: 255})
+
+	for row, line := range strings.Split(text, "\n") {
+		for column, r := range []rune(line) {
+			bitmap := glyph(r)
+			left := column * glyphAdvance * scale
+			top := row * lineAdvance * scale
+			for y := 0; y < glyphHeight; y++ {
+				for x := 0; x < glyphWidth; x++ {
+					if bitmap[y]&(1<<(glyphWidth-1-x)) == 0 {
+						continue
+					}
+					pixel := image.Rect(left+x*scale, top+y*scale, left+(x+1)*scale, top+(y+1)*scale)
+					draw.Draw(mask, pixel, opaque, image.Point{}, draw.Src)
+				}
+			}
+		}
+	}
+
+	return mask
+}
+
+func glyph(r rune) [glyphHeight]uint8 {
+	if bitmap, ok := font[unicode.ToUpper(r)]; ok {
+		return bitmap
+	}
+	return font['?']
+}
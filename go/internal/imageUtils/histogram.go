@@ -0,0 +1,202 @@
+package imageUtils
+
+/*
+Package imageUtils provides histograms of grayscale images, and renders them as small charts, e.g. to show the
+luminance of a photo and the gradients the edge detection thresholds are computed from.
+
+---
+
+### Constants
+- `histogramBinWidth`: Width of the bar of every value of a chart, in pixels.
+- `histogramPlotHeight`: Height of the bars of the most frequent value, in pixels.
+- `histogramMargin`: Space around the plot of a chart and between its texts, in pixels.
+- `histogramTextScale`: Scale of the texts of a chart (see `DrawText`).
+
+---
+
+### Histogram
+Number of pixels of every value of a grayscale image, from 0 to 255.
+
+### Marker
+A value highlighted on a chart, e.g. a threshold, by a vertical line and a label at the top of the plot.
+
+- Fields:
+  - `Value`: Position of the line, in the units of the histogram (0 to 255).
+  - `Label`: Text written next to the line.
+  - `Color`: Color of the line and of the label.
+
+---
+
+### GrayHistogram(img *image.Gray) Histogram
+Counts the pixels of every value of `img`.
+
+### (histogram Histogram) Mean() float64
+Returns the mean value of the pixels counted by `histogram`, 0 if there is none.
+
+### (histogram Histogram) Otsu() uint8
+Returns the threshold of Otsu's method: the value splitting the pixels counted by `histogram` into the two classes of
+the largest variance between them, the values up to the threshold and those above it. Of the thresholds of the same
+variance, e.g. any value between the two levels of a binary image, the lowest is returned. 0 if the pixels all have
+the same value, or if there is none: all the nonzero pixels are above it.
+
+### DrawHistogram(histogram Histogram, title string, markers []Marker) *image.RGBA
+Renders `histogram` as a bar chart titled `title`, with the values on the horizontal axis and the number of pixels on
+a logarithmic vertical axis, so the rare values (e.g. the few strong gradients of the edges of a document) stay
+visible next to the frequent ones. The labels of the markers are stacked from the top of the plot, in order, and
+written on the left of their line if there is no room on its right.
+
+### StackImages(images ...image.Image) *image.RGBA
+Lays `images` out one below the other, left-aligned on a white background, e.g. to send several charts as one image.
+
+---
+
+### Example Usage:
+```go
+histogram := imageUtils.GrayHistogram(gray)
+chart := imageUtils.DrawHistogram(histogram, "LUMINANCE", []imageUtils.Marker{
+	{Value: histogram.Mean(), Label: "MEAN", Color: color.RGBA{B: 255, A: 255}},
+})
+imageUtils.SaveImage(chart, "luminance.png", "png")
+```
+*/
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+)
+
+const (
+	histogramBinWidth   = 2
+	histogramPlotHeight = 160
+	histogramMargin     = 12
+	histogramTextScale  = 2
+)
+
+type Histogram [256]int
+
+type Marker struct {
+	Value float64
+	Label string
+	Color color.Color
+}
+
+func GrayHistogram(img *image.Gray) Histogram {
+	var histogram Histogram
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for _, value := range img.Pix[img.PixOffset(bounds.Min.X, y):img.PixOffset(bounds.Max.X, y)] {
+			histogram[value]++
+		}
+	}
+	return histogram
+}
+
+func (histogram Histogram) Otsu() uint8 {
+	total, sum := 0, 0
+	for value, count := range histogram {
+		total += count
+		sum += value * count
+	}
+
+	var threshold uint8
+	best := -1.0
+	lowCount, lowSum := 0, 0
+	for value := 0; value < 255; value++ {
+		lowCount += histogram[value]
+		lowSum += value * histogram[value]
+		highCount := total - lowCount
+		if lowCount == 0 || highCount == 0 {
+			continue
+		}
+		lowMean := float64(lowSum) / float64(lowCount)
+		highMean := float64(sum-lowSum) / float64(highCount)
+		variance := float64(lowCount) * float64(highCount) * (highMean - lowMean) * (highMean - lowMean)
+		if variance > best {
+			threshold, best = uint8(value), variance
+		}
+	}
+	return threshold
+}
+
+func (histogram Histogram) Mean() float64 {
+	total, sum := 0, 0
+	for value, count := range histogram {
+		total += count
+		sum += value * count
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(sum) / float64(total)
+}
+
+func DrawHistogram(histogram Histogram, title string, markers []Marker) *image.RGBA {
+	textHeight := TextSize("0", histogramTextScale).Y
+	plot := image.Rect(histogramMargin, 2*histogramMargin+textHeight,
+		histogramMargin+len(histogram)*histogramBinWidth, 2*histogramMargin+textHeight+histogramPlotHeight)
+	chart := image.NewRGBA(image.Rect(0, 0, plot.Max.X+histogramMargin, plot.Max.Y+2*histogramMargin+textHeight))
+
+	draw.Draw(chart, chart.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(chart, plot, image.NewUniform(color.RGBA{R: 240, G: 240, B: 240, A: 255}), image.Point{}, draw.Src)
+	DrawText(chart, title, image.Pt(histogramMargin, histogramMargin), histogramTextScale, color.Black)
+
+	largest := 0
+	for _, count := range histogram {
+		largest = max(largest, count)
+	}
+	bar := image.NewUniform(color.RGBA{R: 48, G: 64, B: 96, A: 255})
+	for value, count := range histogram {
+		if count == 0 {
+			continue
+		}
+		height := max(int(math.Round(math.Log1p(float64(count))/math.Log1p(float64(largest))*histogramPlotHeight)), 1)
+		left := plot.Min.X + value*histogramBinWidth
+		draw.Draw(chart, image.Rect(left, plot.Max.Y-height, left+histogramBinWidth, plot.Max.Y), bar, image.Point{}, draw.Src)
+	}
+
+	for _, value := range []int{0, 64, 128, 192, 255} {
+		x := plot.Min.X + value*histogramBinWidth + histogramBinWidth/2
+		DrawLine(chart, image.Pt(x, plot.Max.Y), image.Pt(x, plot.Max.Y+histogramMargin/3), 1, color.Black)
+		label := strconv.Itoa(value)
+		left := min(max(x-TextSize(label, histogramTextScale).X/2, 0), chart.Bounds().Max.X-TextSize(label, histogramTextScale).X)
+		DrawText(chart, label, image.Pt(left, plot.Max.Y+histogramMargin/2), histogramTextScale, color.Black)
+	}
+
+	top := plot.Min.Y + histogramTextScale
+	for _, marker := range markers {
+		x := plot.Min.X + int(marker.Value*histogramBinWidth) + histogramBinWidth/2
+		x = min(max(x, plot.Min.X), plot.Max.X-1)
+		DrawLine(chart, image.Pt(x, plot.Min.Y), image.Pt(x, plot.Max.Y-1), 1, marker.Color)
+
+		size := TextSize(marker.Label, histogramTextScale).Add(image.Pt(2*histogramTextScale, 2*histogramTextScale))
+		left := x + histogramTextScale
+		if left+size.X > plot.Max.X {
+			left = x - histogramTextScale - size.X
+		}
+		box := DrawLabel(chart, marker.Label, image.Pt(left, top), histogramTextScale, marker.Color, color.White)
+		top = box.Max.Y + histogramTextScale
+	}
+
+	return chart
+}
+
+func StackImages(images ...image.Image) *image.RGBA {
+	width, height := 0, 0
+	for _, img := range images {
+		width = max(width, img.Bounds().Dx())
+		height += img.Bounds().Dy()
+	}
+
+	stacked := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(stacked, stacked.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	top := 0
+	for _, img := range images {
+		bounds := img.Bounds()
+		draw.Draw(stacked, image.Rect(0, top, bounds.Dx(), top+bounds.Dy()), img, bounds.Min, draw.Src)
+		top += bounds.Dy()
+	}
+	return stacked
+}
@@ -0,0 +1,706 @@
+package imageUtils
+
+/*
+Package imageUtils provides the lossless crop of a JPEG image: the blocks of DCT coefficients inside the crop are
+copied as they are into a new JPEG stream, without decoding them to pixels and encoding them again, so the crop loses
+no quality and costs a fraction of a decode and an encode. Only the entropy coding is redone: the DC coefficients are
+coded as differences from the previous block, which changes at the border of the crop, and the Huffman tables are
+optimized for the blocks kept.
+
+The blocks are those of an MCU (minimum coded unit), 16x16 pixels for the common 4:2:0 color JPEG, 8x8 for a
+grayscale one: the crop starts on the grid of the MCUs, so its top left corner is moved up and left onto it, by up to
+an MCU less a pixel. Its right and bottom sides are kept, the MCUs they cut being padding for the decoders.
+
+Only the baseline and extended sequential JPEG images of 8-bit samples coded in a single scan, the output of cameras
+and of most encoders, can be cropped losslessly. The progressive ones, those coded arithmetically or in several scans
+fail with `ErrLosslessCrop`, and are cropped by decoding them.
+
+---
+
+### Constants
+- `blockSize`: Number of coefficients of a block, 8x8.
+
+---
+
+### ErrLosslessCrop
+Error of an image that cannot be cropped losslessly: not a sequential 8-bit JPEG coded in a single scan.
+
+### JPEGCropBounds(data []byte, rect image.Rectangle) (image.Rectangle, error)
+Returns the part of the JPEG image `data` cropped by `CropJPEG` for `rect`: `rect` within the image, its top left
+corner moved onto the grid of the MCUs. Only the header of the image is read.
+
+### CropJPEG(data []byte, rect image.Rectangle) ([]byte, error)
+Crops the JPEG image `data` losslessly to `rect`, extended up and left to the grid of the MCUs (see
+`JPEGCropBounds`). The result is a JPEG image whose bounds start at (0, 0), keeping the metadata segments of the
+image (EXIF, ICC profile, comments) and its quantization tables, without restart markers.
+
+---
+
+### `parseJPEG(data []byte) (*jpegStream, error)`
+Reads the segments of a JPEG image up to its scan, and finds the end of its entropy-coded data.
+
+### `(stream *jpegStream) decodeBlocks(units image.Rectangle) ([]int16, error)`
+Decodes the coefficients of the blocks of the MCUs of `units`, a rectangle of the grid of the MCUs, in the order they
+are coded in.
+
+### `(stream *jpegStream) walkBlocks(blocks []int16, emit func(component jpegComponent, symbol byte, dc bool, value int32))`
+Calls `emit` with the symbols coding `blocks` (see `blockSymbols`), decoded by `decodeBlocks`, and their component.
+
+### `blockSymbols(block []int16, previous int16, emit func(symbol byte, dc bool, value int32))`
+Calls `emit` with the Huffman symbols coding `block`, and the value of the additional bits following each one: the
+category of the difference of its DC coefficient from that of `previous`, then the run and size of its non-zero AC
+coefficients, an end of block (0x00) after the last one.
+
+### `optimalHuffman(frequencies *[256]int) huffmanSpec`
+Returns the Huffman table coding the symbols of `frequencies` in the fewest bits, with codes of at most 16 bits, as in
+Annex K.2 of the JPEG standard.
+
+---
+
+### Example Usage:
+```go
+bounds, err := imageUtils.JPEGCropBounds(data, image.Rect(120, 85, 1980, 2710))
+if errors.Is(err, imageUtils.ErrLosslessCrop) {
+    // Progressive JPEG: decode, crop and encode the image instead.
+}
+cropped, err := imageUtils.CropJPEG(data, bounds)
+```
+*/
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"math/bits"
+)
+
+const blockSize = 64
+
+var ErrLosslessCrop = errors.New("the image cannot be cropped losslessly")
+
+type jpegComponent struct {
+	id         byte
+	horizontal int
+	vertical   int
+	quantTable byte
+	dcTable    int
+	acTable    int
+}
+
+type jpegStream struct {
+	marker     byte
+	width      int
+	height     int
+	components []jpegComponent
+	maxH, maxV int
+	restart    int
+	segments   [][]byte
+	dc, ac     [4]*huffmanDecoder
+	entropy    []byte
+}
+
+type huffmanSpec struct {
+	counts [16]byte
+	values []byte
+}
+
+type huffmanDecoder struct {
+	maxCode [17]int32
+	offset  [17]int32
+	values  []byte
+}
+
+type huffmanEncoder struct {
+	codes   [256]uint32
+	lengths [256]uint8
+}
+
+type bitReader struct {
+	data   []byte
+	pos    int
+	bits   uint32
+	count  int
+	marker bool
+}
+
+type bitWriter struct {
+	buffer *bytes.Buffer
+	bits   uint32
+	count  int
+}
+
+func JPEGCropBounds(data []byte, rect image.Rectangle) (image.Rectangle, error) {
+	stream, err := parseJPEG(data)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	return stream.cropBounds(rect)
+}
+
+func CropJPEG(data []byte, rect image.Rectangle) ([]byte, error) {
+	stream, err := parseJPEG(data)
+	if err != nil {
+		return nil, err
+	}
+	bounds, err := stream.cropBounds(rect)
+	if err != nil {
+		return nil, err
+	}
+
+	unitWidth, unitHeight := stream.unitSize()
+	units := image.Rect(bounds.Min.X/unitWidth, bounds.Min.Y/unitHeight,
+		(bounds.Max.X+unitWidth-1)/unitWidth, (bounds.Max.Y+unitHeight-1)/unitHeight)
+	blocks, err := stream.decodeBlocks(units)
+	if err != nil {
+		return nil, err
+	}
+
+	// The Huffman tables are optimized for the blocks kept, whose DC differences may use categories the tables of
+	// the image lack.
+	var dcFrequencies, acFrequencies [4][256]int
+	stream.walkBlocks(blocks, func(component jpegComponent, symbol byte, dc bool, _ int32) {
+		if dc {
+			dcFrequencies[component.dcTable][symbol]++
+		} else {
+			acFrequencies[component.acTable][symbol]++
+		}
+	})
+
+	var output bytes.Buffer
+	output.Grow(len(data))
+	output.Write([]byte{0xff, 0xd8})
+	for _, segment := range stream.segments {
+		output.Write(segment)
+	}
+	stream.writeFrame(&output, bounds.Size())
+
+	var dcEncoders, acEncoders [4]*huffmanEncoder
+	var tables bytes.Buffer
+	for class, frequencies := range [2]*[4][256]int{&dcFrequencies, &acFrequencies} {
+		for table := range frequencies {
+			if frequencies[table] == [256]int{} {
+				continue
+			}
+			spec := optimalHuffman(&frequencies[table])
+			tables.WriteByte(byte(class<<4 | table))
+			tables.Write(spec.counts[:])
+			tables.Write(spec.values)
+			if class == 0 {
+				dcEncoders[table] = spec.encoder()
+			} else {
+				acEncoders[table] = spec.encoder()
+			}
+		}
+	}
+	writeSegment(&output, 0xc4, tables.Bytes())
+
+	scanHeader := []byte{byte(len(stream.components))}
+	for _, component := range stream.components {
+		scanHeader = append(scanHeader, component.id, byte(component.dcTable<<4|component.acTable))
+	}
+	writeSegment(&output, 0xda, append(scanHeader, 0, 63, 0))
+
+	writer := bitWriter{buffer: &output}
+	stream.walkBlocks(blocks, func(component jpegComponent, symbol byte, dc bool, value int32) {
+		encoder := acEncoders[component.acTable]
+		if dc {
+			encoder = dcEncoders[component.dcTable]
+		}
+		writer.write(encoder.codes[symbol], int(encoder.lengths[symbol]))
+		if size := int(symbol & 0x0f); size > 0 {
+			if value < 0 {
+				value--
+			}
+			writer.write(uint32(value)&(1<<size-1), size)
+		}
+	})
+	writer.flush()
+	output.Write([]byte{0xff, 0xd9})
+	return output.Bytes(), nil
+}
+
+func parseJPEG(data []byte) (*jpegStream, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, fmt.Errorf("%w: not a JPEG image", ErrLosslessCrop)
+	}
+	stream := &jpegStream{}
+	pos := 2
+	for {
+		for pos < len(data) && data[pos] == 0xff && pos+1 < len(data) && data[pos+1] == 0xff {
+			pos++
+		}
+		if pos+4 > len(data) || data[pos] != 0xff {
+			return nil, errors.New("truncated JPEG image")
+		}
+		marker := data[pos+1]
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, errors.New("truncated JPEG segment")
+		}
+		segment, payload := data[pos:pos+2+length], data[pos+4:pos+2+length]
+		pos += 2 + length
+
+		switch {
+		case marker == 0xc0 || marker == 0xc1:
+			if err := stream.parseFrame(marker, payload); err != nil {
+				return nil, err
+			}
+		case marker >= 0xc2 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc:
+			return nil, fmt.Errorf("%w: progressive, lossless or arithmetic-coded JPEG (SOF%d)", ErrLosslessCrop, marker-0xc0)
+		case marker == 0xc4:
+			if err := stream.parseHuffman(payload); err != nil {
+				return nil, err
+			}
+		case marker == 0xdd:
+			if len(payload) < 2 {
+				return nil, errors.New("invalid restart interval")
+			}
+			stream.restart = int(binary.BigEndian.Uint16(payload))
+		case marker == 0xda:
+			if err := stream.parseScan(payload); err != nil {
+				return nil, err
+			}
+			end := pos
+			for end+1 < len(data) && (data[end] != 0xff || data[end+1] == 0 || data[end+1] >= 0xd0 && data[end+1] <= 0xd7) {
+				end++
+			}
+			stream.entropy = data[pos:end]
+			next := end + 1
+			for next < len(data) && data[next] == 0xff {
+				next++
+			}
+			// A second scan codes more components, or refines the coefficients of the first one.
+			if next < len(data) && data[next] != 0xd9 {
+				return nil, fmt.Errorf("%w: JPEG coded in several scans", ErrLosslessCrop)
+			}
+			return stream, nil
+		case marker >= 0xe0 && marker <= 0xef || marker == 0xfe || marker == 0xdb:
+			stream.segments = append(stream.segments, segment)
+		default:
+			return nil, fmt.Errorf("%w: unexpected JPEG marker 0x%02x", ErrLosslessCrop, marker)
+		}
+	}
+}
+
+func (stream *jpegStream) parseFrame(marker byte, payload []byte) error {
+	if stream.components != nil {
+		return errors.New("several JPEG frames")
+	}
+	if len(payload) < 6 {
+		return errors.New("invalid JPEG frame")
+	}
+	if payload[0] != 8 {
+		return fmt.Errorf("%w: %d-bit JPEG", ErrLosslessCrop, payload[0])
+	}
+	stream.marker = marker
+	stream.height = int(binary.BigEndian.Uint16(payload[1:]))
+	stream.width = int(binary.BigEndian.Uint16(payload[3:]))
+	count := int(payload[5])
+	if stream.width == 0 || stream.height == 0 || count == 0 || count > 4 || len(payload) < 6+3*count {
+		return errors.New("invalid JPEG frame")
+	}
+	for i := range count {
+		fields := payload[6+3*i:]
+		component := jpegComponent{id: fields[0], horizontal: int(fields[1] >> 4), vertical: int(fields[1] & 0x0f), quantTable: fields[2]}
+		if component.horizontal < 1 || component.horizontal > 4 || component.vertical < 1 || component.vertical > 4 {
+			return errors.New("invalid JPEG sampling factors")
+		}
+		stream.maxH, stream.maxV = max(stream.maxH, component.horizontal), max(stream.maxV, component.vertical)
+		stream.components = append(stream.components, component)
+	}
+	return nil
+}
+
+func (stream *jpegStream) parseHuffman(payload []byte) error {
+	for len(payload) > 0 {
+		if len(payload) < 17 || payload[0]>>4 > 1 || payload[0]&0x0f > 3 {
+			return errors.New("invalid JPEG Huffman table")
+		}
+		var spec huffmanSpec
+		copy(spec.counts[:], payload[1:17])
+		total := 0
+		for _, count := range spec.counts {
+			total += int(count)
+		}
+		if total > 256 || len(payload) < 17+total {
+			return errors.New("invalid JPEG Huffman table")
+		}
+		spec.values = payload[17 : 17+total]
+		if payload[0]>>4 == 0 {
+			stream.dc[payload[0]&0x0f] = spec.decoder()
+		} else {
+			stream.ac[payload[0]&0x0f] = spec.decoder()
+		}
+		payload = payload[17+total:]
+	}
+	return nil
+}
+
+func (stream *jpegStream) parseScan(payload []byte) error {
+	if stream.components == nil {
+		return errors.New("JPEG scan before its frame")
+	}
+	if len(payload) < 1 || int(payload[0]) != len(stream.components) || len(payload) < 4+2*len(stream.components) {
+		return fmt.Errorf("%w: JPEG coded in several scans", ErrLosslessCrop)
+	}
+	for i := range stream.components {
+		id, tables := payload[1+2*i], payload[2+2*i]
+		component := &stream.components[i]
+		if component.id != id {
+			return fmt.Errorf("%w: JPEG scan of its components out of order", ErrLosslessCrop)
+		}
+		component.dcTable, component.acTable = int(tables>>4), int(tables&0x0f)
+		if component.dcTable > 3 || component.acTable > 3 || stream.dc[component.dcTable] == nil || stream.ac[component.acTable] == nil {
+			return errors.New("JPEG scan without its Huffman tables")
+		}
+	}
+	return nil
+}
+
+// A single component is coded block by block, whatever its sampling factors.
+func (stream *jpegStream) unitSize() (int, int) {
+	if len(stream.components) == 1 {
+		return 8, 8
+	}
+	return 8 * stream.maxH, 8 * stream.maxV
+}
+
+func (stream *jpegStream) unitLayout() []int {
+	if len(stream.components) == 1 {
+		return []int{0}
+	}
+	var layout []int
+	for index, component := range stream.components {
+		for range component.horizontal * component.vertical {
+			layout = append(layout, index)
+		}
+	}
+	return layout
+}
+
+func (stream *jpegStream) cropBounds(rect image.Rectangle) (image.Rectangle, error) {
+	rect = rect.Intersect(image.Rect(0, 0, stream.width, stream.height))
+	if rect.Empty() {
+		return image.Rectangle{}, errors.New("crop outside of the image")
+	}
+	unitWidth, unitHeight := stream.unitSize()
+	rect.Min.X -= rect.Min.X % unitWidth
+	rect.Min.Y -= rect.Min.Y % unitHeight
+	return rect, nil
+}
+
+func (stream *jpegStream) writeFrame(output *bytes.Buffer, size image.Point) {
+	frame := []byte{8, byte(size.Y >> 8), byte(size.Y), byte(size.X >> 8), byte(size.X), byte(len(stream.components))}
+	for _, component := range stream.components {
+		frame = append(frame, component.id, byte(component.horizontal<<4|component.vertical), component.quantTable)
+	}
+	writeSegment(output, stream.marker, frame)
+}
+
+func writeSegment(output *bytes.Buffer, marker byte, payload []byte) {
+	output.Write([]byte{0xff, marker, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)})
+	output.Write(payload)
+}
+
+func (stream *jpegStream) decodeBlocks(units image.Rectangle) ([]int16, error) {
+	unitWidth, _ := stream.unitSize()
+	columns := (stream.width + unitWidth - 1) / unitWidth
+	layout := stream.unitLayout()
+
+	blocks := make([]int16, 0, units.Dx()*units.Dy()*len(layout)*blockSize)
+	predictors := make([]int16, len(stream.components))
+	reader := bitReader{data: stream.entropy}
+	block := make([]int16, blockSize)
+	decoded := 0
+	for y := 0; y < units.Max.Y; y++ {
+		for x := 0; x < columns; x++ {
+			if stream.restart > 0 && decoded > 0 && decoded%stream.restart == 0 {
+				if err := reader.restart(); err != nil {
+					return nil, err
+				}
+				clear(predictors)
+			}
+			decoded++
+			keep := image.Pt(x, y).In(units)
+			for _, index := range layout {
+				component := stream.components[index]
+				clear(block)
+				if err := reader.decodeBlock(block, stream.dc[component.dcTable], stream.ac[component.acTable], &predictors[index]); err != nil {
+					return nil, fmt.Errorf("decoding the JPEG block at (%d, %d): %w", x, y, err)
+				}
+				if keep {
+					blocks = append(blocks, block...)
+				}
+			}
+		}
+	}
+	return blocks, nil
+}
+
+func (stream *jpegStream) walkBlocks(blocks []int16, emit func(component jpegComponent, symbol byte, dc bool, value int32)) {
+	layout := stream.unitLayout()
+	predictors := make([]int16, len(stream.components))
+	for i := 0; i < len(blocks); i += blockSize {
+		index := layout[(i/blockSize)%len(layout)]
+		blockSymbols(blocks[i:i+blockSize], predictors[index], func(symbol byte, dc bool, value int32) {
+			emit(stream.components[index], symbol, dc, value)
+		})
+		predictors[index] = blocks[i]
+	}
+}
+
+func blockSymbols(block []int16, previous int16, emit func(symbol byte, dc bool, value int32)) {
+	difference := int32(block[0]) - int32(previous)
+	emit(byte(magnitudeBits(difference)), true, difference)
+
+	run := 0
+	for _, coefficient := range block[1:] {
+		if coefficient == 0 {
+			run++
+			continue
+		}
+		for ; run > 15; run -= 16 {
+			emit(0xf0, false, 0)
+		}
+		emit(byte(run<<4|magnitudeBits(int32(coefficient))), false, int32(coefficient))
+		run = 0
+	}
+	if run > 0 {
+		emit(0x00, false, 0)
+	}
+}
+
+func magnitudeBits(value int32) int {
+	if value < 0 {
+		value = -value
+	}
+	return bits.Len32(uint32(value))
+}
+
+func (reader *bitReader) fill() {
+	for reader.count <= 24 {
+		var next byte
+		if !reader.marker && reader.pos < len(reader.data) {
+			next = reader.data[reader.pos]
+			switch {
+			case next != 0xff:
+				reader.pos++
+			case reader.pos+1 < len(reader.data) && reader.data[reader.pos+1] == 0:
+				reader.pos += 2
+			default:
+				// A restart marker: the segment is padded with zeros up to it.
+				reader.marker = true
+				next = 0
+			}
+		}
+		reader.bits |= uint32(next) << (24 - reader.count)
+		reader.count += 8
+	}
+}
+
+func (reader *bitReader) read(count int) int32 {
+	if count == 0 {
+		return 0
+	}
+	reader.fill()
+	value := reader.bits >> (32 - count)
+	reader.bits <<= count
+	reader.count -= count
+	return int32(value)
+}
+
+func (reader *bitReader) decode(decoder *huffmanDecoder) (byte, error) {
+	reader.fill()
+	peek := int32(reader.bits >> 16)
+	for length := 1; length <= 16; length++ {
+		code := peek >> (16 - length)
+		if code <= decoder.maxCode[length] {
+			reader.bits <<= length
+			reader.count -= length
+			return decoder.values[code+decoder.offset[length]], nil
+		}
+	}
+	return 0, errors.New("invalid Huffman code")
+}
+
+func (reader *bitReader) decodeBlock(block []int16, dc, ac *huffmanDecoder, predictor *int16) error {
+	size, err := reader.decode(dc)
+	if err != nil {
+		return err
+	}
+	if size > 11 {
+		return errors.New("invalid DC coefficient")
+	}
+	*predictor += int16(extend(reader.read(int(size)), int(size)))
+	block[0] = *predictor
+
+	for k := 1; k < blockSize; k++ {
+		symbol, err := reader.decode(ac)
+		if err != nil {
+			return err
+		}
+		run, size := int(symbol>>4), int(symbol&0x0f)
+		if size == 0 {
+			if run != 15 {
+				break
+			}
+			k += 15
+			continue
+		}
+		k += run
+		if k >= blockSize || size > 10 {
+			return errors.New("invalid AC coefficient")
+		}
+		block[k] = int16(extend(reader.read(size), size))
+	}
+	return nil
+}
+
+func extend(value int32, size int) int32 {
+	if size > 0 && value < 1<<(size-1) {
+		return value - 1<<size + 1
+	}
+	return value
+}
+
+func (reader *bitReader) restart() error {
+	reader.bits, reader.count = 0, 0
+	for reader.pos+1 < len(reader.data) && (reader.data[reader.pos] != 0xff || reader.data[reader.pos+1] == 0 || reader.data[reader.pos+1] == 0xff) {
+		reader.pos++
+	}
+	if reader.pos+1 >= len(reader.data) || reader.data[reader.pos+1] < 0xd0 || reader.data[reader.pos+1] > 0xd7 {
+		return errors.New("missing JPEG restart marker")
+	}
+	reader.pos += 2
+	reader.marker = false
+	return nil
+}
+
+func (spec huffmanSpec) decoder() *huffmanDecoder {
+	decoder := &huffmanDecoder{values: spec.values}
+	code, index := int32(0), int32(0)
+	for length := 1; length <= 16; length++ {
+		count := int32(spec.counts[length-1])
+		decoder.maxCode[length] = -1
+		if count > 0 {
+			decoder.offset[length] = index - code
+			decoder.maxCode[length] = code + count - 1
+		}
+		code = (code + count) << 1
+		index += count
+	}
+	return decoder
+}
+
+func (spec huffmanSpec) encoder() *huffmanEncoder {
+	encoder := &huffmanEncoder{}
+	code, index := uint32(0), 0
+	for length := 1; length <= 16; length++ {
+		for range spec.counts[length-1] {
+			encoder.codes[spec.values[index]] = code
+			encoder.lengths[spec.values[index]] = uint8(length)
+			code++
+			index++
+		}
+		code <<= 1
+	}
+	return encoder
+}
+
+func optimalHuffman(frequencies *[256]int) huffmanSpec {
+	// A reserved symbol of frequency 1 keeps the code of all ones, forbidden by the standard, unused.
+	var frequency [257]int
+	copy(frequency[:], frequencies[:])
+	frequency[256] = 1
+	var codeSize [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
+	}
+
+	for {
+		first, second := -1, -1
+		for symbol, count := range frequency {
+			if count == 0 {
+				continue
+			}
+			if first < 0 || count <= frequency[first] {
+				first, second = symbol, first
+			} else if second < 0 || count <= frequency[second] {
+				second = symbol
+			}
+		}
+		if second < 0 {
+			break
+		}
+		frequency[first] += frequency[second]
+		frequency[second] = 0
+		for codeSize[first]++; others[first] >= 0; codeSize[first]++ {
+			first = others[first]
+		}
+		others[first] = second
+		for codeSize[second]++; others[second] >= 0; codeSize[second]++ {
+			second = others[second]
+		}
+	}
+
+	var lengths [33]int
+	for _, size := range codeSize {
+		if size > 0 {
+			lengths[size]++
+		}
+	}
+	// Codes longer than 16 bits are shortened, pairing their symbols with a shorter code split in two.
+	for size := 32; size > 16; size-- {
+		for lengths[size] > 0 {
+			shorter := size - 2
+			for lengths[shorter] == 0 {
+				shorter--
+			}
+			lengths[size] -= 2
+			lengths[size-1]++
+			lengths[shorter+1] += 2
+			lengths[shorter]--
+		}
+	}
+	longest := 16
+	for lengths[longest] == 0 {
+		longest--
+	}
+	lengths[longest]--
+
+	var spec huffmanSpec
+	for size := 1; size <= 16; size++ {
+		spec.counts[size-1] = byte(lengths[size])
+	}
+	for size := 1; size <= 32; size++ {
+		for symbol := range 256 {
+			if codeSize[symbol] == size {
+				spec.values = append(spec.values, byte(symbol))
+			}
+		}
+	}
+	return spec
+}
+
+func (writer *bitWriter) write(value uint32, count int) {
+	writer.bits = writer.bits<<count | value
+	writer.count += count
+	for writer.count >= 8 {
+		writer.count -= 8
+		next := byte(writer.bits >> writer.count)
+		writer.buffer.WriteByte(next)
+		if next == 0xff {
+			writer.buffer.WriteByte(0)
+		}
+	}
+	writer.bits &= 1<<writer.count - 1
+}
+
+func (writer *bitWriter) flush() {
+	if writer.count > 0 {
+		writer.write(1<<(8-writer.count)-1, 8-writer.count)
+	}
+}
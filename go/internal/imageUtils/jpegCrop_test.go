@@ -0,0 +1,89 @@
+package imageUtils
+
+/*
+This file tests the lossless crop of a JPEG image: the decoded crop has the pixels of the decoded image, exactly, for
+a color and a grayscale image, and the images that cannot be cropped losslessly are refused.
+*/
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestCropJPEG(t *testing.T) {
+	color := gradient(100, 70)
+	gray := image.NewGray(color.Bounds())
+	for i := range gray.Pix {
+		gray.Pix[i] = color.Pix[4*i] ^ color.Pix[4*i+1]
+	}
+
+	tests := []struct {
+		name     string
+		img      image.Image
+		expected image.Rectangle
+	}{
+		// 4:2:0 color: MCUs of 16x16 pixels.
+		{"color", color, image.Rect(16, 0, 91, 67)},
+		{"gray", gray, image.Rect(16, 8, 91, 67)},
+	}
+	for _, test := range tests {
+		var encoded bytes.Buffer
+		if err := jpeg.Encode(&encoded, test.img, &jpeg.Options{Quality: 90}); err != nil {
+			t.Fatal(err)
+		}
+		original, err := jpeg.Decode(bytes.NewReader(encoded.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rect := image.Rect(21, 13, 91, 67)
+		bounds, err := JPEGCropBounds(encoded.Bytes(), rect)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if bounds != test.expected {
+			t.Fatalf("%s: crop of %v extended to %v, expected %v", test.name, rect, bounds, test.expected)
+		}
+
+		data, err := CropJPEG(encoded.Bytes(), rect)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		cropped, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: cropped image does not decode: %v", test.name, err)
+		}
+		if size := cropped.Bounds().Size(); size != bounds.Size() {
+			t.Fatalf("%s: cropped image is %v, expected %v", test.name, size, bounds.Size())
+		}
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				if got, expected := cropped.At(x, y), original.At(bounds.Min.X+x, bounds.Min.Y+y); got != expected {
+					t.Fatalf("%s: pixel (%d, %d) of the crop is %v, expected %v", test.name, x, y, got, expected)
+				}
+			}
+		}
+	}
+}
+
+func TestCropJPEGRefused(t *testing.T) {
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, gradient(20, 20)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CropJPEG(encoded.Bytes(), image.Rect(0, 0, 10, 10)); !errors.Is(err, ErrLosslessCrop) {
+		t.Errorf("PNG image cropped with error %v, expected ErrLosslessCrop", err)
+	}
+
+	encoded.Reset()
+	if err := jpeg.Encode(&encoded, gradient(20, 20), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CropJPEG(encoded.Bytes(), image.Rect(30, 30, 40, 40)); err == nil {
+		t.Error("crop outside of the image succeeded")
+	}
+}
@@ -0,0 +1,221 @@
+package imageUtils
+
+/*
+Package imageUtils provides the cleanup of the punched holes and the staple shadows of a cropped page: a page taken
+out of a binder shows its holes as dark disks along one of its edges, the background seen through them, and a page
+taken off a stack its staple, or the shadow of the staple, as a short dark stroke in a corner. They are found among
+the dark blobs of the margins of the page by their size and their shape, and painted over with the paper around
+them, for a cleaner archive.
+
+---
+
+### Constants
+- `holeMargin`: Width of the margins the holes and the staples are looked for in, as a fraction of the shorter side
+  of the page: the holes of a binder are punched about 12 mm from the edge of a sheet 210 mm wide.
+- `holeDarkness`: Share of the gray level of the paper under which a pixel belongs to a blob.
+- `minHoleSize`, `maxHoleSize`: Smallest and largest diameter of a hole, as a fraction of the shorter side of the
+  page: the 5 to 8 mm holes of the binders, on a page of any size.
+- `maxHoleAspect`: Largest ratio of the sides of the bounding box of a hole, a disk seen slightly askew.
+- `minHoleFill`, `maxHoleFill`: Range of the share of the bounding box of a hole it fills, that of a disk being
+  about 0.79: a letter `O` fills less, a square figure more.
+- `minStapleLength`, `maxStapleLength`: Shortest and longest staple, as a fraction of the shorter side of the page.
+- `stapleThinness`: Largest ratio of the thickness of a staple to its length.
+- `holeRim`: Number of pixels the blobs are grown by before they are painted over, so their blurred rim goes with
+  them.
+
+---
+
+### FillPunchHoles(img *image.RGBA) []image.Rectangle
+Paints over the punched holes and the staple shadows of `img`, a cropped page, in place, and returns the regions
+painted over.
+
+- **Behavior**:
+  1. Estimates the color of the paper across the page, as `CleanWhiteboard` does for a board (see
+     `boardBackground`), so the blobs are found whatever the lighting: a pixel belongs to a blob when its gray level
+     is under `holeDarkness` of the gray level of the paper under it. Only the margins of the page are searched,
+     widened by the size of the largest hole so the blobs on their inner border are whole.
+  2. Groups the dark pixels into blobs of 8-connected pixels, and keeps those whose center is in the margins and:
+     - whose bounding box is nearly square, from `minHoleSize` to `maxHoleSize` wide, and filled like a disk: a hole;
+     - or, in a corner of the page, whose length, the diagonal of their bounding box, is from `minStapleLength` to
+       `maxStapleLength`, and whose thickness, their area divided by their length, is thin: a staple.
+     The text of the margins, page numbers and headers, is made of blobs too small, too hollow or too long.
+  3. Grows every blob kept by `holeRim` pixels, and paints every pixel of it with the paper around it (see
+     `inpaint`).
+
+### inpaint(img *image.RGBA, mask []bool, area image.Rectangle)
+Replaces the pixels of `area` set in `mask`, one value per pixel of `area` row by row, with the mean of the nearest
+pixels outside of the mask to their left, right, top and bottom, weighted by the inverse of their distance, so a
+gradient of the paper is continued across the hole. The directions leaving the image are left out, and a pixel with
+none is kept.
+
+---
+
+### Example Usage:
+```go
+regions := imageUtils.FillPunchHoles(croppedPage)
+log.Printf("Painted over %d holes and staples: %v", len(regions), regions)
+```
+*/
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	holeMargin      = 0.12
+	holeDarkness    = 0.6
+	minHoleSize     = 0.015
+	maxHoleSize     = 0.06
+	maxHoleAspect   = 1.3
+	minHoleFill     = 0.6
+	maxHoleFill     = 0.92
+	minStapleLength = 0.02
+	maxStapleLength = 0.15
+	stapleThinness  = 0.2
+	holeRim         = 2
+)
+
+func FillPunchHoles(img *image.RGBA) []image.Rectangle {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if bounds.Empty() {
+		return nil
+	}
+	shorter := float64(min(width, height))
+	margin := int(holeMargin * shorter)
+	search := margin + int(maxHoleSize*shorter)
+
+	cell := max(max(width, height)/boardCells, minBoardCell)
+	columns := (width + cell - 1) / cell
+	rows := (height + cell - 1) / cell
+	background := boardBackground(img, cell, columns, rows)
+
+	inMargin := func(x, y, margin int) bool {
+		return x < margin || x >= width-margin || y < margin || y >= height-margin
+	}
+	dark := make([]bool, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if !inMargin(x, y, search) {
+				continue
+			}
+			paper := boardColor(background, columns, rows, cell, x, y)
+			level := 0.299*paper[0] + 0.587*paper[1] + 0.114*paper[2]
+			offset := img.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+			dark[y*width+x] = float64(luminance(img.Pix[offset:offset+3])) < holeDarkness*level
+		}
+	}
+
+	var regions []image.Rectangle
+	var stack, blob []int
+	for start, isDark := range dark {
+		if !isDark {
+			continue
+		}
+		dark[start] = false
+		stack, blob = append(stack[:0], start), blob[:0]
+		box := image.Rect(start%width, start/width, start%width+1, start/width+1)
+		for len(stack) > 0 {
+			pixel := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			blob = append(blob, pixel)
+			x, y := pixel%width, pixel/width
+			box = box.Union(image.Rect(x, y, x+1, y+1))
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx >= 0 && nx < width && ny >= 0 && ny < height && dark[ny*width+nx] {
+						dark[ny*width+nx] = false
+						stack = append(stack, ny*width+nx)
+					}
+				}
+			}
+		}
+
+		center := box.Min.Add(box.Max).Div(2)
+		if !inMargin(center.X, center.Y, margin) {
+			continue
+		}
+		w, h := float64(box.Dx()), float64(box.Dy())
+		fill := float64(len(blob)) / (w * h)
+		hole := max(w, h) >= minHoleSize*shorter && max(w, h) <= maxHoleSize*shorter &&
+			max(w, h) <= maxHoleAspect*min(w, h) && fill >= minHoleFill && fill <= maxHoleFill
+		length := math.Hypot(w, h)
+		corner := (center.X < margin || center.X >= width-margin) && (center.Y < margin || center.Y >= height-margin)
+		staple := corner && length >= minStapleLength*shorter && length <= maxStapleLength*shorter &&
+			float64(len(blob))/length <= stapleThinness*length
+		if !hole && !staple {
+			continue
+		}
+
+		area := box.Inset(-holeRim).Intersect(image.Rect(0, 0, width, height))
+		mask := make([]bool, area.Dx()*area.Dy())
+		for _, pixel := range blob {
+			x, y := pixel%width, pixel/width
+			for ny := max(y-holeRim, area.Min.Y); ny < min(y+holeRim+1, area.Max.Y); ny++ {
+				for nx := max(x-holeRim, area.Min.X); nx < min(x+holeRim+1, area.Max.X); nx++ {
+					mask[(ny-area.Min.Y)*area.Dx()+nx-area.Min.X] = true
+				}
+			}
+		}
+		area = area.Add(bounds.Min)
+		inpaint(img, mask, area)
+		regions = append(regions, area)
+	}
+	return regions
+}
+
+func inpaint(img *image.RGBA, mask []bool, area image.Rectangle) {
+	bounds := img.Bounds()
+	masked := func(x, y int) bool {
+		return image.Pt(x, y).In(area) && mask[(y-area.Min.Y)*area.Dx()+x-area.Min.X]
+	}
+
+	filled := make([][3]float64, len(mask))
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			if !masked(x, y) {
+				continue
+			}
+			index := (y-area.Min.Y)*area.Dx() + x - area.Min.X
+			offset := img.PixOffset(x, y)
+			filled[index] = [3]float64{float64(img.Pix[offset]), float64(img.Pix[offset+1]), float64(img.Pix[offset+2])}
+			var sum [3]float64
+			var weights float64
+			for _, step := range [4]image.Point{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+				point := image.Pt(x, y).Add(step)
+				for masked(point.X, point.Y) {
+					point = point.Add(step)
+				}
+				if !point.In(bounds) {
+					continue
+				}
+				weight := 1 / float64(max(abs(point.X-x), abs(point.Y-y)))
+				neighbor := img.PixOffset(point.X, point.Y)
+				for c := range sum {
+					sum[c] += weight * float64(img.Pix[neighbor+c])
+				}
+				weights += weight
+			}
+			if weights > 0 {
+				for c := range sum {
+					filled[index][c] = sum[c] / weights
+				}
+			}
+		}
+	}
+
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			if !masked(x, y) {
+				continue
+			}
+			offset := img.PixOffset(x, y)
+			for c, value := range filled[(y-area.Min.Y)*area.Dx()+x-area.Min.X] {
+				img.Pix[offset+c] = uint8(math.Round(value))
+			}
+			img.Pix[offset+3] = 255
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package imageUtils
+
+/*
+This file tests the cleanup of a page with two punched holes along its left edge and a staple in its top left corner:
+they must be painted over with the paper, unevenly lit, while the text lines and a figure in the margin are kept.
+*/
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func punchedPage() *image.RGBA {
+	page := image.NewRGBA(image.Rect(0, 0, 400, 560))
+	for y := 0; y < 560; y++ {
+		for x := 0; x < 400; x++ {
+			light := 1 - 0.3*float64(y)/560
+			value := 230 * light
+			switch {
+			case math.Hypot(float64(x-25), float64(y-150)) < 8, math.Hypot(float64(x-25), float64(y-410)) < 8:
+				value = 45
+			case x > 14 && x < 46 && math.Abs(float64(y)-(40-0.6*float64(x-14))) < 1.5:
+				value = 70
+			case x > 340 && x < 380 && y > 250 && y < 300:
+				value = 30
+			case y%20 < 3 && x > 60 && x < 340:
+				value = 40 * light
+			}
+			page.SetRGBA(x, y, color.RGBA{R: uint8(value), G: uint8(value), B: uint8(value), A: 255})
+		}
+	}
+	return page
+}
+
+func TestFillPunchHoles(t *testing.T) {
+	page := punchedPage()
+	regions := FillPunchHoles(page)
+	if len(regions) != 3 {
+		t.Errorf("%d regions painted over (%v), expected the 2 holes and the staple", len(regions), regions)
+	}
+
+	for _, point := range []image.Point{{25, 150}, {25, 410}, {30, 30}} {
+		expected := 230 * (1 - 0.3*float64(point.Y)/560)
+		if value := float64(page.RGBAAt(point.X, point.Y).R); math.Abs(value-expected) > 12 {
+			t.Errorf("pixel at %v is %g once cleaned up, expected the paper, about %g", point, value, expected)
+		}
+	}
+	for _, point := range []image.Point{{200, 21}, {360, 275}} {
+		if value := page.RGBAAt(point.X, point.Y).R; value > 50 {
+			t.Errorf("pixel at %v is %d once cleaned up, expected the print kept", point, value)
+		}
+	}
+}
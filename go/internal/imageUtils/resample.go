@@ -0,0 +1,269 @@
+package imageUtils
+
+/*
+Package imageUtils provides the resampling of images shared by the geometric transforms of the pipeline: the scaling
+of a document to a page, the perspective warp of a document and the crop of a rotated receipt, the thumbnails of a
+contact sheet and the logos of the stamps. Nearest-neighbour sampling visibly degrades a scan: the letters get jagged
+edges when it scales up and lose strokes when it scales down.
+
+---
+
+### Interpolation
+The interpolation of the colors of an image between its pixels:
+- `Bilinear`: Linear between the 2x2 pixels around a position. Smooth, never overshoots: suited to the photos and
+  the warps, whose scale is close to 1.
+- `Bicubic`: Catmull-Rom spline through the 4x4 pixels around a position. Sharper than `Bilinear`, which keeps the
+  text of a document crisp, at the cost of a slight halo along the strong edges.
+
+---
+
+### Resize(img image.Image, width, height int, interpolation Interpolation) *image.RGBA
+Scales `img` to `width` x `height` pixels, in two passes (horizontal, then vertical) for a cost proportional to the
+size of the kernel rather than to its square.
+
+- **Behavior**:
+  - When scaling down, the kernel is widened by the scale factor so every source pixel contributes to the result:
+    the result is antialiased, e.g. the thin lines of a page do not vanish nor turn into a moiré pattern.
+  - The borders of the image are extended by their closest pixel.
+  - An empty image is returned if the source image or the requested size is empty.
+
+### WarpAffine(img image.Image, matrix [2][3]float64, width, height int, interpolation Interpolation) *image.RGBA
+Transforms `img` by the affine map `matrix` into an image of `width` x `height` pixels whose bounds start at (0, 0):
+the pixel (x, y) of `img` goes to (`matrix[0][0]*x + matrix[0][1]*y + matrix[0][2]`,
+`matrix[1][0]*x + matrix[1][1]*y + matrix[1][2]`) of the result, e.g. a rotation, a scale or a translation.
+
+- **Behavior**:
+  - Maps every pixel of the result back into `img` through the inverse of `matrix`, and interpolates its color
+    there (see `Sample`), so the result has no holes. The map is not antialiased: it suits scales close to 1, use
+    `Resize` to scale down.
+  - An empty image is returned if the source image or the requested size is empty, or if `matrix` cannot be
+    inverted.
+
+### warpAffine(img image.Image, matrix [2][3]float64, width, height int, interpolation Interpolation, clip bool) *image.RGBA
+Same as `WarpAffine`, the pixels of the result mapped more than half a pixel outside of `img` left transparent if
+`clip` is set, instead of taking the color of its border: the corners of a rotated image.
+
+### Sample(source *image.RGBA, x, y float64, interpolation Interpolation, pixel []uint8)
+Writes to `pixel` the RGBA color of `source` at (x, y), interpolated between the pixels around it. The pixel (x, y) of
+an image is at integer coordinates, and the positions outside the image take the color of the closest pixel of its
+border.
+
+### toRGBA(img image.Image) *image.RGBA
+Returns `img` if it is an `*image.RGBA`, otherwise a copy of it in RGBA.
+
+### kernel(interpolation Interpolation, distance float64) float64
+Returns the weight of a pixel at `distance` pixels from the interpolated position, 0 beyond the radius of the kernel.
+
+### resampleTaps(sourceLength, length int, interpolation Interpolation) []tap
+Returns, for every pixel of a row (or a column) of `length` pixels resampled from `sourceLength` pixels, the source
+pixels it is interpolated from and their weights, which sum to 1.
+
+### storePixel(pixel []uint8, sums [4]float64)
+Rounds the interpolated color `sums` into `pixel`, clamping it to the range of the channels: the colors of the
+premultiplied `image.RGBA` cannot exceed its alpha, which the overshoot of `Bicubic` could give.
+
+---
+
+### Example Usage:
+```go
+page := imageUtils.Resize(document, 2480, 3508, imageUtils.Bicubic)
+rotated := imageUtils.WarpAffine(img, [2][3]float64{{0, -1, float64(height - 1)}, {1, 0, 0}}, height, width, imageUtils.Bilinear)
+```
+*/
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+type Interpolation int
+
+const (
+	Bilinear Interpolation = iota
+	Bicubic
+)
+
+const singularAffine = 1e-12
+
+type tap struct {
+	indices []int
+	weights []float64
+}
+
+func Resize(img image.Image, width, height int, interpolation Interpolation) *image.RGBA {
+	output := image.NewRGBA(image.Rect(0, 0, max(width, 0), max(height, 0)))
+
+	bounds := img.Bounds()
+	if bounds.Empty() || width <= 0 || height <= 0 {
+		return output
+	}
+	source := toRGBA(img)
+
+	// Horizontal pass, into full-precision rows so the colors are rounded once.
+	columns := resampleTaps(bounds.Dx(), width, interpolation)
+	intermediate := make([]float64, 4*width*bounds.Dy())
+	for y := 0; y < bounds.Dy(); y++ {
+		row := source.Pix[source.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+		line := intermediate[4*width*y : 4*width*(y+1)]
+		for x, column := range columns {
+			for i, index := range column.indices {
+				weight := column.weights[i]
+				for c := 0; c < 4; c++ {
+					line[4*x+c] += weight * float64(row[4*index+c])
+				}
+			}
+		}
+	}
+
+	rows := resampleTaps(bounds.Dy(), height, interpolation)
+	for y, row := range rows {
+		pixels := output.Pix[output.PixOffset(0, y):output.PixOffset(width, y)]
+		for x := 0; x < width; x++ {
+			var sums [4]float64
+			for i, index := range row.indices {
+				weight := row.weights[i]
+				for c := 0; c < 4; c++ {
+					sums[c] += weight * intermediate[4*(width*index+x)+c]
+				}
+			}
+			storePixel(pixels[4*x:4*x+4], sums)
+		}
+	}
+
+	return output
+}
+
+func WarpAffine(img image.Image, matrix [2][3]float64, width, height int, interpolation Interpolation) *image.RGBA {
+	return warpAffine(img, matrix, width, height, interpolation, false)
+}
+
+func warpAffine(img image.Image, matrix [2][3]float64, width, height int, interpolation Interpolation, clip bool) *image.RGBA {
+	output := image.NewRGBA(image.Rect(0, 0, max(width, 0), max(height, 0)))
+
+	bounds := img.Bounds()
+	determinant := matrix[0][0]*matrix[1][1] - matrix[0][1]*matrix[1][0]
+	if bounds.Empty() || width <= 0 || height <= 0 || math.Abs(determinant) < singularAffine {
+		return output
+	}
+	source := toRGBA(img)
+
+	// Inverse of the linear part, then of the translation.
+	a, b := matrix[1][1]/determinant, -matrix[0][1]/determinant
+	c, d := -matrix[1][0]/determinant, matrix[0][0]/determinant
+	tx := -(a*matrix[0][2] + b*matrix[1][2])
+	ty := -(c*matrix[0][2] + d*matrix[1][2])
+
+	for y := 0; y < height; y++ {
+		row := output.Pix[output.PixOffset(0, y):output.PixOffset(width, y)]
+		for x := 0; x < width; x++ {
+			u, v := float64(x), float64(y)
+			sourceX, sourceY := a*u+b*v+tx, c*u+d*v+ty
+			if clip && (sourceX < float64(bounds.Min.X)-0.5 || sourceX > float64(bounds.Max.X)-0.5 ||
+				sourceY < float64(bounds.Min.Y)-0.5 || sourceY > float64(bounds.Max.Y)-0.5) {
+				continue
+			}
+			Sample(source, sourceX, sourceY, interpolation, row[4*x:4*x+4])
+		}
+	}
+
+	return output
+}
+
+func Sample(source *image.RGBA, x, y float64, interpolation Interpolation, pixel []uint8) {
+	bounds := source.Bounds()
+	clamp := func(value, low, high int) int {
+		return min(max(value, low), high-1)
+	}
+
+	radius := 1
+	if interpolation == Bicubic {
+		radius = 2
+	}
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+
+	var sums [4]float64
+	for j := y0 - radius + 1; j <= y0+radius; j++ {
+		weightY := kernel(interpolation, y-float64(j))
+		if weightY == 0 {
+			continue
+		}
+		row := clamp(j, bounds.Min.Y, bounds.Max.Y)
+		for i := x0 - radius + 1; i <= x0+radius; i++ {
+			weight := weightY * kernel(interpolation, x-float64(i))
+			if weight == 0 {
+				continue
+			}
+			offset := source.PixOffset(clamp(i, bounds.Min.X, bounds.Max.X), row)
+			for c := 0; c < 4; c++ {
+				sums[c] += weight * float64(source.Pix[offset+c])
+			}
+		}
+	}
+	storePixel(pixel, sums)
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(bounds)
+	draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
+	return rgba
+}
+
+func kernel(interpolation Interpolation, distance float64) float64 {
+	distance = math.Abs(distance)
+	if interpolation == Bicubic {
+		switch {
+		case distance < 1:
+			return (1.5*distance-2.5)*distance*distance + 1
+		case distance < 2:
+			return ((-0.5*distance+2.5)*distance-4)*distance + 2
+		default:
+			return 0
+		}
+	}
+	return max(1-distance, 0)
+}
+
+func resampleTaps(sourceLength, length int, interpolation Interpolation) []tap {
+	radius := 1.0
+	if interpolation == Bicubic {
+		radius = 2
+	}
+	scale := float64(sourceLength) / float64(length)
+	// Scaling down widens the kernel so it covers every source pixel.
+	filterScale := max(scale, 1)
+	support := radius * filterScale
+
+	taps := make([]tap, length)
+	for i := range taps {
+		center := (float64(i)+0.5)*scale - 0.5
+		first, last := int(math.Ceil(center-support)), int(math.Floor(center+support))
+
+		var total float64
+		for j := first; j <= last; j++ {
+			weight := kernel(interpolation, (float64(j)-center)/filterScale)
+			if weight == 0 {
+				continue
+			}
+			taps[i].indices = append(taps[i].indices, min(max(j, 0), sourceLength-1))
+			taps[i].weights = append(taps[i].weights, weight)
+			total += weight
+		}
+		for j := range taps[i].weights {
+			taps[i].weights[j] /= total
+		}
+	}
+	return taps
+}
+
+func storePixel(pixel []uint8, sums [4]float64) {
+	alpha := min(max(math.Round(sums[3]), 0), 255)
+	for c := 0; c < 3; c++ {
+		pixel[c] = uint8(min(max(math.Round(sums[c]), 0), alpha))
+	}
+	pixel[3] = uint8(alpha)
+}
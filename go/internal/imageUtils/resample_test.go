@@ -0,0 +1,111 @@
+package imageUtils
+
+/*
+This file tests the resampling of images: the scaling, antialiased when it scales down, and the affine warp, with both
+interpolations.
+
+---
+
+### gradient(width, height int) *image.RGBA
+Returns an opaque image whose red channel grows with x and green channel with y, so every pixel has its own color.
+*/
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+var interpolations = map[string]Interpolation{"bilinear": Bilinear, "bicubic": Bicubic}
+
+func gradient(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x * 8), G: uint8(y * 8), B: 100, A: 255})
+		}
+	}
+	return img
+}
+
+func TestResizeSameSize(t *testing.T) {
+	img := gradient(20, 12)
+	for name, interpolation := range interpolations {
+		resized := Resize(img, 20, 12, interpolation)
+		for i := range img.Pix {
+			if resized.Pix[i] != img.Pix[i] {
+				t.Fatalf("%s: byte %d is %d, expected %d", name, i, resized.Pix[i], img.Pix[i])
+			}
+		}
+	}
+}
+
+func TestResizeAntialiases(t *testing.T) {
+	// One-pixel black and white stripes: scaled down, they must average to gray rather than keep one of the colors.
+	stripes := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			value := uint8(255 * (x % 2))
+			stripes.SetRGBA(x, y, color.RGBA{R: value, G: value, B: value, A: 255})
+		}
+	}
+
+	for name, interpolation := range interpolations {
+		resized := Resize(stripes, 16, 16, interpolation)
+		for x := 2; x < 14; x++ {
+			if value := resized.RGBAAt(x, 8).R; math.Abs(float64(value)-127.5) > 8 {
+				t.Fatalf("%s: pixel (%d, 8) is %d, expected about 128", name, x, value)
+			}
+		}
+	}
+}
+
+func TestResizeUpscalesLinearly(t *testing.T) {
+	img := gradient(10, 10)
+	resized := Resize(img, 20, 20, Bilinear)
+	// The pixel 5 of the result is centered at 2.25 in the source, between the reds 16 and 24.
+	if red := resized.RGBAAt(5, 10).R; red != 18 {
+		t.Fatalf("red of pixel (5, 10) is %d, expected 18", red)
+	}
+
+	if empty := Resize(img, 0, 10, Bilinear); !empty.Bounds().Empty() {
+		t.Fatalf("resized to a width of 0 gives %v, expected an empty image", empty.Bounds())
+	}
+}
+
+func TestWarpAffine(t *testing.T) {
+	img := gradient(16, 10)
+	// A quarter turn clockwise: the pixel (x, y) goes to (9 - y, x).
+	rotation := [2][3]float64{{0, -1, 9}, {1, 0, 0}}
+
+	for name, interpolation := range interpolations {
+		rotated := WarpAffine(img, rotation, 10, 16, interpolation)
+		for _, point := range []image.Point{{0, 0}, {3, 7}, {15, 9}} {
+			if got, expected := rotated.RGBAAt(9-point.Y, point.X), img.RGBAAt(point.X, point.Y); got != expected {
+				t.Errorf("%s: pixel %v went to %v, expected %v", name, point, got, expected)
+			}
+		}
+	}
+
+	if empty := WarpAffine(img, [2][3]float64{{1, 2, 0}, {2, 4, 0}}, 10, 10, Bilinear); empty.Pix[3] != 0 {
+		t.Error("a singular map gave pixels, expected an empty image")
+	}
+}
+
+func TestSampleBicubic(t *testing.T) {
+	img := gradient(10, 10)
+	pixel := make([]uint8, 4)
+
+	// Midway between two pixels of a linear gradient, the spline passes through their mean.
+	Sample(img, 4.5, 3, Bicubic, pixel)
+	if pixel[0] != 36 || pixel[1] != 24 {
+		t.Fatalf("sampled %v at (4.5, 3), expected red 36 and green 24", pixel)
+	}
+
+	// Outside of the image, the closest pixel of the border.
+	Sample(img, -5, 20, Bicubic, pixel)
+	if expected := img.RGBAAt(0, 9); pixel[0] != expected.R || pixel[1] != expected.G {
+		t.Fatalf("sampled %v outside of the image, expected %v", pixel, expected)
+	}
+}
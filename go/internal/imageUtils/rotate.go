@@ -0,0 +1,89 @@
+package imageUtils
+
+/*
+Package imageUtils provides the rotation of an image by quarter turns, e.g. to turn a document photographed upside
+down or sideways upright, and by any angle, e.g. to straighten a slanted page. The pixels of a quarter turn are moved,
+not interpolated, so the rotation is exact and can be undone.
+
+---
+
+### RotateQuarterTurns(img image.Image, turns int) *image.RGBA
+Rotates `img` clockwise by `turns` quarter turns (90 degrees each), into a new image whose bounds start at (0, 0).
+
+- **Behavior**:
+  - `turns` is taken modulo 4, so -1 turns counterclockwise: an image rotated by `turns`, then by `-turns`, is the
+    original image.
+  - An odd number of turns swaps the width and the height of the image.
+
+### Rotate(img image.Image, angle float64, interpolation Interpolation) *image.RGBA
+Rotates `img` clockwise by `angle` degrees about its center, into a new image whose bounds start at (0, 0), large
+enough to hold the whole of the rotated image, e.g. 142x142 pixels for a 100x100 image turned by 45 degrees.
+
+- **Behavior**:
+  - A multiple of 90 degrees is a quarter turn, exact (see `RotateQuarterTurns`).
+  - Otherwise the colors are interpolated (see `WarpAffine`), and the corners of the result outside the rotated
+    image are transparent.
+
+### RotationMatrix(bounds image.Rectangle, angle float64, width, height int) [2][3]float64
+Returns the affine map of `WarpAffine` rotating the pixels of `bounds` clockwise by `angle` degrees about their
+center, onto the center of an image of `width` x `height` pixels whose bounds start at (0, 0).
+*/
+
+import (
+	"image"
+	"math"
+)
+
+func Rotate(img image.Image, angle float64, interpolation Interpolation) *image.RGBA {
+	if math.Mod(angle, 90) == 0 {
+		return RotateQuarterTurns(img, int(math.Mod(angle/90, 4)))
+	}
+	bounds := img.Bounds()
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	// Less an epsilon, so the rounding errors of an exact size do not add a pixel.
+	width := int(math.Ceil(float64(bounds.Dx())*math.Abs(cos) + float64(bounds.Dy())*math.Abs(sin) - 1e-9))
+	height := int(math.Ceil(float64(bounds.Dx())*math.Abs(sin) + float64(bounds.Dy())*math.Abs(cos) - 1e-9))
+	return warpAffine(img, RotationMatrix(bounds, angle, width, height), width, height, interpolation, true)
+}
+
+func RotationMatrix(bounds image.Rectangle, angle float64, width, height int) [2][3]float64 {
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	centerX, centerY := float64(bounds.Min.X)+float64(bounds.Dx()-1)/2, float64(bounds.Min.Y)+float64(bounds.Dy()-1)/2
+	outputX, outputY := float64(width-1)/2, float64(height-1)/2
+	return [2][3]float64{
+		{cos, -sin, outputX - cos*centerX + sin*centerY},
+		{sin, cos, outputY - sin*centerX - cos*centerY},
+	}
+}
+
+func RotateQuarterTurns(img image.Image, turns int) *image.RGBA {
+	turns = ((turns % 4) + 4) % 4
+	source := toRGBA(img)
+	bounds := source.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if turns%2 == 1 {
+		width, height = height, width
+	}
+	output := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < bounds.Dy(); y++ {
+		row := source.Pix[source.PixOffset(bounds.Min.X, bounds.Min.Y+y):]
+		for x := 0; x < bounds.Dx(); x++ {
+			var targetX, targetY int
+			switch turns {
+			case 0:
+				targetX, targetY = x, y
+			case 1:
+				targetX, targetY = bounds.Dy()-1-y, x
+			case 2:
+				targetX, targetY = bounds.Dx()-1-x, bounds.Dy()-1-y
+			case 3:
+				targetX, targetY = y, bounds.Dx()-1-x
+			}
+			offset := output.PixOffset(targetX, targetY)
+			copy(output.Pix[offset:offset+4], row[4*x:4*x+4])
+		}
+	}
+
+	return output
+}
@@ -0,0 +1,69 @@
+package imageUtils
+
+/*
+This file tests the rotation of an image by quarter turns and by any angle, with the gradient of `resample_test.go`.
+*/
+
+import (
+	"image"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestRotateQuarterTurns(t *testing.T) {
+	img := gradient(16, 10)
+
+	rotated := RotateQuarterTurns(img, 1)
+	if size := rotated.Bounds().Size(); size != image.Pt(10, 16) {
+		t.Fatalf("quarter turn of a 16x10 image gave %v", size)
+	}
+	// Clockwise, like the quarter turn of WarpAffine: the pixel (x, y) goes to (9 - y, x).
+	if got, expected := rotated.RGBAAt(9-3, 7), img.RGBAAt(7, 3); got != expected {
+		t.Fatalf("pixel (7, 3) went to %v, expected %v", got, expected)
+	}
+	if got, expected := RotateQuarterTurns(img, 2).RGBAAt(0, 0), img.RGBAAt(15, 9); got != expected {
+		t.Fatalf("half turn moved %v to the origin, expected %v", got, expected)
+	}
+
+	restored := RotateQuarterTurns(RotateQuarterTurns(img, 3), -3)
+	for i := range img.Pix {
+		if restored.Pix[i] != img.Pix[i] {
+			t.Fatalf("byte %d of an image turned back and forth is %d, expected %d", i, restored.Pix[i], img.Pix[i])
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	img := gradient(16, 10)
+
+	quarter, turned := Rotate(img, -90, Bilinear), RotateQuarterTurns(img, 3)
+	if quarter.Bounds() != turned.Bounds() || !slices.Equal(quarter.Pix, turned.Pix) {
+		t.Fatal("rotation by -90 degrees differs from three quarter turns")
+	}
+
+	square := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	if size := Rotate(square, 45, Bilinear).Bounds().Size(); size != image.Pt(142, 142) {
+		t.Fatalf("100x100 image turned by 45 degrees has a size of %v, expected 142x142", size)
+	}
+
+	for name, interpolation := range interpolations {
+		rotated := Rotate(img, 30, interpolation)
+		// 16x10 turned by 30 degrees: 16 cos 30 + 10 sin 30 = 18.86, 16 sin 30 + 10 cos 30 = 16.66.
+		if size := rotated.Bounds().Size(); size != image.Pt(19, 17) {
+			t.Fatalf("%s: 16x10 image turned by 30 degrees has a size of %v, expected 19x17", name, size)
+		}
+		if corner := rotated.RGBAAt(0, 0); corner.A != 0 {
+			t.Errorf("%s: corner outside of the rotated image is %v, expected transparent", name, corner)
+		}
+		// The center of the image stays at the center of the result.
+		matrix := RotationMatrix(img.Bounds(), 30, 19, 17)
+		if x, y := matrix[0][0]*7.5+matrix[0][1]*4.5+matrix[0][2], matrix[1][0]*7.5+matrix[1][1]*4.5+matrix[1][2]; math.Abs(x-9) > 1e-9 || math.Abs(y-8) > 1e-9 {
+			t.Errorf("%s: the center of the image went to (%g, %g), expected (9, 8)", name, x, y)
+		}
+		// Clockwise: the top edge of the image goes down to the right, from (4.75, 0.35) to (17.75, 7.85).
+		if above, below := rotated.RGBAAt(14, 3), rotated.RGBAAt(14, 8); above.A != 0 || below.A != 255 {
+			t.Errorf("%s: pixels above and below the top edge are %v and %v, expected transparent and opaque", name, above, below)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package imageUtils
+
+/*
+Package imageUtils provides the "scanned document" look of a cropped page: its paper, grayish or yellowish in a photo
+and unevenly lit, is flattened to white, the contrast of its print is boosted, and the page can be binarized into pure
+black and white, as document scanning apps do.
+
+---
+
+### Constants
+- `scanWhitePoint`: Share of the color of the paper above which a pixel becomes pure white, wiping out the texture
+  of the paper and the faint show-through of the back of the page.
+- `scanBlackPercentile`: Percentile of the gray levels of the flattened page stretched to black: the darkest print.
+- `maxScanBlack`: Lightest gray level stretched to black, so the paper of a page with little or no print is not
+  stretched with it.
+- `scanContrast`: Exponent of the curve darkening the stretched print, the faint print and pencil included.
+
+---
+
+### ScanDocument(img image.Image, binarize bool) *image.RGBA
+Returns the copy of a cropped page with the look of a scan.
+
+- **Behavior**:
+  1. Estimates the color of the paper across the page, as `CleanWhiteboard` does for a board (see `boardBackground`),
+     so the shadows and the light gradients of the photo are followed, and divides every pixel by the color of the
+     paper under it: the paper becomes white whatever its color and its lighting. The shares above `scanWhitePoint`
+     are white.
+  2. Stretches the levels so the `scanBlackPercentile` of the gray levels of the page (at most `maxScanBlack`)
+     becomes black, and darkens them with the `scanContrast` curve. The colors of the print are kept.
+  3. If `binarize` is true, every pixel becomes black or white, split at the threshold of Otsu's method on the gray
+     levels of the page (see `Histogram.Otsu`): the smallest result, for archiving or fax-like printing. A blank page
+     stays white.
+
+---
+
+### luminance(pixel []uint8) uint8
+Returns the gray level of the red, green and blue values of `pixel`, weighted as in `Grayscale`.
+
+---
+
+### Example Usage:
+```go
+scan := imageUtils.ScanDocument(croppedPage, true)
+imageUtils.SaveImage(scan, "page.png", "png")
+```
+*/
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+const (
+	scanWhitePoint      = 0.85
+	scanBlackPercentile = 1
+	maxScanBlack        = 128
+	scanContrast        = 1.5
+)
+
+func ScanDocument(img image.Image, binarize bool) *image.RGBA {
+	bounds := img.Bounds()
+	output := image.NewRGBA(bounds)
+	if bounds.Empty() {
+		return output
+	}
+	draw.Draw(output, bounds, img, bounds.Min, draw.Src)
+
+	cell := max(max(bounds.Dx(), bounds.Dy())/boardCells, minBoardCell)
+	columns := (bounds.Dx() + cell - 1) / cell
+	rows := (bounds.Dy() + cell - 1) / cell
+	background := boardBackground(output, cell, columns, rows)
+
+	var histogram Histogram
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := output.Pix[output.PixOffset(bounds.Min.X, y):output.PixOffset(bounds.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			paper := boardColor(background, columns, rows, cell, i/4, y-bounds.Min.Y)
+			for c := 0; c < 3; c++ {
+				row[i+c] = uint8(math.Round(255 * min(float64(row[i+c])/max(paper[c], 1)/scanWhitePoint, 1)))
+			}
+			row[i+3] = 255
+			histogram[luminance(row[i:i+3])]++
+		}
+	}
+
+	black := float64(min(histogram.Percentile(scanBlackPercentile), maxScanBlack))
+	var curve [256]uint8
+	for value := range curve {
+		stretched := min(max((float64(value)-black)/max(255-black, 1), 0), 1)
+		curve[value] = uint8(math.Round(255 * math.Pow(stretched, scanContrast)))
+	}
+
+	var threshold uint8
+	if binarize {
+		var stretched Histogram
+		for value, count := range histogram {
+			stretched[curve[value]] += count
+		}
+		threshold = stretched.Otsu()
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := output.Pix[output.PixOffset(bounds.Min.X, y):output.PixOffset(bounds.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			if !binarize {
+				for c := 0; c < 3; c++ {
+					row[i+c] = curve[row[i+c]]
+				}
+				continue
+			}
+			var value uint8
+			if curve[luminance(row[i:i+3])] > threshold {
+				value = 255
+			}
+			row[i], row[i+1], row[i+2] = value, value, value
+		}
+	}
+
+	return output
+}
+
+func luminance(pixel []uint8) uint8 {
+	return uint8(0.299*float64(pixel[0]) + 0.587*float64(pixel[1]) + 0.114*float64(pixel[2]))
+}
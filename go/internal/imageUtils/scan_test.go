@@ -0,0 +1,63 @@
+package imageUtils
+
+/*
+This file tests the look of a scan given to a photographed page: yellowish paper lit unevenly, with lines of print,
+must come out on white paper with dark print, and in pure black and white once binarized.
+*/
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func photographedPage() *image.RGBA {
+	page := image.NewRGBA(image.Rect(0, 0, 300, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 300; x++ {
+			// The light fades from the left of the page to its right, down to 60%.
+			light := 1 - 0.4*float64(x)/300
+			pixel := color.RGBA{R: uint8(225 * light), G: uint8(215 * light), B: uint8(180 * light), A: 255}
+			if y%20 < 4 && x > 20 && x < 280 {
+				pixel = color.RGBA{R: uint8(70 * light), G: uint8(70 * light), B: uint8(75 * light), A: 255}
+			}
+			page.SetRGBA(x, y, pixel)
+		}
+	}
+	return page
+}
+
+func TestScanDocument(t *testing.T) {
+	page := photographedPage()
+
+	scan := ScanDocument(page, false)
+	for _, x := range []int{40, 260} {
+		if paper := scan.RGBAAt(x, 10); paper.R < 250 || paper.G < 250 || paper.B < 250 {
+			t.Errorf("paper at x=%d is %v once scanned, expected white", x, paper)
+		}
+		if print := scan.RGBAAt(x, 41); print.R > 40 || print.G > 40 || print.B > 40 {
+			t.Errorf("print at x=%d is %v once scanned, expected nearly black", x, print)
+		}
+	}
+
+	binarized := ScanDocument(page, true)
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 300; x++ {
+			pixel := binarized.RGBAAt(x, y)
+			if (pixel.R != 0 && pixel.R != 255) || pixel.G != pixel.R || pixel.B != pixel.R {
+				t.Fatalf("pixel (%d, %d) is %v once binarized, expected black or white", x, y, pixel)
+			}
+		}
+	}
+	if print := binarized.RGBAAt(150, 21); print.R != 0 {
+		t.Errorf("print is %v once binarized, expected black", print)
+	}
+
+	blank := image.NewRGBA(image.Rect(0, 0, 50, 50))
+	for i := range blank.Pix {
+		blank.Pix[i] = 200
+	}
+	if paper := ScanDocument(blank, true).RGBAAt(25, 25); paper.R != 255 {
+		t.Errorf("blank page is %v once binarized, expected white", paper)
+	}
+}
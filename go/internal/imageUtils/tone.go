@@ -0,0 +1,131 @@
+package imageUtils
+
+/*
+Package imageUtils provides the tone adjustments of an image: its gamma, brightness and contrast. They are applied
+through a tone curve, a table giving the new value of every 8-bit level, so a chain of adjustments costs a single pass
+over the pixels, on a color image or on the grayscale image of the edge detection.
+
+---
+
+### ToneCurve
+New value of every 8-bit level of a channel, indexed by the old value.
+
+- **Methods**:
+  - `Then(next ToneCurve) ToneCurve`: Returns the curve applying `curve`, then `next`.
+  - `Apply(img image.Image) *image.RGBA`: Returns a copy of `img` whose red, green and blue values are mapped through
+    the curve. The alpha values are kept.
+  - `ApplyGray(gray *image.Gray)`: Maps, in place, the levels of `gray` through the curve.
+
+### IdentityCurve() ToneCurve
+Returns the curve leaving every level unchanged.
+
+### GammaCurve(gamma float64) ToneCurve
+Returns the curve of a gamma correction: a level `v` of 0 to 1 becomes `v^(1/gamma)`. A gamma above 1 brightens the
+mid-tones, e.g. those of an underexposed photo, below 1 darkens them; black and white are kept. A gamma of 0 or less
+leaves the levels unchanged.
+
+### BrightnessContrastCurve(brightness, contrast float64) ToneCurve
+Returns the curve scaling the levels away from the mid-gray by `1 + contrast`, then shifting them by `brightness`
+times the full range, clamped to 0-255.
+
+- **Parameters**:
+  - `brightness`: From -1 (every level black) to 1 (every level white), 0 leaves the levels unchanged.
+  - `contrast`: From -1 (every level mid-gray) to 1 (twice the contrast), 0 leaves the levels unchanged.
+
+### ApplyGamma(img image.Image, gamma float64) *image.RGBA
+Returns a copy of `img` with the gamma correction of `GammaCurve`.
+
+### AdjustBrightnessContrast(img image.Image, brightness, contrast float64) *image.RGBA
+Returns a copy of `img` with the brightness and contrast of `BrightnessContrastCurve`.
+
+---
+
+### Example Usage:
+```go
+brightened := imageUtils.ApplyGamma(photo, 1.8)
+punchy := imageUtils.AdjustBrightnessContrast(brightened, 0.05, 0.3)
+
+// Both in one pass, or on the grayscale image of the edge detection.
+curve := imageUtils.GammaCurve(1.8).Then(imageUtils.BrightnessContrastCurve(0.05, 0.3))
+gray := imageUtils.Grayscale(photo)
+curve.ApplyGray(gray)
+```
+*/
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+type ToneCurve [256]uint8
+
+func IdentityCurve() ToneCurve {
+	var curve ToneCurve
+	for value := range curve {
+		curve[value] = uint8(value)
+	}
+	return curve
+}
+
+func GammaCurve(gamma float64) ToneCurve {
+	if gamma <= 0 {
+		return IdentityCurve()
+	}
+	var curve ToneCurve
+	for value := range curve {
+		curve[value] = uint8(math.Round(255 * math.Pow(float64(value)/255, 1/gamma)))
+	}
+	return curve
+}
+
+func BrightnessContrastCurve(brightness, contrast float64) ToneCurve {
+	var curve ToneCurve
+	for value := range curve {
+		adjusted := (float64(value)-127.5)*(1+contrast) + 127.5 + 255*brightness
+		curve[value] = uint8(math.Round(min(max(adjusted, 0), 255)))
+	}
+	return curve
+}
+
+func (curve ToneCurve) Then(next ToneCurve) ToneCurve {
+	var combined ToneCurve
+	for value := range combined {
+		combined[value] = next[curve[value]]
+	}
+	return combined
+}
+
+func (curve *ToneCurve) Apply(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	output := image.NewRGBA(bounds)
+	draw.Draw(output, bounds, img, bounds.Min, draw.Src)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := output.Pix[output.PixOffset(bounds.Min.X, y):output.PixOffset(bounds.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			row[i], row[i+1], row[i+2] = curve[row[i]], curve[row[i+1]], curve[row[i+2]]
+		}
+	}
+	return output
+}
+
+func (curve *ToneCurve) ApplyGray(gray *image.Gray) {
+	bounds := gray.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := gray.Pix[gray.PixOffset(bounds.Min.X, y):gray.PixOffset(bounds.Max.X, y)]
+		for x, value := range row {
+			row[x] = curve[value]
+		}
+	}
+}
+
+func ApplyGamma(img image.Image, gamma float64) *image.RGBA {
+	curve := GammaCurve(gamma)
+	return curve.Apply(img)
+}
+
+func AdjustBrightnessContrast(img image.Image, brightness, contrast float64) *image.RGBA {
+	curve := BrightnessContrastCurve(brightness, contrast)
+	return curve.Apply(img)
+}
@@ -0,0 +1,55 @@
+package imageUtils
+
+/*
+This file tests the tone adjustments: the levels they give to a few known values, and their application to the
+channels of a color image, whose alpha is kept.
+*/
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestToneCurves(t *testing.T) {
+	tests := []struct {
+		name     string
+		curve    ToneCurve
+		expected map[uint8]uint8
+	}{
+		{"identity", IdentityCurve(), map[uint8]uint8{0: 0, 100: 100, 255: 255}},
+		{"no gamma", GammaCurve(0), map[uint8]uint8{0: 0, 100: 100, 255: 255}},
+		{"gamma 2", GammaCurve(2), map[uint8]uint8{0: 0, 64: 128, 255: 255}},
+		{"gamma 0.5", GammaCurve(0.5), map[uint8]uint8{0: 0, 128: 64, 255: 255}},
+		{"brightness", BrightnessContrastCurve(0.2, 0), map[uint8]uint8{0: 51, 100: 151, 230: 255}},
+		{"contrast", BrightnessContrastCurve(0, 1), map[uint8]uint8{0: 0, 64: 1, 128: 129, 200: 255}},
+		{"flat", BrightnessContrastCurve(0, -1), map[uint8]uint8{0: 128, 255: 128}},
+		{"chained", GammaCurve(2).Then(BrightnessContrastCurve(-0.1, 0)), map[uint8]uint8{64: 103, 255: 230}},
+	}
+	for _, test := range tests {
+		for value, expected := range test.expected {
+			if actual := test.curve[value]; actual != expected {
+				t.Errorf("%s: level %d becomes %d, expected %d", test.name, value, actual, expected)
+			}
+		}
+	}
+}
+
+func TestApplyGamma(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 64, G: 128, B: 255, A: 255})
+	img.SetNRGBA(1, 0, color.NRGBA{A: 255})
+
+	adjusted := ApplyGamma(img, 2)
+	if pixel := adjusted.RGBAAt(0, 0); pixel != (color.RGBA{R: 128, G: 181, B: 255, A: 255}) {
+		t.Errorf("pixel is %v once corrected, expected {128 181 255 255}", pixel)
+	}
+	if pixel := adjusted.RGBAAt(1, 0); pixel != (color.RGBA{A: 255}) {
+		t.Errorf("black pixel is %v once corrected, expected black", pixel)
+	}
+
+	flat := AdjustBrightnessContrast(img, 0, -1)
+	if pixel := flat.RGBAAt(0, 0); pixel != (color.RGBA{R: 128, G: 128, B: 128, A: 255}) {
+		t.Errorf("pixel is %v without contrast, expected mid-gray", pixel)
+	}
+}
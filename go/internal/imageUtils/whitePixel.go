@@ -5,27 +5,28 @@ Package imageUtils provides utility functions for image processing, including pi
 
 ---
 
-### IsWhite(img *image.Gray, x, y int) bool
-Checks if the pixel at (x, y) in a grayscale image is considered "white".
+### IsForeground(img *image.Gray, x, y int, threshold uint8) bool
+Checks if the pixel at (x, y) in a grayscale image is part of the foreground.
 
 - **Parameters**:
   - img: A grayscale image (`*image.Gray`) where pixels are evaluated.
   - x: The x-coordinate of the pixel.
   - y: The y-coordinate of the pixel.
+  - threshold: The gray level the foreground is brighter than, e.g. the `Otsu` threshold of the histogram of `img`.
 - **Returns**:
-  - A boolean value (`true` if the pixel is "white", otherwise `false`).
+  - A boolean value (`true` if the pixel is in the foreground, otherwise `false`).
 
 - **Behavior**:
   - Accesses the pixel value at the specified coordinates.
-  - Compares the grayscale value (`Y`) to 128 (on a scale of 0 to 255).
-  - If `Y > 128`, the pixel is considered "white" and the function returns `true`.
-  - Otherwise, the function returns `false`.
+  - If `Y > threshold`, the pixel is in the foreground and the function returns `true`.
+  - Otherwise, the function returns `false`, as for the coordinates outside the image.
 
 ---
 
 ### Key Features:
 - Provides a simple way to evaluate pixel brightness in grayscale images.
-- Threshold-based approach ensures consistency across different images.
+- The threshold is chosen by the caller: 128 for a binary image, lower for an edge map whose weak edges count, e.g.
+  the 75 of the weak edges of a three-level Canny edge map.
 
 ---
 
@@ -47,15 +48,15 @@ func main() {
 	// Set a pixel to a specific gray value
 	img.SetGray(5, 5, color.Gray{Y: 200})
 
-	// Check if the pixel at (5, 5) is "white"
-	isWhite := imageUtils.IsWhite(img, 5, 5)
-	fmt.Printf("Is the pixel at (5, 5) white? %v\n", isWhite) // Output: true
+	// Check if the pixel at (5, 5) is in the foreground
+	isForeground := imageUtils.IsForeground(img, 5, 5, 128)
+	fmt.Printf("Is the pixel at (5, 5) in the foreground? %v\n", isForeground) // Output: true
 }
 ```
 */
 
 import "image"
 
-func IsWhite(img *image.Gray, x, y int) bool {
-	return img.GrayAt(x, y).Y > 128
+func IsForeground(img *image.Gray, x, y int, threshold uint8) bool {
+	return img.GrayAt(x, y).Y > threshold
 }
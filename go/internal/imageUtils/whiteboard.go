@@ -0,0 +1,136 @@
+package imageUtils
+
+/*
+Package imageUtils provides the cleanup of a photographed whiteboard: the uneven lighting of the board and its
+reflections are flattened into a white background, and the marker strokes are darkened and saturated, as whiteboard
+scanning apps do.
+
+---
+
+### Constants
+- `boardCells`: Number of cells of the background grid along the longer side of the image.
+- `minBoardCell`: Smallest side of a cell of the background grid, in pixels.
+- `backgroundPercentile`: Percentile of every channel of a cell taken as the color of the bare board: the strokes
+  cover only a small part of a board, the brightest pixels of a cell are the board itself.
+- `whitePoint`: Share of the color of the board above which a pixel becomes pure white, wiping out the ghosts of
+  erased strokes and the noise of the board.
+- `strokeGamma`: Exponent of the curve darkening the flattened strokes, faint markers included.
+- `saturationBoost`: Factor of the distance of every channel to the gray of a pixel, bringing back the colors of
+  the markers washed out by the flattening.
+
+---
+
+### CleanWhiteboard(img image.Image) *image.RGBA
+Returns the cleaned-up copy of a photographed whiteboard, already cropped to the board.
+
+- **Behavior**:
+  1. Estimates the color of the bare board across the image: the `backgroundPercentile` of every channel in the
+     cells of a grid, interpolated bilinearly between the centers of the cells, so shadows and light gradients are
+     followed.
+  2. Divides every pixel by the color of the board under it, which makes the board white whatever its lighting, and
+     maps the shares above `whitePoint` to white.
+  3. Darkens the strokes with the `strokeGamma` curve and multiplies their saturation by `saturationBoost`.
+
+### boardBackground(img *image.RGBA, cell, columns, rows int) [][3]float64
+Returns the color of the board in every cell of the grid, row by row.
+
+### boardColor(background [][3]float64, columns, rows, cell, x, y int) [3]float64
+Interpolates the color of the board at (x, y), relative to the origin of the image.
+
+---
+
+### Example Usage:
+```go
+board := imageUtils.CleanWhiteboard(croppedBoard)
+imageUtils.SaveImage(board, "board.png", "png")
+```
+*/
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+const (
+	boardCells           = 24
+	minBoardCell         = 8
+	backgroundPercentile = 90
+	whitePoint           = 0.9
+	strokeGamma          = 2
+	saturationBoost      = 1.8
+)
+
+func CleanWhiteboard(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	output := image.NewRGBA(bounds)
+	if bounds.Empty() {
+		return output
+	}
+	draw.Draw(output, bounds, img, bounds.Min, draw.Src)
+
+	cell := max(max(bounds.Dx(), bounds.Dy())/boardCells, minBoardCell)
+	columns := (bounds.Dx() + cell - 1) / cell
+	rows := (bounds.Dy() + cell - 1) / cell
+	background := boardBackground(output, cell, columns, rows)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := output.Pix[output.PixOffset(bounds.Min.X, y):output.PixOffset(bounds.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			board := boardColor(background, columns, rows, cell, i/4, y-bounds.Min.Y)
+
+			var channels [3]float64
+			for c := range channels {
+				share := min(float64(row[i+c])/max(board[c], 1)/whitePoint, 1)
+				channels[c] = math.Pow(share, strokeGamma)
+			}
+
+			gray := 0.299*channels[0] + 0.587*channels[1] + 0.114*channels[2]
+			for c, value := range channels {
+				value = gray + (value-gray)*saturationBoost
+				row[i+c] = uint8(math.Round(255 * min(max(value, 0), 1)))
+			}
+			row[i+3] = 255
+		}
+	}
+
+	return output
+}
+
+func boardBackground(img *image.RGBA, cell, columns, rows int) [][3]float64 {
+	bounds := img.Bounds()
+	background := make([][3]float64, columns*rows)
+	for row := 0; row < rows; row++ {
+		for column := 0; column < columns; column++ {
+			var histograms [3]Histogram
+			area := image.Rect(column*cell, row*cell, (column+1)*cell, (row+1)*cell).Add(bounds.Min).Intersect(bounds)
+			eachPixel(img.SubImage(area), func(r, g, b uint8) {
+				histograms[0][r]++
+				histograms[1][g]++
+				histograms[2][b]++
+			})
+			for c, histogram := range histograms {
+				background[row*columns+column][c] = float64(histogram.Percentile(backgroundPercentile))
+			}
+		}
+	}
+	return background
+}
+
+func boardColor(background [][3]float64, columns, rows, cell, x, y int) [3]float64 {
+	position := func(coordinate, cells int) (int, int, float64) {
+		center := min(max((float64(coordinate)+0.5)/float64(cell)-0.5, 0), float64(cells-1))
+		first := int(center)
+		return first, min(first+1, cells-1), center - float64(first)
+	}
+	x0, x1, tx := position(x, columns)
+	y0, y1, ty := position(y, rows)
+
+	var color [3]float64
+	for c := range color {
+		top := background[y0*columns+x0][c]*(1-tx) + background[y0*columns+x1][c]*tx
+		bottom := background[y1*columns+x0][c]*(1-tx) + background[y1*columns+x1][c]*tx
+		color[c] = top*(1-ty) + bottom*ty
+	}
+	return color
+}
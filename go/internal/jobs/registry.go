@@ -0,0 +1,492 @@
+package jobs
+
+/*
+Package jobs keeps track of the asynchronous jobs of the server and persists their results to a storage (see
+`internal/storage`): a local directory, or a bucket shared by several server instances.
+
+---
+
+### Job
+State of an asynchronous job.
+
+- **Fields**:
+  - `ID`: Random identifier returned to the client.
+  - `Client`: Name of the API key which created the job, empty if authentication is disabled.
+  - `Status`: `protocol.StatusPending`, `protocol.StatusRunning`, `protocol.StatusDone` or `protocol.StatusFailed`.
+  - `Error`: Why the job failed.
+  - `Format`: Format of the result image.
+  - `Request`: Header of the request which created the job, used to process it again after a restart.
+  - `Timings`: Time spent in every stage of the processing of a finished job.
+  - `Colors`: Color statistics of the document cropped by a finished job, nil for the other operations.
+  - `Text`: Text recognized on the document of a finished job, if its request asked for it.
+  - `Rotation`: Rotation turning the document of a finished job upright, if its request asked for it.
+  - `Result`: Hash of the result of a finished job in the content-addressed store (see `storage.ContentStore`).
+    Empty for the jobs finished before the results were deduplicated, whose result is the object `<id>.result`.
+  - `Created`, `Updated`: Creation time and time of the last state change.
+
+- **Methods**:
+  - `finished() bool`: Whether the job is done or failed.
+
+---
+
+### Registry
+Stores the jobs in memory and in a storage: every job is described by the object `<id>.json`, and its result is
+written to the content-addressed store of the storage once it is done (see `storage.ContentStore`), referenced by
+the job: jobs giving the same result, e.g. the same page sent again, share a single copy of it, removed with the last
+of them. Jobs already present in the storage are loaded when the registry is
+created, so the results of finished jobs are still available after a restart.
+
+When spooling is enabled, the input image of every job is also written to `<id>.input` until the job completes
+or fails. Jobs which were pending or running when the server stopped are then pending again after a restart, and
+returned by `Recover` so they can be processed. Without spooling, they are marked as failed.
+
+A registry opened in standby mode shares the storage with another server process which is still running, e.g. the
+process handing its listeners over to a new one during an upgrade: the jobs pending or running in the storage
+belong to that process and are left alone. Their state is read again from the storage whenever they are queried,
+and so are the jobs it creates meanwhile, until `Takeover` is called once it has exited.
+
+- **Methods**:
+  - `Create(client string, request protocol.Header, input []byte) (Job, error)`: Registers a new pending job of
+    `client`, and spools its input if spooling is enabled.
+  - `Start(id string)`: Marks a job as running.
+  - `Complete(id string, format string, result []byte, timings []protocol.StageTiming, colors *protocol.ColorStats,
+    text string, rotation int) error`: Persists the result of a job with its timings, color statistics, recognized
+    text and the rotation turning its document upright, and marks it as done.
+  - `Fail(id string, err error)`: Marks a job as failed.
+  - `Get(id string) (Job, bool)`: Returns the state of a job.
+  - `Result(id string) ([]byte, error)`: Reads the result of a finished job.
+  - `Input(id string) ([]byte, error)`: Reads the spooled input of a job.
+  - `ResultURL(id string, expiry time.Duration) (string, error)`: Returns a URL reading the result of a finished
+    job directly from the storage, valid for `expiry`. Fails with `errors.ErrUnsupported` if the storage does not
+    give such URLs (see `storage.Linker`).
+  - `Recover() []Job`: Returns the jobs interrupted by the last restart, once.
+  - `Expire()`: Removes the jobs which were last updated more than `ttl` ago, with their objects.
+  - `Run(ctx context.Context)`: Calls `Expire` periodically until the context is cancelled.
+  - `Takeover() error`: Leaves the standby mode once the other process has exited: loads the jobs it left, and
+    recovers the ones it did not finish like a restart does.
+
+---
+
+### NewRegistry(store storage.Storage, ttl time.Duration, spool bool) (*Registry, error)
+Creates the registry and loads the jobs of the storage.
+
+### NewStandbyRegistry(store storage.Storage, ttl time.Duration, spool bool) (*Registry, error)
+Creates the registry in standby mode and loads the jobs of the storage.
+
+---
+
+### Example Usage:
+```go
+store, _ := storage.NewLocal("jobs")
+registry, err := jobs.NewRegistry(store, 24*time.Hour, true)
+if err != nil {
+	log.Fatal(err)
+}
+go registry.Run(ctx)
+
+for _, job := range registry.Recover() {
+	input, _ := registry.Input(job.ID)
+	// process input again
+}
+
+job, _ := registry.Create("scanner-1", header, input)
+registry.Start(job.ID)
+registry.Complete(job.ID, "png", data, nil, nil, "", 0)
+```
+*/
+
+import (
+	"ELP-project/internal/protocol"
+	"ELP-project/internal/storage"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	metadataExtension = ".json"
+	resultExtension   = ".result"
+	inputExtension    = ".input"
+)
+
+var ErrNotFound = errors.New("jobs: job not found")
+
+type Job struct {
+	ID       string                 `json:"id"`
+	Client   string                 `json:"client,omitempty"`
+	Status   string                 `json:"status"`
+	Error    string                 `json:"error,omitempty"`
+	Format   string                 `json:"format,omitempty"`
+	Request  protocol.Header        `json:"request"`
+	Timings  []protocol.StageTiming `json:"timings,omitempty"`
+	Colors   *protocol.ColorStats   `json:"colors,omitempty"`
+	Text     string                 `json:"text,omitempty"`
+	Rotation int                    `json:"rotation,omitempty"`
+	Result   string                 `json:"result,omitempty"`
+	Created  time.Time              `json:"created"`
+	Updated  time.Time              `json:"updated"`
+}
+
+type Registry struct {
+	store   storage.Storage
+	content *storage.ContentStore
+	ttl     time.Duration
+	spool   bool
+
+	mutex     sync.Mutex
+	jobs      map[string]*Job
+	recovered []Job
+	standby   bool
+	foreign   map[string]bool
+}
+
+func (job *Job) finished() bool {
+	return job.Status == protocol.StatusDone || job.Status == protocol.StatusFailed
+}
+
+func NewRegistry(store storage.Storage, ttl time.Duration, spool bool) (*Registry, error) {
+	return openRegistry(store, ttl, spool, false)
+}
+
+func NewStandbyRegistry(store storage.Storage, ttl time.Duration, spool bool) (*Registry, error) {
+	return openRegistry(store, ttl, spool, true)
+}
+
+func openRegistry(store storage.Storage, ttl time.Duration, spool bool, standby bool) (*Registry, error) {
+	registry := &Registry{
+		store:   store,
+		content: storage.NewContentStore(store),
+		ttl:     ttl,
+		spool:   spool,
+		jobs:    make(map[string]*Job),
+		standby: standby,
+		foreign: make(map[string]bool),
+	}
+
+	if err := registry.load(); err != nil {
+		return nil, err
+	}
+	registry.Expire()
+
+	return registry, nil
+}
+
+func (registry *Registry) Create(client string, request protocol.Header, input []byte) (Job, error) {
+	id, err := newID()
+	if err != nil {
+		return Job{}, err
+	}
+
+	if registry.spool {
+		if err := registry.store.Put(id+inputExtension, input); err != nil {
+			return Job{}, fmt.Errorf("writing job input: %w", err)
+		}
+	}
+
+	now := time.Now()
+	job := &Job{
+		ID:      id,
+		Client:  client,
+		Status:  protocol.StatusPending,
+		Request: request,
+		Created: now,
+		Updated: now,
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	registry.jobs[id] = job
+	if err := registry.save(job); err != nil {
+		delete(registry.jobs, id)
+		return Job{}, err
+	}
+
+	return *job, nil
+}
+
+func (registry *Registry) Start(id string) {
+	registry.update(id, func(job *Job) {
+		job.Status = protocol.StatusRunning
+	})
+}
+
+func (registry *Registry) Complete(id string, format string, result []byte, timings []protocol.StageTiming, colors *protocol.ColorStats, text string, rotation int) error {
+	hash, err := registry.content.Put(id, result)
+	if err != nil {
+		registry.Fail(id, err)
+		return fmt.Errorf("writing job result: %w", err)
+	}
+
+	registry.update(id, func(job *Job) {
+		job.Status = protocol.StatusDone
+		job.Result = hash
+		job.Format = format
+		job.Timings = timings
+		job.Colors = colors
+		job.Text = text
+		job.Rotation = rotation
+	})
+	registry.remove(id, inputExtension)
+	return nil
+}
+
+func (registry *Registry) Fail(id string, err error) {
+	registry.update(id, func(job *Job) {
+		job.Status = protocol.StatusFailed
+		job.Error = err.Error()
+	})
+	registry.remove(id, inputExtension)
+}
+
+func (registry *Registry) Get(id string) (Job, bool) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	job, ok := registry.jobs[id]
+	if registry.standby && (!ok || registry.foreign[id] && !job.finished()) {
+		job, ok = registry.fetch(id)
+	}
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+func (registry *Registry) Result(id string) ([]byte, error) {
+	job, ok := registry.Get(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if job.Status != protocol.StatusDone {
+		return nil, fmt.Errorf("jobs: job %s is %s", id, job.Status)
+	}
+
+	if job.Result != "" {
+		return registry.content.Get(job.Result)
+	}
+	return registry.store.Get(id + resultExtension)
+}
+
+func (registry *Registry) Input(id string) ([]byte, error) {
+	if _, ok := registry.Get(id); !ok {
+		return nil, ErrNotFound
+	}
+
+	return registry.store.Get(id + inputExtension)
+}
+
+func (registry *Registry) ResultURL(id string, expiry time.Duration) (string, error) {
+	job, ok := registry.Get(id)
+	if !ok {
+		return "", ErrNotFound
+	}
+	if job.Status != protocol.StatusDone {
+		return "", fmt.Errorf("jobs: job %s is %s", id, job.Status)
+	}
+
+	linker, ok := registry.store.(storage.Linker)
+	if !ok {
+		return "", errors.ErrUnsupported
+	}
+	if job.Result != "" {
+		return linker.URL(registry.content.Key(job.Result), expiry)
+	}
+	return linker.URL(id+resultExtension, expiry)
+}
+
+func (registry *Registry) Recover() []Job {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	recovered := registry.recovered
+	registry.recovered = nil
+	return recovered
+}
+
+func (registry *Registry) Expire() {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	for id, job := range registry.jobs {
+		if time.Since(job.Updated) < registry.ttl {
+			continue
+		}
+		if job.Status == protocol.StatusPending || job.Status == protocol.StatusRunning {
+			continue
+		}
+
+		delete(registry.jobs, id)
+		if job.Result != "" {
+			if err := registry.content.Release(id, job.Result); err != nil {
+				log.Printf("Error releasing the result of job %s: %v", id, err)
+			}
+		}
+		for _, extension := range []string{metadataExtension, resultExtension, inputExtension} {
+			registry.remove(id, extension)
+		}
+		log.Printf("Job %s expired", id)
+	}
+}
+
+func (registry *Registry) Takeover() error {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	if !registry.standby {
+		return nil
+	}
+	registry.standby = false
+
+	err := registry.load()
+	clear(registry.foreign)
+	return err
+}
+
+func (registry *Registry) Run(ctx context.Context) {
+	interval := registry.ttl / 10
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			registry.Expire()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (registry *Registry) update(id string, change func(job *Job)) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	job, ok := registry.jobs[id]
+	if !ok {
+		return
+	}
+
+	change(job)
+	job.Updated = time.Now()
+
+	if err := registry.save(job); err != nil {
+		log.Printf("Error saving job %s: %v", id, err)
+	}
+}
+
+func (registry *Registry) remove(id string, extension string) {
+	if err := registry.store.Delete(id + extension); err != nil {
+		log.Printf("Error removing job object: %v", err)
+	}
+}
+
+func (registry *Registry) save(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	if err := registry.store.Put(job.ID+metadataExtension, data); err != nil {
+		return fmt.Errorf("writing job metadata: %w", err)
+	}
+	return nil
+}
+
+func (registry *Registry) fetch(id string) (*Job, bool) {
+	data, err := registry.store.Get(id + metadataExtension)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotExist) {
+			log.Printf("Error reading job %s: %v", id, err)
+		}
+		return nil, false
+	}
+
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		log.Printf("Ignoring invalid job object %s: %v", id, err)
+		return nil, false
+	}
+
+	registry.jobs[id] = &job
+	registry.foreign[id] = true
+	return &job, true
+}
+
+func (registry *Registry) load() error {
+	keys, err := registry.store.List("")
+	if err != nil {
+		return fmt.Errorf("listing jobs: %w", err)
+	}
+
+	stored := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		stored[key] = true
+	}
+
+	for _, key := range keys {
+		if !strings.HasSuffix(key, metadataExtension) {
+			continue
+		}
+		id := strings.TrimSuffix(key, metadataExtension)
+		if _, ok := registry.jobs[id]; ok && !registry.foreign[id] {
+			continue
+		}
+
+		data, err := registry.store.Get(key)
+		if errors.Is(err, storage.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("reading job metadata: %w", err)
+		}
+
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			log.Printf("Ignoring invalid job object %s: %v", key, err)
+			continue
+		}
+
+		if registry.standby {
+			registry.foreign[job.ID] = true
+		} else if job.Status == protocol.StatusPending || job.Status == protocol.StatusRunning {
+			if registry.spool && stored[job.ID+inputExtension] {
+				job.Status = protocol.StatusPending
+				registry.recovered = append(registry.recovered, job)
+			} else {
+				job.Status = protocol.StatusFailed
+				job.Error = "server restarted before the job completed"
+				registry.remove(job.ID, inputExtension)
+			}
+			job.Updated = time.Now()
+			if err := registry.save(&job); err != nil {
+				return err
+			}
+		} else {
+			registry.remove(job.ID, inputExtension)
+		}
+
+		registry.jobs[job.ID] = &job
+	}
+
+	return nil
+}
+
+func newID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", fmt.Errorf("generating job id: %w", err)
+	}
+	return hex.EncodeToString(id[:]), nil
+}
@@ -0,0 +1,67 @@
+package jobs
+
+/*
+This file tests the registry over a local directory: a job keeps its client through a restart, with its result, and
+the jobs interrupted by the restart are pending again with their spooled input.
+*/
+
+import (
+	"ELP-project/internal/protocol"
+	"ELP-project/internal/storage"
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRegistryRestart(t *testing.T) {
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry, err := NewRegistry(store, time.Hour, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done, err := registry.Create("backoffice", protocol.Header{Async: true}, []byte("first page"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Start(done.ID)
+	if err := registry.Complete(done.ID, "png", []byte("cropped page"), nil, nil, "", 0); err != nil {
+		t.Fatal(err)
+	}
+	interrupted, err := registry.Create("scanner-1", protocol.Header{Async: true}, []byte("second page"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.Start(interrupted.ID)
+
+	restarted, err := NewRegistry(store, time.Hour, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, ok := restarted.Get(done.ID)
+	if !ok || job.Client != "backoffice" || job.Status != protocol.StatusDone {
+		t.Fatalf("finished job after a restart: %+v, expected done for backoffice", job)
+	}
+	if result, err := restarted.Result(done.ID); err != nil || !bytes.Equal(result, []byte("cropped page")) {
+		t.Fatalf("result after a restart %q (%v), expected the cropped page", result, err)
+	}
+
+	recovered := restarted.Recover()
+	if len(recovered) != 1 || recovered[0].ID != interrupted.ID || recovered[0].Client != "scanner-1" {
+		t.Fatalf("recovered jobs %+v, expected the interrupted job of scanner-1", recovered)
+	}
+	if recovered[0].Status != protocol.StatusPending {
+		t.Fatalf("interrupted job %s after a restart, expected %s", recovered[0].Status, protocol.StatusPending)
+	}
+	if input, err := restarted.Input(interrupted.ID); err != nil || !bytes.Equal(input, []byte("second page")) {
+		t.Fatalf("spooled input %q (%v), expected the second page", input, err)
+	}
+
+	if _, err := restarted.Result("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("result of an unknown job read with %v, expected %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,93 @@
+package netUtils
+
+/*
+This file provides pools of buffers reused across transfers and connections, so that large images are copied
+without allocating a new buffer for every request.
+
+---
+
+### CopyN(dst io.Writer, src io.Reader, n int64) (int64, error)
+Copies exactly `n` bytes from `src` to `dst`, like `io.CopyN`, through a pooled buffer of `copyBufferSize` bytes.
+When `dst` implements `io.ReaderFrom` (e.g. `bytes.Buffer`, `bufio.Writer`), the data is read directly into it.
+
+- **Returns**:
+  - The number of bytes copied, and `io.EOF` if `src` ended before `n` bytes were copied.
+
+### AcquireBuffer() *[]byte / ReleaseBuffer(buffer *[]byte)
+Takes a buffer of `copyBufferSize` bytes from the pool, and gives it back once it is no longer used.
+
+### AcquireReader(r io.Reader, size int) *bufio.Reader / ReleaseReader(reader *bufio.Reader)
+Takes a `bufio.Reader` of `size` bytes reading from `r` from the pool, and gives it back once the connection is
+closed. Readers of another size are not reused.
+
+### AcquireWriter(w io.Writer, size int) *bufio.Writer / ReleaseWriter(writer *bufio.Writer)
+Same as `AcquireReader` and `ReleaseReader` for `bufio.Writer`. The writer must be flushed before being released,
+unflushed data is dropped.
+*/
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+const copyBufferSize = 256 << 10
+
+var (
+	bufferPool = sync.Pool{
+		New: func() any {
+			buffer := make([]byte, copyBufferSize)
+			return &buffer
+		},
+	}
+	readerPool sync.Pool
+	writerPool sync.Pool
+)
+
+func CopyN(dst io.Writer, src io.Reader, n int64) (int64, error) {
+	buffer := AcquireBuffer()
+	defer ReleaseBuffer(buffer)
+
+	written, err := io.CopyBuffer(dst, io.LimitReader(src, n), *buffer)
+	if written == n {
+		return n, nil
+	}
+	if written < n && err == nil {
+		err = io.EOF
+	}
+	return written, err
+}
+
+func AcquireBuffer() *[]byte {
+	return bufferPool.Get().(*[]byte)
+}
+
+func ReleaseBuffer(buffer *[]byte) {
+	bufferPool.Put(buffer)
+}
+
+func AcquireReader(r io.Reader, size int) *bufio.Reader {
+	if reader, ok := readerPool.Get().(*bufio.Reader); ok && reader.Size() == size {
+		reader.Reset(r)
+		return reader
+	}
+	return bufio.NewReaderSize(r, size)
+}
+
+func ReleaseReader(reader *bufio.Reader) {
+	reader.Reset(nil)
+	readerPool.Put(reader)
+}
+
+func AcquireWriter(w io.Writer, size int) *bufio.Writer {
+	if writer, ok := writerPool.Get().(*bufio.Writer); ok && writer.Size() == size {
+		writer.Reset(w)
+		return writer
+	}
+	return bufio.NewWriterSize(w, size)
+}
+
+func ReleaseWriter(writer *bufio.Writer) {
+	writer.Reset(nil)
+	writerPool.Put(writer)
+}
@@ -0,0 +1,97 @@
+package netUtils
+
+/*
+Package netUtils provides helpers shared by the client and the server to tune their network connections.
+
+---
+
+### SocketOptions
+Socket-level settings applied to every TCP connection. Unix domain sockets have no such settings, only the
+user-space buffers apply to them.
+
+- **Fields**:
+  - `ReadBuffer`: Size of the kernel receive buffer (`SO_RCVBUF`), in bytes. 0 keeps the default of the system.
+  - `WriteBuffer`: Size of the kernel send buffer (`SO_SNDBUF`), in bytes. 0 keeps the default of the system.
+  - `NoDelay`: Whether `TCP_NODELAY` is set, i.e. whether small segments are sent without waiting (Nagle's
+    algorithm disabled).
+  - `IOBufferSize`: Size of the user-space buffers (`bufio`) wrapping the connection. Writes are coalesced in this
+    buffer and flushed once a complete message is written, reads are done by blocks of this size.
+
+- **Methods**:
+  - `Apply(conn net.Conn) error`: Applies the kernel settings to a connection. Connections other than TCP (e.g. Unix
+    domain sockets) are left unchanged.
+  - `RegisterFlags(flagSet *flag.FlagSet)`: Binds every field to a command-line flag of `flagSet`.
+
+---
+
+### DefaultSocketOptions() SocketOptions
+Returns the options used when no flag is given: default kernel buffers, `TCP_NODELAY` set (the default of Go) and
+64 KiB user-space buffers.
+
+---
+
+### Example Usage:
+```go
+options := netUtils.DefaultSocketOptions()
+options.RegisterFlags(flag.CommandLine)
+flag.Parse()
+
+conn, _ := net.Dial("tcp", address)
+if err := options.Apply(conn); err != nil {
+	log.Printf("Error tuning connection: %v", err)
+}
+writer := bufio.NewWriterSize(conn, options.IOBufferSize)
+```
+*/
+
+import (
+	"flag"
+	"fmt"
+	"net"
+)
+
+const defaultIOBufferSize = 64 << 10
+
+type SocketOptions struct {
+	ReadBuffer   int
+	WriteBuffer  int
+	NoDelay      bool
+	IOBufferSize int
+}
+
+func DefaultSocketOptions() SocketOptions {
+	return SocketOptions{
+		NoDelay:      true,
+		IOBufferSize: defaultIOBufferSize,
+	}
+}
+
+func (options SocketOptions) Apply(conn net.Conn) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+
+	if err := tcpConn.SetNoDelay(options.NoDelay); err != nil {
+		return fmt.Errorf("setting TCP_NODELAY: %w", err)
+	}
+	if options.ReadBuffer > 0 {
+		if err := tcpConn.SetReadBuffer(options.ReadBuffer); err != nil {
+			return fmt.Errorf("setting SO_RCVBUF: %w", err)
+		}
+	}
+	if options.WriteBuffer > 0 {
+		if err := tcpConn.SetWriteBuffer(options.WriteBuffer); err != nil {
+			return fmt.Errorf("setting SO_SNDBUF: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (options *SocketOptions) RegisterFlags(flagSet *flag.FlagSet) {
+	flagSet.IntVar(&options.ReadBuffer, "so-rcvbuf", options.ReadBuffer, "size of the socket receive buffer in bytes (system default if 0)")
+	flagSet.IntVar(&options.WriteBuffer, "so-sndbuf", options.WriteBuffer, "size of the socket send buffer in bytes (system default if 0)")
+	flagSet.BoolVar(&options.NoDelay, "nodelay", options.NoDelay, "disable Nagle's algorithm (TCP_NODELAY)")
+	flagSet.IntVar(&options.IOBufferSize, "io-buffer", options.IOBufferSize, "size of the buffers coalescing reads and writes, in bytes")
+}
@@ -0,0 +1,269 @@
+package ocr
+
+/*
+Package ocr recognizes the text of the cropped documents, so the scans can feed search or indexing systems directly.
+A `Recognizer` turns an image into text: the server runs the one it is given on the cropped documents of the requests
+asking for it (`protocol.Header.OCR`), and returns the text in the metadata of the response. `Tesseract` is the
+reference implementation, running the `tesseract` command line program. A `WordRecognizer` also locates the words
+on the image, which the searchable PDFs lay as an invisible text layer over the document (see `internal/pdf`), and
+tells the confidence it has in each of them, which gives the orientation of the document (see `orientation.go`).
+
+---
+
+### `Recognizer`
+Recognizes the text of an image.
+
+- Methods:
+  - `Recognize(ctx context.Context, img image.Image) (string, error)`: Returns the text of `img`, one line of the
+    image per line, without the blank lines and the trailing spaces. Gives up when `ctx` is cancelled.
+
+### `Word`
+A word recognized on an image.
+
+- Fields:
+  - `Text`: The text of the word, without spaces.
+  - `Bounds`: The box of the word on the image, in pixels.
+  - `Line`: Index of the line of the word on the image, from 0, in reading order.
+  - `Confidence`: Confidence of the recognizer in the word, from 0 to 100.
+
+### `WordRecognizer`
+Recognizer locating the words of the text.
+
+- Methods:
+  - `RecognizeWords(ctx context.Context, img image.Image) ([]Word, error)`: Returns the words of `img` in reading
+    order. `JoinWords` gives the text of the words.
+
+### `Tesseract`
+Recognizer running the Tesseract OCR engine (https://github.com/tesseract-ocr/tesseract) as a separate process: the
+image is written as PNG to its standard input, and the text read from its standard output.
+
+- Fields:
+  - `Command`: Path or name of the `tesseract` program, looked up in the `PATH` if it has no slash.
+  - `Language`: Languages of the text, as trained data names joined by `+`, e.g. `eng` or `eng+fra`. The default
+    language of Tesseract if empty.
+  - `PageSegmentation`: Page segmentation mode of Tesseract (`--psm`), `DefaultPageSegmentation` if 0, which
+    suits a page of text. 6 reads the image as a single block, e.g. a receipt.
+
+- Methods:
+  - `Recognize(ctx context.Context, img image.Image) (string, error)`: Implements `Recognizer`. Fails with the error
+    output of Tesseract if it cannot read the image, e.g. when the trained data of a language is missing.
+  - `RecognizeWords(ctx context.Context, img image.Image) ([]Word, error)`: Implements `WordRecognizer`, from the TSV
+    output of Tesseract, which gives the box of every word. The words Tesseract has no confidence in (-1) are
+    skipped.
+  - `run(ctx context.Context, img image.Image, configs ...string) (string, error)`: Runs Tesseract on `img` with
+    the output `configs` (e.g. `tsv`, plain text if none) and returns its output.
+  - `Check() error`: Checks that the program can be run and reads the languages, by asking it for its list of
+    languages, so a misconfigured server fails when it starts rather than on its first request.
+
+---
+
+### Constants
+- `DefaultCommand`: Name of the Tesseract program.
+- `DefaultPageSegmentation`: Fully automatic page segmentation (`--psm 3`).
+
+---
+
+### `NewTesseract(command string, language string) *Tesseract`
+Returns a recognizer running `command` (`DefaultCommand` if empty) for `language`.
+
+### `JoinWords(words []Word) string`
+Returns the text of `words`: the words of a line separated by spaces, one line per line.
+
+### `parseTSV(output string) ([]Word, error)`
+Parses the TSV output of Tesseract: a header, then a row per page, block, paragraph, line and word (level 5), whose
+columns are the level, the page, block, paragraph, line and word numbers, the box (left, top, width, height), the
+confidence and the text. The lines are numbered in the order their words come.
+
+### `cleanText(text string) string`
+Removes the trailing spaces of every line of `text`, and its blank lines, which Tesseract outputs between the
+blocks of a page and after the last one.
+
+---
+
+### Example Usage:
+```go
+recognizer := ocr.NewTesseract("", "eng")
+if err := recognizer.Check(); err != nil {
+	log.Fatal(err)
+}
+text, err := recognizer.Recognize(ctx, document)
+
+words, err := recognizer.RecognizeWords(ctx, document)
+fmt.Println(words[0].Text, words[0].Bounds, ocr.JoinWords(words) == text)
+```
+*/
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultCommand          = "tesseract"
+	DefaultPageSegmentation = 3
+)
+
+type Recognizer interface {
+	Recognize(ctx context.Context, img image.Image) (string, error)
+}
+
+type Word struct {
+	Text       string
+	Bounds     image.Rectangle
+	Line       int
+	Confidence float64
+}
+
+type WordRecognizer interface {
+	Recognizer
+	RecognizeWords(ctx context.Context, img image.Image) ([]Word, error)
+}
+
+type Tesseract struct {
+	Command          string
+	Language         string
+	PageSegmentation int
+}
+
+func NewTesseract(command string, language string) *Tesseract {
+	if command == "" {
+		command = DefaultCommand
+	}
+	return &Tesseract{Command: command, Language: language, PageSegmentation: DefaultPageSegmentation}
+}
+
+func (tesseract *Tesseract) Recognize(ctx context.Context, img image.Image) (string, error) {
+	output, err := tesseract.run(ctx, img)
+	if err != nil {
+		return "", err
+	}
+	return cleanText(output), nil
+}
+
+func (tesseract *Tesseract) RecognizeWords(ctx context.Context, img image.Image) ([]Word, error) {
+	output, err := tesseract.run(ctx, img, "tsv")
+	if err != nil {
+		return nil, err
+	}
+	return parseTSV(output)
+}
+
+func (tesseract *Tesseract) run(ctx context.Context, img image.Image, configs ...string) (string, error) {
+	var input bytes.Buffer
+	if err := png.Encode(&input, img); err != nil {
+		return "", err
+	}
+
+	segmentation := tesseract.PageSegmentation
+	if segmentation == 0 {
+		segmentation = DefaultPageSegmentation
+	}
+	args := []string{"stdin", "stdout", "--psm", strconv.Itoa(segmentation)}
+	if tesseract.Language != "" {
+		args = append(args, "-l", tesseract.Language)
+	}
+	args = append(args, configs...)
+
+	var output, errorOutput bytes.Buffer
+	command := exec.CommandContext(ctx, tesseract.Command, args...)
+	command.Stdin = &input
+	command.Stdout = &output
+	command.Stderr = &errorOutput
+	if err := command.Run(); err != nil {
+		if message := strings.TrimSpace(errorOutput.String()); message != "" {
+			return "", fmt.Errorf("tesseract: %w: %s", err, message)
+		}
+		return "", fmt.Errorf("tesseract: %w", err)
+	}
+	return output.String(), nil
+}
+
+func (tesseract *Tesseract) Check() error {
+	output, err := exec.Command(tesseract.Command, "--list-langs").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("running %s: %w", tesseract.Command, err)
+	}
+
+	// The first line introduces the list, e.g. `List of available languages in "/usr/share/tessdata/" (3):`.
+	available := strings.Fields(string(output))
+	for _, language := range strings.Split(tesseract.Language, "+") {
+		if language != "" && !slices.Contains(available, language) {
+			return fmt.Errorf("tesseract has no trained data for the language %q", language)
+		}
+	}
+	return nil
+}
+
+func JoinWords(words []Word) string {
+	var text strings.Builder
+	for i, word := range words {
+		if i > 0 {
+			if word.Line != words[i-1].Line {
+				text.WriteByte('\n')
+			} else {
+				text.WriteByte(' ')
+			}
+		}
+		text.WriteString(word.Text)
+	}
+	return text.String()
+}
+
+func parseTSV(output string) ([]Word, error) {
+	var words []Word
+	line, lastLine := -1, ""
+	for i, row := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		columns := strings.Split(strings.TrimRight(row, "\r"), "\t")
+		if i == 0 || columns[0] != "5" {
+			continue
+		}
+		if len(columns) < 12 {
+			return nil, fmt.Errorf("tesseract: malformed TSV row %d: %q", i+1, row)
+		}
+		text := strings.TrimSpace(columns[11])
+		if text == "" || columns[10] == "-1" {
+			continue
+		}
+
+		confidence, err := strconv.ParseFloat(columns[10], 64)
+		if err != nil {
+			return nil, fmt.Errorf("tesseract: malformed TSV row %d: %w", i+1, err)
+		}
+		var box [4]int
+		for j := range box {
+			value, err := strconv.Atoi(columns[6+j])
+			if err != nil {
+				return nil, fmt.Errorf("tesseract: malformed TSV row %d: %w", i+1, err)
+			}
+			box[j] = value
+		}
+		if key := strings.Join(columns[1:5], " "); key != lastLine {
+			line, lastLine = line+1, key
+		}
+		words = append(words, Word{
+			Text:       text,
+			Bounds:     image.Rect(box[0], box[1], box[0]+box[2], box[1]+box[3]),
+			Line:       line,
+			Confidence: confidence,
+		})
+	}
+	return words, nil
+}
+
+func cleanText(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t\r\f")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
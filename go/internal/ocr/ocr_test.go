@@ -0,0 +1,115 @@
+package ocr
+
+/*
+This file tests the parsing of the outputs of Tesseract, without running it: the plain text and the TSV output
+locating the words, and the orientation of a document from the confidence of its words.
+
+---
+
+### `orientedRecognizer`
+Recognizer reading `text` with confidence on the image only at the call `upright` (from 0), and garbage otherwise:
+`Orient` turns the image by one more quarter turn at every call, so it reads the text once the image is turned by
+`upright` quarter turns, like a recognizer given a page upside down.
+*/
+
+import (
+	"context"
+	"image"
+	"strings"
+	"testing"
+)
+
+type orientedRecognizer struct {
+	upright int
+	text    string
+	calls   *int
+}
+
+func (recognizer orientedRecognizer) Recognize(ctx context.Context, img image.Image) (string, error) {
+	words, err := recognizer.RecognizeWords(ctx, img)
+	return JoinWords(words), err
+}
+
+func (recognizer orientedRecognizer) RecognizeWords(ctx context.Context, img image.Image) ([]Word, error) {
+	turns := *recognizer.calls
+	*recognizer.calls++
+	if turns != recognizer.upright {
+		return []Word{{Text: "~:,", Confidence: 31}, {Text: "lI1", Confidence: 75}}, nil
+	}
+	var words []Word
+	for _, text := range strings.Fields(recognizer.text) {
+		words = append(words, Word{Text: text, Confidence: 93})
+	}
+	return words, nil
+}
+
+func TestParseTSV(t *testing.T) {
+	output := "level\tpage_num\tblock_num\tpar_num\tline_num\tword_num\tleft\ttop\twidth\theight\tconf\ttext\n" +
+		"1\t1\t0\t0\t0\t0\t0\t0\t640\t480\t-1\t\n" +
+		"4\t1\t1\t1\t1\t0\t40\t30\t300\t24\t-1\t\n" +
+		"5\t1\t1\t1\t1\t1\t40\t30\t120\t24\t96.5\tINVOICE\n" +
+		"5\t1\t1\t1\t1\t2\t170\t30\t170\t24\t91.2\t2024-117\n" +
+		"5\t1\t1\t1\t1\t3\t350\t30\t10\t24\t-1\t \n" +
+		"5\t1\t2\t1\t1\t1\t40\t400\t80\t20\t88\tTotal\n" +
+		"5\t1\t2\t1\t1\t2\t130\t400\t60\t20\t90\t42.00\n"
+
+	words, err := parseTSV(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Word{
+		{Text: "INVOICE", Bounds: image.Rect(40, 30, 160, 54), Line: 0, Confidence: 96.5},
+		{Text: "2024-117", Bounds: image.Rect(170, 30, 340, 54), Line: 0, Confidence: 91.2},
+		{Text: "Total", Bounds: image.Rect(40, 400, 120, 420), Line: 1, Confidence: 88},
+		{Text: "42.00", Bounds: image.Rect(130, 400, 190, 420), Line: 1, Confidence: 90},
+	}
+	if len(words) != len(expected) {
+		t.Fatalf("%d words %v, expected %d", len(words), words, len(expected))
+	}
+	for i := range expected {
+		if words[i] != expected[i] {
+			t.Errorf("word %d is %+v, expected %+v", i, words[i], expected[i])
+		}
+	}
+	if text := JoinWords(words); text != "INVOICE 2024-117\nTotal 42.00" {
+		t.Errorf("joined text %q", text)
+	}
+
+	if _, err := parseTSV("header\n5\t1\t1\n"); err == nil {
+		t.Error("a truncated row gave no error")
+	}
+}
+
+func TestCleanText(t *testing.T) {
+	if text := cleanText("INVOICE 2024-117  \n\n Total 42.00\t\n\n\f"); text != "INVOICE 2024-117\n Total 42.00" {
+		t.Errorf("cleaned text %q", text)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	tests := []struct {
+		name     string
+		upright  int
+		text     string
+		expected int
+	}{
+		{"upright", 0, "INVOICE 2024-117", 0},
+		{"upside down", 2, "INVOICE 2024-117", 2},
+		{"sideways", 3, "INVOICE 2024-117", 3},
+		{"too little text", 2, "OK", 0},
+	}
+	for _, test := range tests {
+		calls := 0
+		turns, words, err := Orient(context.Background(), orientedRecognizer{upright: test.upright, text: test.text, calls: &calls}, img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if turns != test.expected || calls != 4 {
+			t.Errorf("%s: %d turns after %d recognitions, expected %d after 4", test.name, turns, calls, test.expected)
+		}
+		if turns == test.upright && JoinWords(words) != test.text {
+			t.Errorf("%s: words %q, expected those of the upright document", test.name, JoinWords(words))
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package ocr
+
+/*
+This file recognizes the orientation of a document from its text: a page photographed upside down or sideways has
+the same outline as an upright one, so the geometry of the pipeline cannot tell its top from its bottom. Its text
+can: recognized in the wrong orientation, the text comes out as a few words of garbage the recognizer has no
+confidence in. The text is recognized in the four orientations and the one giving the most confident characters
+wins, which works for any language and script the recognizer reads.
+
+---
+
+### Constants
+- `orientationConfidence`: Confidence from which the characters of a word count for its orientation.
+- `orientationMargin`: Factor by which the best orientation must beat the orientation of the image as it is, so a
+  document without a clear orientation, e.g. a page of a few words or a photo, is left as it is.
+- `orientationCharacters`: Fewest confident characters an orientation needs to be trusted.
+
+---
+
+### `Orient(ctx context.Context, recognizer WordRecognizer, img image.Image) (int, []Word, error)`
+Returns the number of clockwise quarter turns (0 to 3) making the text of `img` upright, and the words recognized
+on `img` turned by them, so a caller asking for the text does not recognize it a fifth time. Runs `recognizer` on
+the four orientations of `img` (see `imageUtils.RotateQuarterTurns`), up to four times the cost of the recognition.
+
+### `orientationScore(words []Word) int`
+Returns the number of characters of the words recognized with at least `orientationConfidence`.
+
+---
+
+### Example Usage:
+```go
+turns, words, err := ocr.Orient(ctx, ocr.NewTesseract("", "eng"), document)
+upright := imageUtils.RotateQuarterTurns(document, turns)
+```
+*/
+
+import (
+	"ELP-project/internal/imageUtils"
+	"context"
+	"image"
+	"unicode/utf8"
+)
+
+const (
+	orientationConfidence = 60
+	orientationMargin     = 1.5
+	orientationCharacters = 10
+)
+
+func Orient(ctx context.Context, recognizer WordRecognizer, img image.Image) (int, []Word, error) {
+	var orientations [4][]Word
+	var scores [4]int
+	best := 0
+	for turns := range orientations {
+		rotated := img
+		if turns > 0 {
+			rotated = imageUtils.RotateQuarterTurns(img, turns)
+		}
+		words, err := recognizer.RecognizeWords(ctx, rotated)
+		if err != nil {
+			return 0, nil, err
+		}
+		orientations[turns], scores[turns] = words, orientationScore(words)
+		if scores[turns] > scores[best] {
+			best = turns
+		}
+	}
+
+	if best == 0 || scores[best] < orientationCharacters || float64(scores[best]) <= orientationMargin*float64(scores[0]) {
+		return 0, orientations[0], nil
+	}
+	return best, orientations[best], nil
+}
+
+func orientationScore(words []Word) int {
+	score := 0
+	for _, word := range words {
+		if word.Confidence >= orientationConfidence {
+			score += utf8.RuneCountInString(word.Text)
+		}
+	}
+	return score
+}
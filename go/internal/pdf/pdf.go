@@ -0,0 +1,297 @@
+package pdf
+
+/*
+Package pdf writes a document as a PDF of one page showing its image, e.g. the cropped scan of a page. When the
+text of the document was recognized (see `internal/ocr`), it is laid over the image as an invisible text layer, like
+the scanners do: the PDF looks like the image, but its text can be searched, selected and copied, and indexed by the
+search systems reading PDFs.
+
+The file is written by hand, without dependency: the image is embedded in JPEG (`DCTDecode`), which the PDF readers
+decode themselves, and the text is set in Courier, one of the standard fonts every reader has, so no font is
+embedded. Courier has the same width for every character, so a word is stretched exactly over its box on the image
+with the horizontal scaling of the text.
+
+---
+
+### Constants
+- `firstPageObject`: Number of the object of the first page: the catalog, the page tree and the font come first, then
+  the page, its content stream and its image, for every page.
+- `imageQuality`: JPEG quality of the images encoded for the file.
+- `glyphWidth`: Width of a character of Courier, in text space units (1000 per unit of font size).
+- `fallbackFontSize`: Largest font size, in points, of the lines of a text without word boxes.
+
+---
+
+### `Page`
+The page to write.
+
+- Fields:
+  - `Image`: The image filling the page.
+  - `JPEG`: The image already encoded in JPEG, e.g. the result of a request, embedded as it is instead of encoding
+    `Image`, which it may then be nil. `Image` is encoded if the JPEG is in CMYK, which PDF stores differently.
+  - `Width`, `Height`: Size of the page in points (1/72 inch), e.g. the physical size of the document. The image is
+    stretched to it.
+  - `Words`: The words recognized on `Image`, laid at their place over it, e.g. by `ocr.WordRecognizer`. No text
+    layer if empty and `Text` is empty.
+  - `Text`: The recognized text, used if `Words` is empty, e.g. with a recognizer which does not locate the words:
+    its lines are laid from the top of the page, so the PDF can still be searched, but the selection does not
+    match the image.
+
+---
+
+### `Encode(w io.Writer, page Page) error`
+Writes `page` to `w` as a PDF 1.4 file of one page.
+
+### `EncodeDocument(w io.Writer, pages []Page) error`
+Writes `pages` to `w` as a PDF 1.4 file, in order, e.g. the pages of a scan session. Every page has its own size.
+
+- **Objects**: The catalog, the page tree and the font, then for every page the page, its content stream
+  (compressed with `FlateDecode`) and its image, followed by the cross-reference table the readers locate the
+  objects with.
+- **Errors**: If there is no page, or a page has an empty image, no size, or an image which cannot be encoded.
+
+### `PixelPoints(pixels int, dpi int) float64`
+Converts a length in pixels at `dpi` to points.
+
+### `writer`
+Buffer of the file being written, with the offset of every object written to it, in order: the object `n` is at
+`offsets[n-1]`.
+
+- Methods:
+  - `object(entries string, stream []byte)`: Writes the next object, the dictionary of `entries`, followed by
+    `stream` if it is not nil, whose `/Length` is added to the dictionary.
+
+### `pageImage(page Page) ([]byte, image.Rectangle, string, error)`
+Returns the JPEG image of `page`, its bounds, which the words are located in, and its color space.
+
+### `writeTextLayer(content *bytes.Buffer, page Page, bounds image.Rectangle)`
+Writes the invisible text (rendering mode 3) of `page`, whose image has `bounds`, to its content stream. A word is followed by a space if the
+next word is on the same line, and stretched up to the next word, so the selected text has its spaces.
+
+### `writeText(content *bytes.Buffer, text string, x, y, width, size float64)`
+Writes `text` in Courier of `size` points with its baseline starting at (x, y), scaled horizontally to `width`
+points, at its natural width if `width` is 0.
+
+### `encodeText(text string) string`
+Encodes `text` as a PDF string in `WinAnsiEncoding`: the parentheses and backslashes are escaped, the characters
+beyond ASCII are written in octal, and those the encoding does not have are replaced with `?`.
+
+---
+
+### Example Usage:
+```go
+page := pdf.Page{Image: document, Width: pdf.PixelPoints(2480, 300), Height: pdf.PixelPoints(3508, 300), Words: words}
+if err := pdf.Encode(file, page); err != nil {
+	log.Fatal(err)
+}
+```
+*/
+
+import (
+	"ELP-project/internal/ocr"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	firstPageObject  = 4
+	imageQuality     = 90
+	glyphWidth       = 600
+	fallbackFontSize = 10
+)
+
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96,
+	'—': 0x97, 'œ': 0x9c, 'Œ': 0x8c, 'š': 0x9a, 'Š': 0x8a, 'ž': 0x9e, 'Ž': 0x8e, 'Ÿ': 0x9f,
+}
+
+type Page struct {
+	Image  image.Image
+	JPEG   []byte
+	Width  float64
+	Height float64
+	Words  []ocr.Word
+	Text   string
+}
+
+type writer struct {
+	buffer  bytes.Buffer
+	offsets []int
+}
+
+func Encode(w io.Writer, page Page) error {
+	return EncodeDocument(w, []Page{page})
+}
+
+func EncodeDocument(w io.Writer, pages []Page) error {
+	if len(pages) == 0 {
+		return errors.New("pdf: no page")
+	}
+
+	file := &writer{}
+	file.buffer.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPageObject+3*i)
+	}
+	file.object("/Type /Catalog /Pages 2 0 R", nil)
+	file.object(fmt.Sprintf("/Type /Pages /Kids [%s] /Count %d", strings.Join(kids, " "), len(pages)), nil)
+	file.object("/Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding", nil)
+
+	for i, page := range pages {
+		if page.Width <= 0 || page.Height <= 0 {
+			return fmt.Errorf("pdf: invalid size %gx%g of page %d", page.Width, page.Height, i+1)
+		}
+		encodedImage, bounds, colorSpace, err := pageImage(page)
+		if err != nil {
+			return fmt.Errorf("pdf: page %d: %w", i+1, err)
+		}
+
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "q %.2f 0 0 %.2f 0 0 cm /Im0 Do Q\n", page.Width, page.Height)
+		writeTextLayer(&content, page, bounds)
+		var compressed bytes.Buffer
+		compressor := zlib.NewWriter(&compressed)
+		if _, err := compressor.Write(content.Bytes()); err != nil {
+			return err
+		}
+		if err := compressor.Close(); err != nil {
+			return err
+		}
+
+		pageObject := len(file.offsets) + 1
+		file.object(fmt.Sprintf("/Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] "+
+			"/Resources << /XObject << /Im0 %d 0 R >> /Font << /F0 3 0 R >> >> /Contents %d 0 R",
+			page.Width, page.Height, pageObject+2, pageObject+1), nil)
+		file.object("/Filter /FlateDecode", compressed.Bytes())
+		file.object(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 "+
+			"/Filter /DCTDecode", bounds.Dx(), bounds.Dy(), colorSpace), encodedImage)
+	}
+
+	crossReferences := file.buffer.Len()
+	fmt.Fprintf(&file.buffer, "xref\n0 %d\n0000000000 65535 f \n", len(file.offsets)+1)
+	for _, offset := range file.offsets {
+		fmt.Fprintf(&file.buffer, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&file.buffer, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(file.offsets)+1, crossReferences)
+
+	_, err := file.buffer.WriteTo(w)
+	return err
+}
+
+func PixelPoints(pixels int, dpi int) float64 {
+	return float64(pixels) * 72 / float64(dpi)
+}
+
+func (file *writer) object(entries string, stream []byte) {
+	file.offsets = append(file.offsets, file.buffer.Len())
+	fmt.Fprintf(&file.buffer, "%d 0 obj\n", len(file.offsets))
+	if stream == nil {
+		fmt.Fprintf(&file.buffer, "<< %s >>\nendobj\n", entries)
+		return
+	}
+	fmt.Fprintf(&file.buffer, "<< %s /Length %d >>\nstream\n", entries, len(stream))
+	file.buffer.Write(stream)
+	file.buffer.WriteString("\nendstream\nendobj\n")
+}
+
+func pageImage(page Page) ([]byte, image.Rectangle, string, error) {
+	if page.JPEG != nil {
+		config, err := jpeg.DecodeConfig(bytes.NewReader(page.JPEG))
+		if err != nil {
+			return nil, image.Rectangle{}, "", err
+		}
+		switch config.ColorModel {
+		case color.GrayModel:
+			return page.JPEG, image.Rect(0, 0, config.Width, config.Height), "/DeviceGray", nil
+		case color.YCbCrModel:
+			return page.JPEG, image.Rect(0, 0, config.Width, config.Height), "/DeviceRGB", nil
+		}
+		if page.Image == nil {
+			return nil, image.Rectangle{}, "", errors.New("unsupported JPEG color model")
+		}
+	}
+
+	if page.Image == nil || page.Image.Bounds().Empty() {
+		return nil, image.Rectangle{}, "", errors.New("empty image")
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, page.Image, &jpeg.Options{Quality: imageQuality}); err != nil {
+		return nil, image.Rectangle{}, "", fmt.Errorf("encoding the image: %w", err)
+	}
+	colorSpace := "/DeviceRGB"
+	if _, gray := page.Image.(*image.Gray); gray {
+		colorSpace = "/DeviceGray"
+	}
+	return encoded.Bytes(), page.Image.Bounds(), colorSpace, nil
+}
+
+func writeTextLayer(content *bytes.Buffer, page Page, bounds image.Rectangle) {
+	lines := strings.Split(strings.TrimSpace(page.Text), "\n")
+	if len(page.Words) == 0 && lines[0] == "" {
+		return
+	}
+
+	scaleX, scaleY := page.Width/float64(bounds.Dx()), page.Height/float64(bounds.Dy())
+	content.WriteString("BT 3 Tr\n")
+	if len(page.Words) > 0 {
+		for i, word := range page.Words {
+			box := word.Bounds.Sub(bounds.Min)
+			text, right := word.Text, box.Max.X
+			if i+1 < len(page.Words) && page.Words[i+1].Line == word.Line && page.Words[i+1].Bounds.Min.X > word.Bounds.Max.X {
+				text += " "
+				right = page.Words[i+1].Bounds.Min.X - bounds.Min.X
+			}
+			x, y := float64(box.Min.X)*scaleX, page.Height-float64(box.Max.Y)*scaleY
+			writeText(content, text, x, y, float64(right-box.Min.X)*scaleX, float64(box.Dy())*scaleY)
+		}
+	} else {
+		size := min(fallbackFontSize, page.Height/float64(len(lines)))
+		for i, line := range lines {
+			writeText(content, line, 0, page.Height-float64(i+1)*size, 0, size)
+		}
+	}
+	content.WriteString("ET\n")
+}
+
+func writeText(content *bytes.Buffer, text string, x, y, width, size float64) {
+	// Every character is encoded as one byte, whatever its length in UTF-8.
+	characters := utf8.RuneCountInString(text)
+	if size <= 0 || characters == 0 {
+		return
+	}
+
+	scaling := 100.0
+	if width > 0 {
+		scaling = 100 * width * 1000 / (glyphWidth * size * float64(characters))
+	}
+	fmt.Fprintf(content, "/F0 %.2f Tf %.2f Tz 1 0 0 1 %.2f %.2f Tm (%s) Tj\n", size, scaling, x, y, encodeText(text))
+}
+
+func encodeText(text string) string {
+	var encoded strings.Builder
+	for _, character := range text {
+		switch {
+		case character == '(' || character == ')' || character == '\\':
+			encoded.WriteByte('\\')
+			encoded.WriteRune(character)
+		case character >= ' ' && character < 0x7f:
+			encoded.WriteRune(character)
+		case character >= 0xa0 && character <= 0xff:
+			fmt.Fprintf(&encoded, "\\%03o", character)
+		case winAnsi[character] != 0:
+			fmt.Fprintf(&encoded, "\\%03o", winAnsi[character])
+		default:
+			encoded.WriteByte('?')
+		}
+	}
+	return encoded.String()
+}
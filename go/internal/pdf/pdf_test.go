@@ -0,0 +1,163 @@
+package pdf
+
+/*
+This file tests the PDF writer: the structure of the file, which the readers locate the objects of through the
+cross-reference table, and the invisible text layer laid over the image.
+
+---
+
+### content(t *testing.T, data []byte) string
+Returns the decompressed content stream of the first page of the PDF `data`.
+*/
+
+import (
+	"ELP-project/internal/ocr"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func content(t *testing.T, data []byte) string {
+	t.Helper()
+	start := bytes.Index(data, []byte("\n5 0 obj"))
+	stream := bytes.Index(data[start:], []byte("stream\n"))
+	if start < 0 || stream < 0 {
+		t.Fatal("no content stream")
+	}
+	reader, err := zlib.NewReader(bytes.NewReader(data[start+stream+len("stream\n"):]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(decompressed)
+}
+
+func TestEncodeStructure(t *testing.T) {
+	var output bytes.Buffer
+	page := Page{Image: image.NewRGBA(image.Rect(0, 0, 300, 600)), Width: PixelPoints(300, 150), Height: PixelPoints(600, 150)}
+	if err := Encode(&output, page); err != nil {
+		t.Fatal(err)
+	}
+	data := output.Bytes()
+
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Fatal("missing PDF header or trailer")
+	}
+	if !bytes.Contains(data, []byte("/MediaBox [0 0 144.00 288.00]")) {
+		t.Error("page is not 2x4 inches")
+	}
+
+	startxref := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(data)
+	if startxref == nil {
+		t.Fatal("no startxref")
+	}
+	offset, _ := strconv.Atoi(string(startxref[1]))
+	if !bytes.HasPrefix(data[offset:], []byte("xref\n0 7\n")) {
+		t.Fatalf("startxref %d does not point at the cross-reference table", offset)
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(data[offset:], -1)
+	if len(entries) != 6 {
+		t.Fatalf("%d objects in the cross-reference table, expected 6", len(entries))
+	}
+	for i, entry := range entries {
+		objectOffset, _ := strconv.Atoi(string(entry[1]))
+		if header := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(data[objectOffset:], []byte(header)) {
+			t.Errorf("offset %d of object %d does not point at it", objectOffset, i+1)
+		}
+	}
+
+	if strings.Contains(content(t, data), "BT") {
+		t.Error("text layer written without text")
+	}
+	if err := Encode(io.Discard, Page{Image: image.NewRGBA(image.Rectangle{}), Width: 10, Height: 10}); err == nil {
+		t.Error("an empty image gave no error")
+	}
+}
+
+func TestTextLayer(t *testing.T) {
+	words := []ocr.Word{
+		{Text: "Total", Bounds: image.Rect(100, 200, 200, 240), Line: 0},
+		{Text: "(42€)", Bounds: image.Rect(220, 200, 320, 240), Line: 0},
+		{Text: "Merci", Bounds: image.Rect(100, 300, 200, 340), Line: 1},
+	}
+	var output bytes.Buffer
+	page := Page{Image: image.NewGray(image.Rect(0, 0, 720, 720)), Width: 360, Height: 360, Words: words}
+	if err := Encode(&output, page); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(output.Bytes(), []byte("/ColorSpace /DeviceGray")) {
+		t.Error("grayscale image not embedded in DeviceGray")
+	}
+
+	layer := content(t, output.Bytes())
+	if !strings.Contains(layer, "BT 3 Tr\n") {
+		t.Fatal("text layer missing or visible")
+	}
+	// "Total " spans from the start of the word to the next one: 60 points for 6 characters 12 points wide.
+	for _, expected := range []string{
+		"/F0 20.00 Tf 83.33 Tz 1 0 0 1 50.00 240.00 Tm (Total ) Tj",
+		"(\\(42\\200\\)) Tj",
+		"1 0 0 1 50.00 190.00 Tm (Merci) Tj",
+	} {
+		if !strings.Contains(layer, expected) {
+			t.Errorf("text layer %q does not contain %q", layer, expected)
+		}
+	}
+}
+
+func TestEncodeText(t *testing.T) {
+	for text, expected := range map[string]string{
+		"a (b) \\c": "a \\(b\\) \\\\c",
+		"Été":       "\\311t\\351",
+		"“ok” — 日本": "\\223ok\\224 \\227 ??",
+	} {
+		if encoded := encodeText(text); encoded != expected {
+			t.Errorf("%q encoded as %q, expected %q", text, encoded, expected)
+		}
+	}
+}
+
+func TestEncodeDocument(t *testing.T) {
+	var photo bytes.Buffer
+	if err := jpeg.Encode(&photo, image.NewRGBA(image.Rect(0, 0, 60, 40)), nil); err != nil {
+		t.Fatal(err)
+	}
+	pages := []Page{
+		{Image: image.NewGray(image.Rect(0, 0, 30, 40)), Width: 300, Height: 400, Text: "first page"},
+		{JPEG: photo.Bytes(), Width: 600, Height: 400},
+	}
+
+	var output bytes.Buffer
+	if err := EncodeDocument(&output, pages); err != nil {
+		t.Fatal(err)
+	}
+	data := output.Bytes()
+	for _, expected := range []string{
+		"/Kids [4 0 R 7 0 R] /Count 2",
+		"/MediaBox [0 0 300.00 400.00]",
+		"/MediaBox [0 0 600.00 400.00]",
+		"/Width 60 /Height 40 /ColorSpace /DeviceRGB",
+	} {
+		if !bytes.Contains(data, []byte(expected)) {
+			t.Errorf("document does not contain %q", expected)
+		}
+	}
+	// The JPEG of the second page is embedded as it is.
+	if !bytes.Contains(data, photo.Bytes()) {
+		t.Error("JPEG page re-encoded")
+	}
+
+	if err := EncodeDocument(io.Discard, nil); err == nil {
+		t.Error("a document without page gave no error")
+	}
+}
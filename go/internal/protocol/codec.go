@@ -0,0 +1,96 @@
+package protocol
+
+/*
+This file implements the encodings of the control messages. The magic opening a connection selects the encoding
+of all its control frames:
+
+- `MagicJSON` ("ELP1"): JSON, spoken by the clients released before the protobuf schema.
+- `MagicProtobuf` ("ELP2"): protobuf, following `elp.proto`. Spoken by the current clients.
+
+Servers accept both, clients pick one when they connect.
+
+---
+
+### Message
+A control message, encodable with both codecs: `*Header`, `*Auth`, `*Metadata`, `*Progress`, `*Trailer`,
+`*ErrorMessage`.
+
+### Codec
+Encoding of the control messages of a connection.
+
+- Methods:
+  - `Magic() string`: The magic opening the connections using the codec.
+  - `Marshal(message Message) ([]byte, error)`: Encodes a message.
+  - `Unmarshal(payload []byte, message Message) error`: Decodes a message.
+
+### JSON / Protobuf
+The codecs of the `MagicJSON` and `MagicProtobuf` connections.
+
+---
+
+### CodecForMagic(magic string) (Codec, bool)
+Returns the codec of a connection magic, false if the magic is unknown.
+*/
+
+import "encoding/json"
+
+const (
+	MagicJSON     = "ELP1"
+	MagicProtobuf = "ELP2"
+	MagicLength   = 4
+)
+
+type Message interface {
+	MarshalProto() []byte
+	UnmarshalProto(payload []byte) error
+}
+
+type Codec interface {
+	Magic() string
+	Marshal(message Message) ([]byte, error)
+	Unmarshal(payload []byte, message Message) error
+}
+
+type jsonCodec struct{}
+
+type protobufCodec struct{}
+
+var (
+	JSON     Codec = jsonCodec{}
+	Protobuf Codec = protobufCodec{}
+)
+
+func CodecForMagic(magic string) (Codec, bool) {
+	switch magic {
+	case MagicJSON:
+		return JSON, true
+	case MagicProtobuf:
+		return Protobuf, true
+	default:
+		return nil, false
+	}
+}
+
+func (jsonCodec) Magic() string {
+	return MagicJSON
+}
+
+func (jsonCodec) Marshal(message Message) ([]byte, error) {
+	return json.Marshal(message)
+}
+
+func (jsonCodec) Unmarshal(payload []byte, message Message) error {
+	return json.Unmarshal(payload, message)
+}
+
+func (protobufCodec) Magic() string {
+	return MagicProtobuf
+}
+
+func (protobufCodec) Marshal(message Message) ([]byte, error) {
+	return message.MarshalProto(), nil
+}
+
+func (protobufCodec) Unmarshal(payload []byte, message Message) error {
+	return message.UnmarshalProto(payload)
+}
@@ -0,0 +1,199 @@
+package protocol
+
+/*
+Package protocol defines the wire format spoken between the client and the server.
+
+---
+
+### Connection layout
+1. The client starts the connection by sending 4 magic bytes, which select the encoding of the control messages
+   (see `Codec`): `MagicProtobuf` ("ELP2") for protobuf, `MagicJSON` ("ELP1") for JSON. If the server requires
+   authentication, the next frame must be a `FrameAuth` frame carrying an API key, with the request ID
+   `ConnectionRequestID`. Otherwise the server answers with a `CodeUnauthorized` error and closes the connection.
+2. For every request, the client picks a request ID unique on the connection (0 is reserved, see
+   `ConnectionRequestID`) and sends:
+   - a `FrameHeader` frame containing the encoded request `Header`,
+   - a `FrameImage` frame containing the encoded image (JPEG or PNG), unless the header only queries the state
+     of an asynchronous job (`Header.JobID`).
+3. The server answers every request with frames carrying the ID of the request:
+   - `FrameProgress` frames reporting the stages of the processing as they complete, if the client asked for
+     them (`Header.Progress`),
+   - `FrameArtifact` frames containing the intermediate images the client asked for (`Header.Artifacts`), as
+     soon as they are computed,
+   - an optional `FrameMetadata` frame describing the result,
+   - an optional `FrameImage` frame containing the processed image,
+   - a `FrameTrailer` frame with the time spent in every stage of the request, once the image has been sent,
+   - a `FrameEnd` frame closing the response,
+   or with a `FrameError` frame if the request failed, possibly after the artifacts computed before the failure.
+
+Requests are multiplexed: the client may send new requests without waiting for the previous responses, and the
+server answers them in the order they complete. The frames of a single message are never interleaved.
+
+---
+
+### Legacy protocol
+Clients released before the framed protocol send the raw encoded image followed by the 3 bytes `LegacyEndMarker`
+("EOF"), without magic. The server answers with the raw processed image and closes the connection. A server can
+tell both protocols apart by peeking at the first bytes of a connection.
+
+---
+
+### Frame
+Every message is sent as a frame:
+
+```
++------------+--------------------------+----------------------+------------------+
+| type (1 B) | request ID (4 B, BE u32) | length (4 B, BE u32) | payload (length) |
++------------+--------------------------+----------------------+------------------+
+```
+
+- `FrameHeader`: Encoded `Header` (client to server).
+- `FrameImage`: Raw encoded image data (both directions).
+- `FrameError`: Encoded `ErrorMessage` (server to client), closes the response.
+- `FrameMetadata`: Encoded `Metadata` (server to client).
+- `FrameEnd`: Empty payload (server to client), closes the response.
+- `FrameAuth`: Encoded `Auth` (client to server), sent once right after the magic.
+- `FrameProgress`: Encoded `Progress` (server to client), sent before the other frames of the response.
+- `FrameArtifact`: Binary `Artifact` (server to client), whatever the codec: the length of its name (1 B), its
+  name, then the PNG image.
+- `FrameTrailer`: Encoded `Trailer` (server to client), sent right before the `FrameEnd` frame.
+
+The control messages are encoded with the codec of the connection, JSON or protobuf.
+
+---
+
+### WriteMagic(w io.Writer, codec Codec) error / ReadMagic(r io.Reader) (Codec, error)
+Writes the magic bytes opening a connection which uses `codec`, or reads them and returns the matching codec.
+`ReadMagic` returns `ErrBadMagic` if the bytes match no codec.
+
+### WriteFrameHeader(w io.Writer, frameType FrameType, requestID uint32, length int) error
+Writes only the type, request ID and length of a frame, the payload must be written by the caller right after.
+Useful to stream large payloads such as images.
+
+### ReadFrameHeader(r io.Reader) (FrameType, uint32, int, error)
+Reads the type, request ID and length of the next frame, the payload must be read by the caller right after.
+
+### WriteFrame(w io.Writer, frameType FrameType, requestID uint32, payload []byte) error
+Writes a complete frame.
+
+### ReadPayload(r io.Reader, length int, maxSize int) ([]byte, error)
+Reads the payload of a frame whose header was just read. Returns `ErrFrameTooLarge`, without reading anything, if
+the length is greater than `maxSize`.
+*/
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const LegacyEndMarker = "EOF"
+
+type FrameType uint8
+
+const (
+	FrameHeader FrameType = iota + 1
+	FrameImage
+	FrameError
+	FrameMetadata
+	FrameEnd
+	FrameAuth
+	FrameProgress
+	FrameArtifact
+	FrameTrailer
+)
+
+const (
+	frameHeaderSize     = 9
+	MaxControlFrameSize = 1 << 20
+)
+
+var (
+	ErrBadMagic      = errors.New("protocol: bad magic")
+	ErrFrameTooLarge = errors.New("protocol: frame too large")
+)
+
+func (frameType FrameType) String() string {
+	switch frameType {
+	case FrameHeader:
+		return "header"
+	case FrameImage:
+		return "image"
+	case FrameError:
+		return "error"
+	case FrameMetadata:
+		return "metadata"
+	case FrameEnd:
+		return "end"
+	case FrameAuth:
+		return "auth"
+	case FrameProgress:
+		return "progress"
+	case FrameArtifact:
+		return "artifact"
+	case FrameTrailer:
+		return "trailer"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(frameType))
+	}
+}
+
+func WriteMagic(w io.Writer, codec Codec) error {
+	_, err := io.WriteString(w, codec.Magic())
+	return err
+}
+
+func ReadMagic(r io.Reader) (Codec, error) {
+	magic := make([]byte, MagicLength)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, err
+	}
+
+	codec, ok := CodecForMagic(string(magic))
+	if !ok {
+		return nil, ErrBadMagic
+	}
+	return codec, nil
+}
+
+func WriteFrameHeader(w io.Writer, frameType FrameType, requestID uint32, length int) error {
+	var header [frameHeaderSize]byte
+	header[0] = byte(frameType)
+	binary.BigEndian.PutUint32(header[1:5], requestID)
+	binary.BigEndian.PutUint32(header[5:], uint32(length))
+
+	_, err := w.Write(header[:])
+	return err
+}
+
+func ReadFrameHeader(r io.Reader) (FrameType, uint32, int, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, 0, 0, err
+	}
+
+	return FrameType(header[0]), binary.BigEndian.Uint32(header[1:5]), int(binary.BigEndian.Uint32(header[5:])), nil
+}
+
+func WriteFrame(w io.Writer, frameType FrameType, requestID uint32, payload []byte) error {
+	if err := WriteFrameHeader(w, frameType, requestID, len(payload)); err != nil {
+		return err
+	}
+
+	_, err := w.Write(payload)
+	return err
+}
+
+func ReadPayload(r io.Reader, length int, maxSize int) ([]byte, error) {
+	if length > maxSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
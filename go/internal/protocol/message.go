@@ -0,0 +1,872 @@
+package protocol
+
+/*
+Package protocol provides the messages exchanged in the control frames of the protocol. Their schema is
+`elp.proto`, they are encoded with the codec of the connection (see `Codec`).
+
+---
+
+### Header
+Options of a request, sent by the client in a `FrameHeader` frame before the image.
+
+- **Fields**:
+  - `PageSize`: Target page size of the output ("A4", "Letter", "100x150" in millimeters, ...). Empty keeps the
+    cropped image at its original resolution.
+  - `DPI`: Resolution used to compute the output canvas from `PageSize`. Zero selects the server default.
+  - `Async`: If true, the server answers immediately with the ID of a job processing the image in the
+    background, the result is fetched later with another request.
+  - `JobID`: If set, the request queries the job instead of sending an image. The server answers with the state
+    of the job, and with its result once it is done.
+  - `Offset`: With `JobID`, the byte of the result of the job the server starts sending from, to resume a download
+    interrupted after that many bytes instead of downloading the whole result again. At most the size of the
+    result (`Metadata.Size`).
+  - `Progress`: If true, the server reports the stages of a synchronous request as they complete, in
+    `FrameProgress` frames. Clients not asking for them never receive such frames.
+  - `Artifacts`: Intermediate images of a synchronous request to send back in `FrameArtifact` frames, besides the
+    final crop (`ArtifactGrayscale`, `ArtifactEdges`, `ArtifactContours`, `ArtifactHistograms`). Useful to find
+    out why the document was not detected on a photo.
+  - `Webhook`: URL of an asynchronous request to which the server POSTs the state of the job once it is done or
+    failed, so the client does not need to poll it. Only `http` and `https` URLs are accepted.
+  - `Operation`: What the server returns: `OperationCrop` (the default) the cropped document, `OperationGrayscale`
+    the grayscale conversion of the image, `OperationEdges` its Canny edge map. The processing stops once the
+    result is computed, and `PageSize` only applies to the cropped document. `OperationEstimate` returns no image,
+    only an `Estimate` of the cost of cropping the document, computed from the header of the image without
+    decoding it; it cannot be asynchronous. `OperationCorners` returns no image either, only the corners of the
+    detected document as a JSON `Document`, in the `json` format, for callers cropping the image themselves.
+    `OperationScan` returns the cropped document with the look of a scan: white paper and a boosted contrast (see
+    `imageUtils.ScanDocument`), instead of the enhancement of the preset. It is a crop otherwise, and the options
+    applying to `OperationCrop` apply to it.
+  - `Format`: Format of the returned image ("jpeg", "png", or `FormatPDF` for a PDF of one page showing the image).
+    Empty keeps the format of the sent image, unless `Accept` is given. Must be empty or "json" for
+    `OperationCorners`. With `OCR`, the PDF is searchable: the recognized text is laid over the image as an invisible
+    text layer.
+  - `Accept`: Formats the client can read the result in, in its order of preference, when it leaves `Format` empty:
+    the result is returned in the first of them the server can produce for the request, e.g. `["webp", "png"]`
+    receives a lossless PNG of a JPEG photo from a server without WebP. The request fails with `CodeBadRequest` if
+    the server can produce none of them. The `Format` of the `Metadata` gives the format picked.
+  - `Deterministic`: Processes the image in deterministic mode: the same image and options always give a
+    bit-identical result, whatever the server instance and its number of workers, e.g. for archives checked by
+    checksum. Slightly slower on servers with many cores.
+  - `Anonymize`: Blurs the photos found on the cropped document (faces, ID photos) before returning it. Only
+    applies to `OperationCrop`, and no artifact but `ArtifactHistograms` can be requested with it, since they show
+    the photos unblurred.
+  - `Stamp`: Text or image stamped on the cropped document before it is returned, see `Stamp`. Only applies to
+    `OperationCrop`.
+  - `Preset`: Tuning of the processing for a kind of document: `PresetDocument` (the default) for printed pages,
+    `PresetWhiteboard`, `PresetReceipt` for thermal receipts, `PresetPhoto` for photo prints, `PresetIDCard` for
+    identity and bank cards, or `PresetBook` for open books. A preset sets the
+    edge detection and the default `Format` of the result, an explicit `Format` still wins. `PresetWhiteboard` also
+    cleans the cropped board up: its background is flattened to white and the marker strokes are saturated.
+    `PresetReceipt` crops the receipt along its rotated bounding rectangle, however long and thin, and scales it to
+    the width of thermal paper at 400 dpi for OCR, unless `PageSize` or `DPI` are given. `PresetIDCard` checks that
+    the document has the proportions of an ISO/IEC 7810 ID-1 card, failing with `CodeNotFound` otherwise, and warps it
+    to the size of the card at 300 dpi (or `DPI`), whatever the size of the photo. `PresetBook` warps the open book
+    upright and splits a double-page spread into its two pages at the shadow of its binding (see `Metadata.Spread`).
+  - `NoCache`: Processes the image even if the same client sent it with the same options a moment ago, instead of
+    sending back the result of the first submission. Used by benchmarks, which send the same image again and again.
+  - `Source`: URL of the image in one of the storages the server reads its inputs from (e.g.
+    `s3://scans/inbox/2026/receipt.jpg`), instead of sending it: the server fetches the image itself and no image
+    frame follows the header. A URL outside of the storages of the server is refused.
+  - `OCR`: Recognizes the text of the cropped document, returned in `Metadata.Text`, e.g. to index the scans for
+    search. Only applies to `OperationCrop`, and needs a server with a text recognizer: other servers refuse the
+    request with `CodeUnavailable`. The text is recognized before the document is stamped, so the stamp is not part
+    of it.
+  - `Orient`: Turns the cropped document upright, e.g. a page photographed upside down, by recognizing its text in
+    the four orientations and keeping the one read with the most confidence, whatever its language: the outline of
+    a page cannot tell its top from its bottom. Applies to `OperationCrop` only, and needs a server recognizing the
+    words of the text, other servers refuse the request with `CodeUnavailable`. With `OCR`, the text is that of the
+    turned document.
+  - `Session`: ID of the scan session the cropped document is a page of, chosen by the client, e.g. a UUID: the
+    server keeps the result of every page of a session, and combines them into one document once the session is
+    finalized. Only applies to synchronous `OperationCrop` requests, whose `Format` must be an image format. A session
+    is started by its first page, belongs to the client which started it, and is forgotten if it is not finalized
+    within an hour of its last page.
+  - `Page`: Number of the page in its session, from 1, so the pages sent in parallel are combined in order. The pages
+    numbered 0 follow the numbered ones, in the order they were processed. A page sent again replaces the page of the
+    same number.
+  - `Finalize`: Finalizes the `Session` instead of sending an image: no image frame follows the header, and the
+    response carries the pages of the session combined in `Format`: `FormatPDF` (the default) for a PDF of one page
+    per document, searchable if the pages were recognized with `OCR`, or `FormatZIP` for a ZIP archive of the page
+    images, with their text. The session is then forgotten.
+  - `Border`: What becomes of the contours touching the border of the image, often the background cut by the frame
+    (the edge of a table, a keyboard) enclosing a larger area than the document: `BorderKeep` (the default) ranks
+    them like the others, `BorderPenalize` only picks one if no other contour is much smaller, and `BorderDiscard`
+    never picks them, so a document photographed touching the frame is missed.
+  - `Centering`: Preference for the candidate documents near the center of the image, from 0 (the default, the
+    largest candidate wins wherever it is) to 1: the area of a candidate is weighted down by up to `Centering` as its
+    centroid moves from the center to a corner of the image, since a document is usually framed in the middle of the
+    photo.
+  - `Detector`: How the document is found in the edge map: `DetectorContours` (the default) takes the largest
+    contour, `DetectorHough` the quadrilateral of the four dominant straight lines (see
+    `utils.FindHoughQuadrilateral`), which still finds the corners of a page partially hidden, e.g. by a hand holding
+    it. When the lines do not make a quadrilateral, the contour is used. `DetectorRANSAC` takes the largest contour
+    too, but the corners of the document are the intersections of its four sides, fitted on the contour as straight
+    lines (see `utils.FitQuadrilateralRANSAC`), so a rounded or hidden corner is not cut.
+  - `EdgeDetector`: How the edges of the image are detected: `EdgeDetectorCanny` (the default) thins the gradient by
+    the Non-Maximum Suppression of the Canny detector, `EdgeDetectorLoG` takes the zero crossings of the Laplacian of
+    Gaussian of the image instead (see `utils.OperatorLoG`), whose edges stay closed at the corners and along curved
+    borders, so the detectors can be compared on a difficult scan. The smoothing and the thresholds of the preset are
+    the same with both.
+  - `Shape`: Page size the document is expected to have the proportions of, e.g. "A4" or "Letter" (any name or
+    custom size of `PageSize`): the candidates are ranked by their area weighted down by how far they are from a
+    convex quadrilateral of these proportions, whatever their orientation (see `utils.PageShape`), so a larger table
+    edge or window frame loses to the page. Empty for no preference (the default).
+  - `Deskew`: Straightens the cropped document when its text lines are slanted by a few degrees, e.g. a page
+    photographed nearly flat but turned, or cropped to its bounding box, by the angle of its text estimated from its
+    projection profile (see `utils.Deskew`). Applies to `OperationCrop` only.
+  - `FillHoles`: Paints over the punched holes along the edges of the cropped document and the staple, or its shadow,
+    in its corners with the paper around them, before it is straightened and enhanced (see
+    `imageUtils.FillPunchHoles`), for a cleaner archive of pages taken out of a binder. Only applies to
+    `OperationCrop` and `OperationScan`.
+  - `Binarize`: Binarizes the result of `OperationScan` into pure black and white. Only applies to `OperationScan`,
+    whose result is then a PNG unless `Format` is given. The specks left by the binarization are removed and its
+    pinholes filled, see `Despeckle`.
+  - `Despeckle`: Sizes of the specks and the pinholes removed from a `Binarize` scan, see `Despeckle`. Nil for the
+    default sizes.
+  - `Flatten`: Flattens the illumination of the image before its edge detection (see `utils.Illumination`), so the
+    border of a shadow cast on the document, e.g. by the hand of the photographer, is not detected as an edge. The
+    grayscale image and the edge map are those of the flattened image; the cropped document keeps its colors.
+  - `MultiDocument`: Looks for several documents in the image, e.g. receipts laid side by side on a table, besides
+    the best one, see `MultiDocument`. Only applies to `OperationCorners`: every document found is listed in the
+    `Documents` of the `Document` sent back.
+  - `WhiteBalance`: White balance of the cropped document, before the enhancement of the preset:
+    `WhiteBalanceAuto` (the default) corrects the color cast of its paper only if it is visible (see
+    `ColorStats.Cast`), `WhiteBalanceWhitePatch` always balances its paper to neutral, `WhiteBalanceGrayWorld` always
+    balances the mean color of the whole document to neutral, e.g. for a document with little paper showing, and
+    `WhiteBalanceOff` keeps its colors. Only applies to `OperationCrop` and `OperationScan`.
+  - `Tone`: Gamma, brightness and contrast of the cropped document, adjusted once it is cropped and enhanced, before
+    it is scaled to `PageSize`, see `Tone`. Only applies to `OperationCrop` and `OperationScan`.
+  - `DetectionTone`: Gamma, brightness and contrast of the grayscale image the document is detected on, adjusted
+    before its edge detection, see `Tone`, e.g. to bring out a page in a dark photo. The grayscale image and the edge
+    map returned by the operations and the artifacts are those of the adjusted image; the cropped document keeps its
+    tones.
+  - `Lossless`: Crops the document out of a JPEG image without decoding and encoding it again, so it keeps the
+    quality of the photo (see `imageUtils.CropJPEG`): the blocks of the JPEG inside the bounding box of the document
+    are copied as they are, the top left corner of the box being moved onto their grid, by up to 15 pixels. Only
+    applies to an `OperationCrop` returned in JPEG, without `PageSize`, and cropped along the bounding box of the
+    document: the presets warping or turning it, and the options changing its pixels (`Anonymize`, `Stamp`,
+    `Deskew`, `FillHoles`, `Orient`, `Tone`, a `WhiteBalance` other than `WhiteBalanceOff`), are refused with it,
+    and its colors are kept. The images which cannot be cropped losslessly, e.g. a PNG or a progressive JPEG, are
+    cropped as without it: `Metadata.Lossless` tells whether the document was.
+
+### Stamp
+Stamp or watermark laid over a cropped document, e.g. "COPY" or the logo of the company (see `internal/watermark`).
+
+- **Fields**:
+  - `Text`: Text of the stamp, drawn in capitals in a red frame. The placeholders `{date}`, `{time}` and `{request}`
+    are replaced with the date and time of the processing (UTC) and the ID of the request (the ID of the job for an
+    asynchronous request).
+  - `Image`: Image laid over the document instead of a text (JPEG or PNG), scaled to a quarter of the smaller side
+    of the document. The header frame carries it, so it must fit in `MaxControlFrameSize` with the other fields.
+  - `Position`: Where the stamp is placed: `PositionTopLeft`, `PositionTopRight`, `PositionBottomLeft`,
+    `PositionBottomRight` (the default) or `PositionCenter`.
+  - `Opacity`: Opacity of the stamp, from 0 excluded to 1. Zero selects `DefaultStampOpacity`.
+
+### MultiDocument
+What a candidate must look like to be one of the documents of a `Header.MultiDocument` request (see
+`utils.FindDocuments`): a candidate must also be close to a quadrilateral, and those enclosing other documents, or
+enclosed in one, are left out.
+
+- **Fields**:
+  - `MinArea`: Smallest area of a document, as a fraction of the area of the image, from 0 to 1. Zero selects
+    `DefaultDocumentArea`.
+  - `MinAspect`: Smallest ratio of the shorter side of a document to its longer one, from 0 to 1 (see
+    `Candidate.Aspect`). Zero selects `DefaultDocumentAspect`.
+
+### Despeckle
+Despeckle of a binarized scan (see `imageUtils.Despeckle`): the isolated black specks, dust or noise turned black by
+the binarization, are removed, and the white pinholes of the strokes and the figures are filled.
+
+- **Fields**:
+  - `SpeckSize`: The black components of fewer pixels are removed. Zero selects `DefaultSpeckSize`, a negative value
+    keeps them all.
+  - `PinholeSize`: The white components of fewer pixels, inside the black ones, are filled. Zero selects
+    `DefaultPinholeSize`, a negative value keeps them all.
+
+### Tone
+Tone adjustment of an image (see `imageUtils.ToneCurve`): the gamma correction, then the brightness and the contrast.
+The zero values leave the image unchanged.
+
+- **Fields**:
+  - `Gamma`: Gamma correction, from `MinGamma` to `MaxGamma`: above 1 brightens the mid-tones, below 1 darkens them.
+    Zero for no correction.
+  - `Brightness`: Shift of the levels, from -1 (black) to 1 (white).
+  - `Contrast`: Change of the contrast, from -1 (flat mid-gray) to 1 (doubled).
+
+---
+
+### Auth
+Credentials of a connection, sent by the client in a `FrameAuth` frame right after the magic.
+
+- **Fields**:
+  - `Token`: The API key of the client.
+
+---
+
+### Metadata
+Description of a result, sent by the server in a `FrameMetadata` frame.
+
+- **Fields**:
+  - `JobID`: The ID of the asynchronous job the response is about.
+  - `Status`: The state of the job (`StatusPending`, `StatusRunning`, `StatusDone`, `StatusFailed`).
+  - `Error`: Why the job failed.
+  - `Format`: The format of the returned image ("jpeg", "png", "pdf"), "json" for a `Document`, or "zip" for the
+    archive of a finalized session or of the pages of a spread (see `Spread`).
+  - `Stats`: Statistics of the request on the connection, see `TransferStats`.
+  - `Estimate`: The answer to an `OperationEstimate` request.
+  - `Size`: The size of the whole result of a finished job, in bytes, whatever the `Header.Offset` of the query, so
+    the client knows when a resumed download is complete.
+  - `Colors`: Color statistics of the cropped document, see `ColorStats`. Nil for the other operations.
+  - `Text`: Text recognized on the cropped document when the request asked for it (`Header.OCR`), one line of the
+    document per line. Empty if no text was found.
+  - `Pages`: Number of pages of the session of the request (`Header.Session`), including the page of the response.
+  - `Rotation`: Clockwise rotation, in degrees (0, 90, 180 or 270), the document was turned by to stand upright when
+    the request asked for it (`Header.Orient`).
+  - `Alternatives`: The next best candidates for the document after the one returned, best first, see `Candidate`,
+    so a client can offer the user to pick another one when the detection went wrong. Only for `OperationCrop` and
+    `OperationCorners`, and not kept for the asynchronous jobs.
+  - `Lossless`: Whether the document was cropped out of the JPEG image without being encoded again, when the request
+    asked for it (`Header.Lossless`). Not kept for the asynchronous jobs.
+  - `Corners`: The corners of the detected document, in the pixels of the sent image, like those of `Document`, so
+    a client can show where the document was found. Only for `OperationCrop` and `OperationCorners`, and not kept for
+    the asynchronous jobs.
+  - `Spread`: Whether the document was a double-page spread, split into its two pages (`PresetBook`): the result is
+    then a `FormatZIP` archive of both pages, left first, in the format of the request, or a PDF of two pages in
+    `FormatPDF`. Not kept for the asynchronous jobs, whose `Format` still tells.
+
+### Candidate
+A candidate document found in the image.
+
+- **Fields**:
+  - `Corners`: The top-left, top-right, bottom-right and bottom-left corners of the candidate, in the pixels of the
+    sent image, like those of `Document`.
+  - `Area`: Area of the contour of the candidate, in square pixels.
+  - `Score`: Rank of the candidate, its area weighted down by the options of the request (`Header.Border`,
+    `Header.Centering`).
+  - `Validation`: How close to a quadrilateral the candidate is, from 0 to 1.
+  - `CenterDistance`: Distance of the candidate to the center of the image, from 0 at the center to 1 in a corner.
+  - `Aspect`: Ratio of the shorter side of the quadrilateral of the corners to its longer side, from 0 to 1: about
+    0.71 for an A4 page, 0.3 for a receipt.
+
+### ColorStats
+Color statistics of a cropped document, computed before it is anonymized or stamped (see
+`imageUtils.ColorStats`).
+
+- **Fields**:
+  - `Red`, `Green`, `Blue`: Statistics of every channel, see `ChannelStats`.
+  - `Cast`: The color cast of the document (`red`, `green`, `blue`, `cyan`, `magenta` or `yellow`), estimated on its
+    paper, e.g. `yellow` under a tungsten lamp. Empty if the paper is neutral enough that no white balance is needed.
+  - `CastStrength`: How far the paper is from neutral, e.g. 0.1 when a channel is 10% off its gray.
+  - `Balanced`: Whether the server corrected the cast with a white balance before returning the document (see
+    `Header.WhiteBalance`). The statistics above are those of the document before the correction.
+
+### ChannelStats
+Statistics of a channel of an image, in 8-bit values: its `Mean`, and its 5th, 50th and 95th percentiles (`P5`,
+`P50`, `P95`).
+
+### Document
+Result of an `OperationCorners` request, sent as JSON in place of the image.
+
+- **Fields**:
+  - `Width`, `Height`: Size of the sent image, in pixels.
+  - `Corners`: The top-left, top-right, bottom-right and bottom-left corners of the detected document, in the
+    pixels of the sent image. Empty if no document was found.
+  - `Area`: Area of the quadrilateral formed by the corners, in square pixels.
+  - `Documents`: With `Header.MultiDocument`, every document found in the image, best first, see `Candidate`. The
+    detected document of `Corners` is usually the first of them, unless it does not look like a page. Empty if none
+    was found, or without `Header.MultiDocument`.
+
+### Point
+Position of a pixel, from the top left corner of the image.
+
+### Manifest
+Contents of a ZIP archive of results, written as JSON in its `ManifestName` file, so a client can read the archive
+without guessing from the names of its files.
+
+- **Fields**:
+  - `Entries`: One entry per result, in the order of the pages or of the images, see `ManifestEntry`.
+
+### ManifestEntry
+A result of a ZIP archive.
+
+- **Fields**:
+  - `File`: Name of the file of the result in the archive. Empty if the image failed.
+  - `Input`: Path of the image the result comes from, for the archives of a batch written by the client.
+  - `Page`: Number of the page, from 1.
+  - `Format`: Format of the result ("jpeg" or "png").
+  - `Width`, `Height`: Size of the result, in pixels.
+  - `Text`: Name of the file of the text recognized on the result (`Header.OCR`), empty if none.
+  - `Rotation`: Clockwise rotation, in degrees, the document was turned by to stand upright (`Header.Orient`).
+  - `Report`: Name of the file of the HTML processing report of the result, for the archives of a batch written by
+    the client with `-report`, empty if none.
+  - `Error`: Why the image failed, for the archives of a batch. Empty if it succeeded.
+
+### Estimate
+Expected cost of processing an image, so that orchestrators can schedule large jobs.
+
+- **Fields**:
+  - `Width`, `Height`: Size of the image, in pixels.
+  - `Megapixels`: Number of pixels of the image, in millions.
+  - `Chunks`: Number of chunks the image would be split into.
+  - `Millis`: Expected processing time of the image on the server, in milliseconds, once a worker is available.
+  - `Stages`: Expected wall clock time of every stage of the processing (see `StageTiming`).
+
+---
+
+### Progress
+Stage of the processing of a request which has just completed, sent by the server in a `FrameProgress` frame.
+
+- **Fields**:
+  - `Stage`: The completed stage (`StageGrayscale`, `StageEdges`, `StageContours`, `StageCropping`).
+  - `Step`, `Steps`: Number of the stage, from 1, and number of stages of the processing.
+
+---
+
+### Artifact
+Intermediate image of the processing of a request, sent by the server in a `FrameArtifact` frame.
+
+- **Fields**:
+  - `Name`: What the image shows:
+    - `ArtifactGrayscale`: the grayscale conversion of the input,
+    - `ArtifactEdges`: the Canny edge map,
+    - `ArtifactContours`: the input overlaid with the detected contours in red, and the one selected as the
+      document in green.
+    - `ArtifactHistograms`: the luminance histogram of the grayscale image, and the histogram of its gradients with
+      the thresholds of the edge detection.
+  - `Data`: The image, always encoded as PNG.
+
+---
+
+### Trailer
+Information known once a response has been sent, sent by the server in a `FrameTrailer` frame.
+
+- **Fields**:
+  - `Timings`: Time spent in every stage of the request, in the order of the pipeline. Stages which did not run,
+    e.g. the processing of a cached result, are left out.
+
+### StageTiming
+Time spent in a stage of a request.
+
+- **Fields**:
+  - `Stage`: The stage:
+    - `TimingReceive`: upload of the image,
+    - `TimingGrayscale`: grayscale conversion,
+    - `TimingBlur`, `TimingSobel`, `TimingNMS`, `TimingHysteresis`: steps of the Canny edge detection,
+    - `TimingMorphology`: closing of the gaps of the edges by the preset of the request,
+    - `TimingBFS`: search of the contours,
+    - `TimingQuadrilateral`: detection of the document among the contours,
+    - `TimingHough`: detection of the borders of the document as straight lines (`DetectorHough`),
+    - `TimingCrop`: cropping and scaling of the document,
+    - `TimingDeskew`: estimation of the skew of the text of the document and its rotation (`Header.Deskew`),
+    - `TimingEnhance`: enhancement of the cropped document by the preset of the request, e.g. the cleanup of a
+      whiteboard, filling of its punched holes (`Header.FillHoles`) and adjustment of its tone (`Header.Tone`),
+    - `TimingAnonymize`: detection and blurring of the photos of the document,
+    - `TimingOrient`: recognition of the orientation of the document from its text,
+    - `TimingOCR`: recognition of the text of the document,
+    - `TimingStamp`: stamping of the document,
+    - `TimingEncode`: encoding of the result,
+    - `TimingSend`: sending of the result.
+  - `Millis`: Duration of the stage, in milliseconds. The image is processed in chunks by several workers: the
+    steps of the edge detection, which run inside the workers, are summed over the chunks and can exceed the wall
+    clock time of the request. The other stages are wall clock times.
+
+---
+
+### TransferStats
+Statistics of a request, sent in its `Metadata` for capacity planning.
+
+- **Fields**:
+  - `Protocol`: Version of the protocol spoken on the connection (the connection magic).
+  - `Features`: Features available on the connection ("multiplexing", "jobs", "auth", ...).
+  - `BytesReceived`: Size of the request received by the server (header and image payloads).
+  - `BytesSent`: Size of the image sent back, 0 if the response has no image.
+  - `ReceiveMillis`: Time between the reception of the request header and the end of the image upload.
+  - `ProcessMillis`: Processing time of the image on the server.
+
+---
+
+### ErrorMessage
+Error returned by the server in a `FrameError` frame.
+
+- **Fields**:
+  - `Code`: Machine readable error category (`CodeBadRequest`, `CodeTooLarge`, `CodeUnavailable`,
+    `CodeNotFound`, `CodeBusy`, `CodeUnauthorized`, `CodeQuotaExceeded`, `CodeInternal`).
+  - `Message`: Human readable description of the error.
+
+An error frame with the request ID `ConnectionRequestID` concerns the whole connection, e.g. `CodeBusy` when the
+client has too many connections open: the server closes the connection right after it.
+
+---
+
+### WriteHeader(w io.Writer, codec Codec, requestID uint32, header Header) error / DecodeHeader(codec Codec, payload []byte) (Header, error)
+Sends a request header frame, or decodes the payload of a received one.
+
+### WriteError(w io.Writer, codec Codec, requestID uint32, code string, message string) error / DecodeError(codec Codec, payload []byte) ErrorMessage
+Sends an error frame, or decodes the payload of a received one.
+
+### WriteAuth(w io.Writer, codec Codec, auth Auth) error / DecodeAuth(codec Codec, payload []byte) (Auth, error)
+Sends the authentication frame of a connection, or decodes the payload of a received one.
+
+### WriteMetadata(w io.Writer, codec Codec, requestID uint32, metadata Metadata) error / DecodeMetadata(codec Codec, payload []byte) (Metadata, error)
+Sends a metadata frame, or decodes the payload of a received one.
+
+### WriteProgress(w io.Writer, codec Codec, requestID uint32, progress Progress) error / DecodeProgress(codec Codec, payload []byte) (Progress, error)
+Sends a progress frame, or decodes the payload of a received one.
+
+### WriteArtifact(w io.Writer, requestID uint32, artifact Artifact) error / DecodeArtifact(payload []byte) (Artifact, error)
+Sends an artifact frame, or decodes the payload of a received one. The payload is binary whatever the codec of
+the connection, to avoid encoding the image.
+
+### WriteTrailer(w io.Writer, codec Codec, requestID uint32, trailer Trailer) error / DecodeTrailer(codec Codec, payload []byte) (Trailer, error)
+Sends a trailer frame, or decodes the payload of a received one.
+
+### WriteEnd(w io.Writer, requestID uint32) error
+Sends the frame closing a response.
+
+### writeMessage(w io.Writer, codec Codec, frameType FrameType, requestID uint32, message Message) error
+Encodes a control message with `codec` and sends it in a frame.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	CodeBadRequest    = "bad_request"
+	CodeTooLarge      = "too_large"
+	CodeUnavailable   = "unavailable"
+	CodeNotFound      = "not_found"
+	CodeBusy          = "busy"
+	CodeUnauthorized  = "unauthorized"
+	CodeQuotaExceeded = "quota_exceeded"
+	CodeInternal      = "internal"
+)
+
+const ConnectionRequestID = 0
+
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+const (
+	StageGrayscale = "grayscale"
+	StageEdges     = "edges"
+	StageContours  = "contours"
+	StageCropping  = "cropping"
+)
+
+const (
+	OperationCrop      = "crop"
+	OperationGrayscale = "grayscale"
+	OperationEdges     = "edges"
+	OperationEstimate  = "estimate"
+	OperationCorners   = "corners"
+	OperationScan      = "scan"
+)
+
+const (
+	FormatJSON = "json"
+	FormatPDF  = "pdf"
+	FormatZIP  = "zip"
+)
+
+const ManifestName = "manifest.json"
+
+const (
+	PresetDocument   = "document"
+	PresetWhiteboard = "whiteboard"
+	PresetReceipt    = "receipt"
+	PresetPhoto      = "photo"
+	PresetIDCard     = "id-card"
+	PresetBook       = "book"
+)
+
+const (
+	DetectorContours = "contours"
+	DetectorHough    = "hough"
+	DetectorRANSAC   = "ransac"
+)
+
+const (
+	EdgeDetectorCanny = "canny"
+	EdgeDetectorLoG   = "log"
+)
+
+const (
+	BorderKeep     = "keep"
+	BorderPenalize = "penalize"
+	BorderDiscard  = "discard"
+)
+
+const (
+	WhiteBalanceAuto       = "auto"
+	WhiteBalanceWhitePatch = "white-patch"
+	WhiteBalanceGrayWorld  = "gray-world"
+	WhiteBalanceOff        = "off"
+)
+
+const (
+	ArtifactGrayscale  = "grayscale"
+	ArtifactEdges      = "edges"
+	ArtifactContours   = "contours"
+	ArtifactHistograms = "histograms"
+)
+
+const (
+	PositionTopLeft     = "top-left"
+	PositionTopRight    = "top-right"
+	PositionBottomLeft  = "bottom-left"
+	PositionBottomRight = "bottom-right"
+	PositionCenter      = "center"
+)
+
+const DefaultStampOpacity = 0.6
+
+const (
+	DefaultDocumentArea   = 0.01
+	DefaultDocumentAspect = 0.1
+)
+
+const (
+	DefaultSpeckSize   = 8
+	DefaultPinholeSize = 8
+)
+
+const (
+	MinGamma = 0.1
+	MaxGamma = 10.0
+)
+
+const maxArtifactName = 255
+
+const (
+	TimingReceive       = "receive"
+	TimingGrayscale     = "grayscale"
+	TimingBlur          = "blur"
+	TimingSobel         = "sobel"
+	TimingNMS           = "nms"
+	TimingHysteresis    = "hysteresis"
+	TimingMorphology    = "morphology"
+	TimingBFS           = "bfs"
+	TimingQuadrilateral = "quadrilateral"
+	TimingHough         = "hough"
+	TimingCrop          = "crop"
+	TimingDeskew        = "deskew"
+	TimingEnhance       = "enhance"
+	TimingAnonymize     = "anonymize"
+	TimingOrient        = "orient"
+	TimingOCR           = "ocr"
+	TimingStamp         = "stamp"
+	TimingEncode        = "encode"
+	TimingSend          = "send"
+)
+
+type Header struct {
+	PageSize      string   `json:"pageSize,omitempty"`
+	DPI           int      `json:"dpi,omitempty"`
+	Async         bool     `json:"async,omitempty"`
+	JobID         string   `json:"jobId,omitempty"`
+	Progress      bool     `json:"progress,omitempty"`
+	Artifacts     []string `json:"artifacts,omitempty"`
+	Webhook       string   `json:"webhook,omitempty"`
+	Operation     string   `json:"operation,omitempty"`
+	Format        string   `json:"format,omitempty"`
+	Deterministic bool     `json:"deterministic,omitempty"`
+	Anonymize     bool     `json:"anonymize,omitempty"`
+	Stamp         *Stamp   `json:"stamp,omitempty"`
+	Offset        int64    `json:"offset,omitempty"`
+	Preset        string   `json:"preset,omitempty"`
+	NoCache       bool     `json:"noCache,omitempty"`
+	Source        string   `json:"source,omitempty"`
+	OCR           bool     `json:"ocr,omitempty"`
+	Orient        bool     `json:"orient,omitempty"`
+	Session       string   `json:"session,omitempty"`
+	Page          int      `json:"page,omitempty"`
+	Finalize      bool     `json:"finalize,omitempty"`
+	Border        string   `json:"border,omitempty"`
+	Centering     float64  `json:"centering,omitempty"`
+	Detector      string   `json:"detector,omitempty"`
+	Shape         string   `json:"shape,omitempty"`
+	Deskew        bool     `json:"deskew,omitempty"`
+	Flatten       bool     `json:"flatten,omitempty"`
+	Binarize      bool     `json:"binarize,omitempty"`
+	Accept        []string `json:"accept,omitempty"`
+	WhiteBalance  string   `json:"whiteBalance,omitempty"`
+	Lossless      bool     `json:"lossless,omitempty"`
+	EdgeDetector  string   `json:"edgeDetector,omitempty"`
+	FillHoles     bool     `json:"fillHoles,omitempty"`
+
+	MultiDocument *MultiDocument `json:"multiDocument,omitempty"`
+	Tone          *Tone          `json:"tone,omitempty"`
+	DetectionTone *Tone          `json:"detectionTone,omitempty"`
+	Despeckle     *Despeckle     `json:"despeckle,omitempty"`
+}
+
+type Tone struct {
+	Gamma      float64 `json:"gamma,omitempty"`
+	Brightness float64 `json:"brightness,omitempty"`
+	Contrast   float64 `json:"contrast,omitempty"`
+}
+
+type Despeckle struct {
+	SpeckSize   int `json:"speckSize,omitempty"`
+	PinholeSize int `json:"pinholeSize,omitempty"`
+}
+
+type MultiDocument struct {
+	MinArea   float64 `json:"minArea,omitempty"`
+	MinAspect float64 `json:"minAspect,omitempty"`
+}
+
+type Stamp struct {
+	Text     string  `json:"text,omitempty"`
+	Image    []byte  `json:"image,omitempty"`
+	Position string  `json:"position,omitempty"`
+	Opacity  float64 `json:"opacity,omitempty"`
+}
+
+type Auth struct {
+	Token string `json:"token"`
+}
+
+type Metadata struct {
+	JobID    string         `json:"jobId,omitempty"`
+	Status   string         `json:"status,omitempty"`
+	Error    string         `json:"error,omitempty"`
+	Format   string         `json:"format,omitempty"`
+	Stats    *TransferStats `json:"stats,omitempty"`
+	Estimate *Estimate      `json:"estimate,omitempty"`
+	Size     int64          `json:"size,omitempty"`
+	Colors   *ColorStats    `json:"colors,omitempty"`
+	Text     string         `json:"text,omitempty"`
+	Rotation int            `json:"rotation,omitempty"`
+	Pages    int            `json:"pages,omitempty"`
+	Lossless bool           `json:"lossless,omitempty"`
+	Corners  []Point        `json:"corners,omitempty"`
+	Spread   bool           `json:"spread,omitempty"`
+
+	Alternatives []Candidate `json:"alternatives,omitempty"`
+}
+
+type Candidate struct {
+	Corners        []Point `json:"corners"`
+	Area           float64 `json:"area"`
+	Score          float64 `json:"score"`
+	Validation     float64 `json:"validation"`
+	CenterDistance float64 `json:"centerDistance"`
+	Aspect         float64 `json:"aspect"`
+}
+
+type ColorStats struct {
+	Red          ChannelStats `json:"red"`
+	Green        ChannelStats `json:"green"`
+	Blue         ChannelStats `json:"blue"`
+	Cast         string       `json:"cast,omitempty"`
+	CastStrength float64      `json:"castStrength"`
+	Balanced     bool         `json:"balanced,omitempty"`
+}
+
+type ChannelStats struct {
+	Mean float64 `json:"mean"`
+	P5   int     `json:"p5"`
+	P50  int     `json:"p50"`
+	P95  int     `json:"p95"`
+}
+
+type Document struct {
+	Width   int     `json:"width"`
+	Height  int     `json:"height"`
+	Corners []Point `json:"corners"`
+	Area    float64 `json:"area"`
+
+	Documents []Candidate `json:"documents,omitempty"`
+}
+
+type Point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type Manifest struct {
+	Entries []ManifestEntry `json:"entries"`
+}
+
+type ManifestEntry struct {
+	File     string `json:"file,omitempty"`
+	Input    string `json:"input,omitempty"`
+	Page     int    `json:"page"`
+	Format   string `json:"format,omitempty"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Rotation int    `json:"rotation,omitempty"`
+	Report   string `json:"report,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type Estimate struct {
+	Width      int           `json:"width"`
+	Height     int           `json:"height"`
+	Megapixels float64       `json:"megapixels"`
+	Chunks     int           `json:"chunks"`
+	Millis     float64       `json:"ms"`
+	Stages     []StageTiming `json:"stages,omitempty"`
+}
+
+type Progress struct {
+	Stage string `json:"stage"`
+	Step  int    `json:"step"`
+	Steps int    `json:"steps"`
+}
+
+type Artifact struct {
+	Name string
+	Data []byte
+}
+
+type Trailer struct {
+	Timings []StageTiming `json:"timings"`
+}
+
+type StageTiming struct {
+	Stage  string  `json:"stage"`
+	Millis float64 `json:"ms"`
+}
+
+type TransferStats struct {
+	Protocol      string   `json:"protocol"`
+	Features      []string `json:"features,omitempty"`
+	BytesReceived int64    `json:"bytesReceived"`
+	BytesSent     int64    `json:"bytesSent"`
+	ReceiveMillis float64  `json:"receiveMs"`
+	ProcessMillis float64  `json:"processMs"`
+}
+
+type ErrorMessage struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (errorMessage ErrorMessage) Error() string {
+	return fmt.Sprintf("%s: %s", errorMessage.Code, errorMessage.Message)
+}
+
+func WriteHeader(w io.Writer, codec Codec, requestID uint32, header Header) error {
+	return writeMessage(w, codec, FrameHeader, requestID, &header)
+}
+
+func DecodeHeader(codec Codec, payload []byte) (Header, error) {
+	var header Header
+	if err := codec.Unmarshal(payload, &header); err != nil {
+		return header, fmt.Errorf("protocol: invalid header: %w", err)
+	}
+
+	return header, nil
+}
+
+func WriteError(w io.Writer, codec Codec, requestID uint32, code string, message string) error {
+	return writeMessage(w, codec, FrameError, requestID, &ErrorMessage{Code: code, Message: message})
+}
+
+func DecodeError(codec Codec, payload []byte) ErrorMessage {
+	var errorMessage ErrorMessage
+	if err := codec.Unmarshal(payload, &errorMessage); err != nil {
+		return ErrorMessage{Code: CodeInternal, Message: string(payload)}
+	}
+	return errorMessage
+}
+
+func WriteAuth(w io.Writer, codec Codec, auth Auth) error {
+	return writeMessage(w, codec, FrameAuth, ConnectionRequestID, &auth)
+}
+
+func DecodeAuth(codec Codec, payload []byte) (Auth, error) {
+	var auth Auth
+	if err := codec.Unmarshal(payload, &auth); err != nil {
+		return auth, fmt.Errorf("protocol: invalid auth: %w", err)
+	}
+
+	return auth, nil
+}
+
+func WriteMetadata(w io.Writer, codec Codec, requestID uint32, metadata Metadata) error {
+	return writeMessage(w, codec, FrameMetadata, requestID, &metadata)
+}
+
+func DecodeMetadata(codec Codec, payload []byte) (Metadata, error) {
+	var metadata Metadata
+	if err := codec.Unmarshal(payload, &metadata); err != nil {
+		return metadata, fmt.Errorf("protocol: invalid metadata: %w", err)
+	}
+
+	return metadata, nil
+}
+
+func WriteProgress(w io.Writer, codec Codec, requestID uint32, progress Progress) error {
+	return writeMessage(w, codec, FrameProgress, requestID, &progress)
+}
+
+func DecodeProgress(codec Codec, payload []byte) (Progress, error) {
+	var progress Progress
+	if err := codec.Unmarshal(payload, &progress); err != nil {
+		return progress, fmt.Errorf("protocol: invalid progress: %w", err)
+	}
+
+	return progress, nil
+}
+
+func WriteArtifact(w io.Writer, requestID uint32, artifact Artifact) error {
+	if len(artifact.Name) > maxArtifactName {
+		return fmt.Errorf("protocol: artifact name too long: %q", artifact.Name)
+	}
+
+	if err := WriteFrameHeader(w, FrameArtifact, requestID, 1+len(artifact.Name)+len(artifact.Data)); err != nil {
+		return err
+	}
+	if _, err := w.Write(append([]byte{byte(len(artifact.Name))}, artifact.Name...)); err != nil {
+		return err
+	}
+
+	_, err := w.Write(artifact.Data)
+	return err
+}
+
+func DecodeArtifact(payload []byte) (Artifact, error) {
+	if len(payload) == 0 || len(payload) < 1+int(payload[0]) {
+		return Artifact{}, errors.New("protocol: invalid artifact")
+	}
+
+	nameEnd := 1 + int(payload[0])
+	return Artifact{Name: string(payload[1:nameEnd]), Data: payload[nameEnd:]}, nil
+}
+
+func WriteTrailer(w io.Writer, codec Codec, requestID uint32, trailer Trailer) error {
+	return writeMessage(w, codec, FrameTrailer, requestID, &trailer)
+}
+
+func DecodeTrailer(codec Codec, payload []byte) (Trailer, error) {
+	var trailer Trailer
+	if err := codec.Unmarshal(payload, &trailer); err != nil {
+		return trailer, fmt.Errorf("protocol: invalid trailer: %w", err)
+	}
+
+	return trailer, nil
+}
+
+func WriteEnd(w io.Writer, requestID uint32) error {
+	return WriteFrame(w, FrameEnd, requestID, nil)
+}
+
+func writeMessage(w io.Writer, codec Codec, frameType FrameType, requestID uint32, message Message) error {
+	payload, err := codec.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	return WriteFrame(w, frameType, requestID, payload)
+}
@@ -0,0 +1,864 @@
+package protocol
+
+/*
+This file implements the protobuf encoding of the control messages, following the schema of `elp.proto`. The
+encoding is written by hand to keep the module free of dependencies: it supports the few field types used by the
+schema, and must be updated with it.
+
+---
+
+### Encoding
+- Fields holding their default value (empty string, 0, false, nil) are left out, as proto3 does.
+- `int32` and `int64` fields are varints, `double` fields are 64-bit little endian floats, strings and nested
+  messages are length-delimited.
+- Decoding skips the fields it does not know, so a peer can add fields to the schema without breaking older ones.
+  A known field with an unexpected wire type makes the message invalid.
+
+---
+
+### `protoWriter`
+Appends the fields of a message to a buffer.
+
+- Methods:
+  - `string(field int, value string)`, `strings(field int, values []string)`: Appends a string or repeated string
+    field.
+  - `bool(field int, value bool)`, `int(field int, value int64)`, `double(field int, value float64)`: Appends a
+    scalar field.
+  - `message(field int, value Message)`: Appends a nested message.
+
+### `protoReader`
+Reads the fields of a message one by one.
+
+- Methods:
+  - `next() bool`: Reads the tag of the next field into `field` and `wireType`, returns false at the end of the
+    message or on error.
+  - `string() string`, `bool() bool`, `int() int64`, `double() float64`, `bytes() []byte`: Reads the value of the
+    current field.
+  - `message(value Message)`: Decodes the current field into a nested message.
+  - `skip()`: Skips the value of an unknown field.
+  - `err`: The first error met, which stops the reading.
+
+---
+
+### `MarshalProto() []byte` / `UnmarshalProto(payload []byte) error`
+Encode or decode a message. Implemented by `*Header`, `*Stamp`, `*MultiDocument`, `*Tone`, `*Despeckle`, `*Auth`,
+`*Metadata`, `*TransferStats`, `*Estimate`, `*Progress`, `*Trailer`, `*StageTiming` and `*ErrorMessage`. `UnmarshalProto` resets the message first.
+*/
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncated = errors.New("protocol: truncated protobuf message")
+
+type protoWriter struct {
+	buffer []byte
+}
+
+func (writer *protoWriter) tag(field int, wireType int) {
+	writer.buffer = binary.AppendUvarint(writer.buffer, uint64(field)<<3|uint64(wireType))
+}
+
+func (writer *protoWriter) bytes(field int, value []byte) {
+	writer.tag(field, wireBytes)
+	writer.buffer = binary.AppendUvarint(writer.buffer, uint64(len(value)))
+	writer.buffer = append(writer.buffer, value...)
+}
+
+func (writer *protoWriter) string(field int, value string) {
+	if value != "" {
+		writer.bytes(field, []byte(value))
+	}
+}
+
+func (writer *protoWriter) strings(field int, values []string) {
+	for _, value := range values {
+		writer.bytes(field, []byte(value))
+	}
+}
+
+func (writer *protoWriter) bool(field int, value bool) {
+	if value {
+		writer.tag(field, wireVarint)
+		writer.buffer = append(writer.buffer, 1)
+	}
+}
+
+func (writer *protoWriter) int(field int, value int64) {
+	if value != 0 {
+		writer.tag(field, wireVarint)
+		writer.buffer = binary.AppendUvarint(writer.buffer, uint64(value))
+	}
+}
+
+func (writer *protoWriter) double(field int, value float64) {
+	if value != 0 {
+		writer.tag(field, wireFixed64)
+		writer.buffer = binary.LittleEndian.AppendUint64(writer.buffer, math.Float64bits(value))
+	}
+}
+
+func (writer *protoWriter) message(field int, value Message) {
+	writer.bytes(field, value.MarshalProto())
+}
+
+type protoReader struct {
+	data     []byte
+	field    int
+	wireType int
+	err      error
+}
+
+func (reader *protoReader) varint() uint64 {
+	value, length := binary.Uvarint(reader.data)
+	if length <= 0 {
+		reader.err = errTruncated
+		reader.data = nil
+		return 0
+	}
+	reader.data = reader.data[length:]
+	return value
+}
+
+func (reader *protoReader) next() bool {
+	if reader.err != nil || len(reader.data) == 0 {
+		return false
+	}
+
+	tag := reader.varint()
+	reader.field, reader.wireType = int(tag>>3), int(tag&7)
+	if reader.err == nil && reader.field == 0 {
+		reader.err = errors.New("protocol: invalid protobuf field number 0")
+	}
+	return reader.err == nil
+}
+
+func (reader *protoReader) expect(wireType int) bool {
+	if reader.err == nil && reader.wireType != wireType {
+		reader.err = fmt.Errorf("protocol: protobuf field %d has wire type %d, expected %d",
+			reader.field, reader.wireType, wireType)
+	}
+	return reader.err == nil
+}
+
+func (reader *protoReader) bytes() []byte {
+	if !reader.expect(wireBytes) {
+		return nil
+	}
+
+	length := reader.varint()
+	if reader.err != nil || length > uint64(len(reader.data)) {
+		reader.err = errTruncated
+		return nil
+	}
+	value := reader.data[:length]
+	reader.data = reader.data[length:]
+	return value
+}
+
+func (reader *protoReader) string() string {
+	return string(reader.bytes())
+}
+
+func (reader *protoReader) int() int64 {
+	if !reader.expect(wireVarint) {
+		return 0
+	}
+	return int64(reader.varint())
+}
+
+func (reader *protoReader) bool() bool {
+	return reader.int() != 0
+}
+
+func (reader *protoReader) double() float64 {
+	if !reader.expect(wireFixed64) {
+		return 0
+	}
+	if len(reader.data) < 8 {
+		reader.err = errTruncated
+		return 0
+	}
+	value := math.Float64frombits(binary.LittleEndian.Uint64(reader.data))
+	reader.data = reader.data[8:]
+	return value
+}
+
+func (reader *protoReader) message(value Message) {
+	payload := reader.bytes()
+	if reader.err == nil {
+		reader.err = value.UnmarshalProto(payload)
+	}
+}
+
+func (reader *protoReader) skip() {
+	switch reader.wireType {
+	case wireVarint:
+		reader.varint()
+	case wireFixed64, wireFixed32:
+		size := 8
+		if reader.wireType == wireFixed32 {
+			size = 4
+		}
+		if len(reader.data) < size {
+			reader.err = errTruncated
+			return
+		}
+		reader.data = reader.data[size:]
+	case wireBytes:
+		reader.bytes()
+	default:
+		reader.err = fmt.Errorf("protocol: unsupported protobuf wire type %d", reader.wireType)
+	}
+}
+
+func (header *Header) MarshalProto() []byte {
+	var writer protoWriter
+	writer.string(1, header.PageSize)
+	writer.int(2, int64(header.DPI))
+	writer.bool(3, header.Async)
+	writer.string(4, header.JobID)
+	writer.bool(5, header.Progress)
+	writer.strings(6, header.Artifacts)
+	writer.string(7, header.Webhook)
+	writer.string(8, header.Operation)
+	writer.string(9, header.Format)
+	writer.bool(10, header.Deterministic)
+	writer.bool(11, header.Anonymize)
+	if header.Stamp != nil {
+		writer.message(12, header.Stamp)
+	}
+	writer.int(13, header.Offset)
+	writer.string(14, header.Preset)
+	writer.bool(15, header.NoCache)
+	writer.string(16, header.Source)
+	writer.bool(17, header.OCR)
+	writer.bool(18, header.Orient)
+	writer.string(19, header.Session)
+	writer.int(20, int64(header.Page))
+	writer.bool(21, header.Finalize)
+	writer.string(22, header.Border)
+	writer.double(23, header.Centering)
+	writer.string(24, header.Detector)
+	if header.MultiDocument != nil {
+		writer.message(25, header.MultiDocument)
+	}
+	writer.string(26, header.Shape)
+	writer.bool(27, header.Deskew)
+	writer.bool(28, header.Flatten)
+	writer.bool(29, header.Binarize)
+	if header.Tone != nil {
+		writer.message(30, header.Tone)
+	}
+	if header.DetectionTone != nil {
+		writer.message(31, header.DetectionTone)
+	}
+	writer.strings(32, header.Accept)
+	writer.string(33, header.WhiteBalance)
+	writer.bool(34, header.Lossless)
+	writer.string(35, header.EdgeDetector)
+	writer.bool(36, header.FillHoles)
+	if header.Despeckle != nil {
+		writer.message(37, header.Despeckle)
+	}
+	return writer.buffer
+}
+
+func (header *Header) UnmarshalProto(payload []byte) error {
+	*header = Header{}
+	reader := protoReader{data: payload}
+	for reader.next() {
+		switch reader.field {
+		case 1:
+			header.PageSize = reader.string()
+		case 2:
+			header.DPI = int(int32(reader.int()))
+		case 3:
+			header.Async = reader.bool()
+		case 4:
+			header.JobID = reader.string()
+		case 5:
+			header.Progress = reader.bool()
+		case 6:
+			header.Artifacts = append(header.Artifacts, reader.string())
+		case 7:
+			header.Webhook = reader.string()
+		case 8:
+			header.Operation = reader.string()
+		case 9:
+			header.Format = reader.string()
+		case 10:
+			header.Deterministic = reader.bool()
+		case 11:
+			header.Anonymize = reader.bool()
+		case 12:
+			header.Stamp = &Stamp{}
+			reader.message(header.Stamp)
+		case 13:
+			header.Offset = reader.int()
+		case 14:
+			header.Preset = reader.string()
+		case 15:
+			header.NoCache = reader.bool()
+		case 16:
+			header.Source = reader.string()
+		case 17:
+			header.OCR = reader.bool()
+		case 18:
+			header.Orient = reader.bool()
+		case 19:
+			header.Session = reader.string()
+		case 20:
+			header.Page = int(int32(reader.int()))
+		case 21:
+			header.Finalize = reader.bool()
+		case 22:
+			header.Border = reader.string()
+		case 23:
+			header.Centering = reader.double()
+		case 24:
+			header.Detector = reader.string()
+		case 25:
+			header.MultiDocument = &MultiDocument{}
+			reader.message(header.MultiDocument)
+		case 26:
+			header.Shape = reader.string()
+		case 27:
+			header.Deskew = reader.bool()
+		case 28:
+			header.Flatten = reader.bool()
+		case 29:
+			header.Binarize = reader.bool()
+		case 30:
+			header.Tone = &Tone{}
+			reader.message(header.Tone)
+		case 31:
+			header.DetectionTone = &Tone{}
+			reader.message(header.DetectionTone)
+		case 32:
+			header.Accept = append(header.Accept, reader.string())
+		case 33:
+			header.WhiteBalance = reader.string()
+		case 34:
+			header.Lossless = reader.bool()
+		case 35:
+			header.EdgeDetector = reader.string()
+		case 36:
+			header.FillHoles = reader.bool()
+		case 37:
+			header.Despeckle = &Despeckle{}
+			reader.message(header.Despeckle)
+		default:
+			reader.skip()
+		}
+	}
+	return reader.err
+}
+
+func (stamp *Stamp) MarshalProto() []byte {
+	var writer protoWriter
+	writer.string(1, stamp.Text)
+	if len(stamp.Image) > 0 {
+		writer.bytes(2, stamp.Image)
+	}
+	writer.string(3, stamp.Position)
+	writer.double(4, stamp.Opacity)
+	return writer.buffer
+}
+
+func (stamp *Stamp) UnmarshalProto(payload []byte) error {
+	*stamp = Stamp{}
+	reader := protoReader{data: payload}
+	for reader.next() {
+		switch reader.field {
+		case 1:
+			stamp.Text = reader.string()
+		case 2:
+			stamp.Image = reader.bytes()
+		case 3:
+			stamp.Position = reader.string()
+		case 4:
+			stamp.Opacity = reader.double()
+		default:
+			reader.skip()
+		}
+	}
+	return reader.err
+}
+
+func (multi *MultiDocument) MarshalProto() []byte {
+	var writer protoWriter
+	writer.double(1, multi.MinArea)
+	writer.double(2, multi.MinAspect)
+	return writer.buffer
+}
+
+func (multi *MultiDocument) UnmarshalProto(payload []byte) error {
+	*multi = MultiDocument{}
+	reader := protoReader{data: payload}
+	for reader.next() {
+		switch reader.field {
+		case 1:
+			multi.MinArea = reader.double()
+		case 2:
+			multi.MinAspect = reader.double()
+		default:
+			reader.skip()
+		}
+	}
+	return reader.err
+}
+
+func (despeckle *Despeckle) MarshalProto() []byte {
+	var writer protoWriter
+	writer.int(1, int64(despeckle.SpeckSize))
+	writer.int(2, int64(despeckle.PinholeSize))
+	return writer.buffer
+}
+
+func (despeckle *Despeckle) UnmarshalProto(payload []byte) error {
+	*despeckle = Despeckle{}
+	reader := protoReader{data: payload}
+	for reader.next() {
+		switch reader.field {
+		case 1:
+			despeckle.SpeckSize = int(int32(reader.int()))
+		case 2:
+			despeckle.PinholeSize = int(int32(reader.int()))
+		default:
+			reader.skip()
+		}
+	}
+	return reader.err
+}
+
+func (tone *Tone) MarshalProto() []byte {
+	var writer protoWriter
+	writer.double(1, tone.Gamma)
+	writer.double(2, tone.Brightness)
+	writer.double(3, tone.Contrast)
+	return writer.buffer
+}
+
+func (tone *Tone) UnmarshalProto(payload []byte) error {
+	*tone = Tone{}
+	reader := protoReader{data: payload}
+	for reader.next() {
+		switch reader.field {
+		case 1:
+			tone.Gamma = reader.double()
+		case 2:
+			tone.Brightness = reader.double()
+		case 3:
+			tone.Contrast = reader.double()
+		default:
+			reader.skip()
+		}
+	}
+	return reader.err
+}
+
+func (auth *Auth) MarshalProto() []byte {
+	var writer protoWriter
+	writer.string(1, auth.Token)
+	return writer.buffer
+}
+
+func (auth *Auth) UnmarshalProto(payload []byte) error {
+	*auth = Auth{}
+	reader := protoReader{data: payload}
+	for reader.next() {
+		switch reader.field {
+		case 1:
+			auth.Token = reader.string()
+		default:
+			reader.skip()
+		}
+	}
+	return reader.err
+}
+
+func (metadata *Metadata) MarshalProto() []byte {
+	var writer protoWriter
+	writer.string(1, metadata.JobID)
+	writer.string(2, metadata.Status)
+	writer.string(3, metadata.Error)
+	writer.string(4, metadata.Format)
+	if metadata.Stats != nil {
+		writer.message(5, metadata.Stats)
+	}
+	if metadata.Estimate != nil {
+		writer.message(6, metadata.Estimate)
+	}
+	writer.int(7, metadata.Size)
+	if metadata.Colors != nil {
+		writer.message(8, metadata.Colors)
+	}
+	writer.string(9, metadata.Text)
+	writer.int(10, int64(metadata.Rotation))
+	writer.int(11, int64(metadata.Pages))
+	for i := range metadata.Alternatives {
+		writer.message(12, &metadata.Alternatives[i])
+	}
+	writer.bool(13, metadata.Lossless)
+	for i := range metadata.Corners {
+		writer.message(14, &metadata.Corners[i])
+	}
+	writer.bool(15, metadata.Spread)
+	return writer.buffer
+}
+
+func (metadata *Metadata) UnmarshalProto(payload []byte) error {
+	*metadata = Metadata{}
+	reader := protoReader{data: payload}
+	for reader.next() {
+		switch reader.field {
+		case 1:
+			metadata.JobID = reader.string()
+		case 2:
+			metadata.Status = reader.string()
+		case 3:
+			metadata.Error = reader.string()
+		case 4:
+			metadata.Format = reader.string()
+		case 5:
+			metadata.Stats = &TransferStats{}
+			reader.message(metadata.Stats)
+		case 6:
+			metadata.Estimate = &Estimate{}
+			reader.message(metadata.Estimate)
+		case 7:
+			metadata.Size = reader.int()
+		case 8:
+			metadata.Colors = &ColorStats{}
+			reader.message(metadata.Colors)
+		case 9:
+			metadata.Text = reader.string()
+		case 10:
+			metadata.Rotation = int(int32(reader.int()))
+		case 11:
+			metadata.Pages = int(int32(reader.int()))
+		case 12:
+			var candidate Candidate
+			reader.message(&candidate)
+			metadata.Alternatives = append(metadata.Alternatives, candidate)
+		case 13:
+			metadata.Lossless = reader.bool()
+		case 14:
+			var point Point
+			reader.message(&point)
+			metadata.Corners = append(metadata.Corners, point)
+		case 15:
+			metadata.Spread = reader.bool()
+		default:
+			reader.skip()
+		}
+	}
+	return reader.err
+}
+
+func (candidate *Candidate) MarshalProto() []byte {
+	var writer protoWriter
+	for i := range candidate.Corners {
+		writer.message(1, &candidate.Corners[i])
+	}
+	writer.double(2, candidate.Area)
+	writer.double(3, candidate.Score)
+	writer.double(4, candidate.Validation)
+	writer.double(5, candidate.CenterDistance)
+	writer.double(6, candidate.Aspect)
+	return writer.buffer
+}
+
+func (candidate *Candidate) UnmarshalProto(payload []byte) error {
+	*candidate = Candidate{}
+	reader := protoReader{data: payload}
+	for reader.next() {
+		switch reader.field {
+		case 1:
+			var point Point
+			reader.message(&point)
+			candidate.Corners = append(candidate.Corners, point)
+		case 2:
+			candidate.Area = reader.double()
+		case 3:
+			candidate.Score = reader.double()
+		case 4:
+			candidate.Validation = reader.double()
+		case 5:
+			candidate.CenterDistance = reader.double()
+		case 6:
+			candidate.Aspect = reader.double()
+		default:
+			reader.skip()
+		}
+	}
+	return reader.err
+}
+
+func (point *Point) MarshalProto() []byte {
+	var writer protoWriter
+	writer.int(1, int64(point.X))
+	writer.int(2, int64(point.Y))
+	return writer.buffer
+}
+
+func (point *Point) UnmarshalProto(payload []byte) error {
+	*point = Point{}
+	reader := protoReader{data: payload}
+	for reader.next() {
+		switch reader.field {
+		case 1:
+			point.X = int(int32(reader.int()))
+		case 2:
+			point.Y = int(int32(reader.int()))
+		default:
+			reader.skip()
+		}
+	}
+	return reader.err
+}
+
+func (stats *TransferStats) MarshalProto() []byte {
+	var writer protoWriter
+	writer.string(1, stats.Protocol)
+	writer.strings(2, stats.Features)
+	writer.int(3, stats.BytesReceived)
+	writer.int(4, stats.BytesSent)
+	writer.double(5, stats.ReceiveMillis)
+	writer.double(6, stats.ProcessMillis)
+	return writer.buffer
+}
+
+func (stats *TransferStats) UnmarshalProto(payload []byte) error {
+	*stats = TransferStats{}
+	reader := protoReader{data: payload}
+	for reader.next() {
+		switch reader.field {
+		case 1:
+			stats.Protocol = reader.string()
+		case 2:
+			stats.Features = append(stats.Features, reader.string())
+		case 3:
+			stats.BytesReceived = reader.int()
+		case 4:
+			stats.BytesSent = reader.int()
+		case 5:
+			stats.ReceiveMillis = reader.double()
+		case 6:
+			stats.ProcessMillis = reader.double()
+		default:
+			reader.skip()
+		}
+	}
+	return reader.err
+}
+
+func (colors *ColorStats) MarshalProto() []byte {
+	var writer protoWriter
+	writer.message(1, &colors.Red)
+	writer.message(2, &colors.Green)
+	writer.message(3, &colors.Blue)
+	writer.string(4, colors.Cast)
+	writer.double(5, colors.CastStrength)
+	writer.bool(6, colors.Balanced)
+	return writer.buffer
+}
+
+func (colors *ColorStats) UnmarshalProto(payload []byte) error {
+	*colors = ColorStats{}
+	reader := protoReader{data: payload}
+	for reader.next() {
+		switch reader.field {
+		case 1:
+			reader.message(&colors.Red)
+		case 2:
+			reader.message(&colors.Green)
+		case 3:
+			reader.message(&colors.Blue)
+		case 4:
+			colors.Cast = reader.string()
+		case 5:
+			colors.CastStrength = reader.double()
+		case 6:
+			colors.Balanced = reader.bool()
+		default:
+			reader.skip()
+		}
+	}
+	return reader.err
+}
+
+func (channel *ChannelStats) MarshalProto() []byte {
+	var writer protoWriter
+	writer.double(1, channel.Mean)
+	writer.int(2, int64(channel.P5))
+	writer.int(3, int64(channel.P50))
+	writer.int(4, int64(channel.P95))
+	return writer.buffer
+}
+
+func (channel *ChannelStats) UnmarshalProto(payload []byte) error {
+	*channel = ChannelStats{}
+	reader := protoReader{data: payload}
+	for reader.next() {
+		switch reader.field {
+		case 1:
+			channel.Mean = reader.double()
+		case 2:
+			channel.P5 = int(int32(reader.int()))
+		case 3:
+			channel.P50 = int(int32(reader.int()))
+		case 4:
+			channel.P95 = int(int32(reader.int()))
+		default:
+			reader.skip()
+		}
+	}
+	return reader.err
+}
+
+func (estimate *Estimate) MarshalProto() []byte {
+	var writer protoWriter
+	writer.int(1, int64(estimate.Width))
+	writer.int(2, int64(estimate.Height))
+	writer.double(3, estimate.Megapixels)
+	writer.int(4, int64(estimate.Chunks))
+	writer.double(5, estimate.Millis)
+	for i := range estimate.Stages {
+		writer.message(6, &estimate.Stages[i])
+	}
+	return writer.buffer
+}
+
+func (estimate *Estimate) UnmarshalProto(payload []byte) error {
+	*estimate = Estimate{}
+	reader := protoReader{data: payload}
+	for reader.next() {
+		switch reader.field {
+		case 1:
+			estimate.Width = int(int32(reader.int()))
+		case 2:
+			estimate.Height = int(int32(reader.int()))
+		case 3:
+			estimate.Megapixels = reader.double()
+		case 4:
+			estimate.Chunks = int(int32(reader.int()))
+		case 5:
+			estimate.Millis = reader.double()
+		case 6:
+			var timing StageTiming
+			reader.message(&timing)
+			estimate.Stages = append(estimate.Stages, timing)
+		default:
+			reader.skip()
+		}
+	}
+	return reader.err
+}
+
+func (progress *Progress) MarshalProto() []byte {
+	var writer protoWriter
+	writer.string(1, progress.Stage)
+	writer.int(2, int64(progress.Step))
+	writer.int(3, int64(progress.Steps))
+	return writer.buffer
+}
+
+func (progress *Progress) UnmarshalProto(payload []byte) error {
+	*progress = Progress{}
+	reader := protoReader{data: payload}
+	for reader.next() {
+		switch reader.field {
+		case 1:
+			progress.Stage = reader.string()
+		case 2:
+			progress.Step = int(int32(reader.int()))
+		case 3:
+			progress.Steps = int(int32(reader.int()))
+		default:
+			reader.skip()
+		}
+	}
+	return reader.err
+}
+
+func (trailer *Trailer) MarshalProto() []byte {
+	var writer protoWriter
+	for i := range trailer.Timings {
+		writer.message(1, &trailer.Timings[i])
+	}
+	return writer.buffer
+}
+
+func (trailer *Trailer) UnmarshalProto(payload []byte) error {
+	*trailer = Trailer{}
+	reader := protoReader{data: payload}
+	for reader.next() {
+		switch reader.field {
+		case 1:
+			var timing StageTiming
+			reader.message(&timing)
+			trailer.Timings = append(trailer.Timings, timing)
+		default:
+			reader.skip()
+		}
+	}
+	return reader.err
+}
+
+func (timing *StageTiming) MarshalProto() []byte {
+	var writer protoWriter
+	writer.string(1, timing.Stage)
+	writer.double(2, timing.Millis)
+	return writer.buffer
+}
+
+func (timing *StageTiming) UnmarshalProto(payload []byte) error {
+	*timing = StageTiming{}
+	reader := protoReader{data: payload}
+	for reader.next() {
+		switch reader.field {
+		case 1:
+			timing.Stage = reader.string()
+		case 2:
+			timing.Millis = reader.double()
+		default:
+			reader.skip()
+		}
+	}
+	return reader.err
+}
+
+func (errorMessage *ErrorMessage) MarshalProto() []byte {
+	var writer protoWriter
+	writer.string(1, errorMessage.Code)
+	writer.string(2, errorMessage.Message)
+	return writer.buffer
+}
+
+func (errorMessage *ErrorMessage) UnmarshalProto(payload []byte) error {
+	*errorMessage = ErrorMessage{}
+	reader := protoReader{data: payload}
+	for reader.next() {
+		switch reader.field {
+		case 1:
+			errorMessage.Code = reader.string()
+		case 2:
+			errorMessage.Message = reader.string()
+		default:
+			reader.skip()
+		}
+	}
+	return reader.err
+}
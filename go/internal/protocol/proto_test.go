@@ -0,0 +1,482 @@
+package protocol_test
+
+/*
+This file tests the encoding of the control messages with both codecs of the connections, the field numbers and wire
+types of the protobuf encoding against `elp.proto`, and the decoding of malformed protobuf payloads.
+
+---
+
+### `messages() []protocol.Message`
+Returns one value of every control message, with every field set to a value other than its default, so a field left
+out of the encoding is caught by the round trip.
+
+### `checkAllSet(t *testing.T, path string, value reflect.Value)`
+Fails the test if a field of `value`, or of the structures it holds, has its default value: a field added to a
+message must be given a value in `messages` to be covered.
+
+### `newMessage(message protocol.Message) protocol.Message`
+Returns a new, empty message of the type of `message`, to decode into.
+
+### `protoField(field int, wireType int, value []byte) []byte`
+Encodes a field of the given number and wire type by hand, followed by the raw bytes of its value.
+
+### `schemaField`
+Field of a message of `elp.proto`: its name, type and whether it is repeated.
+
+### `readSchema(t *testing.T) map[string]map[int]schemaField`
+Parses the messages of `elp.proto`, by name, with their fields by number.
+
+### `schemaWireType(field schemaField, schema map[string]map[int]schemaField) int`
+Returns the wire type protoc encodes a field with, -1 for a type the codec does not support.
+
+### `checkSchema(t *testing.T, schema map[string]map[int]schemaField, message string, payload []byte, seen map[string]bool)`
+Walks the fields of an encoded `message`, and of the messages nested in it, and fails the test if one of them is not
+in the schema or is encoded with another wire type than the schema gives. Records the fields met in `seen`, as
+`Message.field`.
+*/
+
+import (
+	"ELP-project/internal/protocol"
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type schemaField struct {
+	name     string
+	kind     string
+	repeated bool
+}
+
+var (
+	schemaMessagePattern = regexp.MustCompile(`^message (\w+) \{$`)
+	schemaFieldPattern   = regexp.MustCompile(`^(repeated )?(\w+) (\w+) = (\d+);$`)
+)
+
+func messages() []protocol.Message {
+	channel := func(base int) protocol.ChannelStats {
+		return protocol.ChannelStats{Mean: float64(base) + 0.5, P5: base - 40, P50: base, P95: base + 40}
+	}
+	return []protocol.Message{
+		&protocol.Header{
+			PageSize:      "A4",
+			DPI:           300,
+			Async:         true,
+			JobID:         "3f2a9c1e",
+			Progress:      true,
+			Artifacts:     []string{protocol.ArtifactGrayscale, protocol.ArtifactEdges},
+			Webhook:       "https://example.com/done",
+			Operation:     protocol.OperationCrop,
+			Format:        "png",
+			Deterministic: true,
+			Anonymize:     true,
+			Stamp: &protocol.Stamp{
+				Text:     "CONFIDENTIAL {request}",
+				Image:    []byte{0x89, 'P', 'N', 'G', 0, 0xff},
+				Position: protocol.PositionBottomRight,
+				Opacity:  0.35,
+			},
+			Offset:       1 << 40,
+			Preset:       protocol.PresetReceipt,
+			NoCache:      true,
+			Source:       "s3://scans/inbox/receipt.jpg",
+			OCR:          true,
+			Orient:       true,
+			Session:      "7c1e52d0-session",
+			Page:         3,
+			Finalize:     true,
+			Border:       protocol.BorderPenalize,
+			Centering:    0.4,
+			Detector:     protocol.DetectorHough,
+			Shape:        "Letter",
+			Deskew:       true,
+			Flatten:      true,
+			Binarize:     true,
+			Accept:       []string{"webp", "png"},
+			WhiteBalance: protocol.WhiteBalanceGrayWorld,
+			Lossless:     true,
+			EdgeDetector: protocol.EdgeDetectorLoG,
+			FillHoles:    true,
+			MultiDocument: &protocol.MultiDocument{
+				MinArea:   0.02,
+				MinAspect: 0.25,
+			},
+			Tone:          &protocol.Tone{Gamma: 1.8, Brightness: -0.05, Contrast: 0.3},
+			DetectionTone: &protocol.Tone{Gamma: 2.2, Brightness: 0.1, Contrast: -0.2},
+			Despeckle:     &protocol.Despeckle{SpeckSize: 12, PinholeSize: -1},
+		},
+		&protocol.Auth{Token: "secret-token"},
+		&protocol.Metadata{
+			JobID:  "3f2a9c1e",
+			Status: protocol.StatusDone,
+			Error:  "no document found",
+			Format: "jpeg",
+			Stats: &protocol.TransferStats{
+				Protocol:      "ELP2",
+				Features:      []string{"pipelining", "progress"},
+				BytesReceived: 1 << 33,
+				BytesSent:     123456,
+				ReceiveMillis: 12.25,
+				ProcessMillis: 480.5,
+			},
+			Estimate: &protocol.Estimate{
+				Width:      4000,
+				Height:     3000,
+				Megapixels: 12,
+				Chunks:     16,
+				Millis:     950.75,
+				Stages:     []protocol.StageTiming{{Stage: protocol.TimingBlur, Millis: 40}, {Stage: protocol.TimingSobel, Millis: 55.5}},
+			},
+			Size: 987654,
+			Colors: &protocol.ColorStats{
+				Red:          channel(180),
+				Green:        channel(170),
+				Blue:         channel(120),
+				Cast:         "yellow",
+				CastStrength: 0.42,
+				Balanced:     true,
+			},
+			Text:     "INVOICE 2024-117\nTotal 42.00 EUR",
+			Rotation: 180,
+			Pages:    3,
+			Lossless: true,
+			Corners:  []protocol.Point{{X: 15, Y: 22}, {X: 990, Y: 35}, {X: 981, Y: 1410}, {X: 4, Y: 1398}},
+			Spread:   true,
+			Alternatives: []protocol.Candidate{{
+				Corners:        []protocol.Point{{X: 12, Y: 30}, {X: 980, Y: 41}, {X: 975, Y: 1400}, {X: 8, Y: 1391}},
+				Area:           1.3e6,
+				Score:          0.97e6,
+				Validation:     0.98,
+				CenterDistance: 0.12,
+				Aspect:         0.7,
+			}},
+		},
+		&protocol.Progress{Stage: protocol.TimingHysteresis, Step: 6, Steps: 14},
+		&protocol.Trailer{Timings: []protocol.StageTiming{{Stage: protocol.TimingReceive, Millis: 3.5}, {Stage: protocol.TimingEncode, Millis: -1}}},
+		&protocol.ErrorMessage{Code: protocol.CodeTooLarge, Message: "image of 20000x20000 pixels exceeds the limits"},
+	}
+}
+
+func checkAllSet(t *testing.T, path string, value reflect.Value) {
+	t.Helper()
+
+	switch value.Kind() {
+	case reflect.Pointer:
+		if value.IsNil() {
+			t.Errorf("%s is not set", path)
+			return
+		}
+		checkAllSet(t, path, value.Elem())
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			checkAllSet(t, path+"."+value.Type().Field(i).Name, value.Field(i))
+		}
+	case reflect.Slice:
+		if value.Len() == 0 {
+			t.Errorf("%s is not set", path)
+		}
+		if kind := value.Type().Elem().Kind(); kind == reflect.Struct || kind == reflect.Pointer {
+			for i := 0; i < value.Len(); i++ {
+				checkAllSet(t, path, value.Index(i))
+			}
+		}
+	default:
+		if value.IsZero() {
+			t.Errorf("%s is not set", path)
+		}
+	}
+}
+
+func newMessage(message protocol.Message) protocol.Message {
+	return reflect.New(reflect.TypeOf(message).Elem()).Interface().(protocol.Message)
+}
+
+func protoField(field int, wireType int, value []byte) []byte {
+	return append(binary.AppendUvarint(nil, uint64(field)<<3|uint64(wireType)), value...)
+}
+
+func readSchema(t *testing.T) map[string]map[int]schemaField {
+	t.Helper()
+
+	data, err := os.ReadFile("elp.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := make(map[string]map[int]schemaField)
+	var fields map[int]schemaField
+	for number, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if match := schemaMessagePattern.FindStringSubmatch(line); match != nil {
+			fields = make(map[int]schemaField)
+			schema[match[1]] = fields
+		} else if line == "}" {
+			fields = nil
+		} else if fields != nil && line != "" && !strings.HasPrefix(line, "//") {
+			match := schemaFieldPattern.FindStringSubmatch(line)
+			if match == nil {
+				t.Fatalf("elp.proto:%d: unsupported field %q", number+1, line)
+			}
+			field, _ := strconv.Atoi(match[4])
+			if _, ok := fields[field]; ok {
+				t.Fatalf("elp.proto:%d: field number %d used twice", number+1, field)
+			}
+			fields[field] = schemaField{name: match[3], kind: match[2], repeated: match[1] != ""}
+		}
+	}
+	return schema
+}
+
+func schemaWireType(field schemaField, schema map[string]map[int]schemaField) int {
+	switch field.kind {
+	case "string", "bytes":
+		return 2
+	case "double":
+		if field.repeated {
+			return 2
+		}
+		return 1
+	case "int32", "int64", "bool":
+		if field.repeated {
+			return 2
+		}
+		return 0
+	}
+	if _, ok := schema[field.kind]; ok {
+		return 2
+	}
+	return -1
+}
+
+func checkSchema(t *testing.T, schema map[string]map[int]schemaField, message string, payload []byte, seen map[string]bool) {
+	t.Helper()
+
+	for len(payload) > 0 {
+		tag, length := binary.Uvarint(payload)
+		if length <= 0 {
+			t.Fatalf("%s: truncated tag", message)
+		}
+		payload = payload[length:]
+		number, wireType := int(tag>>3), int(tag&7)
+
+		field, ok := schema[message][number]
+		if !ok {
+			t.Fatalf("%s: field %d encoded, but not in elp.proto", message, number)
+		}
+		path := message + "." + field.name
+		seen[path] = true
+		if expected := schemaWireType(field, schema); wireType != expected {
+			t.Fatalf("%s (%s, field %d) encoded with wire type %d, expected %d", path, field.kind, number, wireType,
+				expected)
+		}
+
+		switch wireType {
+		case 0:
+			if _, length = binary.Uvarint(payload); length <= 0 {
+				t.Fatalf("%s: truncated varint", path)
+			}
+			payload = payload[length:]
+		case 1:
+			if len(payload) < 8 {
+				t.Fatalf("%s: truncated double", path)
+			}
+			payload = payload[8:]
+		case 2:
+			size, length := binary.Uvarint(payload)
+			if length <= 0 || size > uint64(len(payload)-length) {
+				t.Fatalf("%s: truncated value", path)
+			}
+			value := payload[length : length+int(size)]
+			payload = payload[length+int(size):]
+			if _, nested := schema[field.kind]; nested {
+				checkSchema(t, schema, field.kind, value, seen)
+			}
+		}
+	}
+}
+
+func TestMessagesAllSet(t *testing.T) {
+	for _, message := range messages() {
+		checkAllSet(t, reflect.TypeOf(message).Elem().Name(), reflect.ValueOf(message))
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	codecs := map[string]protocol.Codec{"json": protocol.JSON, "protobuf": protocol.Protobuf}
+	for name, codec := range codecs {
+		for _, message := range messages() {
+			t.Run(name+"/"+reflect.TypeOf(message).Elem().Name(), func(t *testing.T) {
+				payload, err := codec.Marshal(message)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				decoded := newMessage(message)
+				if err := codec.Unmarshal(payload, decoded); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(decoded, message) {
+					t.Fatalf("decoded %+v, expected %+v", decoded, message)
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeResets(t *testing.T) {
+	for _, message := range messages() {
+		decoded := newMessage(message)
+		if err := decoded.UnmarshalProto(message.MarshalProto()); err != nil {
+			t.Fatal(err)
+		}
+		if err := decoded.UnmarshalProto(nil); err != nil {
+			t.Fatal(err)
+		}
+		if empty := newMessage(message); !reflect.DeepEqual(decoded, empty) {
+			t.Fatalf("empty payload decoded as %+v, expected %+v", decoded, empty)
+		}
+	}
+}
+
+func TestProtoWireFormat(t *testing.T) {
+	// The bytes protoc gives for the same values of elp.proto.
+	tests := []struct {
+		message  protocol.Message
+		expected []byte
+	}{
+		{&protocol.Header{PageSize: "A4", DPI: 300}, []byte{0x0a, 0x02, 'A', '4', 0x10, 0xac, 0x02}},
+		{&protocol.Header{Async: true, Offset: 150}, []byte{0x18, 0x01, 0x68, 0x96, 0x01}},
+		{&protocol.Header{Stamp: &protocol.Stamp{Opacity: 1}}, []byte{0x62, 0x09, 0x21, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f}},
+		{&protocol.Progress{Step: -1}, []byte{0x10, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{&protocol.Header{}, nil},
+	}
+	for _, test := range tests {
+		if payload := test.message.MarshalProto(); !bytes.Equal(payload, test.expected) {
+			t.Errorf("%+v encoded as % x, expected % x", test.message, payload, test.expected)
+		}
+	}
+}
+
+func TestProtoSchema(t *testing.T) {
+	// Every field the codec writes must be in elp.proto with the same number and wire type, and every field of
+	// elp.proto must be written: the round trip then checks that the decoder reads them back with the same numbers.
+	schema := readSchema(t)
+	seen := make(map[string]bool)
+	for _, message := range messages() {
+		name := reflect.TypeOf(message).Elem().Name()
+		if _, ok := schema[name]; !ok {
+			t.Fatalf("message %s not in elp.proto", name)
+		}
+		checkSchema(t, schema, name, message.MarshalProto(), seen)
+	}
+
+	for message, fields := range schema {
+		for number, field := range fields {
+			if path := message + "." + field.name; !seen[path] {
+				t.Errorf("%s (field %d) of elp.proto never encoded", path, number)
+			}
+		}
+	}
+}
+
+func TestProtoSkipsUnknownFields(t *testing.T) {
+	fixed64 := binary.LittleEndian.AppendUint64(nil, math.Float64bits(2.5))
+	unknown := [][]byte{
+		protoField(100, 0, binary.AppendUvarint(nil, 1<<60)),
+		protoField(101, 1, fixed64),
+		protoField(102, 2, append([]byte{5}, "extra"...)),
+		protoField(103, 5, []byte{1, 2, 3, 4}),
+	}
+
+	for _, message := range messages() {
+		payload := message.MarshalProto()
+		// Unknown fields of every wire type, before and after the known ones.
+		var extended []byte
+		extended = append(extended, unknown[0]...)
+		extended = append(extended, unknown[1]...)
+		extended = append(extended, payload...)
+		extended = append(extended, unknown[2]...)
+		extended = append(extended, unknown[3]...)
+
+		decoded := newMessage(message)
+		if err := decoded.UnmarshalProto(extended); err != nil {
+			t.Fatalf("%T: %v", message, err)
+		}
+		if !reflect.DeepEqual(decoded, message) {
+			t.Fatalf("decoded %+v, expected %+v", decoded, message)
+		}
+	}
+}
+
+func TestProtoRejectsMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+	}{
+		{"truncated tag", []byte{0x80}},
+		{"truncated varint", []byte{0x10, 0xac}},
+		{"overlong varint", append([]byte{0x10}, bytes.Repeat([]byte{0xff}, 11)...)},
+		{"truncated length", []byte{0x0a, 0x80}},
+		{"length beyond the payload", []byte{0x0a, 0x05, 'A', '4'}},
+		{"truncated double", append([]byte{0x62, 0x05}, protoField(4, 1, []byte{0, 0, 0, 0})...)},
+		{"truncated nested message", []byte{0x62, 0x03, 0x0a, 0x05, 'x'}},
+		{"truncated unknown fixed64", protoField(100, 1, []byte{1, 2, 3})},
+		{"truncated unknown fixed32", protoField(100, 5, []byte{1, 2})},
+		{"truncated unknown bytes", protoField(100, 2, []byte{0x09, 'x'})},
+		{"unsupported wire type", protoField(100, 3, nil)},
+		{"field number 0", []byte{0x00, 0x01}},
+		{"wrong wire type", protoField(1, 0, []byte{0x01})},
+	}
+	for _, test := range tests {
+		var header protocol.Header
+		if err := header.UnmarshalProto(test.payload); err == nil {
+			t.Errorf("%s: % x decoded as %+v, expected an error", test.name, test.payload, header)
+		}
+	}
+}
+
+func TestProtoRejectsTruncatedMessages(t *testing.T) {
+	// Cutting the encoding of a message inside its last field leaves that field truncated.
+	for _, message := range messages() {
+		payload := message.MarshalProto()
+		decoded := newMessage(message)
+		if err := decoded.UnmarshalProto(payload[:len(payload)-1]); err == nil {
+			t.Errorf("%T: truncated payload decoded as %+v, expected an error", message, decoded)
+		}
+	}
+}
+
+func TestArtifactRoundTrip(t *testing.T) {
+	artifact := protocol.Artifact{Name: protocol.ArtifactEdges, Data: []byte{0x89, 'P', 'N', 'G'}}
+
+	var buffer bytes.Buffer
+	if err := protocol.WriteArtifact(&buffer, 7, artifact); err != nil {
+		t.Fatal(err)
+	}
+	frameType, requestID, length, err := protocol.ReadFrameHeader(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frameType != protocol.FrameArtifact || requestID != 7 || length != buffer.Len() {
+		t.Fatalf("%s frame for request %d of %d bytes, expected an artifact frame for request 7 of %d bytes",
+			frameType, requestID, length, buffer.Len())
+	}
+
+	decoded, err := protocol.DecodeArtifact(buffer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, artifact) {
+		t.Fatalf("decoded %+v, expected %+v", decoded, artifact)
+	}
+
+	if _, err := protocol.DecodeArtifact([]byte{5, 'e', 'd'}); err == nil {
+		t.Fatal("artifact with a truncated name decoded, expected an error")
+	}
+}
@@ -0,0 +1,144 @@
+package storage
+
+/*
+This file implements the content-addressed store: objects written under the SHA-256 of their bytes on top of any
+`Storage`, so identical outputs, e.g. the same page processed again by another job or session, are stored once.
+
+Every object counts its references: an owner (e.g. the ID of a job) referencing an object writes an empty marker
+`<hash>.ref.<owner>` beside it, and removes it once it no longer needs the object. The object is removed with its
+last marker. The counts live in the storage rather than in memory, so they survive a restart and are shared by the
+server instances using the same bucket.
+
+---
+
+### Constants
+- `contentExtension`: Extension of the keys of the objects, `<hash>.content`.
+- `referenceSeparator`: Separator of the hash and the owner in the keys of the markers.
+
+---
+
+### ContentStore
+Content-addressed objects with reference counting, stored in a `Storage`. The operations of a store are serialized,
+so an object cannot be removed by the release of its last reference while another owner references it. Two server
+instances sharing a bucket can still race on the last reference of an object: the object read afterwards is then
+missing (`ErrNotExist`), never corrupted.
+
+- **Methods**:
+  - `Put(owner string, data []byte) (string, error)`: References the object of `data` on behalf of `owner`, writing
+    it if it is not stored yet, and returns its hash. An owner referencing the same object twice holds a single
+    reference.
+  - `Get(hash string) ([]byte, error)`: Reads an object, `ErrNotExist` if there is none with this hash.
+  - `Release(owner string, hash string) error`: Drops the reference of `owner` to an object, and removes the object
+    if it was the last one. Releasing a reference which does not exist is not an error.
+  - `References(hash string) (int, error)`: Returns the number of owners referencing an object.
+  - `Key(hash string) string`: Returns the key of an object in the underlying storage, e.g. for `Linker.URL`.
+
+---
+
+### NewContentStore(store Storage) *ContentStore
+Returns the content-addressed store over `store`.
+
+### Hash(data []byte) string
+Returns the hash addressing `data`: its SHA-256, in hexadecimal.
+
+---
+
+### Example Usage:
+```go
+content := storage.NewContentStore(store)
+hash, err := content.Put(jobID, result)
+...
+data, err := content.Get(hash)
+err = content.Release(jobID, hash)
+```
+*/
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	contentExtension   = ".content"
+	referenceSeparator = ".ref."
+)
+
+type ContentStore struct {
+	store Storage
+	mutex sync.Mutex
+}
+
+func NewContentStore(store Storage) *ContentStore {
+	return &ContentStore{store: store}
+}
+
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (content *ContentStore) Put(owner string, data []byte) (string, error) {
+	if err := CheckKey(owner); err != nil {
+		return "", err
+	}
+	hash := Hash(data)
+
+	content.mutex.Lock()
+	defer content.mutex.Unlock()
+
+	// The reference is written first: a concurrent release of the last other reference then keeps the object.
+	if err := content.store.Put(hash+referenceSeparator+owner, nil); err != nil {
+		return "", fmt.Errorf("storage: referencing %s: %w", hash, err)
+	}
+	stored, err := content.store.List(content.Key(hash))
+	if err != nil {
+		return "", err
+	}
+	if len(stored) == 0 {
+		if err := content.store.Put(content.Key(hash), data); err != nil {
+			content.store.Delete(hash + referenceSeparator + owner)
+			return "", err
+		}
+	}
+	return hash, nil
+}
+
+func (content *ContentStore) Get(hash string) ([]byte, error) {
+	if err := CheckKey(hash); err != nil {
+		return nil, err
+	}
+	return content.store.Get(content.Key(hash))
+}
+
+func (content *ContentStore) Release(owner string, hash string) error {
+	if err := errors.Join(CheckKey(owner), CheckKey(hash)); err != nil {
+		return err
+	}
+
+	content.mutex.Lock()
+	defer content.mutex.Unlock()
+
+	if err := content.store.Delete(hash + referenceSeparator + owner); err != nil {
+		return err
+	}
+	references, err := content.store.List(hash + referenceSeparator)
+	if err != nil {
+		return err
+	}
+	if len(references) > 0 {
+		return nil
+	}
+	return content.store.Delete(content.Key(hash))
+}
+
+func (content *ContentStore) References(hash string) (int, error) {
+	references, err := content.store.List(hash + referenceSeparator)
+	return len(references), err
+}
+
+func (content *ContentStore) Key(hash string) string {
+	return hash + contentExtension
+}
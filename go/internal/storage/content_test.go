@@ -0,0 +1,68 @@
+package storage
+
+/*
+This file tests the content-addressed store over a local directory: the deduplication of identical objects, and their
+removal with their last reference.
+*/
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestContentStore(t *testing.T) {
+	local, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := NewContentStore(local)
+	page := []byte("the same page")
+
+	first, err := content.Put("job-1", page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := content.Put("job-2", page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second || first != Hash(page) {
+		t.Fatalf("identical pages stored as %s and %s, expected their hash %s", first, second, Hash(page))
+	}
+	if _, err := content.Put("job-2", page); err != nil {
+		t.Fatal(err)
+	}
+	if references, err := content.References(first); err != nil || references != 2 {
+		t.Fatalf("%d references (%v), expected 2", references, err)
+	}
+	if objects, _ := local.List(content.Key(first)); len(objects) != 1 {
+		t.Fatalf("page stored %d times, expected once", len(objects))
+	}
+
+	other, err := content.Put("job-3", []byte("another page"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := content.Release("job-1", first); err != nil {
+		t.Fatal(err)
+	}
+	data, err := content.Get(first)
+	if err != nil || !bytes.Equal(data, page) {
+		t.Fatalf("read %q (%v) with a reference left, expected %q", data, err, page)
+	}
+
+	if err := content.Release("job-2", first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := content.Get(first); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("read the page after its last reference was released (%v), expected ErrNotExist", err)
+	}
+	if err := content.Release("job-2", first); err != nil {
+		t.Fatalf("releasing a released reference: %v", err)
+	}
+	if data, err := content.Get(other); err != nil || string(data) != "another page" {
+		t.Fatalf("read %q (%v), expected the other page untouched", data, err)
+	}
+}
@@ -0,0 +1,106 @@
+package storage
+
+/*
+This file implements the storage backed by a local directory.
+
+---
+
+### Local
+Stores every object in a file of the directory, named after its key. Objects are written to a temporary file which
+is then renamed, so a crash never leaves a truncated object behind. Temporary files are not listed.
+
+---
+
+### NewLocal(dir string) (*Local, error)
+Creates the storage, the directory is created if needed.
+
+### (local *Local) Open(key string) (io.ReadCloser, error)
+Opens the file of the object, see `Reader`.
+
+### (local *Local) URL(key string, expiry time.Duration) (string, error)
+Returns the `file://` URL of the object, only usable on the same machine. The URL does not expire.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const temporarySuffix = ".tmp"
+
+type Local struct {
+	dir string
+}
+
+func NewLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("storage: creating directory: %w", err)
+	}
+	return &Local{dir: dir}, nil
+}
+
+func (local *Local) Put(key string, data []byte) error {
+	temporaryPath := local.path(key) + temporarySuffix
+	if err := os.WriteFile(temporaryPath, data, 0644); err != nil {
+		return fmt.Errorf("storage: writing %s: %w", key, err)
+	}
+	return os.Rename(temporaryPath, local.path(key))
+}
+
+func (local *Local) Get(key string) ([]byte, error) {
+	data, err := os.ReadFile(local.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotExist
+	}
+	return data, err
+}
+
+func (local *Local) Open(key string) (io.ReadCloser, error) {
+	file, err := os.Open(local.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotExist
+	}
+	return file, err
+}
+
+func (local *Local) Delete(key string) error {
+	if err := os.Remove(local.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("storage: removing %s: %w", key, err)
+	}
+	return nil
+}
+
+func (local *Local) List(prefix string) ([]string, error) {
+	entries, err := os.ReadDir(local.dir)
+	if err != nil {
+		return nil, fmt.Errorf("storage: reading directory: %w", err)
+	}
+
+	var keys []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || strings.HasSuffix(name, temporarySuffix) {
+			continue
+		}
+		keys = append(keys, name)
+	}
+	return keys, nil
+}
+
+func (local *Local) URL(key string, expiry time.Duration) (string, error) {
+	path, err := filepath.Abs(local.path(key))
+	if err != nil {
+		return "", fmt.Errorf("storage: %w", err)
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String(), nil
+}
+
+func (local *Local) path(key string) string {
+	return filepath.Join(local.dir, filepath.FromSlash(key))
+}
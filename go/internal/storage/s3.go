@@ -0,0 +1,448 @@
+package storage
+
+/*
+This file implements the storage backed by an S3 bucket, and the Google Cloud Storage one built on it.
+
+The requests are sent with `net/http` and authenticated with AWS Signature Version 4, so no SDK is needed: the
+canonical request (method, path, sorted query, signed headers and hash of the payload) is hashed and signed with a
+key derived from the secret key, the date, the region and the service.
+
+---
+
+### S3Config
+Settings of an S3 storage.
+
+- **Fields**:
+  - `Endpoint`: URL of the service, `https://s3.<region>.amazonaws.com` if empty. Set it to use an S3-compatible
+    service (MinIO, Ceph, Google Cloud Storage...).
+  - `Region`: Region of the bucket, `us-east-1` if empty.
+  - `Bucket`: Name of the bucket.
+  - `Prefix`: Prefix of the keys of the objects in the bucket, e.g. `elp/jobs`.
+  - `PathStyle`: Whether the bucket is addressed in the path of the URLs (`<endpoint>/<bucket>/<key>`) instead of
+    the host name (`<bucket>.<endpoint host>/<key>`). Most S3-compatible services require it.
+  - `AccessKey`, `SecretKey`, `SessionToken`: Credentials signing the requests, the session token being only
+    needed for temporary credentials.
+
+---
+
+### S3
+Storage writing every object to `<Prefix>/<key>` in the bucket. `List` pages through `ListObjectsV2` until every
+key has been returned.
+
+---
+
+### NewS3(config S3Config) (*S3, error)
+Creates the storage. No request is sent until the first operation.
+
+### (store *S3) Open(key string) (io.ReadCloser, error)
+Sends the `GET` request of the object and returns the body of the response, see `Reader`.
+
+### (store *S3) URL(key string, expiry time.Duration) (string, error)
+Returns a presigned URL reading the object, valid for `expiry` (at most `maxPresignExpiry`, 7 days). Anyone holding
+the URL can download the object without credentials until it expires.
+
+### NewGCS(bucket string, prefix string, accessKey string, secretKey string) (*S3, error)
+Creates a storage backed by a Google Cloud Storage bucket, through the S3-compatible XML API of
+`storage.googleapis.com`. The access and secret keys are the HMAC key of a service account.
+
+---
+
+### Signature
+- `sign(request *http.Request, payloadHash string, now time.Time)`: Adds the `x-amz-*` headers and the
+  `Authorization` header to a request. Every header of the request is signed.
+- `canonicalRequest(request *http.Request, payloadHash string) (string, string)`: Returns the canonical request of
+  a request and the list of its signed headers: its method, path, sorted query, headers in lower case with their
+  values trimmed, and the hash of its payload. The `Authorization` header of a request signed before is left out,
+  so a request can be signed again.
+- `presign(key string, expiry time.Duration, now time.Time) (string, error)`: Builds a URL carrying the signature
+  in its query instead of its headers, only the `host` header and an unsigned payload being signed.
+- `stringToSign(timestamp string, canonicalRequest string) string`: Returns the string actually signed: the
+  algorithm, the time of the request, the scope of the credentials and the hash of the canonical request.
+- `signature(timestamp string, canonicalRequest string) string`: Signs a canonical request with the key derived
+  for the date of `timestamp`.
+- `uriEncode(value string, encodeSlash bool) string`: Encodes a path or a query parameter the way the canonical
+  request expects it: every byte except the unreserved characters of RFC 3986 is percent-encoded.
+*/
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultS3Region = "us-east-1"
+	gcsEndpoint     = "https://storage.googleapis.com"
+	gcsRegion       = "auto"
+
+	requestTimeout  = time.Minute
+	maxErrorBody    = 4 << 10
+	signatureScheme = "AWS4-HMAC-SHA256"
+
+	maxPresignExpiry = 7 * 24 * time.Hour
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+)
+
+type S3Config struct {
+	Endpoint     string
+	Region       string
+	Bucket       string
+	Prefix       string
+	PathStyle    bool
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+type S3 struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func NewS3(config S3Config) (*S3, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("storage: missing bucket name")
+	}
+	if config.AccessKey == "" || config.SecretKey == "" {
+		return nil, errors.New("storage: missing credentials for bucket " + config.Bucket)
+	}
+	if config.Region == "" {
+		config.Region = defaultS3Region
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://s3." + config.Region + ".amazonaws.com"
+	}
+	config.Prefix = strings.Trim(config.Prefix, "/")
+
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("storage: invalid endpoint %q", config.Endpoint)
+	}
+
+	return &S3{
+		config:   config,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: requestTimeout},
+	}, nil
+}
+
+func NewGCS(bucket string, prefix string, accessKey string, secretKey string) (*S3, error) {
+	return NewS3(S3Config{
+		Endpoint:  gcsEndpoint,
+		Region:    gcsRegion,
+		Bucket:    bucket,
+		Prefix:    prefix,
+		PathStyle: true,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+	})
+}
+
+func (store *S3) Put(key string, data []byte) error {
+	response, err := store.do(http.MethodPut, store.objectKey(key), nil, data)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return store.responseError(response, "writing "+key)
+	}
+	return nil
+}
+
+func (store *S3) Get(key string) ([]byte, error) {
+	object, err := store.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(object)
+	if err != nil {
+		return nil, fmt.Errorf("storage: reading %s: %w", key, err)
+	}
+	return data, nil
+}
+
+func (store *S3) Open(key string) (io.ReadCloser, error) {
+	response, err := store.do(http.MethodGet, store.objectKey(key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode == http.StatusNotFound {
+		response.Body.Close()
+		return nil, ErrNotExist
+	}
+	if response.StatusCode != http.StatusOK {
+		defer response.Body.Close()
+		return nil, store.responseError(response, "reading "+key)
+	}
+	return response.Body, nil
+}
+
+func (store *S3) Delete(key string) error {
+	response, err := store.do(http.MethodDelete, store.objectKey(key), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return store.responseError(response, "removing "+key)
+	}
+}
+
+func (store *S3) List(prefix string) ([]string, error) {
+	query := url.Values{
+		"list-type": {"2"},
+		"prefix":    {store.objectKey(prefix)},
+	}
+
+	var keys []string
+	for {
+		response, err := store.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if response.StatusCode != http.StatusOK {
+			err := store.responseError(response, "listing "+prefix)
+			response.Body.Close()
+			return nil, err
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("storage: decoding object list: %w", err)
+		}
+
+		for _, object := range result.Contents {
+			keys = append(keys, strings.TrimPrefix(object.Key, store.objectKey("")))
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (store *S3) URL(key string, expiry time.Duration) (string, error) {
+	return store.presign(key, expiry, time.Now())
+}
+
+func (store *S3) objectKey(key string) string {
+	if store.config.Prefix == "" {
+		return key
+	}
+	return store.config.Prefix + "/" + key
+}
+
+func (store *S3) objectURL(objectKey string, query url.Values) url.URL {
+	target := *store.endpoint
+	path := "/" + objectKey
+	if store.config.PathStyle {
+		path = "/" + store.config.Bucket + path
+	} else {
+		target.Host = store.config.Bucket + "." + target.Host
+	}
+	target.Path = path
+	target.RawPath = uriEncode(path, false)
+	target.RawQuery = canonicalQuery(query)
+	return target
+}
+
+func (store *S3) do(method string, objectKey string, query url.Values, body []byte) (*http.Response, error) {
+	target := store.objectURL(objectKey, query)
+
+	request, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+
+	payloadHash := sha256.Sum256(body)
+	store.sign(request, hex.EncodeToString(payloadHash[:]), time.Now())
+
+	response, err := store.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+	return response, nil
+}
+
+func (store *S3) responseError(response *http.Response, action string) error {
+	body, _ := io.ReadAll(io.LimitReader(response.Body, maxErrorBody))
+
+	var serviceError s3Error
+	if xml.Unmarshal(body, &serviceError) == nil && serviceError.Code != "" {
+		return fmt.Errorf("storage: %s: %s: %s (%s)", action, serviceError.Code, serviceError.Message, response.Status)
+	}
+	return fmt.Errorf("storage: %s: %s", action, response.Status)
+}
+
+func (store *S3) sign(request *http.Request, payloadHash string, now time.Time) {
+	timestamp := now.UTC().Format("20060102T150405Z")
+	date := timestamp[:8]
+
+	request.Header.Set("X-Amz-Date", timestamp)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if store.config.SessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", store.config.SessionToken)
+	}
+
+	canonicalRequest, signedHeaders := canonicalRequest(request, payloadHash)
+	signature := store.signature(timestamp, canonicalRequest)
+
+	request.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signatureScheme, store.config.AccessKey, store.scope(date), signedHeaders, signature))
+}
+
+func canonicalRequest(request *http.Request, payloadHash string) (string, string) {
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		if strings.EqualFold(name, "Authorization") {
+			continue
+		}
+		trimmed := make([]string, len(values))
+		for i, value := range values {
+			trimmed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	return strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n"), signedHeaders
+}
+
+func (store *S3) presign(key string, expiry time.Duration, now time.Time) (string, error) {
+	if expiry <= 0 || expiry > maxPresignExpiry {
+		return "", fmt.Errorf("storage: invalid URL expiry %v (at most %v)", expiry, maxPresignExpiry)
+	}
+
+	timestamp := now.UTC().Format("20060102T150405Z")
+	query := url.Values{
+		"X-Amz-Algorithm":     {signatureScheme},
+		"X-Amz-Credential":    {store.config.AccessKey + "/" + store.scope(timestamp[:8])},
+		"X-Amz-Date":          {timestamp},
+		"X-Amz-Expires":       {strconv.Itoa(int(expiry / time.Second))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	if store.config.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", store.config.SessionToken)
+	}
+	target := store.objectURL(store.objectKey(key), query)
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		target.EscapedPath(),
+		target.RawQuery,
+		"host:" + target.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+
+	target.RawQuery += "&X-Amz-Signature=" + store.signature(timestamp, canonicalRequest)
+	return target.String(), nil
+}
+
+func (store *S3) scope(date string) string {
+	return date + "/" + store.config.Region + "/s3/aws4_request"
+}
+
+func (store *S3) stringToSign(timestamp string, canonicalRequest string) string {
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	return signatureScheme + "\n" + timestamp + "\n" + store.scope(timestamp[:8]) + "\n" + hex.EncodeToString(canonicalHash[:])
+}
+
+func (store *S3) signature(timestamp string, canonicalRequest string) string {
+	date := timestamp[:8]
+	key := hmacSHA256([]byte("AWS4"+store.config.SecretKey), date)
+	key = hmacSHA256(key, store.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, store.stringToSign(timestamp, canonicalRequest)))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(query url.Values) string {
+	parameters := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			parameters = append(parameters, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(parameters)
+	return strings.Join(parameters, "&")
+}
+
+func uriEncode(value string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for i := 0; i < len(value); i++ {
+		character := value[i]
+		switch {
+		case 'A' <= character && character <= 'Z', 'a' <= character && character <= 'z',
+			'0' <= character && character <= '9', strings.IndexByte("-._~", character) >= 0:
+			encoded.WriteByte(character)
+		case character == '/' && !encodeSlash:
+			encoded.WriteByte(character)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", character)
+		}
+	}
+	return encoded.String()
+}
@@ -0,0 +1,174 @@
+package storage
+
+/*
+Package storage provides the object stores the server reads its inputs from and persists its outputs to: a local
+directory, an Amazon S3 bucket (or any S3-compatible service) and a Google Cloud Storage bucket.
+
+---
+
+### Storage
+A flat key-value store of objects. Keys are relative names such as `3f2a....json`, they must not start with `/`.
+
+- **Methods**:
+  - `Put(key string, data []byte) error`: Writes an object, replacing the previous one with the same key. Readers
+    never see a partially written object.
+  - `Get(key string) ([]byte, error)`: Reads an object, `ErrNotExist` if there is none with this key.
+  - `Delete(key string) error`: Removes an object. Removing a missing object is not an error.
+  - `List(prefix string) ([]string, error)`: Returns the keys starting with `prefix`, in no particular order.
+
+### Reader
+Implemented by the storages reading an object as a stream, so its size can be bounded before it is in memory.
+
+- **Methods**:
+  - `Open(key string) (io.ReadCloser, error)`: Opens an object for reading, `ErrNotExist` if there is none with
+    this key. The caller closes it.
+
+### Linker
+Implemented by the storages whose objects can be read directly by other programs, without going through the
+server.
+
+- **Methods**:
+  - `URL(key string, expiry time.Duration) (string, error)`: Returns a URL giving read access to an object for at
+    least `expiry`: a presigned URL for the buckets, a `file://` URL for a local directory.
+
+---
+
+### CheckKey(key string) error
+Checks that a key received from a client is a relative name whose segments are neither empty nor `.` or `..`, so it
+cannot read outside of the directory or the prefix of a storage.
+
+### GetLimited(store Storage, key string, limit int64) ([]byte, error)
+Reads an object like `Get`, or `ErrTooLarge` if it is larger than `limit` bytes. With a `Reader`, at most `limit`+1
+bytes of the object are read, so an object too large never fills the memory. Other storages read it whole first.
+
+### Open(location string) (Storage, error)
+Opens the storage described by `location`:
+- `s3://bucket/prefix`: S3 bucket (see `s3.go`). The query can set the `region` (default `us-east-1`), an
+  `endpoint` for S3-compatible services such as MinIO, and `path-style=true` to address the bucket in the path
+  of the URL. The credentials are read from `AWS_ACCESS_KEY_ID`, `AWS_SECRET_ACCESS_KEY` and, for temporary
+  credentials, `AWS_SESSION_TOKEN`.
+- `gs://bucket/prefix`: Google Cloud Storage bucket, accessed through its S3-compatible XML API with the HMAC key
+  read from `GCS_ACCESS_KEY_ID` and `GCS_SECRET_ACCESS_KEY`.
+- `file:///path/to/dir`, or a plain path: local directory (see `local.go`).
+
+---
+
+`ContentStore` stores objects under the hash of their bytes on top of any storage, counting their references, so
+identical outputs are stored once (see `content.go`).
+
+---
+
+### Example Usage:
+```go
+store, err := storage.Open("s3://scans/jobs?region=eu-west-3")
+if err != nil {
+	log.Fatal(err)
+}
+
+if err := store.Put("result.png", data); err != nil {
+	log.Fatal(err)
+}
+data, err = store.Get("result.png")
+```
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotExist = errors.New("storage: object not found")
+	ErrTooLarge = errors.New("storage: object too large")
+)
+
+type Storage interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+	List(prefix string) ([]string, error)
+}
+
+type Reader interface {
+	Open(key string) (io.ReadCloser, error)
+}
+
+type Linker interface {
+	URL(key string, expiry time.Duration) (string, error)
+}
+
+func CheckKey(key string) error {
+	if key == "" {
+		return errors.New("storage: empty key")
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("storage: invalid key %q", key)
+		}
+	}
+	return nil
+}
+
+func GetLimited(store Storage, key string, limit int64) ([]byte, error) {
+	reader, ok := store.(Reader)
+	if !ok {
+		data, err := store.Get(key)
+		if err == nil && int64(len(data)) > limit {
+			return nil, ErrTooLarge
+		}
+		return data, err
+	}
+
+	object, err := reader.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	data, err := io.ReadAll(io.LimitReader(object, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("storage: reading %s: %w", key, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
+
+func Open(location string) (Storage, error) {
+	if !strings.Contains(location, "://") {
+		return NewLocal(location)
+	}
+
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("storage: invalid location %q: %w", location, err)
+	}
+	prefix := strings.TrimPrefix(parsed.Path, "/")
+
+	switch parsed.Scheme {
+	case "file":
+		return NewLocal(parsed.Path)
+	case "s3":
+		query := parsed.Query()
+		return NewS3(S3Config{
+			Endpoint:     query.Get("endpoint"),
+			Region:       query.Get("region"),
+			Bucket:       parsed.Host,
+			Prefix:       prefix,
+			PathStyle:    query.Get("path-style") == "true",
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		})
+	case "gs":
+		return NewGCS(parsed.Host, prefix, os.Getenv("GCS_ACCESS_KEY_ID"), os.Getenv("GCS_SECRET_ACCESS_KEY"))
+	default:
+		return nil, fmt.Errorf("storage: unsupported location %q", location)
+	}
+}
@@ -0,0 +1,60 @@
+package storage
+
+/*
+This file tests the bounded read of an object: an object over the limit must be refused after reading just past the
+limit, whatever its size, and an object within it read whole.
+
+---
+
+### `endlessStorage`
+Storage whose every object is an endless stream of bytes, counting the bytes read from it.
+*/
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+type endlessStorage struct {
+	Storage
+	read int64
+}
+
+func (store *endlessStorage) Read(buffer []byte) (int, error) {
+	store.read += int64(len(buffer))
+	return len(buffer), nil
+}
+
+func (store *endlessStorage) Open(key string) (io.ReadCloser, error) {
+	return io.NopCloser(store), nil
+}
+
+func TestGetLimited(t *testing.T) {
+	endless := &endlessStorage{}
+	if _, err := GetLimited(endless, "scan.jpg", 1000); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("endless object read with %v, expected %v", err, ErrTooLarge)
+	}
+	if endless.read > 1001 {
+		t.Fatalf("%d bytes of the endless object read for a limit of 1000", endless.read)
+	}
+
+	local, err := NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	scan := bytes.Repeat([]byte{0xff}, 1000)
+	if err := local.Put("scan.jpg", scan); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := GetLimited(local, "scan.jpg", 1000); err != nil || !bytes.Equal(data, scan) {
+		t.Fatalf("object of the limit read as %d bytes (%v), expected all of it", len(data), err)
+	}
+	if _, err := GetLimited(local, "scan.jpg", 999); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("object over the limit read with %v, expected %v", err, ErrTooLarge)
+	}
+	if _, err := GetLimited(local, "missing.jpg", 1000); !errors.Is(err, ErrNotExist) {
+		t.Fatalf("missing object read with %v, expected %v", err, ErrNotExist)
+	}
+}
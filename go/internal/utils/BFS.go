@@ -9,7 +9,7 @@ Package utils provides functionality for image analysis and geometry processing,
 Finds contours within the full bounds of a binary grayscale image using a breadth-first search.
 
 - **Parameters**:
-  - img: A binary grayscale image (`*image.Gray`). Non-zero pixels are treated as "white" (foreground), and zero pixels are treated as "black" (background).
+  - img: A grayscale image (`*image.Gray`), e.g. an edge map. The pixels brighter than its `ForegroundThreshold` are treated as "white" (foreground), the others as "black" (background).
 - **Returns**:
   - contours: A slice of `geometry.Contour`, where each contour represents a connected component of white pixels in the image.
 - **Behavior**:
@@ -26,6 +26,7 @@ Finds contours within a specific region of a binary grayscale image using BFS.
 - **Returns**:
   - contours: A slice of `geometry.Contour`, each representing a connected component of white pixels in the specified region.
 - **Behavior**:
+  - The foreground is the pixels brighter than `ForegroundThreshold(img)`, computed on the whole of `img`.
   - Iterates over each pixel in the region defined by `bounds`.
   - For every unvisited white pixel (foreground), initiates a BFS to explore all connected white pixels, marking each as visited.
   - Explores in 8 possible directions (up, down, left, right, and diagonals) defined by the `directions` variable.
@@ -34,6 +35,45 @@ Finds contours within a specific region of a binary grayscale image using BFS.
 
 ---
 
+### FindContoursSeeded(img *image.Gray, bounds image.Rectangle, seeds []geometry.Point) []geometry.Contour
+Same as `FindContoursBFS`, starting the searches from `seeds` only instead of scanning every pixel of `bounds`.
+
+- **Parameters**:
+  - seeds: White pixels of `img` in reading order (top to bottom, then left to right), e.g. the `Edges` of the
+    `EdgeMap` of `ApplyCannyEdgeMap`, or those of `EdgePixels`. The seeds outside `bounds` are skipped, those of its
+    first row being found by a binary search.
+- **Behavior**:
+  - Every component needs one seed: its first pixel in reading order has no white pixel above it, so the pixels
+    adjacent to the background are enough. The seeds being in reading order, the contours are the same as those of
+    `FindContoursBFS`, in the same order.
+  - An edge map is mostly black: a few percent of its pixels are edges, so the scan of the whole chunk, pixel by
+    pixel, was most of the time spent outside the searches themselves.
+
+### ForegroundThreshold(img *image.Gray) uint8
+Returns the gray level the foreground of `img` is brighter than: the `Otsu` threshold of its histogram (see
+`imageUtils.Histogram`). 0 for a binary image, whose white pixels are the foreground, such as the edge maps of
+`ApplyCannyEdgeMap`, and between the weak and the strong edges of a three-level map with few weak edges (see
+`CannyParameters.ThreeLevel`), whose contours are then those of its strong edges.
+
+### EdgePixels(img *image.Gray, bounds image.Rectangle, threshold uint8) []geometry.Point
+Returns the pixels of `bounds` brighter than `threshold` adjacent to a darker pixel or to the border of the image, in
+reading order: the
+seeds of `FindContoursSeeded` for an edge map changed after the edge detection, e.g. closed by `Close`, whose
+thickened edges have fewer such pixels than the edges of `ApplyCannyEdgeMap`. Reads the pixels of `img` directly.
+
+The searches store their contours in a `ContourArena` of their own (see `contourArena.go`): a request searching
+several chunks, or several requests in a row, can reuse the buffers of an arena by calling its methods instead.
+
+### pixelSet
+Set of the pixels of an image, one bit per pixel: 1 MB for an 8 Mpx image, kept by the `ContourArena` of the search.
+A map of the visited pixels cost more than the search itself, about one lookup per neighbor.
+
+- **Methods**:
+  - `has(point geometry.Point) bool`: Tells whether `point` is in the set, false outside the bounds of the image.
+  - `add(point geometry.Point)`: Adds `point`, which must be within the bounds of the image.
+
+---
+
 ### Key Features
 - **Contour Detection**:
   - Implements a BFS-based approach to find connected components with white pixels in binary images.
@@ -49,7 +89,7 @@ Finds contours within a specific region of a binary grayscale image using BFS.
   - The `geometry.Point` type is used to represent 2D points (x, y).
   - The `geometry.Contour` type represents a slice of `geometry.Point`, signifying all points in a contour.
 - **imageUtils**:
-  - The `imageUtils.IsWhite` function is used to determine if a given pixel in the grayscale image is part of the foreground.
+  - The `imageUtils.IsForeground` function is used to determine if a given pixel in the grayscale image is part of the foreground.
 
 ---
 
@@ -83,13 +123,13 @@ func main() {
 ---
 
 ### Contour Filtering
-By default, only contours with more than 50 pixels are returned. Adjusting the threshold for contour size can be achieved by modifying the relevant `if` condition within the `FindContoursBFS` function.
+By default, only contours with more than 50 pixels are returned. The threshold is the `minContourSize` constant of `contourArena.go`.
 
 ### Key Behavior
 - **8-Directional Search**:
   - Ensures all neighbors (vertical, horizontal, and diagonal) are considered during BFS traversal.
 - **Memory Efficiency**:
-  - Uses a `visited` map to avoid revisiting already-processed pixels and reduce redundant computation.
+  - Uses a `visited` set of bits (`pixelSet`) to avoid revisiting already-processed pixels and reduce redundant computation.
 - **Dynamic Adaptation**:
   - Can be applied to entire images or specific subregions, enabling flexibility in use cases like ROI-specific contour detection.
 
@@ -102,7 +142,7 @@ import (
 )
 
 var directions = []geometry.Point{
-	{0, 1}, {1, 0}, {0, -1}, {-1, 0}, {-1, -1}, {-1, 1}, {1, -1}, {1, 1},
+	{X: 0, Y: 1}, {X: 1, Y: 0}, {X: 0, Y: -1}, {X: -1, Y: 0}, {X: -1, Y: -1}, {X: -1, Y: 1}, {X: 1, Y: -1}, {X: 1, Y: 1},
 }
 
 func FindContoursBFSWithDefault(img *image.Gray) []geometry.Contour {
@@ -110,40 +150,52 @@ func FindContoursBFSWithDefault(img *image.Gray) []geometry.Contour {
 }
 
 func FindContoursBFS(img *image.Gray, bounds image.Rectangle) []geometry.Contour {
-	visited := make(map[geometry.Point]bool)
-	var contours []geometry.Contour
+	return NewContourArena().FindContoursBFS(img, bounds, ForegroundThreshold(img))
+}
 
+func FindContoursSeeded(img *image.Gray, bounds image.Rectangle, seeds []geometry.Point) []geometry.Contour {
+	return NewContourArena().FindContoursSeeded(img, bounds, seeds, ForegroundThreshold(img))
+}
+
+func ForegroundThreshold(img *image.Gray) uint8 {
+	return imageUtils.GrayHistogram(img).Otsu()
+}
+
+func EdgePixels(img *image.Gray, bounds image.Rectangle, threshold uint8) []geometry.Point {
+	bounds = bounds.Intersect(img.Bounds())
+	var edges []geometry.Point
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			p := geometry.Point{X: x, Y: y}
-
-			if imageUtils.IsWhite(img, x, y) && !visited[p] {
-				var contour geometry.Contour
-				queue := []geometry.Point{p}
-
-				for len(queue) > 0 {
-					curr := queue[0]
-					queue = queue[1:]
-
-					if visited[curr] {
-						continue
-					}
-					visited[curr] = true
-					contour = append(contour, curr)
-
-					for _, d := range directions {
-						neighbor := geometry.Point{X: curr.X + d.X, Y: curr.Y + d.Y}
-						if imageUtils.IsWhite(img, neighbor.X, neighbor.Y) && !visited[neighbor] {
-							queue = append(queue, neighbor)
-						}
-					}
-				}
-				if len(contour) > 50 {
-					contours = append(contours, contour)
+		row := img.Pix[img.PixOffset(bounds.Min.X, y):img.PixOffset(bounds.Max.X, y)]
+		for i, value := range row {
+			if value <= threshold {
+				continue
+			}
+			x := bounds.Min.X + i
+			for _, d := range directions {
+				if !imageUtils.IsForeground(img, x+d.X, y+d.Y, threshold) {
+					edges = append(edges, geometry.Point{X: x, Y: y})
+					break
 				}
 			}
 		}
 	}
+	return edges
+}
+
+type pixelSet struct {
+	bounds image.Rectangle
+	bits   []uint64
+}
+
+func (set pixelSet) has(point geometry.Point) bool {
+	if !image.Point(point).In(set.bounds) {
+		return false
+	}
+	index := (point.Y-set.bounds.Min.Y)*set.bounds.Dx() + point.X - set.bounds.Min.X
+	return set.bits[index/64]&(1<<(index%64)) != 0
+}
 
-	return contours
+func (set pixelSet) add(point geometry.Point) {
+	index := (point.Y-set.bounds.Min.Y)*set.bounds.Dx() + point.X - set.bounds.Min.X
+	set.bits[index/64] |= 1 << (index % 64)
 }
@@ -0,0 +1,148 @@
+package utils
+
+/*
+This file tests the search of the contours from the edge pixels listed by the edge detection, against the scan of
+every pixel, on the edge map of a page with lines of text, as it is and once closed, the reuse of the arena of the
+searches, and the threshold of the foreground of a mask of weak edges and of an edge map.
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestFindContoursSeeded(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 300, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 300; x++ {
+			value := uint8(50)
+			if x >= 40 && x < 260 && y >= 50 && y < 350 {
+				value = 230
+				if (y-70)%30 < 4 && x >= 60 && x < 240 && (x-60)%25 < 18 {
+					value = 20
+				}
+			}
+			gray.SetGray(x, y, color.Gray{Y: value})
+		}
+	}
+	edges, _ := ApplyCannyEdgeMap(gray, DefaultCannyParameters)
+
+	var white []geometry.Point
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 300; x++ {
+			if edges.GrayAt(x, y).Y > 128 {
+				white = append(white, geometry.Point{X: x, Y: y})
+			}
+		}
+	}
+	if !reflect.DeepEqual(edges.Edges, white) {
+		t.Fatalf("listed %d edge pixels, expected the %d white pixels of the edge map", len(edges.Edges), len(white))
+	}
+
+	closed := Close(edges.Gray, 2)
+	tests := []struct {
+		name  string
+		img   *image.Gray
+		seeds []geometry.Point
+	}{
+		{"edges", edges.Gray, edges.Edges},
+		{"closed", closed, EdgePixels(closed, closed.Bounds(), ForegroundThreshold(closed))},
+	}
+	for _, test := range tests {
+		// The whole image, and a chunk whose search follows the page across its border.
+		for _, bounds := range []image.Rectangle{test.img.Bounds(), image.Rect(0, 100, 300, 200)} {
+			expected := FindContoursBFS(test.img, bounds)
+			if len(expected) == 0 {
+				t.Fatalf("%s: no contour found in %v", test.name, bounds)
+			}
+			if got := FindContoursSeeded(test.img, bounds, test.seeds); !reflect.DeepEqual(got, expected) {
+				t.Errorf("%s: %d contours found from the seeds in %v, expected the %d of the scan", test.name, len(got), bounds, len(expected))
+			}
+		}
+	}
+	thick := 0
+	for _, value := range closed.Pix {
+		if value > 128 {
+			thick++
+		}
+	}
+	if seeds := len(tests[1].seeds); seeds >= thick {
+		t.Errorf("%d seeds on the closed edge map, expected fewer than its %d white pixels", seeds, thick)
+	}
+}
+
+func TestContourArena(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 200, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			value := uint8(40)
+			if x >= 30 && x < 170 && y >= 30 && y < 170 && !(x >= 60 && x < 140 && y >= 60 && y < 140) {
+				value = 220
+			}
+			gray.SetGray(x, y, color.Gray{Y: value})
+		}
+	}
+	edges, _ := ApplyCannyEdgeMap(gray, DefaultCannyParameters)
+	expected := FindContoursSeeded(edges.Gray, edges.Gray.Bounds(), edges.Edges)
+	if len(expected) != 2 {
+		t.Fatalf("found %d contours, expected the outer and the inner outlines of the frame", len(expected))
+	}
+
+	arena := NewContourArena()
+	threshold := ForegroundThreshold(edges.Gray)
+	for round := range 3 {
+		got := arena.FindContoursSeeded(edges.Gray, edges.Gray.Bounds(), edges.Edges, threshold)
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("round %d: the arena found %d contours, expected the %d of a fresh search", round, len(got), len(expected))
+		}
+		if first := got[0]; cap(first) != len(first) {
+			t.Fatalf("round %d: contour of %d points has a capacity of %d, appending to it would write over the next", round, len(first), cap(first))
+		}
+		if round < 2 {
+			arena.Reset()
+		}
+	}
+	// Without a reset, the next search appends to the buffer: the contours found before stay valid.
+	kept := arena.FindContoursBFS(edges.Gray, edges.Gray.Bounds(), threshold)
+	again := arena.FindContoursBFS(edges.Gray, edges.Gray.Bounds(), threshold)
+	if !reflect.DeepEqual(kept, expected) || !reflect.DeepEqual(again, expected) {
+		t.Fatal("the contours of two searches in the same arena differ from those of a fresh search")
+	}
+}
+
+func TestForegroundThreshold(t *testing.T) {
+	// A mask of weak edges only: the outline of a square, at the gray level of the weak edges of the edge detection.
+	mask := image.NewGray(image.Rect(0, 0, 100, 100))
+	for i := 20; i < 80; i++ {
+		for _, p := range []image.Point{{i, 20}, {i, 79}, {20, i}, {79, i}} {
+			mask.SetGray(p.X, p.Y, color.Gray{Y: 75})
+		}
+	}
+	if threshold := ForegroundThreshold(mask); threshold >= 75 {
+		t.Fatalf("threshold of a mask of weak edges is %d, expected below 75", threshold)
+	}
+	if contours := FindContoursBFSWithDefault(mask); len(contours) != 1 || len(contours[0]) != 236 {
+		t.Fatalf("found %d contours in a mask of weak edges, expected the outline of the square", len(contours))
+	}
+	if contours := NewContourArena().FindContoursBFS(mask, mask.Bounds(), 128); len(contours) != 0 {
+		t.Fatalf("found %d contours brighter than 128 in a mask of weak edges, expected none", len(contours))
+	}
+
+	// Strong edges, and a few weak edges apart from them.
+	for i := 20; i < 80; i++ {
+		mask.SetGray(i, 20, color.Gray{Y: 255})
+		mask.SetGray(i, 79, color.Gray{Y: 255})
+		mask.SetGray(20, i, color.Gray{Y: 255})
+		mask.SetGray(79, i, color.Gray{Y: 255})
+		mask.SetGray(i, 0, color.Gray{Y: 75})
+	}
+	if threshold := ForegroundThreshold(mask); threshold < 75 || threshold >= 255 {
+		t.Fatalf("threshold of an edge map is %d, expected between its weak and strong edges", threshold)
+	}
+	if contours := FindContoursBFSWithDefault(mask); len(contours) != 1 || contours[0][0] != (geometry.Point{X: 20, Y: 20}) {
+		t.Fatalf("found %d contours in an edge map, expected the square without the weak border", len(contours))
+	}
+}
@@ -0,0 +1,89 @@
+package utils
+
+/*
+This file tests the bilateral filter against the Gaussian blur on a noisy low-contrast step, the border of a white page
+on a light table.
+
+---
+
+### noisyStep(width, height int) *image.Gray
+Returns an image whose left half is 150 and right half 190, with a deterministic noise of ±6 gray levels.
+
+### stepWidth(img *image.Gray, y int) int
+Returns the number of pixels of row `y` more than 5 gray levels away from both levels of the step: the width the
+border is spread over.
+*/
+
+import (
+	"image"
+	"testing"
+)
+
+func noisyStep(width, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			value := 150
+			if x >= width/2 {
+				value = 190
+			}
+			img.Pix[y*img.Stride+x] = uint8(value + (x*7+y*13)%13 - 6)
+		}
+	}
+	return img
+}
+
+func stepWidth(img *image.Gray, y int) int {
+	spread := 0
+	for _, value := range img.Pix[y*img.Stride : y*img.Stride+img.Bounds().Dx()] {
+		if value > 155 && value < 185 {
+			spread++
+		}
+	}
+	return spread
+}
+
+func TestBilateralFilterKeepsEdges(t *testing.T) {
+	img := noisyStep(40, 20)
+	bilateral := ApplyBilateralFilter(img, 3, 2, 15)
+	gaussian := ApplyKernel(img, GenerateGaussianKernel(7, 2))
+
+	if width := stepWidth(bilateral, 10); width > 1 {
+		t.Errorf("bilateral filter spread the step over %d pixels, expected it to stay sharp", width)
+	}
+	if width := stepWidth(gaussian, 10); width < 3 {
+		t.Errorf("Gaussian blur spread the step over %d pixels, expected a blurred step", width)
+	}
+
+	// Away from the step, the noise is smoothed out.
+	for _, x := range []int{5, 30} {
+		var low, high uint8 = 255, 0
+		for y := 3; y < 17; y++ {
+			value := bilateral.GrayAt(x, y).Y
+			low, high = min(low, value), max(high, value)
+		}
+		if high-low > 4 {
+			t.Errorf("column %d ranges from %d to %d after the filter, expected the noise to be smoothed", x, low, high)
+		}
+	}
+}
+
+func TestBilateralFilterIdentity(t *testing.T) {
+	img := noisyStep(10, 6)
+	for _, filtered := range []*image.Gray{ApplyBilateralFilter(img, 0, 2, 15), ApplyBilateralFilter(img, 2, 2, 0)} {
+		for i := range img.Pix {
+			if filtered.Pix[i] != img.Pix[i] {
+				t.Fatalf("byte %d is %d, expected a copy of the image (%d)", i, filtered.Pix[i], img.Pix[i])
+			}
+		}
+	}
+
+	// A flat image stays flat, whatever the weights.
+	flat := image.NewGray(image.Rect(0, 0, 8, 8))
+	for i := range flat.Pix {
+		flat.Pix[i] = 90
+	}
+	if value := ApplyBilateralFilter(flat, 3, 1, 10).GrayAt(0, 7).Y; value != 90 {
+		t.Fatalf("corner of a flat image is %d after the filter, expected 90", value)
+	}
+}
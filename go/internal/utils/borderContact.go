@@ -0,0 +1,76 @@
+package utils
+
+/*
+Package utils provides the suppression of the contours touching the border of the image. The background of a photo,
+e.g. the edge of a table, a keyboard or the fold of a sheet under the document, is often cut by the frame: its
+contours then run along the border of the image and may enclose a larger area than the document itself, which
+`FindQuadrilateral` would pick. A document is rarely photographed touching the frame, so such contours can be
+discarded, or only ranked lower, so they are picked when nothing else is found.
+
+---
+
+### BorderContact
+Rule applied to the contours touching the border of an image.
+
+- **Fields**:
+  - `Bounds`: Bounds of the image the contours were found in. A contour touches the border when one of its points
+    lies within `Margin` pixels of the edge of `Bounds`.
+  - `Margin`: Width of the band along the edge, in pixels. The edges of the image are often blurred or darkened, so
+    a contour cut by the frame may stop a few pixels short of it.
+  - `Weight`: Factor applied to the area of the contours touching the border when ranking them: 1 keeps them as they
+    are, 0 or less discards them.
+
+- **Methods**:
+  - `Touches(contour geometry.Contour) bool`: Tells whether `contour` touches the border. Always false for a zero
+    `BorderContact`, whose `Bounds` are empty.
+  - `Factor(contour geometry.Contour) float64`: Returns the factor the area of `contour` is ranked by: `Weight` if it
+    touches the border (0 if `Weight` is negative), 1 otherwise. Since the extreme points of a contour are on its
+    convex hull, a hull touches the border when its contour does.
+  - `Discards(contour geometry.Contour) bool`: Tells whether `contour` is discarded: it touches the border and
+    `Weight` is 0 or less.
+
+The contours are ranked by `CandidateRanking` (see `candidateRanking.go`).
+
+---
+
+### Example Usage:
+```go
+ranking := utils.CandidateRanking{Border: utils.BorderContact{Bounds: gray.Bounds(), Margin: 3, Weight: 0.25}}
+document := ranking.Find(contours, utils.FindQuadrilateral)
+```
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"image"
+)
+
+type BorderContact struct {
+	Bounds image.Rectangle
+	Margin int
+	Weight float64
+}
+
+func (border BorderContact) Touches(contour geometry.Contour) bool {
+	if border.Bounds.Empty() {
+		return false
+	}
+	inner := border.Bounds.Inset(border.Margin)
+	for _, point := range contour {
+		if point.X < inner.Min.X || point.X >= inner.Max.X || point.Y < inner.Min.Y || point.Y >= inner.Max.Y {
+			return true
+		}
+	}
+	return false
+}
+
+func (border BorderContact) Factor(contour geometry.Contour) float64 {
+	if border.Touches(contour) {
+		return max(border.Weight, 0)
+	}
+	return 1
+}
+
+func (border BorderContact) Discards(contour geometry.Contour) bool {
+	return border.Weight <= 0 && border.Touches(contour)
+}
@@ -0,0 +1,70 @@
+package utils
+
+/*
+This file tests the suppression of the contours touching the border: a page in the middle of a photo and the larger
+outline of a table running off its left edge.
+
+---
+
+### rectangle(x0, y0, x1, y1 int) geometry.Contour
+Returns the four corners of a rectangle, in order.
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"image"
+	"testing"
+)
+
+func rectangle(x0, y0, x1, y1 int) geometry.Contour {
+	return geometry.Contour{{X: x0, Y: y0}, {X: x1, Y: y0}, {X: x1, Y: y1}, {X: x0, Y: y1}}
+}
+
+func TestBorderContact(t *testing.T) {
+	bounds := image.Rect(0, 0, 200, 100)
+	page := rectangle(40, 15, 160, 85)
+	table := rectangle(1, 5, 190, 95)
+	contours := []geometry.Contour{table, page}
+
+	tests := []struct {
+		name   string
+		weight float64
+		want   geometry.Contour
+	}{
+		{"keep", 1, table},
+		{"penalize", 0.25, page},
+		{"discard", 0, page},
+	}
+	for _, test := range tests {
+		ranking := CandidateRanking{Border: BorderContact{Bounds: bounds, Margin: 3, Weight: test.weight}}
+		got := ranking.Find(contours, FindQuadrilateral)
+		if got.Contour[0] != test.want[0] {
+			t.Errorf("%s: picked the contour starting at %v, expected %v", test.name, got.Contour[0], test.want[0])
+		}
+		if got.Area != polygonArea(test.want) {
+			t.Errorf("%s: area %.0f, expected the true area %.0f", test.name, got.Area, polygonArea(test.want))
+		}
+	}
+
+	// A penalized contour still wins when it is much larger than the others.
+	border := BorderContact{Bounds: bounds, Margin: 3, Weight: 0.25}
+	small := rectangle(90, 40, 100, 50)
+	if got := (CandidateRanking{Border: border}).Find([]geometry.Contour{small, table}, FindLargestHull); got.Area != polygonArea(table) {
+		t.Errorf("picked an area of %.0f, expected the table (%.0f)", got.Area, polygonArea(table))
+	}
+
+	// Only the discarded contours touching the border: nothing is found.
+	discard := CandidateRanking{Border: BorderContact{Bounds: bounds, Margin: 3}}
+	if got := discard.Find([]geometry.Contour{table}, FindQuadrilateral); got.Area != 0 {
+		t.Errorf("found an area of %.0f with every contour discarded", got.Area)
+	}
+	if (BorderContact{}).Touches(table) {
+		t.Error("a zero BorderContact reports a contour touching the border")
+	}
+	if border.Touches(rectangle(3, 3, 196, 96)) {
+		t.Error("a contour outside the margin reported touching the border")
+	}
+	if !border.Touches(rectangle(10, 10, 197, 50)) {
+		t.Error("a contour within the margin of the right edge not reported touching the border")
+	}
+}
@@ -0,0 +1,175 @@
+package utils
+
+/*
+Package utils provides the ranking of the candidate documents of an image. The largest contour is not always the
+document: the background cut by the frame may enclose a larger area (see `BorderContact`), and a photo of a document
+is framed around it, so a candidate far from the center of the image is more likely a part of the background than
+the document, and a candidate of the wrong shape, a strip or a square, is more likely a table or a window (see
+`PageShape`). The area of every candidate is multiplied by factors from 0 to 1 penalizing such candidates, and the
+candidates are ranked by this score. The best one is the document; the others are the fallbacks when it turns out
+not to be, and the alternatives a user can pick from when the detection went wrong.
+
+---
+
+### CenterProximity
+Preference for the candidates near the center of the image, as `FindCorner` takes a center point.
+
+- **Fields**:
+  - `Bounds`: Bounds of the image the contours were found in.
+  - `Weight`: How much the distance to the center costs, from 0 (ignored) to 1: the factor of a candidate is 1 at
+    the center of `Bounds`, down to `1 - Weight` in a corner of the image.
+
+- **Methods**:
+  - `Distance(contour geometry.Contour) float64`: Returns the distance between the center of the bounding box of
+    `contour`, standing for its centroid, and the center of `Bounds`, relative to half the diagonal of `Bounds`: 0 at
+    the center, 1 in a corner. The bounding box of a contour is that of its convex hull, so a contour and its hull
+    are at the same distance. Always 0 if `Bounds` is empty.
+  - `Factor(contour geometry.Contour) float64`: Returns the factor the area of `contour` is ranked by,
+    `1 - Weight*Distance(contour)`. Always 1 for a zero `CenterProximity`.
+
+---
+
+### Candidate
+Candidate document, as ranked by `CandidateRanking.Rank`.
+
+- **Fields**:
+  - `ContourWithArea`: The contour of the candidate, as returned by the `find` function of the ranking, and its
+    area.
+  - `Score`: The area of the candidate, multiplied by the factors of the ranking.
+  - `Validation`: How well the four corners of the candidate (`FitQuadrilateral`) cover its convex hull, from 0 to 1: 1
+    for a quadrilateral, about 0.64 for a disk, lower for a thin curved stroke. Set by `Validate` only, 0 until then.
+  - `CenterDistance`: Distance of the candidate to the center of the image, see `CenterProximity.Distance`.
+
+### CompareCandidates(a, b Candidate) int
+Orders the candidates by decreasing score, then the candidates of the same score by the position of the first point
+of their contour, top to bottom then left to right, so the ranking does not depend on the order of the contours.
+Returns a negative number if `a` ranks before `b`, for `slices.SortFunc`.
+
+### Validate(candidates []Candidate)
+Sets the `Validation` of `candidates`. The convex hull of a large contour costs far more than its area, so the
+candidates are validated once ranked, only those which are reported, e.g. the document and its alternatives.
+
+---
+
+### CandidateRanking
+Scoring of the candidate documents of an image.
+
+- **Fields**:
+  - `Border`: Rule applied to the contours touching the border of the image, none if zero.
+  - `Center`: Preference for the contours near the center of the image, none if zero.
+  - `Shape`: Preference for the contours shaped like a page, none if zero.
+
+- **Methods**:
+  - `Score(candidate geometry.ContourWithArea) float64`: Returns the area of `candidate`, multiplied by the factors of
+    `Border`, `Center` and `Shape`.
+  - `Rank(contours []geometry.Contour, find func([]geometry.Contour) geometry.ContourWithArea) []Candidate`: Returns
+    the candidates of `contours` ranked by `CompareCandidates`, `find` turning a contour into a candidate, e.g.
+    `FindQuadrilateral` or `FindLargestHull`. The contours discarded by `Border` and the candidates of a zero score
+    are left out. The area of a candidate is its true area, not its score.
+  - `Find(contours []geometry.Contour, find func([]geometry.Contour) geometry.ContourWithArea) geometry.ContourWithArea`:
+    Returns the best candidate of `Rank`, an empty one if there is none.
+
+---
+
+### Example Usage:
+```go
+ranking := utils.CandidateRanking{
+	Border: utils.BorderContact{Bounds: gray.Bounds(), Margin: 3, Weight: 0.25},
+	Center: utils.CenterProximity{Bounds: gray.Bounds(), Weight: 0.5},
+}
+candidates := ranking.Rank(contours, utils.FindQuadrilateral)
+```
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"cmp"
+	"image"
+	"math"
+	"slices"
+)
+
+type CenterProximity struct {
+	Bounds image.Rectangle
+	Weight float64
+}
+
+type Candidate struct {
+	geometry.ContourWithArea
+	Score          float64
+	Validation     float64
+	CenterDistance float64
+}
+
+type CandidateRanking struct {
+	Border BorderContact
+	Center CenterProximity
+	Shape  PageShape
+}
+
+func (center CenterProximity) Distance(contour geometry.Contour) float64 {
+	if center.Bounds.Empty() || len(contour) == 0 {
+		return 0
+	}
+
+	box := contourBox(contour)
+	dx := float64(box.Min.X+box.Max.X-1-center.Bounds.Min.X-center.Bounds.Max.X) / 2
+	dy := float64(box.Min.Y+box.Max.Y-1-center.Bounds.Min.Y-center.Bounds.Max.Y) / 2
+	halfDiagonal := math.Hypot(float64(center.Bounds.Dx()), float64(center.Bounds.Dy())) / 2
+	return math.Hypot(dx, dy) / halfDiagonal
+}
+
+func (center CenterProximity) Factor(contour geometry.Contour) float64 {
+	if center.Weight == 0 {
+		return 1
+	}
+	return max(1-center.Weight*center.Distance(contour), 0)
+}
+
+func CompareCandidates(a, b Candidate) int {
+	if a.Score != b.Score || len(a.Contour) == 0 || len(b.Contour) == 0 {
+		return cmp.Compare(b.Score, a.Score)
+	}
+	first, otherFirst := a.Contour[0], b.Contour[0]
+	return cmp.Or(cmp.Compare(first.Y, otherFirst.Y), cmp.Compare(first.X, otherFirst.X))
+}
+
+func (ranking CandidateRanking) Score(candidate geometry.ContourWithArea) float64 {
+	return candidate.Area * ranking.Border.Factor(candidate.Contour) * ranking.Center.Factor(candidate.Contour) *
+		ranking.Shape.Factor(candidate.Contour)
+}
+
+func (ranking CandidateRanking) Rank(contours []geometry.Contour, find func([]geometry.Contour) geometry.ContourWithArea) []Candidate {
+	var candidates []Candidate
+	for _, contour := range contours {
+		if ranking.Border.Discards(contour) {
+			continue
+		}
+		found := find([]geometry.Contour{contour})
+		score := ranking.Score(found)
+		if score <= 0 {
+			continue
+		}
+		candidates = append(candidates, Candidate{
+			ContourWithArea: found,
+			Score:           score,
+			CenterDistance:  ranking.Center.Distance(found.Contour),
+		})
+	}
+	slices.SortStableFunc(candidates, CompareCandidates)
+	return candidates
+}
+
+func (ranking CandidateRanking) Find(contours []geometry.Contour, find func([]geometry.Contour) geometry.ContourWithArea) geometry.ContourWithArea {
+	candidates := ranking.Rank(contours, find)
+	if len(candidates) == 0 {
+		return geometry.ContourWithArea{}
+	}
+	return candidates[0].ContourWithArea
+}
+
+func Validate(candidates []Candidate) {
+	for i := range candidates {
+		_, candidates[i].Validation = quadrilateralCoverage(candidates[i].Contour)
+	}
+}
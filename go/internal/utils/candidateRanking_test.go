@@ -0,0 +1,116 @@
+package utils
+
+/*
+This file tests the preference for the candidates near the center of the image: a page in the middle of a photo and
+a slightly larger sheet lying in its corner, then the ranking and the validation of the candidates, and the
+preference for the candidates shaped like a page.
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"image"
+	"math"
+	"testing"
+)
+
+func TestCenterProximity(t *testing.T) {
+	bounds := image.Rect(0, 0, 400, 300)
+	page := rectangle(150, 100, 250, 200)
+	corner := rectangle(290, 200, 400, 300)
+	contours := []geometry.Contour{corner, page}
+
+	if got := (CandidateRanking{}).Find(contours, FindQuadrilateral); got.Contour[0] != corner[0] {
+		t.Fatalf("picked the contour starting at %v without preference, expected the larger one", got.Contour[0])
+	}
+	ranking := CandidateRanking{Center: CenterProximity{Bounds: bounds, Weight: 0.5}}
+	got := ranking.Find(contours, FindQuadrilateral)
+	if got.Contour[0] != page[0] {
+		t.Fatalf("picked the contour starting at %v, expected the centered page", got.Contour[0])
+	}
+	if got.Area != polygonArea(page) {
+		t.Fatalf("area %.0f, expected the true area %.0f", got.Area, polygonArea(page))
+	}
+
+	center := ranking.Center
+	if factor := center.Factor(page); factor != 1 {
+		t.Errorf("factor of a centered contour is %g, expected 1", factor)
+	}
+	if factor := center.Factor(rectangle(0, 0, 0, 0)); math.Abs(factor-0.5) > 1e-9 {
+		t.Errorf("factor of a contour in the corner is %g, expected 0.5", factor)
+	}
+	if factor := (CenterProximity{}).Factor(corner); factor != 1 {
+		t.Errorf("factor of a zero CenterProximity is %g, expected 1", factor)
+	}
+}
+
+func TestRankCandidates(t *testing.T) {
+	bounds := image.Rect(0, 0, 400, 300)
+	page := rectangle(150, 100, 250, 200)
+	corner := rectangle(290, 200, 400, 300)
+	// A parallelogram of the same area as the page, ranked after it by position.
+	slanted := geometry.Contour{{X: 30, Y: 150}, {X: 130, Y: 160}, {X: 130, Y: 260}, {X: 30, Y: 250}}
+	stroke := geometry.Contour{{X: 10, Y: 10}, {X: 20, Y: 10}}
+
+	ranking := CandidateRanking{Center: CenterProximity{Bounds: bounds}}
+	candidates := ranking.Rank([]geometry.Contour{slanted, stroke, page, corner}, FindQuadrilateral)
+	if len(candidates) != 3 {
+		t.Fatalf("ranked %d candidates, expected 3 without the stroke of no area", len(candidates))
+	}
+	for i, expected := range []geometry.Contour{corner, page, slanted} {
+		if candidates[i].Contour[0] != expected[0] {
+			t.Errorf("candidate %d starts at %v, expected %v", i, candidates[i].Contour[0], expected[0])
+		}
+	}
+	if distance := candidates[1].CenterDistance; distance != 0 {
+		t.Errorf("centered page at a distance of %g, expected 0", distance)
+	}
+
+	Validate(candidates)
+	for i, candidate := range candidates {
+		if math.Abs(candidate.Validation-1) > 1e-9 {
+			t.Errorf("candidate %d validated at %g, expected 1 for a quadrilateral", i, candidate.Validation)
+		}
+	}
+	octagon := Candidate{ContourWithArea: geometry.ContourWithArea{Contour: geometry.Contour{
+		{X: 30, Y: 0}, {X: 70, Y: 0}, {X: 100, Y: 30}, {X: 100, Y: 70}, {X: 70, Y: 100}, {X: 30, Y: 100}, {X: 0, Y: 70}, {X: 0, Y: 30},
+	}}}
+	octagons := []Candidate{octagon}
+	Validate(octagons)
+	if validation := octagons[0].Validation; validation < 0.5 || validation > 0.8 {
+		t.Errorf("octagon validated at %g, expected well under 1", validation)
+	}
+}
+
+func TestPageShape(t *testing.T) {
+	page := rectangle(220, 20, 340, 190)
+	window := rectangle(20, 20, 180, 180)
+	table := rectangle(0, 210, 399, 299)
+	contours := []geometry.Contour{table, window, page}
+
+	if got := (CandidateRanking{}).Find(contours, FindQuadrilateral); got.Contour[0] != table[0] {
+		t.Fatalf("picked the contour starting at %v without preference, expected the larger table", got.Contour[0])
+	}
+	shape := PageShape{Ratio: math.Sqrt2, Weight: 0.5}
+	if got := (CandidateRanking{Shape: shape}).Find(contours, FindQuadrilateral); got.Contour[0] != page[0] {
+		t.Fatalf("picked the contour starting at %v, expected the A4 page", got.Contour[0])
+	}
+
+	if factor := shape.Factor(page); math.Abs(factor-1) > 0.01 {
+		t.Errorf("factor of an A4 page is %g, expected 1", factor)
+	}
+	if closeness := shape.Closeness(rectangle(0, 0, 141, 100)); closeness < 0.99 {
+		t.Errorf("closeness of an A4 page in landscape is %g, expected 1", closeness)
+	}
+	if closeness := shape.Closeness(window); closeness < 0.15 || closeness > 0.3 {
+		t.Errorf("closeness of a square is %g, expected about 0.2", closeness)
+	}
+	if factor := (PageShape{Weight: 1}).Factor(table); factor != 1 {
+		t.Errorf("factor without a ratio is %g, expected 1", factor)
+	}
+
+	// The outline of a table broken around a cup bulges out of its four corners.
+	cup := geometry.Contour{{X: 0, Y: 0}, {X: 141, Y: 0}, {X: 141, Y: 100}, {X: 90, Y: 140}, {X: 50, Y: 140}, {X: 0, Y: 100}}
+	if convexity := shape.Convexity(cup); convexity > 0.95 {
+		t.Errorf("convexity of a bulging outline is %g, expected under 0.95", convexity)
+	}
+}
@@ -5,15 +5,56 @@ Package utils provides tools for advanced image processing, including the implem
 
 ---
 
-### nonMaxSuppression(gradient image.Gray, angles [][]float64) *image.Gray
+### Constants
+- `blurKernelSize`, `blurSigma`: Size and standard deviation of the Gaussian kernel blurring the image.
+- `sobelKernelSize`: Size of the Sobel kernels computing the gradients.
+- `thresholdScale`: Multiplier of the Otsu threshold of the gradient giving the high threshold (see `GradientStats`).
+- `StrongEdge`, `WeakEdge`: Gray levels of the strong and of the weak edges in an edge map. The weak edges are kept
+  only in a three-level map (see `CannyParameters.ThreeLevel`): the edge maps are binary by default, 0 or
+  `StrongEdge`.
+
+### DefaultCannyParameters
+The parameters of `ApplyCannyEdgeDetection`, made of the constants above.
+
+### CannyParameters
+Tuning of the edge detection, e.g. a stronger blur for the reflections of a whiteboard.
+
+- **Fields**:
+  - `BlurKernelSize`, `BlurSigma`: Size and standard deviation of the Gaussian kernel blurring the image.
+  - `BilateralSigma`: If positive, the image is smoothed by a bilateral filter instead of the Gaussian blur (see
+    `ApplyBilateralFilter`), over the same kernel with the same spatial sigma, and with this range sigma in gray
+    levels: the noise is smoothed without blurring the border of a low-contrast document.
+  - `ThresholdScale`: Multiplier of the Otsu threshold of the gradient magnitudes giving the high threshold (see
+    `GradientStats`): the higher, the fewer edges.
+  - `ThreeLevel`: If set, the weak edges kept by the hysteresis are `WeakEdge` in the edge map instead of
+    `StrongEdge`, e.g. to show them apart from the strong ones. Their contours are then searched with a foreground
+    threshold below `WeakEdge` (see `ContourArena`): the `ForegroundThreshold` of a map with few weak edges is
+    above it.
+  - `Operator`: How the gradient is thinned into edges: `OperatorCanny` (the default) by the Non-Maximum
+    Suppression, `OperatorLoG` by the zero crossings of the Laplacian of the smoothed image (see `laplacian.go`).
+    The thresholds and the hysteresis are the same.
+  - `Workers`: Number of goroutines the Gaussian blur and the gradients are computed by, in stripes of rows (see
+    `parallelRows`). 0 or 1 computes them in the calling goroutine, as the server does: its chunks are already
+    processed in parallel.
+
+- **Methods**:
+  - `Smooth(img *image.Gray) *FloatImage`: Returns `img` smoothed before its gradients are computed: blurred by the
+    Gaussian kernel, or filtered by the bilateral filter if `BilateralSigma` is set. The smoothed values are not
+    rounded (see `FloatImage`).
+  - `SmoothRGBA(img *image.RGBA) *FloatImage`: Same as `Smooth` on a color image, converted to gray as it is blurred
+    (see `grayscaleBlur.go`).
+
+---
+
+### nonMaxSuppression(gradient, angles *FloatImage) *FloatImage
 Performs Non-Maximum Suppression (NMS) to thin edges by suppressing non-edge gradients.
 
 - **Parameters**:
-  - gradient: A grayscale image (`image.Gray`) representing the gradient magnitudes.
-  - angles: A 2D slice of angles (`[][]float64`) representing the gradient directions.
+  - gradient: A plane (`*FloatImage`) of the gradient magnitudes.
+  - angles: A plane (`*FloatImage`) of the gradient directions, in degrees.
 
 - **Returns**:
-  - A new grayscale image with thinned edges (`*image.Gray`).
+  - A new plane of the gradient magnitudes of the thinned edges (`*FloatImage`).
 
 - **Behavior**:
   - Based on gradient angles, compares the current pixel's magnitude with neighboring pixels along the gradient direction.
@@ -22,21 +63,28 @@ Performs Non-Maximum Suppression (NMS) to thin edges by suppressing non-edge gra
 
 ---
 
-### hysteresisThresholding(img *image.Gray, lowThreshold, highThreshold float64) *image.Gray
+### hysteresisThresholding(img *FloatImage, lowThreshold, highThreshold float64, threeLevel bool) (*image.Gray, []geometry.Point)
 Applies hysteresis thresholding to classify edges as strong, weak, or non-edges.
 
 - **Parameters**:
-  - img: A grayscale image (`*image.Gray`) containing edge gradients.
+  - img: A plane (`*FloatImage`) containing edge gradients.
   - lowThreshold: The lower threshold for edge detection.
   - highThreshold: The upper threshold for edge detection.
+  - threeLevel: Whether the weak edges kept are `WeakEdge` in the result, instead of `StrongEdge`.
 
 - **Returns**:
-  - A grayscale image (`*image.Gray`) with edges classified as strong or non-edges.
+  - A grayscale image (`*image.Gray`) with edges classified as strong (`StrongEdge`), kept weak (`StrongEdge`, or
+    `WeakEdge` with `threeLevel`) or non-edges (0).
+  - The edge pixels, strong and kept weak ones, in reading order (top to bottom, then left to right), recorded as
+    the weak edges are settled: a pixel does not change once it is passed.
 
 - **Behavior**:
   - Pixels with magnitude above `highThreshold` are classified as strong edges.
   - Pixels with magnitude between `lowThreshold` and `highThreshold` are weak edges.
-  - Weak edges are only preserved if they are connected to strong edges; otherwise, they are discarded.
+  - Weak edges are only preserved if they are connected to strong edges; otherwise, they are discarded. The weak
+    edges of the border of the image are settled too, the neighbors outside the image being no edges.
+  - A weak edge kept counts as a strong one for the weak edges after it, so the edges chain along the weak pixels
+    in reading order; with `threeLevel`, the kept weak edges are lowered to `WeakEdge` once all are settled.
 
 ---
 
@@ -66,15 +114,58 @@ The main function to apply the complete Canny edge detection pipeline to a grays
   - A grayscale image (`*image.Gray`) with detected edges.
 
 - **Behavior**:
-  1. Applies Gaussian blurring to reduce noise using `GenerateGaussianKernel` and `ApplyKernel`.
-  2. Computes gradient magnitudes and directions using Sobel filters by calling `GenerateSobelKernel` and `ApplySobelEdgeDetection`.
-  3. Applies Non-Maximum Suppression (`nonMaxSuppression`) to thin the edges.
-  4. Calculates dynamic thresholds using `ComputeDynamicThresholds`.
+  1. Applies Gaussian blurring to reduce noise using `GaussianKernel` (cached) and `ApplyKernelFloat`, or the bilateral
+     filter (`ApplyBilateralFilterFloat`) with `ApplyCannyEdgeDetectionWith` and a `BilateralSigma`.
+  2. Computes gradient magnitudes and directions using Sobel filters by calling `SobelKernels` and `ApplySobelFloat`,
+     and the histogram of the magnitudes (see `MeasureGradient`).
+  The intermediate results are planes of floating point values (`FloatImage`): only the edge map is quantized.
+  3. Applies Non-Maximum Suppression (`nonMaxSuppression`) to thin the edges, or takes the zero crossings of the
+     Laplacian (`zeroCrossings`) with `ApplyCannyEdgeDetectionWith` and `OperatorLoG`.
+  4. Calculates dynamic thresholds from the histogram of the magnitudes, by Otsu's method (see `GradientStats`).
   5. Applies hysteresis thresholding (`hysteresisThresholding`) to finalize edge classification.
   6. Returns the final edge-detected image.
 
 ---
 
+### ApplyCannyEdgeDetectionTimed(img *image.Gray) (*image.Gray, CannyTimings)
+Same as `ApplyCannyEdgeDetection`, and also returns the time spent in every step of the pipeline.
+
+### ApplyCannyEdgeDetectionWith(img *image.Gray, parameters CannyParameters) (*image.Gray, CannyTimings)
+Same as `ApplyCannyEdgeDetectionTimed`, with the blur and the thresholds of `parameters`.
+
+### ApplyCannyEdgeMap(img *image.Gray, parameters CannyParameters) (*EdgeMap, CannyTimings)
+Same as `ApplyCannyEdgeDetectionWith`, and also returns the list of the edge pixels, so the contours can be searched
+from them only instead of scanning every pixel of the image (see `FindContoursSeeded`).
+
+### DetectEdges(smoothed *SmoothedImage, lowThreshold, highThreshold float64, parameters CannyParameters) (*EdgeMap, CannyTimings)
+Runs the steps of `ApplyCannyEdgeMap` after the gradients and the thresholds: the Non-Maximum Suppression of the
+gradients of `smoothed`, or the zero crossings of its Laplacian with the `OperatorLoG` of `parameters`, and the
+hysteresis, with the given thresholds, into a three-level map if the `ThreeLevel` of `parameters` is set (see
+`CannyParameters`). Used to apply the same thresholds to every chunk of an image (see `GradientStats`). The
+thresholds of the zero crossings are scaled by `logThresholdScale`. The `Blur` and the `Sobel` of the timings are 0.
+
+### DetectEdgesSmoothed(blurred *FloatImage, parameters CannyParameters) (*EdgeMap, CannyTimings)
+Runs the steps of `ApplyCannyEdgeMap` after the blur, the thresholds computed from the histogram of the gradients of
+`blurred` and the `ThresholdScale` of `parameters`: the edge detection of a chunk already smoothed, e.g. by
+`CannyParameters.SmoothRGBA`. The `Blur` of the timings is 0, the histogram of the gradients is part of their `Sobel`.
+
+- **CannyTimings fields**:
+  - `Blur`: Gaussian blurring, or bilateral filtering.
+  - `Sobel`: Computation of the gradients.
+  - `NMS`: Non-Maximum Suppression, or the Laplacian and its zero crossings with `OperatorLoG`.
+  - `Hysteresis`: Computation of the dynamic thresholds and hysteresis thresholding.
+
+### EdgeMap
+Edge map of an image and its sparse list of edge pixels. Embedding the `*image.Gray` of the edges, an `EdgeMap` is an
+`image.Image` itself, and goes through the image tasks of the workers.
+
+- **Fields**:
+  - `Gray`: The edge map, `StrongEdge` on the edges and 0 elsewhere, or `WeakEdge` on the weak edges of a
+    three-level map.
+  - `Edges`: The pixels of the edges, in reading order.
+
+---
+
 ### Key Features:
 - **Edge Preservation**:
   - By applying Non-Maximum Suppression, only the most prominent edges are preserved.
@@ -115,50 +206,98 @@ func main() {
 */
 
 import (
+	"ELP-project/internal/geometry"
 	"image"
 	"image/color"
+	"time"
+)
+
+const (
+	blurKernelSize  = 5
+	blurSigma       = 1.4
+	sobelKernelSize = 3
+	thresholdScale  = 1.0
 )
 
-func nonMaxSuppression(gradient image.Gray, angles [][]float64) *image.Gray {
+const (
+	StrongEdge uint8 = 255
+	WeakEdge   uint8 = 75
+)
+
+var DefaultCannyParameters = CannyParameters{
+	BlurKernelSize: blurKernelSize,
+	BlurSigma:      blurSigma,
+	ThresholdScale: thresholdScale,
+}
+
+type CannyParameters struct {
+	BlurKernelSize int
+	BlurSigma      float64
+	BilateralSigma float64
+	ThresholdScale float64
+	ThreeLevel     bool
+	Operator       EdgeOperator
+	Workers        int
+}
+
+func (parameters CannyParameters) Smooth(img *image.Gray) *FloatImage {
+	if parameters.BilateralSigma > 0 {
+		return ApplyBilateralFilterFloat(img, parameters.BlurKernelSize/2, parameters.BlurSigma, parameters.BilateralSigma)
+	}
+	kernel := GaussianKernel(parameters.BlurKernelSize, parameters.BlurSigma)
+	return ApplyKernelFloatParallel(FloatFromGray(img), kernel, parameters.Workers)
+}
+
+type EdgeMap struct {
+	*image.Gray
+	Edges []geometry.Point
+}
+
+type CannyTimings struct {
+	Blur       time.Duration
+	Sobel      time.Duration
+	NMS        time.Duration
+	Hysteresis time.Duration
+}
+
+func nonMaxSuppression(gradient, angles *FloatImage) *FloatImage {
 	bounds := gradient.Bounds()
-	suppressed := image.NewGray(bounds)
+	suppressed := NewFloatImage(bounds)
 
-	for y := 1; y < bounds.Max.Y-1; y++ {
-		for x := 1; x < bounds.Max.X-1; x++ {
-			angle := angles[y][x]
-			mag := gradient.GrayAt(x, y).Y
-			n1, n2 := uint8(0), uint8(0)
+	for y := max(bounds.Min.Y, 1); y < bounds.Max.Y-1; y++ {
+		for x := max(bounds.Min.X, 1); x < bounds.Max.X-1; x++ {
+			angle := angles.FloatAt(x, y)
+			mag := gradient.FloatAt(x, y)
+			var n1, n2 float32
 
 			if (angle >= -22.5 && angle <= 22.5) || (angle >= 157.5 || angle <= -157.5) {
-				n1, n2 = gradient.GrayAt(x-1, y).Y, gradient.GrayAt(x+1, y).Y
+				n1, n2 = gradient.FloatAt(x-1, y), gradient.FloatAt(x+1, y)
 			} else if (angle > 22.5 && angle <= 67.5) || (angle < -112.5 && angle >= -157.5) {
-				n1, n2 = gradient.GrayAt(x-1, y-1).Y, gradient.GrayAt(x+1, y+1).Y
+				n1, n2 = gradient.FloatAt(x-1, y-1), gradient.FloatAt(x+1, y+1)
 			} else if (angle > 67.5 && angle <= 112.5) || (angle < -67.5 && angle >= -112.5) {
-				n1, n2 = gradient.GrayAt(x, y-1).Y, gradient.GrayAt(x, y+1).Y
+				n1, n2 = gradient.FloatAt(x, y-1), gradient.FloatAt(x, y+1)
 			} else {
-				n1, n2 = gradient.GrayAt(x-1, y+1).Y, gradient.GrayAt(x+1, y-1).Y
+				n1, n2 = gradient.FloatAt(x-1, y+1), gradient.FloatAt(x+1, y-1)
 			}
 
 			if mag >= n1 && mag >= n2 {
-				suppressed.SetGray(x, y, color.Gray{Y: mag})
-			} else {
-				suppressed.SetGray(x, y, color.Gray{Y: 0})
+				suppressed.Pix[suppressed.PixOffset(x, y)] = mag
 			}
 		}
 	}
 	return suppressed
 }
 
-func hysteresisThresholding(img *image.Gray, lowThreshold, highThreshold float64) *image.Gray {
+func hysteresisThresholding(img *FloatImage, lowThreshold, highThreshold float64, threeLevel bool) (*image.Gray, []geometry.Point) {
 	bounds := img.Bounds()
 	output := image.NewGray(bounds)
 
-	strong := uint8(255)
-	weak := uint8(75)
+	strong := StrongEdge
+	weak := WeakEdge
 
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			pixel := img.GrayAt(x, y).Y
+			pixel := img.FloatAt(x, y)
 			if float64(pixel) >= highThreshold {
 				output.SetGray(x, y, color.Gray{Y: strong})
 			} else if float64(pixel) >= lowThreshold {
@@ -169,19 +308,31 @@ func hysteresisThresholding(img *image.Gray, lowThreshold, highThreshold float64
 		}
 	}
 
-	for y := 1; y < bounds.Max.Y-1; y++ {
-		for x := 1; x < bounds.Max.X-1; x++ {
-			if output.GrayAt(x, y).Y == weak {
+	var edges, kept []geometry.Point
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pixel := output.GrayAt(x, y).Y
+			if pixel == weak {
 				if isConnectedToStrong(output, x, y, strong) {
-					output.SetGray(x, y, color.Gray{Y: strong})
+					pixel = strong
+					kept = append(kept, geometry.Point{X: x, Y: y})
 				} else {
-					output.SetGray(x, y, color.Gray{Y: 0})
+					pixel = 0
 				}
+				output.SetGray(x, y, color.Gray{Y: pixel})
+			}
+			if pixel == strong {
+				edges = append(edges, geometry.Point{X: x, Y: y})
 			}
 		}
 	}
 
-	return output
+	if threeLevel {
+		for _, p := range kept {
+			output.SetGray(p.X, p.Y, color.Gray{Y: weak})
+		}
+	}
+	return output, edges
 }
 
 func isConnectedToStrong(img *image.Gray, x, y int, strong uint8) bool {
@@ -199,17 +350,60 @@ func isConnectedToStrong(img *image.Gray, x, y int, strong uint8) bool {
 }
 
 func ApplyCannyEdgeDetection(img *image.Gray) *image.Gray {
-	kernel := GenerateGaussianKernel(5, 1.4)
-	blurred := ApplyKernel(img, kernel)
+	finalEdges, _ := ApplyCannyEdgeDetectionTimed(img)
+	return finalEdges
+}
 
-	lowThreshold, highThreshold := ComputeDynamicThresholds(blurred, 1.5)
+func ApplyCannyEdgeDetectionTimed(img *image.Gray) (*image.Gray, CannyTimings) {
+	return ApplyCannyEdgeDetectionWith(img, DefaultCannyParameters)
+}
+
+func ApplyCannyEdgeDetectionWith(img *image.Gray, parameters CannyParameters) (*image.Gray, CannyTimings) {
+	edges, timings := ApplyCannyEdgeMap(img, parameters)
+	return edges.Gray, timings
+}
 
-	sobelX, sobelY := GenerateSobelKernel(3)
-	edges, gradientAngles := ApplySobelEdgeDetection(blurred, sobelX, sobelY)
+func ApplyCannyEdgeMap(img *image.Gray, parameters CannyParameters) (*EdgeMap, CannyTimings) {
+	start := time.Now()
+	blurred := parameters.Smooth(img)
+	blur := time.Since(start)
 
-	nms := nonMaxSuppression(*edges, gradientAngles)
+	edges, timings := DetectEdgesSmoothed(blurred, parameters)
+	timings.Blur = blur
+	return edges, timings
+}
 
-	finalEdges := hysteresisThresholding(nms, lowThreshold, highThreshold)
+func DetectEdgesSmoothed(blurred *FloatImage, parameters CannyParameters) (*EdgeMap, CannyTimings) {
+	start := time.Now()
+	smoothed := measureGradient(blurred, blurred.Bounds(), parameters.Workers)
+	sobel := time.Since(start)
 
-	return finalEdges
+	start = time.Now()
+	lowThreshold, highThreshold := smoothed.Gradient.Thresholds(parameters.ThresholdScale)
+	thresholds := time.Since(start)
+
+	edges, timings := DetectEdges(smoothed, lowThreshold, highThreshold, parameters)
+	timings.Sobel = sobel
+	timings.Hysteresis += thresholds
+	return edges, timings
+}
+
+func DetectEdges(smoothed *SmoothedImage, lowThreshold, highThreshold float64, parameters CannyParameters) (*EdgeMap, CannyTimings) {
+	var timings CannyTimings
+
+	start := time.Now()
+	var nms *FloatImage
+	if parameters.Operator == OperatorLoG {
+		nms = zeroCrossings(smoothed.Magnitude, laplacian(smoothed.FloatImage))
+		lowThreshold, highThreshold = logThresholdScale*lowThreshold, logThresholdScale*highThreshold
+	} else {
+		nms = nonMaxSuppression(smoothed.Magnitude, smoothed.Angles)
+	}
+	timings.NMS = time.Since(start)
+
+	start = time.Now()
+	finalEdges, edgePixels := hysteresisThresholding(nms, lowThreshold, highThreshold, parameters.ThreeLevel)
+	timings.Hysteresis = time.Since(start)
+
+	return &EdgeMap{Gray: finalEdges, Edges: edgePixels}, timings
 }
@@ -0,0 +1,57 @@
+package utils
+
+/*
+This file tests the levels of the edge maps: binary by default, and with the weak edges kept by the hysteresis apart
+from the strong ones in a three-level map, on a page with lines of faint text.
+*/
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestEdgeLevels(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 200, 160))
+	for y := 0; y < 160; y++ {
+		for x := 0; x < 200; x++ {
+			value := uint8(60)
+			if x >= 20 && x < 180 && y >= 20 && y < 140 {
+				value = 220
+				if (y-35)%20 < 3 && x >= 35 && x < 165 && (x-35)%16 < 11 {
+					value = 190 - uint8(x%40)
+				}
+			}
+			gray.SetGray(x, y, color.Gray{Y: value})
+		}
+	}
+
+	binary, _ := ApplyCannyEdgeMap(gray, DefaultCannyParameters)
+	parameters := DefaultCannyParameters
+	parameters.ThreeLevel = true
+	threeLevel, _ := ApplyCannyEdgeMap(gray, parameters)
+
+	if !reflect.DeepEqual(binary.Edges, threeLevel.Edges) {
+		t.Fatal("the edge pixels of the three-level map differ from those of the binary one")
+	}
+	weak := 0
+	for i, value := range threeLevel.Pix {
+		switch {
+		case binary.Pix[i] != 0 && binary.Pix[i] != StrongEdge:
+			t.Fatalf("pixel %d of the binary map is %d, expected 0 or %d", i, binary.Pix[i], StrongEdge)
+		case value == WeakEdge && binary.Pix[i] == StrongEdge:
+			weak++
+		case value != binary.Pix[i]:
+			t.Fatalf("pixel %d of the three-level map is %d, expected %d as in the binary map", i, value, binary.Pix[i])
+		}
+	}
+	if weak == 0 {
+		t.Fatal("no weak edge in the three-level map")
+	}
+
+	contours := NewContourArena().FindContoursSeeded(threeLevel.Gray, threeLevel.Bounds(), threeLevel.Edges, WeakEdge-1)
+	if expected := FindContoursSeeded(binary.Gray, binary.Bounds(), binary.Edges); !reflect.DeepEqual(contours, expected) {
+		t.Fatalf("found %d contours in the three-level map, expected the %d of the binary one", len(contours), len(expected))
+	}
+}
@@ -0,0 +1,155 @@
+package utils
+
+/*
+Package utils provides the arena the contour searches store their points in. Every component used to be traced into
+a queue of its own, grown by appends and sliced from the front, then copied point by point into a contour grown the
+same way, and every search allocated a set of the visited pixels of the whole image: on a high-resolution edge map,
+millions of small allocations, most of the time of the search spent in the allocator and the garbage collector. The
+points of all the contours of a search are now appended to a single buffer, which is also the queue of the search,
+and the buffer and the set of the visited pixels are kept from one search to the next.
+
+---
+
+### ContourArena
+Buffers of the contour searches of a request: the points of its contours and the set of the visited pixels. An arena
+is used by one goroutine at a time, e.g. by the search of one chunk.
+
+- **Methods**:
+  - `FindContoursBFS(img *image.Gray, bounds image.Rectangle, threshold uint8) []geometry.Contour`,
+    `FindContoursSeeded(img *image.Gray, bounds image.Rectangle, seeds []geometry.Point, threshold uint8) []geometry.Contour`:
+    The searches of the same name (see `BFS.go`), of the pixels of `img` brighter than `threshold` (see
+    `ForegroundThreshold`), their contours stored in the arena. They stay valid until `Reset`, even when a later
+    search of the arena grows its buffer.
+  - `Reset()`: Empties the arena, keeping its buffers for the next request. The contours found by its searches must
+    no longer be used: the next searches write over their points.
+
+### NewContourArena() *ContourArena
+Returns an empty arena, whose buffers are allocated by its first searches.
+
+### (arena *ContourArena) visitedSet(bounds image.Rectangle) pixelSet
+Returns an empty set of the pixels of `bounds`, reusing the bits of the previous search.
+
+### (arena *ContourArena) trace(img *image.Gray, start geometry.Point, visited pixelSet, threshold uint8) geometry.Contour
+Returns the pixels of the component of `start`, those brighter than `threshold`, in the order of the search, and marks them visited. The pixels are
+marked visited as soon as they are queued rather than once they are dequeued, so the queue holds every pixel once,
+in the order they are dequeued: the queue is the contour. The components of `minContourSize` pixels or fewer are
+removed from the buffer and nil is returned. The capacity of the contour is its length, so appending to it copies it
+instead of writing over the next contour.
+
+---
+
+### Constants
+- `minContourSize`: Number of pixels a component must exceed to make a contour: the smaller ones are noise.
+
+---
+
+### Example Usage:
+```go
+arena := utils.NewContourArena()
+for _, edges := range edgeMaps {
+	threshold := utils.ForegroundThreshold(edges.Gray)
+	contours := arena.FindContoursSeeded(edges.Gray, edges.Gray.Bounds(), edges.Edges, threshold)
+	document := utils.FindQuadrilateral(contours)
+	...
+	arena.Reset()
+}
+```
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"ELP-project/internal/imageUtils"
+	"cmp"
+	"image"
+	"slices"
+)
+
+const minContourSize = 50
+
+type ContourArena struct {
+	points []geometry.Point
+	bits   []uint64
+}
+
+func NewContourArena() *ContourArena {
+	return &ContourArena{}
+}
+
+func (arena *ContourArena) Reset() {
+	arena.points = arena.points[:0]
+}
+
+func (arena *ContourArena) FindContoursBFS(img *image.Gray, bounds image.Rectangle, threshold uint8) []geometry.Contour {
+	visited := arena.visitedSet(img.Bounds())
+	var contours []geometry.Contour
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			p := geometry.Point{X: x, Y: y}
+
+			if imageUtils.IsForeground(img, x, y, threshold) && !visited.has(p) {
+				if contour := arena.trace(img, p, visited, threshold); contour != nil {
+					contours = append(contours, contour)
+				}
+			}
+		}
+	}
+
+	return contours
+}
+
+func (arena *ContourArena) FindContoursSeeded(img *image.Gray, bounds image.Rectangle, seeds []geometry.Point, threshold uint8) []geometry.Contour {
+	visited := arena.visitedSet(img.Bounds())
+	var contours []geometry.Contour
+
+	first, _ := slices.BinarySearchFunc(seeds, bounds.Min.Y, func(seed geometry.Point, y int) int {
+		return cmp.Compare(seed.Y, y)
+	})
+	for _, p := range seeds[first:] {
+		if p.Y >= bounds.Max.Y {
+			break
+		}
+		if p.X < bounds.Min.X || p.X >= bounds.Max.X || visited.has(p) || !imageUtils.IsForeground(img, p.X, p.Y, threshold) {
+			continue
+		}
+		if contour := arena.trace(img, p, visited, threshold); contour != nil {
+			contours = append(contours, contour)
+		}
+	}
+
+	return contours
+}
+
+func (arena *ContourArena) visitedSet(bounds image.Rectangle) pixelSet {
+	words := (bounds.Dx()*bounds.Dy() + 63) / 64
+	if cap(arena.bits) < words {
+		arena.bits = make([]uint64, words)
+	} else {
+		arena.bits = arena.bits[:words]
+		clear(arena.bits)
+	}
+	return pixelSet{bounds: bounds, bits: arena.bits}
+}
+
+func (arena *ContourArena) trace(img *image.Gray, start geometry.Point, visited pixelSet, threshold uint8) geometry.Contour {
+	first := len(arena.points)
+	visited.add(start)
+	arena.points = append(arena.points, start)
+
+	for i := first; i < len(arena.points); i++ {
+		curr := arena.points[i]
+		for _, d := range directions {
+			neighbor := geometry.Point{X: curr.X + d.X, Y: curr.Y + d.Y}
+			if imageUtils.IsForeground(img, neighbor.X, neighbor.Y, threshold) && !visited.has(neighbor) {
+				visited.add(neighbor)
+				arena.points = append(arena.points, neighbor)
+			}
+		}
+	}
+
+	if len(arena.points)-first <= minContourSize {
+		arena.points = arena.points[:first]
+		return nil
+	}
+	return arena.points[first:len(arena.points):len(arena.points)]
+}
@@ -0,0 +1,146 @@
+package utils
+
+/*
+Package utils provides the correction of the skew of a document: a page photographed nearly flat but not square to the
+camera, or fed askew into a scanner, keeps its text lines slanted once cropped, by a few degrees the outline of the
+page does not show when its corners are off the photo, or when the page was cropped to its bounding box. The angle of
+the text is estimated from its projection profile: the dark pixels of the page, summed along the lines of every angle
+tried, pile up into narrow peaks at the angle of the text lines, and spread out at any other.
+
+---
+
+### EstimateSkew(gray *image.Gray) float64
+Returns the clockwise angle, in degrees, the text lines of `gray` are slanted by, from -`maxSkew` to `maxSkew`: a line
+going down to the right has a positive angle. 0 for an image without ink.
+
+- **Behavior**:
+  - The ink is the pixels darker than `skewInkRatio` times the mean brightness of the image, sampled on a grid of
+    about `skewSamples` pixels so a large scan costs no more than a small one.
+  - Every angle is scored by the sum of the squares of the ink counts of the lines of the angle, one sample apart:
+    the sum is the largest when the ink falls into the fewest lines, i.e. along the text lines.
+  - The angles are tried every `skewCoarseStep` degrees, then every `skewFineStep` degrees around the best one. Of
+    the angles of the same score, the closest to 0 wins, so a straight page is not turned.
+
+### Deskew(img *image.RGBA) (*image.RGBA, float64)
+Returns `img` rotated about its center to straighten its text lines (see `EstimateSkew`), interpolated with
+`imageUtils.Bilinear`, and the clockwise angle it was slanted by, in degrees.
+
+- **Behavior**:
+  - The result has the size of `img`, and bounds starting at (0, 0). Its corners, outside of `img` once rotated,
+    take the color of the closest pixel of its border, the paper of a cropped page.
+  - `img` itself is returned if it is slanted by less than `minDeskewAngle`, whose rotation would only blur it.
+
+### skewScore(ink []geometry.Point, angle float64, step int, counts []int) float64
+Returns the score of `angle`, in degrees, for the `ink` pixels sampled every `step` pixels: the sum of the squares of
+their counts along the lines of `angle`. `counts` holds the count of every line, the line through the origin in its
+middle, and is cleared first: it is reused from one angle to the next.
+
+---
+
+### Constants
+- `maxSkew`: Largest angle tried, in degrees: a document slanted by more was not captured flat.
+- `minDeskewAngle`: Smallest angle, in degrees, `Deskew` corrects.
+- `skewCoarseStep`, `skewFineStep`: Steps, in degrees, of the first and second searches of the angle.
+- `skewInkRatio`: Brightness, as a fraction of the mean brightness of the image, below which a pixel is ink.
+- `skewSamples`: Number of pixels of the image sampled for ink, about.
+
+---
+
+### Example Usage:
+```go
+straightened, angle := utils.Deskew(cropped)
+log.Printf("Straightened the document by %.1f°", angle)
+```
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"ELP-project/internal/imageUtils"
+	"image"
+	"math"
+)
+
+const (
+	maxSkew        = 15.0
+	minDeskewAngle = 0.1
+	skewCoarseStep = 0.5
+	skewFineStep   = 0.05
+	skewInkRatio   = 0.75
+	skewSamples    = 1 << 20
+)
+
+func EstimateSkew(gray *image.Gray) float64 {
+	bounds := gray.Bounds()
+	if bounds.Empty() {
+		return 0
+	}
+	step := max(1, int(math.Sqrt(float64(bounds.Dx()*bounds.Dy())/skewSamples)))
+
+	var sum, count int
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			sum += int(gray.Pix[gray.PixOffset(x, y)])
+			count++
+		}
+	}
+	threshold := skewInkRatio * float64(sum) / float64(count)
+
+	var ink []geometry.Point
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			if float64(gray.Pix[gray.PixOffset(x, y)]) < threshold {
+				ink = append(ink, geometry.Point{X: x - bounds.Min.X, Y: y - bounds.Min.Y})
+			}
+		}
+	}
+	if len(ink) == 0 {
+		return 0
+	}
+	counts := make([]int, 2*(bounds.Dx()+bounds.Dy())/step+3)
+
+	// Lines as thick as the ink score the same over a range of angles: the one closest to 0 is kept.
+	search := func(around, by float64, steps int) float64 {
+		best, bestScore := 0.0, -1.0
+		for i := -steps; i <= steps; i++ {
+			angle := around + float64(i)*by
+			if math.Abs(angle) > maxSkew {
+				continue
+			}
+			score := skewScore(ink, angle, step, counts)
+			if score > bestScore || (score == bestScore && math.Abs(angle) < math.Abs(best)) {
+				best, bestScore = angle, score
+			}
+		}
+		return best
+	}
+	coarse := search(0, skewCoarseStep, int(maxSkew/skewCoarseStep))
+	return search(coarse, skewFineStep, int(math.Round(skewCoarseStep/skewFineStep)))
+}
+
+func Deskew(img *image.RGBA) (*image.RGBA, float64) {
+	angle := EstimateSkew(imageUtils.Grayscale(img))
+	if math.Abs(angle) < minDeskewAngle {
+		return img, angle
+	}
+
+	// Not `imageUtils.Rotate`: the page keeps its size, without transparent corners.
+	bounds := img.Bounds()
+	matrix := imageUtils.RotationMatrix(bounds, -angle, bounds.Dx(), bounds.Dy())
+	return imageUtils.WarpAffine(img, matrix, bounds.Dx(), bounds.Dy(), imageUtils.Bilinear), angle
+}
+
+func skewScore(ink []geometry.Point, angle float64, step int, counts []int) float64 {
+	clear(counts)
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	origin := len(counts) / 2
+	for _, p := range ink {
+		line := math.Round((float64(p.Y)*cos - float64(p.X)*sin) / float64(step))
+		counts[origin+int(line)]++
+	}
+
+	var score float64
+	for _, count := range counts {
+		score += float64(count) * float64(count)
+	}
+	return score
+}
@@ -0,0 +1,58 @@
+package utils
+
+/*
+This file tests the estimation of the skew of a page of text lines turned by a few degrees either way, and its
+correction.
+*/
+
+import (
+	"ELP-project/internal/imageUtils"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+func textPage(width, height int) *image.RGBA {
+	page := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(page, page.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	ink := image.NewUniform(color.Black)
+	for y := 30; y < height-30; y += 18 {
+		for x, word := 30, 0; x < width-60; word++ {
+			length := 12 + (word*7+y)%25
+			draw.Draw(page, image.Rect(x, y, x+length, y+6), ink, image.Point{}, draw.Src)
+			x += length + 8
+		}
+	}
+	return page
+}
+
+func TestDeskew(t *testing.T) {
+	page := textPage(500, 400)
+	if got := EstimateSkew(imageUtils.Grayscale(page)); math.Abs(got) > skewFineStep {
+		t.Fatalf("estimated a skew of %g° for a straight page, expected 0", got)
+	}
+
+	for _, angle := range []float64{3, -7.2} {
+		slanted := imageUtils.WarpAffine(page, imageUtils.RotationMatrix(page.Bounds(), angle, 500, 400), 500, 400, imageUtils.Bilinear)
+
+		if got := EstimateSkew(imageUtils.Grayscale(slanted)); math.Abs(got-angle) > 0.2 {
+			t.Errorf("estimated a skew of %g° for a page turned by %g°", got, angle)
+		}
+		straightened, got := Deskew(slanted)
+		if math.Abs(got-angle) > 0.2 {
+			t.Errorf("deskewed a page turned by %g° by %g°", angle, got)
+		}
+		if straightened.Bounds() != slanted.Bounds() {
+			t.Errorf("deskewed page has bounds %v, expected %v", straightened.Bounds(), slanted.Bounds())
+		}
+		if residual := EstimateSkew(imageUtils.Grayscale(straightened)); math.Abs(residual) > 0.2 {
+			t.Errorf("page turned by %g° is still slanted by %g° once deskewed", angle, residual)
+		}
+	}
+
+	if got, _ := Deskew(page); got != page {
+		t.Error("deskewed a straight page, expected it returned as is")
+	}
+}
@@ -25,6 +25,22 @@ Draws a contour on the given image and returns a new RGBA image with the highlig
   - Highlighting detected shapes or contours in an image.
   - Visualization of geometric data superimposed on images.
 
+### DrawContours(img image.Image, contours []geometry.Contour, selected geometry.Contour) *image.RGBA
+Same as `DrawContour` for a set of contours, all drawn in red, except `selected` which is drawn in green over them.
+Used to show which contours were found on an image, and which one was taken for the document.
+
+### AnnotateDocument(img draw.Image, contours int, document geometry.ContourWithArea)
+Labels an overlay drawn by `DrawContours` for a human reader, with the bitmap font of `imageUtils`:
+- The quadrilateral of the document, through its four corners found by `FitQuadrilateral`, is outlined in green, and
+  every corner is labelled with its number (1 for the top left corner, clockwise) and its coordinates.
+- A summary in the top left corner of the image gives the number of contours found and the area of the
+  quadrilateral of the document, or tells that no document was found.
+
+The labels are drawn in white on black boxes at the scale of `imageUtils.LabelScale`, and are kept inside the image.
+
+### labelOrigin(bounds image.Rectangle, point image.Point, size image.Point) image.Point
+Returns where a label of `size` pixels is drawn next to `point` so it stays inside `bounds`.
+
 ---
 
 ### Example Usage:
@@ -75,6 +91,8 @@ func main() {
 
 import (
 	"ELP-project/internal/geometry"
+	"ELP-project/internal/imageUtils"
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
@@ -93,3 +111,59 @@ func DrawContour(img image.Image, contour geometry.Contour) *image.RGBA {
 
 	return output
 }
+
+func DrawContours(img image.Image, contours []geometry.Contour, selected geometry.Contour) *image.RGBA {
+	bounds := img.Bounds()
+	output := image.NewRGBA(bounds)
+
+	draw.Draw(output, bounds, img, bounds.Min, draw.Src)
+
+	red := color.RGBA{R: 255, A: 255}
+	for _, contour := range contours {
+		for _, p := range contour {
+			output.Set(p.X, p.Y, red)
+		}
+	}
+
+	green := color.RGBA{G: 255, A: 255}
+	for _, p := range selected {
+		output.Set(p.X, p.Y, green)
+	}
+
+	return output
+}
+
+func AnnotateDocument(img draw.Image, contours int, document geometry.ContourWithArea) {
+	bounds := img.Bounds()
+	scale := imageUtils.LabelScale(bounds)
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	black := color.RGBA{A: 192}
+
+	summary := fmt.Sprintf("CONTOURS: %d\nNO DOCUMENT FOUND", contours)
+	if len(document.Contour) > 0 {
+		quadrilateral := FitQuadrilateral(document.Contour)
+		summary = fmt.Sprintf("CONTOURS: %d\nDOCUMENT: %.0f PX", contours, quadrilateral.Area)
+
+		green := color.RGBA{G: 255, A: 255}
+		corners := quadrilateral.Contour
+		for i, corner := range corners {
+			next := corners[(i+1)%len(corners)]
+			imageUtils.DrawLine(img, image.Pt(corner.X, corner.Y), image.Pt(next.X, next.Y), scale, green)
+		}
+		for i, corner := range corners {
+			label := fmt.Sprintf("%d (%d,%d)", i+1, corner.X, corner.Y)
+			size := imageUtils.TextSize(label, scale).Add(image.Pt(2*scale, 2*scale))
+			origin := labelOrigin(bounds, image.Pt(corner.X, corner.Y), size)
+			imageUtils.DrawLabel(img, label, origin, scale, white, black)
+		}
+	}
+
+	imageUtils.DrawLabel(img, summary, bounds.Min, scale, white, black)
+}
+
+func labelOrigin(bounds image.Rectangle, point image.Point, size image.Point) image.Point {
+	origin := point
+	origin.X = max(bounds.Min.X, min(origin.X, bounds.Max.X-size.X))
+	origin.Y = max(bounds.Min.Y, min(origin.Y, bounds.Max.Y-size.Y))
+	return origin
+}
@@ -23,6 +23,19 @@ Finds the bounding corners of a contour.
     - `corner2` is updated to ensure it holds the maximum `X` and `Y` values.
   - Effectively computes a bounding box for the entire contour.
 
+### FindQuadrilateralCorners(contour geometry.Contour) geometry.ContourWithArea
+Finds the four corners of a contour shaped like a quadrilateral, e.g. a photographed document, which unlike the
+bounding box of `FindCorner` follow the document when it is rotated or seen in perspective.
+
+- **Returns**:
+  - `geometry.ContourWithArea`: The top-left, top-right, bottom-right and bottom-left corners, in this order, and
+    the area of the quadrilateral they form. An empty contour gives no corner and a zero area.
+
+- **Behavior**:
+  - The top-left and bottom-right corners are the points with the smallest and largest `X + Y`, the top-right and
+    bottom-left corners the points with the largest and smallest `X - Y`. The first point reaching an extreme wins.
+  - The area is computed with the shoelace formula.
+
 ---
 
 ### Key Features:
@@ -86,3 +99,28 @@ func FindCorner(contour geometry.Contour, center geometry.Point) geometry.Contou
 
 	return geometry.Contour{corner1, corner2}
 }
+
+func FindQuadrilateralCorners(contour geometry.Contour) geometry.ContourWithArea {
+	if len(contour) == 0 {
+		return geometry.ContourWithArea{}
+	}
+
+	topLeft, topRight, bottomRight, bottomLeft := contour[0], contour[0], contour[0], contour[0]
+	for _, point := range contour[1:] {
+		if point.X+point.Y < topLeft.X+topLeft.Y {
+			topLeft = point
+		}
+		if point.X+point.Y > bottomRight.X+bottomRight.Y {
+			bottomRight = point
+		}
+		if point.X-point.Y > topRight.X-topRight.Y {
+			topRight = point
+		}
+		if point.X-point.Y < bottomLeft.X-bottomLeft.Y {
+			bottomLeft = point
+		}
+	}
+
+	corners := geometry.Contour{topLeft, topRight, bottomRight, bottomLeft}
+	return geometry.ContourWithArea{Contour: corners, Area: polygonArea(corners)}
+}
@@ -0,0 +1,150 @@
+package utils
+
+/*
+Package utils provides the detection of several documents in the same image, e.g. receipts laid side by side on a
+table. The ranking keeps a single document, the best candidate, and its next ones are only alternatives: here every
+candidate looking like a page is a document, the others being the characters and the drawings of the documents, the
+background, or the table around them.
+
+---
+
+### DocumentFilter
+What a candidate must look like to be one of the documents of `FindDocuments`.
+
+- **Fields**:
+  - `Bounds`: Bounds of the image the candidates were found in.
+  - `MinArea`: Smallest area of the quadrilateral of a document (`FitQuadrilateral`), as a fraction of the area of
+    `Bounds`, from 0 to 1.
+  - `MinAspect`: Smallest `AspectScore` of a document, from 0 to 1: 0.1 keeps a receipt ten times as long as it is
+    wide, and drops the thin strips of a table edge or a ruler.
+
+### AspectScore(corners geometry.Contour) float64
+Returns the ratio of the shorter side of the quadrilateral of `corners` to its longer side (see
+`QuadrilateralSize`), from 0 for a line to 1 for a square: about 0.71 for an A4 page, 0.3 for a receipt. 0 if
+`corners` are not four.
+
+### FindDocuments(candidates []Candidate, filter DocumentFilter) []Candidate
+Returns the `candidates` which are documents of their own, in their order, with their `Validation` set.
+
+- **Behavior**:
+  - A document must pass `filter`, the candidates of a bounding box smaller than `MinArea` being skipped right away
+    (the characters of the documents), and be close enough to a quadrilateral: its `Validation` must be at least
+    `documentMinValidation`.
+  - A candidate enclosing two documents or more, e.g. a tray or a sheet of paper the receipts are laid on, is the
+    background, not a document.
+  - A document within another one (its center inside the quadrilateral of the larger one), e.g. a photo printed on
+    a page or the same page found by two detectors, is a part of it and dropped.
+
+### quadrilateralCenter(corners geometry.Contour) geometry.Point
+Returns the mean of `corners`, inside the quadrilateral they make if it is convex.
+
+---
+
+### Constants
+- `documentMinValidation`: Smallest `Validation` of a document: a disk has about 0.64.
+
+---
+
+### Example Usage:
+```go
+candidates := ranking.Rank(contours, utils.FindQuadrilateral)
+filter := utils.DocumentFilter{Bounds: gray.Bounds(), MinArea: 0.01, MinAspect: 0.1}
+for _, receipt := range utils.FindDocuments(candidates, filter) {
+	cropped := utils.WarpQuadrilateral(img, utils.FitQuadrilateral(receipt.Contour).Contour)
+}
+```
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"image"
+)
+
+const documentMinValidation = 0.85
+
+type DocumentFilter struct {
+	Bounds    image.Rectangle
+	MinArea   float64
+	MinAspect float64
+}
+
+func AspectScore(corners geometry.Contour) float64 {
+	width, height := QuadrilateralSize(corners)
+	if width == 0 || height == 0 {
+		return 0
+	}
+	return float64(min(width, height)) / float64(max(width, height))
+}
+
+func FindDocuments(candidates []Candidate, filter DocumentFilter) []Candidate {
+	type document struct {
+		candidate     Candidate
+		quadrilateral geometry.ContourWithArea
+		center        geometry.Point
+	}
+	minArea := filter.MinArea * float64(filter.Bounds.Dx()*filter.Bounds.Dy())
+	var documents []document
+	for _, candidate := range candidates {
+		if box := contourBox(candidate.Contour); float64(box.Dx()*box.Dy()) < minArea {
+			continue
+		}
+		quadrilateral := FitQuadrilateral(candidate.Contour)
+		if len(quadrilateral.Contour) != 4 || quadrilateral.Area < minArea ||
+			AspectScore(quadrilateral.Contour) < filter.MinAspect {
+			continue
+		}
+		validated := []Candidate{candidate}
+		Validate(validated)
+		if validated[0].Validation < documentMinValidation {
+			continue
+		}
+		documents = append(documents, document{
+			candidate:     validated[0],
+			quadrilateral: quadrilateral,
+			center:        quadrilateralCenter(quadrilateral.Contour),
+		})
+	}
+
+	inside := func(inner, outer document) bool {
+		return isInsideQuad(inner.center.X, inner.center.Y, outer.quadrilateral.Contour)
+	}
+	enclosing := make([]bool, len(documents))
+	for i, outer := range documents {
+		enclosed := 0
+		for j, inner := range documents {
+			if i != j && inner.quadrilateral.Area < outer.quadrilateral.Area && inside(inner, outer) {
+				enclosed++
+			}
+		}
+		enclosing[i] = enclosed >= 2
+	}
+
+	var found []Candidate
+	for i, inner := range documents {
+		if enclosing[i] {
+			continue
+		}
+		nested := false
+		for j, outer := range documents {
+			larger := outer.quadrilateral.Area > inner.quadrilateral.Area ||
+				(outer.quadrilateral.Area == inner.quadrilateral.Area && j < i)
+			if j != i && !enclosing[j] && larger && inside(inner, outer) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			found = append(found, inner.candidate)
+		}
+	}
+	return found
+}
+
+func quadrilateralCenter(corners geometry.Contour) geometry.Point {
+	var x, y int
+	for _, corner := range corners {
+		x += corner.X
+		y += corner.Y
+	}
+	return geometry.Point{X: x / len(corners), Y: y / len(corners)}
+}
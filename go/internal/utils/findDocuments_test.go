@@ -0,0 +1,57 @@
+package utils
+
+/*
+This file tests the detection of several documents: receipts and a page laid on a tray, among a photo printed on
+the page, a ruler, a character and a coin, which are not documents.
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"image"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestFindDocuments(t *testing.T) {
+	bounds := image.Rect(0, 0, 400, 300)
+	tray := rectangle(20, 20, 380, 280)
+	receipt := rectangle(40, 40, 100, 260)
+	otherReceipt := rectangle(150, 40, 210, 240)
+	page := rectangle(250, 60, 360, 200)
+	photo := rectangle(270, 80, 320, 130)
+	ruler := rectangle(20, 285, 380, 292)
+	character := rectangle(300, 250, 305, 258)
+	var coin geometry.Contour
+	for i := range 64 {
+		angle := 2 * math.Pi * float64(i) / 64
+		coin = append(coin, geometry.Point{X: 150 + int(math.Round(30*math.Cos(angle))), Y: 260 + int(math.Round(30*math.Sin(angle)))})
+	}
+
+	contours := []geometry.Contour{tray, receipt, otherReceipt, page, photo, ruler, character, coin}
+	candidates := (CandidateRanking{}).Rank(contours, FindQuadrilateral)
+	documents := FindDocuments(candidates, DocumentFilter{Bounds: bounds, MinArea: 0.01, MinAspect: 0.1})
+
+	var found []geometry.Point
+	for _, document := range documents {
+		found = append(found, document.Contour[0])
+		if document.Validation < documentMinValidation {
+			t.Errorf("document at %v has a validation of %g", document.Contour[0], document.Validation)
+		}
+	}
+	if want := []geometry.Point{page[0], receipt[0], otherReceipt[0]}; !slices.Equal(found, want) {
+		t.Fatalf("found the documents at %v, expected %v", found, want)
+	}
+
+	documents = FindDocuments(candidates, DocumentFilter{Bounds: bounds, MinArea: 0.01, MinAspect: 0.5})
+	if len(documents) != 1 || documents[0].Contour[0] != page[0] {
+		t.Fatalf("found %d documents without the receipts, expected the page only", len(documents))
+	}
+
+	if got := AspectScore(receipt); math.Abs(got-61.0/221) > 1e-9 {
+		t.Errorf("aspect of the receipt is %g, expected %g", got, 61.0/221)
+	}
+	if got := AspectScore(geometry.Contour{{X: 0, Y: 0}}); got != 0 {
+		t.Errorf("aspect of a point is %g, expected 0", got)
+	}
+}
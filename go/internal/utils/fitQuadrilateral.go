@@ -0,0 +1,156 @@
+package utils
+
+/*
+Package utils provides the fitting of the four corners of a document on its contour. `FindCorner` only gives the
+bounding box of the contour, and the extreme points of `FindQuadrilateralCorners` slide along a side once the page is
+rotated by about 45°, where two corners reach the same `X + Y`. The corners are found on the outline itself instead:
+the convex hull of the contour is simplified down to four vertices, whatever the orientation of the page.
+
+---
+
+### SimplifyPolygon(polygon geometry.Contour, epsilon float64) geometry.Contour
+Simplifies a closed polygon with the Douglas-Peucker algorithm: the vertices within `epsilon` pixels of the segment
+joining the vertices kept around them are dropped, in the order of `polygon`.
+
+- **Behavior**:
+  - The polygon is split at its first vertex and the vertex farthest from it, which are always kept, and both chains
+    are simplified in turn: the vertex of a chain farthest from the segment joining its ends is kept if it is more
+    than `epsilon` away, and the chain is split there.
+  - A polygon of fewer than four vertices is returned as it is.
+
+### FitQuadrilateral(contour geometry.Contour) geometry.ContourWithArea
+Finds the four corners of a contour shaped like a quadrilateral, e.g. a photographed document, at any rotation.
+
+- **Returns**:
+  - `geometry.ContourWithArea`: The top-left, top-right, bottom-right and bottom-left corners, clockwise on the
+    screen from the corner of the smallest `X + Y` (the topmost of them on a tie), and the area of the quadrilateral
+    they form. The corners are points of the contour.
+
+- **Behavior**:
+  - The convex hull of the contour is simplified by `SimplifyPolygon`, with a tolerance of `quadrilateralTolerance`
+    of its perimeter, which leaves the vertices of the corners and a few on the rounded or dog-eared ones.
+  - The vertex spanning the smallest triangle with its neighbors is then dropped until four are left, so the
+    quadrilateral loses as little area of the hull as possible at every step.
+  - A hull of fewer than four vertices, e.g. that of a straight stroke, falls back on `FindQuadrilateralCorners`.
+
+### topLeftFirst(corners geometry.Contour) geometry.Contour
+Returns `corners`, listed clockwise, rotated to start from the corner of the smallest `X + Y`, the topmost of them on
+a tie.
+
+### triangleArea(a, b, c geometry.Point) int
+Returns twice the area of the triangle `abc`.
+
+---
+
+### Example Usage:
+```go
+document := utils.FindQuadrilateral(contours)
+corners := utils.FitQuadrilateral(document.Contour).Contour
+page := utils.WarpQuadrilateral(img, corners)
+```
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"math"
+	"slices"
+)
+
+const quadrilateralTolerance = 0.01
+
+func SimplifyPolygon(polygon geometry.Contour, epsilon float64) geometry.Contour {
+	if len(polygon) < 4 {
+		return polygon
+	}
+
+	farthest, farthestDistance := 0, 0.0
+	for i, point := range polygon {
+		if distance := math.Hypot(float64(point.X-polygon[0].X), float64(point.Y-polygon[0].Y)); distance > farthestDistance {
+			farthest, farthestDistance = i, distance
+		}
+	}
+
+	keep := make([]bool, len(polygon))
+	keep[0], keep[farthest] = true, true
+	type chain struct{ start, end int }
+	chains := []chain{{0, farthest}, {farthest, len(polygon)}}
+	for len(chains) > 0 {
+		current := chains[len(chains)-1]
+		chains = chains[:len(chains)-1]
+		start, end := polygon[current.start], polygon[current.end%len(polygon)]
+		length := math.Hypot(float64(end.X-start.X), float64(end.Y-start.Y))
+
+		split, splitDistance := -1, epsilon
+		for i := current.start + 1; i < current.end; i++ {
+			distance := math.Hypot(float64(polygon[i].X-start.X), float64(polygon[i].Y-start.Y))
+			if length > 0 {
+				distance = float64(triangleArea(start, end, polygon[i])) / length
+			}
+			if distance > splitDistance {
+				split, splitDistance = i, distance
+			}
+		}
+		if split >= 0 {
+			keep[split] = true
+			chains = append(chains, chain{current.start, split}, chain{split, current.end})
+		}
+	}
+
+	simplified := make(geometry.Contour, 0, len(polygon))
+	for i, point := range polygon {
+		if keep[i] {
+			simplified = append(simplified, point)
+		}
+	}
+	return simplified
+}
+
+func FitQuadrilateral(contour geometry.Contour) geometry.ContourWithArea {
+	hull := ConvexHull(contour)
+	if len(hull) < 4 {
+		return FindQuadrilateralCorners(hull)
+	}
+
+	perimeter := 0.0
+	for i, point := range hull {
+		next := hull[(i+1)%len(hull)]
+		perimeter += math.Hypot(float64(next.X-point.X), float64(next.Y-point.Y))
+	}
+	corners := SimplifyPolygon(hull, quadrilateralTolerance*perimeter)
+	if len(corners) < 4 {
+		corners = hull
+	}
+	corners = slices.Clone(corners)
+	for len(corners) > 4 {
+		smallest, smallestArea := 0, math.MaxInt
+		for i, corner := range corners {
+			previous, next := corners[(i+len(corners)-1)%len(corners)], corners[(i+1)%len(corners)]
+			if area := triangleArea(previous, corner, next); area < smallestArea {
+				smallest, smallestArea = i, area
+			}
+		}
+		corners = slices.Delete(corners, smallest, smallest+1)
+	}
+
+	corners = topLeftFirst(corners)
+	return geometry.ContourWithArea{Contour: corners, Area: polygonArea(corners)}
+}
+
+func topLeftFirst(corners geometry.Contour) geometry.Contour {
+	first := 0
+	for i, corner := range corners {
+		top := corners[first]
+		if corner.X+corner.Y < top.X+top.Y || (corner.X+corner.Y == top.X+top.Y && corner.Y < top.Y) {
+			first = i
+		}
+	}
+	return slices.Concat(corners[first:], corners[:first])
+}
+
+func triangleArea(a, b, c geometry.Point) int {
+	area := (b.X-a.X)*(c.Y-a.Y) - (b.Y-a.Y)*(c.X-a.X)
+	if area < 0 {
+		return -area
+	}
+	return area
+}
@@ -0,0 +1,91 @@
+package utils
+
+/*
+This file tests the fitting of the corners of a page outline rotated by various angles, up to the 45° where the
+extreme points of `FindQuadrilateralCorners` slide along a side, and of a page with a dog-eared corner.
+
+---
+
+### outlinePolygon(corners geometry.Contour) geometry.Contour
+Returns the pixels of the outline of the polygon of `corners`, as `FindContoursBFS` would find them.
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"image"
+	"math"
+	"testing"
+)
+
+func outlinePolygon(corners geometry.Contour) geometry.Contour {
+	edges := image.NewGray(image.Rect(0, 0, 400, 400))
+	for i, corner := range corners {
+		drawSegment(edges, corner, corners[(i+1)%len(corners)])
+	}
+	var outline geometry.Contour
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 400; x++ {
+			if edges.GrayAt(x, y).Y > 0 {
+				outline = append(outline, geometry.Point{X: x, Y: y})
+			}
+		}
+	}
+	return outline
+}
+
+func TestFitQuadrilateral(t *testing.T) {
+	for _, degrees := range []float64{0, 10, 30, 45, 60} {
+		angle := degrees * math.Pi / 180
+		rect := RotatedRect{CenterX: 200, CenterY: 200, Width: 160, Height: 220, Angle: angle}
+		expected := rect.Corners()
+		fitted := FitQuadrilateral(outlinePolygon(expected))
+		if len(fitted.Contour) != 4 {
+			t.Fatalf("%g°: fitted %d corners, expected 4", degrees, len(fitted.Contour))
+		}
+		// The corners are listed from the one of the smallest X + Y, which turns with the page.
+		first := 0
+		for i, corner := range expected {
+			if corner.X+corner.Y < expected[first].X+expected[first].Y {
+				first = i
+			}
+		}
+		for i, corner := range fitted.Contour {
+			want := expected[(first+i)%4]
+			if math.Abs(float64(corner.X-want.X)) > 2 || math.Abs(float64(corner.Y-want.Y)) > 2 {
+				t.Errorf("%g°: corner %d at %v, expected %v", degrees, i, corner, want)
+			}
+		}
+		if area := rect.Width * rect.Height; math.Abs(fitted.Area-area) > 0.02*area {
+			t.Errorf("%g°: area %.0f, expected about %.0f", degrees, fitted.Area, area)
+		}
+	}
+
+	// A dog-eared corner only cuts a small triangle off the quadrilateral.
+	page := outlinePolygon(geometry.Contour{{X: 50, Y: 60}, {X: 320, Y: 60}, {X: 340, Y: 80}, {X: 340, Y: 330}, {X: 50, Y: 330}})
+	expected := geometry.Contour{{X: 50, Y: 60}, {X: 320, Y: 60}, {X: 340, Y: 330}, {X: 50, Y: 330}}
+	fitted := FitQuadrilateral(page).Contour
+	for i, corner := range fitted {
+		if corner != expected[i] && !(i == 1 && corner == geometry.Point{X: 340, Y: 80}) {
+			t.Errorf("dog-eared page: corner %d at %v, expected %v", i, corner, expected[i])
+		}
+	}
+
+	stroke := FitQuadrilateral(geometry.Contour{{X: 10, Y: 10}, {X: 20, Y: 10}, {X: 30, Y: 10}})
+	if stroke.Area != 0 {
+		t.Errorf("straight stroke fitted with an area of %g", stroke.Area)
+	}
+}
+
+func TestSimplifyPolygon(t *testing.T) {
+	// A square with points along its sides and a bump of 2 pixels on its bottom side.
+	polygon := geometry.Contour{
+		{X: 0, Y: 0}, {X: 50, Y: 0}, {X: 100, Y: 0}, {X: 100, Y: 50}, {X: 100, Y: 100},
+		{X: 50, Y: 102}, {X: 0, Y: 100}, {X: 0, Y: 50},
+	}
+	if simplified := SimplifyPolygon(polygon, 3); len(simplified) != 4 {
+		t.Errorf("simplified into %v, expected the four corners", simplified)
+	}
+	if simplified := SimplifyPolygon(polygon, 1); len(simplified) != 5 || simplified[3] != polygon[5] {
+		t.Errorf("simplified into %v, expected the corners and the bump", simplified)
+	}
+}
@@ -0,0 +1,149 @@
+package utils
+
+/*
+Package utils provides the plane of floating point values the stages of the edge detection hand over to each other.
+Stored in an `image.Gray`, the blurred image, its gradient and the gradient left by the Non-Maximum Suppression were
+each rounded down to a whole gray level: a faint edge lost up to a level at every stage, and the gradient of a soft
+edge, a fraction of a level from one pixel to the next, was flattened into ties. The stages now keep the exact values,
+which are only quantized once the edges are detected.
+
+---
+
+### FloatImage
+Plane of `float32` values, laid out like the pixels of an `image.Gray`: the value of (x, y) is at
+`Pix[(y-Rect.Min.Y)*Stride+(x-Rect.Min.X)]`. A `FloatImage` is an `image.Image` of gray levels, its values rounded and
+clamped between 0 and 255, so it can go through the image tasks of the workers, be drawn or be encoded.
+
+- **Fields**:
+  - `Pix`: The values of the plane, row by row.
+  - `Stride`: Distance between two vertically adjacent values in `Pix`.
+  - `Rect`: Bounds of the plane.
+
+- **Methods**:
+  - `ColorModel() color.Model`, `Bounds() image.Rectangle`, `At(x, y int) color.Color`: The `image.Image` interface,
+    `At` returning a `color.Gray`.
+  - `PixOffset(x, y int) int`: Returns the index of the value of (x, y) in `Pix`.
+  - `FloatAt(x, y int) float32`: Returns the value of (x, y), 0 outside of `Rect`.
+  - `SetFloat(x, y int, value float32)`: Sets the value of (x, y), ignored outside of `Rect`.
+  - `SubImage(r image.Rectangle) *FloatImage`: Returns the part of the plane within `r`, sharing its values.
+  - `Gray() *image.Gray`: Returns the plane as gray levels, rounded to the nearest level and clamped between 0 and
+    255.
+
+### NewFloatImage(r image.Rectangle) *FloatImage
+Returns a plane of zeros of bounds `r`.
+
+### FloatFromGray(img *image.Gray) *FloatImage
+Returns the gray levels of `img` as a plane of the same bounds.
+
+### FloatFromRGBA(img *image.RGBA) *FloatImage
+Returns the luminance of `img` as a plane of the same bounds, with the weights of `imageUtils.Grayscale` but not
+rounded.
+
+### quantize(value float32) uint8
+Returns `value` rounded to the nearest gray level, clamped between 0 and 255.
+
+---
+
+### Example Usage:
+```go
+blurred := utils.ApplyKernelFloat(utils.FloatFromGray(gray), utils.GenerateGaussianKernel(5, 1.4))
+gradient, angles := utils.ApplySobelFloat(blurred, sobelX, sobelY)
+imageUtils.SaveImage(gradient.Gray(), "gradient.png", "png")
+```
+*/
+
+import (
+	"image"
+	"image/color"
+)
+
+type FloatImage struct {
+	Pix    []float32
+	Stride int
+	Rect   image.Rectangle
+}
+
+func NewFloatImage(r image.Rectangle) *FloatImage {
+	return &FloatImage{Pix: make([]float32, r.Dx()*r.Dy()), Stride: r.Dx(), Rect: r}
+}
+
+func FloatFromGray(img *image.Gray) *FloatImage {
+	bounds := img.Bounds()
+	plane := NewFloatImage(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		source := img.Pix[img.PixOffset(bounds.Min.X, y):img.PixOffset(bounds.Max.X, y)]
+		row := plane.Pix[plane.PixOffset(bounds.Min.X, y):plane.PixOffset(bounds.Max.X, y)]
+		for x, value := range source {
+			row[x] = float32(value)
+		}
+	}
+	return plane
+}
+
+func FloatFromRGBA(img *image.RGBA) *FloatImage {
+	bounds := img.Bounds()
+	plane := NewFloatImage(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		source := img.Pix[img.PixOffset(bounds.Min.X, y):img.PixOffset(bounds.Max.X, y)]
+		row := plane.Pix[plane.PixOffset(bounds.Min.X, y):plane.PixOffset(bounds.Max.X, y)]
+		for x := range row {
+			pixel := source[4*x : 4*x+3]
+			row[x] = float32(0.299*float64(pixel[0]) + 0.587*float64(pixel[1]) + 0.114*float64(pixel[2]))
+		}
+	}
+	return plane
+}
+
+func (img *FloatImage) ColorModel() color.Model {
+	return color.GrayModel
+}
+
+func (img *FloatImage) Bounds() image.Rectangle {
+	return img.Rect
+}
+
+func (img *FloatImage) At(x, y int) color.Color {
+	return color.Gray{Y: quantize(img.FloatAt(x, y))}
+}
+
+func (img *FloatImage) PixOffset(x, y int) int {
+	return (y-img.Rect.Min.Y)*img.Stride + (x - img.Rect.Min.X)
+}
+
+func (img *FloatImage) FloatAt(x, y int) float32 {
+	if !(image.Point{X: x, Y: y}.In(img.Rect)) {
+		return 0
+	}
+	return img.Pix[img.PixOffset(x, y)]
+}
+
+func (img *FloatImage) SetFloat(x, y int, value float32) {
+	if !(image.Point{X: x, Y: y}.In(img.Rect)) {
+		return
+	}
+	img.Pix[img.PixOffset(x, y)] = value
+}
+
+func (img *FloatImage) SubImage(r image.Rectangle) *FloatImage {
+	r = r.Intersect(img.Rect)
+	if r.Empty() {
+		return &FloatImage{}
+	}
+	return &FloatImage{Pix: img.Pix[img.PixOffset(r.Min.X, r.Min.Y):], Stride: img.Stride, Rect: r}
+}
+
+func (img *FloatImage) Gray() *image.Gray {
+	gray := image.NewGray(img.Rect)
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		source := img.Pix[img.PixOffset(img.Rect.Min.X, y):img.PixOffset(img.Rect.Max.X, y)]
+		row := gray.Pix[gray.PixOffset(img.Rect.Min.X, y):gray.PixOffset(img.Rect.Max.X, y)]
+		for x, value := range source {
+			row[x] = quantize(value)
+		}
+	}
+	return gray
+}
+
+func quantize(value float32) uint8 {
+	return uint8(min(max(value+0.5, 0), 255))
+}
@@ -0,0 +1,73 @@
+package utils
+
+/*
+This file tests the conversions of the planes of floating point values from and to gray levels, their sub-images,
+and the blur keeping the fractions of gray levels a rounded blur loses.
+*/
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFloatImage(t *testing.T) {
+	gray := image.NewGray(image.Rect(2, 3, 12, 9))
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i * 4)
+	}
+	plane := FloatFromGray(gray)
+	if plane.Bounds() != gray.Bounds() {
+		t.Fatalf("plane of bounds %v, expected %v", plane.Bounds(), gray.Bounds())
+	}
+	if back := plane.Gray(); string(back.Pix) != string(gray.Pix) {
+		t.Fatal("gray levels changed by the round trip through a plane")
+	}
+
+	sub := plane.SubImage(image.Rect(5, 4, 8, 7))
+	if value := sub.FloatAt(6, 5); value != float32(gray.GrayAt(6, 5).Y) {
+		t.Errorf("sub-image value %v, expected %d", value, gray.GrayAt(6, 5).Y)
+	}
+	sub.SetFloat(6, 5, 300.4)
+	if value := plane.FloatAt(6, 5); value != 300.4 {
+		t.Errorf("value %v set through the sub-image, expected 300.4 in the plane", value)
+	}
+	if value := plane.At(6, 5).(color.Gray).Y; value != 255 {
+		t.Errorf("value 300.4 seen as the gray level %d, expected 255", value)
+	}
+	plane.SetFloat(2, 3, 7.6)
+	if value := plane.At(2, 3).(color.Gray).Y; value != 8 {
+		t.Errorf("value 7.6 seen as the gray level %d, expected 8", value)
+	}
+	if value := sub.FloatAt(2, 3); value != 0 {
+		t.Errorf("value %v outside of the sub-image, expected 0", value)
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	rgba.SetRGBA(0, 0, color.RGBA{R: 200, G: 100, B: 51, A: 255})
+	if value, expected := FloatFromRGBA(rgba).FloatAt(0, 0), float32(0.299*200+0.587*100+0.114*51); value != expected {
+		t.Errorf("luminance %v, expected %v", value, expected)
+	}
+}
+
+func TestApplyKernelFloat(t *testing.T) {
+	// A step of a single gray level, which the blur turns into a ramp of fractions of a level.
+	gray := image.NewGray(image.Rect(0, 0, 20, 5))
+	for y := 0; y < 5; y++ {
+		for x := 10; x < 20; x++ {
+			gray.SetGray(x, y, color.Gray{Y: 1})
+		}
+	}
+	blurred := ApplyKernelFloat(FloatFromGray(gray), GenerateGaussianKernel(5, 1.4))
+	previous := float32(-1)
+	for x := 7; x < 13; x++ {
+		value := blurred.FloatAt(x, 2)
+		if value <= previous || value < 0 || value > 1 {
+			t.Fatalf("blurred step is %v at %d after %v, expected a ramp from 0 to 1", value, x, previous)
+		}
+		previous = value
+	}
+	if rounded := ApplyKernel(gray, GenerateGaussianKernel(5, 1.4)); rounded.GrayAt(9, 2).Y != quantize(blurred.FloatAt(9, 2)) {
+		t.Errorf("ApplyKernel gave %d, expected the rounded blur %v", rounded.GrayAt(9, 2).Y, blurred.FloatAt(9, 2))
+	}
+}
@@ -39,7 +39,8 @@ Applies a 2D convolution using a specified kernel (e.g., a Gaussian kernel) to a
 #### Behavior:
 - Iterates over the image pixels and calculates a weighted sum for each pixel based on the kernel.
 - Accounts for image boundaries by excluding out-of-bounds pixels during convolution.
-- Creates and returns a new grayscale image resulting from the convolution.
+- Creates and returns a new grayscale image resulting from the convolution, rounded to the nearest gray level (see
+  `ApplyKernelFloat`).
 
 #### Example Usage:
 ```go
@@ -50,6 +51,22 @@ blurredImg := ApplyKernel(img, kernel)   // Apply the kernel to blur the image
 
 ---
 
+### ApplyKernelFloat(img *FloatImage, kernel [][]float64) *FloatImage
+Same as `ApplyKernel` on a plane of floating point values, whose result is not rounded: the convolution done by the
+edge detection, whose gradient is computed on the exact blurred values.
+
+### ApplyKernelParallel(img *image.Gray, kernel [][]float64, workers int) *image.Gray
+Same as `ApplyKernel`, the rows of the result being computed by `workers` goroutines, in stripes (see
+`parallelRows`). The result is the same whatever the number of workers.
+
+### ApplyKernelFloatParallel(img *FloatImage, kernel [][]float64, workers int) *FloatImage
+Same as `ApplyKernelFloat`, computed by `workers` goroutines like `ApplyKernelParallel`.
+
+### applyKernelRows(img, output *FloatImage, kernel [][]float64, minY, maxY int)
+Writes the convolution of `img` by `kernel` into the rows of `output` from `minY` to `maxY` excluded.
+
+---
+
 ### Key Features:
 - **Dynamic Gaussian Kernel Generation**:
   - Easily create Gaussian kernels of various sizes to match specific filter requirements.
@@ -93,7 +110,6 @@ func main() {
 
 import (
 	"image"
-	"image/color"
 	"math"
 )
 
@@ -125,11 +141,30 @@ func GenerateGaussianKernel(size int, sigma float64) [][]float64 {
 }
 
 func ApplyKernel(img *image.Gray, kernel [][]float64) *image.Gray {
+	return ApplyKernelParallel(img, kernel, 1)
+}
+
+func ApplyKernelParallel(img *image.Gray, kernel [][]float64, workers int) *image.Gray {
+	return ApplyKernelFloatParallel(FloatFromGray(img), kernel, workers).Gray()
+}
+
+func ApplyKernelFloat(img *FloatImage, kernel [][]float64) *FloatImage {
+	return ApplyKernelFloatParallel(img, kernel, 1)
+}
+
+func ApplyKernelFloatParallel(img *FloatImage, kernel [][]float64, workers int) *FloatImage {
+	output := NewFloatImage(img.Bounds())
+	parallelRows(img.Bounds(), workers, func(minY, maxY int) {
+		applyKernelRows(img, output, kernel, minY, maxY)
+	})
+	return output
+}
+
+func applyKernelRows(img, output *FloatImage, kernel [][]float64, minY, maxY int) {
 	bounds := img.Bounds()
-	output := image.NewGray(bounds)
 	radius := len(kernel) / 2
 
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+	for y := minY; y < maxY; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
 			var sum float64
 			var weightSum float64
@@ -139,16 +174,14 @@ func ApplyKernel(img *image.Gray, kernel [][]float64) *image.Gray {
 					pixelX := x + kx
 					pixelY := y + ky
 					if pixelX >= bounds.Min.X && pixelX < bounds.Max.X && pixelY >= bounds.Min.Y && pixelY < bounds.Max.Y {
-						gray := float64(img.GrayAt(pixelX, pixelY).Y)
+						gray := float64(img.Pix[img.PixOffset(pixelX, pixelY)])
 						sum += gray * kernel[ky+radius][kx+radius]
 						weightSum += kernel[ky+radius][kx+radius]
 					}
 				}
 			}
 
-			output.SetGray(x, y, color.Gray{Y: uint8(sum / weightSum)})
+			output.Pix[output.PixOffset(x, y)] = float32(sum / weightSum)
 		}
 	}
-
-	return output
 }
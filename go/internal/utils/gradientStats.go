@@ -0,0 +1,153 @@
+package utils
+
+/*
+Package utils provides the statistics of the gradient the thresholds of the Canny edge detection are computed from:
+the histogram of the gradient magnitudes, split by Otsu's method. The mean gradient is pulled up by a few strong
+borders and down by a wide plain background, so a fixed multiple of it keeps the noise of a clean scan and loses the
+fainter borders of a photo. Most pixels of a page are flat, their magnitudes a
+mass near 0, and those of the borders a long tail: the Otsu threshold falls between both whatever their share of the
+image. The histogram is taken from the gradients the edge detection computes anyway, with no Sobel pass of its own.
+
+When the image is split into chunks, every chunk has its own thresholds, and a band of plain background gets edges
+from its noise where a band of text next to it loses its fainter edges. The histograms of the chunks can instead be
+measured first, summed, and the same thresholds applied to every chunk (see `DetectEdges`).
+
+---
+
+### Constants
+- `gradientBinsPerLevel`, `gradientBins`: The histogram has bins of a quarter of a gray level, from 0 to 256: the
+  thresholds of a soft image are a few gray levels only.
+- `lowThresholdRatio`: The low threshold of the hysteresis, as a fraction of the high one.
+
+### GradientStats
+Histogram of the gradient magnitudes of a set of pixels.
+
+- **Fields**:
+  - `Histogram`: Number of pixels per bin of magnitude, the magnitudes being those of the 3x3 Sobel gradient, from 0
+    to 255 per pixel. The counts are whole numbers, so the statistics of several chunks add up exactly, in any order.
+  - `Count`: Number of pixels.
+
+- **Methods**:
+  - `Add(other GradientStats) GradientStats`: Returns the statistics of both sets of pixels.
+  - `Otsu() float64`: Returns the magnitude splitting the pixels into the two classes of the largest between-class
+    variance, the upper bound of the last bin of the lower class.
+  - `Thresholds(scale float64) (float64, float64)`: Returns the low and high thresholds of the pixels: `scale` times
+    their Otsu threshold for the high one, `lowThresholdRatio` of it for the low one. Both are infinite if there is
+    no pixel, so no pixel is an edge.
+
+### MeasureGradient(img *FloatImage, rows image.Rectangle) *SmoothedImage
+Computes the gradients of `img`, their magnitudes and directions, and returns them with the statistics of the pixels
+within `rows`, except those of the border of `img`, whose gradient is not computed. The gradient is computed on the
+whole of `img`, so a chunk measured on the rows it owns, without the rows it overlaps its neighbors on, sees the same
+gradient as the whole image there: the statistics of the chunks add up to those of the image.
+
+### measureGradient(img *FloatImage, rows image.Rectangle, workers int) *SmoothedImage
+Same as `MeasureGradient`, the gradients being computed by `workers` goroutines (see `ApplySobelFloatParallel`), for
+`DetectEdgesSmoothed` with the `Workers` of its `CannyParameters`.
+
+### SmoothedImage
+Chunk smoothed before its edges are detected, with its gradients and their statistics, so `DetectEdges` does not
+compute them again. Embedding the `*FloatImage` of the chunk, a `SmoothedImage` is an `image.Image` itself, and goes
+through the image tasks of the workers.
+
+- **Fields**:
+  - `FloatImage`: The chunk smoothed by `CannyParameters.Smooth`.
+  - `Magnitude`, `Angles`: The magnitudes and directions of its gradients, in degrees (see `ApplySobelFloat`).
+  - `Gradient`: The statistics of the rows it owns, see `MeasureGradient`.
+
+---
+
+### Example Usage:
+```go
+var stats utils.GradientStats
+measured := make([]*utils.SmoothedImage, len(smoothed))
+for i, chunk := range smoothed {
+	measured[i] = utils.MeasureGradient(chunk, rows[i])
+	stats = stats.Add(measured[i].Gradient)
+}
+low, high := stats.Thresholds(utils.DefaultCannyParameters.ThresholdScale)
+edges, _ := utils.DetectEdges(measured[0], low, high, utils.DefaultCannyParameters)
+```
+*/
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	gradientBinsPerLevel = 4
+	gradientBins         = 256 * gradientBinsPerLevel
+	lowThresholdRatio    = 0.4
+)
+
+type GradientStats struct {
+	Histogram [gradientBins]int
+	Count     int
+}
+
+type SmoothedImage struct {
+	*FloatImage
+	Magnitude *FloatImage
+	Angles    *FloatImage
+	Gradient  GradientStats
+}
+
+func (stats GradientStats) Add(other GradientStats) GradientStats {
+	for i, count := range other.Histogram {
+		stats.Histogram[i] += count
+	}
+	stats.Count += other.Count
+	return stats
+}
+
+func (stats GradientStats) Otsu() float64 {
+	var total float64
+	for i, count := range stats.Histogram {
+		total += float64(i) * float64(count)
+	}
+
+	var background, backgroundTotal, bestVariance float64
+	best := 0
+	for i, count := range stats.Histogram {
+		background += float64(count)
+		backgroundTotal += float64(i) * float64(count)
+		foreground := float64(stats.Count) - background
+		if background == 0 || foreground == 0 {
+			continue
+		}
+		difference := backgroundTotal/background - (total-backgroundTotal)/foreground
+		if variance := background * foreground * difference * difference; variance > bestVariance {
+			best, bestVariance = i, variance
+		}
+	}
+	return float64(best+1) / gradientBinsPerLevel
+}
+
+func (stats GradientStats) Thresholds(scale float64) (float64, float64) {
+	if stats.Count == 0 {
+		return math.Inf(1), math.Inf(1)
+	}
+	highThreshold := scale * stats.Otsu()
+	return lowThresholdRatio * highThreshold, highThreshold
+}
+
+func MeasureGradient(img *FloatImage, rows image.Rectangle) *SmoothedImage {
+	return measureGradient(img, rows, 1)
+}
+
+func measureGradient(img *FloatImage, rows image.Rectangle, workers int) *SmoothedImage {
+	sobelX, sobelY := SobelKernels(sobelKernelSize)
+	magnitude, angles := ApplySobelFloatParallel(img, sobelX, sobelY, workers)
+
+	smoothed := &SmoothedImage{FloatImage: img, Magnitude: magnitude, Angles: angles}
+	interior := img.Bounds().Inset(1).Intersect(rows)
+	for y := interior.Min.Y; y < interior.Max.Y; y++ {
+		for x := interior.Min.X; x < interior.Max.X; x++ {
+			bin := int(magnitude.Pix[magnitude.PixOffset(x, y)] * gradientBinsPerLevel)
+			smoothed.Gradient.Histogram[min(bin, gradientBins-1)]++
+			smoothed.Gradient.Count++
+		}
+	}
+	return smoothed
+}
@@ -0,0 +1,63 @@
+package utils
+
+/*
+This file tests the statistics of the gradient measured on the chunks of a page, against those of the whole page,
+the edge detection with given thresholds, against `ApplyCannyEdgeMap`, and the Otsu threshold of a histogram.
+*/
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestGradientStats(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 300, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 300; x++ {
+			value := uint8(50 + (x*7+y*13)%9)
+			if x >= 40 && x < 260 && y >= 50 && y < 350 {
+				value = 230
+				if (y-70)%30 < 4 && x >= 60 && x < 240 && (x-60)%25 < 18 {
+					value = 20
+				}
+			}
+			gray.SetGray(x, y, color.Gray{Y: value})
+		}
+	}
+	blurred := DefaultCannyParameters.Smooth(gray)
+	whole := MeasureGradient(blurred, blurred.Bounds())
+
+	var chunks GradientStats
+	for startY := 0; startY < 400; startY += 100 {
+		chunk := blurred.SubImage(image.Rect(0, max(startY-20, 0), 300, min(startY+120, 400)))
+		chunks = chunks.Add(MeasureGradient(chunk, image.Rect(0, startY, 300, startY+100)).Gradient)
+	}
+	if chunks != whole.Gradient {
+		t.Fatal("chunks measured another histogram than the whole image")
+	}
+
+	low, high := whole.Gradient.Thresholds(DefaultCannyParameters.ThresholdScale)
+	detected, _ := DetectEdges(whole, low, high, DefaultCannyParameters)
+	expected, _ := ApplyCannyEdgeMap(gray, DefaultCannyParameters)
+	if !reflect.DeepEqual(detected.Pix, expected.Pix) || !reflect.DeepEqual(detected.Edges, expected.Edges) {
+		t.Fatal("edges detected with the thresholds of the image differ from those of ApplyCannyEdgeMap")
+	}
+
+	if low, high := (GradientStats{}).Thresholds(1); !math.IsInf(low, 1) || !math.IsInf(high, 1) {
+		t.Fatalf("thresholds of no pixel %v and %v, expected infinite", low, high)
+	}
+
+	// A flat background and fewer, much stronger borders, whatever their share of the pixels.
+	for _, borders := range []int{10, 100, 1000} {
+		var stats GradientStats
+		stats.Histogram[2*gradientBinsPerLevel] = 10000
+		stats.Histogram[120*gradientBinsPerLevel] = borders
+		stats.Count = 10000 + borders
+		if otsu := stats.Otsu(); otsu <= 2 || otsu > 120 {
+			t.Errorf("Otsu threshold %v with %d border pixels, expected between the background and the borders", otsu, borders)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package utils
+
+/*
+Package utils provides the grayscale conversion of a color image fused with its Gaussian blur. Converted first, a
+chunk of a large photo is written whole as an `*image.Gray`, copied whole into a plane of floats, then read back by a
+blur visiting every pixel once per weight of its 2D kernel: three passes over the chunk, none of them fitting in the
+caches. The Gaussian kernel is separable, so the blur is instead a horizontal pass over
+every row, converted to gray as it is read from the source, then a vertical pass over the last rows blurred, kept in
+a ring of as many rows as the kernel: a single pass over the source, and no intermediate image.
+
+---
+
+### GrayscaleBlur(img *image.RGBA, kernel [][]float64) *FloatImage
+Returns `img` converted to gray, as `imageUtils.Grayscale` does (its gray levels truncated), and blurred by `kernel`,
+a 2D Gaussian kernel of `GenerateGaussianKernel`: the same values as `ApplyKernelFloat` on the grayscale image, up to
+rounding errors.
+
+- **Behavior**:
+  - The weights of the horizontal and vertical passes are the middle row of `kernel`, normalized: a Gaussian kernel
+    is the product of that row by itself.
+  - The pixels outside `img` are ignored, like in `ApplyKernelFloat`: the pixels near the border are divided by the
+    sum of the weights of the pixels inside, which is the product of those of the two passes.
+  - The pixels of the source row are converted once, before the horizontal pass, into a row of gray levels.
+
+### (parameters CannyParameters) SmoothRGBA(img *image.RGBA) *FloatImage
+Returns `img` converted to gray and smoothed as `Smooth` does, by `GrayscaleBlur` for the Gaussian blur. The bilateral filter weights the neighbors by their gray
+levels, so it needs the grayscale image first.
+
+### gaussianWeights(kernel [][]float64) []float64
+Returns the 1D weights of the separable 2D `kernel`, summing to 1.
+
+---
+
+### Example Usage:
+```go
+blurred := utils.DefaultCannyParameters.SmoothRGBA(chunk)
+smoothed := utils.MeasureGradient(blurred, blurred.Bounds())
+low, high := smoothed.Gradient.Thresholds(utils.DefaultCannyParameters.ThresholdScale)
+edges, _ := utils.DetectEdges(smoothed, low, high, utils.DefaultCannyParameters)
+```
+*/
+
+import (
+	"ELP-project/internal/imageUtils"
+	"image"
+)
+
+func (parameters CannyParameters) SmoothRGBA(img *image.RGBA) *FloatImage {
+	if parameters.BilateralSigma > 0 {
+		return parameters.Smooth(imageUtils.Grayscale(img))
+	}
+	return GrayscaleBlur(img, GaussianKernel(parameters.BlurKernelSize, parameters.BlurSigma))
+}
+
+func GrayscaleBlur(img *image.RGBA, kernel [][]float64) *FloatImage {
+	bounds := img.Bounds()
+	output := NewFloatImage(bounds)
+	if bounds.Empty() {
+		return output
+	}
+	weights := gaussianWeights(kernel)
+	radius := len(weights) / 2
+	width := bounds.Dx()
+
+	gray := make([]float64, width)
+	sums := make([]float64, width)
+	ring := make([][]float64, len(weights))
+	for i := range ring {
+		ring[i] = make([]float64, width)
+	}
+
+	blurRow := func(y int) {
+		source := img.Pix[img.PixOffset(bounds.Min.X, y):img.PixOffset(bounds.Max.X, y)]
+		for x := range gray {
+			pixel := source[4*x : 4*x+3]
+			gray[x] = float64(uint8(0.299*float64(pixel[0]) + 0.587*float64(pixel[1]) + 0.114*float64(pixel[2])))
+		}
+
+		row := ring[(y-bounds.Min.Y)%len(ring)]
+		for x := range row {
+			var sum, weightSum float64
+			if x >= radius && x+radius < width {
+				for k, weight := range weights {
+					sum += weight * gray[x+k-radius]
+				}
+				row[x] = sum
+				continue
+			}
+			for k, weight := range weights {
+				if column := x + k - radius; column >= 0 && column < width {
+					sum += weight * gray[column]
+					weightSum += weight
+				}
+			}
+			row[x] = sum / weightSum
+		}
+	}
+
+	blurred := bounds.Min.Y
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for ; blurred < bounds.Max.Y && blurred <= y+radius; blurred++ {
+			blurRow(blurred)
+		}
+
+		clear(sums)
+		var weightSum float64
+		for j := max(y-radius, bounds.Min.Y); j <= min(y+radius, bounds.Max.Y-1); j++ {
+			weight := weights[j-y+radius]
+			for x, value := range ring[(j-bounds.Min.Y)%len(ring)] {
+				sums[x] += weight * value
+			}
+			weightSum += weight
+		}
+		row := output.Pix[output.PixOffset(bounds.Min.X, y):output.PixOffset(bounds.Max.X, y)]
+		for x, sum := range sums {
+			row[x] = float32(sum / weightSum)
+		}
+	}
+
+	return output
+}
+
+func gaussianWeights(kernel [][]float64) []float64 {
+	middle := kernel[len(kernel)/2]
+	var sum float64
+	for _, weight := range middle {
+		sum += weight
+	}
+	weights := make([]float64, len(middle))
+	for i, weight := range middle {
+		weights[i] = weight / sum
+	}
+	return weights
+}
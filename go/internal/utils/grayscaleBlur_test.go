@@ -0,0 +1,47 @@
+package utils
+
+/*
+This file tests the fused grayscale conversion and blur against the conversion followed by the 2D blur, on a whole
+photo and on a chunk of it.
+*/
+
+import (
+	"ELP-project/internal/imageUtils"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestGrayscaleBlur(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 120, 90))
+	for y := 0; y < 90; y++ {
+		for x := 0; x < 120; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x * 2), G: uint8((x*7 + y*13) % 251), B: uint8(y * 3), A: 255})
+		}
+	}
+
+	for _, size := range []int{3, 5, 7} {
+		kernel := GenerateGaussianKernel(size, 1.4)
+		for _, chunk := range []*image.RGBA{img, img.SubImage(image.Rect(0, 30, 120, 70)).(*image.RGBA)} {
+			expected := ApplyKernelFloat(FloatFromGray(imageUtils.Grayscale(chunk)), kernel)
+			got := GrayscaleBlur(chunk, kernel)
+			if got.Bounds() != chunk.Bounds() {
+				t.Fatalf("size %d: blurred %v, expected %v", size, got.Bounds(), chunk.Bounds())
+			}
+			for i := range expected.Pix {
+				if math.Abs(float64(got.Pix[i]-expected.Pix[i])) > 1e-3 {
+					t.Fatalf("size %d, chunk %v: pixel %d is %g, expected %g", size, chunk.Bounds(), i, got.Pix[i], expected.Pix[i])
+				}
+			}
+		}
+	}
+
+	bilateral := CannyParameters{BlurKernelSize: 5, BlurSigma: 1.4, BilateralSigma: 25}
+	expected := bilateral.Smooth(imageUtils.Grayscale(img))
+	for i, value := range bilateral.SmoothRGBA(img).Pix {
+		if value != expected.Pix[i] {
+			t.Fatalf("bilateral pixel %d is %g, expected %g", i, value, expected.Pix[i])
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package utils
+
+/*
+Package utils provides the histograms of the Canny edge detection, showing why its thresholds landed where they did.
+
+---
+
+### RenderHistograms(img *image.Gray, parameters CannyParameters) *image.RGBA
+Renders the histograms of a grayscale image as one chart, for the `histograms` artifact and the debug bundles.
+
+- **Parameters**:
+  - img: The grayscale image given to `ApplyCannyEdgeDetectionWith`.
+  - parameters: The parameters of the edge detection, e.g. `DefaultCannyParameters`.
+
+- **Returns**:
+  - The luminance histogram of `img`, with its mean, above the histogram of the gradient magnitudes, with the low and
+    high thresholds of the hysteresis.
+
+- **Behavior**:
+  - Blurs the image and computes its gradients and thresholds as `ApplyCannyEdgeDetectionWith` does, on the whole
+    image. The server runs the edge detection on chunks of the image, each with thresholds of its own: those vary
+    around the thresholds of the whole image.
+  - The gradient histogram is the one hysteresis thresholding sees, before Non-Maximum Suppression: the thresholds
+    are expected between the mass of the flat areas, near 0, and the tail of the edges.
+
+---
+
+### Example Usage:
+```go
+gray := imageUtils.Grayscale(img)
+imageUtils.SaveImage(utils.RenderHistograms(gray, utils.DefaultCannyParameters), "histograms.png", "png")
+```
+*/
+
+import (
+	"ELP-project/internal/imageUtils"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+func RenderHistograms(img *image.Gray, parameters CannyParameters) *image.RGBA {
+	luminance := imageUtils.GrayHistogram(img)
+	mean := luminance.Mean()
+
+	blurred := parameters.Smooth(img)
+	smoothed := MeasureGradient(blurred, blurred.Bounds())
+	lowThreshold, highThreshold := smoothed.Gradient.Thresholds(parameters.ThresholdScale)
+
+	return imageUtils.StackImages(
+		imageUtils.DrawHistogram(luminance, "LUMINANCE", []imageUtils.Marker{
+			{Value: mean, Label: fmt.Sprintf("MEAN %.1f", mean), Color: color.RGBA{R: 16, G: 96, B: 200, A: 255}},
+		}),
+		imageUtils.DrawHistogram(imageUtils.GrayHistogram(smoothed.Magnitude.Gray()), "GRADIENT", []imageUtils.Marker{
+			{Value: lowThreshold, Label: fmt.Sprintf("LOW %.1f", lowThreshold), Color: color.RGBA{R: 224, G: 128, A: 255}},
+			{Value: highThreshold, Label: fmt.Sprintf("HIGH %.1f", highThreshold), Color: color.RGBA{R: 200, G: 16, B: 16, A: 255}},
+		}),
+	)
+}
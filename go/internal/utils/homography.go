@@ -0,0 +1,222 @@
+package utils
+
+/*
+Package utils provides the perspective transform straightening a document photographed at an angle: the homography
+mapping its four corners to the corners of an upright rectangle, and the warp resampling the photo through it.
+
+---
+
+### Point2f
+A point with floating-point coordinates, e.g. a corner of a document or of the rectangle it is warped to.
+
+---
+
+### ComputeHomographyMatrix(source, destination [4]Point2f) [3][3]float64
+Returns the homography mapping each of the four `source` points to the `destination` point of the same index, with
+its bottom-right coefficient set to 1.
+
+- **Behavior**:
+  - Solves the eight linear equations of the direct linear transform (two per pair of points) by Gaussian
+    elimination with partial pivoting.
+  - Degenerate points, e.g. three of them aligned, give no homography: the identity is returned.
+
+### ApplyPerspectiveTransform(img image.Image, homography [3][3]float64, width, height int) *image.RGBA
+Warps `img` through `homography` into an image of `width` x `height` pixels, whose bounds start at (0, 0): the
+homography maps the coordinates of `img` to those of the result, e.g. the one computed from the corners of a
+document to the corners of the result.
+
+- **Behavior**:
+  - Maps every pixel of the result back into `img` through the inverse of the homography, and interpolates its color
+    bilinearly there (see `imageUtils.Sample`), so the result has no holes.
+  - An empty image is returned if the source image or the requested size is empty, or if the homography cannot be
+    inverted.
+
+### WarpQuadrilateral(img image.Image, corners geometry.Contour) *image.RGBA
+Straightens the quadrilateral of the top-left, top-right, bottom-right and bottom-left `corners` of a document into
+an upright image: the result is as wide as the longer of the top and bottom sides, and as high as the longer of the
+left and right sides, so the document loses no resolution. Returns an empty image if `corners` does not have four
+points.
+
+### WarpToPage(img image.Image, corners geometry.Contour, page geometry.PageSize) *image.RGBA
+Straightens the quadrilateral of `corners` like `WarpQuadrilateral`, but into a rectangle of the proportions of
+`page`, e.g. 1:√2 for A4, so a document photographed at a steep angle does not come out squashed: the longer side of
+the result is the longer side of the quadrilateral, and the result is in landscape when the quadrilateral is wider
+than high. Returns an empty image if `corners` does not have four points.
+
+### PageQuadrilateralSize(corners geometry.Contour, page geometry.PageSize) (int, int)
+Returns the size of the image `WarpToPage` gives for `corners` and `page`, (0, 0) if it does not have four points.
+
+### WarpToRectangle(img image.Image, corners geometry.Contour, width, height int) *image.RGBA
+Warps the quadrilateral of the top-left, top-right, bottom-right and bottom-left `corners` onto the whole of an image
+of `width` x `height` pixels, e.g. the size of an ID card at a given resolution whatever the size of the photo.
+Returns an empty image if `corners` does not have four points or the size is empty.
+
+### QuadrilateralSize(corners geometry.Contour) (int, int)
+Returns the size of the image `WarpQuadrilateral` gives for `corners`, (0, 0) if it does not have four points.
+
+### invert3(matrix [3][3]float64) ([3][3]float64, bool)
+Inverts a 3x3 matrix by its adjugate, and reports whether it is invertible.
+
+---
+
+### Example Usage:
+```go
+corners := utils.FitQuadrilateral(document.Contour).Contour
+straight := utils.WarpQuadrilateral(img, corners)
+
+a4, _ := geometry.ParsePageSize("A4")
+page := utils.WarpToPage(img, corners, a4)
+```
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"ELP-project/internal/imageUtils"
+	"image"
+	"image/draw"
+	"math"
+)
+
+const singularPivot = 1e-12
+
+type Point2f struct {
+	X, Y float64
+}
+
+func ComputeHomographyMatrix(source, destination [4]Point2f) [3][3]float64 {
+	var system [8][9]float64
+	for i := range source {
+		x, y := source[i].X, source[i].Y
+		u, v := destination[i].X, destination[i].Y
+		system[2*i] = [9]float64{x, y, 1, 0, 0, 0, -u * x, -u * y, u}
+		system[2*i+1] = [9]float64{0, 0, 0, x, y, 1, -v * x, -v * y, v}
+	}
+
+	for column := 0; column < 8; column++ {
+		pivot := column
+		for row := column + 1; row < 8; row++ {
+			if math.Abs(system[row][column]) > math.Abs(system[pivot][column]) {
+				pivot = row
+			}
+		}
+		if math.Abs(system[pivot][column]) < singularPivot {
+			return [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+		}
+		system[column], system[pivot] = system[pivot], system[column]
+
+		for row := 0; row < 8; row++ {
+			if row == column {
+				continue
+			}
+			factor := system[row][column] / system[column][column]
+			for k := column; k < 9; k++ {
+				system[row][k] -= factor * system[column][k]
+			}
+		}
+	}
+
+	var coefficients [9]float64
+	for i := 0; i < 8; i++ {
+		coefficients[i] = system[i][8] / system[i][i]
+	}
+	coefficients[8] = 1
+	return [3][3]float64{
+		{coefficients[0], coefficients[1], coefficients[2]},
+		{coefficients[3], coefficients[4], coefficients[5]},
+		{coefficients[6], coefficients[7], coefficients[8]},
+	}
+}
+
+func ApplyPerspectiveTransform(img image.Image, homography [3][3]float64, width, height int) *image.RGBA {
+	output := image.NewRGBA(image.Rect(0, 0, max(width, 0), max(height, 0)))
+
+	bounds := img.Bounds()
+	inverse, ok := invert3(homography)
+	if bounds.Empty() || width <= 0 || height <= 0 || !ok {
+		return output
+	}
+
+	source, isRGBA := img.(*image.RGBA)
+	if !isRGBA {
+		source = image.NewRGBA(bounds)
+		draw.Draw(source, bounds, img, bounds.Min, draw.Src)
+	}
+
+	for y := 0; y < height; y++ {
+		row := output.Pix[output.PixOffset(0, y):output.PixOffset(width, y)]
+		for x := 0; x < width; x++ {
+			u, v := float64(x), float64(y)
+			w := inverse[2][0]*u + inverse[2][1]*v + inverse[2][2]
+			sourceX := (inverse[0][0]*u + inverse[0][1]*v + inverse[0][2]) / w
+			sourceY := (inverse[1][0]*u + inverse[1][1]*v + inverse[1][2]) / w
+			imageUtils.Sample(source, sourceX, sourceY, imageUtils.Bilinear, row[4*x:4*x+4])
+		}
+	}
+
+	return output
+}
+
+func WarpQuadrilateral(img image.Image, corners geometry.Contour) *image.RGBA {
+	width, height := QuadrilateralSize(corners)
+	return WarpToRectangle(img, corners, width, height)
+}
+
+func WarpToPage(img image.Image, corners geometry.Contour, page geometry.PageSize) *image.RGBA {
+	width, height := PageQuadrilateralSize(corners, page)
+	return WarpToRectangle(img, corners, width, height)
+}
+
+func PageQuadrilateralSize(corners geometry.Contour, page geometry.PageSize) (int, int) {
+	width, height := QuadrilateralSize(corners)
+	if width == 0 || height == 0 || page.Width <= 0 || page.Height <= 0 {
+		return 0, 0
+	}
+
+	long := max(width, height)
+	short := max(int(math.Round(float64(long)*min(page.Width, page.Height)/max(page.Width, page.Height))), 1)
+	if width > height {
+		return long, short
+	}
+	return short, long
+}
+
+func WarpToRectangle(img image.Image, corners geometry.Contour, width, height int) *image.RGBA {
+	if len(corners) != 4 || width <= 0 || height <= 0 {
+		return image.NewRGBA(image.Rectangle{})
+	}
+
+	var source [4]Point2f
+	for i, corner := range corners {
+		source[i] = Point2f{X: float64(corner.X), Y: float64(corner.Y)}
+	}
+	destination := [4]Point2f{{0, 0}, {float64(width - 1), 0}, {float64(width - 1), float64(height - 1)}, {0, float64(height - 1)}}
+	return ApplyPerspectiveTransform(img, ComputeHomographyMatrix(source, destination), width, height)
+}
+
+func QuadrilateralSize(corners geometry.Contour) (int, int) {
+	if len(corners) != 4 {
+		return 0, 0
+	}
+	side := func(a, b geometry.Point) float64 {
+		return math.Hypot(float64(b.X-a.X), float64(b.Y-a.Y))
+	}
+	width := max(side(corners[0], corners[1]), side(corners[3], corners[2]))
+	height := max(side(corners[0], corners[3]), side(corners[1], corners[2]))
+	return int(math.Round(width)) + 1, int(math.Round(height)) + 1
+}
+
+func invert3(matrix [3][3]float64) ([3][3]float64, bool) {
+	m := matrix
+	determinant := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if math.Abs(determinant) < singularPivot {
+		return [3][3]float64{}, false
+	}
+
+	return [3][3]float64{
+		{(m[1][1]*m[2][2] - m[1][2]*m[2][1]) / determinant, (m[0][2]*m[2][1] - m[0][1]*m[2][2]) / determinant, (m[0][1]*m[1][2] - m[0][2]*m[1][1]) / determinant},
+		{(m[1][2]*m[2][0] - m[1][0]*m[2][2]) / determinant, (m[0][0]*m[2][2] - m[0][2]*m[2][0]) / determinant, (m[0][2]*m[1][0] - m[0][0]*m[1][2]) / determinant},
+		{(m[1][0]*m[2][1] - m[1][1]*m[2][0]) / determinant, (m[0][1]*m[2][0] - m[0][0]*m[2][1]) / determinant, (m[0][0]*m[1][1] - m[0][1]*m[1][0]) / determinant},
+	}, true
+}
@@ -0,0 +1,94 @@
+package utils
+
+/*
+This file tests the perspective transform: the homography solved from four pairs of points, the warp through it, and
+the size of a document straightened to the proportions of a page.
+
+---
+
+### contour(coordinates ...int) geometry.Contour
+Returns the contour of the points whose x and y coordinates follow each other in `coordinates`.
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func contour(coordinates ...int) geometry.Contour {
+	points := make(geometry.Contour, 0, len(coordinates)/2)
+	for i := 0; i+1 < len(coordinates); i += 2 {
+		points = append(points, geometry.Point{X: coordinates[i], Y: coordinates[i+1]})
+	}
+	return points
+}
+
+func TestComputeHomographyMatrix(t *testing.T) {
+	source := [4]Point2f{{120, 80}, {900, 140}, {860, 700}, {60, 620}}
+	destination := [4]Point2f{{0, 0}, {2099, 0}, {2099, 2969}, {0, 2969}}
+
+	homography := ComputeHomographyMatrix(source, destination)
+	for i, point := range source {
+		w := homography[2][0]*point.X + homography[2][1]*point.Y + homography[2][2]
+		x := (homography[0][0]*point.X + homography[0][1]*point.Y + homography[0][2]) / w
+		y := (homography[1][0]*point.X + homography[1][1]*point.Y + homography[1][2]) / w
+		if math.Abs(x-destination[i].X) > 1e-6 || math.Abs(y-destination[i].Y) > 1e-6 {
+			t.Errorf("%+v mapped to (%g, %g), expected %+v", point, x, y, destination[i])
+		}
+	}
+
+	aligned := [4]Point2f{{0, 0}, {10, 10}, {20, 20}, {30, 30}}
+	if identity := ComputeHomographyMatrix(aligned, destination); identity != [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}} {
+		t.Errorf("aligned points gave %v, expected the identity", identity)
+	}
+}
+
+func TestApplyPerspectiveTransform(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 6), G: uint8(y * 6), A: 255})
+		}
+	}
+
+	// Half of the image, scaled twice: every pixel of the result comes from the image at half its coordinates.
+	homography := [3][3]float64{{2, 0, 0}, {0, 2, 0}, {0, 0, 1}}
+	warped := ApplyPerspectiveTransform(img, homography, 40, 40)
+	for _, point := range []image.Point{{0, 0}, {10, 20}, {38, 38}, {7, 13}} {
+		expected := color.RGBA{R: uint8(math.Round(float64(point.X) * 3)), G: uint8(math.Round(float64(point.Y) * 3)), A: 255}
+		if got := warped.RGBAAt(point.X, point.Y); got != expected {
+			t.Errorf("pixel %v is %v, expected %v", point, got, expected)
+		}
+	}
+
+	if empty := ApplyPerspectiveTransform(img, [3][3]float64{}, 40, 40); empty.Pix[3] != 0 {
+		t.Error("a singular homography gave pixels, expected an empty image")
+	}
+}
+
+func TestPageQuadrilateralSize(t *testing.T) {
+	a4, err := geometry.ParsePageSize("A4")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		corners       geometry.Contour
+		width, height int
+	}{
+		{"portrait", contour(0, 0, 399, 0, 399, 699, 0, 699), 495, 700},
+		{"landscape", contour(0, 0, 699, 0, 699, 399, 0, 399), 700, 495},
+		{"trapezoid", contour(100, 0, 300, 0, 400, 200, 0, 200), 401, 284},
+		{"not a quadrilateral", contour(0, 0, 10, 0, 10, 10), 0, 0},
+	}
+	for _, test := range tests {
+		width, height := PageQuadrilateralSize(test.corners, a4)
+		if width != test.width || height != test.height {
+			t.Errorf("%s: %dx%d, expected %dx%d", test.name, width, height, test.width, test.height)
+		}
+	}
+}
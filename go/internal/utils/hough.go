@@ -0,0 +1,324 @@
+package utils
+
+/*
+Package utils provides the Hough transform of an edge map, which finds the borders of a document as straight lines
+instead of following its contour. Every edge pixel votes for all the lines through it, so a border keeps the votes
+of its visible parts when a hand, a clip or another sheet hides the rest of it: the four lines still meet at the
+corners of the page, where the contour found by `FindContoursBFS` would stop at the occluding object.
+
+---
+
+### HoughLine
+Straight line of the points (x, y) such that `x*cos(Theta) + y*sin(Theta) = Rho`, in the coordinates of the image.
+
+- **Fields**:
+  - `Rho`: Signed distance of the line to the origin of the image, in pixels.
+  - `Theta`: Angle of the normal of the line, in radians, from 0 included to π excluded: a horizontal line has a
+    `Theta` of π/2, a vertical line of 0.
+  - `Votes`: Number of edge pixels on the line.
+
+- **Methods**:
+  - `same(other HoughLine) bool`: Tells whether two lines are within `houghAngleWindow` and `houghDistanceWindow`
+    of each other, the angles close to π being close to 0 as well.
+  - `horizontal() bool`: Tells whether the line is closer to horizontal than to vertical.
+  - `position(bounds image.Rectangle) float64`: Returns the ordinate of a horizontal line at the center of `bounds`,
+    or the abscissa of a vertical one, to sort parallel lines and measure their distance.
+  - `intersect(other HoughLine) (geometry.Point, bool)`: Returns the point where two lines cross, false if they are
+    parallel.
+  - `distance(point geometry.Point) float64`: Returns the distance of `point` to the line, in pixels.
+
+---
+
+### HoughLines(edges *image.Gray, count int) []HoughLine
+Returns the `count` strongest distinct lines of the edge map `edges`, by decreasing number of votes.
+
+- **Behavior**:
+  - The non-zero pixels of `edges` vote in an accumulator of `houghAngles` angles by one pixel of distance. The
+    sines and cosines of the angles are computed once, in fixed point of `houghShift` bits.
+  - The local maxima of the accumulator with at least `houghMinVotes` of the smaller side of the image are the
+    candidate lines. A candidate within `houghAngleWindow` and `houghDistanceWindow` of a stronger line accepted
+    already is the same border, blurred or slightly bent, and is skipped.
+
+### houghPeak(accumulator []int32, distances, angle, rho int) bool
+Tells whether a cell of the accumulator is a local maximum among its 8 neighbors. Of a plateau of equal cells, only
+the first one is.
+
+### FindHoughQuadrilateral(edges *image.Gray) geometry.ContourWithArea
+Finds the document of the edge map `edges` as the quadrilateral of its dominant lines: two horizontal ones and two
+vertical ones, whose intersections are its corners, returned in the order top-left, top-right, bottom-right,
+bottom-left, with the area of the quadrilateral.
+
+- **Behavior**:
+  - Among the `houghCandidates` strongest lines, a pair of each orientation is kept, whose lines are at most
+    `houghMaxSkew` from parallel and at least `houghMinSide` of the image apart: the pair of the highest product of
+    its votes and its distance. The borders of a page are its outermost lines, so a partly hidden border still wins
+    over the lines of text between the borders, about as long as it.
+  - The corners may lie slightly outside the image, e.g. a corner of the page cut by the frame: those within
+    `houghOutside` of the image are moved onto its border.
+  - Returns an empty `geometry.ContourWithArea` if no such quadrilateral is found, e.g. for an image without
+    straight edges, if a corner lies farther outside the image, or if the quadrilateral is not convex.
+
+### houghSides(lines []HoughLine, bounds image.Rectangle, side float64) (HoughLine, HoughLine, bool)
+Returns the pair of `lines` which can be opposite sides of a document whose side is `side` pixels long in their
+direction of the highest score, sorted by position, false if there is none.
+
+### convex(corners geometry.Contour) bool
+Tells whether the polygon of `corners` is strictly convex.
+
+---
+
+### Constants
+- `houghAngles`: Number of angles of the accumulator, four per degree: at one per degree, the votes of a border of a
+  thousand pixels tilted between two angles spread over several distances, and the lines of text win.
+- `houghShift`, `houghScale`: Precision of the fixed point sines and cosines.
+- `houghMinVotes`: Fraction of the smaller side of the image a line must be supported by.
+- `houghAngleWindow`, `houghDistanceWindow`: Distances in angle (in steps of the accumulator, 3°) and in pixels
+  under which two lines are the same.
+- `houghCandidates`: Number of lines the sides of the document are chosen among: enough for a page of text, whose
+  lines may get as many votes as a partly hidden border.
+- `houghMaxSkew`: Largest angle between opposite sides of the document, in radians: about 20°, for a page
+  photographed in perspective.
+- `houghMinSide`: Fraction of the side of the image opposite sides of the document are at least apart.
+- `houghOutside`: Fraction of the side of the image a corner may lie outside of it.
+
+---
+
+### Example Usage:
+```go
+edges := utils.ApplyCannyEdgeDetection(gray)
+if document := utils.FindHoughQuadrilateral(edges); document.Area > 0 {
+	cropped := utils.WarpQuadrilateral(img, document.Contour)
+}
+```
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"image"
+	"math"
+	"slices"
+)
+
+const (
+	houghAngles         = 720
+	houghMinVotes       = 0.125
+	houghAngleWindow    = 12
+	houghDistanceWindow = 10
+	houghCandidates     = 64
+	houghMaxSkew        = 0.35
+	houghMinSide        = 0.2
+	houghOutside        = 0.03
+
+	houghShift = 16
+	houghScale = 1 << houghShift
+)
+
+type HoughLine struct {
+	Rho   float64
+	Theta float64
+	Votes int
+}
+
+func HoughLines(edges *image.Gray, count int) []HoughLine {
+	bounds := edges.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 || count <= 0 {
+		return nil
+	}
+
+	// The sines and cosines are in fixed point, so a vote costs two integer multiplications.
+	var cosines, sines [houghAngles]int
+	for angle := range houghAngles {
+		theta := float64(angle) * math.Pi / houghAngles
+		cosines[angle] = int(math.Round(math.Cos(theta) * houghScale))
+		sines[angle] = int(math.Round(math.Sin(theta) * houghScale))
+	}
+	maxRho := int(math.Ceil(math.Hypot(float64(width), float64(height))))
+	distances := 2*maxRho + 1
+	accumulator := make([]int32, houghAngles*distances)
+	offset := maxRho*houghScale + houghScale/2
+	for y := 0; y < height; y++ {
+		row := edges.Pix[y*edges.Stride : y*edges.Stride+width]
+		for x, value := range row {
+			if value == 0 {
+				continue
+			}
+			cell := 0
+			for angle := range houghAngles {
+				rho := (x*cosines[angle] + y*sines[angle] + offset) >> houghShift
+				accumulator[cell+rho]++
+				cell += distances
+			}
+		}
+	}
+
+	minVotes := int32(max(houghMinVotes*float64(min(width, height)), 1))
+	var candidates []HoughLine
+	for angle := range houghAngles {
+		for rho := range distances {
+			votes := accumulator[angle*distances+rho]
+			if votes < minVotes || !houghPeak(accumulator, distances, angle, rho) {
+				continue
+			}
+			candidates = append(candidates, HoughLine{
+				Rho:   float64(rho - maxRho),
+				Theta: float64(angle) * math.Pi / houghAngles,
+				Votes: int(votes),
+			})
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b HoughLine) int { return b.Votes - a.Votes })
+
+	var lines []HoughLine
+	for _, candidate := range candidates {
+		if !slices.ContainsFunc(lines, candidate.same) {
+			lines = append(lines, candidate)
+			if len(lines) == count {
+				break
+			}
+		}
+	}
+	// The votes are counted from the top-left corner of edges: the lines are moved to the coordinates of the image.
+	for i := range lines {
+		lines[i].Rho += float64(bounds.Min.X)*math.Cos(lines[i].Theta) + float64(bounds.Min.Y)*math.Sin(lines[i].Theta)
+	}
+	return lines
+}
+
+func houghPeak(accumulator []int32, distances, angle, rho int) bool {
+	votes := accumulator[angle*distances+rho]
+	for dAngle := -1; dAngle <= 1; dAngle++ {
+		for dRho := -1; dRho <= 1; dRho++ {
+			neighborAngle, neighborRho := angle+dAngle, rho+dRho
+			if neighborAngle < 0 || neighborAngle >= houghAngles || neighborRho < 0 || neighborRho >= distances {
+				continue
+			}
+			neighbor := accumulator[neighborAngle*distances+neighborRho]
+			// Plateaus keep their first cell only.
+			if neighbor > votes || (neighbor == votes && (dAngle < 0 || (dAngle == 0 && dRho < 0))) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (line HoughLine) same(other HoughLine) bool {
+	window := float64(houghAngleWindow) * math.Pi / houghAngles
+	difference := math.Abs(line.Theta - other.Theta)
+	if difference <= window {
+		return math.Abs(line.Rho-other.Rho) <= houghDistanceWindow
+	}
+	// A line of an angle close to π is also close to the lines of an angle close to 0, of the opposite distance.
+	if math.Pi-difference <= window {
+		return math.Abs(line.Rho+other.Rho) <= houghDistanceWindow
+	}
+	return false
+}
+
+func (line HoughLine) horizontal() bool {
+	return math.Abs(math.Sin(line.Theta)) > math.Abs(math.Cos(line.Theta))
+}
+
+func (line HoughLine) position(bounds image.Rectangle) float64 {
+	centerX, centerY := float64(bounds.Min.X+bounds.Max.X)/2, float64(bounds.Min.Y+bounds.Max.Y)/2
+	sin, cos := math.Sin(line.Theta), math.Cos(line.Theta)
+	if line.horizontal() {
+		return (line.Rho - centerX*cos) / sin
+	}
+	return (line.Rho - centerY*sin) / cos
+}
+
+func (line HoughLine) intersect(other HoughLine) (geometry.Point, bool) {
+	cos1, sin1 := math.Cos(line.Theta), math.Sin(line.Theta)
+	cos2, sin2 := math.Cos(other.Theta), math.Sin(other.Theta)
+	determinant := cos1*sin2 - sin1*cos2
+	if math.Abs(determinant) < 1e-9 {
+		return geometry.Point{}, false
+	}
+	x := (line.Rho*sin2 - other.Rho*sin1) / determinant
+	y := (cos1*other.Rho - cos2*line.Rho) / determinant
+	return geometry.Point{X: int(math.Round(x)), Y: int(math.Round(y))}, true
+}
+
+func (line HoughLine) distance(point geometry.Point) float64 {
+	return math.Abs(float64(point.X)*math.Cos(line.Theta) + float64(point.Y)*math.Sin(line.Theta) - line.Rho)
+}
+
+func FindHoughQuadrilateral(edges *image.Gray) geometry.ContourWithArea {
+	bounds := edges.Bounds()
+	var horizontal, vertical []HoughLine
+	for _, line := range HoughLines(edges, houghCandidates) {
+		if line.horizontal() {
+			horizontal = append(horizontal, line)
+		} else {
+			vertical = append(vertical, line)
+		}
+	}
+	top, bottom, ok := houghSides(horizontal, bounds, float64(bounds.Dy()))
+	if !ok {
+		return geometry.ContourWithArea{}
+	}
+	left, right, ok := houghSides(vertical, bounds, float64(bounds.Dx()))
+	if !ok {
+		return geometry.ContourWithArea{}
+	}
+
+	corners := make(geometry.Contour, 0, 4)
+	outside := houghOutside * float64(max(bounds.Dx(), bounds.Dy()))
+	for _, pair := range [4][2]HoughLine{{top, left}, {top, right}, {bottom, right}, {bottom, left}} {
+		corner, ok := pair[0].intersect(pair[1])
+		if !ok {
+			return geometry.ContourWithArea{}
+		}
+		if float64(bounds.Min.X-corner.X) > outside || float64(corner.X-bounds.Max.X+1) > outside ||
+			float64(bounds.Min.Y-corner.Y) > outside || float64(corner.Y-bounds.Max.Y+1) > outside {
+			return geometry.ContourWithArea{}
+		}
+		corner.X = min(max(corner.X, bounds.Min.X), bounds.Max.X-1)
+		corner.Y = min(max(corner.Y, bounds.Min.Y), bounds.Max.Y-1)
+		corners = append(corners, corner)
+	}
+	if !convex(corners) {
+		return geometry.ContourWithArea{}
+	}
+	return geometry.ContourWithArea{Contour: corners, Area: polygonArea(corners)}
+}
+
+func houghSides(lines []HoughLine, bounds image.Rectangle, side float64) (HoughLine, HoughLine, bool) {
+	var first, second HoughLine
+	best := 0.0
+	for i, line := range lines {
+		for _, other := range lines[i+1:] {
+			skew := math.Abs(line.Theta - other.Theta)
+			skew = min(skew, math.Pi-skew)
+			distance := math.Abs(line.position(bounds) - other.position(bounds))
+			score := float64(line.Votes+other.Votes) * distance
+			if skew > houghMaxSkew || distance < houghMinSide*side || score <= best {
+				continue
+			}
+			first, second, best = line, other, score
+		}
+	}
+	if first.position(bounds) > second.position(bounds) {
+		first, second = second, first
+	}
+	return first, second, best > 0
+}
+
+func convex(corners geometry.Contour) bool {
+	sign := 0
+	for i := range corners {
+		a, b, c := corners[i], corners[(i+1)%len(corners)], corners[(i+2)%len(corners)]
+		cross := (b.X-a.X)*(c.Y-b.Y) - (b.Y-a.Y)*(c.X-b.X)
+		switch {
+		case cross == 0:
+			return false
+		case sign == 0:
+			sign = cross
+		case (cross > 0) != (sign > 0):
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package utils
+
+/*
+This file tests the Hough transform on the edge map of a tilted page whose bottom-right corner is hidden, e.g. by a
+hand, and whose text makes shorter horizontal edges.
+
+---
+
+### drawSegment(img *image.Gray, from, to geometry.Point)
+Draws a one pixel wide segment of edge pixels.
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func drawSegment(img *image.Gray, from, to geometry.Point) {
+	steps := int(max(math.Abs(float64(to.X-from.X)), math.Abs(float64(to.Y-from.Y))))
+	for step := 0; step <= steps; step++ {
+		t := float64(step) / float64(max(steps, 1))
+		x := int(math.Round(float64(from.X) + t*float64(to.X-from.X)))
+		y := int(math.Round(float64(from.Y) + t*float64(to.Y-from.Y)))
+		img.SetGray(x, y, color.Gray{Y: 255})
+	}
+}
+
+func TestFindHoughQuadrilateral(t *testing.T) {
+	edges := image.NewGray(image.Rect(0, 0, 400, 300))
+	corners := geometry.Contour{{X: 60, Y: 40}, {X: 330, Y: 60}, {X: 320, Y: 260}, {X: 50, Y: 240}}
+
+	// The bottom and right sides stop short of their corner, hidden by an object.
+	drawSegment(edges, corners[0], corners[1])
+	drawSegment(edges, corners[1], geometry.Point{X: 325, Y: 160})
+	drawSegment(edges, geometry.Point{X: 200, Y: 251}, corners[3])
+	drawSegment(edges, corners[3], corners[0])
+	for y := 80; y < 220; y += 20 {
+		drawSegment(edges, geometry.Point{X: 90, Y: y}, geometry.Point{X: 180, Y: y + 6})
+	}
+
+	document := FindHoughQuadrilateral(edges)
+	if len(document.Contour) != 4 {
+		t.Fatalf("found %d corners, expected 4", len(document.Contour))
+	}
+	for i, corner := range document.Contour {
+		if math.Abs(float64(corner.X-corners[i].X)) > 3 || math.Abs(float64(corner.Y-corners[i].Y)) > 3 {
+			t.Errorf("corner %d at %v, expected about %v", i, corner, corners[i])
+		}
+	}
+	if expected := polygonArea(corners); math.Abs(document.Area-expected) > 0.03*expected {
+		t.Errorf("area %.0f, expected about %.0f", document.Area, expected)
+	}
+
+	if document := FindHoughQuadrilateral(image.NewGray(image.Rect(0, 0, 100, 100))); document.Area != 0 {
+		t.Fatalf("found a document of area %.0f in an empty edge map", document.Area)
+	}
+}
@@ -0,0 +1,176 @@
+package utils
+
+/*
+Package utils provides the flattening of the illumination of a photo before its edge detection. The shadow the hand
+or the phone of the photographer casts on a page is a broad dark area whose border crosses the document: Canny finds
+that border as strongly as the outline of the page, and the contours of the shadow cut the page apart. The brightness
+of the background is estimated on a grid of small cells over the photo, the text removed by a closing, and every pixel
+is divided by the brightness of the background around it, so the shadowed paper is as bright as the lit paper while
+the text keeps its contrast.
+
+---
+
+### Illumination
+Brightness of the background of an image, estimated on a grid of square cells.
+
+- **Fields**:
+  - `Rect`: Bounds of the image.
+  - `Cell`: Side of a cell, in pixels.
+  - `Columns`, `Rows`: Number of cells across and down the image.
+  - `Levels`: Brightness of the background of every cell, row by row, from 1 to 255.
+  - `Target`: Brightness of the most lit background, which the flattened background is brought to.
+
+- **Methods**:
+  - `Flatten(gray *image.Gray)`: Divides, in place, every pixel of `gray`, an image of `Rect` or a chunk of it, by
+    the brightness of the background at its position, interpolated bilinearly between the centers of the cells, and
+    multiplies it by `Target`. The gain is at most `maxIlluminationGain`, that of a deep shadow: the table around a
+    document, much darker than a shadowed paper, stays darker than the page.
+
+### EstimateIllumination(img *image.RGBA) *Illumination
+Returns the brightness of the background of `img`, on a grid of about `illuminationCells` cells along its longer
+side.
+
+- **Behavior**:
+  - The brightness of a cell is the `illuminationPercentile` of the gray levels of its pixels, sampled on a grid of
+    at most `illuminationSamples` per side of the cell. The median of a cell crossed by the border of a shadow is
+    the level at its center, so the levels interpolated between the cells follow the penumbra instead of
+    overshooting it; the cells inside the text are dark, and left to the closing.
+  - The grid is then closed (see `Close`) with a radius of `illuminationClosing` cells, which fills the text lines
+    and the other dark marks narrower than the closing with the brightness of the paper around them. A closing keeps
+    the steps wider than that where they are: the border of a shadow, flattened up to the cell it crosses, and the
+    outline of the page, which keeps its contrast.
+
+### cellPosition(position, cell, cells int) (int, float32)
+Returns the index of the last of `cells` cells of side `cell` whose center is at or before the pixel at `position`,
+and the weight of the next cell in the interpolation of the pixel, from 0 to 1. The pixels before the center of the
+first cell or after that of the last take its level.
+
+---
+
+### Constants
+- `illuminationCells`: Number of cells along the longer side of an image: the precision of the border of a shadow.
+- `illuminationSamples`: Largest number of pixels sampled along a side of a cell.
+- `illuminationPercentile`: Fraction of the samples of a cell darker than its brightness: the median.
+- `illuminationClosing`: Radius, in cells, of the closing removing the text from the grid: a line of text is a few
+  cells high on a photo of a page.
+- `maxIlluminationGain`: Highest factor a pixel is brightened by.
+
+---
+
+### Example Usage:
+```go
+illumination := utils.EstimateIllumination(photo)
+gray := imageUtils.Grayscale(photo)
+illumination.Flatten(gray)
+edges := utils.ApplyCannyEdgeDetection(gray)
+```
+*/
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	illuminationCells      = 256
+	illuminationSamples    = 8
+	illuminationPercentile = 0.5
+	illuminationClosing    = 6
+	maxIlluminationGain    = 2.5
+)
+
+type Illumination struct {
+	Rect          image.Rectangle
+	Cell          int
+	Columns, Rows int
+	Levels        []float32
+	Target        float32
+}
+
+func EstimateIllumination(img *image.RGBA) *Illumination {
+	bounds := img.Bounds()
+	cell := max(1, (max(bounds.Dx(), bounds.Dy())+illuminationCells-1)/illuminationCells)
+	columns, rows := (bounds.Dx()+cell-1)/cell, (bounds.Dy()+cell-1)/cell
+	illumination := &Illumination{Rect: bounds, Cell: cell, Columns: columns, Rows: rows, Levels: make([]float32, columns*rows)}
+	if bounds.Empty() {
+		return illumination
+	}
+	levels := image.NewGray(image.Rect(0, 0, columns, rows))
+
+	step := max(1, cell/illuminationSamples)
+	var histogram [256]int
+	for row := 0; row < rows; row++ {
+		for column := 0; column < columns; column++ {
+			area := image.Rect(column*cell, row*cell, (column+1)*cell, (row+1)*cell).Add(bounds.Min).Intersect(bounds)
+			clear(histogram[:])
+			count := 0
+			for y := area.Min.Y; y < area.Max.Y; y += step {
+				for x := area.Min.X; x < area.Max.X; x += step {
+					pixel := img.Pix[img.PixOffset(x, y):]
+					histogram[uint8(0.299*float64(pixel[0])+0.587*float64(pixel[1])+0.114*float64(pixel[2]))]++
+					count++
+				}
+			}
+			darker, level := 0, 0
+			for ; level < 255; level++ {
+				darker += histogram[level]
+				if float64(darker) >= illuminationPercentile*float64(count) {
+					break
+				}
+			}
+			levels.Pix[row*levels.Stride+column] = uint8(level)
+		}
+	}
+
+	levels = Close(levels, illuminationClosing)
+	for i, level := range levels.Pix {
+		illumination.Levels[i] = float32(max(level, 1))
+		illumination.Target = max(illumination.Target, illumination.Levels[i])
+	}
+	return illumination
+}
+
+func (illumination *Illumination) Flatten(gray *image.Gray) {
+	bounds := gray.Bounds().Intersect(illumination.Rect)
+	if bounds.Empty() {
+		return
+	}
+
+	// Position of every column between the centers of the cells, computed once for all the rows.
+	left := make([]int, bounds.Dx())
+	weights := make([]float32, bounds.Dx())
+	for x := range left {
+		left[x], weights[x] = cellPosition(bounds.Min.X+x-illumination.Rect.Min.X, illumination.Cell, illumination.Columns)
+	}
+	gains := make([]float32, bounds.Dx())
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		top, weight := cellPosition(y-illumination.Rect.Min.Y, illumination.Cell, illumination.Rows)
+		bottom := min(top+1, illumination.Rows-1)
+		upper := illumination.Levels[top*illumination.Columns : (top+1)*illumination.Columns]
+		lower := illumination.Levels[bottom*illumination.Columns : (bottom+1)*illumination.Columns]
+		for x := range gains {
+			right := min(left[x]+1, illumination.Columns-1)
+			above := upper[left[x]] + weights[x]*(upper[right]-upper[left[x]])
+			below := lower[left[x]] + weights[x]*(lower[right]-lower[left[x]])
+			gains[x] = min(illumination.Target/(above+weight*(below-above)), maxIlluminationGain)
+		}
+
+		row := gray.Pix[gray.PixOffset(bounds.Min.X, y):gray.PixOffset(bounds.Max.X, y)]
+		for x, value := range row {
+			row[x] = uint8(min(float32(value)*gains[x], 255))
+		}
+	}
+}
+
+func cellPosition(position, cell, cells int) (int, float32) {
+	offset := (float64(position)+0.5)/float64(cell) - 0.5
+	if offset <= 0 {
+		return 0, 0
+	}
+	index := int(math.Floor(offset))
+	if index >= cells-1 {
+		return cells - 1, 0
+	}
+	return index, float32(offset - float64(index))
+}
@@ -0,0 +1,67 @@
+package utils
+
+/*
+This file tests the flattening of the illumination of a photo: a page on a dark table, half of it in the shadow of
+the photographer, whose border must no longer be found by the edge detection once flattened, while the outline of the
+page still is.
+*/
+
+import (
+	"ELP-project/internal/imageUtils"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func shadowedPage() *image.RGBA {
+	photo := image.NewRGBA(image.Rect(0, 0, 600, 450))
+	draw.Draw(photo, photo.Bounds(), image.NewUniform(color.Gray{Y: 60}), image.Point{}, draw.Src)
+	page := textPage(440, 330)
+	draw.Draw(page, image.Rect(0, 140, 440, 190), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(photo, image.Rect(80, 60, 520, 390), page, image.Point{}, draw.Src)
+
+	// The shadow darkens the right of the photo to 45%, over a penumbra of 6 pixels.
+	for y := 0; y < 450; y++ {
+		for x := 0; x < 600; x++ {
+			factor := 1 - 0.55*min(max(float64(x-297)/6, 0), 1)
+			pixel := photo.Pix[photo.PixOffset(x, y):]
+			for c := 0; c < 3; c++ {
+				pixel[c] = uint8(float64(pixel[c]) * factor)
+			}
+		}
+	}
+	return photo
+}
+
+func TestFlattenIllumination(t *testing.T) {
+	photo := shadowedPage()
+	shadowEdges := func(gray *image.Gray) int {
+		edges := ApplyCannyEdgeDetection(gray)
+		count := 0
+		for y := 210; y < 240; y++ {
+			for x := 270; x < 330; x++ {
+				if edges.GrayAt(x, y).Y > 0 {
+					count++
+				}
+			}
+		}
+		return count
+	}
+	if shadowEdges(imageUtils.Grayscale(photo)) == 0 {
+		t.Fatal("found no edge along the shadow, expected the test image to need flattening")
+	}
+
+	gray := imageUtils.Grayscale(photo)
+	EstimateIllumination(photo).Flatten(gray)
+
+	if count := shadowEdges(gray); count > 0 {
+		t.Errorf("found %d edge pixels along the shadow once flattened", count)
+	}
+	if lit, shadowed := gray.GrayAt(200, 225).Y, gray.GrayAt(420, 225).Y; int(lit)-int(shadowed) > 25 {
+		t.Errorf("paper has a level of %d in the light but %d in the shadow once flattened", lit, shadowed)
+	}
+	if table, paper := gray.GrayAt(70, 225).Y, gray.GrayAt(90, 225).Y; int(paper)-int(table) < 100 {
+		t.Errorf("page border has a contrast of %d once flattened, from %d to %d", int(paper)-int(table), table, paper)
+	}
+}
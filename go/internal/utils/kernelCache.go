@@ -0,0 +1,90 @@
+package utils
+
+/*
+Package utils provides the cache of the convolution kernels. The edge detection of every chunk of every request
+generated its Gaussian and Sobel kernels again, computing the same exponentials for the same few parameters, those of
+the presets: the kernels are now generated once per kind, size and sigma, and shared by all the goroutines.
+
+---
+
+### GaussianKernel(size int, sigma float64) [][]float64
+Returns the kernel of `GenerateGaussianKernel(size, sigma)`, generated on the first call only. The kernel is shared:
+it must not be modified.
+
+### SobelKernels(size int) ([][]float64, [][]float64)
+Returns the kernels of `GenerateSobelKernel(size)`, generated on the first call only, shared like those of
+`GaussianKernel`.
+
+### kernelKey
+Parameters a kernel is generated from: its `kind` (`gaussian`, `sobelX` or `sobelY`), `size` and `sigma` (0 for the
+Sobel kernels).
+
+### cachedKernel(key kernelKey, generate func() [][]float64) [][]float64
+Returns the kernel of `key` from the cache, or generates it with `generate` and stores it. Two goroutines missing the
+same kernel at once may both generate it, only the first one stored is returned. Once the cache holds
+`kernelCacheSize` kernels, e.g. for a caller trying many sigmas, the new kernels are generated but not stored.
+
+---
+
+### Constants
+- `kernelCacheSize`: Largest number of kernels kept.
+
+---
+
+### Example Usage:
+```go
+blurred := utils.ApplyKernelFloat(plane, utils.GaussianKernel(5, 1.4))
+sobelX, sobelY := utils.SobelKernels(3)
+```
+*/
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const kernelCacheSize = 64
+
+type kernelKey struct {
+	kind  string
+	size  int
+	sigma float64
+}
+
+var (
+	kernels     sync.Map
+	kernelCount atomic.Int32
+)
+
+func GaussianKernel(size int, sigma float64) [][]float64 {
+	return cachedKernel(kernelKey{kind: "gaussian", size: size, sigma: sigma}, func() [][]float64 {
+		return GenerateGaussianKernel(size, sigma)
+	})
+}
+
+func SobelKernels(size int) ([][]float64, [][]float64) {
+	sobelX := cachedKernel(kernelKey{kind: "sobelX", size: size}, func() [][]float64 {
+		kernelX, _ := GenerateSobelKernel(size)
+		return kernelX
+	})
+	sobelY := cachedKernel(kernelKey{kind: "sobelY", size: size}, func() [][]float64 {
+		_, kernelY := GenerateSobelKernel(size)
+		return kernelY
+	})
+	return sobelX, sobelY
+}
+
+func cachedKernel(key kernelKey, generate func() [][]float64) [][]float64 {
+	if kernel, ok := kernels.Load(key); ok {
+		return kernel.([][]float64)
+	}
+	kernel := generate()
+	if kernelCount.Load() >= kernelCacheSize {
+		return kernel
+	}
+	stored, loaded := kernels.LoadOrStore(key, kernel)
+	if !loaded {
+		kernelCount.Add(1)
+	}
+	return stored.([][]float64)
+}
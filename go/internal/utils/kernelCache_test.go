@@ -0,0 +1,43 @@
+package utils
+
+/*
+This file tests that the cached kernels are those generated, shared between the calls of the same parameters, and
+safe to request from several goroutines at once.
+*/
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestKernelCache(t *testing.T) {
+	kernel := GaussianKernel(5, 1.4)
+	if !reflect.DeepEqual(kernel, GenerateGaussianKernel(5, 1.4)) {
+		t.Fatal("cached Gaussian kernel differs from the generated one")
+	}
+	if again := GaussianKernel(5, 1.4); &again[0][0] != &kernel[0][0] {
+		t.Error("Gaussian kernel generated again for the same parameters")
+	}
+	if other := GaussianKernel(5, 2); reflect.DeepEqual(other, kernel) {
+		t.Error("Gaussian kernel of another sigma returned from the cache")
+	}
+
+	sobelX, sobelY := SobelKernels(7)
+	expectedX, expectedY := GenerateSobelKernel(7)
+	if !reflect.DeepEqual(sobelX, expectedX) || !reflect.DeepEqual(sobelY, expectedY) {
+		t.Fatal("cached Sobel kernels differ from the generated ones")
+	}
+
+	var group sync.WaitGroup
+	for range 8 {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			if kernel := GaussianKernel(9, 3); len(kernel) != 9 {
+				t.Errorf("kernel of size %d, expected 9", len(kernel))
+			}
+		}()
+	}
+	group.Wait()
+}
@@ -0,0 +1,108 @@
+package utils
+
+/*
+Package utils provides the Laplacian of Gaussian (LoG) edge operator, the alternative to the Non-Maximum Suppression of
+the Canny edge detection selected by `CannyParameters.Operator`. The edges are the zero crossings of the Laplacian of
+the smoothed image, where its second derivative changes sign: the smoothing of `CannyParameters.Smooth` being a
+Gaussian blur, the Laplacian of the blurred image is the LoG of the image. The zero crossings are closed around
+the regions of the image whatever the direction of their border, where the Non-Maximum Suppression, comparing a pixel
+to its neighbors along one of four directions, breaks the corners and the curved borders. They are noisier on
+the flat regions, where the Laplacian changes sign for a fraction of a gray level: every zero crossing is weighted by
+the gradient of the image there, and goes through the same hysteresis as the Canny edges, so only those on a real
+border are kept.
+
+---
+
+### EdgeOperator
+Operator thinning the gradient of the edge detection into edges of one pixel.
+
+### Constants
+- `OperatorCanny`: The Non-Maximum Suppression along the direction of the gradient (see `nonMaxSuppression`), the
+  default.
+- `OperatorLoG`: The zero crossings of the Laplacian of the smoothed image (see `zeroCrossings`).
+- `logThresholdScale`: Multiplier of the thresholds of the hysteresis of the zero crossings. Both pixels of a crossing
+  are kept, so a faint border along a strong one, like the border of the page along a line of text touching it, is
+  not thinned away as by the Non-Maximum Suppression: between the thresholds of the Canny edges, it is kept in
+  scattered pieces by the hysteresis, which merge with the strong border into a broken contour, and the document is
+  missed. Half the thresholds keep it whole.
+
+---
+
+### laplacian(img *FloatImage) *FloatImage
+Returns the Laplacian of `img`, the sum of the differences of every value with its four neighbors. The border of the
+plane, whose neighbors are missing, is left at 0.
+
+### zeroCrossings(gradient, laplacian *FloatImage) *FloatImage
+Returns the plane of the gradient magnitudes of the zero crossings of `laplacian`, 0 elsewhere, like the plane left by
+the Non-Maximum Suppression.
+
+- **Behavior**:
+  - A pixel is a zero crossing when one of its four neighbors has a Laplacian of the opposite sign, or when its
+    Laplacian is exactly 0 between two opposite neighbors of opposite signs, as on the sharp steps of a clean image:
+    a Laplacian of 0 is no crossing by itself, the flat regions around a border having one. Both pixels the Laplacian
+    crosses zero between are edges, so the edges are two pixels thick, about as thick as those of the Canny
+    detector. Keeping only the pixel nearer to zero gives edges of one pixel, but the area of the contours of
+    such thin outlines, in the order of their search, is no longer that of the region they enclose (see
+    `FindQuadrilateral`), and the document is missed.
+  - The border of the plane is never an edge, its Laplacian being unknown.
+
+### oppositeSigns(a, b float32) bool
+Reports whether `a` and `b` are both nonzero and of opposite signs.
+
+---
+
+### Example Usage:
+```go
+parameters := utils.DefaultCannyParameters
+parameters.Operator = utils.OperatorLoG
+edges, _ := utils.ApplyCannyEdgeMap(gray, parameters)
+```
+*/
+
+type EdgeOperator int
+
+const (
+	OperatorCanny EdgeOperator = iota
+	OperatorLoG
+)
+
+const logThresholdScale = 0.5
+
+func laplacian(img *FloatImage) *FloatImage {
+	bounds := img.Bounds()
+	result := NewFloatImage(bounds)
+
+	for y := bounds.Min.Y + 1; y < bounds.Max.Y-1; y++ {
+		for x := bounds.Min.X + 1; x < bounds.Max.X-1; x++ {
+			offset := img.PixOffset(x, y)
+			sum := img.Pix[offset-1] + img.Pix[offset+1] + img.Pix[offset-img.Stride] + img.Pix[offset+img.Stride]
+			result.Pix[result.PixOffset(x, y)] = sum - 4*img.Pix[offset]
+		}
+	}
+	return result
+}
+
+func zeroCrossings(gradient, laplacian *FloatImage) *FloatImage {
+	bounds := gradient.Bounds()
+	crossings := NewFloatImage(bounds)
+
+	for y := bounds.Min.Y + 2; y < bounds.Max.Y-2; y++ {
+		for x := bounds.Min.X + 2; x < bounds.Max.X-2; x++ {
+			offset := laplacian.PixOffset(x, y)
+			value := laplacian.Pix[offset]
+			for _, step := range [2]int{1, laplacian.Stride} {
+				before, after := laplacian.Pix[offset-step], laplacian.Pix[offset+step]
+				if oppositeSigns(value, before) || oppositeSigns(value, after) ||
+					(value == 0 && oppositeSigns(before, after)) {
+					crossings.Pix[crossings.PixOffset(x, y)] = gradient.FloatAt(x, y)
+					break
+				}
+			}
+		}
+	}
+	return crossings
+}
+
+func oppositeSigns(a, b float32) bool {
+	return (a > 0 && b < 0) || (a < 0 && b > 0)
+}
@@ -0,0 +1,54 @@
+package utils
+
+/*
+This file tests the edges of the Laplacian of Gaussian: on a disk, they follow its whole border, curved in every
+direction, and nothing else.
+*/
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestZeroCrossings(t *testing.T) {
+	const radius = 60
+	gray := image.NewGray(image.Rect(0, 0, 200, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 200; x++ {
+			value := uint8(60)
+			if math.Hypot(float64(x)-100, float64(y)-100) < radius {
+				value = 200
+			}
+			gray.SetGray(x, y, color.Gray{Y: value})
+		}
+	}
+
+	parameters := DefaultCannyParameters
+	parameters.Operator = OperatorLoG
+	edges, _ := ApplyCannyEdgeMap(gray, parameters)
+	if len(edges.Edges) == 0 {
+		t.Fatal("no edge found on the disk")
+	}
+	for _, edge := range edges.Edges {
+		if distance := math.Hypot(float64(edge.X)-100, float64(edge.Y)-100); math.Abs(distance-radius) > 3 {
+			t.Fatalf("edge at %v, %.1f pixels from the center, expected about %d", edge, distance, radius)
+		}
+	}
+	// Every part of the border is found, whatever its direction.
+	for degrees := 0; degrees < 360; degrees += 5 {
+		angle := float64(degrees) * math.Pi / 180
+		x, y := 100+radius*math.Cos(angle), 100+radius*math.Sin(angle)
+		found := false
+		for _, edge := range edges.Edges {
+			if math.Hypot(float64(edge.X)-x, float64(edge.Y)-y) <= 2 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("no edge near (%.0f, %.0f), on the border of the disk at %d degrees", x, y, degrees)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package utils
+
+/*
+Package utils provides the merging of the contours found in the chunks of an image. `FindContoursBFS` only starts a
+search from the pixels of its chunk, but follows a connected component wherever it goes: a component crossing the
+border between two chunks is found by both, and the page itself, which usually spans the whole image, is found once
+per chunk. Concatenating the contours of the chunks then ranks the same candidate several times, and returns it among
+its own alternatives. A search stopping at the border of its chunk would instead split a component into pieces
+sharing the rows the chunks overlap on: both cases are handled the same way.
+
+---
+
+### MergeContours(contours []geometry.Contour) []geometry.Contour
+Merges the contours sharing a point, as they are parts of the same connected component: the merged contour is the
+first of them in `contours`, followed by the points of the others it lacks, in order. The other contours are returned
+unchanged, in the order of `contours`, so the result does not depend on the order the chunks finish in.
+
+The contours of the same bounding box, number of points and first point in reading order are copies of the same
+component found by several chunks: they share that point, and are merged right away without comparing the others.
+The remaining contours are only compared when their bounding boxes intersect, sweeping them from top to bottom, and
+a contour is compared point by point against the set of the points of the larger one, built once: the characters of
+a page are within its bounding box but share no point with it, and cost a lookup per point.
+
+### contourBox(contour geometry.Contour) image.Rectangle
+Returns the bounding box of `contour`, its `Max` excluded like that of an `image.Rectangle`, empty for an empty
+contour.
+
+### contourOrigin(contour geometry.Contour) geometry.Point
+Returns the first point of `contour` in reading order, top to bottom then left to right.
+
+---
+
+### Example Usage:
+```go
+contours := utils.MergeContours(slices.Concat(chunkContours...))
+```
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"cmp"
+	"image"
+	"slices"
+)
+
+func MergeContours(contours []geometry.Contour) []geometry.Contour {
+	type signature struct {
+		box    image.Rectangle
+		origin geometry.Point
+		length int
+	}
+	parent := make([]int, len(contours))
+	boxes := make([]image.Rectangle, len(contours))
+	order := make([]int, 0, len(contours))
+	originals := make(map[signature]int, len(contours))
+	merged := false
+	for i, contour := range contours {
+		parent[i] = i
+		boxes[i] = contourBox(contour)
+		key := signature{boxes[i], contourOrigin(contour), len(contour)}
+		if original, ok := originals[key]; ok && len(contour) > 0 {
+			parent[i] = original
+			merged = true
+			continue
+		}
+		originals[key] = i
+		order = append(order, i)
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Compare(boxes[a].Min.Y, boxes[b].Min.Y)
+	})
+
+	root := func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+	sets := make(map[int]map[geometry.Point]struct{})
+	pointSet := func(i int) map[geometry.Point]struct{} {
+		set, ok := sets[i]
+		if !ok {
+			set = make(map[geometry.Point]struct{}, len(contours[i]))
+			for _, point := range contours[i] {
+				set[point] = struct{}{}
+			}
+			sets[i] = set
+		}
+		return set
+	}
+	shares := func(i, j int) bool {
+		if len(contours[i]) < len(contours[j]) {
+			i, j = j, i
+		}
+		set := pointSet(i)
+		for _, point := range contours[j] {
+			if _, ok := set[point]; ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	for a, i := range order {
+		for _, j := range order[a+1:] {
+			if boxes[j].Min.Y >= boxes[i].Max.Y {
+				break
+			}
+			if !boxes[i].Overlaps(boxes[j]) {
+				continue
+			}
+			first, second := root(i), root(j)
+			if first == second || !shares(i, j) {
+				continue
+			}
+			parent[max(first, second)] = min(first, second)
+			merged = true
+		}
+	}
+	if !merged {
+		return contours
+	}
+
+	groups := make(map[int][]int)
+	for _, i := range order {
+		if first := root(i); first != i {
+			groups[first] = append(groups[first], i)
+		}
+	}
+	result := make([]geometry.Contour, 0, len(contours))
+	for i, contour := range contours {
+		if root(i) != i {
+			continue
+		}
+		members, ok := groups[i]
+		if !ok {
+			result = append(result, contour)
+			continue
+		}
+		seen := make(map[geometry.Point]struct{}, len(contour))
+		union := slices.Clone(contour)
+		for _, point := range contour {
+			seen[point] = struct{}{}
+		}
+		for _, member := range members {
+			for _, point := range contours[member] {
+				if _, ok := seen[point]; !ok {
+					seen[point] = struct{}{}
+					union = append(union, point)
+				}
+			}
+		}
+		result = append(result, union)
+	}
+	return result
+}
+
+func contourOrigin(contour geometry.Contour) geometry.Point {
+	var origin geometry.Point
+	for i, point := range contour {
+		if i == 0 || point.Y < origin.Y || (point.Y == origin.Y && point.X < origin.X) {
+			origin = point
+		}
+	}
+	return origin
+}
+
+func contourBox(contour geometry.Contour) image.Rectangle {
+	if len(contour) == 0 {
+		return image.Rectangle{}
+	}
+	box := image.Rectangle{Min: image.Point(contour[0]), Max: image.Point(contour[0])}
+	for _, point := range contour[1:] {
+		box.Min.X, box.Min.Y = min(box.Min.X, point.X), min(box.Min.Y, point.Y)
+		box.Max.X, box.Max.Y = max(box.Max.X, point.X), max(box.Max.Y, point.Y)
+	}
+	box.Max = box.Max.Add(image.Point{X: 1, Y: 1})
+	return box
+}
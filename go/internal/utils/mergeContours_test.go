@@ -0,0 +1,59 @@
+package utils
+
+/*
+This file tests the merging of the contours of the chunks of an image: a page found by every chunk it crosses, a frame
+split at the border of two chunks, and the characters inside the page, which share no point with it.
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"slices"
+	"testing"
+)
+
+func TestMergeContours(t *testing.T) {
+	outline := func(x0, y0, x1, y1 int) geometry.Contour {
+		var contour geometry.Contour
+		for x := x0; x <= x1; x++ {
+			contour = append(contour, geometry.Point{X: x, Y: y0}, geometry.Point{X: x, Y: y1})
+		}
+		for y := y0 + 1; y < y1; y++ {
+			contour = append(contour, geometry.Point{X: x0, Y: y}, geometry.Point{X: x1, Y: y})
+		}
+		return contour
+	}
+	page := outline(10, 10, 90, 190)
+	character := outline(20, 20, 30, 35)
+	frame := outline(100, 50, 150, 150)
+	var top, bottom geometry.Contour
+	for _, point := range frame {
+		if point.Y <= 105 {
+			top = append(top, point)
+		}
+		if point.Y >= 95 {
+			bottom = append(bottom, point)
+		}
+	}
+
+	// The page found by both chunks, its second copy in another order, and the frame found in two pieces.
+	reversed := slices.Clone(page)
+	slices.Reverse(reversed)
+	merged := MergeContours([]geometry.Contour{page, character, top, reversed, bottom})
+	if len(merged) != 3 {
+		t.Fatalf("merged into %d contours, expected 3", len(merged))
+	}
+	if !slices.Equal(merged[0], page) {
+		t.Error("the page merged with its copy is not the first copy")
+	}
+	if !slices.Equal(merged[1], character) {
+		t.Error("the character inside the page was changed")
+	}
+	if len(merged[2]) != len(frame) || !slices.Equal(merged[2][:len(top)], top) {
+		t.Errorf("frame merged into %d points starting with its top, expected %d", len(merged[2]), len(frame))
+	}
+
+	contours := []geometry.Contour{page, character}
+	if got := MergeContours(contours); len(got) != 2 || &got[0] != &contours[0] {
+		t.Error("contours sharing no point were not returned as they are")
+	}
+}
@@ -0,0 +1,276 @@
+package utils
+
+/*
+Package utils provides the morphological operations run on the edge map before the search of the contours: a
+closing bridges the small gaps of the border of a document, which would otherwise split it into several contours.
+The operations work on any `*image.Gray`, and are meant for binary masks such as the Canny edges, whose pixels are 0
+or 255.
+
+---
+
+### StructuringElement
+The neighborhood a morphological operation looks at around every pixel: the offsets, from the pixel, of the cells of
+a `Width` x `Height` mask centered on it (the anchor is the cell `(Width/2, Height/2)`).
+
+- Fields:
+  - `Width`, `Height`: Size of the mask.
+  - `Mask`: Cells of the mask, row by row, true for the cells in the neighborhood.
+
+- Methods:
+  - `isRectangle() bool`: Whether every cell of the mask is set, the operations then take the fast separable path.
+
+The elements are built with:
+- `Rectangle(width, height int) StructuringElement`: Every cell of the mask, e.g. a horizontal line of `width` x 1
+  bridging the gaps of the horizontal edges only.
+- `Cross(width, height int) StructuringElement`: The middle row and column of the mask: the 4-neighborhood for 3x3.
+- `Ellipse(width, height int) StructuringElement`: The cells inside the ellipse inscribed in the mask, which
+  rounds the corners a square would leave: a disk for a square mask.
+- `NewStructuringElement(rows []string) (StructuringElement, error)`: The mask drawn by `rows`, one string per row
+  of the same length, `#` for the cells of the neighborhood and `.` for the others. Fails if the rows are empty, of
+  different lengths, or contain another character.
+
+---
+
+### Dilate(img *image.Gray, radius int) *image.Gray
+Returns the dilation of `img` by a square of side `2*radius+1`: every pixel takes the largest value around it.
+
+- **Behavior**:
+  - The square is separable: the maximum is taken along the rows, then along the columns of the result, which costs
+    `2*(2*radius+1)` comparisons per pixel instead of `(2*radius+1)²`.
+  - The pixels outside the image are ignored. A `radius` of 0 or less returns a copy of `img`.
+
+### Erode(img *image.Gray, radius int) *image.Gray
+Returns the erosion of `img` by the same square: every pixel takes the smallest value around it.
+
+### Close(img *image.Gray, radius int) *image.Gray
+Returns the closing of `img`: its dilation then the erosion of the result. The gaps narrower than `2*radius+1`
+pixels between white regions are filled, and the white regions keep their outline otherwise.
+
+### Open(img *image.Gray, radius int) *image.Gray
+Returns the opening of `img`: its erosion then the dilation of the result. The white specks and lines thinner than
+`2*radius+1` pixels are removed, e.g. the noise of an edge map, and the white regions keep their outline otherwise.
+
+### DilateWith, ErodeWith, CloseWith, OpenWith(img *image.Gray, element StructuringElement) *image.Gray
+The same operations with the neighborhood of `element`.
+
+- **Behavior**:
+  - The dilation looks at the neighborhood reflected through its anchor, so an opening or a closing by an element
+    which is not symmetric still keeps the regions it fits in.
+  - A rectangle takes the separable path of the square; the other elements cost a comparison per cell of the mask
+    for every pixel.
+  - An empty element returns a copy of `img`.
+
+### morph(img *image.Gray, radiusX, radiusY int, pick func(a, b uint8) uint8) *image.Gray
+Applies the separable rectangle filter of `2*radiusX+1` x `2*radiusY+1` pixels keeping the value chosen by `pick`
+(the max or the min) around every pixel.
+
+### morphWith(img *image.Gray, element StructuringElement, reflect bool, pick func(a, b uint8) uint8) *image.Gray
+Applies the filter of the neighborhood of `element`, reflected through its anchor if `reflect` is set, keeping the
+value chosen by `pick` around every pixel.
+
+---
+
+### Example Usage:
+```go
+edges := utils.ApplyCannyEdgeDetection(gray)
+closed := utils.Close(edges, 2)
+contours := utils.FindContoursBFSWithDefault(closed)
+
+cleaned := utils.OpenWith(utils.CloseWith(edges, utils.Ellipse(5, 5)), utils.Cross(3, 3))
+```
+*/
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+)
+
+type StructuringElement struct {
+	Width  int
+	Height int
+	Mask   []bool
+}
+
+func Rectangle(width, height int) StructuringElement {
+	width, height = max(width, 0), max(height, 0)
+	element := StructuringElement{Width: width, Height: height, Mask: make([]bool, width*height)}
+	for i := range element.Mask {
+		element.Mask[i] = true
+	}
+	return element
+}
+
+func Cross(width, height int) StructuringElement {
+	width, height = max(width, 0), max(height, 0)
+	element := StructuringElement{Width: width, Height: height, Mask: make([]bool, width*height)}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			element.Mask[y*width+x] = x == width/2 || y == height/2
+		}
+	}
+	return element
+}
+
+func Ellipse(width, height int) StructuringElement {
+	width, height = max(width, 0), max(height, 0)
+	element := StructuringElement{Width: width, Height: height, Mask: make([]bool, width*height)}
+	radiusX, radiusY := float64(width)/2, float64(height)/2
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dx, dy := (float64(x)+0.5-radiusX)/radiusX, (float64(y)+0.5-radiusY)/radiusY
+			element.Mask[y*width+x] = dx*dx+dy*dy <= 1 || (x == width/2 && y == height/2)
+		}
+	}
+	return element
+}
+
+func NewStructuringElement(rows []string) (StructuringElement, error) {
+	if len(rows) == 0 || len(rows[0]) == 0 {
+		return StructuringElement{}, errors.New("empty structuring element")
+	}
+	element := StructuringElement{Width: len(rows[0]), Height: len(rows)}
+	for y, row := range rows {
+		if len(row) != element.Width {
+			return StructuringElement{}, fmt.Errorf("row %d of the structuring element has %d cells, expected %d", y, len(row), element.Width)
+		}
+		for _, cell := range row {
+			switch cell {
+			case '#':
+				element.Mask = append(element.Mask, true)
+			case '.':
+				element.Mask = append(element.Mask, false)
+			default:
+				return StructuringElement{}, fmt.Errorf("invalid cell %q in row %d of the structuring element", cell, y)
+			}
+		}
+	}
+	return element, nil
+}
+
+func (element StructuringElement) isRectangle() bool {
+	for _, set := range element.Mask {
+		if !set {
+			return false
+		}
+	}
+	return true
+}
+
+func Dilate(img *image.Gray, radius int) *image.Gray {
+	return morph(img, radius, radius, func(a, b uint8) uint8 { return max(a, b) })
+}
+
+func Erode(img *image.Gray, radius int) *image.Gray {
+	return morph(img, radius, radius, func(a, b uint8) uint8 { return min(a, b) })
+}
+
+func Close(img *image.Gray, radius int) *image.Gray {
+	return Erode(Dilate(img, radius), radius)
+}
+
+func Open(img *image.Gray, radius int) *image.Gray {
+	return Dilate(Erode(img, radius), radius)
+}
+
+func DilateWith(img *image.Gray, element StructuringElement) *image.Gray {
+	return morphWith(img, element, true, func(a, b uint8) uint8 { return max(a, b) })
+}
+
+func ErodeWith(img *image.Gray, element StructuringElement) *image.Gray {
+	return morphWith(img, element, false, func(a, b uint8) uint8 { return min(a, b) })
+}
+
+func CloseWith(img *image.Gray, element StructuringElement) *image.Gray {
+	return ErodeWith(DilateWith(img, element), element)
+}
+
+func OpenWith(img *image.Gray, element StructuringElement) *image.Gray {
+	return DilateWith(ErodeWith(img, element), element)
+}
+
+func morph(img *image.Gray, radiusX, radiusY int, pick func(a, b uint8) uint8) *image.Gray {
+	bounds := img.Bounds()
+	output := image.NewGray(bounds)
+	draw.Draw(output, bounds, img, bounds.Min, draw.Src)
+	radiusX, radiusY = max(radiusX, 0), max(radiusY, 0)
+	if radiusX+radiusY == 0 || bounds.Empty() {
+		return output
+	}
+
+	width, height := bounds.Dx(), bounds.Dy()
+	rows := make([]uint8, width*height)
+	for y := 0; y < height; y++ {
+		source := output.Pix[y*output.Stride : y*output.Stride+width]
+		row := rows[y*width : (y+1)*width]
+		for x := range row {
+			value := source[x]
+			for _, neighbor := range source[max(x-radiusX, 0):min(x+radiusX+1, width)] {
+				value = pick(value, neighbor)
+			}
+			row[x] = value
+		}
+	}
+
+	for y := 0; y < height; y++ {
+		row := output.Pix[y*output.Stride : y*output.Stride+width]
+		copy(row, rows[y*width:(y+1)*width])
+		for neighbor := max(y-radiusY, 0); neighbor < min(y+radiusY+1, height); neighbor++ {
+			for x, value := range rows[neighbor*width : (neighbor+1)*width] {
+				row[x] = pick(row[x], value)
+			}
+		}
+	}
+	return output
+}
+
+func morphWith(img *image.Gray, element StructuringElement, reflect bool, pick func(a, b uint8) uint8) *image.Gray {
+	anchorX, anchorY := element.Width/2, element.Height/2
+	if element.isRectangle() && element.Width%2 == 1 && element.Height%2 == 1 {
+		return morph(img, anchorX, anchorY, pick)
+	}
+
+	var offsets []image.Point
+	for y := 0; y < element.Height; y++ {
+		for x := 0; x < element.Width; x++ {
+			if element.Mask[y*element.Width+x] {
+				offset := image.Pt(x-anchorX, y-anchorY)
+				if reflect {
+					offset = image.Pt(-offset.X, -offset.Y)
+				}
+				offsets = append(offsets, offset)
+			}
+		}
+	}
+
+	bounds := img.Bounds()
+	output := image.NewGray(bounds)
+	draw.Draw(output, bounds, img, bounds.Min, draw.Src)
+	if len(offsets) == 0 || bounds.Empty() {
+		return output
+	}
+
+	width, height := bounds.Dx(), bounds.Dy()
+	source := make([]uint8, len(output.Pix))
+	copy(source, output.Pix)
+	for y := 0; y < height; y++ {
+		row := output.Pix[y*output.Stride : y*output.Stride+width]
+		for x := range row {
+			found := false
+			for _, offset := range offsets {
+				neighborX, neighborY := x+offset.X, y+offset.Y
+				if neighborX < 0 || neighborX >= width || neighborY < 0 || neighborY >= height {
+					continue
+				}
+				neighbor := source[neighborY*output.Stride+neighborX]
+				if !found {
+					row[x], found = neighbor, true
+				} else {
+					row[x] = pick(row[x], neighbor)
+				}
+			}
+		}
+	}
+	return output
+}
@@ -0,0 +1,139 @@
+package utils
+
+/*
+This file tests the morphological operations on small binary masks drawn as text.
+
+---
+
+### mask(rows ...string) *image.Gray
+Returns the binary mask drawn by `rows`, 255 for `#` and 0 for the other characters.
+
+### drawing(img *image.Gray) []string
+Returns the rows of `img` drawn like those of `mask`, `#` for the pixels above 127 and `.` for the others.
+*/
+
+import (
+	"image"
+	"slices"
+	"testing"
+)
+
+func mask(rows ...string) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x, cell := range row {
+			if cell == '#' {
+				img.Pix[y*img.Stride+x] = 255
+			}
+		}
+	}
+	return img
+}
+
+func drawing(img *image.Gray) []string {
+	rows := make([]string, img.Bounds().Dy())
+	for y := range rows {
+		row := make([]byte, img.Bounds().Dx())
+		for x := range row {
+			row[x] = '.'
+			if img.Pix[y*img.Stride+x] > 127 {
+				row[x] = '#'
+			}
+		}
+		rows[y] = string(row)
+	}
+	return rows
+}
+
+func TestStructuringElements(t *testing.T) {
+	tests := []struct {
+		name     string
+		element  StructuringElement
+		expected []string
+	}{
+		{"rectangle", Rectangle(3, 2), []string{"###", "###"}},
+		{"cross", Cross(3, 3), []string{".#.", "###", ".#."}},
+		{"ellipse", Ellipse(5, 5), []string{".###.", "#####", "#####", "#####", ".###."}},
+	}
+	for _, test := range tests {
+		element, err := NewStructuringElement(test.expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(test.element.Mask, element.Mask) || test.element.Width != element.Width {
+			t.Errorf("%s: mask %v, expected %v", test.name, test.element.Mask, element.Mask)
+		}
+	}
+
+	for _, rows := range [][]string{nil, {"#.", "#"}, {"#x#"}} {
+		if _, err := NewStructuringElement(rows); err == nil {
+			t.Errorf("%q gave no error", rows)
+		}
+	}
+}
+
+func TestCloseBridgesGaps(t *testing.T) {
+	// The border of a document broken by a gap of two pixels, which would split it into two contours.
+	edges := mask(
+		"............",
+		"............",
+		"..###..###..",
+		"............",
+		"............",
+	)
+	expected := []string{"............", "............", "..########..", "............", "............"}
+	if closed := drawing(Close(edges, 1)); !slices.Equal(closed, expected) {
+		t.Errorf("square closing gave %q, expected %q", closed, expected)
+	}
+	if closed := drawing(CloseWith(edges, Rectangle(3, 1))); !slices.Equal(closed, expected) {
+		t.Errorf("horizontal closing gave %q, expected %q", closed, expected)
+	}
+
+	// A vertical line does not bridge a horizontal gap.
+	if closed := drawing(CloseWith(edges, Rectangle(1, 3))); !slices.Equal(closed, drawing(edges)) {
+		t.Errorf("vertical closing gave %q, expected the edges unchanged", closed)
+	}
+}
+
+func TestOpenRemovesSpecks(t *testing.T) {
+	img := mask(
+		"#.......",
+		"...#####",
+		"...#####",
+		"...#####",
+		".#......",
+	)
+	expected := []string{"........", "...#####", "...#####", "...#####", "........"}
+	if opened := drawing(Open(img, 1)); !slices.Equal(opened, expected) {
+		t.Errorf("square opening gave %q, expected %q", opened, expected)
+	}
+	// The cross rounds the corners the square keeps.
+	expected = []string{"........", "....####", "...#####", "....####", "........"}
+	if opened := drawing(OpenWith(img, Cross(3, 3))); !slices.Equal(opened, expected) {
+		t.Errorf("cross opening gave %q, expected %q", opened, expected)
+	}
+}
+
+func TestMorphologyWithElement(t *testing.T) {
+	dot := mask(".....", ".....", "..#..", ".....", ".....")
+
+	if dilated := drawing(DilateWith(dot, Cross(3, 3))); !slices.Equal(dilated, []string{".....", "..#..", ".###.", "..#..", "....."}) {
+		t.Errorf("cross dilation gave %q", dilated)
+	}
+	if dilated := drawing(DilateWith(dot, Ellipse(5, 5))); !slices.Equal(dilated, []string{".###.", "#####", "#####", "#####", ".###."}) {
+		t.Errorf("disk dilation gave %q", dilated)
+	}
+
+	// An element off its anchor moves the dot by its offset, and the erosion moves it back.
+	right, err := NewStructuringElement([]string{"..#"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved := DilateWith(dot, right)
+	if rows := drawing(moved); rows[2] != "...#." {
+		t.Errorf("dilation by an offset element gave %q", rows)
+	}
+	if rows := drawing(ErodeWith(moved, right)); rows[2] != "..#.." {
+		t.Errorf("erosion by an offset element gave %q", rows)
+	}
+}
@@ -0,0 +1,93 @@
+package utils
+
+/*
+Package utils provides the preference of the ranking for the candidates shaped like a page. The largest contour of a
+photo is often not the document but the edge of the table it lies on, or the frame of a window behind it: the edge of
+a table is a long thin strip, or an outline broken around the objects on it, and a window is nearly square, while a
+page is a convex quadrilateral whose sides have the ratio of its paper size, √2 for the A sizes. The area of every
+candidate is weighted down by how far it is from that shape.
+
+---
+
+### PageShape
+Preference for the candidates shaped like a page of a given size.
+
+- **Fields**:
+  - `Ratio`: Ratio of the longer side of the page to its shorter side, e.g. `math.Sqrt2` for A4 or A5, 1.294 for
+    Letter (see `geometry.PageSize.Ratio`). No preference if 0.
+  - `Weight`: How much the shape costs, from 0 (ignored) to 1: the factor of a candidate is 1 for a convex
+    quadrilateral of the proportions of `Ratio`, down to `1 - Weight` for a candidate of no such shape.
+
+- **Methods**:
+  - `Convexity(contour geometry.Contour) float64`: Returns how well the four corners of `contour` (`FitQuadrilateral`)
+    cover its convex hull, from 0 to 1, like `Candidate.Validation`: 1 for a convex quadrilateral, lower for a
+    contour bulging out of its corners, e.g. the outline of a table broken around a cup.
+  - `Closeness(corners geometry.Contour) float64`: Returns how close the ratio of the sides of the quadrilateral of
+    `corners` (see `QuadrilateralSize`) is to `Ratio`, whatever its orientation, from 0 to 1: a Gaussian of the
+    logarithm of their quotient, of standard deviation `shapeTolerance`, so a ratio 10% off, e.g. by the perspective
+    of a photo, still gives 0.9, a square about 0.2 for an A4 `Ratio`. 0 if `corners` are not four.
+  - `Factor(contour geometry.Contour) float64`: Returns the factor the area of `contour` is ranked by,
+    `1 - Weight*(1 - Convexity*Closeness)`. Always 1 for a zero `PageShape`. The convex hull of the contour is
+    computed, for every candidate: a request only pays for it when it asks for a shape.
+
+### quadrilateralCoverage(contour geometry.Contour) (geometry.Contour, float64)
+Returns the four corners of `contour` fitted on its convex hull, and the fraction of the area of the hull they
+cover, 0 for a contour of no area.
+
+---
+
+### Constants
+- `shapeTolerance`: Standard deviation of the logarithm of the ratio of a page to that of `PageShape`.
+
+---
+
+### Example Usage:
+```go
+ranking := utils.CandidateRanking{Shape: utils.PageShape{Ratio: math.Sqrt2, Weight: 0.5}}
+document := ranking.Find(contours, utils.FindQuadrilateral)
+```
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"math"
+)
+
+const shapeTolerance = 0.2
+
+type PageShape struct {
+	Ratio  float64
+	Weight float64
+}
+
+func (shape PageShape) Convexity(contour geometry.Contour) float64 {
+	_, coverage := quadrilateralCoverage(contour)
+	return coverage
+}
+
+func (shape PageShape) Closeness(corners geometry.Contour) float64 {
+	width, height := QuadrilateralSize(corners)
+	if width == 0 || height == 0 || shape.Ratio <= 0 {
+		return 0
+	}
+	deviation := math.Log(float64(max(width, height)) / float64(min(width, height)) / shape.Ratio)
+	return math.Exp(-deviation * deviation / (2 * shapeTolerance * shapeTolerance))
+}
+
+func (shape PageShape) Factor(contour geometry.Contour) float64 {
+	if shape.Weight == 0 || shape.Ratio == 0 {
+		return 1
+	}
+	corners, coverage := quadrilateralCoverage(contour)
+	return max(1-shape.Weight*(1-coverage*shape.Closeness(corners)), 0)
+}
+
+func quadrilateralCoverage(contour geometry.Contour) (geometry.Contour, float64) {
+	hull := ConvexHull(contour)
+	hullArea := polygonArea(hull)
+	if hullArea == 0 {
+		return nil, 0
+	}
+	quadrilateral := FitQuadrilateral(hull)
+	return quadrilateral.Contour, min(quadrilateral.Area/hullArea, 1)
+}
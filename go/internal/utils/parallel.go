@@ -0,0 +1,49 @@
+package utils
+
+/*
+Package utils provides the split of the convolutions over several goroutines: a convolution computes every pixel of
+its output from the input alone, so the rows of the output can be computed apart, in stripes of consecutive rows,
+each by its own goroutine. The server already processes an image in chunks on its worker pools, one goroutine per
+chunk: it convolves with a single goroutine. The local runs of the edge detection on a whole image, like
+`cmd/app`, use all the cores this way (see `CannyParameters.Workers`).
+
+---
+
+### parallelRows(rows image.Rectangle, workers int, process func(minY, maxY int))
+Calls `process` on `workers` stripes of the rows of `rows`, from `minY` to `maxY` excluded, of about the same height,
+each in its own goroutine, and returns once all are done. With `workers` of 1 or less, or a single row, `process` is
+called once on all the rows, in the calling goroutine.
+
+---
+
+### Example Usage:
+```go
+blurred := utils.ApplyKernelFloatParallel(plane, utils.GaussianKernel(5, 1.4), runtime.NumCPU())
+magnitudes, angles := utils.ApplySobelFloatParallel(blurred, sobelX, sobelY, runtime.NumCPU())
+```
+*/
+
+import (
+	"image"
+	"sync"
+)
+
+func parallelRows(rows image.Rectangle, workers int, process func(minY, maxY int)) {
+	height := rows.Dy()
+	workers = min(workers, height)
+	if workers <= 1 {
+		process(rows.Min.Y, rows.Max.Y)
+		return
+	}
+
+	var wait sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		minY, maxY := rows.Min.Y+i*height/workers, rows.Min.Y+(i+1)*height/workers
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			process(minY, maxY)
+		}()
+	}
+	wait.Wait()
+}
@@ -0,0 +1,47 @@
+package utils
+
+/*
+This file tests the convolutions computed in stripes of rows: the blur, the gradients and the edges must be the same
+whatever the number of goroutines, even more goroutines than rows, and on an image not at the origin.
+*/
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestParallelConvolutions(t *testing.T) {
+	gray := image.NewGray(image.Rect(10, 20, 170, 140))
+	for y := 20; y < 140; y++ {
+		for x := 10; x < 170; x++ {
+			value := uint8(40 + (x*11+y*5)%13)
+			if x >= 50 && x < 130 && y >= 45 && y < 115 {
+				value = 220
+			}
+			gray.SetGray(x, y, color.Gray{Y: value})
+		}
+	}
+	plane := FloatFromGray(gray)
+	kernel := GaussianKernel(5, 1.4)
+	sobelX, sobelY := SobelKernels(3)
+	blurred := ApplyKernelFloat(plane, kernel)
+	magnitudes, angles := ApplySobelFloat(blurred, sobelX, sobelY)
+	edges, _ := ApplyCannyEdgeDetectionWith(gray, DefaultCannyParameters)
+
+	for _, workers := range []int{0, 2, 7, 500} {
+		if parallel := ApplyKernelFloatParallel(plane, kernel, workers); !reflect.DeepEqual(parallel, blurred) {
+			t.Errorf("blur computed by %d workers differs from the serial one", workers)
+		}
+		parallelMagnitudes, parallelAngles := ApplySobelFloatParallel(blurred, sobelX, sobelY, workers)
+		if !reflect.DeepEqual(parallelMagnitudes, magnitudes) || !reflect.DeepEqual(parallelAngles, angles) {
+			t.Errorf("gradients computed by %d workers differ from the serial ones", workers)
+		}
+		parameters := DefaultCannyParameters
+		parameters.Workers = workers
+		if parallel, _ := ApplyCannyEdgeDetectionWith(gray, parameters); !reflect.DeepEqual(parallel.Pix, edges.Pix) {
+			t.Errorf("edges detected by %d workers differ from the serial ones", workers)
+		}
+	}
+}
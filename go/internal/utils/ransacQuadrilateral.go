@@ -0,0 +1,292 @@
+package utils
+
+/*
+Package utils provides the fitting of the four sides of a document on its contour by RANSAC (random sample
+consensus). `FitQuadrilateral` keeps points of the contour as corners, so a rounded corner is cut, and a corner hidden
+by a hand or a clip is replaced by the outline of the occluding object. The sides are fitted as straight lines
+instead: the line through two points picked at random is scored by the number of points of the contour within
+`ransacTolerance` of it, the best of `ransacIterations` samples is kept, and the noise of the contour, the points of
+the rounded corners and those of the occluding objects, on no line, are simply outvoted. The corners are the
+intersections of the four lines, even when they are not on the contour.
+
+---
+
+### FitQuadrilateralRANSAC(contour geometry.Contour, bounds image.Rectangle) geometry.ContourWithArea
+Finds the four corners of a contour shaped like a quadrilateral, e.g. a photographed document, as the intersections
+of its four sides, with the area of the quadrilateral. The corners are in the order of `FitQuadrilateral`.
+
+- **Behavior**:
+  - Only the outermost points of the contour are fitted (see `outermostPoints`): the lines of text of a page
+    touching its outline, once the edges are closed, would otherwise make lines as long as its sides.
+  - More than `ransacMaxPoints` points are subsampled, keeping one point out of several.
+  - The four sides are found one after the other by `ransacSide`, each among the points left by the previous ones,
+    so a long side does not hide a shorter one. A side must be supported by `ransacMinInliers` of the points. An
+    occluding object with a straight edge longer than the visible part of a side still wins over it: the Hough
+    transform of the whole edge map (`FindHoughQuadrilateral`) handles that case better.
+  - The sides are sorted by the angle of the center of their points around the center of the contour, clockwise on
+    the screen, and each corner is the intersection of two consecutive sides. Two consecutive sides must make an
+    angle of at least `ransacMinAngle`.
+  - The corners within `ransacOutside` of `bounds`, e.g. the corner of a page cut by the frame, are moved onto its
+    border.
+  - The random samples are drawn from a fixed seed, so the same contour always gives the same corners.
+  - Returns an empty `geometry.ContourWithArea` if no such quadrilateral is found, e.g. for a disk or a contour of
+    fewer than four sides, if a corner lies farther outside `bounds`, or if the quadrilateral is not convex.
+
+### ransacSide(points geometry.Contour, sides []HoughLine, random *rand.Rand) (HoughLine, float64, float64)
+Returns the line of `points` supported by the most points, its `Votes`, and the center of these points. The line is
+refined once by a least squares fit on the points of the best sample, so it does not depend on the two points drawn.
+The lines within `houghAngleWindow` and `houghDistanceWindow` of one of `sides`, found already, are skipped: the
+points of a side a little farther than `ransacTolerance` from its line must not make a fifth side.
+
+### outermostPoints(contour geometry.Contour) geometry.Contour
+Returns the points of `contour` seen from outside its bounding box: the first and the last points of each of its
+rows and of each of its columns, in the order of `contour`. For a page, its sides and the outline of the objects
+hiding it, not what lies within.
+
+### lineThrough(a, b geometry.Point) HoughLine
+Returns the line through two distinct points, as a `HoughLine` without votes.
+
+### fitLine(points geometry.Contour) HoughLine
+Returns the least squares line of `points`: the line through their center along their principal axis, minimizing
+the sum of the squared distances of the points to it.
+
+### normalizeLine(cos, sin, rho float64) HoughLine
+Returns the line of the normal `(cos, sin)` at the distance `rho` of the origin, its angle brought back between 0
+and π.
+
+---
+
+### Constants
+- `ransacIterations`: Number of random samples drawn for each side.
+- `ransacTolerance`: Largest distance of a point to a line it supports, in pixels.
+- `ransacMinSpan`: Smallest distance between the two points of a sample, in pixels: closer points give an unstable
+  direction.
+- `ransacMinInliers`: Fraction of the points of the contour a side must be supported by. A disk of more than about
+  80 pixels of radius has no side.
+- `ransacMaxPoints`: Number of points of the contour the lines are fitted on at most.
+- `ransacMinAngle`: Smallest angle between two consecutive sides, in radians (30°).
+- `ransacOutside`: Fraction of the side of `bounds` a corner may lie outside of it.
+- `ransacSeed`: Seed of the random samples.
+
+---
+
+### Example Usage:
+```go
+document := utils.FindQuadrilateral(contours)
+if fitted := utils.FitQuadrilateralRANSAC(document.Contour, gray.Bounds()); fitted.Area > 0 {
+	cropped := utils.WarpQuadrilateral(img, fitted.Contour)
+}
+```
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"cmp"
+	"image"
+	"math"
+	"math/rand/v2"
+	"slices"
+)
+
+const (
+	ransacIterations = 256
+	ransacTolerance  = 2.5
+	ransacMinSpan    = 10
+	ransacMinInliers = 0.08
+	ransacMaxPoints  = 4096
+	ransacMinAngle   = math.Pi / 6
+	ransacOutside    = 0.03
+	ransacSeed       = 0x5eed
+)
+
+func FitQuadrilateralRANSAC(contour geometry.Contour, bounds image.Rectangle) geometry.ContourWithArea {
+	points := outermostPoints(contour)
+	if len(points) > ransacMaxPoints {
+		step := (len(points) + ransacMaxPoints - 1) / ransacMaxPoints
+		sampled := make(geometry.Contour, 0, ransacMaxPoints)
+		for i := 0; i < len(points); i += step {
+			sampled = append(sampled, points[i])
+		}
+		points = sampled
+	}
+	if len(points) < 8 {
+		return geometry.ContourWithArea{}
+	}
+
+	var centerX, centerY float64
+	for _, point := range points {
+		centerX += float64(point.X)
+		centerY += float64(point.Y)
+	}
+	centerX /= float64(len(points))
+	centerY /= float64(len(points))
+
+	type side struct {
+		line  HoughLine
+		angle float64
+	}
+	random := rand.New(rand.NewPCG(ransacSeed, uint64(len(points))))
+	minInliers := int(math.Ceil(ransacMinInliers * float64(len(points))))
+	remaining := slices.Clone(points)
+	sides := make([]side, 0, 4)
+	lines := make([]HoughLine, 0, 4)
+	for len(sides) < 4 {
+		line, sideX, sideY := ransacSide(remaining, lines, random)
+		if line.Votes < minInliers {
+			return geometry.ContourWithArea{}
+		}
+		sides = append(sides, side{line: line, angle: math.Atan2(sideY-centerY, sideX-centerX)})
+		lines = append(lines, line)
+		remaining = slices.DeleteFunc(remaining, func(point geometry.Point) bool {
+			return line.distance(point) <= ransacTolerance
+		})
+	}
+	slices.SortFunc(sides, func(a, b side) int {
+		return cmp.Compare(a.angle, b.angle)
+	})
+
+	corners := make(geometry.Contour, 0, 4)
+	outside := ransacOutside * float64(max(bounds.Dx(), bounds.Dy()))
+	for i, current := range sides {
+		next := sides[(i+1)%len(sides)].line
+		skew := math.Abs(current.line.Theta - next.Theta)
+		if min(skew, math.Pi-skew) < ransacMinAngle {
+			return geometry.ContourWithArea{}
+		}
+		corner, ok := current.line.intersect(next)
+		if !ok {
+			return geometry.ContourWithArea{}
+		}
+		if float64(bounds.Min.X-corner.X) > outside || float64(corner.X-bounds.Max.X+1) > outside ||
+			float64(bounds.Min.Y-corner.Y) > outside || float64(corner.Y-bounds.Max.Y+1) > outside {
+			return geometry.ContourWithArea{}
+		}
+		corner.X = min(max(corner.X, bounds.Min.X), bounds.Max.X-1)
+		corner.Y = min(max(corner.Y, bounds.Min.Y), bounds.Max.Y-1)
+		corners = append(corners, corner)
+	}
+	if !convex(corners) {
+		return geometry.ContourWithArea{}
+	}
+	corners = topLeftFirst(corners)
+	return geometry.ContourWithArea{Contour: corners, Area: polygonArea(corners)}
+}
+
+func ransacSide(points geometry.Contour, sides []HoughLine, random *rand.Rand) (HoughLine, float64, float64) {
+	var best HoughLine
+	for range ransacIterations {
+		if len(points) < 2 {
+			break
+		}
+		a, b := points[random.IntN(len(points))], points[random.IntN(len(points))]
+		if dx, dy := b.X-a.X, b.Y-a.Y; dx*dx+dy*dy < ransacMinSpan*ransacMinSpan {
+			continue
+		}
+		line := lineThrough(a, b)
+		if slices.ContainsFunc(sides, line.same) {
+			continue
+		}
+		for _, point := range points {
+			if line.distance(point) <= ransacTolerance {
+				line.Votes++
+			}
+		}
+		if line.Votes > best.Votes {
+			best = line
+		}
+	}
+	if best.Votes == 0 {
+		return best, 0, 0
+	}
+
+	inliers := make(geometry.Contour, 0, best.Votes)
+	for _, point := range points {
+		if best.distance(point) <= ransacTolerance {
+			inliers = append(inliers, point)
+		}
+	}
+	refined := fitLine(inliers)
+	for _, point := range points {
+		if refined.distance(point) <= ransacTolerance {
+			refined.Votes++
+		}
+	}
+	if refined.Votes >= best.Votes {
+		best = refined
+	}
+
+	var centerX, centerY float64
+	for _, point := range points {
+		if best.distance(point) <= ransacTolerance {
+			centerX += float64(point.X)
+			centerY += float64(point.Y)
+		}
+	}
+	return best, centerX / float64(best.Votes), centerY / float64(best.Votes)
+}
+
+func outermostPoints(contour geometry.Contour) geometry.Contour {
+	box := contourBox(contour)
+	left, right := make([]int, box.Dy()), make([]int, box.Dy())
+	top, bottom := make([]int, box.Dx()), make([]int, box.Dx())
+	for i := range left {
+		left[i], right[i] = box.Max.X, box.Min.X-1
+	}
+	for i := range top {
+		top[i], bottom[i] = box.Max.Y, box.Min.Y-1
+	}
+	for _, point := range contour {
+		row, column := point.Y-box.Min.Y, point.X-box.Min.X
+		left[row], right[row] = min(left[row], point.X), max(right[row], point.X)
+		top[column], bottom[column] = min(top[column], point.Y), max(bottom[column], point.Y)
+	}
+
+	var outermost geometry.Contour
+	for _, point := range contour {
+		row, column := point.Y-box.Min.Y, point.X-box.Min.X
+		if point.X == left[row] || point.X == right[row] || point.Y == top[column] || point.Y == bottom[column] {
+			outermost = append(outermost, point)
+		}
+	}
+	return outermost
+}
+
+func lineThrough(a, b geometry.Point) HoughLine {
+	dx, dy := float64(b.X-a.X), float64(b.Y-a.Y)
+	length := math.Hypot(dx, dy)
+	cos, sin := -dy/length, dx/length
+	return normalizeLine(cos, sin, cos*float64(a.X)+sin*float64(a.Y))
+}
+
+func fitLine(points geometry.Contour) HoughLine {
+	var centerX, centerY float64
+	for _, point := range points {
+		centerX += float64(point.X)
+		centerY += float64(point.Y)
+	}
+	centerX /= float64(len(points))
+	centerY /= float64(len(points))
+
+	var xx, yy, xy float64
+	for _, point := range points {
+		dx, dy := float64(point.X)-centerX, float64(point.Y)-centerY
+		xx += dx * dx
+		yy += dy * dy
+		xy += dx * dy
+	}
+	// The normal of the line is perpendicular to the principal axis of the points.
+	theta := math.Atan2(2*xy, xx-yy)/2 + math.Pi/2
+	cos, sin := math.Cos(theta), math.Sin(theta)
+	return normalizeLine(cos, sin, cos*centerX+sin*centerY)
+}
+
+func normalizeLine(cos, sin, rho float64) HoughLine {
+	theta := math.Atan2(sin, cos)
+	if theta < 0 {
+		theta, rho = theta+math.Pi, -rho
+	}
+	if theta >= math.Pi {
+		theta, rho = theta-math.Pi, -rho
+	}
+	return HoughLine{Rho: rho, Theta: theta}
+}
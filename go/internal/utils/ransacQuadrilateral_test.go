@@ -0,0 +1,74 @@
+package utils
+
+/*
+This file tests the RANSAC fitting of the sides of a tilted page with rounded corners, one of them hidden by an
+occluding object, and of a disk, which has no side.
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"image"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestFitQuadrilateralRANSAC(t *testing.T) {
+	// Outline of a page of 220x300 pixels with corners rounded over 12 pixels, except the bottom-right one, hidden
+	// by an object covering its last 40x60 pixels.
+	const width, height, radius = 220.0, 300.0, 12.0
+	var local [][2]float64
+	for _, corner := range [][3]float64{{radius, radius, math.Pi}, {width - radius, radius, 1.5 * math.Pi}} {
+		for step := 0; step <= 4; step++ {
+			angle := corner[2] + float64(step)*math.Pi/8
+			local = append(local, [2]float64{corner[0] + radius*math.Cos(angle), corner[1] + radius*math.Sin(angle)})
+		}
+	}
+	local = append(local, [2]float64{width, height - 60}, [2]float64{width - 40, height - 60}, [2]float64{width - 40, height})
+	for step := 0; step <= 4; step++ {
+		angle := math.Pi/2 + float64(step)*math.Pi/8
+		local = append(local, [2]float64{radius + radius*math.Cos(angle), height - radius + radius*math.Sin(angle)})
+	}
+
+	angle := 20 * math.Pi / 180
+	place := func(x, y float64) geometry.Point {
+		x, y = x-width/2, y-height/2
+		return geometry.Point{
+			X: int(math.Round(200 + x*math.Cos(angle) - y*math.Sin(angle))),
+			Y: int(math.Round(200 + x*math.Sin(angle) + y*math.Cos(angle))),
+		}
+	}
+	polygon := make(geometry.Contour, 0, len(local))
+	for _, point := range local {
+		polygon = append(polygon, place(point[0], point[1]))
+	}
+	outline := outlinePolygon(polygon)
+
+	bounds := image.Rect(0, 0, 400, 400)
+	fitted := FitQuadrilateralRANSAC(outline, bounds)
+	expected := geometry.Contour{place(0, 0), place(width, 0), place(width, height), place(0, height)}
+	expected = topLeftFirst(expected)
+	if len(fitted.Contour) != 4 {
+		t.Fatalf("fitted %v, expected the corners %v", fitted.Contour, expected)
+	}
+	for i, corner := range fitted.Contour {
+		if math.Abs(float64(corner.X-expected[i].X)) > 3 || math.Abs(float64(corner.Y-expected[i].Y)) > 3 {
+			t.Errorf("corner %d at %v, expected %v", i, corner, expected[i])
+		}
+	}
+	if area := width * height; math.Abs(fitted.Area-area) > 0.03*area {
+		t.Errorf("area %.0f, expected about %.0f", fitted.Area, area)
+	}
+	if again := FitQuadrilateralRANSAC(outline, bounds); !reflect.DeepEqual(again, fitted) {
+		t.Errorf("fitted %v the second time, expected the same corners %v", again.Contour, fitted.Contour)
+	}
+
+	var disk geometry.Contour
+	for step := 0; step < 720; step++ {
+		angle := float64(step) * math.Pi / 360
+		disk = append(disk, geometry.Point{X: int(math.Round(200 + 150*math.Cos(angle))), Y: int(math.Round(200 + 150*math.Sin(angle)))})
+	}
+	if fitted := FitQuadrilateralRANSAC(disk, bounds); fitted.Area != 0 {
+		t.Errorf("disk fitted as %v", fitted.Contour)
+	}
+}
@@ -0,0 +1,220 @@
+package utils
+
+/*
+Package utils provides the detection and the crop of narrow documents such as thermal receipts, whose outline is a
+long strip rather than a page: the document is the contour with the largest convex hull, and it is cropped along the
+smallest rotated rectangle bounding that hull, so a tilted strip comes out upright without the background around it.
+
+---
+
+### RotatedRect
+Rectangle of any orientation, in the coordinates of the image.
+
+- **Fields**:
+  - `CenterX`, `CenterY`: The center of the rectangle.
+  - `Width`, `Height`: The lengths of its sides, `Height` being the longer one: a receipt is tall.
+  - `Angle`: The angle in radians between the X axis and the side of length `Width`, in (-π/2, π/2], so the side of
+    length `Height` points down the image.
+
+### (rect RotatedRect) Corners() geometry.Contour
+Returns the top-left, top-right, bottom-right and bottom-left corners of the rectangle once upright, rounded to the
+nearest pixel.
+
+---
+
+### ConvexHull(points geometry.Contour) geometry.Contour
+Returns the convex hull of a set of points, clockwise on the screen (the Y axis of the image points down),
+starting from the leftmost point (the topmost of them on a tie). Collinear points are dropped, so a single point or
+a segment gives one or two points.
+
+- **Behavior**:
+  - Sorts the points and builds the lower and upper chains of the hull (Andrew's monotone chain).
+
+### FindLargestHull(contours []geometry.Contour) geometry.ContourWithArea
+Identifies the document in a set of contours as the contour whose convex hull is the largest, e.g. a receipt, or
+a whiteboard whose strokes would outrank its frame by the area of their outline.
+
+- **Returns**:
+  - `geometry.ContourWithArea`: The convex hull of that contour and its area, an empty contour with a zero area if
+    there is none.
+
+- **Behavior**:
+  - The area of the hull does not depend on the order in which the contour was traversed, nor on the gaps of its
+    border, which makes the thin outline of a narrow strip compare fairly with the outlines of the background.
+
+### MinAreaRect(contour geometry.Contour) RotatedRect
+Returns the rotated rectangle of smallest area bounding a contour.
+
+- **Behavior**:
+  - Computes the convex hull of the contour. One side of the smallest rectangle lies on a side of the hull (rotating
+    calipers), so every side of the hull is tried: the hull is projected on that side and on its normal.
+  - Costs the square of the number of points of the hull, a few dozens for the outline of a document.
+  - An empty contour gives a zero rectangle.
+
+### CropRotatedRect(img image.Image, rect RotatedRect, width, height int) *image.RGBA
+Samples the content of `rect` into an upright image of `width` x `height` pixels, whose bounds start at (0, 0).
+
+- **Behavior**:
+  - Every output pixel is interpolated bilinearly between the four source pixels around its position in the
+    rectangle (see `imageUtils.Sample`), so the crop can also scale the document up or down in a single resampling.
+  - An empty image is returned if the source image or the requested size is empty.
+
+---
+
+### Example Usage:
+```go
+receipt := utils.FindLargestHull(contours)
+rect := utils.MinAreaRect(receipt.Contour)
+upright := utils.CropRotatedRect(img, rect, int(math.Round(rect.Width)), int(math.Round(rect.Height)))
+```
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"ELP-project/internal/imageUtils"
+	"cmp"
+	"image"
+	"image/draw"
+	"math"
+	"slices"
+)
+
+type RotatedRect struct {
+	CenterX, CenterY float64
+	Width, Height    float64
+	Angle            float64
+}
+
+func (rect RotatedRect) Corners() geometry.Contour {
+	cos, sin := math.Cos(rect.Angle), math.Sin(rect.Angle)
+	corner := func(u, v float64) geometry.Point {
+		return geometry.Point{
+			X: int(math.Round(rect.CenterX + u*rect.Width/2*cos - v*rect.Height/2*sin)),
+			Y: int(math.Round(rect.CenterY + u*rect.Width/2*sin + v*rect.Height/2*cos)),
+		}
+	}
+	return geometry.Contour{corner(-1, -1), corner(1, -1), corner(1, 1), corner(-1, 1)}
+}
+
+func ConvexHull(points geometry.Contour) geometry.Contour {
+	sorted := slices.Clone(points)
+	slices.SortFunc(sorted, func(a, b geometry.Point) int {
+		return cmp.Or(cmp.Compare(a.X, b.X), cmp.Compare(a.Y, b.Y))
+	})
+	sorted = slices.Compact(sorted)
+	if len(sorted) < 3 {
+		return sorted
+	}
+
+	cross := func(origin, a, b geometry.Point) int {
+		return (a.X-origin.X)*(b.Y-origin.Y) - (a.Y-origin.Y)*(b.X-origin.X)
+	}
+	hull := make(geometry.Contour, 0, 2*len(sorted))
+	for _, point := range sorted {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], point) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, point)
+	}
+	lower := len(hull) + 1
+	for i := len(sorted) - 2; i >= 0; i-- {
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], sorted[i]) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, sorted[i])
+	}
+	return hull[:len(hull)-1]
+}
+
+func FindLargestHull(contours []geometry.Contour) geometry.ContourWithArea {
+	var best geometry.ContourWithArea
+	for _, contour := range contours {
+		hull := ConvexHull(contour)
+		if area := polygonArea(hull); area > best.Area {
+			best = geometry.ContourWithArea{Contour: hull, Area: area}
+		}
+	}
+	return best
+}
+
+func MinAreaRect(contour geometry.Contour) RotatedRect {
+	hull := ConvexHull(contour)
+	if len(hull) == 0 {
+		return RotatedRect{}
+	}
+	if len(hull) == 1 {
+		return RotatedRect{CenterX: float64(hull[0].X), CenterY: float64(hull[0].Y)}
+	}
+
+	var best RotatedRect
+	bestArea := math.Inf(1)
+	for i, start := range hull {
+		end := hull[(i+1)%len(hull)]
+		edgeX, edgeY := float64(end.X-start.X), float64(end.Y-start.Y)
+		length := math.Hypot(edgeX, edgeY)
+		ux, uy := edgeX/length, edgeY/length
+
+		minU, maxU, minV, maxV := math.Inf(1), math.Inf(-1), math.Inf(1), math.Inf(-1)
+		for _, point := range hull {
+			x, y := float64(point.X-start.X), float64(point.Y-start.Y)
+			u, v := x*ux+y*uy, -x*uy+y*ux
+			minU, maxU = min(minU, u), max(maxU, u)
+			minV, maxV = min(minV, v), max(maxV, v)
+		}
+
+		if area := (maxU - minU) * (maxV - minV); area < bestArea {
+			bestArea = area
+			u, v := (minU+maxU)/2, (minV+maxV)/2
+			best = RotatedRect{
+				CenterX: float64(start.X) + u*ux - v*uy,
+				CenterY: float64(start.Y) + u*uy + v*ux,
+				Width:   maxU - minU,
+				Height:  maxV - minV,
+				Angle:   math.Atan2(uy, ux),
+			}
+		}
+	}
+
+	if best.Width > best.Height {
+		best.Width, best.Height = best.Height, best.Width
+		best.Angle += math.Pi / 2
+	}
+	for best.Angle > math.Pi/2 {
+		best.Angle -= math.Pi
+	}
+	for best.Angle <= -math.Pi/2 {
+		best.Angle += math.Pi
+	}
+	return best
+}
+
+func CropRotatedRect(img image.Image, rect RotatedRect, width, height int) *image.RGBA {
+	output := image.NewRGBA(image.Rect(0, 0, max(width, 0), max(height, 0)))
+
+	bounds := img.Bounds()
+	if bounds.Empty() || width <= 0 || height <= 0 {
+		return output
+	}
+
+	source, ok := img.(*image.RGBA)
+	if !ok {
+		source = image.NewRGBA(bounds)
+		draw.Draw(source, bounds, img, bounds.Min, draw.Src)
+	}
+
+	cos, sin := math.Cos(rect.Angle), math.Sin(rect.Angle)
+	stepU, stepV := rect.Width/float64(width), rect.Height/float64(height)
+
+	for y := 0; y < height; y++ {
+		v := (float64(y)+0.5)*stepV - rect.Height/2
+		row := output.Pix[output.PixOffset(0, y):output.PixOffset(width, y)]
+		for x := 0; x < width; x++ {
+			u := (float64(x)+0.5)*stepU - rect.Width/2
+			sourceX := rect.CenterX + u*cos - v*sin
+			sourceY := rect.CenterY + u*sin + v*cos
+			imageUtils.Sample(source, sourceX, sourceY, imageUtils.Bilinear, row[4*x:4*x+4])
+		}
+	}
+
+	return output
+}
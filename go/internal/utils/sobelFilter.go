@@ -33,22 +33,6 @@ Normalizes a Sobel kernel so that the sum of its absolute values equals 1.
 
 ---
 
-### ComputeDynamicThresholds(img *image.Gray, alpha float64) (float64, float64)
-Calculates dynamic thresholds for edge detection based on image gradients.
-
-- **Parameters**:
-  - img: A grayscale image (`*image.Gray`).
-  - alpha: A multiplier for the high threshold.
-- **Returns**:
-  - lowThreshold: The lower bound for edge detection.
-  - highThreshold: The upper bound for edge detection.
-- **Behavior**:
-  - Applies a 5x5 Sobel filter to compute the gradient magnitude of the image.
-  - Calculates the average gradient magnitude and sets `highThreshold` as `alpha * meanGradient`.
-  - `lowThreshold` is set to 40% of `highThreshold`.
-
----
-
 ### ApplySobelEdgeDetection(img *image.Gray, kernelX, kernelY [][]float64) (*image.Gray, [][]float64)
 Applies a Sobel edge detection filter to a grayscale image.
 
@@ -67,13 +51,38 @@ Applies a Sobel edge detection filter to a grayscale image.
 
 ---
 
+### ApplySobelFloat(img *FloatImage, kernelX, kernelY [][]float64) (*FloatImage, *FloatImage)
+Same as `ApplySobelEdgeDetection` on a plane of floating point values, the computation done by the edge detection.
+Returns the magnitude of the gradient, clamped to 255 but not rounded, and its angle in degrees, both as planes of
+the bounds of `img`, 0 on the border the kernels do not fit in.
+
+---
+
+### ApplySobelEdgeDetectionParallel(img *image.Gray, kernelX, kernelY [][]float64, workers int) (*image.Gray, [][]float64)
+Same as `ApplySobelEdgeDetection`, the rows of the gradient being computed by `workers` goroutines, in stripes (see
+`parallelRows`). The result is the same whatever the number of workers.
+
+---
+
+### ApplySobelFloatParallel(img *FloatImage, kernelX, kernelY [][]float64, workers int) (*FloatImage, *FloatImage)
+Same as `ApplySobelFloat`, computed by `workers` goroutines like `ApplySobelEdgeDetectionParallel`.
+
+---
+
+### applySobelRows(img, magnitudes, angles *FloatImage, kernelX, kernelY [][]float64, minY, maxY int)
+Writes the gradient of `img` into the rows of `magnitudes` and `angles` from `minY` to `maxY` excluded, which the
+kernels fit in.
+
+---
+
 ### Key Features:
 - **Dynamic Kernel Generation**:
   - Easily customize Sobel kernels to adapt to specific image resolutions and requirements.
 - **Edge Detection**:
   - Apply Sobel filters to highlight edges of various orientations.
 - **Thresholding**:
-  - Dynamically computed thresholds allow robust edge detection independent of image intensity.
+  - The histogram of the gradient magnitudes gives the thresholds of the edge detection, independent of the image
+    intensity (see `GradientStats`).
 
 ---
 
@@ -110,7 +119,6 @@ func main() {
 
 import (
 	"image"
-	"image/color"
 	"math"
 )
 
@@ -186,49 +194,56 @@ func normalizeKernel(kernel [][]float64) {
 	}
 }
 
-func ComputeDynamicThresholds(img *image.Gray, alpha float64) (float64, float64) {
-	bounds := img.Bounds()
-	totalGradient := 0.0
-	count := 0
+func ApplySobelEdgeDetection(img *image.Gray, kernelX, kernelY [][]float64) (*image.Gray, [][]float64) {
+	return ApplySobelEdgeDetectionParallel(img, kernelX, kernelY, 1)
+}
 
-	sobelX, sobelY := GenerateSobelKernel(5)
-	gradient, _ := ApplySobelEdgeDetection(img, sobelX, sobelY)
+func ApplySobelEdgeDetectionParallel(img *image.Gray, kernelX, kernelY [][]float64, workers int) (*image.Gray, [][]float64) {
+	bounds := img.Bounds()
+	magnitudes, angles := ApplySobelFloatParallel(FloatFromGray(img), kernelX, kernelY, workers)
+	gradientAngles := make([][]float64, bounds.Max.Y)
 
-	for y := bounds.Min.Y + 1; y < bounds.Max.Y-1; y++ {
-		for x := bounds.Min.X + 1; x < bounds.Max.X-1; x++ {
-			totalGradient += float64(gradient.GrayAt(x, y).Y)
-			count++
+	for i := range gradientAngles {
+		gradientAngles[i] = make([]float64, bounds.Max.X)
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gradientAngles[y][x] = float64(angles.FloatAt(x, y))
 		}
 	}
 
-	meanGradient := totalGradient / float64(count)
-
-	highThreshold := alpha * meanGradient
-	lowThreshold := 0.4 * highThreshold
+	return magnitudes.Gray(), gradientAngles
+}
 
-	return lowThreshold, highThreshold
+func ApplySobelFloat(img *FloatImage, kernelX, kernelY [][]float64) (*FloatImage, *FloatImage) {
+	return ApplySobelFloatParallel(img, kernelX, kernelY, 1)
 }
 
-func ApplySobelEdgeDetection(img *image.Gray, kernelX, kernelY [][]float64) (*image.Gray, [][]float64) {
+func ApplySobelFloatParallel(img *FloatImage, kernelX, kernelY [][]float64, workers int) (*FloatImage, *FloatImage) {
 	bounds := img.Bounds()
-	output := image.NewGray(bounds)
-	gradientAngles := make([][]float64, bounds.Max.Y)
+	magnitudes := NewFloatImage(bounds)
+	angles := NewFloatImage(bounds)
 	radius := len(kernelX) / 2
 
-	for i := range gradientAngles {
-		gradientAngles[i] = make([]float64, bounds.Max.X)
-	}
+	interior := bounds.Inset(radius)
+	parallelRows(interior, workers, func(minY, maxY int) {
+		applySobelRows(img, magnitudes, angles, kernelX, kernelY, minY, maxY)
+	})
+	return magnitudes, angles
+}
 
-	for y := bounds.Min.Y + radius; y < bounds.Max.Y-radius; y++ {
+func applySobelRows(img, magnitudes, angles *FloatImage, kernelX, kernelY [][]float64, minY, maxY int) {
+	bounds := img.Bounds()
+	radius := len(kernelX) / 2
+
+	for y := minY; y < maxY; y++ {
 		for x := bounds.Min.X + radius; x < bounds.Max.X-radius; x++ {
 			var gx, gy float64
 
 			for ky := -radius; ky <= radius; ky++ {
+				row := img.Pix[img.PixOffset(x-radius, y+ky):]
 				for kx := -radius; kx <= radius; kx++ {
-					px := x + kx
-					py := y + ky
-
-					gray := float64(img.GrayAt(px, py).Y)
+					gray := float64(row[kx+radius])
 					gx += gray * kernelX[ky+radius][kx+radius]
 					gy += gray * kernelY[ky+radius][kx+radius]
 				}
@@ -237,10 +252,8 @@ func ApplySobelEdgeDetection(img *image.Gray, kernelX, kernelY [][]float64) (*im
 			magnitude := math.Sqrt(gx*gx + gy*gy)
 			angle := math.Atan2(gy, gx) * (180 / math.Pi)
 
-			output.SetGray(x, y, color.Gray{Y: uint8(math.Min(magnitude, 255))})
-			gradientAngles[y][x] = angle
+			magnitudes.Pix[magnitudes.PixOffset(x, y)] = float32(math.Min(magnitude, 255))
+			angles.Pix[angles.PixOffset(x, y)] = float32(angle)
 		}
 	}
-
-	return output, gradientAngles
 }
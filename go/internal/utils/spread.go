@@ -0,0 +1,160 @@
+package utils
+
+/*
+Package utils provides the detection of the gutter of a double-page spread: an open book photographed flat shows two
+pages side by side, and its binding, where the paper curves down between them, casts a shadow along the middle of the
+spread. The shadow is found in the profile of the columns of the spread, their mean brightness: the paper of both
+pages is about as bright from one column to the next, the text lines spread evenly over them, and the gutter is a
+valley of darker columns near the middle.
+
+---
+
+### FindGutter(img image.Image) (int, bool)
+Returns the column of the gutter of `img`, the spread warped upright, and whether `img` is a spread at all.
+
+- **Behavior**:
+  - A spread of two upright pages is wider than high: an image less than `minSpreadAspect` times wider than high is
+    a single page.
+  - The profile is the mean brightness of every column, without the `gutterMargin` of the rows at the top and at the
+    bottom, where the corners of a curved spread are often cut. It is measured on `gutterBands` bands of rows, and
+    smoothed over about half a percent of the width, so a thin dark stroke of the text does not make a valley.
+  - The gutter is the darkest column of the `gutterBand` of the width around the middle. It is only a gutter if it is
+    darker than the paper (see `paperBrightness`) by `gutterContrast`, if the profile rises back to the paper on both
+    sides of it within `gutterMargin` of the width, and if it is as dark in three quarters of the bands at least,
+    compared with the paper of each band: the shadow of the binding runs along the whole height of the spread, where a
+    dark figure in the middle of a single page, lighter on both sides, covers a few bands only.
+
+### columnProfile(gray *image.Gray, top, bottom int) []float64
+Returns the mean gray level of every column of `gray`, over its rows from `top` to `bottom`, excluded.
+
+### paperBrightness(profile []float64) float64
+Returns the brightness of the paper of a profile: the median of its columns of the middle half of the spread, away
+from its border and its background.
+
+### smoothProfile(profile []float64, radius int) []float64
+Returns the mean of every value of `profile` with its `radius` neighbors on both sides, those inside `profile`.
+
+---
+
+### Constants
+- `minSpreadAspect`: Smallest ratio of the width of a spread to its height. Two A-series pages side by side make about
+  1.41, a spread cut short by the perspective a bit less.
+- `gutterBand`: Width of the band the gutter is searched in, as a fraction of the width, centered on the middle: the
+  pages of an open book are rarely warped to the same width.
+- `gutterContrast`: Darkening of the gutter under the paper, as a fraction of the brightness of the paper: the text
+  lines darken the columns of a page by a tenth at most, the shadow of a binding by a third or more.
+- `gutterMargin`: Fraction of the height left out of the profile at the top and at the bottom, and of the width the
+  profile rises back to the paper within on both sides of the gutter.
+- `gutterBands`: Number of the bands of rows the gutter is checked on.
+
+---
+
+### Example Usage:
+```go
+if gutter, ok := utils.FindGutter(warped); ok {
+	left := warped.SubImage(image.Rect(warped.Rect.Min.X, warped.Rect.Min.Y, gutter, warped.Rect.Max.Y))
+	right := warped.SubImage(image.Rect(gutter, warped.Rect.Min.Y, warped.Rect.Max.X, warped.Rect.Max.Y))
+	pages = append(pages, left, right)
+}
+```
+*/
+
+import (
+	"ELP-project/internal/imageUtils"
+	"image"
+	"slices"
+)
+
+const (
+	minSpreadAspect = 1.15
+	gutterBand      = 0.2
+	gutterContrast  = 0.2
+	gutterMargin    = 0.1
+	gutterBands     = 8
+)
+
+func FindGutter(img image.Image) (int, bool) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if height == 0 || float64(width) < minSpreadAspect*float64(height) {
+		return 0, false
+	}
+
+	gray := imageUtils.Grayscale(img)
+	margin := int(gutterMargin * float64(height))
+	bands := make([][]float64, gutterBands)
+	profile := make([]float64, width)
+	for i := range bands {
+		top := bounds.Min.Y + margin + i*(height-2*margin)/gutterBands
+		bottom := bounds.Min.Y + margin + (i+1)*(height-2*margin)/gutterBands
+		bands[i] = smoothProfile(columnProfile(gray, top, bottom), max(1, width/200))
+		for x, value := range bands[i] {
+			profile[x] += value / gutterBands
+		}
+	}
+
+	from, to := int(float64(width)*(0.5-gutterBand/2)), int(float64(width)*(0.5+gutterBand/2))
+	gutter := from
+	for x := from; x < to; x++ {
+		if profile[x] < profile[gutter] {
+			gutter = x
+		}
+	}
+
+	paper := paperBrightness(profile)
+	if profile[gutter] > (1-gutterContrast)*paper {
+		return 0, false
+	}
+
+	span := max(1, int(gutterMargin*float64(width)))
+	left := slices.Max(profile[max(gutter-span, 0):gutter])
+	right := slices.Max(profile[gutter+1 : min(gutter+1+span, width)])
+	if min(left, right) < (1-gutterContrast/2)*paper {
+		return 0, false
+	}
+
+	dark := 0
+	for _, band := range bands {
+		if band[gutter] <= (1-gutterContrast)*paperBrightness(band) {
+			dark++
+		}
+	}
+	if dark < gutterBands*3/4 {
+		return 0, false
+	}
+	return bounds.Min.X + gutter, true
+}
+
+func columnProfile(gray *image.Gray, top, bottom int) []float64 {
+	bounds := gray.Bounds()
+	profile := make([]float64, bounds.Dx())
+	for y := top; y < bottom; y++ {
+		row := gray.Pix[gray.PixOffset(bounds.Min.X, y):]
+		for x := range profile {
+			profile[x] += float64(row[x])
+		}
+	}
+	for x := range profile {
+		profile[x] /= float64(max(bottom-top, 1))
+	}
+	return profile
+}
+
+func paperBrightness(profile []float64) float64 {
+	middle := slices.Clone(profile[len(profile)/4 : 3*len(profile)/4])
+	slices.Sort(middle)
+	return middle[len(middle)/2]
+}
+
+func smoothProfile(profile []float64, radius int) []float64 {
+	sums := make([]float64, len(profile)+1)
+	for i, value := range profile {
+		sums[i+1] = sums[i] + value
+	}
+	smoothed := make([]float64, len(profile))
+	for i := range profile {
+		from, to := max(i-radius, 0), min(i+radius+1, len(profile))
+		smoothed[i] = (sums[to] - sums[from]) / float64(to-from)
+	}
+	return smoothed
+}
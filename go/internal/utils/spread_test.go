@@ -0,0 +1,53 @@
+package utils
+
+/*
+This file tests the detection of the gutter of a spread of two text pages with the shadow of the binding between them,
+against single pages: upright, in landscape, and in landscape with a dark figure in their middle.
+*/
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+func bookSpread(leftWidth, rightWidth, height int, shadow bool) *image.RGBA {
+	spread := image.NewRGBA(image.Rect(0, 0, leftWidth+rightWidth, height))
+	draw.Draw(spread, image.Rect(0, 0, leftWidth, height), textPage(leftWidth, height), image.Point{}, draw.Src)
+	draw.Draw(spread, image.Rect(leftWidth, 0, leftWidth+rightWidth, height), textPage(rightWidth, height), image.Point{}, draw.Src)
+	if !shadow {
+		return spread
+	}
+	for x := leftWidth - 40; x < leftWidth+40; x++ {
+		darkening := 1 - 0.45*(1-math.Abs(float64(x-leftWidth))/40)
+		for y := 0; y < height; y++ {
+			pixel := spread.RGBAAt(x, y)
+			value := uint8(float64(pixel.R) * darkening)
+			spread.SetRGBA(x, y, color.RGBA{R: value, G: value, B: value, A: 255})
+		}
+	}
+	return spread
+}
+
+func TestFindGutter(t *testing.T) {
+	for _, leftWidth := range []int{600, 560, 650} {
+		gutter, ok := FindGutter(bookSpread(leftWidth, 1200-leftWidth, 850, true))
+		if !ok || math.Abs(float64(gutter-leftWidth)) > 4 {
+			t.Errorf("gutter of a spread of a %d pixel wide left page found at %d (%v), expected about %d", leftWidth, gutter, ok, leftWidth)
+		}
+	}
+
+	figure := bookSpread(600, 600, 850, false)
+	draw.Draw(figure, image.Rect(520, 300, 680, 550), image.NewUniform(color.Gray{Y: 40}), image.Point{}, draw.Src)
+	for name, img := range map[string]image.Image{
+		"page":               textPage(850, 1200),
+		"landscape page":     bookSpread(600, 600, 850, false),
+		"figure in the page": figure,
+	} {
+		if gutter, ok := FindGutter(img); ok {
+			t.Errorf("gutter found at %d in a %s", gutter, name)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package watermark
+
+/*
+Package watermark stamps a document with a text, e.g. "COPY" or the date it was scanned, or with an image such as the
+logo of a company, at one of its corners or at its center. The stamp is sized from the document, so it looks the
+same whatever the resolution of the scan.
+
+---
+
+### Constants
+- `textColor`: Color of the text stamps and of their frame, before the opacity is applied.
+- `scaleDivisor`: The pixels of the font of a text stamp are squares of the smaller side of the document divided by
+  this, so a text stamp is about a thirtieth of the document high.
+- `marginDivisor`: The stamp is placed at the smaller side of the document divided by this from its edges.
+- `imageDivisor`: An image stamp is scaled to the smaller side of the document divided by this, on its larger side.
+
+---
+
+### `Stamp`
+What is laid over the document.
+
+- Fields:
+  - `Text`: Text of the stamp, whose placeholders are replaced by `Expand`. Drawn with the bitmap font of
+    `imageUtils.DrawText`, in a frame drawn with `imageUtils.DrawRectangle`, like a rubber stamp.
+  - `Image`: Image of the stamp, used instead of `Text` if it is not nil.
+  - `Position`: Where the stamp is placed (`protocol.PositionBottomRight`, ...).
+  - `Opacity`: Opacity of the stamp, from 0 to 1.
+
+---
+
+### `Expand(text string, now time.Time, id string) string`
+Replaces the placeholders of a text stamp: `{date}` (`2006-01-02`) and `{time}` (`15:04:05`) with `now` in UTC, and
+`{request}` with `id`.
+
+### `HasPlaceholders(text string) bool`
+Tells whether a text stamp has placeholders, so two documents stamped with it can differ.
+
+### `Apply(img image.Image, stamp Stamp) *image.RGBA`
+Returns a copy of `img` with the stamp laid over it. A text stamp larger than the document is drawn with a smaller
+font, down to the smallest one.
+
+### `place(bounds image.Rectangle, size image.Point, position string, margin int) image.Point`
+Returns the top left corner of a stamp of `size` pixels at `position` in `bounds`.
+*/
+
+import (
+	"ELP-project/internal/imageUtils"
+	"ELP-project/internal/protocol"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"time"
+)
+
+const (
+	scaleDivisor  = 200
+	marginDivisor = 30
+	imageDivisor  = 4
+)
+
+var textColor = color.NRGBA{R: 200, G: 16, B: 16, A: 255}
+
+type Stamp struct {
+	Text     string
+	Image    image.Image
+	Position string
+	Opacity  float64
+}
+
+func Expand(text string, now time.Time, id string) string {
+	now = now.UTC()
+	return strings.NewReplacer(
+		"{date}", now.Format("2006-01-02"),
+		"{time}", now.Format("15:04:05"),
+		"{request}", id,
+	).Replace(text)
+}
+
+func HasPlaceholders(text string) bool {
+	return Expand(text, time.Time{}, "") != text
+}
+
+func Apply(img image.Image, stamp Stamp) *image.RGBA {
+	bounds := img.Bounds()
+	output := image.NewRGBA(bounds)
+	draw.Draw(output, bounds, img, bounds.Min, draw.Src)
+
+	side := min(bounds.Dx(), bounds.Dy())
+	margin := side / marginDivisor
+	alpha := uint8(255*stamp.Opacity + 0.5)
+
+	if stamp.Image != nil {
+		imageBounds := stamp.Image.Bounds()
+		if imageBounds.Empty() {
+			return output
+		}
+		length := max(side/imageDivisor, 1)
+		width, height := length, max(length*imageBounds.Dy()/imageBounds.Dx(), 1)
+		if imageBounds.Dy() > imageBounds.Dx() {
+			width, height = max(length*imageBounds.Dx()/imageBounds.Dy(), 1), length
+		}
+
+		scaled := imageUtils.Resize(stamp.Image, width, height, imageUtils.Bilinear)
+		rect := scaled.Bounds().Add(place(bounds, image.Pt(width, height), stamp.Position, margin))
+		draw.DrawMask(output, rect, scaled, image.Point{}, image.NewUniform(color.Alpha{A: alpha}), image.Point{}, draw.Over)
+		return output
+	}
+
+	text := strings.ToUpper(stamp.Text)
+	scale := max(side/scaleDivisor, 1)
+	size := imageUtils.TextSize(text, scale)
+	for scale > 1 && (size.X+4*scale > bounds.Dx()-2*margin || size.Y+4*scale > bounds.Dy()-2*margin) {
+		scale--
+		size = imageUtils.TextSize(text, scale)
+	}
+
+	// The frame is one font pixel thick, one font pixel away from the text.
+	frame := image.Pt(size.X+4*scale, size.Y+4*scale)
+	corner := place(bounds, frame, stamp.Position, margin)
+	ink := textColor
+	ink.A = alpha
+	imageUtils.DrawRectangle(output, image.Rectangle{Min: corner, Max: corner.Add(frame)}, scale, ink)
+	imageUtils.DrawText(output, text, corner.Add(image.Pt(2*scale, 2*scale)), scale, ink)
+
+	return output
+}
+
+func place(bounds image.Rectangle, size image.Point, position string, margin int) image.Point {
+	left := bounds.Min.X + margin
+	right := bounds.Max.X - margin - size.X
+	top := bounds.Min.Y + margin
+	bottom := bounds.Max.Y - margin - size.Y
+
+	switch position {
+	case protocol.PositionTopLeft:
+		return image.Pt(left, top)
+	case protocol.PositionTopRight:
+		return image.Pt(right, top)
+	case protocol.PositionBottomLeft:
+		return image.Pt(left, bottom)
+	case protocol.PositionCenter:
+		return image.Pt((left+right)/2, (top+bottom)/2)
+	default:
+		return image.Pt(right, bottom)
+	}
+}
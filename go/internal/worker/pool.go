@@ -0,0 +1,102 @@
+package worker
+
+/*
+This file provides a worker pool whose number of workers can be changed while it is running.
+
+---
+
+### Pool[T any, R any]
+A set of workers processing the tasks of a channel, like `StartWorkerPool`.
+
+- **Methods**:
+  - `Resize(numWorkers int)`: Starts or stops workers until the pool has `numWorkers` workers. A stopped worker
+    finishes the task it is processing first.
+  - `Size() int`: Returns the current number of workers.
+
+The workers also stop when the task channel is closed. The workers of a pool created by `NewTrackedPool` are counted
+by a `Tracker` (see `tracker.go`).
+
+---
+
+### NewPool[T any, R any](name string, workerFunc func(Task[T, R]), tasks <-chan Task[T, R]) *Pool[T, R]
+Creates an empty pool, call `Resize` to start its workers.
+
+---
+
+### Example Usage:
+```go
+tasks := make(chan Task[int, string])
+pool := NewPool("ExamplePool", TreatmentWorker[int, string], tasks)
+pool.Resize(3)
+// later, under load
+pool.Resize(8)
+```
+*/
+
+import (
+	"log"
+	"sync"
+)
+
+type Pool[T any, R any] struct {
+	name       string
+	workerFunc func(Task[T, R])
+	tasks      <-chan Task[T, R]
+	tracker    *Tracker
+
+	mutex  sync.Mutex
+	stops  []chan struct{}
+	nextID int
+}
+
+func NewPool[T any, R any](name string, workerFunc func(Task[T, R]), tasks <-chan Task[T, R]) *Pool[T, R] {
+	return &Pool[T, R]{
+		name:       name,
+		workerFunc: workerFunc,
+		tasks:      tasks,
+	}
+}
+
+func (pool *Pool[T, R]) Resize(numWorkers int) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	for len(pool.stops) < numWorkers {
+		stop := make(chan struct{})
+		pool.stops = append(pool.stops, stop)
+		pool.tracker.workerStarted(pool.name)
+		go pool.work(pool.nextID, stop)
+		pool.nextID++
+	}
+
+	for len(pool.stops) > numWorkers {
+		last := len(pool.stops) - 1
+		close(pool.stops[last])
+		pool.stops = pool.stops[:last]
+	}
+}
+
+func (pool *Pool[T, R]) Size() int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	return len(pool.stops)
+}
+
+func (pool *Pool[T, R]) work(workerID int, stop <-chan struct{}) {
+	log.Printf("%s Worker %d started", pool.name, workerID)
+	defer log.Printf("%s Worker %d stopped", pool.name, workerID)
+	defer pool.tracker.workerStopped(pool.name)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case task, ok := <-pool.tasks:
+			if !ok {
+				return
+			}
+			pool.workerFunc(task)
+		}
+	}
+}
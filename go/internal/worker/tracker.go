@@ -0,0 +1,287 @@
+package worker
+
+/*
+This file provides the tracking of the workers and of their task channels, to check that a pool shuts down cleanly:
+every worker stopped, every task channel closed, and no task sent to a closed channel. Sending to a closed channel
+panics, and closing a channel while a sender is blocked on it only panics if the sender is scheduled after the close,
+so such a bug only shows up once in a while under load. The tracker records it whenever the close and the send
+overlap, and rejects the tasks sent after the close with an error instead of a panic.
+
+---
+
+### Constants
+- `shutdownPollPeriod`: Period at which `WaitShutdown` checks whether the workers stopped.
+
+---
+
+### ErrChannelClosed
+Error of a task sent to a closed `TaskChannel`: the workers are stopping, and the task will not be processed.
+
+### Tracker
+Counts the workers started and stopped per pool, and the state of the task channels.
+
+- **Methods**:
+  - `Report() TrackerReport`: Returns the state of the workers and the channels.
+  - `CheckShutdown() error`: Returns an error listing the workers still running, the channels still open and the
+    misuses of the channels recorded so far, nil if there is none.
+  - `WaitShutdown(ctx context.Context) error`: Waits until every worker stopped, for at most the life of `ctx`, then
+    returns `CheckShutdown()`.
+
+### TrackerReport
+State of the tracked workers and channels.
+
+- Fields:
+  - `Started`, `Stopped`: Number of workers started and stopped, across the pools.
+  - `Running`: Number of running workers of every pool that has some.
+  - `OpenChannels`: Names of the task channels not closed yet, sorted.
+  - `Violations`: Misuses of the channels: a task sent to a closed channel, a channel closed while a task was being
+    sent to it, or closed twice.
+
+### TaskChannel[T any, R any]
+A task channel whose sends and close are tracked.
+
+- **Methods**:
+  - `Tasks() <-chan Task[T, R]`: The channel the workers receive the tasks from.
+  - `Send(task Task[T, R]) error`: Sends a task, waiting for room in the channel. Returns `ErrChannelClosed`, and
+    records a violation, if the channel is already closed.
+  - `Close()`: Closes the channel, recording a violation if a task is being sent to it: the channel is then closed
+    once the task is in it, by its sender, so the workers still process it. Closing it again only records a
+    violation.
+
+---
+
+### NewTracker() *Tracker
+Creates a tracker with no worker and no channel.
+
+### NewTaskChannel[T any, R any](tracker *Tracker, name string, size int) *TaskChannel[T, R]
+Creates a task channel buffering `size` tasks, tracked as open under `name` until it is closed.
+
+### NewTrackedPool[T any, R any](name string, workerFunc func(Task[T, R]), channel *TaskChannel[T, R]) *Pool[T, R]
+Creates an empty pool, like `NewPool`, receiving the tasks of `channel`, whose workers are counted by the tracker of
+the channel.
+
+### `(tracker *Tracker) workerStarted(pool string)` / `(tracker *Tracker) workerStopped(pool string)`
+Count a worker of `pool` as started or stopped. Do nothing on a nil tracker, that of the untracked pools.
+
+### `(tracker *Tracker) violation(format string, args ...any)`
+Records and logs a misuse of a channel.
+
+---
+
+### Example Usage:
+```go
+tracker := NewTracker()
+tasks := NewTaskChannel[int, string](tracker, "ExamplePool", 100)
+pool := NewTrackedPool("ExamplePool", TreatmentWorker[int, string], tasks)
+pool.Resize(3)
+
+if err := tasks.Send(task); err != nil {
+    // The pool is shutting down.
+}
+
+tasks.Close()
+if err := tracker.WaitShutdown(ctx); err != nil {
+    log.Printf("Unclean shutdown: %v", err)
+}
+```
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const shutdownPollPeriod = 10 * time.Millisecond
+
+var ErrChannelClosed = errors.New("task channel closed")
+
+type Tracker struct {
+	mutex      sync.Mutex
+	started    int
+	stopped    int
+	running    map[string]int
+	channels   map[string]*channelState
+	violations []string
+}
+
+type channelState struct {
+	closed  bool
+	pending bool
+	sending int
+}
+
+type TrackerReport struct {
+	Started      int
+	Stopped      int
+	Running      map[string]int
+	OpenChannels []string
+	Violations   []string
+}
+
+type TaskChannel[T any, R any] struct {
+	name    string
+	tasks   chan Task[T, R]
+	state   *channelState
+	tracker *Tracker
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		running:  make(map[string]int),
+		channels: make(map[string]*channelState),
+	}
+}
+
+func NewTaskChannel[T any, R any](tracker *Tracker, name string, size int) *TaskChannel[T, R] {
+	state := &channelState{}
+	tracker.mutex.Lock()
+	tracker.channels[name] = state
+	tracker.mutex.Unlock()
+
+	return &TaskChannel[T, R]{
+		name:    name,
+		tasks:   make(chan Task[T, R], size),
+		state:   state,
+		tracker: tracker,
+	}
+}
+
+func NewTrackedPool[T any, R any](name string, workerFunc func(Task[T, R]), channel *TaskChannel[T, R]) *Pool[T, R] {
+	pool := NewPool(name, workerFunc, channel.Tasks())
+	pool.tracker = channel.tracker
+	return pool
+}
+
+func (channel *TaskChannel[T, R]) Tasks() <-chan Task[T, R] {
+	return channel.tasks
+}
+
+func (channel *TaskChannel[T, R]) Send(task Task[T, R]) error {
+	tracker := channel.tracker
+	tracker.mutex.Lock()
+	if channel.state.closed {
+		tracker.violation("task sent to the closed channel %s", channel.name)
+		tracker.mutex.Unlock()
+		return ErrChannelClosed
+	}
+	channel.state.sending++
+	tracker.mutex.Unlock()
+
+	channel.tasks <- task
+
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	channel.state.sending--
+	if channel.state.pending && channel.state.sending == 0 {
+		channel.state.pending = false
+		close(channel.tasks)
+	}
+	return nil
+}
+
+func (channel *TaskChannel[T, R]) Close() {
+	tracker := channel.tracker
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	if channel.state.closed {
+		tracker.violation("channel %s closed twice", channel.name)
+		return
+	}
+	channel.state.closed = true
+	if channel.state.sending > 0 {
+		tracker.violation("channel %s closed while %d tasks were sent to it", channel.name, channel.state.sending)
+		channel.state.pending = true
+		return
+	}
+	close(channel.tasks)
+}
+
+func (tracker *Tracker) Report() TrackerReport {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	report := TrackerReport{
+		Started:    tracker.started,
+		Stopped:    tracker.stopped,
+		Running:    maps.Clone(tracker.running),
+		Violations: slices.Clone(tracker.violations),
+	}
+	for name, state := range tracker.channels {
+		if !state.closed {
+			report.OpenChannels = append(report.OpenChannels, name)
+		}
+	}
+	slices.Sort(report.OpenChannels)
+	return report
+}
+
+func (tracker *Tracker) CheckShutdown() error {
+	report := tracker.Report()
+
+	var problems []string
+	for _, pool := range slices.Sorted(maps.Keys(report.Running)) {
+		problems = append(problems, fmt.Sprintf("%d workers of %s still running", report.Running[pool], pool))
+	}
+	for _, name := range report.OpenChannels {
+		problems = append(problems, fmt.Sprintf("channel %s not closed", name))
+	}
+	problems = append(problems, report.Violations...)
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "; "))
+}
+
+func (tracker *Tracker) WaitShutdown(ctx context.Context) error {
+	ticker := time.NewTicker(shutdownPollPeriod)
+	defer ticker.Stop()
+
+	for len(tracker.Report().Running) > 0 {
+		select {
+		case <-ctx.Done():
+			return tracker.CheckShutdown()
+		case <-ticker.C:
+		}
+	}
+	return tracker.CheckShutdown()
+}
+
+func (tracker *Tracker) workerStarted(pool string) {
+	if tracker == nil {
+		return
+	}
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.started++
+	tracker.running[pool]++
+}
+
+func (tracker *Tracker) workerStopped(pool string) {
+	if tracker == nil {
+		return
+	}
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.stopped++
+	tracker.running[pool]--
+	if tracker.running[pool] == 0 {
+		delete(tracker.running, pool)
+	}
+}
+
+func (tracker *Tracker) violation(format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	tracker.violations = append(tracker.violations, message)
+	log.Printf("Worker tracker: %s", message)
+}
@@ -0,0 +1,95 @@
+package worker
+
+/*
+This file tests the tracker of the workers: the clean shutdown of a tracked pool, and the misuses of the task channels
+it records instead of panicking, even when the close and the send only overlap.
+*/
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+func TestTrackedPoolShutdown(t *testing.T) {
+	tracker := NewTracker()
+	tasks := NewTaskChannel[int, int](tracker, "double", 1)
+	pool := NewTrackedPool("double", TreatmentWorker[int, int], tasks)
+	pool.Resize(3)
+	pool.Resize(2)
+
+	results := make(chan Task[int, int], 1)
+	double := func(input int) (int, error) { return 2 * input, nil }
+	if err := tasks.Send(Task[int, int]{Input: 21, Function: double, ResultChan: results}); err != nil {
+		t.Fatal(err)
+	}
+	if result := <-results; result.Output != 42 {
+		t.Fatalf("result is %d, expected 42", result.Output)
+	}
+
+	if err := tracker.CheckShutdown(); err == nil || !strings.Contains(err.Error(), "channel double not closed") {
+		t.Fatalf("running pool checked as shut down: %v", err)
+	}
+
+	tasks.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := tracker.WaitShutdown(ctx); err != nil {
+		t.Fatalf("unclean shutdown: %v", err)
+	}
+	if report := tracker.Report(); report.Started != 3 || report.Stopped != 3 {
+		t.Fatalf("%d workers started and %d stopped, expected 3", report.Started, report.Stopped)
+	}
+}
+
+func TestChannelMisuses(t *testing.T) {
+	tracker := NewTracker()
+	tasks := NewTaskChannel[int, int](tracker, "unbuffered", 0)
+
+	// Blocked on a channel no worker receives from yet, as a request is when the workers are busy.
+	sent := make(chan error)
+	go func() {
+		sent <- tasks.Send(Task[int, int]{})
+	}()
+	for sending := 0; sending == 0; time.Sleep(time.Millisecond) {
+		tracker.mutex.Lock()
+		sending = tasks.state.sending
+		tracker.mutex.Unlock()
+	}
+
+	tasks.Close()
+	<-tasks.Tasks()
+	if err := <-sent; err != nil {
+		t.Fatalf("send interrupted by the close failed: %v", err)
+	}
+	if _, ok := <-tasks.Tasks(); ok {
+		t.Fatal("channel still open once the interrupted send is done")
+	}
+	if err := tasks.Send(Task[int, int]{}); !errors.Is(err, ErrChannelClosed) {
+		t.Fatalf("send after the close returned %v, expected ErrChannelClosed", err)
+	}
+	tasks.Close()
+
+	violations := tracker.Report().Violations
+	expected := []string{
+		"channel unbuffered closed while 1 tasks were sent to it",
+		"task sent to the closed channel unbuffered",
+		"channel unbuffered closed twice",
+	}
+	if strings.Join(violations, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("violations are %q, expected %q", violations, expected)
+	}
+	if err := tracker.CheckShutdown(); err == nil {
+		t.Fatal("misused channel checked as shut down cleanly")
+	}
+}
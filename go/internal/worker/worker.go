@@ -10,7 +10,8 @@ Represents a single unit of work to be executed by the worker pool.
 The struct uses Go generics to support various types for input (T) and output (R).
 
 Fields:
-- `Conn net.Conn`: Represents the associated network connection for the task.
+- `Conn net.Conn`: Represents the associated network connection for the task, nil if the task does not come from a
+  connection (e.g. an asynchronous job resumed after a restart).
 - `Input T`: The input data for the task.
 - `Output R`: The result of task processing.
 - `Err error`: Captures any error that occurs during task processing.
@@ -19,6 +20,16 @@ Fields:
 
 ---
 
+### PanicError:
+Error of a task whose `Function` panicked, so that a bug in a processing stage fails the request it belongs to instead
+of killing the whole process.
+
+Fields:
+- `Value any`: The value the function panicked with.
+- `Stack []byte`: The stack of the worker when it panicked, as formatted by `runtime/debug.Stack`.
+
+---
+
 ### StartWorkerPool[T any, R any]:
 Starts a pool of workers that process tasks from a channel concurrently.
 
@@ -50,7 +61,8 @@ Parameters:
 Behavior:
 1. Logs the start of task processing.
 2. If no `Function` is provided, logs an error, sets the `Err` field, and sends the result back via `ResultChan` (if specified).
-3. Executes the `Function` with `Input`, stores the result in `Output`, and captures any errors in `Err`.
+3. Executes the `Function` with `Input`, stores the result in `Output`, and captures any errors in `Err`. A panic of
+   the `Function` is recovered and stored in `Err` as a `*PanicError`, and the worker goes on with the next task.
 4. Sends the processed task back via `ResultChan` for further handling (if specified).
 5. Logs the conclusion of task processing.
 
@@ -69,6 +81,11 @@ TreatmentWorker(task)
 
 ---
 
+### call[T any, R any](function func(T) (R, error), input T) (R, error):
+Calls `function` with `input`, returning a `*PanicError` if it panics.
+
+---
+
 ### Logging:
 - Logs worker activity (start/stop) and individual task processing events.
 - Transparent error reporting via structured logging, aiding troubleshooting and monitoring.
@@ -85,8 +102,10 @@ TreatmentWorker(task)
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"net"
+	"runtime/debug"
 )
 
 type Task[T any, R any] struct {
@@ -98,6 +117,15 @@ type Task[T any, R any] struct {
 	Function   func(T) (R, error)
 }
 
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (err *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", err.Value)
+}
+
 func StartWorkerPool[T any, R any](name string, numWorkers int, workerFunc func(Task[T, R]), tasks <-chan Task[T, R]) {
 	for i := 0; i < numWorkers; i++ {
 		go func(workerID int) {
@@ -111,23 +139,39 @@ func StartWorkerPool[T any, R any](name string, numWorkers int, workerFunc func(
 }
 
 func TreatmentWorker[T any, R any](task Task[T, R]) {
-	log.Printf("Processing task for connection: %v", task.Conn.RemoteAddr())
+	log.Printf("Processing task for connection: %v", task.source())
 
 	if task.Function == nil {
 		task.Err = errors.New("no processing function provided")
 		if task.ResultChan != nil {
 			task.ResultChan <- task
 		}
-		log.Printf("No function provided for task from: %v", task.Conn.RemoteAddr())
+		log.Printf("No function provided for task from: %v", task.source())
 		return
 	}
 
-	output, err := task.Function(task.Input)
+	output, err := call(task.Function, task.Input)
 	task.Output = output
 	task.Err = err
 
 	if task.ResultChan != nil {
 		task.ResultChan <- task
 	}
-	log.Printf("Task processing completed for connection: %v", task.Conn.RemoteAddr())
+	log.Printf("Task processing completed for connection: %v", task.source())
+}
+
+func call[T any, R any](function func(T) (R, error), input T) (output R, err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			err = &PanicError{Value: value, Stack: debug.Stack()}
+		}
+	}()
+	return function(input)
+}
+
+func (task Task[T, R]) source() string {
+	if task.Conn == nil {
+		return "none"
+	}
+	return task.Conn.RemoteAddr().String()
 }
@@ -0,0 +1,116 @@
+package server
+
+/*
+This file implements the statistics collected for every request, reported to the client in the metadata of the
+response and written to the access log.
+
+---
+
+### `requestTransfer`
+Measures of the upload of a request, taken by the connection loop.
+
+- Fields:
+  - `bytesReceived`: Size of the header and image payloads.
+  - `started`: Time the request header was received.
+  - `received`: Time the image was fully received, zero if the image was rejected without being received.
+
+### `accessEntry`
+Line of the access log describing a request.
+
+- Fields:
+  - `remoteAddr`, `client`: Address of the client and name of its API key.
+  - `requestID`: ID of the request on the connection.
+  - `status`: "ok", "cached" (duplicate submission), "async" (job created), "estimated" (cost estimate) or the error
+    code sent to the client.
+  - `transfer`: Measures of the upload.
+  - `processing`, `sending`: Time spent processing the image and sending the response.
+  - `bytesSent`: Size of the image sent back.
+
+---
+
+### `newAccessLog(path string, logger *log.Logger) (*log.Logger, error)`
+Opens the access log. The entries are appended to `path`, or written to the main log (`logger`) if `path` is empty.
+
+### `logAccess(entry *accessEntry)`
+Writes an entry to the access log.
+
+### `transferStats(conn *connection, transfer requestTransfer, processing time.Duration, bytesSent int) *protocol.TransferStats`
+Builds the statistics sent in the metadata of a response.
+*/
+
+import (
+	"ELP-project/internal/protocol"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+type requestTransfer struct {
+	bytesReceived int64
+	started       time.Time
+	received      time.Time
+}
+
+type accessEntry struct {
+	remoteAddr string
+	client     string
+	requestID  uint32
+	status     string
+	transfer   requestTransfer
+	processing time.Duration
+	sending    time.Duration
+	bytesSent  int
+}
+
+func newAccessLog(path string, logger *log.Logger) (*log.Logger, error) {
+	if path == "" {
+		return log.New(logger.Writer(), "access: ", logger.Flags()), nil
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening access log: %w", err)
+	}
+	return log.New(file, "", log.LstdFlags), nil
+}
+
+func (server *Server) logAccess(entry *accessEntry) {
+	client := entry.client
+	if client == "" {
+		client = "-"
+	}
+
+	server.accessLog.Printf("%s client=%s request=%d status=%s in=%d out=%d receive=%s process=%s send=%s",
+		entry.remoteAddr, client, entry.requestID, entry.status,
+		entry.transfer.bytesReceived, entry.bytesSent,
+		entry.transfer.duration().Round(time.Microsecond),
+		entry.processing.Round(time.Microsecond), entry.sending.Round(time.Microsecond))
+}
+
+func (transfer requestTransfer) duration() time.Duration {
+	if transfer.received.IsZero() {
+		return 0
+	}
+	return transfer.received.Sub(transfer.started)
+}
+
+func (server *Server) transferStats(conn *connection, transfer requestTransfer, processing time.Duration, bytesSent int) *protocol.TransferStats {
+	features := []string{"multiplexing", "jobs"}
+	if conn.client != "" {
+		features = append(features, "auth")
+	}
+
+	return &protocol.TransferStats{
+		Protocol:      conn.codec.Magic(),
+		Features:      features,
+		BytesReceived: transfer.bytesReceived,
+		BytesSent:     int64(bytesSent),
+		ReceiveMillis: milliseconds(transfer.duration()),
+		ProcessMillis: milliseconds(processing),
+	}
+}
+
+func milliseconds(duration time.Duration) float64 {
+	return float64(duration.Microseconds()) / 1000
+}
@@ -0,0 +1,291 @@
+package server
+
+/*
+This file implements the admin interface of the server, an HTTP endpoint letting operators inspect and manage the
+server while it is running. It is only started when `Config.AdminAddress` is set, and should not be exposed
+outside of the host or the private network of the operators.
+
+---
+
+### Endpoints
+- `GET /stats`: Counters of the server (requests, failures, recovered panics, requests in progress, running and
+  queued jobs, workers...).
+- `GET /connections`: Active client connections, with their address, client name (API key), start time and
+  number of requests.
+- `POST /drain`: Stops accepting new connections, and shuts the server down once the connections, requests and
+  jobs in progress are finished.
+- `POST /handover`: Hands the listeners over to a new process of the server, then drains this one (see
+  `handover.go`). Answers once the new process accepts the connections.
+- `GET /workers`: Number of workers of each pool.
+- `POST /workers?count=N`: Resizes every worker pool to `N` workers (at most `maxAdminWorkers`).
+- `GET /pipeline?preset=NAME&format=json|dot`: Stages the preset `NAME` (`document` if omitted) runs an image through,
+  with their parameters and the current size of the worker pools (see `pipeline.go`), as JSON or, with
+  `format=dot`, as a Graphviz graph (`text/vnd.graphviz`).
+
+Every response is JSON encoded, but the graphs of `GET /pipeline`. Errors are sent as `{"error": "..."}` with an HTTP error status.
+
+---
+
+### `serverStats`
+Counters updated by the request handlers with atomic operations.
+
+### `trackedConnection`
+Active connection listed by `GET /connections`.
+
+### `resizablePool`
+Worker pool whose size can be changed at runtime (see `worker.Pool`).
+
+---
+
+### `listenAdmin() (net.Listener, error)`
+Listens on `Config.AdminAddress`, or takes the listener inherited from the previous process.
+
+### `serveAdmin(listener net.Listener)`
+Serves the admin interface on `listener` until the server stops.
+
+### `trackConnection(conn net.Conn, client string) *trackedConnection` / `untrackConnection(conn net.Conn)`
+Registers a connection as active, and removes it once it is closed.
+
+### `drain()`
+Puts the server in draining mode, see `POST /drain`.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	maxAdminWorkers = 1024
+	drainInterval   = 100 * time.Millisecond
+)
+
+type serverStats struct {
+	requests    atomic.Int64
+	failures    atomic.Int64
+	panics      atomic.Int64
+	inFlight    atomic.Int64
+	handling    atomic.Int64
+	runningJobs atomic.Int64
+}
+
+type trackedConnection struct {
+	remoteAddr string
+	client     string
+	since      time.Time
+	requests   atomic.Int64
+}
+
+type resizablePool interface {
+	Resize(numWorkers int)
+	Size() int
+}
+
+type statsResponse struct {
+	Uptime      string `json:"uptime"`
+	Connections int    `json:"connections"`
+	Requests    int64  `json:"requests"`
+	Failures    int64  `json:"failures"`
+	Panics      int64  `json:"panics"`
+	InFlight    int64  `json:"inFlight"`
+	RunningJobs int64  `json:"runningJobs"`
+	QueuedJobs  int    `json:"queuedJobs"`
+	Workers     int    `json:"workers"`
+	Draining    bool   `json:"draining"`
+}
+
+type connectionResponse struct {
+	RemoteAddr string    `json:"remoteAddr"`
+	Client     string    `json:"client,omitempty"`
+	Since      time.Time `json:"since"`
+	Requests   int64     `json:"requests"`
+}
+
+func (server *Server) listenAdmin() (net.Listener, error) {
+	if server.inherited != nil && server.inherited.admin != nil {
+		return server.inherited.admin, nil
+	}
+	return net.Listen("tcp", server.config.AdminAddress)
+}
+
+func (server *Server) serveAdmin(listener net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /stats", server.handleAdminStats)
+	mux.HandleFunc("GET /connections", server.handleAdminConnections)
+	mux.HandleFunc("POST /drain", server.handleAdminDrain)
+	mux.HandleFunc("POST /handover", server.handleAdminHandover)
+	mux.HandleFunc("GET /workers", server.handleAdminWorkers)
+	mux.HandleFunc("POST /workers", server.handleAdminWorkers)
+	mux.HandleFunc("GET /pipeline", server.handleAdminPipeline)
+
+	httpServer := &http.Server{Handler: mux}
+
+	go func() {
+		<-server.stopCtx.Done()
+		httpServer.Close()
+	}()
+
+	server.logger.Printf("Admin interface listening on %s", listener.Addr())
+	err := httpServer.Serve(listener)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+		server.logger.Printf("Admin interface stopped: %v", err)
+	}
+}
+
+func (server *Server) handleAdminStats(writer http.ResponseWriter, request *http.Request) {
+	server.connectionsMutex.Lock()
+	connections := len(server.connections)
+	server.connectionsMutex.Unlock()
+
+	server.writeJSON(writer, http.StatusOK, statsResponse{
+		Uptime:      time.Since(server.started).Round(time.Second).String(),
+		Connections: connections,
+		Requests:    server.stats.requests.Load(),
+		Failures:    server.stats.failures.Load(),
+		Panics:      server.stats.panics.Load(),
+		InFlight:    server.stats.inFlight.Load(),
+		RunningJobs: server.stats.runningJobs.Load(),
+		QueuedJobs:  server.queue.length(),
+		Workers:     server.workerCount(),
+		Draining:    server.draining.Load(),
+	})
+}
+
+func (server *Server) handleAdminConnections(writer http.ResponseWriter, request *http.Request) {
+	server.connectionsMutex.Lock()
+	connections := make([]connectionResponse, 0, len(server.connections))
+	for _, connection := range server.connections {
+		connections = append(connections, connectionResponse{
+			RemoteAddr: connection.remoteAddr,
+			Client:     connection.client,
+			Since:      connection.since,
+			Requests:   connection.requests.Load(),
+		})
+	}
+	server.connectionsMutex.Unlock()
+
+	sort.Slice(connections, func(i, j int) bool {
+		return connections[i].Since.Before(connections[j].Since)
+	})
+	server.writeJSON(writer, http.StatusOK, connections)
+}
+
+func (server *Server) handleAdminDrain(writer http.ResponseWriter, request *http.Request) {
+	server.drain()
+	server.writeJSON(writer, http.StatusOK, map[string]bool{"draining": true})
+}
+
+func (server *Server) handleAdminHandover(writer http.ResponseWriter, request *http.Request) {
+	if err := server.Handover(); err != nil {
+		server.logger.Printf("Handover failed: %v", err)
+		server.writeJSON(writer, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	server.writeJSON(writer, http.StatusOK, map[string]bool{"handedOver": true})
+}
+
+func (server *Server) handleAdminWorkers(writer http.ResponseWriter, request *http.Request) {
+	if request.Method == http.MethodPost {
+		count, err := strconv.Atoi(request.URL.Query().Get("count"))
+		if err != nil || count < 1 || count > maxAdminWorkers {
+			server.writeJSON(writer, http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("count must be a number between 1 and %d", maxAdminWorkers),
+			})
+			return
+		}
+
+		server.logger.Printf("Resizing worker pools to %d workers", count)
+		for _, pool := range server.pools {
+			pool.Resize(count)
+		}
+	}
+
+	server.writeJSON(writer, http.StatusOK, map[string]int{"workers": server.workerCount()})
+}
+
+func (server *Server) handleAdminPipeline(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "dot" {
+		server.writeJSON(writer, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("unknown format: %q", format)})
+		return
+	}
+
+	pipeline, err := describePipeline(server.config, server.workerCount(), query.Get("preset"))
+	if err != nil {
+		server.writeJSON(writer, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if format == "dot" {
+		writer.Header().Set("Content-Type", "text/vnd.graphviz")
+		if _, err := writer.Write([]byte(pipeline.DOT())); err != nil {
+			server.logger.Printf("Error writing admin response: %v", err)
+		}
+		return
+	}
+	server.writeJSON(writer, http.StatusOK, pipeline)
+}
+
+func (server *Server) workerCount() int {
+	if len(server.pools) == 0 {
+		return 0
+	}
+	return server.pools[0].Size()
+}
+
+func (server *Server) trackConnection(conn net.Conn, client string) *trackedConnection {
+	connection := &trackedConnection{
+		remoteAddr: conn.RemoteAddr().String(),
+		client:     client,
+		since:      time.Now(),
+	}
+
+	server.connectionsMutex.Lock()
+	server.connections[conn] = connection
+	server.connectionsMutex.Unlock()
+
+	return connection
+}
+
+func (server *Server) untrackConnection(conn net.Conn) {
+	server.connectionsMutex.Lock()
+	delete(server.connections, conn)
+	server.connectionsMutex.Unlock()
+}
+
+func (server *Server) drain() {
+	if !server.draining.CompareAndSwap(false, true) {
+		return
+	}
+	server.logger.Println("Draining server, new connections are refused.")
+
+	go func() {
+		ticker := time.NewTicker(drainInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if server.stats.handling.Load() == 0 && server.stats.inFlight.Load() == 0 && server.stats.runningJobs.Load() == 0 &&
+				server.queue.length() == 0 {
+				server.logger.Println("Server drained.")
+				server.cancel()
+				return
+			}
+		}
+	}()
+}
+
+func (server *Server) writeJSON(writer http.ResponseWriter, status int, value any) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	if err := json.NewEncoder(writer).Encode(value); err != nil {
+		server.logger.Printf("Error writing admin response: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package server
+
+/*
+This file implements the authentication of the clients, required when the server is started with an API keys
+file (`Config.AuthFile`) so that it can be exposed beyond localhost.
+
+---
+
+### Keys file
+Text file with one API key per line, preceded by a name identifying the client in the logs, and optionally
+followed by the priority and quotas of its asynchronous jobs (see `queue.go`):
+
+```
+# name  key        options
+scanner-1  3f0c1a... priority=high
+backoffice 9b72de... priority=low jobs-per-day=500 concurrent-jobs=2
+```
+
+- `priority`: `low`, `normal` (default) or `high`.
+- `jobs-per-day`: Jobs the key can submit per day (unlimited if 0 or missing).
+- `concurrent-jobs`: Jobs of the key running at the same time (unlimited if 0 or missing).
+
+Empty lines and lines starting with `#` are ignored.
+
+---
+
+### `apiKeys`
+Known API keys, indexed by key, with the name of their client and its limits as value.
+
+- Methods:
+  - `authenticate(token string) (string, bool)`: Returns the name of the client owning `token`. Every key is
+    compared in constant time, so the response time does not reveal how much of a key was guessed.
+  - `limits(client string) keyLimits`: Returns the priority and quotas of a client, the defaults for an unknown
+    client or when authentication is disabled.
+
+---
+
+### `loadAPIKeys(path string) (apiKeys, error)`
+Reads a keys file.
+
+### `parseKeyOptions(options []string) (keyLimits, error)`
+Parses the `name=value` options following a key.
+
+### `authenticateConnection(conn *bufferedConn, codec protocol.Codec) (string, error)`
+Reads the `protocol.FrameAuth` frame opening a connection and checks its key, the client has `authTimeout` to
+send it. Returns the name of the client, or a `protocol.CodeUnauthorized` error. Legacy clients (`codec` nil)
+cannot authenticate and are always rejected.
+*/
+
+import (
+	"ELP-project/internal/protocol"
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const authTimeout = 10 * time.Second
+
+type apiKeys map[string]apiKey
+
+type apiKey struct {
+	name   string
+	limits keyLimits
+}
+
+var errUnauthorized = protocol.ErrorMessage{
+	Code:    protocol.CodeUnauthorized,
+	Message: "missing or invalid API key",
+}
+
+func loadAPIKeys(path string) (apiKeys, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening API keys file: %w", err)
+	}
+	defer file.Close()
+
+	keys := make(apiKeys)
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: expected a name and a key", path, lineNumber)
+		}
+		limits, err := parseKeyOptions(fields[2:])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		keys[fields[1]] = apiKey{name: fields[0], limits: limits}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading API keys file: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no API key", path)
+	}
+
+	return keys, nil
+}
+
+func parseKeyOptions(options []string) (keyLimits, error) {
+	limits := defaultKeyLimits
+	for _, option := range options {
+		name, value, ok := strings.Cut(option, "=")
+		if !ok {
+			return limits, fmt.Errorf("expected name=value, got %q", option)
+		}
+
+		var err error
+		switch name {
+		case "priority":
+			limits.priority, err = parsePriority(value)
+		case "jobs-per-day":
+			limits.jobsPerDay, err = strconv.Atoi(value)
+		case "concurrent-jobs":
+			limits.concurrentJobs, err = strconv.Atoi(value)
+		default:
+			err = fmt.Errorf("unknown option %q", name)
+		}
+		if err != nil {
+			return limits, err
+		}
+	}
+	if limits.jobsPerDay < 0 || limits.concurrentJobs < 0 {
+		return limits, errors.New("quotas cannot be negative")
+	}
+	return limits, nil
+}
+
+func (keys apiKeys) authenticate(token string) (string, bool) {
+	name, found := "", false
+	for key, owner := range keys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1 {
+			name, found = owner.name, true
+		}
+	}
+	return name, found
+}
+
+func (keys apiKeys) limits(client string) keyLimits {
+	for _, key := range keys {
+		if key.name == client {
+			return key.limits
+		}
+	}
+	return defaultKeyLimits
+}
+
+func (server *Server) authenticateConnection(conn *bufferedConn, codec protocol.Codec) (string, error) {
+	if codec == nil {
+		return "", errUnauthorized
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(authTimeout)); err != nil {
+		return "", err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	frameType, _, length, err := protocol.ReadFrameHeader(conn)
+	if err != nil {
+		return "", err
+	}
+	if frameType != protocol.FrameAuth {
+		return "", errUnauthorized
+	}
+
+	payload, err := protocol.ReadPayload(conn, length, protocol.MaxControlFrameSize)
+	if errors.Is(err, protocol.ErrFrameTooLarge) {
+		return "", errUnauthorized
+	}
+	if err != nil {
+		return "", err
+	}
+
+	auth, err := protocol.DecodeAuth(codec, payload)
+	if err != nil {
+		return "", errUnauthorized
+	}
+
+	name, ok := server.keys.authenticate(auth.Token)
+	if !ok {
+		return "", errUnauthorized
+	}
+	return name, nil
+}
@@ -0,0 +1,224 @@
+package server
+
+/*
+This file implements the calibration of the server: the stages of the pipeline are benchmarked on the host, and the
+measured throughput is saved in a profile, which the server loads (`Config.CalibrationFile`) to estimate the cost of
+the images (see `estimate.go`) from the speed of its own host rather than from `defaultCalibration`.
+
+---
+
+### Measure
+Every image is processed once by a single goroutine, stage after stage, like a chunk covering the whole image: the
+grayscale conversion, the steps of the edge detection, the search of the contours and of the document, the crop, the
+anonymization of the document with the default detector, a text stamp and the encoding of the result as JPEG. The
+rate of a stage is its total time over the total number of megapixels of the images, so larger images weigh more. Benchmarking several resolutions smooths the effects of the CPU caches.
+
+---
+
+### `CalibrationProfile`
+Result of a calibration, saved as JSON.
+
+- Fields:
+  - `Host`: Name of the host the profile was measured on.
+  - `CPUs`: Number of CPUs of the host.
+  - `Measured`: When the profile was measured.
+  - `Rates`: Processing rate of every stage, in milliseconds per megapixel for a single worker (see `calibration`).
+  - `Samples`: The measures of every benchmarked image.
+
+- Methods:
+  - `Save(path string) error`: Writes the profile to a file.
+
+### `CalibrationSample`
+Measures of one benchmarked image.
+
+- Fields:
+  - `Width`, `Height`: Size of the image, in pixels.
+  - `Megapixels`: Number of pixels of the image, in millions.
+  - `Stages`: Time spent in every stage.
+
+---
+
+### `Calibrate(images []image.Image) (CalibrationProfile, error)`
+Benchmarks the pipeline on `images`, which should show a document like the ones processed by the server (the crop
+is not measured on an image where no document is found). Fails if there is no image.
+
+### `loadCalibration(path string) (calibration, error)`
+Reads the rates of a profile written by `Save`. The stages missing from the profile keep the rates of
+`defaultCalibration`. Fails if a rate is not positive.
+
+### `measureStage(sample *CalibrationSample, stage string, start time.Time)`
+Records the time elapsed since `start` as the time spent in a stage of a benchmarked image.
+
+### `measureDuration(sample *CalibrationSample, stage string, duration time.Duration)`
+Records the time spent in a stage of a benchmarked image.
+*/
+
+import (
+	"ELP-project/internal/anonymize"
+	"ELP-project/internal/geometry"
+	"ELP-project/internal/imageUtils"
+	"ELP-project/internal/protocol"
+	"ELP-project/internal/utils"
+	"ELP-project/internal/watermark"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"maps"
+	"os"
+	"runtime"
+	"time"
+)
+
+type CalibrationProfile struct {
+	Host     string              `json:"host"`
+	CPUs     int                 `json:"cpus"`
+	Measured time.Time           `json:"measured"`
+	Rates    map[string]float64  `json:"rates"`
+	Samples  []CalibrationSample `json:"samples"`
+}
+
+type CalibrationSample struct {
+	Width      int                    `json:"width"`
+	Height     int                    `json:"height"`
+	Megapixels float64                `json:"megapixels"`
+	Stages     []protocol.StageTiming `json:"stages"`
+}
+
+func Calibrate(images []image.Image) (CalibrationProfile, error) {
+	if len(images) == 0 {
+		return CalibrationProfile{}, errors.New("no image to calibrate with")
+	}
+
+	host, _ := os.Hostname()
+	profile := CalibrationProfile{
+		Host:     host,
+		CPUs:     runtime.NumCPU(),
+		Measured: time.Now(),
+		Rates:    make(map[string]float64),
+	}
+
+	totals := make(map[string]float64)
+	megapixels := make(map[string]float64)
+	for _, img := range images {
+		bounds := img.Bounds()
+		sample := CalibrationSample{
+			Width:      bounds.Dx(),
+			Height:     bounds.Dy(),
+			Megapixels: float64(bounds.Dx()*bounds.Dy()) / 1e6,
+		}
+
+		start := time.Now()
+		gray := imageUtils.Grayscale(img)
+		measureStage(&sample, protocol.TimingGrayscale, start)
+
+		edges, cannyTimings := utils.ApplyCannyEdgeMap(gray, utils.DefaultCannyParameters)
+		measureDuration(&sample, protocol.TimingBlur, cannyTimings.Blur)
+		measureDuration(&sample, protocol.TimingSobel, cannyTimings.Sobel)
+		measureDuration(&sample, protocol.TimingNMS, cannyTimings.NMS)
+		measureDuration(&sample, protocol.TimingHysteresis, cannyTimings.Hysteresis)
+
+		start = time.Now()
+		utils.Close(edges.Gray, 1)
+		measureStage(&sample, protocol.TimingMorphology, start)
+
+		start = time.Now()
+		contours := utils.FindContoursSeeded(edges.Gray, bounds, edges.Edges)
+		measureStage(&sample, protocol.TimingBFS, start)
+
+		start = time.Now()
+		document := utils.FindQuadrilateral(contours)
+		measureStage(&sample, protocol.TimingQuadrilateral, start)
+
+		start = time.Now()
+		utils.FindHoughQuadrilateral(edges.Gray)
+		measureStage(&sample, protocol.TimingHough, start)
+
+		var result image.Image = img
+		if len(document.Contour) > 0 {
+			start = time.Now()
+			corners := utils.FindCorner(document.Contour, geometry.Point{X: bounds.Dx() / 2, Y: bounds.Dy() / 2})
+			rect := image.Rect(corners[0].X, corners[0].Y, corners[1].X, corners[1].Y)
+			cropped := image.NewRGBA(rect)
+			draw.Draw(cropped, rect, img, rect.Min, draw.Src)
+			measureStage(&sample, protocol.TimingCrop, start)
+			result = cropped
+		}
+
+		start = time.Now()
+		imageUtils.CleanWhiteboard(result)
+		measureStage(&sample, protocol.TimingEnhance, start)
+
+		start = time.Now()
+		result, _ = anonymize.Apply(result, anonymize.NewSkinDetector())
+		measureStage(&sample, protocol.TimingAnonymize, start)
+
+		start = time.Now()
+		result = watermark.Apply(result, watermark.Stamp{
+			Text:     watermark.Expand("COPY {date}", start, "calibration"),
+			Position: protocol.PositionBottomRight,
+			Opacity:  protocol.DefaultStampOpacity,
+		})
+		measureStage(&sample, protocol.TimingStamp, start)
+
+		start = time.Now()
+		if _, err := encodeImage(result, "jpeg"); err != nil {
+			return profile, err
+		}
+		measureStage(&sample, protocol.TimingEncode, start)
+
+		for _, timing := range sample.Stages {
+			totals[timing.Stage] += timing.Millis
+			megapixels[timing.Stage] += sample.Megapixels
+		}
+		profile.Samples = append(profile.Samples, sample)
+	}
+
+	for stage, total := range totals {
+		if megapixels[stage] > 0 {
+			profile.Rates[stage] = total / megapixels[stage]
+		}
+	}
+	return profile, nil
+}
+
+func measureStage(sample *CalibrationSample, stage string, start time.Time) {
+	measureDuration(sample, stage, time.Since(start))
+}
+
+func measureDuration(sample *CalibrationSample, stage string, duration time.Duration) {
+	sample.Stages = append(sample.Stages, protocol.StageTiming{
+		Stage:  stage,
+		Millis: float64(duration) / float64(time.Millisecond),
+	})
+}
+
+func (profile CalibrationProfile) Save(path string) error {
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func loadCalibration(path string) (calibration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var profile CalibrationProfile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, fmt.Errorf("parsing calibration profile %s: %w", path, err)
+	}
+
+	rates := maps.Clone(defaultCalibration)
+	for stage, rate := range profile.Rates {
+		if rate <= 0 {
+			return nil, fmt.Errorf("invalid rate of stage %s in %s: %v", stage, path, rate)
+		}
+		rates[stage] = rate
+	}
+	return rates, nil
+}
@@ -0,0 +1,260 @@
+package server
+
+/*
+This file defines the configuration of the server and the command-line flags used to set it.
+
+---
+
+### `Config`
+Groups the tunable settings of the server.
+
+- Fields:
+  - `Host`, `Port`: Address the server listens on (`localhost:14750` by default).
+  - `Listen`: Addresses the server listens on, replacing `Host`/`Port`, `Network` and `SocketPath` when set. An
+    address can be IPv4 or IPv6 and be prefixed by its network, e.g. `0.0.0.0:14750`, `tcp6:[::]:14750` or
+    `unix:/tmp/elp-project.sock` (see `listeners.go`).
+  - `Workers`: Number of workers of each worker pool, and number of chunks an image is split into (number of CPU
+    cores if 0).
+  - `CalibrationFile`: Calibration profile written by `server calibrate` (see `calibration.go`), giving the speed
+    of the host to the cost estimates. Built-in rates are used if empty.
+  - `Deterministic`: Whether every request is processed in deterministic mode, as if it set
+    `protocol.Header.Deterministic`: the results are bit-identical whatever the number of workers.
+  - `ContourPass`: How the contours of the edge map are searched (`ContourPassChunked` if empty):
+    - `ContourPassChunked`: One task per chunk of the image, like the convolution stages. The search of a chunk
+      follows the components crossing its border, so the page is traversed once per chunk it spans and the copies
+      are merged afterwards (see `utils.MergeContours`): about 60% more work in all, spread over the workers.
+    - `ContourPassGlobal`: A single task on the whole edge map, on one worker. Every component is traversed once and
+      nothing is merged, which spares CPU time on a loaded server or a single core, but the search no longer runs in
+      parallel: on an 8 Mpx photo, the single task took 80 ms where the slowest of 8 chunks took 22 ms, and their
+      merge 18 ms.
+  - `GlobalThresholds`: Whether the thresholds of the edge detection are computed once for the whole image instead of
+    once per chunk. Every chunk is smoothed and the gradient of the rows it owns measured first (see
+    `utils.GradientStats`), then the edges of all the chunks are detected with the thresholds of their sum: a chunk
+    of plain background no longer gets edges from its noise, nor a chunk of dense text loses its fainter ones, and
+    the edge map hardly depends on the number of chunks any more. The chunks wait for each other between both phases,
+    holding their gradients, computed once for both (see `utils.SmoothedImage`).
+  - `MaxPayloadSize`: Largest image frame accepted from a client, in bytes.
+  - `MaxPixels`: Largest decoded image accepted, in pixels (width x height). Also bounds the result, scaled to a
+    page or to the paper of a preset, which is checked before it is allocated.
+  - `MaxDimension`: Largest width or height of a decoded image, in pixels, and of a result.
+  - `Anonymize`: Whether the photos found on every document are blurred, as if every request set
+    `protocol.Header.Anonymize`, for privacy-sensitive deployments. The operations and artifacts showing the photos
+    unblurred are then refused. The debug bundles still keep the received images.
+  - `DebugDir`: Directory where the failed requests are persisted with their intermediate results, as debug bundles
+    (see `debug.go`). Disabled if empty.
+  - `DebugKeep`: Number of debug bundles kept, the oldest ones are deleted first. Also bounds the crash bundles.
+  - `CrashDir`: Directory where the requests whose processing panicked are persisted with the panic and its stack, as
+    crash bundles (see `crash.go`). The panics are recovered and logged even if empty.
+  - `CrashInput`: Whether the crash bundles keep the received image, to reproduce the crash. Off by default, as the
+    documents of the clients are written to the disk of the server.
+  - `JobsDir`: Directory where the asynchronous jobs and their results are persisted.
+  - `Storage`: Location of the storage of the asynchronous jobs, replacing `JobsDir` when set: `s3://bucket/prefix`,
+    `gs://bucket/prefix` or a directory (see `internal/storage`). A shared bucket lets any server instance answer
+    the queries of a job.
+  - `Sources`: Locations of the storages the requests can read their image from instead of sending it
+    (`protocol.Header.Source`): `s3://bucket/prefix`, `gs://bucket/prefix` or a directory, like `Storage`. A request
+    names its image by the location followed by the key of the image, e.g. `s3://scans/inbox/receipt.jpg` for the
+    location `s3://scans/inbox`. The requests cannot read from anywhere else, so the server never fetches a URL
+    chosen by a client. None if empty.
+  - `MaxRunningJobs`: Highest number of asynchronous jobs processed at the same time, the other ones wait in the
+    queue of the jobs (see `queue.go`). Number of workers if 0.
+  - `JobTTL`: Time after which a finished job and its result are deleted.
+  - `ResultURLExpiry`: Validity of the result URLs sent in the webhooks of the jobs (see `webhooks.go`).
+  - `WebhookSecret`: Key signing the webhook requests, unsigned if empty.
+  - `WebhookNetworks`: Networks the webhooks may reach besides the public addresses, in CIDR notation, e.g.
+    `10.0.0.0/8` for a receiver on the private network of the server (see `webhooks.go`). None if empty.
+  - `SpoolJobs`: Whether the input images of the asynchronous jobs are written to the storage, so the jobs interrupted
+    by a restart are processed again instead of failing.
+  - `LegacyProtocol`: Whether clients speaking the legacy protocol, without magic nor frames, are still served.
+  - `MaxConnectionsPerHost`: Highest number of simultaneous connections of a client host (unlimited if 0).
+  - `ConnectionRate`, `ConnectionBurst`: Rate of new connections allowed per client host, per second, and the
+    burst tolerated above it (unlimited if the rate is 0).
+  - `QueueTimeout`: Longest time a connection waits for a free connection slot before being rejected.
+  - `AuthFile`: File listing the API keys accepted from the clients (see `auth.go`). Authentication is disabled if
+    empty.
+  - `AccessLog`: File receiving the access log, one line per request. The entries go to the main log if empty.
+  - `Network`: Network the server listens on: `tcp` (IPv4 and IPv6), `tcp4`, `tcp6` or `unix`.
+  - `SocketPath`: Path of the Unix domain socket, used instead of the host and port when `Network` is `unix`. Local
+    clients avoid the TCP stack entirely, a stale socket file left by a crash is removed before listening.
+  - `AdminAddress`: Address of the HTTP admin interface (see `admin.go`), disabled if empty.
+  - `ReusePort`: Whether the TCP listeners are opened with `SO_REUSEPORT`, so a new process of the server can
+    listen on the same addresses before the old one is stopped (Linux only, see `handover.go`).
+  - `Socket`: Tuning of the client connections (kernel buffers, `TCP_NODELAY`, write coalescing), see
+    `internal/netUtils`.
+  - `Listener`: Listener the server accepts its connections from, set by the programs embedding the server (e.g. on
+    an ephemeral port). The server listens on `Network` and `Host`/`Port` or `SocketPath` if nil. Not bound to a
+    flag.
+  - `Logger`: Logger receiving the logs of the server, the standard logger if nil. Not bound to a flag.
+  - `Detector`: Detector of the photos to blur when anonymizing (see `internal/anonymize`), a
+    `anonymize.SkinDetector` if nil. Not bound to a flag.
+  - `OCRCommand`: Tesseract program recognizing the text of the documents of the requests asking for it
+    (`protocol.Header.OCR`, see `internal/ocr`), e.g. `tesseract` or its path. The requests asking for the text are
+    refused if empty and `Recognizer` is nil.
+  - `OCRLanguage`: Languages of the text recognized by `OCRCommand`, e.g. `eng` or `eng+fra`.
+  - `Recognizer`: Recognizer of the text of the documents, replacing `OCRCommand`, e.g. a client of an OCR service.
+    Not bound to a flag.
+
+---
+
+### Contour passes
+`ContourPassChunked` (`chunked`) and `ContourPassGlobal` (`global`) are the values of `ContourPass`.
+
+---
+
+### `DefaultConfig() Config`
+Returns the configuration used when no flag is given.
+
+### `(config *Config) RegisterFlags(flagSet *flag.FlagSet)`
+Binds every field of the configuration, except `Listener`, `Logger`, `Detector` and `Recognizer`, to a command-line
+flag of `flagSet`.
+*/
+
+import (
+	"ELP-project/internal/anonymize"
+	"ELP-project/internal/netUtils"
+	"ELP-project/internal/ocr"
+	"flag"
+	"log"
+	"net"
+	"time"
+)
+
+const (
+	defaultHost                  = "localhost"
+	defaultPort                  = "14750"
+	defaultMaxPayloadSize        = 32 << 20
+	defaultMaxPixels             = 50_000_000
+	defaultMaxDimension          = 20_000
+	defaultJobsDir               = "jobs"
+	defaultDebugKeep             = 100
+	defaultJobTTL                = 24 * time.Hour
+	defaultResultURLExpiry       = 24 * time.Hour
+	defaultSpoolJobs             = false
+	defaultLegacyProtocol        = true
+	defaultMaxConnectionsPerHost = 2
+	defaultConnectionRate        = 0
+	defaultConnectionBurst       = 10
+	defaultQueueTimeout          = 30 * time.Second
+	defaultNetwork               = "tcp"
+	defaultSocketPath            = "/tmp/elp-project.sock"
+	defaultOCRLanguage           = "eng"
+)
+
+const (
+	ContourPassChunked = "chunked"
+	ContourPassGlobal  = "global"
+)
+
+type Config struct {
+	Host                  string
+	Port                  string
+	Listen                []string
+	Workers               int
+	Deterministic         bool
+	ContourPass           string
+	GlobalThresholds      bool
+	Anonymize             bool
+	CalibrationFile       string
+	MaxPayloadSize        int
+	MaxPixels             int
+	MaxDimension          int
+	DebugDir              string
+	DebugKeep             int
+	CrashDir              string
+	CrashInput            bool
+	JobsDir               string
+	Storage               string
+	Sources               []string
+	MaxRunningJobs        int
+	JobTTL                time.Duration
+	ResultURLExpiry       time.Duration
+	WebhookSecret         string
+	WebhookNetworks       []string
+	SpoolJobs             bool
+	LegacyProtocol        bool
+	AuthFile              string
+	AccessLog             string
+	Network               string
+	SocketPath            string
+	AdminAddress          string
+	ReusePort             bool
+	MaxConnectionsPerHost int
+	ConnectionRate        float64
+	ConnectionBurst       int
+	QueueTimeout          time.Duration
+	Socket                netUtils.SocketOptions
+	OCRCommand            string
+	OCRLanguage           string
+
+	Listener   net.Listener
+	Logger     *log.Logger
+	Detector   anonymize.Detector
+	Recognizer ocr.Recognizer
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Host:                  defaultHost,
+		Port:                  defaultPort,
+		ContourPass:           ContourPassChunked,
+		MaxPayloadSize:        defaultMaxPayloadSize,
+		MaxPixels:             defaultMaxPixels,
+		MaxDimension:          defaultMaxDimension,
+		DebugKeep:             defaultDebugKeep,
+		JobsDir:               defaultJobsDir,
+		JobTTL:                defaultJobTTL,
+		ResultURLExpiry:       defaultResultURLExpiry,
+		SpoolJobs:             defaultSpoolJobs,
+		LegacyProtocol:        defaultLegacyProtocol,
+		MaxConnectionsPerHost: defaultMaxConnectionsPerHost,
+		ConnectionRate:        defaultConnectionRate,
+		ConnectionBurst:       defaultConnectionBurst,
+		QueueTimeout:          defaultQueueTimeout,
+		Network:               defaultNetwork,
+		SocketPath:            defaultSocketPath,
+		Socket:                netUtils.DefaultSocketOptions(),
+		OCRLanguage:           defaultOCRLanguage,
+	}
+}
+
+func (config *Config) RegisterFlags(flagSet *flag.FlagSet) {
+	flagSet.StringVar(&config.Host, "host", config.Host, "host or IP address to listen on")
+	flagSet.StringVar(&config.Port, "port", config.Port, "port to listen on")
+	flagSet.Var((*addressList)(&config.Listen), "listen", "address to listen on, e.g. 0.0.0.0:14750, [::1]:14750 or unix:/path/to/socket (repeatable, replaces -host, -port and -network)")
+	flagSet.IntVar(&config.Workers, "workers", config.Workers, "workers per pool and chunks per image (number of CPU cores if 0)")
+	flagSet.BoolVar(&config.Deterministic, "deterministic", config.Deterministic, "process every image in deterministic mode, bit-identical whatever the number of workers")
+	flagSet.StringVar(&config.ContourPass, "contour-pass", config.ContourPass, "search of the contours of the edge map: chunked (one task per chunk) or global (a single task)")
+	flagSet.BoolVar(&config.GlobalThresholds, "global-thresholds", config.GlobalThresholds, "compute the thresholds of the edge detection on the whole image instead of per chunk")
+	flagSet.BoolVar(&config.Anonymize, "anonymize", config.Anonymize, "blur the photos (faces, ID photos) found on every document")
+	flagSet.StringVar(&config.CalibrationFile, "calibration", config.CalibrationFile, "calibration profile of the host written by 'server calibrate' (built-in rates if empty)")
+	flagSet.IntVar(&config.MaxPayloadSize, "max-size", config.MaxPayloadSize, "largest accepted upload, in bytes")
+	flagSet.IntVar(&config.MaxPixels, "max-pixels", config.MaxPixels, "largest accepted decoded image, in pixels")
+	flagSet.IntVar(&config.MaxDimension, "max-dimension", config.MaxDimension, "largest accepted image width or height, in pixels")
+	flagSet.StringVar(&config.DebugDir, "debug-dir", config.DebugDir, "directory where failed requests are saved with their intermediate results (disabled if empty)")
+	flagSet.IntVar(&config.DebugKeep, "debug-keep", config.DebugKeep, "number of debug bundles kept, the oldest ones are deleted first")
+	flagSet.StringVar(&config.CrashDir, "crash-dir", config.CrashDir, "directory where the requests whose processing panicked are saved with the stack of the panic (disabled if empty)")
+	flagSet.BoolVar(&config.CrashInput, "crash-input", config.CrashInput, "keep the received image in the crash bundles")
+	flagSet.StringVar(&config.JobsDir, "jobs-dir", config.JobsDir, "directory where asynchronous job results are stored")
+	flagSet.StringVar(&config.Storage, "storage", config.Storage, "storage of asynchronous jobs: s3://bucket/prefix, gs://bucket/prefix or a directory (replaces -jobs-dir)")
+	flagSet.Var((*addressList)(&config.Sources), "source", "storage the requests can read their image from: s3://bucket/prefix, gs://bucket/prefix or a directory (repeatable)")
+	flagSet.IntVar(&config.MaxRunningJobs, "max-running-jobs", config.MaxRunningJobs, "asynchronous jobs processed at the same time, by priority (number of workers if 0)")
+	flagSet.DurationVar(&config.JobTTL, "job-ttl", config.JobTTL, "time after which finished jobs are deleted")
+	flagSet.DurationVar(&config.ResultURLExpiry, "result-url-expiry", config.ResultURLExpiry, "validity of the result URLs sent to the webhooks of the jobs (at most 7 days for buckets)")
+	flagSet.StringVar(&config.WebhookSecret, "webhook-secret", config.WebhookSecret, "key signing the webhook requests with HMAC-SHA256 (unsigned if empty)")
+	flagSet.Var((*addressList)(&config.WebhookNetworks), "webhook-network", "network the webhooks may reach besides the public addresses, e.g. 10.0.0.0/8 (repeatable)")
+	flagSet.BoolVar(&config.SpoolJobs, "spool", config.SpoolJobs, "keep the input of asynchronous jobs on disk to resume them after a restart")
+	flagSet.BoolVar(&config.LegacyProtocol, "legacy", config.LegacyProtocol, "serve clients speaking the legacy protocol")
+	flagSet.StringVar(&config.AuthFile, "auth-file", config.AuthFile, "file of API keys required from the clients (no authentication if empty)")
+	flagSet.StringVar(&config.AccessLog, "access-log", config.AccessLog, "file receiving the access log (main log if empty)")
+	flagSet.StringVar(&config.Network, "network", config.Network, "network to listen on: tcp, tcp4, tcp6 or unix")
+	flagSet.StringVar(&config.SocketPath, "socket", config.SocketPath, "path of the Unix socket to listen on with -network unix")
+	flagSet.StringVar(&config.AdminAddress, "admin", config.AdminAddress, "address of the HTTP admin interface, e.g. localhost:14751 (disabled if empty)")
+	flagSet.BoolVar(&config.ReusePort, "reuse-port", config.ReusePort, "listen with SO_REUSEPORT, so a new server process can listen on the same addresses (Linux only)")
+	flagSet.IntVar(&config.MaxConnectionsPerHost, "max-conns-per-host", config.MaxConnectionsPerHost, "largest number of simultaneous connections of a client host (unlimited if 0)")
+	flagSet.Float64Var(&config.ConnectionRate, "conn-rate", config.ConnectionRate, "new connections allowed per second and client host (unlimited if 0)")
+	flagSet.IntVar(&config.ConnectionBurst, "conn-burst", config.ConnectionBurst, "burst of new connections tolerated above -conn-rate")
+	flagSet.DurationVar(&config.QueueTimeout, "queue-timeout", config.QueueTimeout, "longest wait for a connection slot before a client is told to retry later")
+	flagSet.StringVar(&config.OCRCommand, "ocr", config.OCRCommand, "Tesseract program recognizing the text of the documents for the requests asking for it, e.g. tesseract (disabled if empty)")
+	flagSet.StringVar(&config.OCRLanguage, "ocr-language", config.OCRLanguage, "languages of the recognized text, e.g. eng or eng+fra")
+	config.Socket.RegisterFlags(flagSet)
+}
@@ -0,0 +1,339 @@
+package server
+
+/*
+This file implements the connection loop of the server, which reads the multiplexed requests of a client.
+
+---
+
+### `connection`
+Wraps a client connection so that frames written concurrently by several request handlers are never interleaved.
+
+- Fields:
+  - `Conn`: The underlying network connection.
+  - `writeMutex`: Held while a complete frame is written.
+  - `writer`: Buffer coalescing the writes of a message, flushed by `flush` once the message is complete.
+  - `client`: Name of the API key the client authenticated with, empty if authentication is disabled.
+  - `codec`: Encoding of the control messages, selected by the magic opening the connection.
+
+### `pendingRequest`
+Request whose header was received, waiting for its image frame.
+
+- Fields:
+  - `header`: The options of the request.
+  - `transfer`: Measures of the upload of the request (see `accesslog.go`).
+
+### `bufferedConn`
+Network connection read through a buffer, so that the first bytes sent by the client can be inspected before they
+are consumed. The buffers of a connection are taken from the pools of `internal/netUtils` and given back once the
+connection is closed.
+
+---
+
+### `handleConnection(conn net.Conn, workerChannels workerChannels)`
+Reads the requests of a client until the connection is closed.
+
+- **Behavior**:
+  1. Applies the socket options of the configuration to the connection, and checks the quotas of the client host
+     (see `limiter.go`) before reading anything, so the idle and unauthenticated connections of a host count
+     against its quota too.
+  2. Checks the protocol magic opening the connection, which selects the encoding of the control messages (JSON
+     or protobuf, see `protocol.Codec`). The client has `magicTimeout` to send it. A connection starting with other
+     bytes comes from a legacy client and is served by `handleLegacyConnection` (see `legacy.go`), unless the legacy
+     protocol is disabled. A client over its quotas receives a `protocol.CodeBusy` error, in the encoding of its
+     magic.
+  3. If the server requires authentication, checks the API key sent by the client (see `auth.go`). Clients
+     without a valid key receive a `protocol.CodeUnauthorized` error.
+  4. Waits for a connection slot for at most `QueueTimeout`, or until the server shuts down. A rejected client
+     receives a `protocol.CodeBusy` error.
+  5. Reads the frames sent by the client:
+     - A header frame is remembered until the image frame of the same request arrives. At most
+       `maxPipelinedRequests` headers wait for their image: the headers over that limit are answered with a
+       `protocol.CodeBadRequest` error, so a client cannot fill the memory with headers never followed by an image.
+       A header querying an asynchronous job is answered in its own goroutine (`handleJobQuery`), like an image
+       frame, since reading the result from the storage of the jobs may be slow. No image follows it.
+     - A header finalizing a scan session is answered in its own goroutine as well (`handleFinalize`, see
+       `sessions.go`), since combining the pages may be slow. No image follows it.
+     - A header giving the `Source` of its image, read by the server from one of its storages, starts the request
+       in its own goroutine (`handleSourceRequest`, see `sources.go`), like an image frame. No image follows it
+       either.
+     - An image frame starts the processing of the request in its own goroutine (`handleRequest`), so the
+       following requests can be read while it runs. At most `maxPipelinedRequests` requests of a connection are
+       processed at the same time, the reading stops until one of them completes.
+     - An authentication frame is ignored when authentication is disabled.
+     - Invalid frames are answered with an error frame and skipped.
+  6. Waits for the requests in progress before closing the connection. A panic of a request only fails that request,
+     that of the connection loop closes the connection (see `crash.go`). When the server shuts down, `Shutdown`
+     interrupts the reading of the idle connections, which end once their requests are answered.
+*/
+
+import (
+	"ELP-project/internal/netUtils"
+	"ELP-project/internal/protocol"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	maxPipelinedRequests = 8
+	magicTimeout         = 10 * time.Second
+)
+
+type connection struct {
+	net.Conn
+	writeMutex sync.Mutex
+	writer     *bufio.Writer
+	client     string
+	codec      protocol.Codec
+}
+
+func (conn *connection) Write(data []byte) (int, error) {
+	return conn.writer.Write(data)
+}
+
+func (conn *connection) flush() error {
+	return conn.writer.Flush()
+}
+
+type pendingRequest struct {
+	header   protocol.Header
+	transfer requestTransfer
+}
+
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (conn *bufferedConn) Read(buffer []byte) (int, error) {
+	return conn.reader.Read(buffer)
+}
+
+func (server *Server) handleConnection(conn net.Conn, workerChannels workerChannels) {
+	defer conn.Close()
+	defer server.recoverConnection(conn)
+
+	server.logger.Printf("New connection from %s", conn.RemoteAddr())
+
+	if err := server.config.Socket.Apply(conn); err != nil {
+		server.logger.Printf("Error tuning connection from %s: %v", conn.RemoteAddr(), err)
+	}
+
+	host := remoteHost(conn)
+	quotaErr := server.limiter.admit(host)
+	if quotaErr == nil {
+		defer server.limiter.release(host)
+	}
+
+	buffered := &bufferedConn{Conn: conn, reader: netUtils.AcquireReader(conn, server.config.Socket.IOBufferSize)}
+	defer netUtils.ReleaseReader(buffered.reader)
+
+	conn.SetReadDeadline(time.Now().Add(magicTimeout))
+	magic, err := buffered.reader.Peek(protocol.MagicLength)
+	conn.SetReadDeadline(time.Time{})
+	codec, framed := protocol.CodecForMagic(string(magic))
+	if !framed && !(server.config.LegacyProtocol && len(magic) > 0) {
+		server.logger.Printf("Invalid protocol magic from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	if framed {
+		buffered.reader.Discard(len(magic))
+	}
+
+	if quotaErr != nil {
+		server.reject(buffered, codec, quotaErr)
+		return
+	}
+
+	var client string
+	if server.keys != nil {
+		client, err = server.authenticateConnection(buffered, codec)
+		var errorMessage protocol.ErrorMessage
+		if errors.As(err, &errorMessage) {
+			server.reject(buffered, codec, err)
+			return
+		}
+		if err != nil {
+			server.logger.Printf("Failed to authenticate %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		server.logger.Printf("Client %s authenticated as %s", conn.RemoteAddr(), client)
+	}
+
+	queueTimer := time.NewTimer(server.config.QueueTimeout)
+	select {
+	case workerChannels.socketSemaphore <- conn:
+		queueTimer.Stop()
+	case <-queueTimer.C:
+		server.reject(buffered, codec, protocol.ErrorMessage{Code: protocol.CodeBusy, Message: "server busy, retry later"})
+		return
+	case <-server.stopCtx.Done():
+		queueTimer.Stop()
+		return
+	}
+	defer func() { <-workerChannels.socketSemaphore }()
+
+	tracked := server.trackConnection(conn, client)
+	defer server.untrackConnection(conn)
+
+	if !framed {
+		server.handleLegacyConnection(buffered, workerChannels)
+		return
+	}
+	conn = buffered
+
+	clientConn := &connection{
+		Conn:   conn,
+		writer: netUtils.AcquireWriter(conn, server.config.Socket.IOBufferSize),
+		client: client,
+		codec:  codec,
+	}
+	defer netUtils.ReleaseWriter(clientConn.writer)
+
+	headers := make(map[uint32]pendingRequest)
+	pipeline := make(chan struct{}, maxPipelinedRequests)
+
+	var requests sync.WaitGroup
+	defer requests.Wait()
+
+	for {
+		frameType, requestID, length, err := protocol.ReadFrameHeader(conn)
+		frameTime := time.Now()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && server.stopCtx.Err() == nil {
+				server.logger.Printf("Error reading from %s: %v", conn.RemoteAddr(), err)
+			}
+			server.logger.Println("Connection finished:", conn.RemoteAddr())
+			return
+		}
+
+		switch frameType {
+		case protocol.FrameHeader:
+			payload, err := protocol.ReadPayload(conn, length, protocol.MaxControlFrameSize)
+			if errors.Is(err, protocol.ErrFrameTooLarge) {
+				if server.discard(conn, length) != nil {
+					return
+				}
+				server.sendError(clientConn, requestID, protocol.CodeTooLarge, err)
+				continue
+			}
+			if err != nil {
+				server.logger.Printf("Failed to receive request header from %s: %v", conn.RemoteAddr(), err)
+				return
+			}
+
+			header, err := protocol.DecodeHeader(clientConn.codec, payload)
+			if err != nil {
+				server.sendError(clientConn, requestID, protocol.CodeBadRequest, err)
+				continue
+			}
+			if header.JobID != "" {
+				pipeline <- struct{}{}
+				requests.Add(1)
+				go func() {
+					defer requests.Done()
+					defer func() { <-pipeline }()
+					defer server.recoverRequest(clientConn, requestID, header, nil, nil, nil)
+					server.handleJobQuery(clientConn, requestID, header.JobID, header.Offset)
+				}()
+				continue
+			}
+			if header.Finalize {
+				pipeline <- struct{}{}
+				requests.Add(1)
+				go func() {
+					defer requests.Done()
+					defer func() { <-pipeline }()
+					defer server.recoverRequest(clientConn, requestID, header, nil, nil, nil)
+					server.handleFinalize(clientConn, requestID, header)
+				}()
+				continue
+			}
+			if header.Source != "" {
+				transfer := requestTransfer{bytesReceived: int64(length), started: frameTime}
+				tracked.requests.Add(1)
+				pipeline <- struct{}{}
+				requests.Add(1)
+				go func() {
+					defer requests.Done()
+					defer func() { <-pipeline }()
+					defer server.recoverRequest(clientConn, requestID, header, nil, nil, nil)
+					server.handleSourceRequest(clientConn, requestID, header, transfer, workerChannels)
+				}()
+				continue
+			}
+			if _, replaced := headers[requestID]; !replaced && len(headers) >= maxPipelinedRequests {
+				server.sendError(clientConn, requestID, protocol.CodeBadRequest,
+					fmt.Errorf("more than %d requests waiting for their image", maxPipelinedRequests))
+				continue
+			}
+			headers[requestID] = pendingRequest{
+				header: header,
+				transfer: requestTransfer{
+					bytesReceived: int64(length),
+					started:       frameTime,
+				},
+			}
+
+		case protocol.FrameImage:
+			request, ok := headers[requestID]
+			delete(headers, requestID)
+
+			if length > server.config.MaxPayloadSize {
+				if server.discard(conn, length) != nil {
+					return
+				}
+				status := server.sendError(clientConn, requestID, protocol.CodeTooLarge,
+					fmt.Errorf("image of %d bytes exceeds the limit of %d bytes", length, server.config.MaxPayloadSize))
+				server.logAccess(&accessEntry{
+					remoteAddr: conn.RemoteAddr().String(),
+					client:     client,
+					requestID:  requestID,
+					status:     status,
+					transfer:   request.transfer,
+				})
+				continue
+			}
+
+			server.logger.Printf("Receiving image for request %d...", requestID)
+			data, err := server.receiveImage(conn, length)
+			if err != nil {
+				server.logger.Printf("Failed to receive image from %s: %v", conn.RemoteAddr(), err)
+				return
+			}
+			server.logger.Println("Image received successfully!")
+			request.transfer.bytesReceived += int64(length)
+			request.transfer.received = time.Now()
+
+			if !ok {
+				server.sendError(clientConn, requestID, protocol.CodeBadRequest, errors.New("image sent without request header"))
+				continue
+			}
+
+			tracked.requests.Add(1)
+			pipeline <- struct{}{}
+			requests.Add(1)
+			go func() {
+				defer requests.Done()
+				defer func() { <-pipeline }()
+				server.handleRequest(clientConn, requestID, request.header, data, request.transfer, workerChannels)
+			}()
+
+		case protocol.FrameAuth:
+			if server.discard(conn, length) != nil {
+				return
+			}
+
+		default:
+			if server.discard(conn, length) != nil {
+				return
+			}
+			server.sendError(clientConn, requestID, protocol.CodeBadRequest, fmt.Errorf("unexpected %s frame", frameType))
+		}
+	}
+}
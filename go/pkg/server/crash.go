@@ -0,0 +1,117 @@
+package server
+
+/*
+This file implements the panic handler of the server. A panic on the goroutine of a request, or of a job, or on a
+worker processing one of their tasks, used to kill the process and every connection with it: a bug triggered by the
+image of one client took the server down for all of them. The panics are now recovered where the request, the job or
+the connection starts, and only fail the request they happened in.
+
+A recovered panic is logged with its stack, counted in `GET /stats`, and saved as a crash bundle when `Config.CrashDir`
+is set: a debug bundle (see `debug.go`) whose report also gives the value of the panic and its stack, with the
+intermediate results of the request if debug bundles are enabled as well, and the received image if
+`Config.CrashInput` is set, so `server replay` can reproduce the crash. The client receives a `protocol.CodeInternal`
+error giving the ID of the bundle, which it can pass on in a bug report.
+
+The panics of the workers are recovered by `worker.TreatmentWorker` (see `worker.PanicError`), which keeps the worker
+running and returns the panic as the error of the task: the stack is then that of the worker, not of the request.
+
+---
+
+### `(server *Server) recoverRequest(conn *connection, requestID uint32, header protocol.Header, data []byte, capture *debugCapture, status *string)`
+Deferred by the goroutines of the requests: recovers a panic, saves its crash bundle and answers the request with
+the error, whose code is stored in `status` unless it is nil. Does nothing if the request did not panic.
+
+### `(server *Server) recoverJob(job jobs.Job)`
+Deferred by the goroutines of the asynchronous jobs: recovers a panic, saves its crash bundle (without the received
+image, which the job no longer holds) and fails the job.
+
+### `(server *Server) recoverConnection(conn net.Conn)`
+Deferred by the goroutines of the connections: recovers a panic of the connection loop, outside of any request, and
+saves its crash bundle. The connection is then closed, as nothing tells which request to answer.
+
+### `(server *Server) savePanic(source string, report DebugReport, data []byte, capture *debugCapture, value any, stack []byte) error`
+Logs the panic of `source`, the request or the job it happened in, saves its crash bundle and returns the
+`protocol.CodeInternal` error to send back, with the ID of the bundle appended to its message if it was written. The
+value of the panic is only given in the bundle and the log: it may disclose the internals of the server.
+
+### `workerPanic(err error) (*worker.PanicError, bool)`
+Returns the panic of a worker `err` wraps, if any.
+*/
+
+import (
+	"ELP-project/internal/jobs"
+	"ELP-project/internal/protocol"
+	"ELP-project/internal/worker"
+	"errors"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"time"
+)
+
+func (server *Server) recoverRequest(conn *connection, requestID uint32, header protocol.Header, data []byte, capture *debugCapture, status *string) {
+	value := recover()
+	if value == nil {
+		return
+	}
+
+	err := server.savePanic(fmt.Sprintf("request %d from %s", requestID, conn.RemoteAddr()), DebugReport{
+		RemoteAddr: conn.RemoteAddr().String(),
+		Client:     conn.client,
+		RequestID:  requestID,
+		Header:     header,
+	}, data, capture, value, debug.Stack())
+	code := server.sendError(conn, requestID, protocol.CodeInternal, err)
+	if status != nil {
+		*status = code
+	}
+}
+
+func (server *Server) recoverJob(job jobs.Job) {
+	value := recover()
+	if value == nil {
+		return
+	}
+
+	err := server.savePanic("job "+job.ID, DebugReport{Client: job.Client, JobID: job.ID, Header: job.Request}, nil, nil, value, debug.Stack())
+	server.jobs.Fail(job.ID, err)
+	server.notifyJob(job.ID)
+}
+
+func (server *Server) recoverConnection(conn net.Conn) {
+	value := recover()
+	if value == nil {
+		return
+	}
+
+	server.savePanic("connection from "+conn.RemoteAddr().String(), DebugReport{RemoteAddr: conn.RemoteAddr().String()}, nil, nil, value, debug.Stack())
+}
+
+func (server *Server) savePanic(source string, report DebugReport, data []byte, capture *debugCapture, value any, stack []byte) error {
+	server.stats.panics.Add(1)
+	server.logger.Printf("Panic serving %s: %v\n%s", source, value, stack)
+
+	errorMessage := protocol.ErrorMessage{Code: protocol.CodeInternal, Message: "internal server error"}
+	report.Time = time.Now().UTC()
+	report.Code = errorMessage.Code
+	report.Error = errorMessage.Message
+	report.Panic = fmt.Sprint(value)
+	report.Stack = string(stack)
+	report.InputBytes = len(data)
+
+	id, err := server.crashes.save(report, data, capture)
+	if err != nil {
+		server.logger.Printf("Error saving crash bundle of %s: %v", source, err)
+	}
+	if id != "" {
+		server.logger.Printf("Crash bundle %s saved for %s", id, source)
+		errorMessage.Message += " (crash bundle " + id + ")"
+	}
+	return errorMessage
+}
+
+func workerPanic(err error) (*worker.PanicError, bool) {
+	var panicErr *worker.PanicError
+	ok := errors.As(err, &panicErr)
+	return panicErr, ok
+}
@@ -0,0 +1,353 @@
+package server
+
+/*
+This file implements the debug bundles of the failed requests: when `Config.DebugDir` is set, every synchronous
+request which fails is persisted with its intermediate results in a directory of its own, so the failure can be
+reproduced, e.g. by attaching the bundle to a bug report. The ID of the bundle is appended to the error message sent
+to the client, which can pass it on.
+
+---
+
+### Bundle
+A bundle is a directory of `Config.DebugDir` named by its ID, `<UTC time>-<random hex>` so the bundles sort by age.
+It contains:
+- `request.json`: The request header, the client, the error sent back and the list of the files of the bundle
+  (`DebugReport`).
+- `input.<ext>`: The received bytes, untouched, with the extension of their format (`.bin` if it is not recognized).
+- `grayscale.png`, `edges.png`: The intermediate images computed before the failure, if any.
+- `histograms.png`: The histograms of the grayscale image and of its gradients, with the thresholds of the edge
+  detection of the preset of the request (see `utils.RenderHistograms`), if the grayscale image was computed. They are rendered when the bundle
+  is written, so the requests which succeed do not pay for them.
+- `contours.json`: The contours found in the edge map and the detected document (`debugContours`), if the
+  processing got that far.
+
+The intermediate results of a request are only kept when bundles are enabled: the grayscale image is then assembled
+for every request, as if the client asked for it as an artifact. Once a bundle is written, the oldest ones are deleted
+beyond `Config.DebugKeep` bundles. Asynchronous jobs keep their error in the job registry instead, and estimates are
+not bundled.
+
+The crash bundles of the requests whose processing panicked (see `crash.go`) have the same layout, in
+`Config.CrashDir`: their report gives the panic and its stack as well, and they only keep the received image if
+`Config.CrashInput` is set.
+
+---
+
+### `debugBundles`
+Directory of the bundles, the number of bundles kept and whether they keep the received image, nil if bundles are
+disabled. `mutex` serializes the deletion of the oldest bundles.
+
+### `debugCapture`
+Intermediate results of a request, recorded by the processing pipeline (`requestOptions.debug`). Its methods do
+nothing on a nil capture, so the pipeline records its results without checking whether bundles are enabled.
+
+- Methods:
+  - `keepImage(name string, img image.Image)`: Records an intermediate image (`protocol.ArtifactGrayscale`,
+    `protocol.ArtifactEdges`).
+  - `keepContours(contours []geometry.Contour, document geometry.ContourWithArea)`: Records copies of the contours
+    and of the detected document: their points are in the arenas of the request, reused by the next request once
+    it is done, while the bundle is only saved after it failed.
+
+### `DebugReport`
+Content of `request.json`, read back by `server replay` (see `cmd/server/replay.go`).
+
+- Fields:
+  - `ID`: ID of the bundle.
+  - `Time`: When the request failed, in UTC.
+  - `RemoteAddr`, `Client`: Address of the client and name of its API key.
+  - `RequestID`: ID of the request on its connection.
+  - `JobID`: ID of the asynchronous job, for the crash of a job.
+  - `Header`: Header of the request, as received.
+  - `Code`, `Error`: Error code and message sent to the client, without the ID of the bundle.
+  - `Panic`, `Stack`: Value the processing panicked with and the stack of the goroutine which panicked, for a crash
+    bundle.
+  - `InputBytes`: Size of the received image.
+  - `Files`: Names of the files of the bundle, apart from `request.json`.
+
+### `debugContours`
+Content of `contours.json`.
+
+---
+
+### `newDebugBundles(dir string, keep int, input bool) (*debugBundles, error)`
+Creates the directory of the bundles, which keep the received image if `input` is true. Returns nil if `dir` is
+empty.
+
+### `(bundles *debugBundles) save(report DebugReport, data []byte, capture *debugCapture) (string, error)`
+Writes the bundle of a failed request and deletes the oldest bundles beyond the limit. Returns the ID of the bundle,
+or an empty ID if bundles are disabled or the bundle could not be written, in which case nothing is left of it.
+
+### `(bundles *debugBundles) prune() error`
+Deletes the oldest bundles beyond the limit.
+
+### `(server *Server) saveBundle(conn *connection, requestID uint32, header protocol.Header, data []byte, capture *debugCapture, code string, err error) error`
+Persists the bundle of a failed request, and returns the error to send to the client: `err`, with the ID of the
+bundle appended to its message. Errors writing the bundle are logged, `err` is then returned unchanged.
+
+### `newBundleID() (string, error)`
+Generates the ID of a new bundle.
+
+### `ReadDebugBundle(dir string) (DebugReport, []byte, error)`
+Reads the report and the received image of the bundle in `dir`.
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"ELP-project/internal/protocol"
+	"ELP-project/internal/utils"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	debugReportFile   = "request.json"
+	debugContoursFile = "contours.json"
+)
+
+type debugBundles struct {
+	dir   string
+	keep  int
+	input bool
+	mutex sync.Mutex
+}
+
+type debugCapture struct {
+	mutex    sync.Mutex
+	images   map[string]image.Image
+	contours *debugContours
+}
+
+type DebugReport struct {
+	ID         string          `json:"id"`
+	Time       time.Time       `json:"time"`
+	RemoteAddr string          `json:"remote_addr"`
+	Client     string          `json:"client,omitempty"`
+	RequestID  uint32          `json:"request_id"`
+	JobID      string          `json:"job_id,omitempty"`
+	Header     protocol.Header `json:"header"`
+	Code       string          `json:"code"`
+	Error      string          `json:"error"`
+	Panic      string          `json:"panic,omitempty"`
+	Stack      string          `json:"stack,omitempty"`
+	InputBytes int             `json:"input_bytes"`
+	Files      []string        `json:"files"`
+}
+
+type debugContours struct {
+	Contours []geometry.Contour `json:"contours"`
+	Document geometry.Contour   `json:"document"`
+	Area     float64            `json:"area"`
+}
+
+func newDebugBundles(dir string, keep int, input bool) (*debugBundles, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating debug directory: %w", err)
+	}
+	return &debugBundles{dir: dir, keep: keep, input: input}, nil
+}
+
+func (capture *debugCapture) keepImage(name string, img image.Image) {
+	if capture == nil {
+		return
+	}
+	capture.mutex.Lock()
+	defer capture.mutex.Unlock()
+
+	if capture.images == nil {
+		capture.images = make(map[string]image.Image)
+	}
+	capture.images[name] = img
+}
+
+func (capture *debugCapture) keepContours(contours []geometry.Contour, document geometry.ContourWithArea) {
+	if capture == nil {
+		return
+	}
+	capture.mutex.Lock()
+	defer capture.mutex.Unlock()
+
+	copies := make([]geometry.Contour, len(contours))
+	for i, contour := range contours {
+		copies[i] = slices.Clone(contour)
+	}
+	capture.contours = &debugContours{Contours: copies, Document: slices.Clone(document.Contour), Area: document.Area}
+}
+
+func (bundles *debugBundles) save(report DebugReport, data []byte, capture *debugCapture) (id string, err error) {
+	if bundles == nil {
+		return "", nil
+	}
+
+	if id, err = newBundleID(); err != nil {
+		return "", err
+	}
+	report.ID = id
+	dir := filepath.Join(bundles.dir, id)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", err
+	}
+	defer func() {
+		if id == "" {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	files := make(map[string][]byte)
+
+	if bundles.input && data != nil {
+		extension := ".bin"
+		if _, format, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+			extension = "." + format
+			if format == "jpeg" {
+				extension = ".jpg"
+			}
+		}
+		files["input"+extension] = data
+	}
+
+	if capture != nil {
+		capture.mutex.Lock()
+		if gray, ok := capture.images[protocol.ArtifactGrayscale].(*image.Gray); ok && capture.images[protocol.ArtifactHistograms] == nil {
+			parameters := utils.DefaultCannyParameters
+			if preset, err := lookupPreset(report.Header.Preset); err == nil {
+				parameters = preset.canny
+			}
+			capture.images[protocol.ArtifactHistograms] = utils.RenderHistograms(gray, parameters)
+		}
+		for _, name := range artifactNames {
+			if img, ok := capture.images[name]; ok {
+				encoded, err := encodeImage(img, "png")
+				if err != nil {
+					capture.mutex.Unlock()
+					return "", err
+				}
+				files[name+".png"] = encoded
+			}
+		}
+		if capture.contours != nil {
+			encoded, err := json.Marshal(capture.contours)
+			if err != nil {
+				capture.mutex.Unlock()
+				return "", err
+			}
+			files[debugContoursFile] = encoded
+		}
+		capture.mutex.Unlock()
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			return "", err
+		}
+		report.Files = append(report.Files, name)
+	}
+	slices.Sort(report.Files)
+
+	encoded, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, debugReportFile), append(encoded, '\n'), 0644); err != nil {
+		return "", err
+	}
+
+	return id, bundles.prune()
+}
+
+func (bundles *debugBundles) prune() error {
+	bundles.mutex.Lock()
+	defer bundles.mutex.Unlock()
+
+	entries, err := os.ReadDir(bundles.dir)
+	if err != nil {
+		return err
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			ids = append(ids, entry.Name())
+		}
+	}
+	if len(ids) <= bundles.keep {
+		return nil
+	}
+
+	// os.ReadDir sorts by name, which starts with the time of the bundle.
+	for _, id := range ids[:len(ids)-bundles.keep] {
+		if err := os.RemoveAll(filepath.Join(bundles.dir, id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (server *Server) saveBundle(conn *connection, requestID uint32, header protocol.Header, data []byte, capture *debugCapture, code string, err error) error {
+	var errorMessage protocol.ErrorMessage
+	if errors.As(err, &errorMessage) {
+		code = errorMessage.Code
+	} else {
+		errorMessage = protocol.ErrorMessage{Code: code, Message: err.Error()}
+	}
+
+	id, saveErr := server.debug.save(DebugReport{
+		Time:       time.Now().UTC(),
+		RemoteAddr: conn.RemoteAddr().String(),
+		Client:     conn.client,
+		RequestID:  requestID,
+		Header:     header,
+		Code:       code,
+		Error:      errorMessage.Message,
+		InputBytes: len(data),
+	}, data, capture)
+	if saveErr != nil {
+		server.logger.Printf("Error saving debug bundle of request %d from %s: %v", requestID, conn.RemoteAddr(), saveErr)
+	}
+	if id == "" {
+		return err
+	}
+
+	server.logger.Printf("Debug bundle %s saved for request %d from %s", id, requestID, conn.RemoteAddr())
+	errorMessage.Message += " (debug bundle " + id + ")"
+	return errorMessage
+}
+
+func newBundleID() (string, error) {
+	var random [4]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return "", err
+	}
+	return time.Now().UTC().Format("20060102T150405.000Z") + "-" + hex.EncodeToString(random[:]), nil
+}
+
+func ReadDebugBundle(dir string) (DebugReport, []byte, error) {
+	var report DebugReport
+	data, err := os.ReadFile(filepath.Join(dir, debugReportFile))
+	if err != nil {
+		return report, nil, err
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return report, nil, fmt.Errorf("parsing %s: %w", debugReportFile, err)
+	}
+
+	for _, name := range report.Files {
+		if strings.HasPrefix(name, "input.") {
+			input, err := os.ReadFile(filepath.Join(dir, name))
+			return report, input, err
+		}
+	}
+	return report, nil, fmt.Errorf("no input image in bundle %s", dir)
+}
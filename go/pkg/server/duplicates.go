@@ -0,0 +1,143 @@
+package server
+
+/*
+This file implements the duplicate-submission detection used by the server.
+
+---
+
+### `recentResults`
+Remembers, for every client host, the content hash of the images it recently submitted together with the encoded result
+that was sent back, and the color statistics, recognized text, rotation, lossless crop, document corners,
+alternative candidates and split spread of its metadata. When a client sends exactly the same bytes again within
+`duplicateWindow`, the cached result is returned immediately instead of running the whole processing pipeline a second
+time.
+
+- Fields:
+  - `mutex`: Protects the entries, the cache is shared by every connection handler.
+  - `entries`: Cached results indexed by client host, then by the SHA-256 digest of the received image.
+
+- Methods:
+  - `lookup(client string, digest [32]byte) (recentResult, bool)`: Returns the cached result if the image is a
+    duplicate.
+  - `store(client string, digest [32]byte, entry recentResult)`: Records the result sent for an image, with its
+    metadata. The time of `entry` is set to now.
+
+---
+
+### `submissionDigest(header protocol.Header, data []byte) [32]byte`
+Computes the SHA-256 digest identifying a submission: the request header and the image data are both hashed,
+so the same image sent with different options is not considered a duplicate. Asking for progress reports does not
+change the result, it is left out of the digest.
+
+---
+
+### Behavior
+- A "session" is identified by the remote host of the connection, so that successive connections of a batch
+  are grouped together.
+- Entries older than `duplicateWindow` are dropped lazily on every access.
+- At most `maxRecentPerClient` entries are kept per client, the oldest one is evicted first.
+*/
+
+import (
+	"ELP-project/internal/protocol"
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	duplicateWindow    = 10 * time.Minute
+	maxRecentPerClient = 32
+)
+
+type recentResult struct {
+	result       []byte
+	colors       *protocol.ColorStats
+	text         string
+	rotation     int
+	lossless     bool
+	corners      []protocol.Point
+	alternatives []protocol.Candidate
+	spread       bool
+	storedAt     time.Time
+}
+
+type recentResults struct {
+	mutex   sync.Mutex
+	entries map[string]map[[32]byte]recentResult
+}
+
+func newRecentResults() *recentResults {
+	return &recentResults{
+		entries: make(map[string]map[[32]byte]recentResult),
+	}
+}
+
+func (recent *recentResults) lookup(client string, digest [32]byte) (recentResult, bool) {
+	recent.mutex.Lock()
+	defer recent.mutex.Unlock()
+
+	recent.expire(client)
+
+	entry, ok := recent.entries[client][digest]
+	return entry, ok
+}
+
+func (recent *recentResults) store(client string, digest [32]byte, entry recentResult) {
+	recent.mutex.Lock()
+	defer recent.mutex.Unlock()
+
+	recent.expire(client)
+
+	clientEntries, ok := recent.entries[client]
+	if !ok {
+		clientEntries = make(map[[32]byte]recentResult)
+		recent.entries[client] = clientEntries
+	}
+
+	if len(clientEntries) >= maxRecentPerClient {
+		var oldestDigest [32]byte
+		var oldest time.Time
+		for key, entry := range clientEntries {
+			if oldest.IsZero() || entry.storedAt.Before(oldest) {
+				oldest = entry.storedAt
+				oldestDigest = key
+			}
+		}
+		delete(clientEntries, oldestDigest)
+	}
+
+	entry.storedAt = time.Now()
+	clientEntries[digest] = entry
+}
+
+func (recent *recentResults) expire(client string) {
+	clientEntries, ok := recent.entries[client]
+	if !ok {
+		return
+	}
+
+	for key, entry := range clientEntries {
+		if time.Since(entry.storedAt) > duplicateWindow {
+			delete(clientEntries, key)
+		}
+	}
+
+	if len(clientEntries) == 0 {
+		delete(recent.entries, client)
+	}
+}
+
+func submissionDigest(header protocol.Header, data []byte) [32]byte {
+	header.Progress = false
+
+	hash := sha256.New()
+	encodedHeader, _ := json.Marshal(header)
+	hash.Write(encodedHeader)
+	hash.Write(data)
+
+	var digest [32]byte
+	copy(digest[:], hash.Sum(nil))
+	return digest
+}
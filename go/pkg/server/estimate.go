@@ -0,0 +1,157 @@
+package server
+
+/*
+This file implements the estimate of the processing cost of an image (`protocol.OperationEstimate`): the server reads
+only the header of the image, and answers with its size, the number of chunks it would be split into and the expected
+duration of every stage, so orchestrators can schedule large jobs before sending them for processing.
+
+---
+
+### Cost model
+The duration of a stage is proportional to the number of pixels it processes, at the rate of the `calibration` of
+the server:
+- The stages run by the workers (grayscale, edge detection, contours) are spread over the chunks of the image, as
+  many at a time as there are workers. The chunks of the grayscale conversion and of the edge detection overlap by
+  `overlapSize` rows, which are processed twice.
+- The other stages (closing of the edges, document detection, crop, enhancement, anonymization, stamp, encoding)
+  run once, on the whole image. The closing, the enhancement, the anonymization and the stamp only count for the
+  requests asking for them, and are estimated on the whole image rather than on the document, which is not known
+  yet.
+The blur is calibrated with the kernel of `utils.DefaultCannyParameters`: its duration grows with the area of the
+kernel of the preset of the request. The closing is calibrated with a radius of 1: its duration grows with the side
+of the square of the preset. The upload and the sending of the result depend on the network and are not
+estimated, nor is the time a request waits for a free worker while the server is busy.
+
+---
+
+### `calibration`
+Processing rate of every stage, in milliseconds per megapixel for a single worker, indexed by the stages of
+`protocol.StageTiming`.
+
+### `defaultCalibration`
+Rates measured with a single worker on a development machine, used until the server is calibrated on its host.
+
+### `parallelStages` / `overlappingStages`
+The stages run by the workers, and the ones among them processing overlapping chunks.
+
+---
+
+### `estimate(width, height int, options requestOptions) protocol.Estimate`
+Estimates the cost of processing an image of `width` x `height` pixels with the current number of workers.
+
+### `sendEstimate(conn *connection, requestID uint32, data []byte, options requestOptions, entry *accessEntry) string`
+Answers an `OperationEstimate` request from the header of its image, and returns the status of the access log
+entry.
+*/
+
+import (
+	"ELP-project/internal/protocol"
+	"ELP-project/internal/utils"
+	"bytes"
+	"fmt"
+	"image"
+	"slices"
+)
+
+type calibration map[string]float64
+
+var defaultCalibration = calibration{
+	protocol.TimingGrayscale:     70,
+	protocol.TimingBlur:          210,
+	protocol.TimingSobel:         120,
+	protocol.TimingNMS:           40,
+	protocol.TimingHysteresis:    250,
+	protocol.TimingMorphology:    70,
+	protocol.TimingBFS:           12,
+	protocol.TimingQuadrilateral: 1,
+	protocol.TimingHough:         180,
+	protocol.TimingCrop:          3,
+	protocol.TimingEnhance:       90,
+	protocol.TimingAnonymize:     30,
+	protocol.TimingStamp:         3,
+	protocol.TimingEncode:        25,
+}
+
+var parallelStages = []string{
+	protocol.TimingGrayscale,
+	protocol.TimingBlur,
+	protocol.TimingSobel,
+	protocol.TimingNMS,
+	protocol.TimingHysteresis,
+	protocol.TimingBFS,
+}
+
+var overlappingStages = []string{
+	protocol.TimingGrayscale,
+	protocol.TimingBlur,
+	protocol.TimingSobel,
+	protocol.TimingNMS,
+	protocol.TimingHysteresis,
+}
+
+func (server *Server) estimate(width, height int, options requestOptions) protocol.Estimate {
+	chunks := server.chunkCount(options)
+	estimate := protocol.Estimate{
+		Width:      width,
+		Height:     height,
+		Megapixels: float64(width*height) / 1e6,
+		Chunks:     chunks,
+	}
+
+	rounds := 1
+	if workers := server.workerCount(); workers > 0 {
+		rounds = (chunks + workers - 1) / workers
+	}
+	overlap := 1.0
+	if height > 0 {
+		overlap = float64(min(height+chunks*overlapSize, chunks*height)) / float64(height)
+	}
+
+	for _, stage := range timingStages {
+		rate, ok := server.calibration[stage]
+		if !ok || (stage == protocol.TimingMorphology && options.closing == 0) || (stage == protocol.TimingHough && !options.hough) ||
+			(stage == protocol.TimingEnhance && options.enhance == nil) ||
+			(stage == protocol.TimingAnonymize && !options.anonymize) || (stage == protocol.TimingStamp && options.stamp == nil) {
+			continue
+		}
+
+		millis := rate * estimate.Megapixels
+		if stage == protocol.TimingBlur {
+			kernel := float64(options.canny.BlurKernelSize) / float64(utils.DefaultCannyParameters.BlurKernelSize)
+			millis *= kernel * kernel
+		}
+		if stage == protocol.TimingMorphology {
+			millis *= float64(2*options.closing+1) / 3
+		}
+		if slices.Contains(parallelStages, stage) {
+			if slices.Contains(overlappingStages, stage) {
+				millis *= overlap
+			}
+			millis = millis / float64(chunks) * float64(rounds)
+		}
+		estimate.Stages = append(estimate.Stages, protocol.StageTiming{Stage: stage, Millis: millis})
+		estimate.Millis += millis
+	}
+
+	return estimate
+}
+
+func (server *Server) sendEstimate(conn *connection, requestID uint32, data []byte, options requestOptions, entry *accessEntry) string {
+	imageConfig, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return server.sendError(conn, requestID, protocol.CodeBadRequest, fmt.Errorf("decoding image header: %w", err))
+	}
+	if err := server.checkDimensions(imageConfig.Width, imageConfig.Height); err != nil {
+		return server.sendError(conn, requestID, protocol.CodeBadRequest, err)
+	}
+
+	estimate := server.estimate(imageConfig.Width, imageConfig.Height, options)
+	server.logger.Printf("Estimated %.1f ms for a %dx%d image from %s", estimate.Millis, imageConfig.Width,
+		imageConfig.Height, conn.RemoteAddr())
+	server.sendTimedResponse(conn, requestID, &protocol.Metadata{
+		Format:   options.outputFormat(format),
+		Estimate: &estimate,
+		Stats:    server.transferStats(conn, entry.transfer, 0, 0),
+	}, nil, nil, entry)
+	return "estimated"
+}
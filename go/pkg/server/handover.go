@@ -0,0 +1,305 @@
+package server
+
+/*
+This file implements the handover of the listening sockets of the server to a new process, so the server can be
+upgraded or restarted without refusing a single connection.
+
+---
+
+### Handover
+1. `Handover` is called on the running process: the command calls it on `SIGHUP`, the admin interface on
+   `POST /handover`. It starts the executable of the server again with the same arguments, and passes it the
+   listening sockets of the server and of the admin interface as inherited file descriptors.
+2. The new process accepts the connections of the inherited sockets right away, then reports that it is ready.
+3. The old process closes its copies of the sockets and, once the connections it accepted meanwhile are handed to
+   their handlers, drains (see `drain`): the connections, requests and jobs in progress are finished, then it
+   stops. The sockets stay open in the new process the whole time, so no connection is refused: a connection
+   arriving in between waits in the backlog of its socket.
+4. Until the old process has exited, the new one keeps its job registry in standby mode (see `internal/jobs`): the
+   jobs of the old process are left to it. Once it has exited, the new process takes the registry over and resumes
+   the jobs which were interrupted, as after a restart.
+
+If the new process cannot start or is not ready within `handoverTimeout`, it is killed and the old process keeps
+serving. Clients keeping an idle connection open are still served by the old process, which stops once they
+disconnect.
+
+### Inherited file descriptors
+Besides the standard streams, the new process receives:
+- 3: the writing end of the pipe on which it reports that it is ready,
+- 4: the reading end of a pipe kept open by the old process, which reaches its end once the old process exited,
+- 5 and above: the listeners of the server (their number is given by `handoverListenersEnvironment`), then the
+  listener of the admin interface if `handoverAdminEnvironment` is set.
+
+### `SO_REUSEPORT`
+Alternatively, with `Config.ReusePort` (Linux only), several processes listen on the same TCP addresses: the new
+process is started independently, then the old one is drained. The kernel spreads the new connections across the
+processes listening meanwhile.
+
+---
+
+### `inheritance`
+Sockets and pipes inherited by a process started by `Handover`.
+
+- Fields:
+  - `listeners`: The listeners of the server.
+  - `admin`: The listener of the admin interface, nil if the old process had none.
+  - `ready`: Pipe on which the process reports that it is ready.
+  - `parent`: Pipe reaching its end once the old process exited.
+
+---
+
+### `inherit() (*inheritance, error)`
+Returns the sockets inherited from the old process, nil if the process was not started by a handover.
+
+### `fileListener(fd uintptr) (net.Listener, error)`
+Creates a listener from an inherited file descriptor.
+
+### `Handover() error`
+Starts a new process taking the listeners over, and drains the server once it is ready. Fails, without stopping
+the server, if the new process cannot take over.
+
+### `handoverCommand(executable string, ready *os.File, parent *os.File) (*exec.Cmd, error)`
+Prepares the command starting `executable` with the arguments of the process to take the listeners over: its
+environment gives the number of listeners, and it inherits `ready`, `parent`, then a copy of every listener (see
+Inherited file descriptors). The caller closes the inherited files once the command is started.
+
+### `listenerFile(listener net.Listener) (*os.File, error)`
+Returns a copy of the file descriptor of `listener`, to be inherited by the new process (see `handover_unix.go`). The
+copy is made with `dup` rather than `File`: the file returned by `File` switches the socket it shares with the
+listener to blocking mode once it is passed to a process, which would leave the accept loop of the server stuck in
+the kernel and its listener impossible to close, whether the handover succeeds or not. Fails on the platforms other
+than Unix.
+
+### `closeFiles(files []*os.File)`
+Closes every file of `files`.
+
+### `handedListeners() []net.Listener`
+Returns the listeners of the server, the ones merged in a `listenerSet` included.
+
+### `waitReady(ready *os.File) error`
+Waits until the new process reports that it is ready, for at most `handoverTimeout`.
+
+### `takeOver()`
+Run by the new process once it serves: reports that it is ready, waits for the old process to exit, then takes
+the job registry over and resumes the interrupted jobs.
+
+### `reusePort(network string, address string, conn syscall.RawConn) error`
+Sets `SO_REUSEPORT` on a listening socket, before it is bound (see `reuseport_linux.go`). Fails on the other
+platforms.
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	handoverListenersEnvironment = "ELP_HANDOVER_LISTENERS"
+	handoverAdminEnvironment     = "ELP_HANDOVER_ADMIN"
+
+	handoverTimeout = 30 * time.Second
+
+	handoverReadyFD     = 3
+	handoverParentFD    = 4
+	handoverListenersFD = 5
+)
+
+type inheritance struct {
+	listeners []net.Listener
+	admin     net.Listener
+	ready     *os.File
+	parent    *os.File
+}
+
+func inherit() (*inheritance, error) {
+	count, ok := os.LookupEnv(handoverListenersEnvironment)
+	if !ok {
+		return nil, nil
+	}
+	_, admin := os.LookupEnv(handoverAdminEnvironment)
+	os.Unsetenv(handoverListenersEnvironment)
+	os.Unsetenv(handoverAdminEnvironment)
+
+	listeners, err := strconv.Atoi(count)
+	if err != nil || listeners < 1 {
+		return nil, fmt.Errorf("invalid %s: %q", handoverListenersEnvironment, count)
+	}
+
+	inherited := &inheritance{
+		ready:  os.NewFile(handoverReadyFD, "handover-ready"),
+		parent: os.NewFile(handoverParentFD, "handover-parent"),
+	}
+	for i := range listeners {
+		listener, err := fileListener(uintptr(handoverListenersFD + i))
+		if err != nil {
+			return nil, err
+		}
+		inherited.listeners = append(inherited.listeners, listener)
+	}
+	if admin {
+		if inherited.admin, err = fileListener(uintptr(handoverListenersFD + listeners)); err != nil {
+			return nil, err
+		}
+	}
+
+	return inherited, nil
+}
+
+func fileListener(fd uintptr) (net.Listener, error) {
+	file := os.NewFile(fd, "handover-listener")
+	defer file.Close()
+
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("inheriting listener %d: %w", fd, err)
+	}
+	return listener, nil
+}
+
+func (server *Server) Handover() error {
+	server.handoverMutex.Lock()
+	defer server.handoverMutex.Unlock()
+
+	if server.draining.Load() || server.handedOver.Load() {
+		return errors.New("server is draining")
+	}
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyReader.Close()
+	parentReader, parentWriter, err := os.Pipe()
+	if err != nil {
+		readyWriter.Close()
+		return err
+	}
+
+	var command *exec.Cmd
+	executable, err := os.Executable()
+	if err == nil {
+		command, err = server.handoverCommand(executable, readyWriter, parentReader)
+	}
+	if err != nil {
+		readyWriter.Close()
+		parentReader.Close()
+		parentWriter.Close()
+		return err
+	}
+
+	err = command.Start()
+	closeFiles(command.ExtraFiles)
+	if err != nil {
+		parentWriter.Close()
+		return fmt.Errorf("starting new process: %w", err)
+	}
+	server.logger.Printf("Started process %d to take the listeners over", command.Process.Pid)
+
+	if err := waitReady(readyReader); err != nil {
+		command.Process.Kill()
+		command.Wait()
+		parentWriter.Close()
+		return fmt.Errorf("process %d did not take over: %w", command.Process.Pid, err)
+	}
+	go command.Wait()
+	server.successor = parentWriter
+
+	for _, listener := range server.handedListeners() {
+		if unixListener, ok := listener.(*net.UnixListener); ok {
+			unixListener.SetUnlinkOnClose(false)
+		}
+	}
+
+	server.logger.Printf("Listeners handed over to process %d", command.Process.Pid)
+	server.handedOver.Store(true)
+	if err := server.listener.Close(); err != nil {
+		server.logger.Printf("Error closing listener: %v", err)
+	}
+	if server.adminListener != nil {
+		server.adminListener.Close()
+	}
+	return nil
+}
+
+func (server *Server) handoverCommand(executable string, ready *os.File, parent *os.File) (*exec.Cmd, error) {
+	listeners := slices.Clone(server.handedListeners())
+	if server.adminListener != nil {
+		listeners = append(listeners, server.adminListener)
+	}
+
+	files := []*os.File{ready, parent}
+	for _, listener := range listeners {
+		file, err := listenerFile(listener)
+		if err != nil {
+			closeFiles(files[2:])
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	command := exec.Command(executable, os.Args[1:]...)
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	command.Env = append(os.Environ(), fmt.Sprintf("%s=%d", handoverListenersEnvironment, len(server.handedListeners())))
+	if server.adminListener != nil {
+		command.Env = append(command.Env, handoverAdminEnvironment+"=1")
+	}
+	command.ExtraFiles = files
+	return command, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
+
+func (server *Server) handedListeners() []net.Listener {
+	if set, ok := server.listener.(*listenerSet); ok {
+		return set.listeners
+	}
+	return []net.Listener{server.listener}
+}
+
+func waitReady(ready *os.File) error {
+	if err := ready.SetReadDeadline(time.Now().Add(handoverTimeout)); err != nil {
+		return err
+	}
+
+	var buffer [1]byte
+	if _, err := ready.Read(buffer[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("process exited before being ready")
+		}
+		return err
+	}
+	return nil
+}
+
+func (server *Server) takeOver() {
+	inherited := server.inherited
+	if _, err := inherited.ready.Write([]byte{1}); err != nil {
+		server.logger.Printf("Error reporting readiness to the previous process: %v", err)
+	}
+	inherited.ready.Close()
+
+	server.logger.Println("Listeners taken over, waiting for the previous process to exit.")
+	io.Copy(io.Discard, inherited.parent)
+	inherited.parent.Close()
+
+	if server.stopCtx.Err() != nil {
+		return
+	}
+	if err := server.jobs.Takeover(); err != nil {
+		server.logger.Printf("Error taking the jobs over: %v", err)
+	}
+	server.logger.Println("Previous process exited, jobs taken over.")
+	server.resumeJobs(server.channels)
+}
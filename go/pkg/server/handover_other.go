@@ -0,0 +1,17 @@
+//go:build !unix
+
+package server
+
+/*
+This file implements `listenerFile` on the platforms without file descriptor inheritance (see `handover.go`).
+*/
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+func listenerFile(listener net.Listener) (*os.File, error) {
+	return nil, errors.New("listeners can only be handed over on Unix")
+}
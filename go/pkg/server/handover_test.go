@@ -0,0 +1,139 @@
+package server
+
+/*
+This file tests the inheritance of the listeners by the process started by a handover: `inherit` must ignore a process
+started without the handover environment and refuse an invalid one, and the process started by `handoverCommand`
+must inherit every listener of the server, then the one of the admin interface, on the descriptors it announces.
+
+The new process is the test binary itself, running `TestHandoverProcess` only: it inherits the listeners, prints their
+addresses, and reports that it is ready, like a server taking over would.
+*/
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestInherit(t *testing.T) {
+	if _, ok := os.LookupEnv(handoverListenersEnvironment); ok {
+		t.Skip("process started by a handover")
+	}
+	if inherited, err := inherit(); inherited != nil || err != nil {
+		t.Fatalf("inheritance %+v (%v) without a handover, expected none", inherited, err)
+	}
+
+	for _, count := range []string{"", "0", "-1", "two"} {
+		t.Setenv(handoverListenersEnvironment, count)
+		t.Setenv(handoverAdminEnvironment, "1")
+		if inherited, err := inherit(); inherited != nil || err == nil {
+			t.Fatalf("%s=%q inherited %+v, expected an error", handoverListenersEnvironment, count, inherited)
+		}
+		for _, environment := range []string{handoverListenersEnvironment, handoverAdminEnvironment} {
+			if _, ok := os.LookupEnv(environment); ok {
+				t.Fatalf("%s left in the environment of the process", environment)
+			}
+		}
+	}
+}
+
+func TestHandoverCommand(t *testing.T) {
+	var listeners []net.Listener
+	for range 2 {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners = append(listeners, listener)
+	}
+	admin, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{listener: newListenerSet(listeners), adminListener: admin}
+	defer server.listener.Close()
+	defer admin.Close()
+
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readyReader.Close()
+	parentReader, parentWriter, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parentWriter.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	command, err := server.handoverCommand(executable, readyWriter, parentReader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := len(command.ExtraFiles); files != 2+len(server.handedListeners())+1 {
+		t.Fatalf("%d inherited files for %d listeners and the admin one", files, len(server.handedListeners()))
+	}
+
+	var output bytes.Buffer
+	command.Args = []string{executable, "-test.run=^TestHandoverProcess$"}
+	command.Stdout = &output
+	err = command.Start()
+	closeFiles(command.ExtraFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := waitReady(readyReader); err != nil {
+		command.Process.Kill()
+		command.Wait()
+		t.Fatalf("new process not ready: %v\n%s", err, output.String())
+	}
+	if err := command.Wait(); err != nil {
+		t.Fatalf("new process failed: %v\n%s", err, output.String())
+	}
+
+	var expected, inherited []string
+	for _, listener := range listeners {
+		expected = append(expected, "listener "+listener.Addr().String())
+	}
+	expected = append(expected, "admin "+admin.Addr().String())
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "listener ") || strings.HasPrefix(line, "admin ") {
+			inherited = append(inherited, line)
+		}
+	}
+	if strings.Join(inherited, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("new process inherited %q, expected %q", inherited, expected)
+	}
+}
+
+func TestHandoverProcess(t *testing.T) {
+	if _, ok := os.LookupEnv(handoverListenersEnvironment); !ok {
+		t.Skip("not started by a handover")
+	}
+
+	inherited, err := inherit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, listener := range inherited.listeners {
+		os.Stdout.WriteString("listener " + listener.Addr().String() + "\n")
+		listener.Close()
+	}
+	if inherited.admin != nil {
+		os.Stdout.WriteString("admin " + inherited.admin.Addr().String() + "\n")
+		inherited.admin.Close()
+	}
+
+	if _, err := inherited.ready.Write([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	inherited.ready.Close()
+	inherited.parent.Close()
+}
@@ -0,0 +1,42 @@
+//go:build unix
+
+package server
+
+/*
+This file implements `listenerFile` on the Unix platforms (see `handover.go`).
+*/
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+func listenerFile(listener net.Listener) (*os.File, error) {
+	syscallListener, ok := listener.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("listener %s cannot be handed over", listener.Addr())
+	}
+	conn, err := syscallListener.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var fd int
+	var dupErr error
+	syscall.ForkLock.RLock()
+	err = conn.Control(func(sysfd uintptr) {
+		if fd, dupErr = syscall.Dup(int(sysfd)); dupErr == nil {
+			syscall.CloseOnExec(fd)
+		}
+	})
+	syscall.ForkLock.RUnlock()
+	if err == nil {
+		err = dupErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("handing over listener %s: %w", listener.Addr(), err)
+	}
+	return os.NewFile(uintptr(fd), "handover-listener"), nil
+}
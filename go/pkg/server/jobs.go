@@ -0,0 +1,196 @@
+package server
+
+/*
+This file implements the asynchronous mode of the server, backed by the job registry of `internal/jobs`.
+
+---
+
+### `startJob(conn *connection, requestID uint32, header protocol.Header, data []byte, img image.Image, format string, options requestOptions, stats *protocol.TransferStats, workerChannels workerChannels) string`
+Registers a new job, answers the request immediately with the ID of the job and the statistics of the upload, and queues the image to be
+processed in the background. The result is persisted by the registry, so the client can fetch it from another connection.
+The request and its raw image are given to the registry, which spools them when `Config.SpoolJobs` is enabled.
+A client over its daily quota of jobs gets a `protocol.CodeQuotaExceeded` error instead (see `queue.go`). Returns
+the status of the request for the access log.
+
+### `enqueueJob(conn net.Conn, job jobs.Job, img image.Image, format string, options requestOptions, workerChannels workerChannels)`
+Queues a job with the priority and quotas of its client, `runJob` is called once it gets a slot. The job is
+waited for by `Shutdown` from the moment it is queued.
+
+### `runJob(conn net.Conn, job jobs.Job, img image.Image, format string, options requestOptions, workerChannels workerChannels)`
+Runs the processing pipeline for a job, with the ID of the job as the `{request}` of its stamp, and records its result
+or its error, then calls the webhook of the job (see `webhooks.go`). A job interrupted by the shutdown of the server
+is left as it is: it is resumed by the next start if it was spooled, and marked as failed otherwise.
+
+### `resumeJobs(workerChannels workerChannels)`
+Processes again the spooled jobs which were interrupted by the last restart. `conn` is nil for those jobs. A job
+whose input can no longer be processed fails, and its webhook is called.
+
+### `remoteAddr(conn net.Conn) string`
+Describes the client of a request for the logs, "none" for the resumed jobs.
+
+### `handleJobQuery(conn *connection, requestID uint32, jobID string, offset int64)`
+Answers a request querying a job:
+- Unknown job: error frame with the `not_found` code.
+- Pending, running or failed job: metadata frame describing the state of the job.
+- Finished job: metadata frame, with the size of the whole result, followed by the result image from byte `offset`
+  (`protocol.Header.Offset`) so an interrupted download is resumed, and the trailer with the timings of the job and
+  of the sending of its result. An offset beyond the end of the result gets an error frame with the `bad_request`
+  code.
+*/
+
+import (
+	"ELP-project/internal/jobs"
+	"ELP-project/internal/protocol"
+	"errors"
+	"fmt"
+	"image"
+	"net"
+	"time"
+)
+
+func (server *Server) startJob(conn *connection, requestID uint32, header protocol.Header, data []byte, img image.Image, format string, options requestOptions, stats *protocol.TransferStats, workerChannels workerChannels) string {
+	if err := server.queue.admit(conn.client, server.keys.limits(conn.client)); err != nil {
+		return server.sendError(conn, requestID, protocol.CodeQuotaExceeded, err)
+	}
+
+	job, err := server.jobs.Create(conn.client, header, data)
+	if err != nil {
+		return server.sendError(conn, requestID, protocol.CodeInternal, err)
+	}
+	server.logger.Printf("Job %s created for %s", job.ID, conn.RemoteAddr())
+
+	server.sendResponse(conn, requestID, &protocol.Metadata{JobID: job.ID, Status: job.Status, Stats: stats}, nil, nil)
+
+	server.enqueueJob(conn, job, img, format, options, workerChannels)
+	return "async"
+}
+
+func (server *Server) enqueueJob(conn net.Conn, job jobs.Job, img image.Image, format string, options requestOptions, workerChannels workerChannels) {
+	server.active.Add(1)
+	server.queue.push(job.Client, server.keys.limits(job.Client), func() {
+		defer server.active.Done()
+		server.runJob(conn, job, img, format, options, workerChannels)
+	})
+}
+
+func (server *Server) runJob(conn net.Conn, job jobs.Job, img image.Image, format string, options requestOptions, workerChannels workerChannels) {
+	server.stats.runningJobs.Add(1)
+	defer server.stats.runningJobs.Add(-1)
+	defer server.recoverJob(job)
+
+	server.jobs.Start(job.ID)
+	options.requestID = job.ID
+
+	finalImage, err := server.process(conn, img, options, workerChannels)
+	if errors.Is(err, errShuttingDown) {
+		server.logger.Printf("Job %s interrupted by shutdown", job.ID)
+		return
+	}
+	if panicked, ok := workerPanic(err); ok {
+		err = server.savePanic("job "+job.ID, DebugReport{Client: job.Client, JobID: job.ID, Header: job.Request}, nil, nil, panicked.Value, panicked.Stack)
+	}
+	if err != nil {
+		server.logger.Printf("Job %s failed: %v", job.ID, err)
+		server.jobs.Fail(job.ID, err)
+		server.notifyJob(job.ID)
+		return
+	}
+
+	encodingStart := time.Now()
+	result, err := encodeResult(finalImage, format, options)
+	if err != nil {
+		server.logger.Printf("Job %s failed: %v", job.ID, err)
+		server.jobs.Fail(job.ID, err)
+		server.notifyJob(job.ID)
+		return
+	}
+	options.timings.since(protocol.TimingEncode, encodingStart)
+
+	if err := server.jobs.Complete(job.ID, spreadFormat(format, options.split()), result, options.timings.report(), options.colors, options.recognizedText(), options.rotationDegrees()); err != nil {
+		server.logger.Printf("Error saving result of job %s: %v", job.ID, err)
+		server.notifyJob(job.ID)
+		return
+	}
+	server.logger.Printf("Job %s done", job.ID)
+	server.notifyJob(job.ID)
+}
+
+func (server *Server) resumeJobs(workerChannels workerChannels) {
+	for _, job := range server.jobs.Recover() {
+		data, err := server.jobs.Input(job.ID)
+		if err != nil {
+			server.logger.Printf("Error reading input of job %s: %v", job.ID, err)
+			server.jobs.Fail(job.ID, err)
+			server.notifyJob(job.ID)
+			continue
+		}
+
+		img, format, err := server.decodeImage(data)
+		if err != nil {
+			server.jobs.Fail(job.ID, err)
+			server.notifyJob(job.ID)
+			continue
+		}
+
+		options, err := server.parseOptions(job.Request)
+		if err != nil {
+			server.jobs.Fail(job.ID, err)
+			server.notifyJob(job.ID)
+			continue
+		}
+		options.lossless.keep(data, format)
+		format = options.outputFormat(format)
+
+		options.timings = newStageTimings()
+		server.logger.Printf("Resuming job %s", job.ID)
+		server.enqueueJob(nil, job, img, format, options, workerChannels)
+	}
+}
+
+func remoteAddr(conn net.Conn) string {
+	if conn == nil {
+		return "none"
+	}
+	return conn.RemoteAddr().String()
+}
+
+func (server *Server) handleJobQuery(conn *connection, requestID uint32, jobID string, offset int64) {
+	job, ok := server.jobs.Get(jobID)
+	if !ok {
+		server.sendError(conn, requestID, protocol.CodeNotFound, errors.New("unknown job: "+jobID))
+		return
+	}
+
+	metadata := &protocol.Metadata{
+		JobID:    job.ID,
+		Status:   job.Status,
+		Error:    job.Error,
+		Format:   job.Format,
+		Colors:   job.Colors,
+		Text:     job.Text,
+		Rotation: job.Rotation,
+	}
+
+	if job.Status != protocol.StatusDone {
+		server.sendResponse(conn, requestID, metadata, nil, nil)
+		return
+	}
+
+	result, err := server.jobs.Result(job.ID)
+	if err != nil {
+		server.sendError(conn, requestID, protocol.CodeInternal, err)
+		return
+	}
+	if offset < 0 || offset > int64(len(result)) {
+		server.sendError(conn, requestID, protocol.CodeBadRequest, fmt.Errorf("offset %d out of the %d bytes of the result", offset, len(result)))
+		return
+	}
+	metadata.Size = int64(len(result))
+
+	if offset > 0 {
+		server.logger.Printf("Resuming result of job %s at byte %d of %d for %s", job.ID, offset, len(result), conn.RemoteAddr())
+	} else {
+		server.logger.Printf("Sending result of job %s to %s", job.ID, conn.RemoteAddr())
+	}
+	server.sendResponse(conn, requestID, metadata, result[offset:], restoreTimings(job.Timings))
+}
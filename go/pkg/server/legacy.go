@@ -0,0 +1,136 @@
+package server
+
+/*
+This file implements the legacy protocol, spoken by the clients released before the framed protocol (see
+`internal/protocol`). Such clients do not send the protocol magic: they send the raw image followed by the
+`protocol.LegacyEndMarker` bytes, and read the raw processed image until the server closes the connection.
+
+The legacy protocol is accepted as long as `Config.LegacyProtocol` is enabled, so both kinds of clients can be
+served by the same server while the framed protocol is rolled out.
+
+---
+
+### `handleLegacyConnection(conn net.Conn, workerChannels workerChannels)`
+Serves the single request of a legacy client.
+
+- **Behavior**:
+  1. Receives the image with `receiveLegacyImage`.
+  2. Processes it with the default options (no page size, default resolution).
+  3. Sends back the raw processed image in the format of the input.
+  The request is recorded in the access log like the requests of the framed protocol.
+  Legacy clients cannot receive errors: on failure, the connection is closed without response.
+
+### `receiveLegacyImage(conn net.Conn) ([]byte, error)`
+Reads the image of a legacy client until the end marker, or until the client closes the connection.
+Images larger than `MaxPayloadSize` are rejected.
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"ELP-project/internal/netUtils"
+	"ELP-project/internal/protocol"
+	"ELP-project/internal/utils"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+func (server *Server) handleLegacyConnection(conn net.Conn, workerChannels workerChannels) {
+	server.logger.Printf("Legacy client detected: %s", conn.RemoteAddr())
+
+	server.stats.requests.Add(1)
+	server.stats.inFlight.Add(1)
+	defer server.stats.inFlight.Add(-1)
+
+	entry := accessEntry{
+		remoteAddr: conn.RemoteAddr().String(),
+		status:     "ok",
+		transfer:   requestTransfer{started: time.Now()},
+	}
+	defer server.logAccess(&entry)
+
+	data, err := server.receiveLegacyImage(conn)
+	if err != nil {
+		server.logger.Printf("Failed to receive image from %s: %v", conn.RemoteAddr(), err)
+		entry.status = protocol.CodeBadRequest
+		return
+	}
+	server.logger.Println("Image received successfully!")
+	entry.transfer.bytesReceived = int64(len(data))
+	entry.transfer.received = time.Now()
+
+	img, format, err := server.decodeImage(data)
+	if err != nil {
+		server.logger.Printf("Invalid image from %s: %v", conn.RemoteAddr(), err)
+		server.stats.failures.Add(1)
+		entry.status = protocol.CodeBadRequest
+		return
+	}
+
+	processingStart := time.Now()
+	finalImage, err := server.process(conn, img, requestOptions{dpi: geometry.DefaultDPI, canny: utils.DefaultCannyParameters}, workerChannels)
+	entry.processing = time.Since(processingStart)
+	if err != nil {
+		server.logger.Printf("Error processing image for %s: %v", conn.RemoteAddr(), err)
+		if panicked, ok := workerPanic(err); ok {
+			server.savePanic("legacy client "+conn.RemoteAddr().String(), DebugReport{RemoteAddr: conn.RemoteAddr().String()}, data, nil, panicked.Value, panicked.Stack)
+		}
+		server.stats.failures.Add(1)
+		entry.status = errorCode(err)
+		return
+	}
+
+	result, err := encodeImage(finalImage, format)
+	if err != nil {
+		server.logger.Printf("Error encoding image for %s: %v", conn.RemoteAddr(), err)
+		server.stats.failures.Add(1)
+		entry.status = protocol.CodeInternal
+		return
+	}
+
+	server.logger.Printf("Sending processed image back to %s", conn.RemoteAddr())
+	entry.bytesSent = len(result)
+
+	sendingStart := time.Now()
+	defer func() { entry.sending = time.Since(sendingStart) }()
+	if _, err := conn.Write(result); err != nil {
+		server.logger.Printf("Error sending data: %v", err)
+		return
+	}
+	server.logger.Printf("Image sent successfully. Total bytes: %d", len(result))
+	server.logger.Println("Connection finished:", conn.RemoteAddr())
+}
+
+func (server *Server) receiveLegacyImage(conn net.Conn) ([]byte, error) {
+	var dataBuffer bytes.Buffer
+	marker := []byte(protocol.LegacyEndMarker)
+
+	pooled := netUtils.AcquireBuffer()
+	defer netUtils.ReleaseBuffer(pooled)
+	buffer := *pooled
+
+	for {
+		n, err := conn.Read(buffer)
+		dataBuffer.Write(buffer[:n])
+
+		if bytes.HasSuffix(dataBuffer.Bytes(), marker) {
+			server.logger.Println("End of data detected.")
+			break
+		}
+		if dataBuffer.Len() > server.config.MaxPayloadSize+len(marker) {
+			return nil, fmt.Errorf("image exceeds the limit of %d bytes", server.config.MaxPayloadSize)
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("reading image data: %w", err)
+		}
+	}
+
+	return bytes.TrimSuffix(dataBuffer.Bytes(), marker), nil
+}
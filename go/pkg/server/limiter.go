@@ -0,0 +1,187 @@
+package server
+
+/*
+This file implements the per-client quotas of the server, so that a single client cannot monopolize the
+connection slots (`socketSemaphore`).
+
+---
+
+### `connectionLimiter`
+Tracks the connections of every client host.
+
+- Fields:
+  - `maxPerHost`: Highest number of simultaneous connections of a host (unlimited if 0).
+  - `rate`, `burst`: Token bucket limiting the rate of new connections of a host, `rate` connections per second
+    with bursts of `burst` connections (unlimited if `rate` is 0).
+  - `clients`: State of every known host, protected by `mutex`.
+
+- Methods:
+  - `admit(host string) error`: Registers a new connection of `host`, or returns a `protocol.CodeBusy` error if
+    the host is over its quota.
+  - `release(host string)`: Unregisters a connection admitted by `admit`.
+
+Hosts without connection and whose bucket is full again are forgotten once more than `maxTrackedHosts` hosts
+are known.
+
+---
+
+### `remoteHost(conn net.Conn) string`
+Returns the host part of the remote address of a connection, used to identify a client across its connections.
+The clients of the Unix socket have no address: they all share the `local` host, and so a single quota, whatever
+the local user or program they are.
+
+### `reject(conn *bufferedConn, codec protocol.Codec, err error)`
+Sends a connection-level error frame (`protocol.ConnectionRequestID`) to a client before closing the connection.
+The writing side is closed first and the data already sent by the client is drained for `rejectTimeout`, so the
+client can read the error instead of getting a connection reset. Legacy clients (`codec` nil) cannot receive
+errors, their connection is only closed.
+*/
+
+import (
+	"ELP-project/internal/netUtils"
+	"ELP-project/internal/protocol"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	maxTrackedHosts = 1024
+	rejectTimeout   = time.Second
+)
+
+type connectionLimiter struct {
+	maxPerHost int
+	rate       float64
+	burst      int
+
+	mutex   sync.Mutex
+	clients map[string]*clientQuota
+}
+
+type clientQuota struct {
+	connections int
+	tokens      float64
+	updated     time.Time
+}
+
+func newConnectionLimiter(maxPerHost int, rate float64, burst int) *connectionLimiter {
+	return &connectionLimiter{
+		maxPerHost: maxPerHost,
+		rate:       rate,
+		burst:      burst,
+		clients:    make(map[string]*clientQuota),
+	}
+}
+
+func (limiter *connectionLimiter) admit(host string) error {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := time.Now()
+	if len(limiter.clients) > maxTrackedHosts {
+		limiter.forgetIdle(now)
+	}
+
+	quota, ok := limiter.clients[host]
+	if !ok {
+		quota = &clientQuota{tokens: float64(limiter.burst), updated: now}
+		limiter.clients[host] = quota
+	}
+	limiter.refill(quota, now)
+
+	if limiter.maxPerHost > 0 && quota.connections >= limiter.maxPerHost {
+		return protocol.ErrorMessage{
+			Code:    protocol.CodeBusy,
+			Message: fmt.Sprintf("too many connections (limit of %d), retry later", limiter.maxPerHost),
+		}
+	}
+	if limiter.rate > 0 {
+		if quota.tokens < 1 {
+			return protocol.ErrorMessage{
+				Code:    protocol.CodeBusy,
+				Message: fmt.Sprintf("too many new connections (limit of %g per second), retry later", limiter.rate),
+			}
+		}
+		quota.tokens--
+	}
+
+	quota.connections++
+	return nil
+}
+
+func (limiter *connectionLimiter) release(host string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	quota, ok := limiter.clients[host]
+	if !ok {
+		return
+	}
+
+	quota.connections--
+	if quota.connections == 0 && limiter.rate == 0 {
+		delete(limiter.clients, host)
+	}
+}
+
+func (limiter *connectionLimiter) refill(quota *clientQuota, now time.Time) {
+	if limiter.rate == 0 {
+		return
+	}
+
+	quota.tokens += now.Sub(quota.updated).Seconds() * limiter.rate
+	if quota.tokens > float64(limiter.burst) {
+		quota.tokens = float64(limiter.burst)
+	}
+	quota.updated = now
+}
+
+func (limiter *connectionLimiter) forgetIdle(now time.Time) {
+	for host, quota := range limiter.clients {
+		limiter.refill(quota, now)
+		if quota.connections == 0 && quota.tokens >= float64(limiter.burst) {
+			delete(limiter.clients, host)
+		}
+	}
+}
+
+func remoteHost(conn net.Conn) string {
+	if _, ok := conn.RemoteAddr().(*net.UnixAddr); ok {
+		return "local"
+	}
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+func (server *Server) reject(conn *bufferedConn, codec protocol.Codec, err error) {
+	server.logger.Printf("Rejecting connection from %s: %v", conn.RemoteAddr(), err)
+	server.stats.failures.Add(1)
+
+	if codec == nil {
+		return
+	}
+
+	code, message := protocol.CodeBusy, err.Error()
+	var errorMessage protocol.ErrorMessage
+	if errors.As(err, &errorMessage) {
+		code, message = errorMessage.Code, errorMessage.Message
+	}
+
+	if writeErr := protocol.WriteError(conn, codec, protocol.ConnectionRequestID, code, message); writeErr != nil {
+		server.logger.Printf("Error sending error frame: %v", writeErr)
+		return
+	}
+
+	if closer, ok := conn.Conn.(interface{ CloseWrite() error }); ok {
+		closer.CloseWrite()
+	}
+	conn.SetReadDeadline(time.Now().Add(rejectTimeout))
+	netUtils.CopyN(io.Discard, conn, int64(server.config.MaxPayloadSize))
+}
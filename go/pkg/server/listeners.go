@@ -0,0 +1,228 @@
+package server
+
+/*
+This file implements the listening side of the server: the addresses it listens on, and the listener set merging
+the connections accepted on all of them.
+
+---
+
+### Addresses
+An address of `Config.Listen` is `host:port`, listened on with TCP (IPv4 and IPv6), or is prefixed by its network:
+- `tcp4:0.0.0.0:14750`: IPv4 only, on every interface.
+- `tcp6:[::1]:14750`: IPv6 only, on the loopback interface.
+- `unix:/tmp/elp-project.sock`: Unix domain socket. A stale socket file left by a crash is removed first.
+
+Without `Config.Listen`, the server listens on the single address built from `Network` and `Host`/`Port`, or
+`SocketPath` for the `unix` network.
+
+A server started by a handover accepts from the listeners inherited from the previous process instead, and with
+`Config.ReusePort` its TCP listeners are opened with `SO_REUSEPORT` (see `handover.go`).
+
+---
+
+### `listenerSet`
+A `net.Listener` accepting the connections of several listeners, so that `serve` handles every address with a single
+accept loop. Each listener is accepted from by its own goroutine, which hands the connections over through `conns`.
+The connections accepted while the set is closed are still returned by `Accept`, so that none is dropped when the
+listeners are handed over (see `handover.go`).
+
+- Fields:
+  - `listeners`: The listeners of the set.
+  - `conns`: Connections, or errors, accepted by the listeners. Closed once every listener is closed.
+  - `accepting`: The accepting goroutines, one per listener.
+
+- Methods:
+  - `Accept() (net.Conn, error)`: Returns the next connection accepted on any address, `net.ErrClosed` once the
+    set is closed.
+  - `Close() error`: Closes every listener.
+  - `Addr() net.Addr`: Address of the first listener.
+
+---
+
+### `listenAddresses(config Config) []string`
+Returns the addresses the configuration asks to listen on.
+
+### `parseListenAddress(address string) (string, string)`
+Splits an address of `Config.Listen` into its network and the address given to `net.Listen`.
+
+### `listen() (net.Listener, error)`
+Listens on every configured address, or takes the inherited listeners. A single listener is returned as it is, several ones are merged in a
+`listenerSet`. If one of the addresses cannot be listened on, the listeners already open are closed and the error
+is returned.
+
+### `listenUnix(path string) (net.Listener, error)`
+Listens on a Unix domain socket, removing the stale socket file first.
+
+### `addressList`
+`flag.Value` collecting the values of a repeated flag, e.g. the addresses of
+`-listen localhost:14750 -listen [::1]:14750` or the locations of `-source`.
+*/
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const acceptRetryDelay = 100 * time.Millisecond
+
+var listenNetworks = []string{"tcp", "tcp4", "tcp6", "unix"}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+type listenerSet struct {
+	listeners []net.Listener
+	conns     chan acceptResult
+	accepting sync.WaitGroup
+	closeOnce sync.Once
+}
+
+func newListenerSet(listeners []net.Listener) *listenerSet {
+	set := &listenerSet{
+		listeners: listeners,
+		conns:     make(chan acceptResult),
+	}
+	for _, listener := range listeners {
+		set.accepting.Add(1)
+		go set.accept(listener)
+	}
+	go func() {
+		set.accepting.Wait()
+		close(set.conns)
+	}()
+	return set
+}
+
+func (set *listenerSet) accept(listener net.Listener) {
+	defer set.accepting.Done()
+
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		set.conns <- acceptResult{conn: conn, err: err}
+
+		if err != nil {
+			time.Sleep(acceptRetryDelay)
+		}
+	}
+}
+
+func (set *listenerSet) Accept() (net.Conn, error) {
+	result, ok := <-set.conns
+	if !ok {
+		return nil, net.ErrClosed
+	}
+	return result.conn, result.err
+}
+
+func (set *listenerSet) Close() error {
+	var errs []error
+	set.closeOnce.Do(func() {
+		for _, listener := range set.listeners {
+			if err := listener.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+func (set *listenerSet) Addr() net.Addr {
+	return set.listeners[0].Addr()
+}
+
+func listenAddresses(config Config) []string {
+	if len(config.Listen) > 0 {
+		return config.Listen
+	}
+	if config.Network == "unix" {
+		return []string{"unix:" + config.SocketPath}
+	}
+	return []string{config.Network + ":" + net.JoinHostPort(config.Host, config.Port)}
+}
+
+func parseListenAddress(address string) (string, string) {
+	for _, network := range listenNetworks {
+		if rest, ok := strings.CutPrefix(address, network+":"); ok {
+			return network, rest
+		}
+	}
+	return "tcp", address
+}
+
+func (server *Server) listen() (net.Listener, error) {
+	if server.inherited != nil {
+		listeners := server.inherited.listeners
+		for _, listener := range listeners {
+			server.logger.Printf("Server is listening on %s (inherited)...", listener.Addr())
+		}
+		if len(listeners) == 1 {
+			return listeners[0], nil
+		}
+		return newListenerSet(listeners), nil
+	}
+
+	var listenConfig net.ListenConfig
+	if server.config.ReusePort {
+		listenConfig.Control = reusePort
+	}
+
+	var listeners []net.Listener
+	for _, address := range listenAddresses(server.config) {
+		network, address := parseListenAddress(address)
+
+		var listener net.Listener
+		var err error
+		if network == "unix" {
+			listener, err = server.listenUnix(address)
+		} else {
+			listener, err = listenConfig.Listen(context.Background(), network, address)
+		}
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return nil, fmt.Errorf("starting server on %s: %w", address, err)
+		}
+
+		server.logger.Printf("Server is listening on %s (%s)...", listener.Addr(), network)
+		listeners = append(listeners, listener)
+	}
+
+	if len(listeners) == 1 {
+		return listeners[0], nil
+	}
+	return newListenerSet(listeners), nil
+}
+
+func (server *Server) listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		server.logger.Printf("Removing stale socket %s", path)
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing stale socket: %w", err)
+		}
+	}
+
+	return net.Listen("unix", path)
+}
+
+type addressList []string
+
+func (list *addressList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *addressList) Set(address string) error {
+	*list = append(*list, address)
+	return nil
+}
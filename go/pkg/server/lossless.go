@@ -0,0 +1,102 @@
+package server
+
+/*
+This file implements the lossless crop of the documents of JPEG images (`protocol.Header.Lossless`): `process` finds
+the document on the decoded image as usual, then cuts its bounding box out of the received JPEG (see
+`imageUtils.CropJPEG`), which is sent back as it is instead of the cropped pixels encoded again. The decoded crop is
+still computed, extended to the grid of the blocks of the JPEG like the lossless one, for the color statistics and the
+text recognition of the document.
+
+---
+
+### losslessCrop
+Lossless crop of the document of a request.
+
+- **Fields**:
+  - `source`: The received image, kept by `keep` if it is a JPEG, nil otherwise.
+  - `data`: The JPEG of the part of `source` the document is cropped to, set by `crop`. Nil until the document is
+    cropped, or if it cannot be cropped losslessly.
+
+- **Methods**:
+  - `keep(data []byte, format string)`: Keeps the received image `data`, of the `format` it was decoded from, if it
+    is a JPEG.
+  - `crop(rect image.Rectangle) (image.Rectangle, error)`: Crops `source` losslessly to the bounding box `rect` of the
+    document, and returns the part of the image cropped, `rect` extended up and left to the grid of the blocks of the
+    JPEG. Fails with `imageUtils.ErrLosslessCrop` if the image is not a JPEG, or not one that can be cropped
+    losslessly.
+  - `done() bool`: Whether the document was cropped losslessly. False on a nil crop, that of the requests not asking
+    for it.
+
+---
+
+### `checkLossless(header protocol.Header, options requestOptions) error`
+Checks that a request asking for a lossless crop only crops its document along its bounding box, and returns it in
+JPEG: the operations other than `protocol.OperationCrop`, the other formats, a page size, the presets warping or
+turning the document, and the options changing its pixels are refused.
+*/
+
+import (
+	"ELP-project/internal/imageUtils"
+	"ELP-project/internal/protocol"
+	"cmp"
+	"errors"
+	"fmt"
+	"image"
+)
+
+type losslessCrop struct {
+	source []byte
+	data   []byte
+}
+
+func (lossless *losslessCrop) keep(data []byte, format string) {
+	if lossless != nil && format == "jpeg" {
+		lossless.source = data
+	}
+}
+
+func (lossless *losslessCrop) crop(rect image.Rectangle) (image.Rectangle, error) {
+	if lossless.source == nil {
+		return rect, fmt.Errorf("%w: not a JPEG image", imageUtils.ErrLosslessCrop)
+	}
+	bounds, err := imageUtils.JPEGCropBounds(lossless.source, rect)
+	if err != nil {
+		return rect, err
+	}
+	data, err := imageUtils.CropJPEG(lossless.source, bounds)
+	if err != nil {
+		return rect, err
+	}
+	lossless.data = data
+	return bounds, nil
+}
+
+func (lossless *losslessCrop) done() bool {
+	return lossless != nil && lossless.data != nil
+}
+
+func checkLossless(header protocol.Header, options requestOptions) error {
+	switch {
+	case header.Operation != "" && header.Operation != protocol.OperationCrop:
+		return fmt.Errorf("only the cropped document is cropped losslessly, not the result of the %s operation", header.Operation)
+	case options.format != "" && options.format != "jpeg":
+		return fmt.Errorf("a lossless crop is a JPEG, not %s", options.format)
+	case options.page != nil:
+		return errors.New("a lossless crop cannot be scaled to a page size")
+	case options.warp || options.rotated || options.size != nil || options.enhance != nil:
+		return fmt.Errorf("the %s preset changes the pixels of the document, it cannot be cropped losslessly", cmp.Or(header.Preset, protocol.PresetDocument))
+	case options.anonymize:
+		return errors.New("a lossless crop cannot be anonymized")
+	case options.stamp != nil:
+		return errors.New("a lossless crop cannot be stamped")
+	case options.deskew || options.rotation != nil:
+		return errors.New("a lossless crop cannot be straightened nor turned")
+	case options.fillHoles:
+		return errors.New("the holes of a lossless crop cannot be filled")
+	case options.tone != nil:
+		return errors.New("the tone of a lossless crop cannot be adjusted")
+	case options.whiteBalance != "" && options.whiteBalance != protocol.WhiteBalanceOff:
+		return errors.New("a lossless crop keeps the colors of the document, it cannot be white balanced")
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package server
+
+/*
+This file implements the description of the processing pipeline: the stages a preset runs an image through, with
+their parameters and the worker pools they run on, exported as JSON or as a Graphviz graph, so users can check
+exactly what transformations a preset applies before sending it their documents. It is served by the admin
+interface (`GET /pipeline`) and printed by `cmd/app -print-pipeline`.
+
+The description is that of a crop request giving only the preset: the options of a request (a page size, an
+orientation, a stamp...) add their own stages on top of it.
+
+---
+
+### `Pipeline`
+Description of the pipeline of a preset.
+
+- Fields:
+  - `Preset`: Name of the preset.
+  - `Workers`: Number of workers of every pool.
+  - `Chunks`: Number of chunks an image is split into for the stages run on the pools: one per worker the server
+    was started with, whatever the later size of its pools, or `deterministicChunks` in deterministic mode.
+  - `Stages`: The stages, in the order they run.
+
+- Methods:
+  - `DOT() string`: Returns the pipeline as a Graphviz `digraph`: a node per stage, labeled with its parameters
+    sorted by name, chained in the order of the stages. The stages run on a pool are grouped in a cluster of that
+    pool.
+
+### `PipelineStage`
+Stage of a `Pipeline`.
+
+- Fields:
+  - `Name`: Name of the stage.
+  - `Pool`: Worker pool the stage runs on, empty if it runs on the goroutine of the request.
+  - `Tasks`: Number of tasks the stage is split into on its pool, one per chunk, 0 without a pool.
+  - `Parameters`: Parameters of the stage, by name.
+
+---
+
+### `DescribePipeline(config Config, presetName string) (Pipeline, error)`
+Returns the pipeline a server started with `config` runs for the preset `presetName` (the `document` preset if
+empty), and fails on an unknown preset.
+
+### `describePipeline(config Config, workers int, presetName string) (Pipeline, error)`
+Returns the pipeline of `presetName` with pools of `workers` workers, e.g. the live size of the pools of a running
+server (see `POST /workers`).
+
+### `configuredWorkers(config Config) int`
+Returns the number of workers of every pool of a server started with `config`: `Config.Workers`, or the number of
+CPUs if it is not set.
+
+### `functionName(function any) string`
+Returns the name of `function`, qualified by its package, e.g. `imageUtils.CleanWhiteboard`.
+
+---
+
+### Constants
+- `imagePool`, `contourPool`, `rankingPool`: Names of the worker pools, as logged when they start.
+
+---
+
+### Example Usage:
+```go
+pipeline, err := server.DescribePipeline(server.DefaultConfig(), protocol.PresetWhiteboard)
+if err != nil {
+	log.Fatal(err)
+}
+fmt.Print(pipeline.DOT())
+```
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"ELP-project/internal/protocol"
+	"ELP-project/internal/utils"
+	"fmt"
+	"maps"
+	"path"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+const (
+	imagePool   = "Image Worker"
+	contourPool = "BFS worker"
+	rankingPool = "FindQuadrilateral worker"
+)
+
+type Pipeline struct {
+	Preset  string          `json:"preset"`
+	Workers int             `json:"workers"`
+	Chunks  int             `json:"chunks"`
+	Stages  []PipelineStage `json:"stages"`
+}
+
+type PipelineStage struct {
+	Name       string         `json:"name"`
+	Pool       string         `json:"pool,omitempty"`
+	Tasks      int            `json:"tasks,omitempty"`
+	Parameters map[string]any `json:"parameters,omitempty"`
+}
+
+func DescribePipeline(config Config, presetName string) (Pipeline, error) {
+	return describePipeline(config, configuredWorkers(config), presetName)
+}
+
+func describePipeline(config Config, workers int, presetName string) (Pipeline, error) {
+	if presetName == "" {
+		presetName = protocol.PresetDocument
+	}
+	preset, err := lookupPreset(presetName)
+	if err != nil {
+		return Pipeline{}, err
+	}
+
+	chunks := configuredWorkers(config)
+	if config.Deterministic {
+		chunks = deterministicChunks
+	}
+	pipeline := Pipeline{Preset: presetName, Workers: workers, Chunks: chunks}
+	add := func(name, pool string, tasks int, parameters map[string]any) {
+		pipeline.Stages = append(pipeline.Stages, PipelineStage{Name: name, Pool: pool, Tasks: tasks, Parameters: parameters})
+	}
+
+	add("decode", "", 0, map[string]any{"maxPixels": config.MaxPixels, "maxDimension": config.MaxDimension})
+
+	canny := preset.canny
+	if canny.BilateralSigma > 0 {
+		add("grayscale", imagePool, chunks, nil)
+		add("bilateral filter", imagePool, chunks, map[string]any{
+			"radius":     canny.BlurKernelSize / 2,
+			"sigmaSpace": canny.BlurSigma,
+			"sigmaRange": canny.BilateralSigma,
+		})
+	} else {
+		add("grayscale + gaussian blur", imagePool, chunks, map[string]any{
+			"kernelSize": canny.BlurKernelSize,
+			"sigma":      canny.BlurSigma,
+		})
+	}
+
+	thresholds, levels := "per chunk", "binary"
+	if config.GlobalThresholds {
+		thresholds = "global"
+	}
+	if canny.ThreeLevel {
+		levels = "three-level"
+	}
+	add("canny", imagePool, chunks, map[string]any{
+		"thresholdScale": canny.ThresholdScale,
+		"thresholds":     thresholds,
+		"edgeMap":        levels,
+	})
+
+	if preset.closing > 0 {
+		add("closing", "", 0, map[string]any{"radius": preset.closing})
+	}
+
+	contourPass, contourChunks := config.ContourPass, chunks
+	if contourPass == "" {
+		contourPass = ContourPassChunked
+	}
+	if contourPass == ContourPassGlobal {
+		contourChunks = 1
+	}
+	add("contours", contourPool, contourChunks, map[string]any{
+		"pass":      contourPass,
+		"threshold": "otsu",
+		"merged":    contourChunks > 1,
+	})
+
+	ranking := "FindQuadrilateral"
+	if preset.rotated || preset.warp {
+		ranking = "FindLargestHull"
+	}
+	add("ranking", rankingPool, chunks, map[string]any{"candidates": ranking})
+
+	dpi := preset.dpi
+	if dpi == 0 {
+		dpi = geometry.DefaultDPI
+	}
+	crop := map[string]any{"dpi": dpi}
+	switch {
+	case preset.rotated:
+		crop["mode"] = "rotated rectangle"
+		if preset.paperWidth > 0 {
+			crop["paperWidth"] = preset.paperWidth
+		}
+	case preset.size != nil:
+		crop["mode"] = "warp to size"
+		crop["size"] = preset.size.Name
+		crop["aspectTolerance"] = preset.aspectTolerance
+	case preset.warp:
+		crop["mode"] = "perspective warp"
+	default:
+		crop["mode"] = "bounding box"
+	}
+	add("crop", "", 0, crop)
+
+	if preset.enhance != nil {
+		add("enhance", "", 0, map[string]any{"function": functionName(preset.enhance)})
+	}
+	if config.Anonymize {
+		add("anonymize", "", 0, nil)
+	}
+	if preset.spread {
+		add("split", "", 0, map[string]any{"function": functionName(utils.FindGutter)})
+	}
+
+	format := preset.format
+	if format == "" {
+		format = "source"
+	}
+	add("encode", "", 0, map[string]any{"format": format})
+
+	return pipeline, nil
+}
+
+func (pipeline Pipeline) DOT() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "digraph %q {\n", "pipeline "+pipeline.Preset)
+	builder.WriteString("\trankdir=LR;\n\tnode [shape=box];\n")
+
+	node := func(i int, stage PipelineStage) string {
+		label := stage.Name
+		if stage.Tasks > 0 {
+			label += fmt.Sprintf(" (%d tasks)", stage.Tasks)
+		}
+		for _, name := range slices.Sorted(maps.Keys(stage.Parameters)) {
+			label += fmt.Sprintf("\n%s = %v", name, stage.Parameters[name])
+		}
+		return fmt.Sprintf("stage%d [label=%q];", i, label)
+	}
+
+	for i := 0; i < len(pipeline.Stages); {
+		pool := pipeline.Stages[i].Pool
+		if pool == "" {
+			fmt.Fprintf(&builder, "\t%s\n", node(i, pipeline.Stages[i]))
+			i++
+			continue
+		}
+		fmt.Fprintf(&builder, "\tsubgraph \"cluster%d\" {\n\t\tlabel=%q;\n", i, fmt.Sprintf("%s (%d workers)", pool, pipeline.Workers))
+		for ; i < len(pipeline.Stages) && pipeline.Stages[i].Pool == pool; i++ {
+			fmt.Fprintf(&builder, "\t\t%s\n", node(i, pipeline.Stages[i]))
+		}
+		builder.WriteString("\t}\n")
+	}
+
+	for i := 1; i < len(pipeline.Stages); i++ {
+		fmt.Fprintf(&builder, "\tstage%d -> stage%d;\n", i-1, i)
+	}
+	builder.WriteString("}\n")
+	return builder.String()
+}
+
+func configuredWorkers(config Config) int {
+	if config.Workers > 0 {
+		return config.Workers
+	}
+	return runtime.NumCPU()
+}
+
+func functionName(function any) string {
+	name := runtime.FuncForPC(reflect.ValueOf(function).Pointer()).Name()
+	return path.Base(name)
+}
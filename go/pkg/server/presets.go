@@ -0,0 +1,148 @@
+package server
+
+/*
+This file implements the presets of the requests (`protocol.Header.Preset`): named tunings of the processing for a
+kind of document, since a whiteboard or a thermal receipt does not photograph like a printed page.
+
+---
+
+### `preset`
+Tuning of the processing bundled by a preset.
+
+- Fields:
+  - `canny`: Parameters of the edge detection (see `utils.CannyParameters`).
+  - `closing`: Radius of the closing of the edge map before the search of the contours (see `utils.Close`), which
+    bridges the gaps of the border of the document so it is found as a single contour. No closing if 0.
+  - `format`: Format of the result when the request does not give one, the format of the received image if empty.
+  - `enhance`: Enhancement of the cropped document, before it is scaled to the page, nil if none.
+  - `warp`: Whether the document is straightened by a perspective warp of its four corners (see
+    `utils.WarpQuadrilateral`) instead of the axis-aligned crop of its bounding box, for the documents photographed
+    at an angle. The document is then the contour with the largest convex hull, like with `rotated`: the outline of
+    a frame holds the content inside it, whose contours would otherwise be picked.
+  - `rotated`: Whether the document is detected and cropped as a narrow strip of paper rather than as a page: the
+    contour with the largest convex hull wins (see `utils.FindLargestHull`), however long and thin, and the document is
+    cropped along the rotated rectangle bounding it (see `utils.MinAreaRect`), so a tilted strip comes out upright.
+    The corners of `protocol.OperationCorners` are then those of that rectangle.
+  - `size`: Physical size of the document, nil if any. The document is warped by its four corners to that size at the
+    resolution of the request (see `utils.WarpToRectangle`), in landscape if it is wider than high, whatever the size
+    of the photo. A document whose proportions differ from those of the size by more than `aspectTolerance` is not
+    the one sought: the request fails with `protocol.CodeNotFound`.
+  - `aspectTolerance`: With `size`, highest relative difference between the ratio of the sides of the document and
+    that of the size, which leaves room for the perspective of a photo taken slightly at an angle.
+  - `paperWidth`: Width in millimeters the cropped document is scaled to, at the resolution of the request, when
+    the request gives no page size. No scaling if 0.
+  - `dpi`: Resolution of the result when the request gives none, `geometry.DefaultDPI` if 0.
+  - `spread`: Whether a document showing two pages side by side, an open book, is split into its pages at the
+    shadow of its binding (see `utils.FindGutter`). The split is made on the final result, so the pages come out in
+    the order they are read, left first, whatever the document was turned by.
+
+### `presets`
+The presets by name:
+- `document`: The tuning of printed pages (`utils.DefaultCannyParameters`), the default. The edges are closed with
+  a radius of 1, like those of the receipts and the photos, which bridges the one-pixel breaks Canny leaves in the
+  border of a page where its contrast with the background fades.
+- `whiteboard`: A stronger smoothing, against the reflections of the lamps on the board and the ghosts of erased
+  strokes, and a lower threshold to keep the frame of a white board hung on a light wall. The smoothing is bilateral
+  (see `utils.ApplyBilateralFilter`), so that low-contrast frame is not blurred into the wall with the noise. The edges are closed with a radius
+  of 2, since a reflection can break the frame over several pixels. A board is rarely photographed straight on: its
+  four corners are warped to an upright rectangle (`warp`), then the board is cleaned up
+  (see `imageUtils.CleanWhiteboard`): its background flattened to white and its strokes saturated. The result is a
+  PNG, which keeps the strokes sharp on a flat background.
+- `receipt`: A lighter blur, so the borders of a narrow strip of paper are not smeared into the background, and a
+  higher threshold to ignore the creases of a crumpled receipt and its faint print. The receipt is detected and
+  cropped as a rotated strip (`rotated`), and scaled to the 80 mm of the common thermal paper at 400 dpi, where the
+  small print of a receipt, about 1.5 mm high, is tall enough for OCR engines. The result is a PNG: JPEG artifacts
+  blur the faded print of thermal paper.
+- `photo`: A stronger blur and a higher threshold to ignore the content of the photo, whose edges compete with the
+  border of the print. The result is a JPEG, which suits continuous tones.
+- `id-card`: The tuning of the photos, whose edges compete with the border of the card, with the closing of the
+  whiteboards, since the rounded corners of a card break its outline. The card is checked against the proportions of
+  the ISO/IEC 7810 ID-1 format (85.60 x 53.98 mm) within 12%, then warped to that size (`size`) at 300 dpi, i.e.
+  1011 x 638 pixels, so every card of a file comes out at the same size. The result is a JPEG, for the photo of
+  the holder.
+- `book`: The tuning of printed pages, for an open book photographed from above: its outline is warped to an upright
+  rectangle (`warp`), since the pages of a book held open are rarely square to the camera, and a double-page spread
+  is split into its two pages (`spread`). The result is a JPEG, like the photos of the pages it comes from.
+
+---
+
+### `lookupPreset(name string) (preset, error)`
+Returns the preset of a request, the `document` preset if `name` is empty, and fails on an unknown name.
+*/
+
+import (
+	"ELP-project/internal/geometry"
+	"ELP-project/internal/imageUtils"
+	"ELP-project/internal/protocol"
+	"ELP-project/internal/utils"
+	"fmt"
+	"image"
+)
+
+type preset struct {
+	canny           utils.CannyParameters
+	closing         int
+	format          string
+	enhance         func(img image.Image) *image.RGBA
+	warp            bool
+	rotated         bool
+	size            *geometry.PageSize
+	aspectTolerance float64
+	paperWidth      float64
+	dpi             int
+	spread          bool
+}
+
+var presets = map[string]preset{
+	protocol.PresetDocument: {
+		canny:   utils.DefaultCannyParameters,
+		closing: 1,
+	},
+	protocol.PresetWhiteboard: {
+		canny:   utils.CannyParameters{BlurKernelSize: 7, BlurSigma: 2.2, BilateralSigma: 20, ThresholdScale: 0.8},
+		closing: 2,
+		format:  "png",
+		enhance: imageUtils.CleanWhiteboard,
+		warp:    true,
+	},
+	protocol.PresetReceipt: {
+		canny:      utils.CannyParameters{BlurKernelSize: 3, BlurSigma: 0.8, ThresholdScale: 1.3},
+		closing:    1,
+		format:     "png",
+		rotated:    true,
+		paperWidth: 80,
+		dpi:        400,
+	},
+	protocol.PresetPhoto: {
+		canny:   utils.CannyParameters{BlurKernelSize: 7, BlurSigma: 1.8, ThresholdScale: 1.2},
+		closing: 1,
+		format:  "jpeg",
+	},
+	protocol.PresetIDCard: {
+		canny:           utils.CannyParameters{BlurKernelSize: 7, BlurSigma: 1.8, ThresholdScale: 1.2},
+		closing:         2,
+		format:          "jpeg",
+		warp:            true,
+		size:            &geometry.ID1,
+		aspectTolerance: 0.12,
+		dpi:             300,
+	},
+	protocol.PresetBook: {
+		canny:   utils.DefaultCannyParameters,
+		closing: 1,
+		format:  "jpeg",
+		warp:    true,
+		spread:  true,
+	},
+}
+
+func lookupPreset(name string) (preset, error) {
+	if name == "" {
+		name = protocol.PresetDocument
+	}
+	found, ok := presets[name]
+	if !ok {
+		return preset{}, fmt.Errorf("unknown preset: %q", name)
+	}
+	return found, nil
+}
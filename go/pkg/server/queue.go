@@ -0,0 +1,220 @@
+package server
+
+/*
+This file implements the queue of the asynchronous jobs, which lets a shared server serve several teams fairly:
+every API key has a priority class and quotas, set in the keys file (see `auth.go`).
+
+---
+
+### Priorities and quotas
+- At most `Config.MaxRunningJobs` jobs run at the same time, the other ones wait in the queue as pending jobs.
+- A free slot goes to the highest priority class with a waiting job (`high`, then `normal`, then `low`). Within a
+  class, the clients take turns, so a client submitting many jobs does not delay the jobs of the other clients of
+  its class. The jobs of a client start in the order they were submitted.
+- `concurrent-jobs`: Highest number of jobs of a key running at the same time, its other jobs wait even if slots
+  are free (unlimited if 0).
+- `jobs-per-day`: Highest number of jobs a key can submit per day (UTC), further submissions fail with a
+  `protocol.CodeQuotaExceeded` error (unlimited if 0). The counters are kept in memory, a restart resets them.
+
+Without authentication, every job has the `normal` priority and no quota.
+
+---
+
+### `jobPriority`
+Priority class of a key: `priorityLow`, `priorityNormal` or `priorityHigh`. Parsed from the keys file by
+`parsePriority(name string) (jobPriority, error)`.
+
+### `keyLimits`
+Priority and quotas of an API key (`priority`, `jobsPerDay`, `concurrentJobs`).
+
+### `queuedJob`
+A job waiting for a slot: the name of its client and the function running it.
+
+### `jobQueue`
+Queue of the jobs of the server.
+
+- Fields:
+  - `maxRunning`: Highest number of jobs running at the same time.
+  - `running`, `runningByClient`: Jobs running, in total and per client.
+  - `waiting`: Waiting jobs of every priority class and client, and `turns` the clients of every class in the order
+    they are served.
+  - `day`, `submitted`: Current day (UTC) and jobs submitted by every client during that day.
+
+- Methods:
+  - `admit(client string, limits keyLimits) error`: Counts a new job of `client` against its daily quota, or
+    returns a `protocol.CodeQuotaExceeded` error.
+  - `push(client string, limits keyLimits, run func())`: Queues a job, started as soon as the priorities and the
+    quotas allow it. `run` is called in its own goroutine.
+  - `length() int`: Number of waiting jobs.
+  - `dispatch()`: Starts waiting jobs while slots are free, must be called with `mutex` held.
+  - `next() (queuedJob, bool)`: Removes the next job to start from the queue, if any may start.
+
+---
+
+### `newJobQueue(maxRunning int) *jobQueue`
+Creates an empty queue.
+*/
+
+import (
+	"ELP-project/internal/protocol"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+type jobPriority int
+
+const (
+	priorityLow jobPriority = iota
+	priorityNormal
+	priorityHigh
+
+	priorityClasses = 3
+)
+
+type keyLimits struct {
+	priority       jobPriority
+	jobsPerDay     int
+	concurrentJobs int
+}
+
+var defaultKeyLimits = keyLimits{priority: priorityNormal}
+
+type queuedJob struct {
+	client string
+	limits keyLimits
+	run    func()
+}
+
+type jobQueue struct {
+	maxRunning int
+
+	mutex           sync.Mutex
+	running         int
+	runningByClient map[string]int
+	waiting         [priorityClasses]map[string][]queuedJob
+	turns           [priorityClasses][]string
+	day             string
+	submitted       map[string]int
+}
+
+func parsePriority(name string) (jobPriority, error) {
+	switch name {
+	case "low":
+		return priorityLow, nil
+	case "normal":
+		return priorityNormal, nil
+	case "high":
+		return priorityHigh, nil
+	default:
+		return 0, fmt.Errorf("unknown priority %q (low, normal or high)", name)
+	}
+}
+
+func newJobQueue(maxRunning int) *jobQueue {
+	queue := &jobQueue{
+		maxRunning:      maxRunning,
+		runningByClient: make(map[string]int),
+		submitted:       make(map[string]int),
+	}
+	for priority := range queue.waiting {
+		queue.waiting[priority] = make(map[string][]queuedJob)
+	}
+	return queue
+}
+
+func (queue *jobQueue) admit(client string, limits keyLimits) error {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	today := time.Now().UTC().Format(time.DateOnly)
+	if queue.day != today {
+		queue.day = today
+		clear(queue.submitted)
+	}
+
+	if limits.jobsPerDay > 0 && queue.submitted[client] >= limits.jobsPerDay {
+		return protocol.ErrorMessage{
+			Code:    protocol.CodeQuotaExceeded,
+			Message: fmt.Sprintf("daily quota of %d jobs reached, retry tomorrow", limits.jobsPerDay),
+		}
+	}
+	queue.submitted[client]++
+	return nil
+}
+
+func (queue *jobQueue) push(client string, limits keyLimits, run func()) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	waiting := queue.waiting[limits.priority]
+	if len(waiting[client]) == 0 {
+		queue.turns[limits.priority] = append(queue.turns[limits.priority], client)
+	}
+	waiting[client] = append(waiting[client], queuedJob{client: client, limits: limits, run: run})
+
+	queue.dispatch()
+}
+
+func (queue *jobQueue) length() int {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	length := 0
+	for _, waiting := range queue.waiting {
+		for _, jobs := range waiting {
+			length += len(jobs)
+		}
+	}
+	return length
+}
+
+func (queue *jobQueue) dispatch() {
+	for queue.running < queue.maxRunning {
+		job, ok := queue.next()
+		if !ok {
+			return
+		}
+
+		queue.running++
+		queue.runningByClient[job.client]++
+		go func() {
+			job.run()
+
+			queue.mutex.Lock()
+			defer queue.mutex.Unlock()
+			queue.running--
+			queue.runningByClient[job.client]--
+			if queue.runningByClient[job.client] == 0 {
+				delete(queue.runningByClient, job.client)
+			}
+			queue.dispatch()
+		}()
+	}
+}
+
+func (queue *jobQueue) next() (queuedJob, bool) {
+	for priority := priorityClasses - 1; priority >= 0; priority-- {
+		waiting := queue.waiting[priority]
+		turns := queue.turns[priority]
+
+		for i, client := range turns {
+			job := waiting[client][0]
+			if job.limits.concurrentJobs > 0 && queue.runningByClient[client] >= job.limits.concurrentJobs {
+				continue
+			}
+
+			waiting[client] = waiting[client][1:]
+			turns = slices.Delete(turns, i, i+1)
+			if len(waiting[client]) == 0 {
+				delete(waiting, client)
+			} else {
+				turns = append(turns, client)
+			}
+			queue.turns[priority] = turns
+			return job, true
+		}
+	}
+	return queuedJob{}, false
+}
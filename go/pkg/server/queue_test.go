@@ -0,0 +1,118 @@
+package server
+
+/*
+This file tests the queue of the asynchronous jobs on its own, with jobs blocking until the test releases them: the
+daily quota of a key, the jobs of a key over its concurrent jobs held back while slots are free, and the order the
+priority classes and the clients of a class are served in.
+
+---
+
+### `blockingJob(started chan<- string, name string) (func(), chan struct{})`
+Returns a job sending `name` to `started` once it runs, then blocking until the returned channel is closed.
+
+### `expectStarted(t *testing.T, started <-chan string, expected ...string)`
+Asserts that the jobs `expected` start, in this order, and that no other job starts meanwhile.
+*/
+
+import (
+	"ELP-project/internal/protocol"
+	"errors"
+	"testing"
+	"time"
+)
+
+const queueTestTimeout = 5 * time.Second
+
+func blockingJob(started chan<- string, name string) (func(), chan struct{}) {
+	release := make(chan struct{})
+	return func() {
+		started <- name
+		<-release
+	}, release
+}
+
+func expectStarted(t *testing.T, started <-chan string, expected ...string) {
+	t.Helper()
+
+	for _, name := range expected {
+		select {
+		case got := <-started:
+			if got != name {
+				t.Fatalf("job %s started, expected %s", got, name)
+			}
+		case <-time.After(queueTestTimeout):
+			t.Fatalf("job %s not started after %v", name, queueTestTimeout)
+		}
+	}
+	select {
+	case got := <-started:
+		t.Fatalf("job %s started, expected none", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestJobQueueQuotas(t *testing.T) {
+	queue := newJobQueue(4)
+
+	daily := keyLimits{priority: priorityNormal, jobsPerDay: 2}
+	for i := 0; i < daily.jobsPerDay; i++ {
+		if err := queue.admit("backoffice", daily); err != nil {
+			t.Fatalf("job %d of the daily quota refused: %v", i+1, err)
+		}
+	}
+	var errorMessage protocol.ErrorMessage
+	if err := queue.admit("backoffice", daily); !errors.As(err, &errorMessage) || errorMessage.Code != protocol.CodeQuotaExceeded {
+		t.Fatalf("job over the daily quota answered with %v, expected a %q error", err, protocol.CodeQuotaExceeded)
+	}
+	if err := queue.admit("scanner-1", daily); err != nil {
+		t.Fatalf("job of another key refused: %v", err)
+	}
+
+	// Free slots, but a single job of the key at a time.
+	started := make(chan string, 4)
+	concurrent := keyLimits{priority: priorityNormal, concurrentJobs: 1}
+	first, releaseFirst := blockingJob(started, "first")
+	second, releaseSecond := blockingJob(started, "second")
+	defer close(releaseSecond)
+	queue.push("backoffice", concurrent, first)
+	queue.push("backoffice", concurrent, second)
+	expectStarted(t, started, "first")
+	if length := queue.length(); length != 1 {
+		t.Fatalf("%d jobs waiting, expected the second job of the key", length)
+	}
+	close(releaseFirst)
+	expectStarted(t, started, "second")
+}
+
+func TestJobQueuePriorities(t *testing.T) {
+	queue := newJobQueue(1)
+	started := make(chan string, 8)
+
+	busy, releaseBusy := blockingJob(started, "busy")
+	queue.push("scanner-1", defaultKeyLimits, busy)
+	expectStarted(t, started, "busy")
+
+	// Queued while the only slot is busy: served by class, the clients of a class taking turns.
+	jobs := []struct {
+		client, name string
+		priority     jobPriority
+	}{
+		{"archive", "low", priorityLow},
+		{"backoffice", "normal-1", priorityNormal},
+		{"backoffice", "normal-2", priorityNormal},
+		{"scanner-1", "normal-3", priorityNormal},
+		{"frontdesk", "high", priorityHigh},
+	}
+	releases := make(map[string]chan struct{})
+	for _, job := range jobs {
+		run, release := blockingJob(started, job.name)
+		releases[job.name] = release
+		queue.push(job.client, keyLimits{priority: job.priority}, run)
+	}
+
+	close(releaseBusy)
+	for _, name := range []string{"high", "normal-1", "normal-3", "normal-2", "low"} {
+		expectStarted(t, started, name)
+		close(releases[name])
+	}
+}
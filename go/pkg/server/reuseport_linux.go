@@ -0,0 +1,21 @@
+//go:build linux
+
+package server
+
+/*
+This file implements `reusePort` on Linux (see `handover.go`).
+*/
+
+import "syscall"
+
+const soReusePort = 0xf
+
+func reusePort(network string, address string, conn syscall.RawConn) error {
+	var optionErr error
+	if err := conn.Control(func(fd uintptr) {
+		optionErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	}); err != nil {
+		return err
+	}
+	return optionErr
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package server
+
+/*
+This file implements `reusePort` on the platforms without `SO_REUSEPORT` support (see `handover.go`).
+*/
+
+import (
+	"errors"
+	"syscall"
+)
+
+func reusePort(network string, address string, conn syscall.RawConn) error {
+	return errors.New("SO_REUSEPORT is only supported on Linux")
+}
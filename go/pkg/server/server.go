@@ -0,0 +1,2484 @@
+package server
+
+/*
+Package server implements a TCP server designed for distributed image processing using a worker pool architecture.
+The server supports concurrent image processing tasks such as grayscale transformation, edge detection, and geometry computation.
+
+The package is used by the `server` command (`cmd/server`), and can be imported by other programs to embed the
+processing server instead of running the binary: they choose its listener and logger through the `Config`.
+
+---
+
+### Features
+1. **TCP Communication**:
+   - Handles incoming connections from clients.
+   - Receives image data over TCP.
+   - Sends the processed image back to the client.
+
+2. **Worker Pool**:
+   - Utilizes a worker pool to process tasks concurrently.
+   - Supports tasks like grayscale image transformation, edge detection, and contour finding.
+
+3. **Image Processing Pipeline**:
+   - Processes images in chunks for efficient parallelism.
+   - Tasks include:
+     - Grayscale conversion.
+     - Canny edge detection.
+     - Contour finding and quadrilateral detection.
+
+---
+
+### Constants
+- `maxDPI` (int): Highest output resolution a client can request.
+- `maxPaperUpscale` (int): Largest factor a document is scaled up by to the paper width of its preset: a photo has
+  no detail beyond it, and a long receipt would fill the memory.
+- `discardTimeout` (time.Duration): Time allowed to drain a rejected upload before closing the connection.
+- `overlapSize` (int): Overlap size between chunks of image processing.
+- `deterministicChunks` (int): Number of chunks an image is split into in deterministic mode.
+- `borderMargin` (int): Width in pixels of the band along the edge of the image a contour touching the border reaches
+  into (see `utils.BorderContact`).
+- `borderPenalty` (float64): Factor the area of a contour touching the border is ranked by with
+  `protocol.BorderPenalize`: it is only picked if it is four times as large as any other.
+- `shapeWeight` (float64): Weight of the shape of the candidates in their ranking when the request expects a page
+  size (`protocol.Header.Shape`, see `utils.PageShape`): a candidate of no such shape is ranked by half its area.
+- `maxAlternatives` (int): Number of candidates returned in the metadata besides the document.
+- `maxDocuments` (int): Number of documents returned at most by a request looking for several of them
+  (`protocol.Header.MultiDocument`).
+
+---
+
+### Structures
+#### `workerChannels`
+Represents the channels used for communication between tasks and workers.
+- Fields:
+  - `socketSemaphore`: Used to limit simultaneous socket connections.
+  - `imageChan`: Tasks for image transformation (e.g., grayscale, edge detection).
+  - `bfsChan`: Tasks for finding contours using BFS.
+  - `findQuadrilateralChan`: Tasks for ranking the candidate documents of the contours.
+
+#### `requestOptions`
+Processing options of a request, parsed from its header.
+- Fields:
+  - `page`, `dpi`: Page size and resolution the result is scaled to, no scaling if `page` is nil.
+  - `warp`: Whether the document is straightened by a perspective warp of its corners, that of the preset of the
+    request.
+  - `rotated`, `paperWidth`: Detection and crop of a narrow strip of paper, and width it is scaled to when `page` is
+    nil, those of the preset of the request (see `presets.go`).
+  - `size`, `tolerance`: Physical size the document is warped to, and tolerance on its proportions (see
+    `checkAspect`), those of the preset of the request. Nil for the presets of documents of any size.
+  - `operation`: The result asked by the client (`protocol.Header.Operation`), the cropped document if empty.
+  - `format`: Format the result is encoded to, the format of the received image if empty.
+  - `canny`: Parameters of the edge detection, those of the preset of the request (see `presets.go`), with the
+    operator of `protocol.Header.EdgeDetector`.
+  - `closing`: Radius of the closing of the edge map by the preset of the request, no closing if 0.
+  - `flatten`: Whether the illumination of the image is flattened before its edge detection
+    (`protocol.Header.Flatten`).
+  - `detectionTone`: Tone curve the grayscale image is mapped through before its edge detection
+    (`protocol.Header.DetectionTone`), nil if none.
+  - `border`: Factor the area of the contours touching the border of the image is ranked by
+    (`protocol.Header.Border`): 1 keeps them as they are, 0 discards them.
+  - `centering`: Weight of the distance of the candidates to the center of the image in their ranking
+    (`protocol.Header.Centering`), from 0 to 1.
+  - `shape`: Ratio of the sides of the page size the candidates are expected to have the proportions of
+    (`protocol.Header.Shape`), 0 for no preference.
+  - `alternatives`: Filled by `process` with the candidates for the document which were not picked, best first, nil
+    for the operations not detecting the document.
+  - `hough`: Whether the document is found as the quadrilateral of the dominant lines of the edge map
+    (`protocol.DetectorHough`), the contour found otherwise being the fallback.
+  - `ransac`: Whether the document is replaced by the quadrilateral of the sides fitted on its contour
+    (`protocol.DetectorRANSAC`), the contour itself being the fallback.
+  - `multiDocument`: What the candidates must look like to be listed in the `Documents` of `document`, nil unless
+    the request looks for several documents (`protocol.Header.MultiDocument`). Its `Bounds` are set by `process`.
+  - `deskew`: Whether the cropped document is straightened by the angle of its text lines (`protocol.Header.Deskew`).
+  - `fillHoles`: Whether the punched holes and the staples of the cropped document are painted over
+    (`protocol.Header.FillHoles`).
+  - `whiteBalance`: White balance of the cropped document (`protocol.Header.WhiteBalance`), applied with its color
+    statistics.
+  - `enhance`: Enhancement of the cropped document by the preset of the request, or the look of a scan for
+    `protocol.OperationScan`, despeckled once binarized, nil if none.
+  - `tone`: Tone curve the cropped document is mapped through once enhanced (`protocol.Header.Tone`), nil if none.
+  - `lossless`: Lossless crop of the document out of the received JPEG (`protocol.Header.Lossless`), nil if the
+    request did not ask for it (see `lossless.go`).
+  - `deterministic`: Whether the result must not depend on the configuration of the server (see `process`).
+  - `anonymize`: Whether the photos found on the cropped document are blurred (see `internal/anonymize`).
+  - `stamp`: Stamp laid over the cropped document, nil if none (see `stamp.go`).
+  - `document`: Filled by `process` with the corners of the detected document for `protocol.OperationCorners` and
+    `protocol.OperationCrop`, nil for the other operations.
+  - `colors`: Filled by `process` with the color statistics of the cropped document (see `colorMetadata`), nil for
+    the other operations.
+  - `text`: Filled by `process` with the text recognized on the cropped document, nil if the request did not ask for
+    it (`protocol.Header.OCR`).
+  - `words`: Filled by `process` with the words recognized on the cropped document and their boxes, for the text
+    layer of a searchable PDF (`protocol.FormatPDF`). Nil if the request did not ask for the text in a PDF, or if the
+    recognizer does not locate the words (`ocr.WordRecognizer`): the text layer is then made of the lines of `text`.
+  - `rotation`: Filled by `process` with the clockwise rotation in degrees turning the cropped document upright,
+    found from the confidence of its text (see `ocr.Orient`), nil if the request did not ask for it
+    (`protocol.Header.Orient`).
+  - `gutter`: Filled by `process` with the column of the gutter the cropped document is split at when it is a
+    double-page spread (see `utils.FindGutter`), 0 if it is a single page. Nil unless the preset of the request
+    splits the spreads (`protocol.PresetBook`).
+  - `session`, `sessionPage`: Scan session the result is added to and number of its page (`protocol.Header.Session`,
+    `protocol.Header.Page`), no session if empty (see `sessions.go`).
+  - `requestID`: ID of the request replacing the `{request}` placeholder of the stamp: the request ID on the
+    connection, or the ID of the job of an asynchronous request.
+  - `progress`: Called with each stage of `processingStages` run by the operation once it is completed, nil if the
+    client did not ask for progress reports.
+  - `artifacts`: Intermediate images requested by the client (`protocol.Header.Artifacts`).
+  - `artifact`: Called with each requested intermediate image once it is computed, nil for asynchronous requests.
+    `wants(name string) bool` tells whether an image must be computed and passed to `emit(name string, img
+    image.Image)`, which gives it to `artifact` and `debug`.
+  - `debug`: Intermediate results kept for the debug bundle of the request if it fails, nil if bundles are disabled
+    (see `debug.go`).
+  - Methods `stages() []string` and `outputFormat(input string) string` return the stages run by the operation and
+    the format of the result of an image received in the `input` format, `recognizedText() string` the text of
+    `text`, empty if the request did not ask for it, `rotationDegrees() int` the rotation of `rotation`, 0 if
+    the request did not ask for it, `split() bool` whether the result was split at the gutter of a spread,
+    `candidateAlternatives() []protocol.Candidate` the candidates of `alternatives`, nil if there are none, and
+    `documentCorners() []protocol.Point` the corners of `document`, nil for the operations not detecting the
+    document.
+  - `timings`: Time spent in every stage of the request, sent to the client in the trailer of the response (see
+    `timings.go`).
+
+#### `Server`
+Represents the TCP server.
+- Fields:
+  - `host`: Host address for the server.
+  - `port`: Port for the server.
+  - `stopCtx`: Context to signal server shutdown.
+  - `cancel`: Callback function to trigger the context cancellation.
+  - `numWorkers`: Number of concurrent workers (`Config.Workers`).
+  - `config`: Tunable settings such as upload and decoding limits (see `config.go`).
+  - `logger`: Logger of the server events (`Config.Logger`).
+  - `recent`: Recently returned results, used to detect duplicate submissions (see `duplicates.go`).
+  - `sessions`: Pages of the scan sessions not finalized yet (see `sessions.go`).
+  - `debug`: Debug bundles of the failed requests, nil if they are disabled (see `debug.go`).
+  - `crashes`: Crash bundles of the requests whose processing panicked, nil if they are disabled (see `crash.go`).
+  - `detector`: Detector of the photos blurred by the anonymization (`Config.Detector`).
+  - `recognizer`: Recognizer of the text of the documents (`Config.Recognizer`, or Tesseract run as
+    `Config.OCRCommand`), nil if the server recognizes no text.
+  - `calibration`: Processing rate of every stage on the host, used to estimate the cost of the images (see
+    `estimate.go`).
+  - `jobs`: Registry of the asynchronous jobs (see `jobs.go`).
+  - `queue`: Queue of the asynchronous jobs waiting to be processed, by priority (see `queue.go`).
+  - `webhooks`: HTTP client calling the webhooks of the jobs (see `webhooks.go`).
+  - `limiter`: Per-host connection quotas (see `limiter.go`).
+  - `accessLog`: Logger of the access log (see `accesslog.go`).
+  - `arenas`: Arenas of the contour searches of the requests, reused from one request to the next (see
+    `takeArenas`). The pool drops them when the garbage collector runs, so an idle server does not keep the
+    buffers of its largest image.
+  - `keys`: API keys accepted from the clients, nil if authentication is disabled (see `auth.go`).
+  - `started`: Start time of the server.
+  - `stats`: Counters exposed by the admin interface (see `admin.go`).
+  - `connections`: Active connections, protected by `connectionsMutex`.
+  - `draining`: Set once the server is draining, new connections are then refused.
+  - `handedOver`: Set once the listeners were handed over to a new process, the server then drains once they are
+    closed.
+  - `pools`: Worker pools, resizable from the admin interface.
+  - `workers`: Tracker of the workers of the pools and of their task channels, checked by `WaitWorkers`.
+  - `listener`, `channels`: The listener and the worker channels of the running server.
+  - `adminListener`: The listener of the admin interface, nil if it is disabled.
+  - `inherited`: The sockets inherited from the previous process, nil unless the server was started by a handover
+    (see `handover.go`).
+  - `successor`: Pipe kept open until the process exits once the listeners were handed over, protected by
+    `handoverMutex`.
+  - `active`: Connections and asynchronous jobs in progress, waited for by `Shutdown`.
+  - `done`: Closed once the server stopped accepting connections.
+
+- Methods:
+  - `Start(ctx context.Context) error`: Starts the workers and accepts the connections in the background, until
+    `ctx` is cancelled, the server is drained or `Shutdown` is called. Returns once connections are accepted.
+  - `Shutdown(ctx context.Context) error`: Stops accepting connections, closes the idle ones, and waits until the
+    requests and jobs in progress are finished before stopping the workers. Returns `ctx.Err()` if they are not
+    finished when `ctx` is done.
+  - `Done() <-chan struct{}`: Closed once the server stopped accepting connections, after which `Shutdown` should
+    be called.
+  - `Handover() error`: Hands the listeners over to a new process of the server, then drains the server (see
+    `handover.go`).
+  - `Addr() net.Addr`: Address of the listener of a started server, e.g. the port picked for an ephemeral listener.
+    The first address when the server listens on several ones.
+  - `WaitWorkers(ctx context.Context) error`: Waits until the workers of a shut down server stopped, and returns an
+    error if some are still running when `ctx` is done, if a task channel was left open, or if a task was sent to a
+    closed one (see `worker.Tracker`). Nil once the server shut down cleanly.
+  - `AdminAddr() net.Addr`: Address of the admin interface of a started server, nil if it is disabled or could not
+    listen.
+  - `listen() (net.Listener, error)`: Starts listening on the configured addresses (see `listeners.go`).
+  - `receiveImage(conn net.Conn, length int) ([]byte, error)`: Receives the payload of an image frame, copied in
+    one pass into a buffer of the announced length.
+  - `decodeImage(data []byte) (image.Image, string, error)`: Decodes a received image. Images whose header
+    announces more than `MaxPixels` pixels are rejected before being decoded.
+  - `checkDimensions(width, height int) error`: Checks the decoded size of an image against the configured limits.
+  - `checkCanvas(width, height int) error`: Checks the size of a result against the same limits, before it is
+    allocated: a page size at a high resolution would otherwise let a tiny request allocate gigabytes.
+  - `discard(conn net.Conn, length int) error`: Drains a rejected frame so the client can read the error frame.
+    Uploads larger than `MaxPayloadSize` are discarded this way without being buffered.
+  - `sendError(conn *connection, requestID uint32, code string, err error) string`: Sends an error frame to the client, and returns the error code sent.
+  - `sendData(conn *connection, requestID uint32, data []byte)`: Sends already encoded data to the client.
+  - `sendProgress(conn *connection, requestID uint32, stage string, steps int)`: Reports a completed stage of a
+    request to the client in a progress frame, out of the `steps` stages run by the request.
+  - `sendArtifact(conn *connection, requestID uint32, name string, img image.Image)`: Sends an intermediate image of
+    a request to the client in an artifact frame, encoded as PNG.
+  - `handleConnection(conn net.Conn, workerChannels workerChannels)`: Reads the multiplexed requests of a connection (see `connection.go`).
+  - `handleRequest(conn *connection, requestID uint32, header protocol.Header, data []byte, transfer requestTransfer, workerChannels workerChannels)`: Decodes a request and answers it, synchronously or through an asynchronous job.
+  - `process(conn net.Conn, img image.Image, options requestOptions, workerChannels workerChannels) (image.Image, error)`: Manages the entire image processing pipeline for an image.
+  - `chunkCount(options requestOptions) int`: Number of chunks an image is split into: one per worker of
+    `Config.Workers`, or `deterministicChunks` in deterministic mode.
+  - `sendResponse(conn *connection, requestID uint32, metadata *protocol.Metadata, data []byte, timings *stageTimings)`: Sends the optional metadata and image of a response, then the
+    timing report if `timings` is not nil, and the end frame.
+  - `sendTimedResponse(conn *connection, requestID uint32, metadata *protocol.Metadata, data []byte, timings *stageTimings, entry *accessEntry)`: Same as `sendResponse`, and records the sending time in the access log entry.
+  - `serve(listener net.Listener)`: Accepts and manages the connections of `listener` until the server stops.
+
+---
+
+### New(config Config) (*Server, error)
+Initializes a new server instance: opens the job storage and registry, the storages of the inputs, the API keys, the access log, the directory
+of the debug bundles and the calibration profile of the configuration. A server started by a handover inherits the sockets of the previous
+process, and opens the job registry in standby mode until that process exits. An unknown `Config.ContourPass` is an
+error.
+
+---
+
+### Workflow
+1. **Connection Handling**:
+   - Begins by listening on the configured addresses (`Listen`, or `Host` and `Port`), or accepts from the injected
+     `Listener`. The connections of every address are served alike.
+   - Accepts incoming TCP connections. A client host opening too many connections, or waiting too long for a
+     connection slot, is rejected with a "busy, retry later" error.
+   - If an API keys file is configured, every connection must authenticate with one of the keys first.
+   - Reads the protocol magic, then the requests of the client: each request is a header (page size,
+     resolution) followed by an image. Several requests can be in progress on the same connection.
+   - Receives the image data from the client using `receiveImage`, or reads it from one of the storages of
+     `Config.Sources` when the request gives its URL instead (see `sources.go`).
+   - Clients speaking the legacy protocol (raw image followed by an "EOF" marker) are still served, see `legacy.go`.
+   - If the same client already sent exactly the same image recently, the cached result is sent back immediately,
+     unless the request sets `protocol.Header.NoCache`.
+   - Asynchronous requests are answered immediately with a job ID, the client fetches the result later by
+     querying the job, possibly from another connection, or is notified by a webhook once the job is finished.
+     Jobs are processed by priority, within the quotas of the API key of the client. With `-spool`, the jobs interrupted by a restart are
+     processed again when the server starts.
+   - The pages of a document can be sent one per request in a scan session (`protocol.Header.Session`): the server
+     keeps their results, and combines them into one PDF or ZIP when the client finalizes the session (see
+     `sessions.go`).
+
+2. **Image Processing**:
+   - Splits the image into chunks for parallel processing by workers.
+   - Chunks are processed in stages:
+     - Grayscale transformation, fused with the Gaussian blur of the edge detection in a single pass over the
+       chunk (see `utils.GrayscaleBlur`) unless the request needs the grayscale image itself. If the request asks
+       for it (`protocol.Header.Flatten`), the illumination of the whole image is estimated first, on a coarse grid
+       of its background (see `utils.EstimateIllumination`), and every chunk is flattened once converted, so the
+       border of a shadow cast on the document is not found by the edge detection. The levels of every chunk are
+       then mapped through the tone curve of the request, if it asks for one (`protocol.Header.DetectionTone`).
+     - Canny edge detection, or the zero crossings of the Laplacian of Gaussian of the chunk with
+       `protocol.EdgeDetectorLoG` (see `utils.OperatorLoG`). Every chunk also lists its edge pixels, and the contours are searched from these
+       pixels only instead of scanning the whole edge map (see `utils.FindContoursSeeded`). A closed edge map is
+       listed again by `utils.EdgePixels`.
+     - Contour and quadrilateral detection. The contours of every chunk are stored in an arena reused by the next
+       requests (see `utils.ContourArena` and `takeArenas`), rather than in millions of small allocations.
+
+3. **Result Aggregation**:
+   - Combines processed chunks into the final output image.
+   - If the request selects a page size, the cropped document is scaled to the page canvas computed from
+     its physical size and resolution, with bicubic interpolation (see `imageUtils.Resize`) to keep the text sharp.
+   - If the request asks for it (`protocol.Header.Anonymize`), or the server anonymizes every document
+     (`Config.Anonymize`), the photos found on the document are blurred before it is returned, with the detector of
+     `Config.Detector`. Such requests cannot ask for the grayscale image, the edge map nor any artifact but the
+     histograms.
+   - If the request asks for it (`protocol.Header.Stamp`), a text or an image is then stamped on the document (see
+     `stamp.go`). The results of stamps with placeholders, which change with every request, are not cached.
+   - If the request asks for it (`protocol.Header.OCR`), the text of the cropped document is recognized by
+     `Config.Recognizer` (or the Tesseract program of `Config.OCRCommand`), before the stamp so the stamp is not
+     read, and returned in the metadata (`protocol.Metadata.Text`), also for the jobs and the cached results. With
+     the PDF format, the words are also located on the document when the recognizer can, and the PDF is made
+     searchable by laying them as an invisible text layer over the image.
+   - If the request asks for it (`protocol.Header.FillHoles`), the punched holes along the edges of the cropped
+     document and the staples in its corners are painted over with the paper around them (see
+     `imageUtils.FillPunchHoles`), before it is straightened and enhanced.
+   - If the request asks for it (`protocol.Header.Deskew`), the cropped document is rotated by the angle its text
+     lines are slanted by (see `utils.Deskew`), before its enhancement.
+   - A request can ask for a scan (`protocol.OperationScan`): it is processed as a crop, but the cropped document is
+     given the look of a scan (see `imageUtils.ScanDocument`) instead of the enhancement of the preset, and binarized
+     if the request asks for it (`protocol.Header.Binarize`), in PNG unless it gives another format. A binarized scan
+     is despeckled, its specks removed and its pinholes filled, with the sizes of `protocol.Header.Despeckle` (see
+     `imageUtils.Despeckle`).
+   - If the request asks for it (`protocol.Header.Lossless`), a document cropped along its bounding box out of a JPEG
+     image is cut out of the received JPEG instead of being encoded again, so it keeps the quality of the photo (see
+     `lossless.go`). The images which cannot be cropped losslessly are cropped as usual.
+   - If the request asks for it (`protocol.Header.Tone`), the gamma, brightness and contrast of the cropped document
+     are adjusted once it is enhanced, through a single tone curve (see `imageUtils.ToneCurve`).
+   - If the request asks for it (`protocol.Header.Orient`), the document is turned upright before its text is
+     recognized, by the quarter turn whose text the recognizer reads with the most confidence (see `ocr.Orient`):
+     the last resort for the pages photographed upside down, whose outline looks the same either way.
+   - If the preset of the request asks for it (`protocol.PresetBook`), a document showing two pages side by side is
+     split at the shadow of its binding, once turned upright and recognized, and its pages are returned left first,
+     in a ZIP archive or a PDF of two pages (see `spread.go`).
+   - The contours touching the border of the image, often the background cut by the frame, are kept, ranked lower
+     or discarded as the request asks (`protocol.Header.Border`, see `utils.BorderContact`), so a table or a
+     keyboard enclosing a larger area than the document does not hijack the detection. The request can also prefer
+     the candidates near the center of the image (`protocol.Header.Centering`, see `utils.CenterProximity`), and
+     those shaped like the page size of the request (`protocol.Header.Shape`, see `utils.PageShape`).
+   - With `protocol.DetectorHough`, the corners of the document are the intersections of the four dominant lines of
+     the edge map instead (see `utils.FindHoughQuadrilateral`), unless they do not make a quadrilateral: the
+     contours are still searched, for this fallback and for the contour overlay. With `protocol.DetectorRANSAC`, the
+     document found is replaced by the quadrilateral of its four sides, fitted on its contour as straight lines (see
+     `utils.FitQuadrilateralRANSAC`), so its rounded or hidden corners are not cut, unless its sides do not make one.
+   - With `Config.GlobalThresholds`, the edge detection runs in two phases: the chunks are smoothed and the gradient
+     of the rows they own measured, then their edges are detected with the thresholds of the whole image (see
+     `utils.GradientStats`), instead of those of every chunk.
+   - The contours are gathered in the order of the chunks, whatever the order the workers finish in, and those found by
+     several chunks, the search following a component across their border, are merged into one (see
+     `utils.MergeContours`), so a document is not ranked once per chunk it crosses. With `ContourPassGlobal`
+     (`Config.ContourPass`), a single task searches the whole edge map instead, and nothing is merged. The candidate
+     documents of all the chunks are ranked together, those of the same score by position (see
+     `utils.CompareCandidates`). The best one is the document, unless the preset seeks a document of a given size: the
+     best candidate of that size is then taken (see `preferSize`). The next `maxAlternatives` candidates are returned in
+     the metadata (`protocol.Metadata.Alternatives`), with the corners of the document for a crop
+     (`protocol.Metadata.Corners`). In deterministic mode (`protocol.Header.Deterministic` or
+     `Config.Deterministic`), the image is also split into `deterministicChunks` chunks instead of one per worker, so
+     the same image always gives a bit-identical result, on any server.
+   - A request can ask for an estimate of the cost of its image instead (see `estimate.go`): only the header of the
+     image is decoded.
+   - A request can ask for the grayscale image or the edge map instead of the cropped document: the processing then
+     stops after that stage. It can also ask for the corners of the document only (`protocol.OperationCorners`): the
+     processing stops once the document is detected, and its corners, fitted on its outline whatever its rotation (see
+     `utils.FitQuadrilateral`), are sent back as a JSON `protocol.Document` instead of an image, in the `json` format.
+     With `protocol.Header.MultiDocument`, the document also lists every candidate looking like a document of its
+     own, e.g. the receipts laid side by side on a table, up to `maxDocuments` (see `utils.FindDocuments`).
+     The result is encoded in the format of the received image, unless the request selects another one.
+   - Sends the final processed image back to the client using `sendResponse`, with metadata describing the
+     transfer (bytes received and sent, upload and processing times, see `accesslog.go`), and a trailer with the
+     time spent in every stage of the pipeline.
+   - The intermediate images the client asked for (grayscale image, edge map, contour overlay) are sent as soon
+     as they are computed, even if the document is not found afterwards. Such requests bypass the cache of
+     duplicate submissions.
+   - Every request is recorded in the access log.
+   - With `-debug-dir`, a failed request is saved with its intermediate results as a debug bundle, whose ID is given
+     to the client in the error message (see `debug.go`).
+   - A panic while a request is processed, on its goroutine or on a worker, only fails that request with a
+     `protocol.CodeInternal` error: it is logged with its stack, and saved as a crash bundle with `-crash-dir` (see
+     `crash.go`). The other clients are not disturbed.
+
+4. **Worker Pool**:
+   - Uses multiple worker pools for different computations (e.g., grayscale conversion, BFS for contours).
+   - Tasks are distributed to workers via channels.
+
+5. **Graceful Shutdown**:
+   - Stops when the context given to `Start` is cancelled (the command cancels it on an interrupt signal), or
+     when `Shutdown` is called.
+   - Stops accepting new connections, lets the requests in progress finish, and stops the workers.
+   - The admin interface can also drain the server: new connections are refused, and the server shuts down once
+     the work in progress is finished.
+   - The listeners can be handed over to a new process of the server, e.g. to upgrade it, which drains the old one
+     without refusing any connection (see `handover.go`).
+
+6. **Administration**:
+   - With `-admin`, an HTTP interface exposes statistics and active connections, and lets operators drain the
+     server or change the number of workers at runtime (see `admin.go`).
+
+---
+
+### Key Image Processing Functions
+#### `GrayscaleWrapper(img image.Image) (image.Image, error)`
+Converts an image to grayscale using a utility function.
+
+#### `ApplyCannyEdgeDetectionWrapper(img image.Image) (image.Image, error)`
+Applies Canny edge detection to a grayscale image.
+
+
+#### `encodeResult(img image.Image, format string, options requestOptions) ([]byte, error)`
+Encodes the result of `process` in `format`: the document of `options` as JSON for `protocol.OperationCorners`,
+the JPEG cut out of the received image when the document was cropped losslessly (see `lossless.go`), the pages of
+`img` when it was split at the gutter of a spread (see `spread.go`), `img` otherwise. In `protocol.FormatPDF`, `img` fills a page of its physical size at the resolution of the request,
+under the text recognized on it, if any (see `internal/pdf`).
+
+#### `negotiateFormat(accept []string, formats []string) (string, error)`
+Returns the first format of `accept` (`protocol.Header.Accept`) among the `formats` the request can return: JSON for
+`protocol.OperationCorners`, JPEG or PNG for the pages of a session, PDF or ZIP for a finalized session, JPEG, PNG or
+PDF otherwise. The names are case-insensitive, and "jpg" stands for JPEG.
+
+#### `colorMetadata(stats imageUtils.ColorStatistics) protocol.ColorStats`
+Converts the color statistics of the cropped document to the metadata of the response. They are computed before the
+document is anonymized or stamped. When they find a color cast (`imageUtils.ColorStatistics.NeedsWhiteBalance`),
+`process` corrects it right away with the gains of the cast (`imageUtils.WhiteBalance`), before the enhancement of
+the preset, and sets `Balanced` in the metadata. `protocol.Header.WhiteBalance` turns the correction off, or forces
+it, with the gains of the cast or the gray-world gains of the document (`imageUtils.ColorStatistics.GrayWorldGains`).
+
+#### `chunkEdges(edges []geometry.Point, startY, endY int) []geometry.Point`
+Returns the edge pixels of a chunk, in reading order, in the rows from `startY` to `endY` it gives to the edge map of
+the image: the rows of the overlap are taken from the next chunk.
+
+#### `preferSize(candidates []utils.Candidate, size geometry.PageSize, tolerance float64) []utils.Candidate`
+Moves the best of the ranked `candidates` having the proportions of `size` (see `checkAspect`) to the front, so a
+preset seeking a document of a given size, e.g. an ID card, falls back on the next candidates when the best one is
+something else, e.g. the wallet around the card. Returns `candidates` unchanged if none of them fits.
+
+#### `alternativeMetadata(candidates []utils.Candidate, bounds image.Rectangle) []protocol.Candidate`
+Converts the candidates which were not picked to the alternatives of the metadata of the response, with their corners
+in the pixels of the image of `bounds` and their `Aspect`. Also converts the documents found by a request looking for
+several of them.
+
+#### `checkAspect(corners geometry.Contour, size geometry.PageSize, tolerance float64) error`
+Checks that the quadrilateral of `corners` has the proportions of `size`, e.g. those of an ID card for the
+`id-card` preset, whatever its orientation: the ratio of its longer side to its shorter one (see
+`utils.QuadrilateralSize`) may differ from that of `size` by `tolerance` at most. Fails with a
+`protocol.ErrorMessage` of code `protocol.CodeNotFound` otherwise, since the document found is not the one sought.
+
+#### `takeArenas(count int) []*utils.ContourArena` / `releaseArenas(arenas []*utils.ContourArena)`
+Take the arenas of the contour searches of a request from the pool of the server, one per chunk searched, so every
+task writes to its own arena, and give them back once the request is done with their contours. `process` only gives
+them back when no task can still be reading them: when it stops early on an error or a shutdown while the candidates
+are ranked, the arenas are left to the garbage collector. The contours kept for a debug bundle are copied out of them
+(see `debug.go`).
+
+---
+
+### Logging
+- Logs server events to `Config.Logger` (`server.log` for the command).
+- Important logs include:
+  - Server start and shutdown.
+  - New connections.
+  - Errors during image processing.
+  - Task completion and results.
+
+---
+
+### Example Usage:
+```go
+config := server.DefaultConfig()
+config.Listener, _ = net.Listen("tcp", "127.0.0.1:0")
+config.Logger = log.New(os.Stderr, "elp: ", log.LstdFlags)
+
+processing, err := server.New(config)
+if err != nil {
+	log.Fatal(err)
+}
+if err := processing.Start(ctx); err != nil {
+	log.Fatal(err)
+}
+// clients connect to processing.Addr()
+
+shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+defer cancel()
+processing.Shutdown(shutdownCtx)
+```
+
+---
+
+### Dependencies
+- **geometry**: Used for contour and geometric computations.
+- **storage**: Persists the asynchronous jobs to a directory or a cloud bucket.
+- **imageUtils**: Provides utility functions for image transformations.
+- **utils**: Contains advanced image processing algorithms like edge detection and contour extraction.
+- **worker**: Manages task distribution and the worker pool.
+*/
+
+import (
+	"ELP-project/internal/anonymize"
+	"ELP-project/internal/geometry"
+	"ELP-project/internal/imageUtils"
+	"ELP-project/internal/jobs"
+	"ELP-project/internal/netUtils"
+	"ELP-project/internal/ocr"
+	"ELP-project/internal/pdf"
+	"ELP-project/internal/protocol"
+	"ELP-project/internal/storage"
+	"ELP-project/internal/utils"
+	"ELP-project/internal/watermark"
+	"ELP-project/internal/worker"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	overlapSize         = 20
+	maxDPI              = 1200
+	maxPaperUpscale     = 3
+	deterministicChunks = 8
+	borderMargin        = 3
+	borderPenalty       = 0.25
+	shapeWeight         = 0.5
+	maxAlternatives     = 4
+	maxDocuments        = 16
+	ocrTimeout          = 2 * time.Minute
+
+	discardTimeout = 5 * time.Second
+)
+
+var errShuttingDown = errors.New("server is shutting down")
+
+type workerChannels struct {
+	socketSemaphore       chan net.Conn
+	imageChan             *worker.TaskChannel[image.Image, image.Image]
+	bfsChan               *worker.TaskChannel[image.Rectangle, []geometry.Contour]
+	findQuadrilateralChan *worker.TaskChannel[[]geometry.Contour, []utils.Candidate]
+}
+
+type requestOptions struct {
+	page          *geometry.PageSize
+	dpi           int
+	operation     string
+	format        string
+	canny         utils.CannyParameters
+	closing       int
+	flatten       bool
+	detectionTone *imageUtils.ToneCurve
+	border        float64
+	centering     float64
+	shape         float64
+	hough         bool
+	ransac        bool
+	multiDocument *utils.DocumentFilter
+	alternatives  *[]protocol.Candidate
+	deskew        bool
+	fillHoles     bool
+	whiteBalance  string
+	enhance       func(img image.Image) *image.RGBA
+	tone          *imageUtils.ToneCurve
+	lossless      *losslessCrop
+	warp          bool
+	rotated       bool
+	size          *geometry.PageSize
+	tolerance     float64
+	paperWidth    float64
+	deterministic bool
+	anonymize     bool
+	stamp         *watermark.Stamp
+	document      *protocol.Document
+	colors        *protocol.ColorStats
+	text          *string
+	words         *[]ocr.Word
+	rotation      *int
+	gutter        *int
+	session       string
+	sessionPage   int
+	requestID     string
+	progress      func(stage string)
+	artifacts     []string
+	artifact      func(name string, img image.Image)
+	debug         *debugCapture
+	timings       *stageTimings
+}
+
+var artifactNames = []string{
+	protocol.ArtifactGrayscale,
+	protocol.ArtifactEdges,
+	protocol.ArtifactContours,
+	protocol.ArtifactHistograms,
+}
+
+var processingStages = []string{
+	protocol.StageGrayscale,
+	protocol.StageEdges,
+	protocol.StageContours,
+	protocol.StageCropping,
+}
+
+func (options requestOptions) report(stage string) {
+	if options.progress != nil {
+		options.progress(stage)
+	}
+}
+
+func (options requestOptions) stages() []string {
+	switch options.operation {
+	case protocol.OperationGrayscale:
+		return processingStages[:1]
+	case protocol.OperationEdges:
+		return processingStages[:2]
+	case protocol.OperationCorners:
+		return processingStages[:3]
+	case protocol.OperationEstimate:
+		return nil
+	default:
+		return processingStages
+	}
+}
+
+func (options requestOptions) outputFormat(input string) string {
+	if options.format != "" {
+		return options.format
+	}
+	return input
+}
+
+func (options requestOptions) recognizedText() string {
+	if options.text == nil {
+		return ""
+	}
+	return *options.text
+}
+
+func (options requestOptions) rotationDegrees() int {
+	if options.rotation == nil {
+		return 0
+	}
+	return *options.rotation
+}
+
+func (options requestOptions) split() bool {
+	return options.gutter != nil && *options.gutter != 0
+}
+
+func (options requestOptions) candidateAlternatives() []protocol.Candidate {
+	if options.alternatives == nil {
+		return nil
+	}
+	return *options.alternatives
+}
+
+func (options requestOptions) documentCorners() []protocol.Point {
+	if options.document == nil {
+		return nil
+	}
+	return options.document.Corners
+}
+
+func (options requestOptions) wants(name string) bool {
+	if options.debug != nil && (name == protocol.ArtifactGrayscale || name == protocol.ArtifactEdges) {
+		return true
+	}
+	return options.artifact != nil && slices.Contains(options.artifacts, name)
+}
+
+func (options requestOptions) emit(name string, img image.Image) {
+	options.debug.keepImage(name, img)
+	if options.artifact != nil && slices.Contains(options.artifacts, name) {
+		options.artifact(name, img)
+	}
+}
+
+type Server struct {
+	host        string
+	port        string
+	stopCtx     context.Context
+	cancel      context.CancelFunc
+	numWorkers  int
+	config      Config
+	logger      *log.Logger
+	recent      *recentResults
+	sessions    *sessionStore
+	debug       *debugBundles
+	crashes     *debugBundles
+	detector    anonymize.Detector
+	recognizer  ocr.Recognizer
+	calibration calibration
+	jobs        *jobs.Registry
+	sources     []source
+	queue       *jobQueue
+	webhooks    *http.Client
+	limiter     *connectionLimiter
+	keys        apiKeys
+	accessLog   *log.Logger
+	arenas      sync.Pool
+
+	started          time.Time
+	stats            serverStats
+	connectionsMutex sync.Mutex
+	connections      map[net.Conn]*trackedConnection
+	draining         atomic.Bool
+	handedOver       atomic.Bool
+	pools            []resizablePool
+	workers          *worker.Tracker
+
+	listener      net.Listener
+	adminListener net.Listener
+	channels      workerChannels
+	active        sync.WaitGroup
+	done          chan struct{}
+	stopWorkers   sync.Once
+
+	inherited     *inheritance
+	handoverMutex sync.Mutex
+	successor     *os.File
+}
+
+func New(config Config) (*Server, error) {
+	logger := config.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	numWorkers := configuredWorkers(config)
+
+	rates := defaultCalibration
+	if config.CalibrationFile != "" {
+		var err error
+		if rates, err = loadCalibration(config.CalibrationFile); err != nil {
+			return nil, fmt.Errorf("loading calibration: %w", err)
+		}
+	}
+
+	maxRunningJobs := config.MaxRunningJobs
+	if maxRunningJobs <= 0 {
+		maxRunningJobs = numWorkers
+	}
+
+	location := config.Storage
+	if location == "" {
+		location = config.JobsDir
+	}
+	store, err := storage.Open(location)
+	if err != nil {
+		return nil, fmt.Errorf("opening job storage: %w", err)
+	}
+
+	inherited, err := inherit()
+	if err != nil {
+		return nil, fmt.Errorf("inheriting listeners: %w", err)
+	}
+
+	openRegistry := jobs.NewRegistry
+	if inherited != nil {
+		openRegistry = jobs.NewStandbyRegistry
+	}
+	registry, err := openRegistry(store, config.JobTTL, config.SpoolJobs)
+	if err != nil {
+		return nil, fmt.Errorf("opening job registry: %w", err)
+	}
+
+	sources, err := openSources(config.Sources)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks, err := newWebhookClient(config.WebhookNetworks)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys apiKeys
+	if config.AuthFile != "" {
+		keys, err = loadAPIKeys(config.AuthFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading API keys: %w", err)
+		}
+		logger.Printf("Authentication required, %d API keys loaded", len(keys))
+	}
+
+	accessLog, err := newAccessLog(config.AccessLog, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	detector := config.Detector
+	if detector == nil {
+		detector = anonymize.NewSkinDetector()
+	}
+
+	recognizer := config.Recognizer
+	if recognizer == nil && config.OCRCommand != "" {
+		tesseract := ocr.NewTesseract(config.OCRCommand, config.OCRLanguage)
+		if err := tesseract.Check(); err != nil {
+			return nil, fmt.Errorf("checking the OCR engine: %w", err)
+		}
+		recognizer = tesseract
+	}
+
+	switch config.ContourPass {
+	case "", ContourPassChunked, ContourPassGlobal:
+	default:
+		return nil, fmt.Errorf("unknown contour pass: %q", config.ContourPass)
+	}
+
+	if (config.DebugDir != "" || config.CrashDir != "") && config.DebugKeep < 1 {
+		return nil, fmt.Errorf("invalid number of debug bundles kept: %d", config.DebugKeep)
+	}
+	debug, err := newDebugBundles(config.DebugDir, config.DebugKeep, true)
+	if err != nil {
+		return nil, err
+	}
+	crashes, err := newDebugBundles(config.CrashDir, config.DebugKeep, config.CrashInput)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		host:        config.Host,
+		port:        config.Port,
+		stopCtx:     ctx,
+		cancel:      cancel,
+		numWorkers:  numWorkers,
+		config:      config,
+		logger:      logger,
+		recent:      newRecentResults(),
+		sessions:    newSessionStore(),
+		debug:       debug,
+		crashes:     crashes,
+		detector:    detector,
+		recognizer:  recognizer,
+		calibration: rates,
+		jobs:        registry,
+		sources:     sources,
+		queue:       newJobQueue(maxRunningJobs),
+		webhooks:    webhooks,
+		keys:        keys,
+		accessLog:   accessLog,
+		limiter:     newConnectionLimiter(config.MaxConnectionsPerHost, config.ConnectionRate, config.ConnectionBurst),
+		started:     time.Now(),
+		connections: make(map[net.Conn]*trackedConnection),
+		workers:     worker.NewTracker(),
+		inherited:   inherited,
+		done:        make(chan struct{}),
+	}, nil
+}
+
+func (server *Server) receiveImage(conn net.Conn, length int) ([]byte, error) {
+	var dataBuffer bytes.Buffer
+	dataBuffer.Grow(length + bytes.MinRead)
+
+	if _, err := netUtils.CopyN(&dataBuffer, conn, int64(length)); err != nil {
+		return nil, fmt.Errorf("reading image data: %w", err)
+	}
+
+	return dataBuffer.Bytes(), nil
+}
+
+func (server *Server) decodeImage(data []byte) (image.Image, string, error) {
+	imageConfig, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("decoding image header: %w", err)
+	}
+	if err := server.checkDimensions(imageConfig.Width, imageConfig.Height); err != nil {
+		return nil, "", err
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("decoding image: %w", err)
+	}
+
+	server.logger.Printf("Image decoded successfully. Format: %s", format)
+	return img, format, nil
+}
+
+func (server *Server) checkDimensions(width, height int) error {
+	if width > server.config.MaxDimension || height > server.config.MaxDimension ||
+		width*height > server.config.MaxPixels {
+		return protocol.ErrorMessage{
+			Code: protocol.CodeTooLarge,
+			Message: fmt.Sprintf("image of %dx%d pixels exceeds the limits (%d pixels, %d per side)",
+				width, height, server.config.MaxPixels, server.config.MaxDimension),
+		}
+	}
+	return nil
+}
+
+func (server *Server) checkCanvas(width, height int) error {
+	if width > server.config.MaxDimension || height > server.config.MaxDimension ||
+		width*height > server.config.MaxPixels {
+		return protocol.ErrorMessage{
+			Code: protocol.CodeTooLarge,
+			Message: fmt.Sprintf("result of %dx%d pixels exceeds the limits (%d pixels, %d per side)",
+				width, height, server.config.MaxPixels, server.config.MaxDimension),
+		}
+	}
+	return nil
+}
+
+func (server *Server) discard(conn net.Conn, length int) error {
+	if err := conn.SetReadDeadline(time.Now().Add(discardTimeout)); err != nil {
+		return err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+
+	if _, err := netUtils.CopyN(io.Discard, conn, int64(length)); err != nil {
+		server.logger.Printf("Error discarding rejected data from %s: %v", conn.RemoteAddr(), err)
+		return err
+	}
+	return nil
+}
+
+func (server *Server) sendError(conn *connection, requestID uint32, code string, err error) string {
+	var errorMessage protocol.ErrorMessage
+	if errors.As(err, &errorMessage) {
+		code = errorMessage.Code
+		err = errors.New(errorMessage.Message)
+	}
+
+	server.logger.Printf("Sending error to %s (request %d): %v", conn.RemoteAddr(), requestID, err)
+	server.stats.failures.Add(1)
+
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+
+	if writeErr := protocol.WriteError(conn, conn.codec, requestID, code, err.Error()); writeErr != nil {
+		server.logger.Printf("Error sending error frame: %v", writeErr)
+		return code
+	}
+	if writeErr := conn.flush(); writeErr != nil {
+		server.logger.Printf("Error sending error frame: %v", writeErr)
+	}
+	return code
+}
+
+func imageToBuffer(img image.Image, format string) (*bytes.Buffer, error) {
+	var buffer bytes.Buffer
+
+	switch format {
+	case "jpeg":
+		if err := jpeg.Encode(&buffer, img, nil); err != nil {
+			return nil, fmt.Errorf("failed to encode image to JPEG: %w", err)
+		}
+	case "png":
+		if err := png.Encode(&buffer, img); err != nil {
+			return nil, fmt.Errorf("failed to encode image to PNG: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported format: %v", format)
+	}
+
+	return &buffer, nil
+}
+
+func encodeResult(img image.Image, format string, options requestOptions) ([]byte, error) {
+	if options.operation == protocol.OperationCorners {
+		data, err := json.Marshal(options.document)
+		return append(data, '\n'), err
+	}
+	if options.lossless.done() {
+		return options.lossless.data, nil
+	}
+	if options.split() {
+		if format == protocol.FormatPDF {
+			var buffer bytes.Buffer
+			if err := pdf.EncodeDocument(&buffer, spreadHalves(img, options)); err != nil {
+				return nil, err
+			}
+			return buffer.Bytes(), nil
+		}
+		pages, err := spreadPages(img, format, options)
+		if err != nil {
+			return nil, err
+		}
+		return combinePages(pages, protocol.FormatZIP)
+	}
+	if format == protocol.FormatPDF {
+		page := pdf.Page{
+			Image:  img,
+			Width:  pdf.PixelPoints(img.Bounds().Dx(), options.dpi),
+			Height: pdf.PixelPoints(img.Bounds().Dy(), options.dpi),
+			Text:   options.recognizedText(),
+		}
+		if options.words != nil {
+			page.Words = *options.words
+		}
+		var buffer bytes.Buffer
+		if err := pdf.Encode(&buffer, page); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	}
+	return encodeImage(img, format)
+}
+
+func negotiateFormat(accept []string, formats []string) (string, error) {
+	for _, format := range accept {
+		format = strings.ToLower(strings.TrimSpace(format))
+		if format == "jpg" {
+			format = "jpeg"
+		}
+		if slices.Contains(formats, format) {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("none of the accepted formats %q can be returned, only %s", accept, strings.Join(formats, ", "))
+}
+
+func encodeImage(img image.Image, format string) ([]byte, error) {
+	buffer, err := imageToBuffer(img, format)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func (server *Server) sendResponse(conn *connection, requestID uint32, metadata *protocol.Metadata, data []byte, timings *stageTimings) {
+	sendingStart := time.Now()
+
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+
+	if metadata != nil {
+		if err := protocol.WriteMetadata(conn, conn.codec, requestID, *metadata); err != nil {
+			server.logger.Printf("Error sending metadata: %v", err)
+			return
+		}
+	}
+
+	if data != nil {
+		if err := server.sendData(conn, requestID, data); err != nil {
+			server.logger.Printf("Error sending data: %v", err)
+			return
+		}
+	}
+
+	if timings != nil {
+		timings.since(protocol.TimingSend, sendingStart)
+		if err := protocol.WriteTrailer(conn, conn.codec, requestID, protocol.Trailer{Timings: timings.report()}); err != nil {
+			server.logger.Printf("Error sending trailer: %v", err)
+			return
+		}
+	}
+
+	if err := protocol.WriteEnd(conn, requestID); err != nil {
+		server.logger.Printf("Error sending end of response: %v", err)
+		return
+	}
+	if err := conn.flush(); err != nil {
+		server.logger.Printf("Error sending end of response: %v", err)
+	}
+}
+
+func (server *Server) sendData(conn *connection, requestID uint32, data []byte) error {
+	if err := protocol.WriteFrameHeader(conn, protocol.FrameImage, requestID, len(data)); err != nil {
+		return err
+	}
+
+	if _, err := conn.Write(data); err != nil {
+		return err
+	}
+
+	server.logger.Printf("Image sent successfully. Total bytes: %d", len(data))
+	return nil
+}
+
+func (server *Server) sendProgress(conn *connection, requestID uint32, stage string, steps int) {
+	progress := protocol.Progress{
+		Stage: stage,
+		Step:  slices.Index(processingStages, stage) + 1,
+		Steps: steps,
+	}
+
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+
+	if err := protocol.WriteProgress(conn, conn.codec, requestID, progress); err != nil {
+		server.logger.Printf("Error sending progress: %v", err)
+		return
+	}
+	if err := conn.flush(); err != nil {
+		server.logger.Printf("Error sending progress: %v", err)
+	}
+}
+
+func (server *Server) sendArtifact(conn *connection, requestID uint32, name string, img image.Image) {
+	data, err := encodeImage(img, "png")
+	if err != nil {
+		server.logger.Printf("Error encoding %s artifact: %v", name, err)
+		return
+	}
+
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+
+	if err := protocol.WriteArtifact(conn, requestID, protocol.Artifact{Name: name, Data: data}); err != nil {
+		server.logger.Printf("Error sending artifact: %v", err)
+		return
+	}
+	if err := conn.flush(); err != nil {
+		server.logger.Printf("Error sending artifact: %v", err)
+	}
+}
+
+func colorMetadata(stats imageUtils.ColorStatistics) protocol.ColorStats {
+	channel := func(channel imageUtils.ChannelStats) protocol.ChannelStats {
+		return protocol.ChannelStats{
+			Mean: channel.Mean,
+			P5:   int(channel.P5),
+			P50:  int(channel.P50),
+			P95:  int(channel.P95),
+		}
+	}
+	return protocol.ColorStats{
+		Red:          channel(stats.Red),
+		Green:        channel(stats.Green),
+		Blue:         channel(stats.Blue),
+		Cast:         stats.Cast.Color,
+		CastStrength: stats.Cast.Strength,
+	}
+}
+
+func (server *Server) parseOptions(header protocol.Header) (requestOptions, error) {
+	options := requestOptions{
+		dpi:           header.DPI,
+		operation:     header.Operation,
+		format:        header.Format,
+		deterministic: header.Deterministic,
+		anonymize:     header.Anonymize,
+		artifacts:     header.Artifacts,
+	}
+
+	if header.Offset != 0 {
+		return options, errors.New("an offset only applies to the queries of a job")
+	}
+
+	// A scan is a crop, enhanced differently.
+	scan := options.operation == protocol.OperationScan
+	if scan {
+		options.operation = protocol.OperationCrop
+	} else if header.Binarize {
+		return options, fmt.Errorf("only a scan is binarized, not the result of the %s operation", cmp.Or(options.operation, protocol.OperationCrop))
+	}
+	if header.Binarize && options.format == "" {
+		options.format = "png"
+	}
+	if header.Despeckle != nil && !header.Binarize {
+		return options, errors.New("only a binarized scan is despeckled")
+	}
+
+	switch options.operation {
+	case "", protocol.OperationCrop:
+		options.colors = &protocol.ColorStats{}
+		options.document = &protocol.Document{}
+		options.alternatives = new([]protocol.Candidate)
+	case protocol.OperationGrayscale, protocol.OperationEdges:
+	case protocol.OperationCorners:
+		if options.format != "" && options.format != protocol.FormatJSON {
+			return options, fmt.Errorf("the corners are returned as %s, not %s", protocol.FormatJSON, options.format)
+		}
+		options.format = protocol.FormatJSON
+		options.document = &protocol.Document{}
+		options.alternatives = new([]protocol.Candidate)
+	case protocol.OperationEstimate:
+		if header.Async {
+			return options, errors.New("an estimate cannot be asynchronous")
+		}
+	default:
+		return options, fmt.Errorf("unknown operation: %q", options.operation)
+	}
+
+	preset, err := lookupPreset(header.Preset)
+	if err != nil {
+		return options, err
+	}
+	options.canny = preset.canny
+	options.closing = preset.closing
+	options.flatten = header.Flatten
+	if options.detectionTone, err = toneCurve(header.DetectionTone); err != nil {
+		return options, err
+	}
+	options.enhance = preset.enhance
+	if scan {
+		speckSize, pinholeSize := protocol.DefaultSpeckSize, protocol.DefaultPinholeSize
+		if despeckle := header.Despeckle; despeckle != nil {
+			speckSize = cmp.Or(despeckle.SpeckSize, speckSize)
+			pinholeSize = cmp.Or(despeckle.PinholeSize, pinholeSize)
+		}
+		options.enhance = func(img image.Image) *image.RGBA {
+			scan := imageUtils.ScanDocument(img, header.Binarize)
+			if header.Binarize {
+				imageUtils.Despeckle(scan, speckSize, pinholeSize)
+			}
+			return scan
+		}
+	}
+	options.warp = preset.warp
+	options.rotated = preset.rotated
+	options.size = preset.size
+	options.tolerance = preset.aspectTolerance
+	options.paperWidth = preset.paperWidth
+	if options.format == "" {
+		options.format = preset.format
+	}
+	if options.dpi == 0 {
+		options.dpi = preset.dpi
+	}
+	if preset.spread && (options.operation == "" || options.operation == protocol.OperationCrop) {
+		options.gutter = new(int)
+	}
+
+	switch header.Border {
+	case "", protocol.BorderKeep:
+		options.border = 1
+	case protocol.BorderPenalize:
+		options.border = borderPenalty
+	case protocol.BorderDiscard:
+		options.border = 0
+	default:
+		return options, fmt.Errorf("unknown border handling: %q", header.Border)
+	}
+	if header.Centering < 0 || header.Centering > 1 || math.IsNaN(header.Centering) {
+		return options, fmt.Errorf("the centering must be between 0 and 1, not %g", header.Centering)
+	}
+	options.centering = header.Centering
+	if header.Shape != "" {
+		shape, err := geometry.ParsePageSize(header.Shape)
+		if err != nil {
+			return options, fmt.Errorf("invalid shape: %w", err)
+		}
+		options.shape = shape.Ratio()
+	}
+
+	switch header.Detector {
+	case "", protocol.DetectorContours:
+	case protocol.DetectorHough:
+		options.hough = true
+	case protocol.DetectorRANSAC:
+		options.ransac = true
+	default:
+		return options, fmt.Errorf("unknown detector: %q", header.Detector)
+	}
+	switch header.EdgeDetector {
+	case "", protocol.EdgeDetectorCanny:
+	case protocol.EdgeDetectorLoG:
+		options.canny.Operator = utils.OperatorLoG
+	default:
+		return options, fmt.Errorf("unknown edge detector: %q", header.EdgeDetector)
+	}
+
+	if multi := header.MultiDocument; multi != nil {
+		if options.operation != protocol.OperationCorners {
+			return options, fmt.Errorf("several documents are only returned by the %s operation", protocol.OperationCorners)
+		}
+		if multi.MinArea < 0 || multi.MinArea > 1 || math.IsNaN(multi.MinArea) {
+			return options, fmt.Errorf("the smallest area of a document must be between 0 and 1, not %g", multi.MinArea)
+		}
+		if multi.MinAspect < 0 || multi.MinAspect > 1 || math.IsNaN(multi.MinAspect) {
+			return options, fmt.Errorf("the smallest aspect of a document must be between 0 and 1, not %g", multi.MinAspect)
+		}
+		options.multiDocument = &utils.DocumentFilter{
+			MinArea:   cmp.Or(multi.MinArea, protocol.DefaultDocumentArea),
+			MinAspect: cmp.Or(multi.MinAspect, protocol.DefaultDocumentAspect),
+		}
+	}
+
+	if options.anonymize {
+		switch {
+		case options.operation == protocol.OperationGrayscale || options.operation == protocol.OperationEdges:
+			return options, fmt.Errorf("the %s operation shows the photos of the document, it cannot be anonymized", options.operation)
+		case slices.ContainsFunc(options.artifacts, func(name string) bool { return name != protocol.ArtifactHistograms }):
+			return options, errors.New("the artifacts show the photos of the document, they cannot be anonymized")
+		}
+	}
+
+	if header.Stamp != nil {
+		if options.operation != "" && options.operation != protocol.OperationCrop && options.operation != protocol.OperationEstimate {
+			return options, fmt.Errorf("a stamp only applies to the cropped document, not to the %s operation", options.operation)
+		}
+		stamp, err := parseStamp(*header.Stamp)
+		if err != nil {
+			return options, err
+		}
+		options.stamp = &stamp
+	}
+
+	if header.OCR {
+		if options.operation != "" && options.operation != protocol.OperationCrop && options.operation != protocol.OperationEstimate {
+			return options, fmt.Errorf("the text is recognized on the cropped document, not for the %s operation", options.operation)
+		}
+		if server.recognizer == nil {
+			return options, protocol.ErrorMessage{Code: protocol.CodeUnavailable, Message: "this server does not recognize text"}
+		}
+		options.text = new(string)
+		if _, ok := server.recognizer.(ocr.WordRecognizer); ok && (options.format == protocol.FormatPDF || header.Session != "") {
+			options.words = new([]ocr.Word)
+		}
+	}
+
+	if header.Deskew {
+		if options.operation != "" && options.operation != protocol.OperationCrop {
+			return options, fmt.Errorf("the document is straightened once cropped, not for the %s operation", options.operation)
+		}
+		options.deskew = true
+	}
+
+	if header.FillHoles {
+		if options.operation != "" && options.operation != protocol.OperationCrop {
+			return options, fmt.Errorf("the holes of the document are filled once cropped, not for the %s operation", options.operation)
+		}
+		options.fillHoles = true
+	}
+
+	switch header.WhiteBalance {
+	case "", protocol.WhiteBalanceAuto:
+	case protocol.WhiteBalanceWhitePatch, protocol.WhiteBalanceGrayWorld, protocol.WhiteBalanceOff:
+		if options.operation != "" && options.operation != protocol.OperationCrop {
+			return options, fmt.Errorf("the white balance applies to the cropped document, not to the %s operation", options.operation)
+		}
+		options.whiteBalance = header.WhiteBalance
+	default:
+		return options, fmt.Errorf("unknown white balance: %q", header.WhiteBalance)
+	}
+
+	if header.Tone != nil {
+		if options.operation != "" && options.operation != protocol.OperationCrop {
+			return options, fmt.Errorf("the tone of the document is adjusted once cropped, not for the %s operation", options.operation)
+		}
+		if options.tone, err = toneCurve(header.Tone); err != nil {
+			return options, err
+		}
+	}
+
+	if header.Orient {
+		if options.operation != "" && options.operation != protocol.OperationCrop {
+			return options, fmt.Errorf("the document is turned upright once cropped, not for the %s operation", options.operation)
+		}
+		if _, ok := server.recognizer.(ocr.WordRecognizer); !ok {
+			return options, protocol.ErrorMessage{Code: protocol.CodeUnavailable, Message: "this server does not recognize the orientation of the text"}
+		}
+		options.rotation = new(int)
+		if options.text != nil {
+			options.words = new([]ocr.Word)
+		}
+	}
+
+	if len(header.Accept) > 0 && header.Format == "" && options.operation != protocol.OperationEstimate {
+		formats := []string{"jpeg", "png", protocol.FormatPDF}
+		switch {
+		case options.operation == protocol.OperationCorners:
+			formats = []string{protocol.FormatJSON}
+		case header.Lossless:
+			formats = []string{"jpeg"}
+		case header.Session != "":
+			formats = []string{"jpeg", "png"}
+		}
+		if options.format, err = negotiateFormat(header.Accept, formats); err != nil {
+			return options, err
+		}
+	}
+
+	if header.Session != "" {
+		switch {
+		case header.Async:
+			return options, errors.New("the pages of a session cannot be processed asynchronously")
+		case options.operation != "" && options.operation != protocol.OperationCrop:
+			return options, fmt.Errorf("a session gathers cropped documents, not the results of the %s operation", options.operation)
+		case options.format != "" && options.format != "jpeg" && options.format != "png":
+			return options, fmt.Errorf("the pages of a session are images, not %s: the session is combined when it is finalized", options.format)
+		case len(header.Session) > maxSessionIDLength:
+			return options, fmt.Errorf("session ID longer than %d bytes", maxSessionIDLength)
+		case header.Page < 0:
+			return options, fmt.Errorf("invalid page number: %d", header.Page)
+		}
+		options.session = header.Session
+		options.sessionPage = header.Page
+	} else if header.Page != 0 {
+		return options, errors.New("a page number requires a session")
+	}
+
+	switch options.format {
+	case "", "jpeg", "png", protocol.FormatPDF:
+	case protocol.FormatJSON:
+		if options.operation != protocol.OperationCorners {
+			return options, fmt.Errorf("the %s format is only returned by the %s operation", protocol.FormatJSON, protocol.OperationCorners)
+		}
+	default:
+		return options, fmt.Errorf("unsupported output format: %q", options.format)
+	}
+
+	for _, name := range options.artifacts {
+		if !slices.Contains(artifactNames, name) {
+			return options, fmt.Errorf("unknown artifact: %q", name)
+		}
+	}
+
+	if header.Webhook != "" {
+		if !header.Async {
+			return options, errors.New("a webhook requires an asynchronous request")
+		}
+		if err := validateWebhook(header.Webhook); err != nil {
+			return options, err
+		}
+	}
+
+	if header.PageSize != "" {
+		page, err := geometry.ParsePageSize(header.PageSize)
+		if err != nil {
+			return options, err
+		}
+		options.page = &page
+	}
+
+	if options.dpi == 0 {
+		options.dpi = geometry.DefaultDPI
+	}
+	if options.dpi < 0 || options.dpi > maxDPI {
+		return options, fmt.Errorf("invalid dpi: %d", header.DPI)
+	}
+	if options.page != nil {
+		canvas := options.page.Canvas(options.dpi, false)
+		if err := server.checkCanvas(canvas.X, canvas.Y); err != nil {
+			return options, err
+		}
+	}
+
+	if header.Lossless {
+		if err := checkLossless(header, options); err != nil {
+			return options, err
+		}
+		options.whiteBalance = protocol.WhiteBalanceOff
+		options.lossless = &losslessCrop{}
+	}
+
+	return options, nil
+}
+
+func toneCurve(tone *protocol.Tone) (*imageUtils.ToneCurve, error) {
+	if tone == nil {
+		return nil, nil
+	}
+	if tone.Gamma != 0 && !(tone.Gamma >= protocol.MinGamma && tone.Gamma <= protocol.MaxGamma) {
+		return nil, fmt.Errorf("the gamma must be between %g and %g, not %g", protocol.MinGamma, protocol.MaxGamma, tone.Gamma)
+	}
+	if !(tone.Brightness >= -1 && tone.Brightness <= 1) {
+		return nil, fmt.Errorf("the brightness must be between -1 and 1, not %g", tone.Brightness)
+	}
+	if !(tone.Contrast >= -1 && tone.Contrast <= 1) {
+		return nil, fmt.Errorf("the contrast must be between -1 and 1, not %g", tone.Contrast)
+	}
+
+	curve := imageUtils.GammaCurve(tone.Gamma).Then(imageUtils.BrightnessContrastCurve(tone.Brightness, tone.Contrast))
+	return &curve, nil
+}
+
+func errorCode(err error) string {
+	if errors.Is(err, errShuttingDown) {
+		return protocol.CodeUnavailable
+	}
+	return protocol.CodeInternal
+}
+
+func (server *Server) handleRequest(conn *connection, requestID uint32, header protocol.Header, data []byte, transfer requestTransfer, workerChannels workerChannels) {
+	server.stats.requests.Add(1)
+	server.stats.inFlight.Add(1)
+	defer server.stats.inFlight.Add(-1)
+
+	entry := accessEntry{
+		remoteAddr: conn.RemoteAddr().String(),
+		client:     conn.client,
+		requestID:  requestID,
+		status:     "ok",
+		transfer:   transfer,
+	}
+	defer server.logAccess(&entry)
+
+	var capture *debugCapture
+	if server.debug != nil {
+		capture = &debugCapture{}
+	}
+	defer server.recoverRequest(conn, requestID, header, data, capture, &entry.status)
+
+	if server.config.Anonymize {
+		header.Anonymize = true
+	}
+
+	if header.Operation == protocol.OperationEstimate {
+		options, err := server.parseOptions(header)
+		if err != nil {
+			entry.status = server.sendError(conn, requestID, protocol.CodeBadRequest, err)
+			return
+		}
+		entry.status = server.sendEstimate(conn, requestID, data, options, &entry)
+		return
+	}
+
+	fail := func(code string, err error) {
+		if server.debug != nil {
+			err = server.saveBundle(conn, requestID, header, data, capture, code, err)
+		}
+		entry.status = server.sendError(conn, requestID, code, err)
+	}
+
+	img, format, err := server.decodeImage(data)
+	if err != nil {
+		fail(protocol.CodeBadRequest, err)
+		return
+	}
+
+	options, err := server.parseOptions(header)
+	if err != nil {
+		fail(protocol.CodeBadRequest, err)
+		return
+	}
+	options.lossless.keep(data, format)
+	format = options.outputFormat(format)
+	options.requestID = fmt.Sprint(requestID)
+	options.timings = newStageTimings()
+	options.timings.add(protocol.TimingReceive, transfer.duration())
+
+	if header.Async {
+		entry.status = server.startJob(conn, requestID, header, data, img, format, options, server.transferStats(conn, transfer, 0, 0), workerChannels)
+		return
+	}
+
+	options.debug = capture
+	if header.Progress {
+		steps := len(options.stages())
+		options.progress = func(stage string) {
+			server.sendProgress(conn, requestID, stage, steps)
+		}
+	}
+	if len(options.artifacts) > 0 {
+		options.artifact = func(name string, img image.Image) {
+			server.sendArtifact(conn, requestID, name, img)
+		}
+	}
+	cacheable := !header.NoCache && options.session == "" && len(options.artifacts) == 0 && (options.stamp == nil || !watermark.HasPlaceholders(options.stamp.Text))
+
+	digest := submissionDigest(header, data)
+
+	client := remoteHost(conn)
+	if cached, ok := server.recent.lookup(client, digest); ok && cacheable {
+		server.logger.Printf("Duplicate submission from %s (sha256 %x), sending cached result", conn.RemoteAddr(), digest[:8])
+		entry.status = "cached"
+		entry.bytesSent = len(cached.result)
+		server.sendTimedResponse(conn, requestID, &protocol.Metadata{
+			Format:       spreadFormat(format, cached.spread),
+			Stats:        server.transferStats(conn, transfer, 0, len(cached.result)),
+			Colors:       cached.colors,
+			Text:         cached.text,
+			Rotation:     cached.rotation,
+			Lossless:     cached.lossless,
+			Corners:      cached.corners,
+			Alternatives: cached.alternatives,
+			Spread:       cached.spread,
+		}, cached.result, options.timings, &entry)
+		server.logger.Printf("Request %d finished: %s", requestID, conn.RemoteAddr())
+		return
+	}
+
+	processingStart := time.Now()
+	finalImage, err := server.process(conn, img, options, workerChannels)
+	entry.processing = time.Since(processingStart)
+	if panicked, ok := workerPanic(err); ok {
+		crash := DebugReport{RemoteAddr: conn.RemoteAddr().String(), Client: conn.client, RequestID: requestID, Header: header}
+		err = server.savePanic(fmt.Sprintf("request %d from %s", requestID, conn.RemoteAddr()), crash, data, capture, panicked.Value, panicked.Stack)
+		entry.status = server.sendError(conn, requestID, protocol.CodeInternal, err)
+		return
+	}
+	if err != nil {
+		server.logger.Printf("Error processing image for %s: %v", conn.RemoteAddr(), err)
+		fail(errorCode(err), err)
+		return
+	}
+
+	encodingStart := time.Now()
+	result, err := encodeResult(finalImage, format, options)
+	if err != nil {
+		fail(protocol.CodeInternal, err)
+		return
+	}
+	options.timings.since(protocol.TimingEncode, encodingStart)
+
+	pages := 0
+	if options.session != "" {
+		page := sessionPage{
+			number:   options.sessionPage,
+			format:   format,
+			data:     result,
+			width:    finalImage.Bounds().Dx(),
+			height:   finalImage.Bounds().Dy(),
+			dpi:      options.dpi,
+			text:     options.recognizedText(),
+			rotation: options.rotationDegrees(),
+		}
+		if options.words != nil {
+			page.words = *options.words
+		}
+		sessionPages := []sessionPage{page}
+		if options.split() {
+			if sessionPages, err = spreadPages(finalImage, format, options); err != nil {
+				fail(protocol.CodeInternal, err)
+				return
+			}
+		}
+		if pages, err = server.sessions.add(sessionOwner(conn), options.session, sessionPages...); err != nil {
+			fail(protocol.CodeBadRequest, err)
+			return
+		}
+	}
+
+	server.logger.Printf("Sending processed image back to %s", conn.RemoteAddr())
+	entry.bytesSent = len(result)
+	server.sendTimedResponse(conn, requestID, &protocol.Metadata{
+		Format:       spreadFormat(format, options.split()),
+		Stats:        server.transferStats(conn, transfer, entry.processing, len(result)),
+		Colors:       options.colors,
+		Text:         options.recognizedText(),
+		Rotation:     options.rotationDegrees(),
+		Pages:        pages,
+		Lossless:     options.lossless.done(),
+		Corners:      options.documentCorners(),
+		Alternatives: options.candidateAlternatives(),
+		Spread:       options.split(),
+	}, result, options.timings, &entry)
+	if cacheable {
+		server.recent.store(client, digest, recentResult{
+			result:       result,
+			colors:       options.colors,
+			text:         options.recognizedText(),
+			rotation:     options.rotationDegrees(),
+			lossless:     options.lossless.done(),
+			corners:      options.documentCorners(),
+			alternatives: options.candidateAlternatives(),
+			spread:       options.split(),
+		})
+	}
+	server.logger.Printf("Request %d finished: %s", requestID, conn.RemoteAddr())
+}
+
+func (server *Server) sendTimedResponse(conn *connection, requestID uint32, metadata *protocol.Metadata, data []byte, timings *stageTimings, entry *accessEntry) {
+	sendingStart := time.Now()
+	server.sendResponse(conn, requestID, metadata, data, timings)
+	entry.sending = time.Since(sendingStart)
+}
+
+func (server *Server) process(conn net.Conn, img image.Image, options requestOptions, workerChannels workerChannels) (image.Image, error) {
+	stageStart := time.Now()
+	chunks := server.chunkCount(options)
+	resultGrayChan := make(chan worker.Task[image.Image, image.Image], 100)
+
+	rgbaImg, ok := img.(*image.RGBA)
+	if !ok {
+		bounds := img.Bounds()
+		rgbaImg = image.NewRGBA(bounds)
+		draw.Draw(rgbaImg, bounds, img, bounds.Min, draw.Src)
+	}
+
+	bounds := img.Bounds()
+	totalRows := bounds.Max.Y - bounds.Min.Y
+	chunkSize := (totalRows + chunks - 1) / chunks
+	ownRows := make(map[int]image.Rectangle, chunks)
+
+	var grayImage *image.Gray
+	if options.wants(protocol.ArtifactGrayscale) || options.wants(protocol.ArtifactHistograms) || options.operation == protocol.OperationGrayscale {
+		grayImage = image.NewGray(bounds)
+	}
+	grayFunction := GrayscaleWrapper
+	if options.flatten || options.detectionTone != nil {
+		var illumination *utils.Illumination
+		if options.flatten {
+			illumination = utils.EstimateIllumination(rgbaImg)
+		}
+		grayFunction = func(img image.Image) (image.Image, error) {
+			gray := imageUtils.Grayscale(img)
+			if illumination != nil {
+				illumination.Flatten(gray)
+			}
+			if options.detectionTone != nil {
+				options.detectionTone.ApplyGray(gray)
+			}
+			return gray, nil
+		}
+	} else if grayImage == nil {
+		grayFunction = func(img image.Image) (image.Image, error) {
+			start := time.Now()
+			blurred := options.canny.SmoothRGBA(img.(*image.RGBA))
+			options.timings.addCanny(utils.CannyTimings{Blur: time.Since(start)})
+			return blurred, nil
+		}
+	}
+
+	for i := 0; i < chunks; i++ {
+		startY := bounds.Min.Y + i*chunkSize
+		endY := startY + chunkSize + overlapSize
+		owned := image.Rect(bounds.Min.X, startY, bounds.Max.X, startY+chunkSize)
+
+		if startY > overlapSize {
+			startY -= overlapSize
+		}
+
+		if endY > bounds.Max.Y {
+			endY = bounds.Max.Y
+		}
+
+		subBounds := image.Rect(bounds.Min.X, startY, bounds.Max.X, endY)
+		ownRows[startY] = owned
+
+		subImage := rgbaImg.SubImage(subBounds).(*image.RGBA)
+
+		task := worker.Task[image.Image, image.Image]{
+			Conn:       conn,
+			Input:      subImage,
+			ResultChan: resultGrayChan,
+			Function:   grayFunction,
+		}
+		if err := workerChannels.imageChan.Send(task); err != nil {
+			return nil, errShuttingDown
+		}
+	}
+
+	resultCannyChan := make(chan worker.Task[image.Image, image.Image], 100)
+
+	// The chunks come smoothed already, unless their grayscale image is needed.
+	smooth := func(img image.Image) *utils.FloatImage {
+		if blurred, ok := img.(*utils.FloatImage); ok {
+			return blurred
+		}
+		start := time.Now()
+		blurred := options.canny.Smooth(img.(*image.Gray))
+		options.timings.addCanny(utils.CannyTimings{Blur: time.Since(start)})
+		return blurred
+	}
+	cannyFunction := func(img image.Image) (image.Image, error) {
+		edges, cannyTimings := utils.DetectEdgesSmoothed(smooth(img), options.canny)
+		options.timings.addCanny(cannyTimings)
+		return edges, nil
+	}
+	if server.config.GlobalThresholds {
+		cannyFunction = func(img image.Image) (image.Image, error) {
+			blurred := smooth(img)
+			start := time.Now()
+			smoothed := utils.MeasureGradient(blurred, ownRows[blurred.Rect.Min.Y])
+			options.timings.addCanny(utils.CannyTimings{Sobel: time.Since(start)})
+			return smoothed, nil
+		}
+	}
+
+	for i := 0; i < chunks; i++ {
+		select {
+		case result := <-resultGrayChan:
+			if result.Err != nil {
+				server.logger.Printf("Error processing image for %s: %v", remoteAddr(conn), result.Err)
+				return nil, result.Err
+			}
+			if grayImage != nil {
+				chunkBounds := result.Output.Bounds()
+				draw.Draw(grayImage, chunkBounds, result.Output, chunkBounds.Min, draw.Src)
+			}
+			task := worker.Task[image.Image, image.Image]{
+				Conn:       conn,
+				Input:      result.Output,
+				ResultChan: resultCannyChan,
+				Function:   cannyFunction,
+			}
+			if err := workerChannels.imageChan.Send(task); err != nil {
+				return nil, errShuttingDown
+			}
+		case <-server.stopCtx.Done():
+			server.logger.Println("Server is shutting down, closing connection.")
+			return nil, errShuttingDown
+		}
+	}
+	close(resultGrayChan)
+	options.timings.since(protocol.TimingGrayscale, stageStart)
+	options.report(protocol.StageGrayscale)
+	if options.wants(protocol.ArtifactGrayscale) {
+		options.emit(protocol.ArtifactGrayscale, grayImage)
+	}
+	if options.wants(protocol.ArtifactHistograms) {
+		options.emit(protocol.ArtifactHistograms, utils.RenderHistograms(grayImage, options.canny))
+	}
+	if options.operation == protocol.OperationGrayscale {
+		return grayImage, nil
+	}
+
+	results := make([]*utils.EdgeMap, chunks)
+	smoothed := make([]*utils.SmoothedImage, 0, chunks)
+
+	for i := 0; i < chunks; i++ {
+		select {
+		case result := <-resultCannyChan:
+			if result.Err != nil {
+				server.logger.Printf("Error processing image for %s: %v", remoteAddr(conn), result.Err)
+				return nil, result.Err
+			}
+			if chunk, ok := result.Output.(*utils.SmoothedImage); ok {
+				smoothed = append(smoothed, chunk)
+				continue
+			}
+			results[i] = result.Output.(*utils.EdgeMap)
+		case <-server.stopCtx.Done():
+			server.logger.Println("Server is shutting down, closing connection.")
+			return nil, errShuttingDown
+		}
+	}
+
+	if len(smoothed) > 0 {
+		var gradient utils.GradientStats
+		for _, chunk := range smoothed {
+			gradient = gradient.Add(chunk.Gradient)
+		}
+		lowThreshold, highThreshold := gradient.Thresholds(options.canny.ThresholdScale)
+		detectFunction := func(img image.Image) (image.Image, error) {
+			edges, cannyTimings := utils.DetectEdges(img.(*utils.SmoothedImage), lowThreshold, highThreshold, options.canny)
+			options.timings.addCanny(cannyTimings)
+			return edges, nil
+		}
+		for _, chunk := range smoothed {
+			task := worker.Task[image.Image, image.Image]{
+				Conn:       conn,
+				Input:      chunk,
+				ResultChan: resultCannyChan,
+				Function:   detectFunction,
+			}
+			if err := workerChannels.imageChan.Send(task); err != nil {
+				return nil, errShuttingDown
+			}
+		}
+		for i := 0; i < chunks; i++ {
+			select {
+			case result := <-resultCannyChan:
+				if result.Err != nil {
+					server.logger.Printf("Error processing image for %s: %v", remoteAddr(conn), result.Err)
+					return nil, result.Err
+				}
+				results[i] = result.Output.(*utils.EdgeMap)
+			case <-server.stopCtx.Done():
+				server.logger.Println("Server is shutting down, closing connection.")
+				return nil, errShuttingDown
+			}
+		}
+	}
+	close(resultCannyChan)
+	options.report(protocol.StageEdges)
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Rect.Min.Y < results[j].Rect.Min.Y
+	})
+
+	cannyImage := image.NewGray(bounds)
+	var edgePixels []geometry.Point
+	for i, chunk := range results {
+		startY := bounds.Min.Y + i*chunkSize
+		chunkHeight := chunk.Rect.Dy() - overlapSize
+		draw.Draw(cannyImage, image.Rect(bounds.Min.X, startY, bounds.Max.X, startY+chunkHeight), chunk.Gray, image.Point{X: bounds.Min.X, Y: startY}, draw.Src)
+		edgePixels = append(edgePixels, chunkEdges(chunk.Edges, startY, startY+chunkSize)...)
+	}
+	if options.closing > 0 {
+		stageStart = time.Now()
+		cannyImage = utils.Close(cannyImage, options.closing)
+		options.timings.since(protocol.TimingMorphology, stageStart)
+	}
+	if options.wants(protocol.ArtifactEdges) {
+		options.emit(protocol.ArtifactEdges, cannyImage)
+	}
+	if options.operation == protocol.OperationEdges {
+		return cannyImage, nil
+	}
+	foreground := utils.ForegroundThreshold(cannyImage)
+	if options.closing > 0 {
+		edgePixels = utils.EdgePixels(cannyImage, bounds, foreground)
+	}
+
+	stageStart = time.Now()
+	resultBfsChan := make(chan worker.Task[image.Rectangle, []geometry.Contour], 100)
+
+	contourChunks, contourChunkSize := chunks, chunkSize
+	if server.config.ContourPass == ContourPassGlobal {
+		contourChunks, contourChunkSize = 1, totalRows
+	}
+	arenas := server.takeArenas(contourChunks)
+	arenasIdle := false
+	defer func() {
+		if arenasIdle {
+			server.releaseArenas(arenas)
+		}
+	}()
+	FindContoursBFSWrapper := func(rect image.Rectangle) ([]geometry.Contour, error) {
+		arena := arenas[(rect.Min.Y-bounds.Min.Y)/contourChunkSize]
+		return arena.FindContoursSeeded(cannyImage, rect, edgePixels, foreground), nil
+	}
+	for i := 0; i < contourChunks; i++ {
+		startY := bounds.Min.Y + i*contourChunkSize
+		endY := startY + contourChunkSize
+
+		if endY > bounds.Max.Y {
+			endY = bounds.Max.Y
+		}
+
+		rect := image.Rect(bounds.Min.X, startY, bounds.Max.X, endY)
+
+		task := worker.Task[image.Rectangle, []geometry.Contour]{
+			Conn:       conn,
+			Input:      rect,
+			ResultChan: resultBfsChan,
+			Function:   FindContoursBFSWrapper,
+		}
+		if err := workerChannels.bfsChan.Send(task); err != nil {
+			return nil, errShuttingDown
+		}
+	}
+
+	chunkContours := make([][]geometry.Contour, contourChunks)
+	for i := 0; i < contourChunks; i++ {
+		select {
+		case result := <-resultBfsChan:
+			if result.Err != nil {
+				server.logger.Printf("Error processing image for %s: %v", remoteAddr(conn), result.Err)
+				return nil, result.Err
+			}
+			chunkContours[(result.Input.Min.Y-bounds.Min.Y)/contourChunkSize] = result.Output
+		case <-server.stopCtx.Done():
+			server.logger.Println("Server is shutting down, closing connection.")
+			return nil, errShuttingDown
+		}
+	}
+	close(resultBfsChan)
+	bfsResult := slices.Concat(chunkContours...)
+	if contourChunks > 1 {
+		bfsResult = utils.MergeContours(bfsResult)
+	}
+	options.timings.since(protocol.TimingBFS, stageStart)
+
+	stageStart = time.Now()
+
+	ranking := utils.CandidateRanking{
+		Border: utils.BorderContact{Bounds: bounds, Margin: borderMargin, Weight: options.border},
+		Center: utils.CenterProximity{Bounds: bounds, Weight: options.centering},
+		Shape:  utils.PageShape{Ratio: options.shape, Weight: shapeWeight},
+	}
+	find := utils.FindQuadrilateral
+	if options.rotated || options.warp {
+		find = utils.FindLargestHull
+	}
+	RankCandidatesWrapper := func(contours []geometry.Contour) ([]utils.Candidate, error) {
+		return ranking.Rank(contours, find), nil
+	}
+	resultFindQuadrilateralChan := make(chan worker.Task[[]geometry.Contour, []utils.Candidate], 100)
+	for i := 0; i < chunks; i++ {
+		start := i * (len(bfsResult) / chunks)
+		end := (i + 1) * (len(bfsResult) / chunks)
+
+		if i == chunks-1 {
+			end = len(bfsResult)
+		}
+
+		task := worker.Task[[]geometry.Contour, []utils.Candidate]{
+			Conn:       conn,
+			Input:      bfsResult[start:end],
+			ResultChan: resultFindQuadrilateralChan,
+			Function:   RankCandidatesWrapper,
+		}
+		if err := workerChannels.findQuadrilateralChan.Send(task); err != nil {
+			return nil, errShuttingDown
+		}
+	}
+
+	chunkCandidates := make([][]utils.Candidate, 0, chunks)
+	for i := 0; i < chunks; i++ {
+		select {
+		case result := <-resultFindQuadrilateralChan:
+			if result.Err != nil {
+				server.logger.Printf("Error processing image for %s: %v", remoteAddr(conn), result.Err)
+				return nil, result.Err
+			}
+			chunkCandidates = append(chunkCandidates, result.Output)
+		case <-server.stopCtx.Done():
+			server.logger.Println("Server is shutting down, closing connection.")
+			return nil, errShuttingDown
+		}
+	}
+	close(resultFindQuadrilateralChan)
+	// No task reads the contours anymore: the arenas can be reused once the request is done with them.
+	arenasIdle = true
+	options.timings.since(protocol.TimingQuadrilateral, stageStart)
+
+	var lines geometry.ContourWithArea
+	if options.hough {
+		stageStart = time.Now()
+		lines = utils.FindHoughQuadrilateral(cannyImage)
+		options.timings.since(protocol.TimingHough, stageStart)
+	}
+	options.report(protocol.StageContours)
+
+	stageStart = time.Now()
+
+	candidates := slices.Concat(chunkCandidates...)
+	slices.SortStableFunc(candidates, utils.CompareCandidates)
+	if lines.Area > 0 {
+		candidates = slices.Insert(candidates, 0, utils.Candidate{
+			ContourWithArea: lines,
+			Score:           ranking.Score(lines),
+			Validation:      1,
+			CenterDistance:  ranking.Center.Distance(lines.Contour),
+		})
+	}
+	if options.size != nil {
+		candidates = preferSize(candidates, *options.size, options.tolerance)
+	}
+	var contourA4 geometry.ContourWithArea
+	if len(candidates) > 0 {
+		contourA4 = candidates[0].ContourWithArea
+	}
+	if options.ransac {
+		if sides := utils.FitQuadrilateralRANSAC(contourA4.Contour, bounds); sides.Area > 0 {
+			contourA4 = sides
+		}
+	}
+	if options.alternatives != nil && len(candidates) > 1 {
+		alternatives := candidates[1:min(len(candidates), maxAlternatives+1)]
+		utils.Validate(alternatives)
+		*options.alternatives = alternativeMetadata(alternatives, bounds)
+	}
+	options.debug.keepContours(bfsResult, contourA4)
+	if options.wants(protocol.ArtifactContours) {
+		overlay := utils.DrawContours(img, bfsResult, contourA4.Contour)
+		utils.AnnotateDocument(overlay, len(bfsResult), contourA4)
+		options.artifact(protocol.ArtifactContours, overlay)
+	}
+	var receipt utils.RotatedRect
+	if options.rotated {
+		receipt = utils.MinAreaRect(contourA4.Contour)
+	}
+	if options.document != nil {
+		quadrilateral := utils.FitQuadrilateral(contourA4.Contour)
+		if options.rotated {
+			quadrilateral = geometry.ContourWithArea{Contour: receipt.Corners(), Area: receipt.Width * receipt.Height}
+		}
+		*options.document = protocol.Document{
+			Width:   bounds.Dx(),
+			Height:  bounds.Dy(),
+			Corners: make([]protocol.Point, 0, len(quadrilateral.Contour)),
+			Area:    quadrilateral.Area,
+		}
+		for _, corner := range quadrilateral.Contour {
+			options.document.Corners = append(options.document.Corners, protocol.Point{X: corner.X - bounds.Min.X, Y: corner.Y - bounds.Min.Y})
+		}
+		if options.multiDocument != nil {
+			filter := *options.multiDocument
+			filter.Bounds = bounds
+			documents := utils.FindDocuments(candidates, filter)
+			options.document.Documents = alternativeMetadata(documents[:min(len(documents), maxDocuments)], bounds)
+		}
+	}
+	if options.operation == protocol.OperationCorners {
+		return nil, nil
+	}
+
+	var croppedImage *image.RGBA
+	switch {
+	case options.rotated:
+		width, height := int(math.Round(receipt.Width)), int(math.Round(receipt.Height))
+		if options.page == nil && options.paperWidth > 0 && width > 0 {
+			scaled := min(geometry.Pixels(options.paperWidth, options.dpi), maxPaperUpscale*width)
+			height = int(math.Round(float64(height) * float64(scaled) / float64(width)))
+			width = scaled
+			server.logger.Printf("Scaling result to %g mm at %d dpi (%dx%d)", options.paperWidth, options.dpi, width, height)
+		}
+		if err := server.checkCanvas(width, height); err != nil {
+			return nil, err
+		}
+		croppedImage = utils.CropRotatedRect(img, receipt, width, height)
+	case options.size != nil:
+		corners := utils.FitQuadrilateral(contourA4.Contour).Contour
+		if err := checkAspect(corners, *options.size, options.tolerance); err != nil {
+			return nil, err
+		}
+		width, height := utils.QuadrilateralSize(corners)
+		canvas := options.size.Canvas(options.dpi, width > height)
+		if err := server.checkCanvas(canvas.X, canvas.Y); err != nil {
+			return nil, err
+		}
+		croppedImage = utils.WarpToRectangle(img, corners, canvas.X, canvas.Y)
+	case options.warp:
+		corners := utils.FitQuadrilateral(contourA4.Contour).Contour
+		if err := server.checkCanvas(utils.QuadrilateralSize(corners)); err != nil {
+			return nil, err
+		}
+		croppedImage = utils.WarpQuadrilateral(img, corners)
+	default:
+		center := geometry.Point{
+			X: img.Bounds().Dx() / 2,
+			Y: img.Bounds().Dy() / 2,
+		}
+		contourA4.Contour = utils.FindCorner(contourA4.Contour, center)
+
+		rect := image.Rect(contourA4.Contour[0].X, contourA4.Contour[0].Y, contourA4.Contour[1].X, contourA4.Contour[1].Y)
+		if options.lossless != nil {
+			if cropped, err := options.lossless.crop(rect); err != nil {
+				server.logger.Printf("Cropping the document of %s by decoding it: %v", remoteAddr(conn), err)
+			} else {
+				rect = cropped
+			}
+		}
+		croppedImage = image.NewRGBA(rect)
+		draw.Draw(croppedImage, rect, img, rect.Min, draw.Src)
+	}
+
+	if options.colors != nil {
+		stats := imageUtils.ColorStats(croppedImage)
+		*options.colors = colorMetadata(stats)
+		gains, balance := stats.Cast.Gains, stats.NeedsWhiteBalance()
+		switch options.whiteBalance {
+		case protocol.WhiteBalanceWhitePatch:
+			balance = true
+		case protocol.WhiteBalanceGrayWorld:
+			gains, balance = stats.GrayWorldGains(), true
+		case protocol.WhiteBalanceOff:
+			balance = false
+		}
+		if balance {
+			imageUtils.WhiteBalance(croppedImage, gains)
+			options.colors.Balanced = true
+			server.logger.Printf("Corrected the %s cast (%.0f%%) of the document of %s with a %s white balance", cmp.Or(stats.Cast.Color, "no"),
+				100*stats.Cast.Strength, remoteAddr(conn), cmp.Or(options.whiteBalance, protocol.WhiteBalanceAuto))
+		}
+	}
+
+	options.timings.since(protocol.TimingCrop, stageStart)
+	if options.fillHoles {
+		stageStart = time.Now()
+		regions := imageUtils.FillPunchHoles(croppedImage)
+		server.logger.Printf("Filled %d punched holes and staples of the document of %s: %v", len(regions), remoteAddr(conn), regions)
+		options.timings.since(protocol.TimingEnhance, stageStart)
+	}
+	if options.deskew {
+		stageStart = time.Now()
+		var angle float64
+		croppedImage, angle = utils.Deskew(croppedImage)
+		server.logger.Printf("Straightened the document of %s slanted by %.2f°", remoteAddr(conn), angle)
+		options.timings.since(protocol.TimingDeskew, stageStart)
+	}
+	if options.enhance != nil {
+		stageStart = time.Now()
+		croppedImage = options.enhance(croppedImage)
+		options.timings.since(protocol.TimingEnhance, stageStart)
+	}
+	if options.tone != nil {
+		stageStart = time.Now()
+		croppedImage = options.tone.Apply(croppedImage)
+		options.timings.since(protocol.TimingEnhance, stageStart)
+	}
+
+	var finalImage image.Image = croppedImage
+	if options.page != nil {
+		stageStart = time.Now()
+		canvas := options.page.Canvas(options.dpi, croppedImage.Bounds().Dx() > croppedImage.Bounds().Dy())
+		server.logger.Printf("Scaling result to %s at %d dpi (%dx%d)", options.page.Name, options.dpi, canvas.X, canvas.Y)
+		if err := server.checkCanvas(canvas.X, canvas.Y); err != nil {
+			return nil, err
+		}
+		finalImage = imageUtils.Resize(croppedImage, canvas.X, canvas.Y, imageUtils.Bicubic)
+		options.timings.since(protocol.TimingCrop, stageStart)
+	}
+
+	if options.anonymize {
+		stageStart = time.Now()
+		var regions []image.Rectangle
+		finalImage, regions = anonymize.Apply(finalImage, server.detector)
+		server.logger.Printf("Anonymized %d photo regions of the document for %s: %v", len(regions), remoteAddr(conn), regions)
+		options.timings.since(protocol.TimingAnonymize, stageStart)
+	}
+	if options.rotation != nil {
+		stageStart = time.Now()
+		ctx, cancel := context.WithTimeout(server.stopCtx, ocrTimeout)
+		turns, orientedWords, err := ocr.Orient(ctx, server.recognizer.(ocr.WordRecognizer), finalImage)
+		cancel()
+		if err != nil {
+			if server.stopCtx.Err() != nil {
+				return nil, errShuttingDown
+			}
+			return nil, fmt.Errorf("recognizing the orientation: %w", err)
+		}
+		if turns != 0 {
+			finalImage = imageUtils.RotateQuarterTurns(finalImage, turns)
+			server.logger.Printf("Turned the document of %s by %d degrees to read its text", remoteAddr(conn), 90*turns)
+		}
+		*options.rotation = 90 * turns
+		if options.words != nil {
+			*options.words = orientedWords
+		}
+		options.timings.since(protocol.TimingOrient, stageStart)
+	}
+	if options.text != nil {
+		stageStart = time.Now()
+		ctx, cancel := context.WithTimeout(server.stopCtx, ocrTimeout)
+		var text string
+		var err error
+		switch {
+		case options.rotation != nil:
+			// The orientation already recognized the words of the upright document.
+			text = ocr.JoinWords(*options.words)
+		case options.words != nil:
+			*options.words, err = server.recognizer.(ocr.WordRecognizer).RecognizeWords(ctx, finalImage)
+			text = ocr.JoinWords(*options.words)
+		default:
+			text, err = server.recognizer.Recognize(ctx, finalImage)
+		}
+		cancel()
+		if err != nil {
+			if server.stopCtx.Err() != nil {
+				return nil, errShuttingDown
+			}
+			return nil, fmt.Errorf("recognizing the text: %w", err)
+		}
+		*options.text = text
+		server.logger.Printf("Recognized %d characters of text on the document of %s", len(text), remoteAddr(conn))
+		options.timings.since(protocol.TimingOCR, stageStart)
+	}
+	if options.gutter != nil {
+		stageStart = time.Now()
+		if gutter, ok := utils.FindGutter(finalImage); ok {
+			*options.gutter = gutter
+			server.logger.Printf("Splitting the spread of %s at its gutter, column %d", remoteAddr(conn), gutter)
+		}
+		options.timings.since(protocol.TimingCrop, stageStart)
+	}
+	if options.stamp != nil {
+		stageStart = time.Now()
+		stamp := *options.stamp
+		stamp.Text = watermark.Expand(stamp.Text, stageStart, options.requestID)
+		finalImage = watermark.Apply(finalImage, stamp)
+		options.timings.since(protocol.TimingStamp, stageStart)
+	}
+	options.report(protocol.StageCropping)
+
+	return finalImage, nil
+
+}
+
+func (server *Server) chunkCount(options requestOptions) int {
+	if options.deterministic || server.config.Deterministic {
+		return deterministicChunks
+	}
+	return server.numWorkers
+}
+
+func chunkEdges(edges []geometry.Point, startY, endY int) []geometry.Point {
+	compareY := func(edge geometry.Point, y int) int {
+		return cmp.Compare(edge.Y, y)
+	}
+	start, _ := slices.BinarySearchFunc(edges, startY, compareY)
+	end, _ := slices.BinarySearchFunc(edges, endY, compareY)
+	return edges[start:max(start, end)]
+}
+
+func preferSize(candidates []utils.Candidate, size geometry.PageSize, tolerance float64) []utils.Candidate {
+	index := slices.IndexFunc(candidates, func(candidate utils.Candidate) bool {
+		return checkAspect(utils.FitQuadrilateral(candidate.Contour).Contour, size, tolerance) == nil
+	})
+	if index <= 0 {
+		return candidates
+	}
+	return slices.Concat(candidates[index:index+1], candidates[:index], candidates[index+1:])
+}
+
+func alternativeMetadata(candidates []utils.Candidate, bounds image.Rectangle) []protocol.Candidate {
+	alternatives := make([]protocol.Candidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		alternative := protocol.Candidate{
+			Area:           candidate.Area,
+			Score:          candidate.Score,
+			Validation:     candidate.Validation,
+			CenterDistance: candidate.CenterDistance,
+		}
+		corners := utils.FitQuadrilateral(candidate.Contour).Contour
+		alternative.Aspect = utils.AspectScore(corners)
+		for _, corner := range corners {
+			alternative.Corners = append(alternative.Corners, protocol.Point{X: corner.X - bounds.Min.X, Y: corner.Y - bounds.Min.Y})
+		}
+		alternatives = append(alternatives, alternative)
+	}
+	return alternatives
+}
+
+func checkAspect(corners geometry.Contour, size geometry.PageSize, tolerance float64) error {
+	width, height := utils.QuadrilateralSize(corners)
+	if width == 0 || height == 0 {
+		return protocol.ErrorMessage{Code: protocol.CodeNotFound, Message: "no " + size.Name + " document found"}
+	}
+
+	ratio := float64(max(width, height)) / float64(min(width, height))
+	expected := size.Ratio()
+	if math.Abs(ratio/expected-1) > tolerance {
+		return protocol.ErrorMessage{
+			Code:    protocol.CodeNotFound,
+			Message: fmt.Sprintf("no %s document found: the document found is %.2f:1, not %.2f:1", size.Name, ratio, expected),
+		}
+	}
+	return nil
+}
+
+func (server *Server) takeArenas(count int) []*utils.ContourArena {
+	arenas := make([]*utils.ContourArena, count)
+	for i := range arenas {
+		arena, ok := server.arenas.Get().(*utils.ContourArena)
+		if !ok {
+			arena = utils.NewContourArena()
+		}
+		arenas[i] = arena
+	}
+	return arenas
+}
+
+func (server *Server) releaseArenas(arenas []*utils.ContourArena) {
+	for _, arena := range arenas {
+		arena.Reset()
+		server.arenas.Put(arena)
+	}
+}
+
+func ApplyCannyEdgeDetectionWrapper(img image.Image) (image.Image, error) {
+	return utils.ApplyCannyEdgeDetection(img.(*image.Gray)), nil
+}
+
+func GrayscaleWrapper(img image.Image) (image.Image, error) {
+	return imageUtils.Grayscale(img), nil
+}
+
+func (server *Server) Start(ctx context.Context) error {
+	listener := server.config.Listener
+	if listener == nil {
+		var err error
+		if listener, err = server.listen(); err != nil {
+			return err
+		}
+	}
+	server.listener = listener
+
+	imageChan := worker.NewTaskChannel[image.Image, image.Image](server.workers, imagePool, 100)
+	bfsChan := worker.NewTaskChannel[image.Rectangle, []geometry.Contour](server.workers, contourPool, 100)
+	findQuadrilateralChan := worker.NewTaskChannel[[]geometry.Contour, []utils.Candidate](server.workers, rankingPool, 100)
+
+	server.channels = workerChannels{
+		socketSemaphore:       make(chan net.Conn, 5),
+		imageChan:             imageChan,
+		bfsChan:               bfsChan,
+		findQuadrilateralChan: findQuadrilateralChan,
+	}
+
+	go server.jobs.Run(server.stopCtx)
+
+	server.pools = []resizablePool{
+		worker.NewTrackedPool(imagePool, worker.TreatmentWorker, imageChan),
+		worker.NewTrackedPool(contourPool, worker.TreatmentWorker, bfsChan),
+		worker.NewTrackedPool(rankingPool, worker.TreatmentWorker, findQuadrilateralChan),
+	}
+	for _, pool := range server.pools {
+		pool.Resize(server.numWorkers)
+	}
+
+	if server.config.AdminAddress != "" {
+		adminListener, err := server.listenAdmin()
+		if err != nil {
+			server.logger.Printf("Admin interface stopped: %v", err)
+		} else {
+			server.adminListener = adminListener
+			go server.serveAdmin(adminListener)
+		}
+	}
+
+	server.resumeJobs(server.channels)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-server.stopCtx.Done():
+		}
+		server.logger.Println("Shutting down server...")
+		server.cancel()
+		if err := listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			server.logger.Printf("Error closing listener: %v", err)
+		}
+	}()
+
+	go server.serve(listener)
+
+	if server.inherited != nil {
+		go server.takeOver()
+	}
+
+	return nil
+}
+
+func (server *Server) serve(listener net.Listener) {
+	defer close(server.done)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if server.stopCtx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				if server.stopCtx.Err() == nil && server.handedOver.Load() {
+					server.logger.Println("Listener handed over, waiting for the work in progress.")
+					server.drain()
+					<-server.stopCtx.Done()
+				}
+				server.logger.Println("Listener has been closed. Stopping server gracefully.")
+				return
+			}
+			server.logger.Printf("Error accepting connection: %v", err)
+			continue
+		}
+
+		select {
+		case <-server.stopCtx.Done():
+			server.logger.Println("Server is shutting down, closing new connection.")
+			conn.Close()
+		default:
+			if server.draining.Load() {
+				server.logger.Printf("Server is draining, refusing connection from %s", conn.RemoteAddr())
+				conn.Close()
+				continue
+			}
+			server.active.Add(1)
+			server.stats.handling.Add(1)
+			go func() {
+				defer server.active.Done()
+				defer server.stats.handling.Add(-1)
+				server.handleConnection(conn, server.channels)
+			}()
+		}
+	}
+}
+
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.cancel()
+	<-server.done
+
+	server.connectionsMutex.Lock()
+	for conn := range server.connections {
+		conn.SetReadDeadline(time.Now())
+	}
+	server.connectionsMutex.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		server.active.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	server.stopWorkers.Do(func() {
+		server.channels.imageChan.Close()
+		server.channels.bfsChan.Close()
+		server.channels.findQuadrilateralChan.Close()
+		server.logger.Println("All workers will stop after completing their tasks.")
+	})
+	return nil
+}
+
+func (server *Server) Done() <-chan struct{} {
+	return server.done
+}
+
+func (server *Server) Addr() net.Addr {
+	return server.listener.Addr()
+}
+
+func (server *Server) WaitWorkers(ctx context.Context) error {
+	return server.workers.WaitShutdown(ctx)
+}
+
+func (server *Server) AdminAddr() net.Addr {
+	if server.adminListener == nil {
+		return nil
+	}
+	return server.adminListener.Addr()
+}
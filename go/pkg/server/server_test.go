@@ -0,0 +1,2297 @@
+package server_test
+
+/*
+This file implements the end-to-end tests of the server: every test embeds a complete server in the process, listening
+on an ephemeral port of the loopback interface with a temporary job directory, and runs the client library
+(`internal/client`) against it with synthetic images. The tests assert on the images and the metadata returned by the
+server, so a change of the protocol, the connection loop or the processing pipeline is caught by `go test`.
+
+---
+
+### `startServer(t *testing.T, config serverlib.Config) string`
+Starts a server with `config`, on an ephemeral port and with a temporary job directory unless `config` gives one, and
+returns its address. The connections of the tests all come from the loopback host: they are not limited per host
+unless `config` sets another `MaxConnectionsPerHost` than the default one. The server is shut down when the test
+ends, and the test fails if the shutdown is not clean: a request still in progress, a worker still running, or a task
+sent to a closed channel (see `worker.Tracker`).
+
+### `startTestServer(t *testing.T, config serverlib.Config) *serverlib.Server`
+Same as `startServer`, but returns the server itself, for the tests reaching its admin interface or waiting for it
+to stop.
+
+### `syntheticDocument(width, height int, angle float64) image.Image`
+Draws a light, slightly rotated page covered with text lines on a dark background, which the pipeline must detect
+and straighten.
+
+### `syntheticReceipt(width, height int, angle float64) image.Image`
+Draws a narrow strip of paper of `width` x `height` pixels, rotated by `angle`, with rows of print, on a dark
+background.
+
+### `syntheticBoard(corners [4]image.Point) image.Image`
+Draws a white board photographed at an angle, the quadrilateral `corners` (top-left, top-right, bottom-right,
+bottom-left), with strokes parallel to its top side, on a gray wall.
+
+### `encode(t *testing.T, img image.Image, format string) []byte`
+Encodes an image in the given format (`jpeg` or `png`).
+
+### `request(t *testing.T, address string, codec protocol.Codec, header protocol.Header, data []byte) (clientlib.Response, error)`
+Sends a single request on a new connection and returns its response.
+
+### `checkResult(t *testing.T, response clientlib.Response, format string) image.Image`
+Asserts that a response carries a decodable image of the expected format and the statistics of the request.
+
+### `expectErrorCode(t *testing.T, err error, code string)`
+Asserts that a request failed with an error frame carrying `code`.
+
+### `waitJob(t *testing.T, address string, jobID string) clientlib.Response`
+Queries a job until it is finished and returns the response of the last query, its result if the job is done.
+
+### `adminRequest(t *testing.T, server *serverlib.Server, method, path string, response any) int`
+Sends a request to the admin interface of `server`, decodes its JSON body into `response` and returns its status.
+
+### `fakeRecognizer`
+Text recognizer returning the size of the image it is given, so a test can check that it reads the cropped document,
+and a single word covering the image when it is asked for the words.
+
+### `panickingRecognizer`
+Text recognizer panicking on every image, standing for a bug of the pipeline triggered by a request.
+*/
+
+import (
+	clientlib "ELP-project/internal/client"
+	"ELP-project/internal/geometry"
+	"ELP-project/internal/imageUtils"
+	"ELP-project/internal/jobs"
+	"ELP-project/internal/netUtils"
+	"ELP-project/internal/ocr"
+	"ELP-project/internal/protocol"
+	"ELP-project/internal/storage"
+	"ELP-project/internal/utils"
+	serverlib "ELP-project/pkg/server"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testTimeout    = 30 * time.Second
+	testPipelined  = 4
+	testPollPeriod = 50 * time.Millisecond
+)
+
+var codecs = map[string]protocol.Codec{"json": protocol.JSON, "protobuf": protocol.Protobuf}
+
+func startServer(t *testing.T, config serverlib.Config) string {
+	t.Helper()
+	return startTestServer(t, config).Addr().String()
+}
+
+func startTestServer(t *testing.T, config serverlib.Config) *serverlib.Server {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening on an ephemeral port: %v", err)
+	}
+	config.Listener = listener
+	config.Logger = log.New(io.Discard, "", 0)
+	if config.MaxConnectionsPerHost == serverlib.DefaultConfig().MaxConnectionsPerHost {
+		config.MaxConnectionsPerHost = 0
+	}
+	if config.JobsDir == serverlib.DefaultConfig().JobsDir {
+		config.JobsDir = t.TempDir()
+	}
+
+	server, err := serverlib.New(config)
+	if err != nil {
+		listener.Close()
+		t.Fatalf("error creating server: %v", err)
+	}
+	if err := server.Start(context.Background()); err != nil {
+		listener.Close()
+		t.Fatalf("error starting server: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			t.Errorf("error shutting down the server: %v", err)
+		}
+		if err := server.WaitWorkers(ctx); err != nil {
+			t.Errorf("unclean shutdown of the workers: %v", err)
+		}
+	})
+
+	return server
+}
+
+func syntheticDocument(width, height int, angle float64) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	centerX, centerY := float64(width)/2, float64(height)/2
+	halfWidth, halfHeight := float64(width)*0.31, float64(height)*0.35
+
+	background := color.RGBA{R: 60, G: 50, B: 40, A: 255}
+	paper := color.RGBA{R: 235, G: 230, B: 220, A: 255}
+	ink := color.RGBA{R: 20, G: 20, B: 20, A: 255}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dx, dy := float64(x)-centerX, float64(y)-centerY
+			rotatedX := dx*math.Cos(angle) + dy*math.Sin(angle)
+			rotatedY := -dx*math.Sin(angle) + dy*math.Cos(angle)
+
+			pixel := background
+			if math.Abs(rotatedX) < halfWidth && math.Abs(rotatedY) < halfHeight {
+				pixel = paper
+				line := int(rotatedY+halfHeight) % 40
+				if line < 4 && math.Abs(rotatedX) < halfWidth*0.8 {
+					pixel = ink
+				}
+			}
+			img.SetRGBA(x, y, pixel)
+		}
+	}
+
+	return img
+}
+
+func syntheticSpread(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	centerX, centerY := float64(width)/2, float64(height)/2
+	halfWidth, halfHeight := float64(width)*0.42, float64(height)*0.3
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dx, dy := float64(x)-centerX, float64(y)-centerY
+			pixel := color.RGBA{R: 60, G: 50, B: 40, A: 255}
+			if math.Abs(dx) < halfWidth && math.Abs(dy) < halfHeight {
+				// Text lines on both pages, a figure on the left one only, and the shadow of the binding.
+				value := 235.0
+				column := math.Abs(dx) / halfWidth
+				if int(dy+halfHeight)%30 < 3 && column > 0.15 && column < 0.9 {
+					value = 20
+				}
+				if dx < -0.6*halfWidth && dx > -0.8*halfWidth && math.Abs(dy) < 0.3*halfHeight {
+					value = 20
+				}
+				if math.Abs(dx) < 40 {
+					value *= 1 - 0.45*(1-math.Abs(dx)/40)
+				}
+				pixel = color.RGBA{R: uint8(value), G: uint8(value), B: uint8(value), A: 255}
+			}
+			img.SetRGBA(x, y, pixel)
+		}
+	}
+
+	return img
+}
+
+func syntheticReceipt(width, height int, angle float64) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 3*height/4, 5*height/4))
+	bounds := img.Bounds()
+	centerX, centerY := float64(bounds.Dx())/2, float64(bounds.Dy())/2
+	halfWidth, halfHeight := float64(width)/2, float64(height)/2
+
+	background := color.RGBA{R: 50, G: 45, B: 40, A: 255}
+	paper := color.RGBA{R: 238, G: 236, B: 230, A: 255}
+	ink := color.RGBA{R: 60, G: 60, B: 60, A: 255}
+
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			dx, dy := float64(x)-centerX, float64(y)-centerY
+			u := dx*math.Cos(angle) + dy*math.Sin(angle)
+			v := -dx*math.Sin(angle) + dy*math.Cos(angle)
+
+			pixel := background
+			if math.Abs(u) < halfWidth && math.Abs(v) < halfHeight {
+				pixel = paper
+				if int(v+halfHeight)%24 < 6 && math.Abs(u) < halfWidth*0.7 && math.Abs(v) < halfHeight*0.9 {
+					pixel = ink
+				}
+			}
+			img.SetRGBA(x, y, pixel)
+		}
+	}
+
+	return img
+}
+
+func syntheticBoard(corners [4]image.Point) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 750))
+	wall := color.RGBA{R: 120, G: 115, B: 110, A: 255}
+	board := color.RGBA{R: 245, G: 245, B: 245, A: 255}
+	stroke := color.RGBA{R: 20, G: 40, B: 200, A: 255}
+
+	point := func(point image.Point) (float64, float64) { return float64(point.X), float64(point.Y) }
+	topLeftX, topLeftY := point(corners[0])
+	topRightX, topRightY := point(corners[1])
+	bottomRightX, bottomRightY := point(corners[2])
+	bottomLeftX, bottomLeftY := point(corners[3])
+
+	// Inverse of the bilinear mapping of the unit square onto the quadrilateral, by Newton's method: the board is
+	// drawn in its own coordinates (u, v), so its strokes follow its perspective.
+	boardCoordinates := func(x, y float64) (float64, float64) {
+		u, v := 0.5, 0.5
+		for range 8 {
+			pointX := (1-u)*(1-v)*topLeftX + u*(1-v)*topRightX + u*v*bottomRightX + (1-u)*v*bottomLeftX
+			pointY := (1-u)*(1-v)*topLeftY + u*(1-v)*topRightY + u*v*bottomRightY + (1-u)*v*bottomLeftY
+			dxu := (1-v)*(topRightX-topLeftX) + v*(bottomRightX-bottomLeftX)
+			dyu := (1-v)*(topRightY-topLeftY) + v*(bottomRightY-bottomLeftY)
+			dxv := (1-u)*(bottomLeftX-topLeftX) + u*(bottomRightX-topRightX)
+			dyv := (1-u)*(bottomLeftY-topLeftY) + u*(bottomRightY-topRightY)
+			determinant := dxu*dyv - dxv*dyu
+			errorX, errorY := x-pointX, y-pointY
+			u += (errorX*dyv - errorY*dxv) / determinant
+			v += (errorY*dxu - errorX*dyu) / determinant
+		}
+		return u, v
+	}
+
+	for y := 0; y < 750; y++ {
+		for x := 0; x < 1000; x++ {
+			u, v := boardCoordinates(float64(x), float64(y))
+			pixel := wall
+			if u >= 0 && u <= 1 && v >= 0 && v <= 1 {
+				pixel = board
+				if u > 0.1 && u < 0.9 && v > 0.1 && v < 0.9 && math.Mod(v*10, 1.5) < 0.08 {
+					pixel = stroke
+				}
+			}
+			img.SetRGBA(x, y, pixel)
+		}
+	}
+
+	return img
+}
+
+func encode(t *testing.T, img image.Image, format string) []byte {
+	t.Helper()
+
+	var buffer bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buffer, img)
+	default:
+		err = jpeg.Encode(&buffer, img, &jpeg.Options{Quality: 90})
+	}
+	if err != nil {
+		t.Fatalf("error encoding the synthetic image: %v", err)
+	}
+	return buffer.Bytes()
+}
+
+func request(t *testing.T, address string, codec protocol.Codec, header protocol.Header, data []byte) (clientlib.Response, error) {
+	t.Helper()
+
+	client, err := clientlib.DialCodec("tcp", address, netUtils.DefaultSocketOptions(), codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if data == nil {
+		return client.Do(header, nil, 0)
+	}
+	return client.Do(header, bytes.NewReader(data), int64(len(data)))
+}
+
+func checkResult(t *testing.T, response clientlib.Response, format string) image.Image {
+	t.Helper()
+
+	if response.Metadata.Format != format {
+		t.Fatalf("result format %q, expected %q", response.Metadata.Format, format)
+	}
+
+	img, decodedFormat, err := image.Decode(bytes.NewReader(response.Data))
+	if err != nil {
+		t.Fatalf("result not decodable: %v", err)
+	}
+	if decodedFormat != format {
+		t.Fatalf("result encoded as %q, expected %q", decodedFormat, format)
+	}
+
+	stats := response.Metadata.Stats
+	if stats == nil {
+		t.Fatal("no transfer statistics in the response")
+	}
+	if stats.BytesReceived <= 0 || stats.BytesSent != int64(len(response.Data)) {
+		t.Fatalf("inconsistent transfer statistics: %+v for a result of %d bytes", *stats, len(response.Data))
+	}
+
+	return img
+}
+
+func expectErrorCode(t *testing.T, err error, code string) {
+	t.Helper()
+
+	var errorMessage protocol.ErrorMessage
+	if !errors.As(err, &errorMessage) {
+		t.Fatalf("expected a %q error, got %v", code, err)
+	}
+	if errorMessage.Code != code {
+		t.Fatalf("expected a %q error, got %q: %s", code, errorMessage.Code, errorMessage.Message)
+	}
+}
+
+func waitJob(t *testing.T, address string, jobID string) clientlib.Response {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for time.Now().Before(deadline) {
+		response, err := request(t, address, protocol.Protobuf, protocol.Header{JobID: jobID}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch response.Metadata.Status {
+		case protocol.StatusDone, protocol.StatusFailed:
+			return response
+		}
+		time.Sleep(testPollPeriod)
+	}
+	t.Fatalf("job %s not done after %v", jobID, testTimeout)
+	return clientlib.Response{}
+}
+
+func adminRequest(t *testing.T, server *serverlib.Server, method, path string, response any) int {
+	t.Helper()
+
+	httpRequest, err := http.NewRequest(method, "http://"+server.AdminAddr().String()+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpResponse, err := http.DefaultClient.Do(httpRequest)
+	if err != nil {
+		t.Fatalf("error querying %s %s: %v", method, path, err)
+	}
+	defer httpResponse.Body.Close()
+	if err := json.NewDecoder(httpResponse.Body).Decode(response); err != nil {
+		t.Fatalf("malformed answer to %s %s: %v", method, path, err)
+	}
+	return httpResponse.StatusCode
+}
+
+func TestFormats(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+
+	for name, codec := range codecs {
+		for _, format := range []string{"jpeg", "png"} {
+			t.Run(name+"/"+format, func(t *testing.T) {
+				data := encode(t, syntheticDocument(800, 1000, 0.08), format)
+
+				response, err := request(t, address, codec, protocol.Header{}, data)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				img := checkResult(t, response, format)
+				if bounds := img.Bounds(); bounds.Dx() >= 800 || bounds.Dy() >= 1000 {
+					t.Fatalf("result of %dx%d pixels is not cropped to the document", bounds.Dx(), bounds.Dy())
+				}
+			})
+		}
+	}
+}
+
+func TestAccept(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+	data := encode(t, syntheticDocument(800, 1000, 0.08), "jpeg")
+
+	response, err := request(t, address, protocol.Protobuf, protocol.Header{Accept: []string{"webp", "PNG"}}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResult(t, response, "png")
+
+	response, err = request(t, address, protocol.Protobuf, protocol.Header{Format: "jpeg", Accept: []string{"png"}}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResult(t, response, "jpeg")
+
+	_, err = request(t, address, protocol.Protobuf, protocol.Header{Accept: []string{"webp", "avif"}}, data)
+	expectErrorCode(t, err, protocol.CodeBadRequest)
+
+	_, err = request(t, address, protocol.Protobuf, protocol.Header{Operation: protocol.OperationCorners, Accept: []string{"png"}}, data)
+	expectErrorCode(t, err, protocol.CodeBadRequest)
+}
+
+func TestPageSize(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+	data := encode(t, syntheticDocument(800, 1000, 0.08), "png")
+
+	response, err := request(t, address, protocol.Protobuf, protocol.Header{PageSize: "A5", DPI: 100}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := checkResult(t, response, "png")
+
+	page, err := geometry.ParsePageSize("A5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := img.Bounds().Size()
+	if size != page.Canvas(100, false) && size != page.Canvas(100, true) {
+		t.Fatalf("result of %v pixels, expected %v", size, page.Canvas(100, false))
+	}
+}
+
+func TestBadPageSize(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+	data := encode(t, syntheticDocument(800, 1000, 0.08), "jpeg")
+
+	for _, pageSize := range []string{"napkin", "210x297mm", "210x", "0x297", "210x297x3"} {
+		t.Run(pageSize, func(t *testing.T) {
+			_, err := request(t, address, protocol.Protobuf, protocol.Header{PageSize: pageSize}, data)
+			expectErrorCode(t, err, protocol.CodeBadRequest)
+		})
+	}
+}
+
+func TestCanvasLimits(t *testing.T) {
+	config := serverlib.DefaultConfig()
+	config.MaxDimension = 1000
+	address := startServer(t, config)
+	data := encode(t, syntheticDocument(800, 1000, 0.08), "jpeg")
+
+	_, err := request(t, address, protocol.Protobuf, protocol.Header{PageSize: "A4", DPI: 300}, data)
+	expectErrorCode(t, err, protocol.CodeTooLarge)
+}
+
+func TestPipelined(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+	data := encode(t, syntheticDocument(800, 1000, 0.08), "jpeg")
+
+	for name, codec := range codecs {
+		t.Run(name, func(t *testing.T) {
+			client, err := clientlib.DialCodec("tcp", address, netUtils.DefaultSocketOptions(), codec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			var mutex sync.Mutex
+			responses := make(map[uint32]clientlib.Response)
+			for i := 0; i < testPipelined; i++ {
+				header := protocol.Header{DPI: 100 + i}
+				err := client.Submit(header, bytes.NewReader(data), int64(len(data)), func(response clientlib.Response) {
+					mutex.Lock()
+					responses[response.RequestID] = response
+					mutex.Unlock()
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			client.Wait()
+
+			if len(responses) != testPipelined {
+				t.Fatalf("%d responses for %d requests", len(responses), testPipelined)
+			}
+			for requestID, response := range responses {
+				if response.Err != nil {
+					t.Fatalf("request %d: %v", requestID, response.Err)
+				}
+				checkResult(t, response, "jpeg")
+			}
+		})
+	}
+}
+
+func TestPendingHeaders(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(testTimeout))
+
+	writer := bufio.NewWriter(conn)
+	if err := protocol.WriteMagic(writer, protocol.Protobuf); err != nil {
+		t.Fatal(err)
+	}
+	// Headers without their image: the server holds them until the image comes, up to a limit.
+	const requests = 9
+	for requestID := uint32(1); requestID <= requests; requestID++ {
+		if err := protocol.WriteHeader(writer, protocol.Protobuf, requestID, protocol.Header{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(conn)
+	frameType, requestID, length, err := protocol.ReadFrameHeader(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frameType != protocol.FrameError || requestID != requests {
+		t.Fatalf("%s frame for request %d, expected an error for request %d", frameType, requestID, requests)
+	}
+	payload, err := protocol.ReadPayload(reader, length, protocol.MaxControlFrameSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errorMessage := protocol.DecodeError(protocol.Protobuf, payload); errorMessage.Code != protocol.CodeBadRequest {
+		t.Fatalf("error %q, expected %q", errorMessage.Code, protocol.CodeBadRequest)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	config := serverlib.DefaultConfig()
+	config.AuthFile = filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(config.AuthFile, []byte("# name key\ntester 3f0c1a9b\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	address := startServer(t, config)
+
+	for _, test := range []struct {
+		name, token, code string
+	}{{"valid", "3f0c1a9b", ""}, {"wrong", "3f0c1a9c", protocol.CodeUnauthorized}, {"missing", "", protocol.CodeUnauthorized}} {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := clientlib.DialCodec("tcp", address, netUtils.DefaultSocketOptions(), protocol.Protobuf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			if test.token != "" {
+				if err := client.Authenticate(test.token); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err = client.Do(protocol.Header{JobID: "missing"}, nil, 0)
+			if test.code == "" {
+				expectErrorCode(t, err, protocol.CodeNotFound)
+			} else {
+				expectErrorCode(t, err, test.code)
+			}
+		})
+	}
+
+	// A connection sending nothing counts against the quota of its host before it authenticates.
+	config.MaxConnectionsPerHost = 1
+	address = startServer(t, config)
+	idle, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		client, err := clientlib.DialCodec("tcp", address, netUtils.DefaultSocketOptions(), protocol.Protobuf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Authenticate("3f0c1a9b")
+		_, err = client.Do(protocol.Header{JobID: "missing"}, nil, 0)
+		client.Close()
+		var errorMessage protocol.ErrorMessage
+		if errors.As(err, &errorMessage) && errorMessage.Code == protocol.CodeBusy {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("second connection of the host answered with %v, expected a %q error", err, protocol.CodeBusy)
+		}
+		time.Sleep(testPollPeriod)
+	}
+}
+
+func TestLegacyProtocol(t *testing.T) {
+	data := encode(t, syntheticDocument(800, 1000, 0.08), "jpeg")
+	legacyRequest := func(t *testing.T, address string) []byte {
+		t.Helper()
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(testTimeout))
+
+		// No magic nor frames: the raw image, its end marker, and the raw result until the server closes.
+		if _, err := conn.Write(append(slices.Clone(data), protocol.LegacyEndMarker...)); err != nil {
+			t.Fatal(err)
+		}
+		result, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	result := legacyRequest(t, startServer(t, serverlib.DefaultConfig()))
+	img, format, err := image.Decode(bytes.NewReader(result))
+	if err != nil {
+		t.Fatalf("legacy result of %d bytes not decodable: %v", len(result), err)
+	}
+	if bounds := img.Bounds(); format != "jpeg" || bounds.Dx() >= 800 || bounds.Dy() >= 1000 {
+		t.Fatalf("legacy result is a %s of %v, expected the cropped page in jpeg", format, bounds)
+	}
+
+	config := serverlib.DefaultConfig()
+	config.LegacyProtocol = false
+	if result := legacyRequest(t, startServer(t, config)); len(result) != 0 {
+		t.Fatalf("%d bytes answered to a legacy client with the legacy protocol disabled", len(result))
+	}
+}
+
+func TestConnectionQuotas(t *testing.T) {
+	query := func(t *testing.T, client *clientlib.Client) {
+		t.Helper()
+		_, err := client.Do(protocol.Header{JobID: "missing"}, nil, 0)
+		expectErrorCode(t, err, protocol.CodeNotFound)
+	}
+	dial := func(t *testing.T, address string) *clientlib.Client {
+		t.Helper()
+		client, err := clientlib.DialCodec("tcp", address, netUtils.DefaultSocketOptions(), protocol.Protobuf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+
+	t.Run("per-host", func(t *testing.T) {
+		config := serverlib.DefaultConfig()
+		config.MaxConnectionsPerHost = 1
+		address := startServer(t, config)
+
+		query(t, dial(t, address))
+		_, err := request(t, address, protocol.Protobuf, protocol.Header{JobID: "missing"}, nil)
+		expectErrorCode(t, err, protocol.CodeBusy)
+	})
+
+	t.Run("rate", func(t *testing.T) {
+		config := serverlib.DefaultConfig()
+		config.MaxConnectionsPerHost = 0
+		config.ConnectionRate = 0.001
+		config.ConnectionBurst = 2
+		address := startServer(t, config)
+
+		for i := 0; i < config.ConnectionBurst; i++ {
+			query(t, dial(t, address))
+		}
+		_, err := request(t, address, protocol.Protobuf, protocol.Header{JobID: "missing"}, nil)
+		expectErrorCode(t, err, protocol.CodeBusy)
+	})
+
+	t.Run("slots", func(t *testing.T) {
+		config := serverlib.DefaultConfig()
+		config.QueueTimeout = 100 * time.Millisecond
+		address := startServer(t, config)
+
+		// The server serves 5 connections at a time, the next one waits for a slot, then is told to retry later.
+		for i := 0; i < 5; i++ {
+			query(t, dial(t, address))
+		}
+		_, err := request(t, address, protocol.Protobuf, protocol.Header{JobID: "missing"}, nil)
+		expectErrorCode(t, err, protocol.CodeBusy)
+	})
+}
+
+func TestDuplicate(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+	data := encode(t, syntheticDocument(800, 1000, 0.08), "png")
+
+	first, err := request(t, address, protocol.Protobuf, protocol.Header{}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := request(t, address, protocol.Protobuf, protocol.Header{}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(first.Data, second.Data) {
+		t.Fatal("different results for the same image")
+	}
+}
+
+func TestAsyncJob(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+	data := encode(t, syntheticDocument(800, 1000, 0.08), "jpeg")
+
+	response, err := request(t, address, protocol.Protobuf, protocol.Header{Async: true}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobID := response.Metadata.JobID
+	if jobID == "" {
+		t.Fatal("no job ID in the response")
+	}
+	if response.Data != nil {
+		t.Fatal("asynchronous response carries an image")
+	}
+
+	done := waitJob(t, address, jobID)
+	if done.Metadata.Status != protocol.StatusDone {
+		t.Fatalf("job %s failed: %s", jobID, done.Metadata.Error)
+	}
+	if done.Metadata.Size != int64(len(done.Data)) {
+		t.Fatalf("job result of %d bytes, announced %d", len(done.Data), done.Metadata.Size)
+	}
+	if _, _, err := image.Decode(bytes.NewReader(done.Data)); err != nil {
+		t.Fatalf("job result not decodable: %v", err)
+	}
+
+	t.Run("resume", func(t *testing.T) {
+		offset := int64(len(done.Data) / 3)
+		resumed, err := request(t, address, protocol.Protobuf, protocol.Header{JobID: jobID, Offset: offset}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resumed.Metadata.Size != int64(len(done.Data)) {
+			t.Fatalf("resumed result announced as %d bytes, expected the %d of the whole result", resumed.Metadata.Size, len(done.Data))
+		}
+		if !bytes.Equal(resumed.Data, done.Data[offset:]) {
+			t.Fatalf("resumed result of %d bytes differs from the end of the result", len(resumed.Data))
+		}
+	})
+
+	t.Run("offset-beyond-result", func(t *testing.T) {
+		_, err := request(t, address, protocol.Protobuf, protocol.Header{JobID: jobID, Offset: int64(len(done.Data)) + 1}, nil)
+		expectErrorCode(t, err, protocol.CodeBadRequest)
+	})
+
+	t.Run("offset-without-job", func(t *testing.T) {
+		_, err := request(t, address, protocol.Protobuf, protocol.Header{Offset: 10}, data)
+		expectErrorCode(t, err, protocol.CodeBadRequest)
+	})
+}
+
+func TestResumeTransfer(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+	data := encode(t, syntheticDocument(800, 1000, 0.08), "jpeg")
+
+	response, err := request(t, address, protocol.Protobuf, protocol.Header{Async: true}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobID := response.Metadata.JobID
+	done := waitJob(t, address, jobID)
+	if done.Metadata.Status != protocol.StatusDone {
+		t.Fatalf("job %s failed: %s", jobID, done.Metadata.Error)
+	}
+
+	// A download cut in the middle of the image frame.
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(testTimeout))
+	writer := bufio.NewWriter(conn)
+	if err := protocol.WriteMagic(writer, protocol.Protobuf); err != nil {
+		t.Fatal(err)
+	}
+	if err := protocol.WriteHeader(writer, protocol.Protobuf, 1, protocol.Header{JobID: jobID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	var received []byte
+	for received == nil {
+		frameType, _, length, err := protocol.ReadFrameHeader(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload := make([]byte, length)
+		if frameType == protocol.FrameImage {
+			payload = payload[:length/2]
+		}
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			t.Fatal(err)
+		}
+		if frameType == protocol.FrameImage {
+			received = payload
+		}
+	}
+	conn.Close()
+	if !bytes.Equal(received, done.Data[:len(received)]) {
+		t.Fatal("interrupted download differs from the start of the result")
+	}
+
+	client, err := clientlib.DialCodec("tcp", address, netUtils.DefaultSocketOptions(), protocol.Protobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	resumed, err := client.QueryFrom(jobID, int64(len(received)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(append(received, resumed.Data...), done.Data) {
+		t.Fatalf("%d bytes received and %d resumed, expected the %d bytes of the result", len(received), len(resumed.Data), len(done.Data))
+	}
+
+	end, err := client.QueryFrom(jobID, int64(len(done.Data)))
+	if err != nil || len(end.Data) != 0 {
+		t.Fatalf("download resumed at the end of the result: %d bytes (%v), expected none", len(end.Data), err)
+	}
+	_, err = client.QueryFrom(jobID, int64(len(done.Data))+1)
+	expectErrorCode(t, err, protocol.CodeBadRequest)
+}
+
+func TestResumeSpooledJob(t *testing.T) {
+	jobsDir := t.TempDir()
+	data := encode(t, syntheticDocument(800, 1000, 0.08), "jpeg")
+
+	// A job spooled by a server which stopped before running it.
+	store, err := storage.NewLocal(jobsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry, err := jobs.NewRegistry(store, time.Hour, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := registry.Create("127.0.0.1", protocol.Header{Async: true}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := serverlib.DefaultConfig()
+	config.JobsDir = jobsDir
+	config.SpoolJobs = true
+	address := startServer(t, config)
+
+	done := waitJob(t, address, job.ID)
+	if done.Metadata.Status != protocol.StatusDone {
+		t.Fatalf("resumed job %s failed: %s", job.ID, done.Metadata.Error)
+	}
+	if _, _, err := image.Decode(bytes.NewReader(done.Data)); err != nil {
+		t.Fatalf("resumed job result not decodable: %v", err)
+	}
+}
+
+func TestUnknownJob(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+
+	_, err := request(t, address, protocol.Protobuf, protocol.Header{JobID: "missing"}, nil)
+	expectErrorCode(t, err, protocol.CodeNotFound)
+}
+
+func TestJobQuota(t *testing.T) {
+	config := serverlib.DefaultConfig()
+	config.AuthFile = filepath.Join(t.TempDir(), "keys")
+	keys := "scanner-1 3f0c1a9b priority=high\nbackoffice 9b72de41 priority=low jobs-per-day=1 concurrent-jobs=1\n"
+	if err := os.WriteFile(config.AuthFile, []byte(keys), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	address := startServer(t, config)
+	data := encode(t, syntheticDocument(800, 1000, 0.08), "jpeg")
+
+	submit := func(token string, header protocol.Header, data []byte) (clientlib.Response, error) {
+		client, err := clientlib.DialCodec("tcp", address, netUtils.DefaultSocketOptions(), protocol.Protobuf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		if err := client.Authenticate(token); err != nil {
+			t.Fatal(err)
+		}
+		if data == nil {
+			return client.Do(header, nil, 0)
+		}
+		return client.Do(header, bytes.NewReader(data), int64(len(data)))
+	}
+
+	response, err := submit("9b72de41", protocol.Header{Async: true}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = submit("9b72de41", protocol.Header{Async: true}, data)
+	expectErrorCode(t, err, protocol.CodeQuotaExceeded)
+	if _, err := submit("3f0c1a9b", protocol.Header{Async: true}, data); err != nil {
+		t.Fatalf("job of another key refused: %v", err)
+	}
+
+	deadline := time.Now().Add(testTimeout)
+	for {
+		done, err := submit("9b72de41", protocol.Header{JobID: response.Metadata.JobID}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done.Metadata.Status == protocol.StatusDone {
+			break
+		}
+		if done.Metadata.Status == protocol.StatusFailed || time.Now().After(deadline) {
+			t.Fatalf("job %s within the quota %s: %s", response.Metadata.JobID, done.Metadata.Status, done.Metadata.Error)
+		}
+		time.Sleep(testPollPeriod)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	config := serverlib.DefaultConfig()
+	config.WebhookNetworks = []string{"127.0.0.0/8"}
+	address := startServer(t, config)
+	data := encode(t, syntheticDocument(800, 1000, 0.08), "jpeg")
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan map[string]any, 1)
+	receiver := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		select {
+		case events <- event:
+		default:
+		}
+	})}
+	go receiver.Serve(listener)
+	defer receiver.Close()
+
+	webhook := "http://" + listener.Addr().String() + "/done"
+	response, err := request(t, address, protocol.Protobuf, protocol.Header{Async: true, Webhook: webhook}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-events:
+		if event["jobId"] != response.Metadata.JobID {
+			t.Fatalf("webhook called for job %v, expected %s", event["jobId"], response.Metadata.JobID)
+		}
+		if event["status"] != protocol.StatusDone {
+			t.Fatalf("webhook reports a %v job: %v", event["status"], event["error"])
+		}
+		if resultURL, _ := event["resultUrl"].(string); !strings.HasPrefix(resultURL, "file://") {
+			t.Fatalf("unexpected result URL %q", resultURL)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("webhook not called after %v", testTimeout)
+	}
+
+	// The loopback address is not public: a server not allowing its network never calls it.
+	address = startServer(t, serverlib.DefaultConfig())
+	response, err = request(t, address, protocol.Protobuf, protocol.Header{Async: true, Webhook: webhook}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJob(t, address, response.Metadata.JobID)
+	select {
+	case event := <-events:
+		t.Fatalf("webhook on the loopback address called for job %v", event["jobId"])
+	case <-time.After(time.Second):
+	}
+}
+
+func TestPresets(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+	document := syntheticDocument(800, 1000, 0.08)
+
+	tests := []struct {
+		preset string
+		input  string
+		format string
+	}{
+		{protocol.PresetDocument, "png", "png"},
+		{protocol.PresetPhoto, "png", "jpeg"},
+		{protocol.PresetWhiteboard, "jpeg", "png"},
+		{protocol.PresetReceipt, "jpeg", "png"},
+	}
+	for _, test := range tests {
+		t.Run(test.preset, func(t *testing.T) {
+			response, err := request(t, address, protocol.Protobuf, protocol.Header{Preset: test.preset}, encode(t, document, test.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkResult(t, response, test.format)
+		})
+	}
+
+	t.Run("explicit-format", func(t *testing.T) {
+		header := protocol.Header{Preset: protocol.PresetWhiteboard, Format: "jpeg"}
+		response, err := request(t, address, protocol.Protobuf, header, encode(t, document, "png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResult(t, response, "jpeg")
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := request(t, address, protocol.Protobuf, protocol.Header{Preset: "napkin"}, encode(t, document, "jpeg"))
+		expectErrorCode(t, err, protocol.CodeBadRequest)
+	})
+}
+
+func TestReceiptCrop(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+	const width, height = 160, 800
+	data := encode(t, syntheticReceipt(width, height, 0.3), "png")
+
+	response, err := request(t, address, protocol.Protobuf, protocol.Header{Preset: protocol.PresetReceipt}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := checkResult(t, response, "png").Bounds().Size()
+
+	// The 80 mm at 400 dpi of the preset are more than 3 times the width of the strip, the largest upscale.
+	if size.X < 3*width-12 || size.X > 3*width+12 {
+		t.Fatalf("receipt %d pixels wide, expected about %d", size.X, 3*width)
+	}
+	ratio := float64(size.Y) / float64(size.X)
+	if expected := float64(height) / float64(width); math.Abs(ratio-expected) > 0.1*expected {
+		t.Fatalf("receipt of %v pixels, expected upright with a ratio of %.1f", size, expected)
+	}
+}
+
+func TestWhiteboardWarp(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+	corners := [4]image.Point{{200, 80}, {880, 200}, {800, 640}, {120, 520}}
+	data := encode(t, syntheticBoard(corners), "jpeg")
+
+	response, err := request(t, address, protocol.Protobuf, protocol.Header{Preset: protocol.PresetWhiteboard}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := checkResult(t, response, "png").Bounds().Size()
+
+	// The sides of the board, about 690 and 447 pixels long, rather than its 760x560 bounding box.
+	side := func(a, b image.Point) float64 { return math.Hypot(float64(b.X-a.X), float64(b.Y-a.Y)) }
+	expected := image.Pt(int(side(corners[0], corners[1])), int(side(corners[1], corners[2])))
+	if math.Abs(float64(size.X-expected.X)) > 0.05*float64(expected.X) || math.Abs(float64(size.Y-expected.Y)) > 0.05*float64(expected.Y) {
+		t.Fatalf("whiteboard of %v pixels, expected about %v", size, expected)
+	}
+}
+
+func TestIDCard(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+
+	card := encode(t, syntheticBoard([4]image.Point{{200, 150}, {840, 190}, {820, 590}, {190, 560}}), "jpeg")
+	response, err := request(t, address, protocol.Protobuf, protocol.Header{Preset: protocol.PresetIDCard}, card)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 85.60 x 53.98 mm at 300 dpi, whatever the size of the card on the photo.
+	if size := checkResult(t, response, "jpeg").Bounds().Size(); size != image.Pt(1011, 638) {
+		t.Fatalf("card of %v pixels, expected 1011x638", size)
+	}
+
+	square := encode(t, syntheticBoard([4]image.Point{{200, 100}, {800, 120}, {790, 680}, {210, 660}}), "jpeg")
+	_, err = request(t, address, protocol.Protobuf, protocol.Header{Preset: protocol.PresetIDCard}, square)
+	expectErrorCode(t, err, protocol.CodeNotFound)
+}
+
+func TestBookSpread(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+
+	response, err := request(t, address, protocol.Protobuf, protocol.Header{Preset: protocol.PresetBook, Format: "png"}, encode(t, syntheticSpread(1400, 1000), "png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Metadata.Format != protocol.FormatZIP || !response.Metadata.Spread {
+		t.Fatalf("spread returned as %q (split: %v), expected a zip of its pages", response.Metadata.Format, response.Metadata.Spread)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(response.Data), int64(len(response.Data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pages []image.Image
+	for _, name := range []string{"page-001.png", "page-002.png"} {
+		file, err := archive.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := png.Decode(file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, page)
+	}
+
+	// Two pages of about half the spread, 1176 pixels wide, the figure on the first.
+	ink := make([]int, len(pages))
+	for i, page := range pages {
+		bounds := page.Bounds()
+		if math.Abs(float64(bounds.Dx())-588) > 30 {
+			t.Errorf("page %d of %v, expected about 588 pixels wide", i+1, bounds.Size())
+		}
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if color.GrayModel.Convert(page.At(x, y)).(color.Gray).Y < 100 {
+					ink[i]++
+				}
+			}
+		}
+	}
+	if 2*ink[0] < 3*ink[1] {
+		t.Errorf("%d dark pixels on the first page and %d on the second, expected the figure on the first", ink[0], ink[1])
+	}
+
+	document, err := request(t, address, protocol.Protobuf, protocol.Header{Preset: protocol.PresetBook, Format: protocol.FormatPDF}, encode(t, syntheticSpread(1400, 1000), "png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if document.Metadata.Format != protocol.FormatPDF || !bytes.Contains(document.Data, []byte("/Count 2")) {
+		t.Fatalf("spread returned as a %q of %d bytes, expected a PDF of two pages", document.Metadata.Format, len(document.Data))
+	}
+
+	single, err := request(t, address, protocol.Protobuf, protocol.Header{Preset: protocol.PresetBook}, encode(t, syntheticDocument(800, 1000, 0.08), "png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if single.Metadata.Spread {
+		t.Fatal("single page split as a spread")
+	}
+	checkResult(t, single, "jpeg")
+}
+
+func TestSource(t *testing.T) {
+	sourceDir := t.TempDir()
+	data := encode(t, syntheticDocument(800, 1000, 0.08), "jpeg")
+	if err := os.WriteFile(filepath.Join(sourceDir, "scan.jpg"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := serverlib.DefaultConfig()
+	config.Sources = []string{sourceDir}
+	config.MaxPayloadSize = len(data)
+	address := startServer(t, config)
+
+	t.Run("fetch", func(t *testing.T) {
+		response, err := request(t, address, protocol.Protobuf, protocol.Header{Source: sourceDir + "/scan.jpg"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResult(t, response, "jpeg")
+	})
+
+	t.Run("async", func(t *testing.T) {
+		response, err := request(t, address, protocol.Protobuf, protocol.Header{Source: sourceDir + "/scan.jpg", Async: true}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done := waitJob(t, address, response.Metadata.JobID); done.Metadata.Status != protocol.StatusDone {
+			t.Fatalf("job %s failed: %s", response.Metadata.JobID, done.Metadata.Error)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		source string
+		code   string
+	}{
+		{"missing", sourceDir + "/missing.jpg", protocol.CodeNotFound},
+		{"parent", sourceDir + "/../scan.jpg", protocol.CodeBadRequest},
+		{"outside", "/etc/passwd", protocol.CodeBadRequest},
+		{"prefix", sourceDir + "-other/scan.jpg", protocol.CodeBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := request(t, address, protocol.Protobuf, protocol.Header{Source: test.source}, nil)
+			expectErrorCode(t, err, test.code)
+		})
+	}
+
+	t.Run("too-large", func(t *testing.T) {
+		if err := os.WriteFile(filepath.Join(sourceDir, "large.jpg"), bytes.Repeat(data, 4), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := request(t, address, protocol.Protobuf, protocol.Header{Source: sourceDir + "/large.jpg"}, nil)
+		expectErrorCode(t, err, protocol.CodeTooLarge)
+	})
+}
+
+type fakeRecognizer struct{}
+
+func (fakeRecognizer) Recognize(ctx context.Context, img image.Image) (string, error) {
+	return fmt.Sprintf("document of %dx%d", img.Bounds().Dx(), img.Bounds().Dy()), nil
+}
+
+func (fakeRecognizer) RecognizeWords(ctx context.Context, img image.Image) ([]ocr.Word, error) {
+	return []ocr.Word{{Text: "document", Bounds: img.Bounds()}}, nil
+}
+
+func TestOCR(t *testing.T) {
+	data := encode(t, syntheticDocument(800, 1000, 0.08), "jpeg")
+
+	plainAddress := startServer(t, serverlib.DefaultConfig())
+	_, err := request(t, plainAddress, protocol.Protobuf, protocol.Header{OCR: true}, data)
+	expectErrorCode(t, err, protocol.CodeUnavailable)
+	_, err = request(t, plainAddress, protocol.Protobuf, protocol.Header{Orient: true}, data)
+	expectErrorCode(t, err, protocol.CodeUnavailable)
+
+	config := serverlib.DefaultConfig()
+	config.Recognizer = fakeRecognizer{}
+	address := startServer(t, config)
+
+	response, err := request(t, address, protocol.Protobuf, protocol.Header{OCR: true}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := checkResult(t, response, "jpeg").Bounds().Size()
+	if expected := fmt.Sprintf("document of %dx%d", size.X, size.Y); response.Metadata.Text != expected {
+		t.Fatalf("text %q, expected %q", response.Metadata.Text, expected)
+	}
+
+	response, err = request(t, address, protocol.Protobuf, protocol.Header{}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Metadata.Text != "" {
+		t.Fatalf("text %q returned without -ocr", response.Metadata.Text)
+	}
+
+	_, err = request(t, address, protocol.Protobuf, protocol.Header{OCR: true, Operation: protocol.OperationEdges}, data)
+	expectErrorCode(t, err, protocol.CodeBadRequest)
+
+	response, err = request(t, address, protocol.Protobuf, protocol.Header{OCR: true, Format: protocol.FormatPDF, PageSize: "A4", DPI: 72}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Metadata.Format != protocol.FormatPDF || !bytes.HasPrefix(response.Data, []byte("%PDF-")) {
+		t.Fatalf("result in %q, expected a PDF", response.Metadata.Format)
+	}
+	if response.Metadata.Text != "document" {
+		t.Fatalf("text %q, expected the words of the recognizer", response.Metadata.Text)
+	}
+	// An A4 page at 72 dpi is 595x842 pixels, as many points.
+	if !bytes.Contains(response.Data, []byte("/MediaBox [0 0 595.00 842.00]")) {
+		t.Fatal("PDF page is not A4")
+	}
+
+	// A single word in every orientation: not enough text to turn the document.
+	response, err = request(t, address, protocol.Protobuf, protocol.Header{OCR: true, Orient: true}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Metadata.Rotation != 0 || response.Metadata.Text != "document" {
+		t.Fatalf("rotation %d with text %q, expected 0 with the words of the recognizer", response.Metadata.Rotation, response.Metadata.Text)
+	}
+}
+
+func TestSession(t *testing.T) {
+	config := serverlib.DefaultConfig()
+	config.Recognizer = fakeRecognizer{}
+	address := startServer(t, config)
+	jpegPage := encode(t, syntheticDocument(800, 1000, 0.08), "jpeg")
+	pngPage := encode(t, syntheticDocument(700, 900, 0.1), "png")
+
+	_, err := request(t, address, protocol.Protobuf, protocol.Header{Session: "scan", Format: protocol.FormatPDF}, jpegPage)
+	expectErrorCode(t, err, protocol.CodeBadRequest)
+	_, err = request(t, address, protocol.Protobuf, protocol.Header{Page: 1}, jpegPage)
+	expectErrorCode(t, err, protocol.CodeBadRequest)
+	_, err = request(t, address, protocol.Protobuf, protocol.Header{Session: "unknown", Finalize: true}, nil)
+	expectErrorCode(t, err, protocol.CodeNotFound)
+
+	// The pages sent out of order, and a page sent again, are combined by number.
+	for _, page := range []struct {
+		number int
+		data   []byte
+		pages  int
+	}{{2, pngPage, 1}, {1, jpegPage, 2}, {2, pngPage, 2}} {
+		response, err := request(t, address, protocol.Protobuf, protocol.Header{Session: "scan", Page: page.number, OCR: true}, page.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Metadata.Pages != page.pages {
+			t.Fatalf("session of %d pages after page %d, expected %d", response.Metadata.Pages, page.number, page.pages)
+		}
+	}
+
+	response, err := request(t, address, protocol.Protobuf, protocol.Header{Session: "scan", Finalize: true, Format: protocol.FormatZIP}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Metadata.Format != protocol.FormatZIP || response.Metadata.Pages != 2 {
+		t.Fatalf("session finalized into %d pages of %q, expected 2 pages of zip", response.Metadata.Pages, response.Metadata.Format)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(response.Data), int64(len(response.Data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range archive.File {
+		names = append(names, file.Name)
+	}
+	if expected := "page-001.jpg page-001.txt page-002.png page-002.txt manifest.json"; strings.Join(names, " ") != expected {
+		t.Fatalf("archive of %v, expected %s", names, expected)
+	}
+	manifestFile, err := archive.Open(protocol.ManifestName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest protocol.Manifest
+	if err := json.NewDecoder(manifestFile).Decode(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	manifestFile.Close()
+	if len(manifest.Entries) != 2 || manifest.Entries[1].File != "page-002.png" || manifest.Entries[1].Page != 2 ||
+		manifest.Entries[1].Text != "page-002.txt" || manifest.Entries[0].Width == 0 {
+		t.Fatalf("manifest %+v does not describe the pages", manifest)
+	}
+
+	// Finalized, the session is forgotten.
+	_, err = request(t, address, protocol.Protobuf, protocol.Header{Session: "scan", Finalize: true}, nil)
+	expectErrorCode(t, err, protocol.CodeNotFound)
+
+	for _, data := range [][]byte{jpegPage, pngPage} {
+		if _, err := request(t, address, protocol.Protobuf, protocol.Header{Session: "pdf", OCR: true}, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	response, err = request(t, address, protocol.Protobuf, protocol.Header{Session: "pdf", Finalize: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Metadata.Format != protocol.FormatPDF || !bytes.HasPrefix(response.Data, []byte("%PDF-")) {
+		t.Fatalf("session finalized into %q, expected a PDF", response.Metadata.Format)
+	}
+	if pages := bytes.Count(response.Data, []byte("/Type /Page ")); pages != 2 {
+		t.Fatalf("PDF of %d pages, expected 2", pages)
+	}
+}
+
+func TestBorder(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+
+	// A page beside a larger mat cut by the left edge of the photo.
+	img := image.NewRGBA(image.Rect(0, 0, 1400, 1000))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: color.RGBA{R: 60, G: 50, B: 40, A: 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 60, 700, 940), &image.Uniform{C: color.RGBA{R: 150, G: 140, B: 130, A: 255}}, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(780, 180, 1320, 820), &image.Uniform{C: color.RGBA{R: 235, G: 230, B: 220, A: 255}}, image.Point{}, draw.Src)
+	data := encode(t, img, "png")
+
+	tests := []struct {
+		border string
+		left   int
+	}{
+		{protocol.BorderKeep, 0},
+		{protocol.BorderPenalize, 780},
+		{protocol.BorderDiscard, 780},
+	}
+	for _, test := range tests {
+		t.Run(test.border, func(t *testing.T) {
+			// Deterministic, so the image is split into several chunks whatever the number of workers.
+			header := protocol.Header{Operation: protocol.OperationCorners, Border: test.border, Deterministic: true}
+			response, err := request(t, address, protocol.Protobuf, header, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var document protocol.Document
+			if err := json.Unmarshal(response.Data, &document); err != nil {
+				t.Fatal(err)
+			}
+			if left := document.Corners[0].X; left < test.left-2 || left > test.left+2 {
+				t.Fatalf("document found from x = %d, expected about %d", left, test.left)
+			}
+			// A crop reports the corners of the document it cut out.
+			header.Operation = protocol.OperationCrop
+			cropped, err := request(t, address, protocol.Protobuf, header, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(cropped.Metadata.Corners, document.Corners) {
+				t.Fatalf("crop reports the corners %v, expected %v", cropped.Metadata.Corners, document.Corners)
+			}
+			if test.border != protocol.BorderKeep {
+				return
+			}
+			// The page is left to the user as an alternative to the mat.
+			alternatives := response.Metadata.Alternatives
+			if !slices.ContainsFunc(alternatives, func(candidate protocol.Candidate) bool {
+				return len(candidate.Corners) == 4 && candidate.Corners[0].X >= 778 && candidate.Corners[0].X <= 782
+			}) {
+				t.Fatalf("page missing from the %d alternatives", len(alternatives))
+			}
+			// The mat crosses every chunk, but is only ranked once.
+			if slices.ContainsFunc(alternatives, func(candidate protocol.Candidate) bool {
+				return slices.Equal(candidate.Corners, document.Corners)
+			}) {
+				t.Fatal("document repeated among the alternatives")
+			}
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := request(t, address, protocol.Protobuf, protocol.Header{Border: "crop"}, data)
+		expectErrorCode(t, err, protocol.CodeBadRequest)
+	})
+
+	t.Run("centering", func(t *testing.T) {
+		_, err := request(t, address, protocol.Protobuf, protocol.Header{Centering: 1.5}, data)
+		expectErrorCode(t, err, protocol.CodeBadRequest)
+	})
+}
+
+func TestHoughDetector(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+
+	// A page whose bottom-right corner is hidden by a hand.
+	const angle = 0.08
+	img := syntheticDocument(800, 1000, angle).(*image.RGBA)
+	draw.Draw(img, image.Rect(520, 700, 800, 1000), &image.Uniform{C: color.RGBA{R: 200, G: 150, B: 120, A: 255}}, image.Point{}, draw.Src)
+	data := encode(t, img, "png")
+
+	header := protocol.Header{Operation: protocol.OperationCorners, Detector: protocol.DetectorHough}
+	response, err := request(t, address, protocol.Protobuf, header, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document protocol.Document
+	if err := json.Unmarshal(response.Data, &document); err != nil {
+		t.Fatal(err)
+	}
+	if len(document.Corners) != 4 {
+		t.Fatalf("found %d corners, expected 4", len(document.Corners))
+	}
+	// The corners of the page of syntheticDocument, rotated around the center of the image.
+	for i, corner := range [4][2]float64{{-248, -350}, {248, -350}, {248, 350}, {-248, 350}} {
+		x := 400 + corner[0]*math.Cos(angle) - corner[1]*math.Sin(angle)
+		y := 500 + corner[0]*math.Sin(angle) + corner[1]*math.Cos(angle)
+		if got := document.Corners[i]; math.Abs(float64(got.X)-x) > 6 || math.Abs(float64(got.Y)-y) > 6 {
+			t.Errorf("corner %d at %v, expected about (%.0f, %.0f)", i, got, x, y)
+		}
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := request(t, address, protocol.Protobuf, protocol.Header{Detector: "magic"}, data)
+		expectErrorCode(t, err, protocol.CodeBadRequest)
+	})
+}
+
+func TestEdgeDetector(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+
+	const angle = 0.08
+	data := encode(t, syntheticDocument(800, 1000, angle), "png")
+
+	for _, edgeDetector := range []string{protocol.EdgeDetectorCanny, protocol.EdgeDetectorLoG} {
+		t.Run(edgeDetector, func(t *testing.T) {
+			header := protocol.Header{Operation: protocol.OperationCorners, EdgeDetector: edgeDetector}
+			response, err := request(t, address, protocol.Protobuf, header, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var document protocol.Document
+			if err := json.Unmarshal(response.Data, &document); err != nil {
+				t.Fatal(err)
+			}
+			if len(document.Corners) != 4 {
+				t.Fatalf("found %d corners, expected 4", len(document.Corners))
+			}
+			for i, corner := range [4][2]float64{{-248, -350}, {248, -350}, {248, 350}, {-248, 350}} {
+				x := 400 + corner[0]*math.Cos(angle) - corner[1]*math.Sin(angle)
+				y := 500 + corner[0]*math.Sin(angle) + corner[1]*math.Cos(angle)
+				if got := document.Corners[i]; math.Abs(float64(got.X)-x) > 6 || math.Abs(float64(got.Y)-y) > 6 {
+					t.Errorf("corner %d at %v, expected about (%.0f, %.0f)", i, got, x, y)
+				}
+			}
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := request(t, address, protocol.Protobuf, protocol.Header{EdgeDetector: "sobel"}, data)
+		expectErrorCode(t, err, protocol.CodeBadRequest)
+	})
+}
+
+func TestRANSACDetector(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+
+	// A page whose bottom-right corner is hidden by a thumb.
+	const angle = 0.08
+	img := syntheticDocument(800, 1000, angle).(*image.RGBA)
+	cornerX := 400 + 248*math.Cos(angle) - 350*math.Sin(angle)
+	cornerY := 500 + 248*math.Sin(angle) + 350*math.Cos(angle)
+	for y := 0; y < 1000; y++ {
+		for x := 0; x < 800; x++ {
+			if math.Hypot(float64(x)-cornerX, float64(y)-cornerY) < 60 {
+				img.SetRGBA(x, y, color.RGBA{R: 200, G: 150, B: 120, A: 255})
+			}
+		}
+	}
+	data := encode(t, img, "png")
+
+	header := protocol.Header{Operation: protocol.OperationCorners, Detector: protocol.DetectorRANSAC}
+	response, err := request(t, address, protocol.Protobuf, header, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document protocol.Document
+	if err := json.Unmarshal(response.Data, &document); err != nil {
+		t.Fatal(err)
+	}
+	if len(document.Corners) != 4 {
+		t.Fatalf("found %d corners, expected 4", len(document.Corners))
+	}
+	for i, corner := range [4][2]float64{{-248, -350}, {248, -350}, {248, 350}, {-248, 350}} {
+		x := 400 + corner[0]*math.Cos(angle) - corner[1]*math.Sin(angle)
+		y := 500 + corner[0]*math.Sin(angle) + corner[1]*math.Cos(angle)
+		if got := document.Corners[i]; math.Abs(float64(got.X)-x) > 6 || math.Abs(float64(got.Y)-y) > 6 {
+			t.Errorf("corner %d at %v, expected about (%.0f, %.0f)", i, got, x, y)
+		}
+	}
+}
+
+func TestRotatedCorners(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+
+	// Rotated by 40°, the top-left corner is nearly as far up the image as the top-right one is right of it.
+	const angle = 0.7
+	data := encode(t, syntheticDocument(1000, 1000, angle), "png")
+	response, err := request(t, address, protocol.Protobuf, protocol.Header{Operation: protocol.OperationCorners}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document protocol.Document
+	if err := json.Unmarshal(response.Data, &document); err != nil {
+		t.Fatal(err)
+	}
+	if len(document.Corners) != 4 {
+		t.Fatalf("found %d corners, expected 4", len(document.Corners))
+	}
+	for i, corner := range [4][2]float64{{-310, -350}, {310, -350}, {310, 350}, {-310, 350}} {
+		x := 500 + corner[0]*math.Cos(angle) - corner[1]*math.Sin(angle)
+		y := 500 + corner[0]*math.Sin(angle) + corner[1]*math.Cos(angle)
+		if got := document.Corners[i]; math.Abs(float64(got.X)-x) > 6 || math.Abs(float64(got.Y)-y) > 6 {
+			t.Errorf("corner %d at %v, expected about (%.0f, %.0f)", i, got, x, y)
+		}
+	}
+}
+
+func TestShapePreference(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+
+	// A square window frame, larger than the A4 page lying beside it.
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 700))
+	for y := 0; y < 700; y++ {
+		for x := 0; x < 1000; x++ {
+			pixel := color.RGBA{R: 60, G: 50, B: 40, A: 255}
+			switch {
+			case x >= 60 && x < 560 && y >= 100 && y < 600:
+				pixel = color.RGBA{R: 170, G: 190, B: 210, A: 255}
+			case x >= 640 && x < 940 && y >= 138 && y < 562:
+				pixel = color.RGBA{R: 235, G: 230, B: 220, A: 255}
+			}
+			img.SetRGBA(x, y, pixel)
+		}
+	}
+	data := encode(t, img, "png")
+
+	for _, test := range []struct {
+		shape string
+		left  int
+	}{{"", 60}, {"A4", 640}} {
+		header := protocol.Header{Operation: protocol.OperationCorners, Shape: test.shape}
+		response, err := request(t, address, protocol.Protobuf, header, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var document protocol.Document
+		if err := json.Unmarshal(response.Data, &document); err != nil {
+			t.Fatal(err)
+		}
+		if len(document.Corners) != 4 || math.Abs(float64(document.Corners[0].X-test.left)) > 4 {
+			t.Errorf("shape %q: found the corners %v, expected a document from x = %d", test.shape, document.Corners, test.left)
+		}
+	}
+
+	_, err := request(t, address, protocol.Protobuf, protocol.Header{Operation: protocol.OperationCorners, Shape: "B52"}, data)
+	expectErrorCode(t, err, protocol.CodeBadRequest)
+}
+
+func TestMultiDocument(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+
+	// Three receipts laid on a table, slightly turned, each with its lines of text.
+	receipts := []struct{ centerX, centerY, angle float64 }{{170, 400, 0.05}, {450, 360, -0.08}, {730, 420, 0.03}}
+	img := image.NewRGBA(image.Rect(0, 0, 900, 800))
+	for y := 0; y < 800; y++ {
+		for x := 0; x < 900; x++ {
+			pixel := color.RGBA{R: 60, G: 50, B: 40, A: 255}
+			for _, receipt := range receipts {
+				dx, dy := float64(x)-receipt.centerX, float64(y)-receipt.centerY
+				rotatedX := dx*math.Cos(receipt.angle) + dy*math.Sin(receipt.angle)
+				rotatedY := -dx*math.Sin(receipt.angle) + dy*math.Cos(receipt.angle)
+				if math.Abs(rotatedX) < 90 && math.Abs(rotatedY) < 260 {
+					pixel = color.RGBA{R: 238, G: 236, B: 230, A: 255}
+					if int(rotatedY+260)%30 < 3 && math.Abs(rotatedX) < 70 {
+						pixel = color.RGBA{R: 40, G: 40, B: 40, A: 255}
+					}
+				}
+			}
+			img.SetRGBA(x, y, pixel)
+		}
+	}
+	data := encode(t, img, "png")
+
+	header := protocol.Header{Operation: protocol.OperationCorners, MultiDocument: &protocol.MultiDocument{}}
+	response, err := request(t, address, protocol.Protobuf, header, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document protocol.Document
+	if err := json.Unmarshal(response.Data, &document); err != nil {
+		t.Fatal(err)
+	}
+	if len(document.Documents) != len(receipts) {
+		t.Fatalf("found %d documents, expected %d receipts", len(document.Documents), len(receipts))
+	}
+	for _, receipt := range receipts {
+		found := slices.ContainsFunc(document.Documents, func(candidate protocol.Candidate) bool {
+			var centerX, centerY float64
+			for _, corner := range candidate.Corners {
+				centerX += float64(corner.X) / 4
+				centerY += float64(corner.Y) / 4
+			}
+			return math.Abs(centerX-receipt.centerX) < 6 && math.Abs(centerY-receipt.centerY) < 6 &&
+				math.Abs(candidate.Aspect-180.0/520) < 0.03
+		})
+		if !found {
+			t.Errorf("receipt at (%.0f, %.0f) not found in %+v", receipt.centerX, receipt.centerY, document.Documents)
+		}
+	}
+
+	header.MultiDocument.MinAspect = 0.5
+	response, err = request(t, address, protocol.Protobuf, header, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	document = protocol.Document{}
+	if err := json.Unmarshal(response.Data, &document); err != nil {
+		t.Fatal(err)
+	}
+	if len(document.Documents) != 0 {
+		t.Errorf("found %d documents squarer than the receipts, expected none", len(document.Documents))
+	}
+
+	_, err = request(t, address, protocol.Protobuf, protocol.Header{MultiDocument: &protocol.MultiDocument{}}, data)
+	expectErrorCode(t, err, protocol.CodeBadRequest)
+	header.MultiDocument.MinArea = 2
+	_, err = request(t, address, protocol.Protobuf, header, data)
+	expectErrorCode(t, err, protocol.CodeBadRequest)
+}
+
+func TestDeskew(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+
+	// A page of text lines turned by 4° on a table: its bounding box keeps the lines slanted.
+	const angle = 4 * math.Pi / 180
+	img := image.NewRGBA(image.Rect(0, 0, 900, 1000))
+	for y := 0; y < 1000; y++ {
+		for x := 0; x < 900; x++ {
+			pixel := color.RGBA{R: 60, G: 50, B: 40, A: 255}
+			dx, dy := float64(x)-450, float64(y)-500
+			pageX := dx*math.Cos(angle) + dy*math.Sin(angle)
+			pageY := -dx*math.Sin(angle) + dy*math.Cos(angle)
+			if math.Abs(pageX) < 300 && math.Abs(pageY) < 400 {
+				pixel = color.RGBA{R: 240, G: 238, B: 232, A: 255}
+				if int(pageY+400)%24 >= 16 && math.Abs(pageY) < 360 && math.Abs(pageX) < 260 && int(pageX+300)%50 < 42 {
+					pixel = color.RGBA{R: 30, G: 30, B: 30, A: 255}
+				}
+			}
+			img.SetRGBA(x, y, pixel)
+		}
+	}
+	data := encode(t, img, "png")
+
+	for _, deskew := range []bool{false, true} {
+		response, err := request(t, address, protocol.Protobuf, protocol.Header{Format: "png", Deskew: deskew}, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, _, err := image.Decode(bytes.NewReader(response.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		skew, expected := utils.EstimateSkew(imageUtils.Grayscale(result)), 4.0
+		if deskew {
+			expected = 0
+		}
+		if math.Abs(skew-expected) > 0.5 {
+			t.Errorf("deskew %v: the text of the result is slanted by %g°, expected %g°", deskew, skew, expected)
+		}
+	}
+
+	_, err := request(t, address, protocol.Protobuf, protocol.Header{Operation: protocol.OperationCorners, Deskew: true}, data)
+	expectErrorCode(t, err, protocol.CodeBadRequest)
+}
+
+func TestFillHoles(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+
+	// Three holes punched along the left edge of the page, which spans x from 152 to 648.
+	page := syntheticDocument(800, 1000, 0).(*image.RGBA)
+	for _, y := range []int{300, 500, 700} {
+		for dy := -8; dy <= 8; dy++ {
+			for dx := -8; dx <= 8; dx++ {
+				if dx*dx+dy*dy <= 64 {
+					page.SetRGBA(177+dx, y+dy, color.RGBA{R: 60, G: 50, B: 40, A: 255})
+				}
+			}
+		}
+	}
+	data := encode(t, page, "png")
+
+	for _, fill := range []bool{false, true} {
+		response, err := request(t, address, protocol.Protobuf, protocol.Header{Format: "png", FillHoles: fill}, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := checkResult(t, response, "png")
+		bounds := result.Bounds()
+		dark := 0
+		for y := bounds.Min.Y + 50; y < bounds.Max.Y-50; y++ {
+			for x := bounds.Min.X + 5; x < bounds.Min.X+45; x++ {
+				if color.GrayModel.Convert(result.At(x, y)).(color.Gray).Y < 120 {
+					dark++
+				}
+			}
+		}
+		if fill && dark > 0 || !fill && dark < 3*150 {
+			t.Errorf("fill %v: %d dark pixels along the left edge of the result", fill, dark)
+		}
+	}
+
+	_, err := request(t, address, protocol.Protobuf, protocol.Header{Operation: protocol.OperationCorners, FillHoles: true}, data)
+	expectErrorCode(t, err, protocol.CodeBadRequest)
+	_, err = request(t, address, protocol.Protobuf, protocol.Header{Lossless: true, FillHoles: true}, data)
+	expectErrorCode(t, err, protocol.CodeBadRequest)
+}
+
+func TestContourPass(t *testing.T) {
+	// Deterministic, so the chunked pass splits the image into several chunks whatever the number of workers.
+	data := encode(t, syntheticDocument(800, 1000, 0.08), "png")
+	header := protocol.Header{Operation: protocol.OperationCorners, Deterministic: true}
+
+	var documents []protocol.Document
+	for _, pass := range []string{serverlib.ContourPassChunked, serverlib.ContourPassGlobal} {
+		config := serverlib.DefaultConfig()
+		config.ContourPass = pass
+		response, err := request(t, startServer(t, config), protocol.Protobuf, header, data)
+		if err != nil {
+			t.Fatalf("%s: %v", pass, err)
+		}
+		var document protocol.Document
+		if err := json.Unmarshal(response.Data, &document); err != nil {
+			t.Fatal(err)
+		}
+		documents = append(documents, document)
+	}
+	if !slices.Equal(documents[0].Corners, documents[1].Corners) || documents[0].Area != documents[1].Area {
+		t.Fatalf("global pass found %v, expected the corners of the chunked pass %v", documents[1].Corners, documents[0].Corners)
+	}
+
+	config := serverlib.DefaultConfig()
+	config.ContourPass = "parallel"
+	config.JobsDir = t.TempDir()
+	config.Logger = log.New(io.Discard, "", 0)
+	if _, err := serverlib.New(config); err == nil {
+		t.Fatal("server created with an unknown contour pass")
+	}
+}
+
+func TestGlobalThresholds(t *testing.T) {
+	// A single chunk gives the thresholds of the whole image: the chunks of the deterministic mode must match it. The
+	// upper half of the page only has faint rules, found by its chunks with their own thresholds, while the dense
+	// text of the lower half raises those of the image above them.
+	img := image.NewGray(image.Rect(0, 0, 800, 1000))
+	for y := 0; y < 1000; y++ {
+		for x := 0; x < 800; x++ {
+			value := uint8(60)
+			if x > 150 && x < 650 && y > 150 && y < 850 {
+				value = 230
+				if y > 500 && (y-500)%12 < 4 && x > 200 && x < 600 && (x/15)%2 == 0 {
+					value = 20
+				} else if y < 500 && (y-150)%40 < 4 && x > 200 && x < 600 {
+					value = 210
+				}
+			}
+			img.SetGray(x, y, color.Gray{Y: value})
+		}
+	}
+	data := encode(t, img, "png")
+
+	var edges []image.Image
+	for _, global := range []bool{false, true} {
+		config := serverlib.DefaultConfig()
+		config.Workers = 1
+		config.GlobalThresholds = global
+		header := protocol.Header{Operation: protocol.OperationEdges, Deterministic: global}
+		response, err := request(t, startServer(t, config), protocol.Protobuf, header, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		edges = append(edges, checkResult(t, response, "png"))
+	}
+
+	bounds := edges[0].Bounds()
+	differences := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			first, _, _, _ := edges[0].At(x, y).RGBA()
+			second, _, _, _ := edges[1].At(x, y).RGBA()
+			if first != second {
+				differences++
+			}
+		}
+	}
+	if differences > bounds.Dx()*bounds.Dy()/1000 {
+		t.Fatalf("%d pixels of the edge map differ from that of a single chunk", differences)
+	}
+}
+
+func TestDescribePipeline(t *testing.T) {
+	config := serverlib.DefaultConfig()
+	config.Workers = 3
+	config.ContourPass = serverlib.ContourPassGlobal
+
+	pipeline, err := serverlib.DescribePipeline(config, protocol.PresetWhiteboard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pipeline.Workers != 3 || pipeline.Chunks != 3 {
+		t.Errorf("pipeline has %d workers and %d chunks, expected 3 and 3", pipeline.Workers, pipeline.Chunks)
+	}
+
+	stages := map[string]serverlib.PipelineStage{}
+	var names []string
+	for _, stage := range pipeline.Stages {
+		stages[stage.Name] = stage
+		names = append(names, stage.Name)
+	}
+	for _, name := range []string{"bilateral filter", "closing", "crop", "enhance"} {
+		if _, ok := stages[name]; !ok {
+			t.Fatalf("whiteboard pipeline %v has no %s stage", names, name)
+		}
+	}
+	if radius := stages["closing"].Parameters["radius"]; radius != 2 {
+		t.Errorf("closing radius is %v, expected 2", radius)
+	}
+	if mode := stages["crop"].Parameters["mode"]; mode != "perspective warp" {
+		t.Errorf("crop mode is %v, expected a perspective warp", mode)
+	}
+	if function := stages["enhance"].Parameters["function"]; function != "imageUtils.CleanWhiteboard" {
+		t.Errorf("enhancement is %v, expected imageUtils.CleanWhiteboard", function)
+	}
+	if contours := stages["contours"]; contours.Tasks != 1 {
+		t.Errorf("global contour pass has %d tasks, expected 1", contours.Tasks)
+	}
+
+	dot := pipeline.DOT()
+	if !strings.HasPrefix(dot, "digraph") || strings.Count(dot, "->") != len(pipeline.Stages)-1 {
+		t.Errorf("malformed graph of the pipeline:\n%s", dot)
+	}
+
+	if _, err := serverlib.DescribePipeline(config, "unknown"); err == nil {
+		t.Error("described the pipeline of an unknown preset")
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	config := serverlib.DefaultConfig()
+	config.Workers = 3
+	config.AdminAddress = "127.0.0.1:0"
+	server := startTestServer(t, config)
+	address := server.Addr().String()
+
+	client, err := clientlib.DialCodec("tcp", address, netUtils.DefaultSocketOptions(), protocol.Protobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	data := encode(t, syntheticDocument(400, 300, 0.05), "jpeg")
+	response, err := client.Do(protocol.Header{}, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResult(t, response, "jpeg")
+
+	t.Run("stats", func(t *testing.T) {
+		var stats struct {
+			Connections int   `json:"connections"`
+			Requests    int64 `json:"requests"`
+			Workers     int   `json:"workers"`
+			Draining    bool  `json:"draining"`
+		}
+		if status := adminRequest(t, server, http.MethodGet, "/stats", &stats); status != http.StatusOK {
+			t.Fatalf("stats answered with status %d", status)
+		}
+		if stats.Connections != 1 || stats.Requests != 1 || stats.Workers != 3 || stats.Draining {
+			t.Fatalf("stats %+v, expected 1 connection, 1 request and 3 workers", stats)
+		}
+	})
+
+	t.Run("connections", func(t *testing.T) {
+		var connections []struct {
+			RemoteAddr string `json:"remoteAddr"`
+			Requests   int64  `json:"requests"`
+		}
+		if status := adminRequest(t, server, http.MethodGet, "/connections", &connections); status != http.StatusOK {
+			t.Fatalf("connections answered with status %d", status)
+		}
+		if len(connections) != 1 || connections[0].Requests != 1 || !strings.HasPrefix(connections[0].RemoteAddr, "127.0.0.1:") {
+			t.Fatalf("connections %+v, expected the connection of the test with 1 request", connections)
+		}
+	})
+
+	t.Run("workers", func(t *testing.T) {
+		var workers map[string]any
+		if status := adminRequest(t, server, http.MethodPost, "/workers?count=5", &workers); status != http.StatusOK {
+			t.Fatalf("resize answered with status %d: %v", status, workers)
+		}
+		if status := adminRequest(t, server, http.MethodGet, "/workers", &workers); status != http.StatusOK || workers["workers"] != 5.0 {
+			t.Fatalf("workers after a resize to 5: %v (status %d)", workers, status)
+		}
+		for _, count := range []string{"0", "1025", "many"} {
+			if status := adminRequest(t, server, http.MethodPost, "/workers?count="+count, &workers); status != http.StatusBadRequest {
+				t.Fatalf("resize to %s workers answered with status %d, expected %d", count, status, http.StatusBadRequest)
+			}
+		}
+
+		// The resized pools still process the requests.
+		response, err := client.Do(protocol.Header{}, bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResult(t, response, "jpeg")
+	})
+
+	t.Run("drain", func(t *testing.T) {
+		var drain map[string]bool
+		if status := adminRequest(t, server, http.MethodPost, "/drain", &drain); status != http.StatusOK || !drain["draining"] {
+			t.Fatalf("drain answered with %v (status %d)", drain, status)
+		}
+		if _, err := request(t, address, protocol.Protobuf, protocol.Header{JobID: "missing"}, nil); err == nil {
+			t.Fatal("new connection served by a draining server")
+		}
+
+		// The open connection is still served, and the server stops once it is closed.
+		_, err := client.Do(protocol.Header{JobID: "missing"}, nil, 0)
+		expectErrorCode(t, err, protocol.CodeNotFound)
+		client.Close()
+		select {
+		case <-server.Done():
+		case <-time.After(testTimeout):
+			t.Fatalf("drained server still running after %v", testTimeout)
+		}
+	})
+}
+
+func TestFlatten(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+
+	// The shadow of the photographer darkens the right of the page to 45%, over a penumbra of 8 pixels.
+	img := syntheticDocument(800, 600, 0).(*image.RGBA)
+	for y := 0; y < 600; y++ {
+		for x := 0; x < 800; x++ {
+			factor := 1 - 0.55*min(max(float64(x-446)/8, 0), 1)
+			pixel := img.RGBAAt(x, y)
+			img.SetRGBA(x, y, color.RGBA{
+				R: uint8(float64(pixel.R) * factor),
+				G: uint8(float64(pixel.G) * factor),
+				B: uint8(float64(pixel.B) * factor),
+				A: 255,
+			})
+		}
+	}
+	data := encode(t, img, "png")
+
+	// Edge pixels along the border of the shadow, between the text lines of the page.
+	shadowEdges := func(flatten bool) int {
+		response, err := request(t, address, protocol.Protobuf, protocol.Header{Operation: protocol.OperationEdges, Flatten: flatten}, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		edges := checkResult(t, response, "png")
+		count := 0
+		for y := 130; y < 470; y++ {
+			if line := (y - 90) % 40; line < 10 || line > 30 {
+				continue
+			}
+			for x := 430; x < 470; x++ {
+				if value, _, _, _ := edges.At(x, y).RGBA(); value > 0 {
+					count++
+				}
+			}
+		}
+		return count
+	}
+
+	if count := shadowEdges(false); count == 0 {
+		t.Fatal("found no edge along the shadow, expected the test image to need flattening")
+	}
+	if count := shadowEdges(true); count > 0 {
+		t.Errorf("found %d edge pixels along the shadow once flattened", count)
+	}
+}
+
+func TestScan(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+	data := encode(t, syntheticDocument(800, 600, 0.05), "jpeg")
+
+	response, err := request(t, address, protocol.Protobuf, protocol.Header{Operation: protocol.OperationScan, Binarize: true}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := checkResult(t, response, "png")
+	bounds := result.Bounds()
+	var black, white int
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := result.At(x, y).RGBA()
+			switch {
+			case r == 0 && g == 0 && b == 0:
+				black++
+			case r == 0xffff && g == 0xffff && b == 0xffff:
+				white++
+			default:
+				t.Fatalf("pixel (%d, %d) of the binarized scan is neither black nor white", x, y)
+			}
+		}
+	}
+	if black == 0 || white < black {
+		t.Errorf("binarized scan has %d black and %d white pixels, expected print on white paper", black, white)
+	}
+
+	_, err = request(t, address, protocol.Protobuf, protocol.Header{Binarize: true}, data)
+	expectErrorCode(t, err, protocol.CodeBadRequest)
+	_, err = request(t, address, protocol.Protobuf, protocol.Header{Operation: protocol.OperationScan, Despeckle: &protocol.Despeckle{}}, data)
+	expectErrorCode(t, err, protocol.CodeBadRequest)
+
+	// Specks of dust between the lines of print, removed unless the request keeps them.
+	speckled := syntheticDocument(800, 600, 0).(*image.RGBA)
+	for x := 300; x < 500; x += 50 {
+		draw.Draw(speckled, image.Rect(x, 110, x+2, 112), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	}
+	data = encode(t, speckled, "png")
+	var specks []int
+	for _, despeckle := range []*protocol.Despeckle{nil, {SpeckSize: -1}} {
+		response, err := request(t, address, protocol.Protobuf, protocol.Header{Operation: protocol.OperationScan, Binarize: true, Despeckle: despeckle}, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := checkResult(t, response, "png")
+		count := 0
+		for x := 290; x < 500; x++ {
+			for y := 100; y < 122; y++ {
+				if r, _, _, _ := result.At(result.Bounds().Min.X+x-152, result.Bounds().Min.Y+y-90).RGBA(); r == 0 {
+					count++
+				}
+			}
+		}
+		specks = append(specks, count)
+	}
+	if specks[0] != 0 || specks[1] < 4*4 {
+		t.Errorf("%d black pixels around the specks once despeckled, %d when kept, expected none and the 4 specks", specks[0], specks[1])
+	}
+}
+
+type panickingRecognizer struct{}
+
+func (panickingRecognizer) Recognize(ctx context.Context, img image.Image) (string, error) {
+	panic("recognizer bug")
+}
+
+func TestPanic(t *testing.T) {
+	config := serverlib.DefaultConfig()
+	config.Recognizer = panickingRecognizer{}
+	config.CrashDir = t.TempDir()
+	config.CrashInput = true
+	address := startServer(t, config)
+	data := encode(t, syntheticDocument(800, 1000, 0.08), "jpeg")
+
+	_, crashErr := request(t, address, protocol.Protobuf, protocol.Header{OCR: true}, data)
+	expectErrorCode(t, crashErr, protocol.CodeInternal)
+
+	// The server survives the panic, and serves the next requests.
+	response, err := request(t, address, protocol.Protobuf, protocol.Header{}, data)
+	if err != nil {
+		t.Fatalf("request after a panic failed: %v", err)
+	}
+	checkResult(t, response, "jpeg")
+
+	entries, err := os.ReadDir(config.CrashDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("%d crash bundles, expected 1", len(entries))
+	}
+	report, input, err := serverlib.ReadDebugBundle(filepath.Join(config.CrashDir, entries[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(crashErr.Error(), report.ID) {
+		t.Errorf("error %q does not give the crash bundle %s", crashErr, report.ID)
+	}
+	if report.Panic != "recognizer bug" || !strings.Contains(report.Stack, "panickingRecognizer") {
+		t.Errorf("crash bundle gives the panic %q with the stack\n%s", report.Panic, report.Stack)
+	}
+	if !report.Header.OCR || !bytes.Equal(input, data) {
+		t.Errorf("crash bundle does not keep the request: header %+v, %d bytes of input", report.Header, len(input))
+	}
+
+	t.Run("job", func(t *testing.T) {
+		response, err := request(t, address, protocol.Protobuf, protocol.Header{OCR: true, Async: true}, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := waitJob(t, address, response.Metadata.JobID)
+		if done.Metadata.Status != protocol.StatusFailed || !strings.Contains(done.Metadata.Error, "crash bundle") {
+			t.Fatalf("job %s, expected it to fail with a crash bundle: %s", done.Metadata.Status, done.Metadata.Error)
+		}
+	})
+}
+
+func TestWhiteBalance(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+	// The paper of the synthetic document is a little too warm for a visible cast.
+	data := encode(t, syntheticDocument(800, 1000, 0.08), "png")
+
+	for mode, balanced := range map[string]bool{
+		"":                              false,
+		protocol.WhiteBalanceAuto:       false,
+		protocol.WhiteBalanceWhitePatch: true,
+		protocol.WhiteBalanceGrayWorld:  true,
+		protocol.WhiteBalanceOff:        false,
+	} {
+		response, err := request(t, address, protocol.Protobuf, protocol.Header{WhiteBalance: mode, NoCache: true}, data)
+		if err != nil {
+			t.Fatalf("%q: %v", mode, err)
+		}
+		if response.Metadata.Colors == nil || response.Metadata.Colors.Balanced != balanced {
+			t.Errorf("%q: colors %+v, expected balanced %v", mode, response.Metadata.Colors, balanced)
+		}
+	}
+
+	_, err := request(t, address, protocol.Protobuf, protocol.Header{Operation: protocol.OperationEdges, WhiteBalance: protocol.WhiteBalanceOff}, data)
+	expectErrorCode(t, err, protocol.CodeBadRequest)
+	_, err = request(t, address, protocol.Protobuf, protocol.Header{WhiteBalance: "daylight"}, data)
+	expectErrorCode(t, err, protocol.CodeBadRequest)
+}
+
+func TestTone(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+	data := encode(t, syntheticDocument(800, 600, 0), "png")
+
+	grayscale := func(tone *protocol.Tone) *image.Gray {
+		response, err := request(t, address, protocol.Protobuf, protocol.Header{Operation: protocol.OperationGrayscale, DetectionTone: tone}, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gray, ok := checkResult(t, response, "png").(*image.Gray)
+		if !ok {
+			t.Fatal("grayscale result is not a gray image")
+		}
+		return gray
+	}
+	plain, adjusted := grayscale(nil), grayscale(&protocol.Tone{Gamma: 2})
+	curve := imageUtils.GammaCurve(2)
+	for i, value := range plain.Pix {
+		if adjusted.Pix[i] != curve[value] {
+			t.Fatalf("level %d of the grayscale image becomes %d with a gamma of 2, expected %d", value, adjusted.Pix[i], curve[value])
+		}
+	}
+
+	// Without contrast, the cropped document is a plain mid-gray.
+	response, err := request(t, address, protocol.Protobuf, protocol.Header{Tone: &protocol.Tone{Contrast: -1}}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	document := checkResult(t, response, "png")
+	bounds := document.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 7 {
+		for x := bounds.Min.X; x < bounds.Max.X; x += 7 {
+			if r, g, b, _ := document.At(x, y).RGBA(); r>>8 != 128 || g>>8 != 128 || b>>8 != 128 {
+				t.Fatalf("pixel (%d, %d) of the document is (%d, %d, %d) without contrast, expected mid-gray", x, y, r>>8, g>>8, b>>8)
+			}
+		}
+	}
+
+	for name, header := range map[string]protocol.Header{
+		"tone of edges":     {Operation: protocol.OperationEdges, Tone: &protocol.Tone{Gamma: 2}},
+		"gamma too high":    {Tone: &protocol.Tone{Gamma: 20}},
+		"negative gamma":    {DetectionTone: &protocol.Tone{Gamma: -1}},
+		"brightness over 1": {Tone: &protocol.Tone{Brightness: 1.5}},
+		"contrast under -1": {DetectionTone: &protocol.Tone{Contrast: -2}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := request(t, address, protocol.Protobuf, header, data)
+			expectErrorCode(t, err, protocol.CodeBadRequest)
+		})
+	}
+}
+
+func TestLossless(t *testing.T) {
+	address := startServer(t, serverlib.DefaultConfig())
+	data := encode(t, syntheticDocument(800, 1000, 0), "jpeg")
+
+	crop := func(header protocol.Header, data []byte, format string) (image.Image, bool) {
+		t.Helper()
+		header.NoCache = true
+		response, err := request(t, address, protocol.Protobuf, header, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return checkResult(t, response, format), response.Metadata.Lossless
+	}
+
+	decoded, lossless := crop(protocol.Header{WhiteBalance: protocol.WhiteBalanceOff}, data, "jpeg")
+	if lossless {
+		t.Error("document cropped losslessly without asking for it")
+	}
+	cropped, lossless := crop(protocol.Header{Lossless: true}, data, "jpeg")
+	if !lossless {
+		t.Fatal("document of a JPEG image not cropped losslessly")
+	}
+	// The top left corner of the crop moves onto the grid of the 16x16 MCUs of the JPEG.
+	extension := cropped.Bounds().Size().Sub(decoded.Bounds().Size())
+	if extension.X < 0 || extension.X >= 16 || extension.Y < 0 || extension.Y >= 16 {
+		t.Errorf("lossless crop of %v, expected the %v of the decoded crop extended by less than 16 pixels", cropped.Bounds().Size(), decoded.Bounds().Size())
+	}
+
+	if _, lossless := crop(protocol.Header{Lossless: true}, encode(t, syntheticDocument(800, 1000, 0), "png"), "png"); lossless {
+		t.Error("document of a PNG image cropped losslessly")
+	}
+
+	for name, header := range map[string]protocol.Header{
+		"scan":         {Lossless: true, Operation: protocol.OperationScan},
+		"png":          {Lossless: true, Format: "png"},
+		"page size":    {Lossless: true, PageSize: "A4"},
+		"warp":         {Lossless: true, Preset: protocol.PresetWhiteboard},
+		"stamp":        {Lossless: true, Stamp: &protocol.Stamp{Text: "COPY"}},
+		"tone":         {Lossless: true, Tone: &protocol.Tone{Gamma: 2}},
+		"gray world":   {Lossless: true, WhiteBalance: protocol.WhiteBalanceGrayWorld},
+		"png accepted": {Lossless: true, Accept: []string{"png"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := request(t, address, protocol.Protobuf, header, data)
+			expectErrorCode(t, err, protocol.CodeBadRequest)
+		})
+	}
+}
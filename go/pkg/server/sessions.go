@@ -0,0 +1,312 @@
+package server
+
+/*
+This file implements the scan sessions: a document of several pages is scanned one page per request, each request
+naming the session and the number of its page (`protocol.Header.Session`, `protocol.Header.Page`). The server keeps
+the result of every page, and combines them into one multi-page PDF or ZIP archive when the client finalizes the
+session (`protocol.Header.Finalize`), so the client does not have to assemble the pages itself.
+
+---
+
+### Constants
+- `sessionTTL`: Time after its last page after which a session which was not finalized is forgotten.
+- `maxSessions`: Most sessions kept at once, a new session is refused beyond.
+- `maxSessionPages`: Most pages of a session.
+- `maxSessionIDLength`: Longest session ID accepted.
+
+---
+
+### `sessionPage`
+The result of a page of a session.
+
+- Fields:
+  - `number`: Number of the page in the session (`protocol.Header.Page`), 0 if the client did not number it. Both
+    pages of a spread take the number of their request.
+  - `format`, `data`: The result sent for the page, in the format of the request ("jpeg" or "png").
+  - `width`, `height`, `dpi`: Size of the result in pixels, and its resolution, which give its physical size.
+  - `text`, `words`: Text recognized on the page and its words, if the request asked for it (`protocol.Header.OCR`),
+    for the text layer of the PDF.
+  - `rotation`: Rotation the page was turned upright by (`protocol.Header.Orient`), for the manifest of the ZIP.
+
+### `scanSession`
+The pages of a session received so far.
+
+- Fields:
+  - `owner`: The client which started the session (see `sessionOwner`): only it can add pages and finalize it.
+  - `pages`: The pages, in the order they were processed.
+  - `updated`: Time of the last page, the session expires `sessionTTL` after it.
+
+### `sessionStore`
+The sessions being scanned, in memory, protected by `mutex`.
+
+- Methods:
+  - `add(owner string, id string, pages ...sessionPage) (int, error)`: Adds the pages of a request, of the same
+    number, to the session `id`, starting the session if it does not exist, and returns the number of pages of the
+    session. They replace the pages of the same number, those of the spread of a previous request included (see
+    `spread.go`), and stay in their order. Fails with `protocol.CodeUnauthorized` if the session belongs to another
+    client, `protocol.CodeBusy` if there are already `maxSessions` sessions, and `protocol.CodeTooLarge` if the
+    session would have more than `maxSessionPages` pages.
+  - `finish(owner string, id string) ([]sessionPage, error)`: Removes the session `id` and returns its pages in order:
+    the numbered pages by number, then the others in the order they were processed. Fails with
+    `protocol.CodeNotFound` if the session does not exist, has expired or belongs to another client.
+  - `expire()`: Forgets the sessions whose last page is older than `sessionTTL`. Called by `add` and `finish`.
+
+---
+
+### `newSessionStore() *sessionStore`
+Returns an empty store.
+
+### `sessionOwner(conn *connection) string`
+Returns the client a session belongs to: the name of the API key of the connection if the server authenticates its
+clients, otherwise the remote host, like the duplicate detection.
+
+### `handleFinalize(conn *connection, requestID uint32, header protocol.Header)`
+Answers a request finalizing a session: the pages of the session are combined in the format of the request
+(`protocol.FormatPDF` by default, or `protocol.FormatZIP`, negotiated from `protocol.Header.Accept` if the request
+gives no format) and sent in one response, whose metadata gives the number
+of pages. An unknown session gets an error frame with the `not_found` code.
+
+### `combinePages(pages []sessionPage, format string) ([]byte, error)`
+Combines the pages of a session:
+- `protocol.FormatPDF`: A PDF of one page per result, at its physical size, with the words of the pages as an
+  invisible text layer (see `internal/pdf`). The JPEG results are embedded as they are, without losing quality.
+- `protocol.FormatZIP`: A ZIP archive of the results, `page-001.jpg`, `page-002.png`, ..., with the text of every
+  recognized page beside it in `page-001.txt`, ..., and the `protocol.Manifest` of the pages in its
+  `protocol.ManifestName` file, so the client finds the size and the text of every page without decoding them.
+*/
+
+import (
+	"ELP-project/internal/ocr"
+	"ELP-project/internal/pdf"
+	"ELP-project/internal/protocol"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	sessionTTL         = time.Hour
+	maxSessions        = 256
+	maxSessionPages    = 500
+	maxSessionIDLength = 128
+)
+
+type sessionPage struct {
+	number   int
+	format   string
+	data     []byte
+	width    int
+	height   int
+	dpi      int
+	text     string
+	words    []ocr.Word
+	rotation int
+}
+
+type scanSession struct {
+	owner   string
+	pages   []sessionPage
+	updated time.Time
+}
+
+type sessionStore struct {
+	mutex    sync.Mutex
+	sessions map[string]*scanSession
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{sessions: make(map[string]*scanSession)}
+}
+
+func (store *sessionStore) add(owner string, id string, pages ...sessionPage) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.expire()
+
+	session, ok := store.sessions[id]
+	switch {
+	case !ok && len(store.sessions) >= maxSessions:
+		return 0, protocol.ErrorMessage{Code: protocol.CodeBusy, Message: fmt.Sprintf("too many sessions in progress (%d)", maxSessions)}
+	case !ok:
+		session = &scanSession{owner: owner}
+		store.sessions[id] = session
+	case session.owner != owner:
+		return 0, protocol.ErrorMessage{Code: protocol.CodeUnauthorized, Message: fmt.Sprintf("session %q belongs to another client", id)}
+	}
+
+	number := pages[0].number
+	kept := session.pages
+	if number != 0 {
+		kept = slices.DeleteFunc(slices.Clone(kept), func(other sessionPage) bool { return other.number == number })
+	}
+	if len(kept)+len(pages) > maxSessionPages {
+		return 0, protocol.ErrorMessage{Code: protocol.CodeTooLarge, Message: fmt.Sprintf("session %q cannot have more than %d pages", id, maxSessionPages)}
+	}
+	session.pages = append(kept, pages...)
+	session.updated = time.Now()
+	return len(session.pages), nil
+}
+
+func (store *sessionStore) finish(owner string, id string) ([]sessionPage, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.expire()
+
+	session, ok := store.sessions[id]
+	if !ok || session.owner != owner {
+		return nil, protocol.ErrorMessage{Code: protocol.CodeNotFound, Message: fmt.Sprintf("unknown session: %q", id)}
+	}
+	delete(store.sessions, id)
+
+	pages := session.pages
+	slices.SortStableFunc(pages, func(a, b sessionPage) int {
+		switch {
+		case a.number == b.number:
+			return 0
+		case a.number == 0:
+			return 1
+		case b.number == 0:
+			return -1
+		default:
+			return a.number - b.number
+		}
+	})
+	return pages, nil
+}
+
+func (store *sessionStore) expire() {
+	for id, session := range store.sessions {
+		if time.Since(session.updated) > sessionTTL {
+			delete(store.sessions, id)
+		}
+	}
+}
+
+func sessionOwner(conn *connection) string {
+	if conn.client != "" {
+		return conn.client
+	}
+	return remoteHost(conn)
+}
+
+func (server *Server) handleFinalize(conn *connection, requestID uint32, header protocol.Header) {
+	format := header.Format
+	if format == "" && len(header.Accept) > 0 {
+		var err error
+		if format, err = negotiateFormat(header.Accept, []string{protocol.FormatPDF, protocol.FormatZIP}); err != nil {
+			server.sendError(conn, requestID, protocol.CodeBadRequest, err)
+			return
+		}
+	}
+	if format == "" {
+		format = protocol.FormatPDF
+	}
+	switch {
+	case header.Session == "":
+		server.sendError(conn, requestID, protocol.CodeBadRequest, errors.New("finalize needs the ID of a session"))
+		return
+	case format != protocol.FormatPDF && format != protocol.FormatZIP:
+		server.sendError(conn, requestID, protocol.CodeBadRequest, fmt.Errorf("a session is combined into a %s or a %s file, not %s", protocol.FormatPDF, protocol.FormatZIP, format))
+		return
+	}
+
+	pages, err := server.sessions.finish(sessionOwner(conn), header.Session)
+	if err != nil {
+		server.sendError(conn, requestID, protocol.CodeNotFound, err)
+		return
+	}
+
+	encodingStart := time.Now()
+	data, err := combinePages(pages, format)
+	if err != nil {
+		server.sendError(conn, requestID, protocol.CodeInternal, err)
+		return
+	}
+	timings := newStageTimings()
+	timings.since(protocol.TimingEncode, encodingStart)
+	server.logger.Printf("Session %q of %s finalized: %d pages combined into %d bytes of %s", header.Session, conn.RemoteAddr(), len(pages), len(data), format)
+	server.sendResponse(conn, requestID, &protocol.Metadata{Format: format, Pages: len(pages)}, data, timings)
+}
+
+func combinePages(pages []sessionPage, format string) ([]byte, error) {
+	var output bytes.Buffer
+
+	if format == protocol.FormatZIP {
+		archive := zip.NewWriter(&output)
+		var manifest protocol.Manifest
+		for i, page := range pages {
+			name := fmt.Sprintf("page-%03d", i+1)
+			extension := page.format
+			if extension == "jpeg" {
+				extension = "jpg"
+			}
+			entry := protocol.ManifestEntry{
+				File:     name + "." + extension,
+				Page:     i + 1,
+				Format:   page.format,
+				Width:    page.width,
+				Height:   page.height,
+				Rotation: page.rotation,
+			}
+			// The images are already compressed: stored as they are.
+			file, err := archive.CreateHeader(&zip.FileHeader{Name: entry.File, Method: zip.Store, Modified: time.Now()})
+			if err != nil {
+				return nil, err
+			}
+			if _, err := file.Write(page.data); err != nil {
+				return nil, err
+			}
+			if page.text != "" {
+				entry.Text = name + ".txt"
+				file, err := archive.Create(entry.Text)
+				if err != nil {
+					return nil, err
+				}
+				if _, err := file.Write([]byte(page.text + "\n")); err != nil {
+					return nil, err
+				}
+			}
+			manifest.Entries = append(manifest.Entries, entry)
+		}
+		file, err := archive.Create(protocol.ManifestName)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.NewEncoder(file).Encode(manifest); err != nil {
+			return nil, err
+		}
+		if err := archive.Close(); err != nil {
+			return nil, err
+		}
+		return output.Bytes(), nil
+	}
+
+	documentPages := make([]pdf.Page, len(pages))
+	for i, page := range pages {
+		documentPages[i] = pdf.Page{
+			Width:  pdf.PixelPoints(page.width, page.dpi),
+			Height: pdf.PixelPoints(page.height, page.dpi),
+			Words:  page.words,
+			Text:   page.text,
+		}
+		if page.format == "jpeg" {
+			documentPages[i].JPEG = page.data
+			continue
+		}
+		img, _, err := image.Decode(bytes.NewReader(page.data))
+		if err != nil {
+			return nil, fmt.Errorf("decoding page %d: %w", i+1, err)
+		}
+		documentPages[i].Image = img
+	}
+	if err := pdf.EncodeDocument(&output, documentPages); err != nil {
+		return nil, err
+	}
+	return output.Bytes(), nil
+}
@@ -0,0 +1,108 @@
+package server
+
+/*
+This file implements the requests whose image the server reads from a storage (`protocol.Header.Source`) instead of
+receiving it from the client, e.g. the scans a copier uploads to a bucket.
+
+---
+
+### `source`
+A storage the server reads the images of the requests from.
+
+- Fields:
+  - `location`: The location the storage was opened from (see `Config.Sources`), without its trailing `/`: the URLs
+    of its images start with it.
+  - `store`: The storage.
+
+---
+
+### `openSources(locations []string) ([]source, error)`
+Opens the storages of `Config.Sources`.
+
+### `(server *Server) fetchSource(url string) ([]byte, error)`
+Reads the image at `url` from the storage whose location prefixes it, the rest of the URL being the key of the image.
+Fails with a `protocol.ErrorMessage`:
+- `protocol.CodeBadRequest` if no storage of the server holds the URL, or its key could leave the storage (see
+  `storage.CheckKey`).
+- `protocol.CodeNotFound` if the storage has no such image.
+- `protocol.CodeTooLarge` if the image is larger than `Config.MaxPayloadSize`, like an image frame. No more than
+  `MaxPayloadSize`+1 bytes of it are read (see `storage.GetLimited`).
+- `protocol.CodeUnavailable` if the storage cannot be read.
+
+### `(server *Server) handleSourceRequest(conn *connection, requestID uint32, header protocol.Header, transfer requestTransfer, workerChannels workerChannels)`
+Fetches the image of a request, then processes it like an image sent by the client (see `handleRequest`). The time
+spent fetching the image counts as the upload of the request in the access log.
+*/
+
+import (
+	"ELP-project/internal/protocol"
+	"ELP-project/internal/storage"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type source struct {
+	location string
+	store    storage.Storage
+}
+
+func openSources(locations []string) ([]source, error) {
+	sources := make([]source, 0, len(locations))
+	for _, location := range locations {
+		store, err := storage.Open(location)
+		if err != nil {
+			return nil, fmt.Errorf("opening source %s: %w", location, err)
+		}
+		sources = append(sources, source{location: strings.TrimSuffix(location, "/"), store: store})
+	}
+	return sources, nil
+}
+
+func (server *Server) fetchSource(url string) ([]byte, error) {
+	for _, source := range server.sources {
+		key, found := strings.CutPrefix(url, source.location+"/")
+		if !found {
+			continue
+		}
+		if err := storage.CheckKey(key); err != nil {
+			return nil, protocol.ErrorMessage{Code: protocol.CodeBadRequest, Message: err.Error()}
+		}
+
+		data, err := storage.GetLimited(source.store, key, int64(server.config.MaxPayloadSize))
+		if errors.Is(err, storage.ErrNotExist) {
+			return nil, protocol.ErrorMessage{Code: protocol.CodeNotFound, Message: "no image at " + url}
+		}
+		if errors.Is(err, storage.ErrTooLarge) {
+			return nil, protocol.ErrorMessage{
+				Code:    protocol.CodeTooLarge,
+				Message: fmt.Sprintf("image exceeds the limit of %d bytes", server.config.MaxPayloadSize),
+			}
+		}
+		if err != nil {
+			return nil, protocol.ErrorMessage{Code: protocol.CodeUnavailable, Message: fmt.Sprintf("reading %s: %v", url, err)}
+		}
+		return data, nil
+	}
+	return nil, protocol.ErrorMessage{Code: protocol.CodeBadRequest, Message: fmt.Sprintf("source %q is not in the storages of the server", url)}
+}
+
+func (server *Server) handleSourceRequest(conn *connection, requestID uint32, header protocol.Header, transfer requestTransfer, workerChannels workerChannels) {
+	server.logger.Printf("Fetching image for request %d from %s...", requestID, header.Source)
+	data, err := server.fetchSource(header.Source)
+	transfer.received = time.Now()
+	if err != nil {
+		status := server.sendError(conn, requestID, protocol.CodeBadRequest, err)
+		server.logAccess(&accessEntry{
+			remoteAddr: conn.RemoteAddr().String(),
+			client:     conn.client,
+			requestID:  requestID,
+			status:     status,
+			transfer:   transfer,
+		})
+		return
+	}
+
+	server.handleRequest(conn, requestID, header, data, transfer, workerChannels)
+}
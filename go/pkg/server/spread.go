@@ -0,0 +1,129 @@
+package server
+
+/*
+This file implements the split of the double-page spreads (`protocol.PresetBook`): once the document is cropped,
+warped and recognized, `process` looks for the gutter of a spread on it (see `utils.FindGutter`), and the result is
+sent as the two pages on both sides of the gutter, left first, instead of one image: a PDF of two pages in
+`protocol.FormatPDF`, a `protocol.FormatZIP` archive of the pages in the image format of the request otherwise (see
+`combinePages`). A request of a session adds both pages to the session, under the number of the request.
+
+---
+
+### `spreadFormat(format string, split bool) string`
+Returns the format the result of a request asking for `format` is sent in: `format` itself, unless the result was
+`split` at the gutter of a spread, whose pages are sent in `protocol.FormatPDF` for a PDF, `protocol.FormatZIP` for an
+image format.
+
+### `spreadHalves(img image.Image, options requestOptions) []pdf.Page`
+Splits `img`, the result of `process`, at the gutter of `options` into its left and right pages, at the physical
+size of the resolution of the request.
+
+- **Behavior**:
+  - The pages share the pixels of `img` (see `subImage`), at their place in it.
+  - The words recognized on `img` go with the page their center falls on, and the text of each page is made of its
+    words. The text recognized without the boxes of its words (see `requestOptions.words`) cannot be split: it goes
+    with the left page.
+
+### `spreadPages(img image.Image, format string, options requestOptions) ([]sessionPage, error)`
+Returns the pages of `spreadHalves` encoded in `format` ("jpeg" or "png"), for the archive of the response or the
+session of the request. The boxes of their words are moved to the pages, whose images start at their top left
+corner once encoded.
+
+### `subImage(img image.Image, rect image.Rectangle) image.Image`
+Returns the part of `img` within `rect`, sharing its pixels if it can, as the images of the standard library do,
+otherwise copied.
+
+---
+
+### Example Usage:
+```go
+if options.split() {
+	pages, err := spreadPages(finalImage, "jpeg", options)
+	if err != nil {
+		return nil, err
+	}
+	return combinePages(pages, protocol.FormatZIP)
+}
+```
+*/
+
+import (
+	"ELP-project/internal/ocr"
+	"ELP-project/internal/pdf"
+	"ELP-project/internal/protocol"
+	"image"
+	"image/draw"
+)
+
+func spreadFormat(format string, split bool) string {
+	if !split || format == protocol.FormatPDF {
+		return format
+	}
+	return protocol.FormatZIP
+}
+
+func spreadHalves(img image.Image, options requestOptions) []pdf.Page {
+	bounds := img.Bounds()
+	gutter := *options.gutter
+	halves := []pdf.Page{
+		{Image: subImage(img, image.Rect(bounds.Min.X, bounds.Min.Y, gutter, bounds.Max.Y))},
+		{Image: subImage(img, image.Rect(gutter, bounds.Min.Y, bounds.Max.X, bounds.Max.Y))},
+	}
+	if options.words != nil {
+		for _, word := range *options.words {
+			half := &halves[0]
+			if (word.Bounds.Min.X+word.Bounds.Max.X)/2 >= gutter {
+				half = &halves[1]
+			}
+			half.Words = append(half.Words, word)
+		}
+		for i := range halves {
+			halves[i].Text = ocr.JoinWords(halves[i].Words)
+		}
+	} else {
+		halves[0].Text = options.recognizedText()
+	}
+	for i := range halves {
+		halves[i].Width = pdf.PixelPoints(halves[i].Image.Bounds().Dx(), options.dpi)
+		halves[i].Height = pdf.PixelPoints(halves[i].Image.Bounds().Dy(), options.dpi)
+	}
+	return halves
+}
+
+func spreadPages(img image.Image, format string, options requestOptions) ([]sessionPage, error) {
+	halves := spreadHalves(img, options)
+	pages := make([]sessionPage, len(halves))
+	for i, half := range halves {
+		data, err := encodeImage(half.Image, format)
+		if err != nil {
+			return nil, err
+		}
+		bounds := half.Image.Bounds()
+		pages[i] = sessionPage{
+			number:   options.sessionPage,
+			format:   format,
+			data:     data,
+			width:    bounds.Dx(),
+			height:   bounds.Dy(),
+			dpi:      options.dpi,
+			text:     half.Text,
+			rotation: options.rotationDegrees(),
+		}
+		for _, word := range half.Words {
+			word.Bounds = word.Bounds.Sub(bounds.Min)
+			pages[i].words = append(pages[i].words, word)
+		}
+	}
+	return pages, nil
+}
+
+func subImage(img image.Image, rect image.Rectangle) image.Image {
+	if sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(rect)
+	}
+	part := image.NewRGBA(rect)
+	draw.Draw(part, rect, img, rect.Min, draw.Src)
+	return part
+}
@@ -0,0 +1,75 @@
+package server
+
+/*
+This file implements the parsing of the stamps requested by the clients (`protocol.Header.Stamp`), which `process`
+lays over the cropped document once it is anonymized (see `internal/watermark`).
+
+---
+
+### Constants
+- `maxStampDimension`: Largest width or height of a stamp image, in pixels. A stamp is scaled down to a quarter of
+  the document, larger images only cost memory.
+
+---
+
+### `parseStamp(stamp protocol.Stamp) (watermark.Stamp, error)`
+Checks the stamp of a request and decodes its image. A stamp has either a text or an image, a position among the
+`protocol.Position*` constants (`protocol.PositionBottomRight` if empty), and an opacity in (0, 1]
+(`protocol.DefaultStampOpacity` if 0).
+*/
+
+import (
+	"ELP-project/internal/protocol"
+	"ELP-project/internal/watermark"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+)
+
+const maxStampDimension = 2000
+
+func parseStamp(stamp protocol.Stamp) (watermark.Stamp, error) {
+	parsed := watermark.Stamp{
+		Text:     stamp.Text,
+		Position: stamp.Position,
+		Opacity:  stamp.Opacity,
+	}
+
+	switch {
+	case stamp.Text == "" && len(stamp.Image) == 0:
+		return parsed, errors.New("a stamp needs a text or an image")
+	case stamp.Text != "" && len(stamp.Image) > 0:
+		return parsed, errors.New("a stamp has either a text or an image, not both")
+	}
+
+	switch parsed.Position {
+	case "":
+		parsed.Position = protocol.PositionBottomRight
+	case protocol.PositionTopLeft, protocol.PositionTopRight, protocol.PositionBottomLeft, protocol.PositionBottomRight, protocol.PositionCenter:
+	default:
+		return parsed, fmt.Errorf("unknown stamp position: %q", stamp.Position)
+	}
+
+	if parsed.Opacity == 0 {
+		parsed.Opacity = protocol.DefaultStampOpacity
+	}
+	if parsed.Opacity < 0 || parsed.Opacity > 1 {
+		return parsed, fmt.Errorf("invalid stamp opacity: %v", stamp.Opacity)
+	}
+
+	if len(stamp.Image) > 0 {
+		config, _, err := image.DecodeConfig(bytes.NewReader(stamp.Image))
+		if err != nil {
+			return parsed, fmt.Errorf("decoding stamp image: %w", err)
+		}
+		if config.Width > maxStampDimension || config.Height > maxStampDimension {
+			return parsed, fmt.Errorf("stamp image too large: %dx%d pixels (at most %d per side)", config.Width, config.Height, maxStampDimension)
+		}
+		if parsed.Image, _, err = image.Decode(bytes.NewReader(stamp.Image)); err != nil {
+			return parsed, fmt.Errorf("decoding stamp image: %w", err)
+		}
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,114 @@
+package server
+
+/*
+This file implements the timing report of the requests: the time spent in every stage of a request is measured
+while it is processed, and sent to the client in the `protocol.FrameTrailer` frame closing the response, so users
+can see where the time goes.
+
+---
+
+### `timingStages`
+The stages reported, in the order of the pipeline (see `protocol.StageTiming`).
+
+### `stageTimings`
+Durations measured for a request, indexed by stage. Safe for concurrent use, so the workers processing the chunks
+of an image can add their measures. The methods do nothing on a nil `*stageTimings`.
+
+- Methods:
+  - `add(stage string, duration time.Duration)`: Adds a duration to a stage.
+  - `since(stage string, start time.Time)`: Adds the time elapsed since `start` to a stage.
+  - `addCanny(timings utils.CannyTimings)`: Adds the steps of the edge detection of a chunk.
+  - `report() []protocol.StageTiming`: Returns the measured stages in the order of `timingStages`.
+
+---
+
+### `newStageTimings() *stageTimings`
+Creates an empty report.
+
+### `restoreTimings(report []protocol.StageTiming) *stageTimings`
+Creates a report from a previous one, e.g. the report of a job saved with its result.
+*/
+
+import (
+	"ELP-project/internal/protocol"
+	"ELP-project/internal/utils"
+	"sync"
+	"time"
+)
+
+var timingStages = []string{
+	protocol.TimingReceive,
+	protocol.TimingGrayscale,
+	protocol.TimingBlur,
+	protocol.TimingSobel,
+	protocol.TimingNMS,
+	protocol.TimingHysteresis,
+	protocol.TimingMorphology,
+	protocol.TimingBFS,
+	protocol.TimingQuadrilateral,
+	protocol.TimingHough,
+	protocol.TimingCrop,
+	protocol.TimingDeskew,
+	protocol.TimingEnhance,
+	protocol.TimingAnonymize,
+	protocol.TimingOrient,
+	protocol.TimingOCR,
+	protocol.TimingStamp,
+	protocol.TimingEncode,
+	protocol.TimingSend,
+}
+
+type stageTimings struct {
+	mutex     sync.Mutex
+	durations map[string]time.Duration
+}
+
+func newStageTimings() *stageTimings {
+	return &stageTimings{durations: make(map[string]time.Duration)}
+}
+
+func restoreTimings(report []protocol.StageTiming) *stageTimings {
+	timings := newStageTimings()
+	for _, timing := range report {
+		timings.add(timing.Stage, time.Duration(timing.Millis*float64(time.Millisecond)))
+	}
+	return timings
+}
+
+func (timings *stageTimings) add(stage string, duration time.Duration) {
+	if timings == nil {
+		return
+	}
+
+	timings.mutex.Lock()
+	defer timings.mutex.Unlock()
+	timings.durations[stage] += duration
+}
+
+func (timings *stageTimings) since(stage string, start time.Time) {
+	timings.add(stage, time.Since(start))
+}
+
+func (timings *stageTimings) addCanny(cannyTimings utils.CannyTimings) {
+	timings.add(protocol.TimingBlur, cannyTimings.Blur)
+	timings.add(protocol.TimingSobel, cannyTimings.Sobel)
+	timings.add(protocol.TimingNMS, cannyTimings.NMS)
+	timings.add(protocol.TimingHysteresis, cannyTimings.Hysteresis)
+}
+
+func (timings *stageTimings) report() []protocol.StageTiming {
+	if timings == nil {
+		return nil
+	}
+
+	timings.mutex.Lock()
+	defer timings.mutex.Unlock()
+
+	var report []protocol.StageTiming
+	for _, stage := range timingStages {
+		if duration, ok := timings.durations[stage]; ok {
+			report = append(report, protocol.StageTiming{Stage: stage, Millis: milliseconds(duration)})
+		}
+	}
+	return report
+}
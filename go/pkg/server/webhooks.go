@@ -0,0 +1,234 @@
+package server
+
+/*
+This file implements the webhooks of the asynchronous jobs: a client creating a job with `protocol.Header.Webhook`
+is notified by an HTTP request once the job is done or failed, instead of polling it.
+
+---
+
+### Delivery
+The server POSTs a JSON `webhookEvent` to the URL of the job:
+
+```json
+{
+  "jobId": "3f2a...",
+  "status": "done",
+  "format": "jpeg",
+  "resultUrl": "https://scans.s3.eu-west-3.amazonaws.com/jobs/9c1e....content?X-Amz-Signature=...",
+  "created": "2026-10-17T10:00:00Z",
+  "updated": "2026-10-17T10:00:02Z"
+}
+```
+
+- `resultUrl` reads the result directly from the job storage (see `storage.Linker`), for `Config.ResultURLExpiry`.
+  It is only set for finished jobs whose storage gives such URLs, the result can always be fetched from the server
+  with the ID of the job.
+- When `Config.WebhookSecret` is set, the `X-ELP-Signature` header carries `sha256=<hex>`, the HMAC-SHA256 of the
+  body keyed with the secret, so the receiver can check that the event comes from the server.
+- Any 2xx status acknowledges the event. Otherwise the delivery is attempted `webhookAttempts` times, with a delay
+  doubling from `webhookRetryDelay`, unless the server stops in the meantime. Each attempt is given at most
+  `webhookTimeout`.
+- The webhooks only reach public addresses, unless their network is listed in `Config.WebhookNetworks`: a client
+  cannot make the server call the loopback, private or link-local addresses, like the metadata service of a cloud
+  instance. The address is checked once resolved, when it is dialed, so a name resolving to such an address is
+  refused too, and the HTTP proxies of the environment are not used.
+- The body of the response is ignored, only its first `webhookDrainLimit` bytes are read so the connection can be
+  reused.
+
+---
+
+### `webhookEvent`
+Body of a webhook request: the `protocol.Metadata` of the job, the URL of its result, and its creation and last
+update times.
+
+---
+
+### `validateWebhook(webhook string) error`
+Checks that the webhook of a request is an absolute `http` or `https` URL.
+
+### `newWebhookClient(networks []string) (*http.Client, error)`
+Returns the HTTP client calling the webhooks, which only dials the public addresses and those of `networks`.
+
+### `publicAddress(address netip.Addr) bool`
+Reports whether `address` is a public unicast address: neither unspecified, loopback, private, link-local nor
+multicast.
+
+### `notifyJob(jobID string)`
+Delivers the webhook of a finished job in the background, if it has one. The delivery is waited for by `Shutdown`.
+
+### `deliverWebhook(job jobs.Job)`
+Builds the event of a job and sends it, retrying on failure.
+
+### `postWebhook(target string, body []byte) error`
+Sends one attempt of a webhook request.
+*/
+
+import (
+	"ELP-project/internal/jobs"
+	"ELP-project/internal/protocol"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+const (
+	webhookTimeout    = 10 * time.Second
+	webhookAttempts   = 3
+	webhookRetryDelay = time.Second
+	webhookDrainLimit = 64 << 10
+
+	webhookSignatureHeader = "X-ELP-Signature"
+)
+
+type webhookEvent struct {
+	protocol.Metadata
+	ResultURL string    `json:"resultUrl,omitempty"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+}
+
+func validateWebhook(webhook string) error {
+	target, err := url.Parse(webhook)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Errorf("invalid webhook URL: %q", webhook)
+	}
+	return nil
+}
+
+func newWebhookClient(networks []string) (*http.Client, error) {
+	allowed := make([]netip.Prefix, len(networks))
+	for i, network := range networks {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook network: %w", err)
+		}
+		allowed[i] = prefix.Masked()
+	}
+
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, conn syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			ip := addrPort.Addr().Unmap()
+			if publicAddress(ip) {
+				return nil
+			}
+			for _, prefix := range allowed {
+				if prefix.Contains(ip) {
+					return nil
+				}
+			}
+			return fmt.Errorf("webhook address %s is not public", ip)
+		},
+	}
+	transport := &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: webhookTimeout}
+	return &http.Client{Timeout: webhookTimeout, Transport: transport}, nil
+}
+
+func publicAddress(address netip.Addr) bool {
+	return address.IsGlobalUnicast() && !address.IsPrivate()
+}
+
+func (server *Server) notifyJob(jobID string) {
+	job, ok := server.jobs.Get(jobID)
+	if !ok || job.Request.Webhook == "" {
+		return
+	}
+
+	server.active.Add(1)
+	go func() {
+		defer server.active.Done()
+		server.deliverWebhook(job)
+	}()
+}
+
+func (server *Server) deliverWebhook(job jobs.Job) {
+	event := webhookEvent{
+		Metadata: protocol.Metadata{
+			JobID:    job.ID,
+			Status:   job.Status,
+			Error:    job.Error,
+			Format:   job.Format,
+			Colors:   job.Colors,
+			Text:     job.Text,
+			Rotation: job.Rotation,
+		},
+		Created: job.Created,
+		Updated: job.Updated,
+	}
+
+	if job.Status == protocol.StatusDone {
+		resultURL, err := server.jobs.ResultURL(job.ID, server.config.ResultURLExpiry)
+		if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			server.logger.Printf("Error getting result URL of job %s: %v", job.ID, err)
+		}
+		event.ResultURL = resultURL
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		server.logger.Printf("Error encoding webhook of job %s: %v", job.ID, err)
+		return
+	}
+
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := server.postWebhook(job.Request.Webhook, body)
+		if err == nil {
+			server.logger.Printf("Webhook of job %s delivered", job.ID)
+			return
+		}
+		server.logger.Printf("Webhook of job %s failed (attempt %d of %d): %v", job.ID, attempt, webhookAttempts, err)
+
+		if attempt == webhookAttempts {
+			return
+		}
+		select {
+		case <-time.After(delay):
+			delay *= 2
+		case <-server.stopCtx.Done():
+			server.logger.Printf("Webhook of job %s abandoned, server is shutting down", job.ID)
+			return
+		}
+	}
+}
+
+func (server *Server) postWebhook(target string, body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	if server.config.WebhookSecret != "" {
+		mac := hmac.New(sha256.New, []byte(server.config.WebhookSecret))
+		mac.Write(body)
+		request.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	response, err := server.webhooks.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.CopyN(io.Discard, response.Body, webhookDrainLimit)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", response.Status)
+	}
+	return nil
+}